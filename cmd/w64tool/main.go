@@ -2,14 +2,17 @@ package main
 
 import (
 	"bytes"
+	"compress/flate"
 	"encoding/binary"
 	"flag"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"wicos64-server/internal/proto"
 	"wicos64-server/internal/version"
@@ -20,6 +23,7 @@ func main() {
 	var showVersion bool
 	flag.StringVar(&url, "url", "http://127.0.0.1:8080/wicos64/api?token=CHANGE-ME", "W64F endpoint URL (including token)")
 	flag.BoolVar(&showVersion, "version", false, "Print version information and exit")
+	flag.BoolVar(&acceptCompressed, "compress", false, "Accept deflate compressed responses (servers with compress_responses)")
 	flag.Parse()
 
 	if showVersion {
@@ -33,17 +37,27 @@ func main() {
 		os.Exit(2)
 	}
 
+	if strings.EqualFold(args[0], "shell") {
+		runShell(url, os.Stdin)
+		return
+	}
+	os.Exit(run(url, args))
+}
+
+// run executes one subcommand and returns the process exit code (0 ok,
+// 1 failed, 2 usage error). The shell calls it once per line.
+func run(url string, args []string) int {
 	cmd := strings.ToLower(args[0])
 	switch cmd {
 	case "version":
 		fmt.Println(version.Get().String())
-		return
+		return 0
 	case "caps":
 		req := buildReq(proto.OpCAPS, 0, nil)
 		resp, status, errMsg := post(url, req)
 		if status != proto.StatusOK {
 			printErr(status, errMsg, resp)
-			os.Exit(1)
+			return 1
 		}
 		printCaps(resp)
 	case "ping":
@@ -51,19 +65,33 @@ func main() {
 		resp, status, errMsg := post(url, req)
 		if status != proto.StatusOK {
 			printErr(status, errMsg, resp)
-			os.Exit(1)
+			return 1
 		}
 		if len(resp) == 0 {
 			fmt.Println("(no payload)")
-			return
+			return 0
 		}
 		d := proto.NewDecoder(resp)
 		s, _ := d.ReadString(256)
 		fmt.Println(s)
 	case "ls":
+		args, opts := splitOpts(args, "reverse")
+		var flags byte
+		if opts["reverse"] {
+			flags |= proto.FlagLS_REVERSE
+		}
+		args, sortKey := takeValueOpt(args, "sort")
+		if sortKey != "" {
+			key := slices.Index(proto.LSSortNames, strings.ToLower(sortKey))
+			if key < 0 {
+				fmt.Fprintf(os.Stderr, "unknown sort key %q (name, size, mtime, type)\n", sortKey)
+				return 2
+			}
+			flags |= byte(key)
+		}
 		if len(args) < 2 {
-			fmt.Println("ls <path> [start_index] [max_entries]")
-			os.Exit(2)
+			fmt.Println("ls <path> [start_index] [max_entries] [--sort=name|size|mtime|type] [--reverse]")
+			return 2
 		}
 		p := args[1]
 		start := uint16(0)
@@ -77,17 +105,103 @@ func main() {
 			max = uint16(v)
 		}
 		pl := buildLS(p, start, max)
-		req := buildReq(proto.OpLS, 0, pl)
-		resp, status, errMsg := post(url, req)
+		req := buildReq(proto.OpLS, flags, pl)
+		resp, status, rf, errMsg := postFlags(url, req)
 		if status != proto.StatusOK {
 			printErr(status, errMsg, resp)
-			os.Exit(1)
+			return 1
 		}
 		printLS(resp)
+		printFromImage(rf)
+	case "stat":
+		if len(args) < 2 {
+			fmt.Println("stat <path>")
+			return 2
+		}
+		resp, status, rf, errMsg := postFlags(url, buildReq(proto.OpSTAT, 0, buildPathOnly(args[1])))
+		if status != proto.StatusOK {
+			printErr(status, errMsg, resp)
+			return 1
+		}
+		printStat(resp)
+		printFromImage(rf)
+	case "mkdir":
+		rest, opts := splitOpts(args, "p", "parents")
+		if len(rest) < 2 {
+			fmt.Println("mkdir <path> [-p]")
+			return 2
+		}
+		var fl byte
+		if opts["p"] || opts["parents"] {
+			fl = proto.FlagMK_PARENTS
+		}
+		resp, status, errMsg := post(url, buildReq(proto.OpMKDIR, fl, buildPathOnly(rest[1])))
+		if status != proto.StatusOK {
+			printErr(status, errMsg, resp)
+			return 1
+		}
+		fmt.Println("OK")
+	case "rm":
+		rest, opts := splitOpts(args, "dry-run")
+		if len(rest) < 2 {
+			fmt.Println("rm <path> [--dry-run]")
+			return 2
+		}
+		var fl byte
+		if opts["dry-run"] {
+			fl = proto.FlagDRY_RUN
+		}
+		resp, status, errMsg := post(url, buildReq(proto.OpRM, fl, buildPathOnly(rest[1])))
+		if status != proto.StatusOK {
+			printErr(status, errMsg, resp)
+			return 1
+		}
+		if opts["dry-run"] {
+			printDryRun(resp)
+			return 0
+		}
+		fmt.Println("OK")
+	case "cp", "mv":
+		rest, opts := splitOpts(args, "force", "recursive", "dry-run")
+		if len(rest) < 3 {
+			if cmd == "cp" {
+				fmt.Println("cp <src> <dst> [--force] [--recursive] [--dry-run]")
+			} else {
+				fmt.Println("mv <src> <dst> [--force] [--dry-run]")
+			}
+			return 2
+		}
+		op, fl := byte(proto.OpCP), byte(0)
+		if opts["dry-run"] {
+			fl |= proto.FlagDRY_RUN
+		}
+		if cmd == "mv" {
+			op = proto.OpMV
+			if opts["force"] {
+				fl |= proto.FlagMV_OVERWRITE
+			}
+		} else {
+			if opts["force"] {
+				fl |= proto.FlagCP_OVERWRITE
+			}
+			if opts["recursive"] {
+				fl |= proto.FlagCP_RECURSIVE
+			}
+		}
+		resp, status, errMsg := post(url, buildReq(op, fl, buildTwoPaths(rest[1], rest[2])))
+		if status != proto.StatusOK {
+			printErr(status, errMsg, resp)
+			return 1
+		}
+		if opts["dry-run"] {
+			printDryRun(resp)
+			return 0
+		}
+		fmt.Println("OK")
 	case "append":
 		if len(args) < 3 {
 			fmt.Println("append <path> <text>")
-			os.Exit(2)
+			return 2
 		}
 		p := args[1]
 		data := []byte(args[2])
@@ -96,24 +210,90 @@ func main() {
 		resp, status, errMsg := post(url, req)
 		if status != proto.StatusOK {
 			printErr(status, errMsg, resp)
-			os.Exit(1)
+			return 1
 		}
 		fmt.Println("OK")
+	case "read":
+		rest, opts := splitOpts(args, "hex")
+		hexOut := opts["hex"]
+		if len(rest) < 2 {
+			fmt.Println("read <path> [offset] [length] [--hex]")
+			return 2
+		}
+		var offset, length uint64
+		if len(rest) >= 3 {
+			v, err := strconv.ParseUint(rest[2], 0, 32)
+			if err != nil {
+				fmt.Println("invalid offset:", rest[2])
+				return 2
+			}
+			offset = v
+		}
+		if len(rest) >= 4 {
+			v, err := strconv.ParseUint(rest[3], 0, 32)
+			if err != nil {
+				fmt.Println("invalid length:", rest[3])
+				return 2
+			}
+			length = v
+		}
+		if err := readFile(url, rest[1], offset, length, hexOut); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	case "get":
+		rest, opts := splitOpts(args, "restart", "if-changed")
+		if len(rest) < 3 {
+			fmt.Println("get <remote> <localfile> [--restart] [--if-changed]")
+			return 2
+		}
+		if err := getFile(url, rest[1], rest[2], opts["restart"], opts["if-changed"]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	case "put":
+		rest, opts := splitOpts(args, "force", "staged")
+		if len(rest) < 3 {
+			fmt.Println("put <localfile> <remote> [--force] [--staged]")
+			return 2
+		}
+		if err := putFile(url, rest[1], rest[2], opts["force"], opts["staged"]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
 	case "hash":
 		if len(args) < 2 {
-			fmt.Println("hash <path>")
-			os.Exit(2)
+			fmt.Println("hash <path> [crc32|sha256|crc16]")
+			return 2
+		}
+		var fl byte
+		if len(args) >= 3 {
+			switch strings.ToLower(args[2]) {
+			case "sha256":
+				fl = proto.FlagH_SHA256
+			case "crc16":
+				fl = proto.FlagH_CRC16
+			}
 		}
 		pl := buildPathOnly(args[1])
-		req := buildReq(proto.OpHASH, 0, pl)
+		req := buildReq(proto.OpHASH, fl, pl)
 		resp, status, errMsg := post(url, req)
 		if status != proto.StatusOK {
 			printErr(status, errMsg, resp)
-			os.Exit(1)
+			return 1
+		}
+		if fl == proto.FlagH_SHA256 && len(resp) == 32 {
+			fmt.Printf("SHA256=%x\n", resp)
+			return 0
+		}
+		if fl == proto.FlagH_CRC16 && len(resp) == 2 {
+			sum := binary.LittleEndian.Uint16(resp)
+			fmt.Printf("CRC16=0x%04X (%d)\n", sum, sum)
+			return 0
 		}
 		if len(resp) != 4 {
 			fmt.Printf("unexpected payload len=%d\n", len(resp))
-			os.Exit(1)
+			return 1
 		}
 		sum := binary.LittleEndian.Uint32(resp)
 		fmt.Printf("CRC32=0x%08X (%d)\n", sum, sum)
@@ -121,7 +301,7 @@ func main() {
 		if len(args) < 3 {
 			fmt.Println("search <base_path> <query> [start_index] [max_results] [max_scan_bytes] [flags]")
 			fmt.Println("flags: i=case-insensitive, r=recursive, w=whole-word (default: ir)")
-			os.Exit(2)
+			return 2
 		}
 		base := args[1]
 		q := args[2]
@@ -160,25 +340,155 @@ func main() {
 		resp, status, errMsg := post(url, req)
 		if status != proto.StatusOK {
 			printErr(status, errMsg, resp)
-			os.Exit(1)
+			return 1
 		}
 		printSearch(resp)
+	case "echo":
+		if len(args) < 2 {
+			fmt.Println("echo <delay_ms> [text]")
+			return 2
+		}
+		v, _ := strconv.ParseUint(args[1], 10, 16)
+		data := []byte(strings.Join(args[2:], " "))
+		pl := make([]byte, 2, 2+len(data))
+		binary.LittleEndian.PutUint16(pl, uint16(v))
+		pl = append(pl, data...)
+		req := buildReq(proto.OpECHO, 0, pl)
+		t0 := time.Now()
+		resp, status, errMsg := post(url, req)
+		rtt := time.Since(t0)
+		if status != proto.StatusOK {
+			printErr(status, errMsg, resp)
+			return 1
+		}
+		if len(resp) < 2 {
+			fmt.Printf("unexpected payload len=%d\n", len(resp))
+			return 1
+		}
+		delay := time.Duration(binary.LittleEndian.Uint16(resp)) * time.Millisecond
+		fmt.Printf("rtt=%v server_delay=%v net=%v data=%q\n", rtt.Round(time.Millisecond), delay, (rtt - delay).Round(time.Millisecond), resp[2:])
+	case "motd":
+		req := buildReq(proto.OpMOTD, 0, nil)
+		resp, status, errMsg := post(url, req)
+		if status != proto.StatusOK {
+			printErr(status, errMsg, resp)
+			return 1
+		}
+		if len(resp) < 2 || len(resp) < 2+int(binary.LittleEndian.Uint16(resp)) {
+			fmt.Printf("unexpected payload len=%d\n", len(resp))
+			return 1
+		}
+		fmt.Println(string(resp[2 : 2+int(binary.LittleEndian.Uint16(resp))]))
+	case "whoami":
+		req := buildReq(proto.OpWHOAMI, 0, nil)
+		resp, status, errMsg := post(url, req)
+		if status != proto.StatusOK {
+			printErr(status, errMsg, resp)
+			return 1
+		}
+		if len(resp) < 13 {
+			fmt.Printf("unexpected payload len=%d\n", len(resp))
+			return 1
+		}
+		fl := resp[0]
+		fmt.Printf("read_only=%t quota_full=%t\n", fl&proto.WhoReadOnly != 0, fl&proto.WhoQuotaFull != 0)
+		fmt.Printf("quota=%d used=%d max_file=%d\n", binary.LittleEndian.Uint32(resp[1:]), binary.LittleEndian.Uint32(resp[5:]), binary.LittleEndian.Uint32(resp[9:]))
+		fmt.Printf("disk_images=%t disk_images_write=%t\n", fl&proto.WhoDiskImages != 0, fl&proto.WhoDiskImagesWrite != 0)
+	case "images":
+		args, opts := splitOpts(args, "recursive")
+		var flags byte
+		if opts["recursive"] {
+			flags |= proto.FlagIM_RECURSIVE
+		}
+		p := "/"
+		if len(args) >= 2 {
+			p = args[1]
+		}
+		start := uint16(0)
+		if len(args) >= 3 {
+			v, _ := strconv.ParseUint(args[2], 10, 16)
+			start = uint16(v)
+		}
+		e := proto.NewEncoder(4 + len(p))
+		_ = e.WriteString(p)
+		e.WriteU16(start)
+		resp, status, errMsg := post(url, buildReq(proto.OpIMAGES, flags, e.Bytes()))
+		if status != proto.StatusOK {
+			printErr(status, errMsg, resp)
+			return 1
+		}
+		printImages(resp)
 	default:
 		fmt.Printf("unknown command: %s\n", cmd)
 		usage()
-		os.Exit(2)
+		return 2
 	}
+	return 0
 }
 
 func usage() {
-	fmt.Println("Usage: w64tool -url <endpoint> <command> [args]")
+	fmt.Println("Usage: w64tool -url <endpoint> [-compress] <command> [args]")
 	fmt.Println("Commands:")
 	fmt.Println("  caps")
 	fmt.Println("  ping")
-	fmt.Println("  ls <path> [start_index] [max_entries]")
+	fmt.Println("  ls <path> [start_index] [max_entries] [--sort=name|size|mtime|type] [--reverse]")
+	fmt.Println("  stat <path>")
+	fmt.Println("  read <path> [offset] [length] [--hex]   (length 0/omitted: up to EOF)")
+	fmt.Println("  get <remote> <localfile> [--restart]   (resumes into an existing localfile)")
+	fmt.Println("  get <remote> <localfile> --if-changed  (keeps localfile if its CRC32 matches)")
+	fmt.Println("  put <localfile> <remote> [--force] [--staged]   (--force replaces an existing file; --staged swaps it in when complete)")
 	fmt.Println("  append <path> <text>")
-	fmt.Println("  hash <path>")
+	fmt.Println("  hash <path> [crc32|sha256|crc16]")
+	fmt.Println("  mkdir <path> [-p]")
+	fmt.Println("  rm <path> [--dry-run]")
+	fmt.Println("  cp <src> <dst> [--force] [--recursive] [--dry-run]   (--dry-run lists what would change)")
+	fmt.Println("  mv <src> <dst> [--force] [--dry-run]")
 	fmt.Println("  search <base_path> <query> [start_index] [max_results] [max_scan_bytes] [flags]")
+	fmt.Println("  echo <delay_ms> [text]   (diagnostic, server needs enable_echo)")
+	fmt.Println("  motd")
+	fmt.Println("  whoami   (effective limits of the token)")
+	fmt.Println("  images [path] [start_index] [--recursive]   (disk images and their busy/lock state)")
+	fmt.Println("  shell   (interactive; reads commands from stdin)")
+}
+
+// acceptCompressed sets proto.ReqAcceptCompressed on every request (-compress);
+// post inflates the compressed responses.
+var acceptCompressed bool
+
+// splitOpts removes the --name / -name switches listed in names from the
+// subcommand arguments (the flag package stops at the first positional
+// argument) and returns the rest plus the switches that were set.
+func splitOpts(args []string, names ...string) ([]string, map[string]bool) {
+	set := make(map[string]bool)
+	rest := make([]string, 0, len(args))
+	for _, a := range args {
+		known := false
+		for _, n := range names {
+			if a == "--"+n || a == "-"+n {
+				set[n], known = true, true
+				break
+			}
+		}
+		if !known {
+			rest = append(rest, a)
+		}
+	}
+	return rest, set
+}
+
+// takeValueOpt removes a --name=value option from args and returns its value
+// ("" if absent).
+func takeValueOpt(args []string, name string) ([]string, string) {
+	rest := make([]string, 0, len(args))
+	val := ""
+	for _, a := range args {
+		if v, ok := strings.CutPrefix(a, "--"+name+"="); ok {
+			val = v
+			continue
+		}
+		rest = append(rest, a)
+	}
+	return rest, val
 }
 
 func buildReq(op byte, flags byte, payload []byte) []byte {
@@ -188,7 +498,11 @@ func buildReq(op byte, flags byte, payload []byte) []byte {
 	buf = append(buf, proto.Version)
 	buf = append(buf, op)
 	buf = append(buf, flags)
-	buf = append(buf, 0) // reserved
+	var reserved byte
+	if acceptCompressed {
+		reserved |= proto.ReqAcceptCompressed
+	}
+	buf = append(buf, reserved)
 	ln := uint16(len(payload))
 	buf = append(buf, byte(ln), byte(ln>>8))
 	buf = append(buf, payload...)
@@ -201,6 +515,13 @@ func buildPathOnly(p string) []byte {
 	return e.Bytes()
 }
 
+func buildTwoPaths(a, b string) []byte {
+	e := proto.NewEncoder(4 + len(a) + len(b))
+	_ = e.WriteString(a)
+	_ = e.WriteString(b)
+	return e.Bytes()
+}
+
 func buildLS(p string, start, max uint16) []byte {
 	e := proto.NewEncoder(64)
 	_ = e.WriteString(p)
@@ -209,6 +530,23 @@ func buildLS(p string, start, max uint16) []byte {
 	return e.Bytes()
 }
 
+func buildReadRange(p string, offset uint32, ln uint16) []byte {
+	e := proto.NewEncoder(2 + len(p) + 6)
+	_ = e.WriteString(p)
+	e.WriteU32(offset)
+	e.WriteU16(ln)
+	return e.Bytes()
+}
+
+func buildWriteRange(p string, offset uint32, data []byte) []byte {
+	e := proto.NewEncoder(2 + len(p) + 6 + len(data))
+	_ = e.WriteString(p)
+	e.WriteU32(offset)
+	e.WriteU16(uint16(len(data)))
+	e.WriteBytes(data)
+	return e.Bytes()
+}
+
 func buildAppend(p string, data []byte) []byte {
 	e := proto.NewEncoder(2 + len(p) + 2 + len(data))
 	_ = e.WriteString(p)
@@ -227,38 +565,84 @@ func buildSearch(base, q string, start, max uint16, maxScan uint32) []byte {
 	return e.Bytes()
 }
 
+// statusTransport is returned by post when there is no W64F response to
+// report (HTTP error, short or foreign body). Not a server status.
+const statusTransport byte = 0xFF
+
 func post(url string, req []byte) (respPayload []byte, status byte, errMsg string) {
+	respPayload, status, _, errMsg = postFlags(url, req)
+	return
+}
+
+// postFlags is post that also returns the response header flags
+// (proto.RespFromImage).
+func postFlags(url string, req []byte) (respPayload []byte, status, flags byte, errMsg string) {
 	r, err := http.Post(url, "application/octet-stream", bytes.NewReader(req))
 	if err != nil {
-		fmt.Println("http error:", err)
-		os.Exit(1)
+		return nil, statusTransport, 0, "http error: " + err.Error()
 	}
 	defer r.Body.Close()
 	data, _ := io.ReadAll(r.Body)
 	if len(data) < proto.HeaderSize {
-		fmt.Printf("invalid response (len=%d)\n", len(data))
-		os.Exit(1)
+		return nil, statusTransport, 0, fmt.Sprintf("invalid response (HTTP %d, len=%d)", r.StatusCode, len(data))
 	}
 	if string(data[0:4]) != proto.Magic {
-		fmt.Printf("invalid magic: %q\n", string(data[0:4]))
-		os.Exit(1)
+		return nil, statusTransport, 0, fmt.Sprintf("invalid magic: %q", string(data[0:4]))
 	}
 	// Response header layout matches request header:
-	// magic(4) ver(1) op_echo(1) status(1) reserved(1) payload_len(2)
+	// magic(4) ver(1) op_echo(1) status(1) flags(1) payload_len(2)
 	status = data[6]
+	flags = data[7]
 	ln := binary.LittleEndian.Uint16(data[8:10])
 	respPayload = data[proto.HeaderSize:]
 	if int(ln) != len(respPayload) {
 		fmt.Printf("length mismatch header=%d body=%d\n", ln, len(respPayload))
 		// still return what we got
 	}
+	if flags&proto.RespCompressed != 0 {
+		raw, err := inflatePayload(respPayload)
+		if err != nil {
+			return nil, statusTransport, 0, "invalid compressed response: " + err.Error()
+		}
+		respPayload = raw
+	}
 	if status != proto.StatusOK {
 		errMsg = printErrMsgIfAny(respPayload)
 	}
 	return
 }
 
+// inflatePayload decodes a proto.RespCompressed payload: orig_len u16 and a
+// raw DEFLATE stream.
+func inflatePayload(p []byte) ([]byte, error) {
+	if len(p) < 2 {
+		return nil, fmt.Errorf("short payload")
+	}
+	n := int(binary.LittleEndian.Uint16(p[0:2]))
+	zr := flate.NewReader(bytes.NewReader(p[2:]))
+	defer zr.Close()
+	raw, err := io.ReadAll(io.LimitReader(zr, int64(n)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != n {
+		return nil, fmt.Errorf("inflated %d bytes, want %d", len(raw), n)
+	}
+	return raw, nil
+}
+
+// printFromImage notes a response the server served from a mounted disk image.
+func printFromImage(flags byte) {
+	if flags&proto.RespFromImage != 0 {
+		fmt.Println("(from image)")
+	}
+}
+
 func printErr(status byte, errMsg string, payload []byte) {
+	if status == statusTransport {
+		fmt.Println("ERROR", errMsg)
+		return
+	}
 	fmt.Printf("ERROR status=%d\n", status)
 	if errMsg != "" {
 		fmt.Println("message:", errMsg)
@@ -267,6 +651,29 @@ func printErr(status byte, errMsg string, payload []byte) {
 	}
 }
 
+// printDryRun prints the paths a FlagDRY_RUN request reported.
+func printDryRun(payload []byte) {
+	d := proto.NewDecoder(payload)
+	count, err1 := d.ReadU16()
+	listed, err2 := d.ReadU16()
+	if err1 != nil || err2 != nil {
+		fmt.Println("decode error: short dry run response")
+		return
+	}
+	fmt.Printf("dry run, nothing changed: %d path(s)\n", count)
+	for i := 0; i < int(listed); i++ {
+		p, err := d.ReadString(512)
+		if err != nil {
+			fmt.Println("decode error:", err)
+			return
+		}
+		fmt.Println(" ", p)
+	}
+	if int(count) > int(listed) {
+		fmt.Printf("  ... (+%d more)\n", int(count)-int(listed))
+	}
+}
+
 func printErrMsgIfAny(payload []byte) string {
 	// Server may return errmsg as string payload on errors if enable_errmsg=true.
 	d := proto.NewDecoder(payload)
@@ -278,22 +685,41 @@ func printErrMsgIfAny(payload []byte) string {
 }
 
 func printCaps(payload []byte) {
+	c, err := parseCaps(payload)
+	if err != nil {
+		fmt.Println("decode error:", err)
+		return
+	}
+	fmt.Printf("max_chunk:   %d\n", c.MaxChunk)
+	fmt.Printf("max_payload: %d\n", c.MaxPayload)
+	fmt.Printf("max_path:    %d\n", c.MaxPath)
+	fmt.Printf("max_name:    %d\n", c.MaxName)
+	fmt.Printf("max_entries: %d\n", c.MaxEntries)
+	if c.MaxDecompressed != 0 {
+		fmt.Printf("max_decompressed: %d\n", c.MaxDecompressed)
+	}
+	if c.PreviewLen != 0 {
+		fmt.Printf("preview_len: %d\n", c.PreviewLen)
+	}
+	fmt.Printf("features:    0x%016X\n", c.Features)
+	fmt.Printf("server_time: %s\n", time.Unix(int64(c.ServerTime), 0).Format(time.RFC3339))
+	fmt.Printf("server_name: %q\n", c.ServerName)
+}
+
+func printStat(payload []byte) {
 	d := proto.NewDecoder(payload)
-	protoVer, _ := d.ReadU16()
-	maxPayload, _ := d.ReadU16()
-	maxChunk, _ := d.ReadU16()
-	maxPath, _ := d.ReadU16()
-	maxName, _ := d.ReadU16()
-	features, _ := d.ReadU32()
-	serverName, _ := d.ReadString(128)
-
-	fmt.Printf("proto_ver:   %d\n", protoVer)
-	fmt.Printf("max_payload: %d\n", maxPayload)
-	fmt.Printf("max_chunk:   %d\n", maxChunk)
-	fmt.Printf("max_path:    %d\n", maxPath)
-	fmt.Printf("max_name:    %d\n", maxName)
-	fmt.Printf("features:    0x%08X\n", features)
-	fmt.Printf("server_name: %q\n", serverName)
+	typ, _ := d.ReadU8()
+	sz, _ := d.ReadU32()
+	mt, err := d.ReadU32()
+	if err != nil {
+		fmt.Println("decode error:", err)
+		return
+	}
+	kind := "FILE"
+	if typ == 1 {
+		kind = "DIR"
+	}
+	fmt.Printf("type=%s size=%d mtime=%d (%s)\n", kind, sz, mt, time.Unix(int64(mt), 0).Format(time.RFC3339))
 }
 
 func printLS(payload []byte) {
@@ -310,9 +736,12 @@ func printLS(payload []byte) {
 		mt, _ := d.ReadU32()
 		name, _ := d.ReadString(255)
 		kind := "FILE"
-		if typ == 1 {
+		if typ&^proto.LSEntryTruncated == 1 {
 			kind = "DIR"
 		}
+		if typ&proto.LSEntryTruncated != 0 {
+			name += " (truncated)"
+		}
 		fmt.Printf("  %-4s %10d mtime=%d name=%s\n", kind, sz, mt, name)
 	}
 	next, _ := d.ReadU16()
@@ -323,6 +752,51 @@ func printLS(payload []byte) {
 	}
 }
 
+func printImages(payload []byte) {
+	d := proto.NewDecoder(payload)
+	count, err := d.ReadU16()
+	if err != nil {
+		fmt.Println("decode error:", err)
+		return
+	}
+	fmt.Printf("count=%d\n", count)
+	for i := 0; i < int(count); i++ {
+		p, _ := d.ReadString(512)
+		kind, _ := d.ReadU8()
+		sz, _ := d.ReadU32()
+		free, _ := d.ReadU16()
+		fl, _ := d.ReadU8()
+		age, err := d.ReadU32()
+		if err != nil {
+			fmt.Println("decode error:", err)
+			return
+		}
+		kinds := map[byte]string{proto.ImageKindD64: "D64", proto.ImageKindD71: "D71", proto.ImageKindD81: "D81", proto.ImageKindT64: "T64", proto.ImageKindD82: "D82"}
+		line := fmt.Sprintf("  %-3s %8d", kinds[kind], sz)
+		if free == 0xFFFF {
+			line += "  free=?"
+		} else {
+			line += fmt.Sprintf("  free=%d", free)
+		}
+		if fl&proto.ImgBusy != 0 {
+			line += " BUSY"
+		}
+		if fl&proto.ImgLocked != 0 {
+			line += " LOCKED"
+		}
+		if age != 0xFFFFFFFF {
+			line += fmt.Sprintf(" last_write=%ds ago", age)
+		}
+		fmt.Println(line, p)
+	}
+	next, _ := d.ReadU16()
+	if next == 0xFFFF {
+		fmt.Println("next_index=END")
+	} else {
+		fmt.Printf("next_index=%d\n", next)
+	}
+}
+
 func printSearch(payload []byte) {
 	d := proto.NewDecoder(payload)
 	count, err := d.ReadU16()
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"wicos64-server/internal/proto"
+)
+
+// serverCaps is a decoded CAPS payload.
+type serverCaps struct {
+	MaxChunk   uint16
+	MaxPayload uint16
+	MaxPath    uint16
+	MaxName    uint16
+	MaxEntries uint16
+	Features   uint64
+	ServerTime uint32
+	ServerName string
+	// MaxDecompressed and PreviewLen are 0 on servers that do not report them.
+	MaxDecompressed uint16
+	PreviewLen      uint16
+}
+
+// parseCaps decodes a CAPS payload: max_chunk, max_payload, max_path,
+// max_name, max_entries (u16 each), features_lo u32, server_time u32,
+// server_name string and, on newer servers, max_decompressed u16,
+// features_hi u32 and preview_len u16.
+func parseCaps(payload []byte) (serverCaps, error) {
+	var c serverCaps
+	d := proto.NewDecoder(payload)
+	for _, f := range []*uint16{&c.MaxChunk, &c.MaxPayload, &c.MaxPath, &c.MaxName, &c.MaxEntries} {
+		v, err := d.ReadU16()
+		if err != nil {
+			return c, fmt.Errorf("CAPS: %w", err)
+		}
+		*f = v
+	}
+	lo, err := d.ReadU32()
+	if err != nil {
+		return c, fmt.Errorf("CAPS: %w", err)
+	}
+	c.ServerTime, _ = d.ReadU32()
+	c.ServerName, _ = d.ReadString(128)
+	c.MaxDecompressed, _ = d.ReadU16()
+	hi, _ := d.ReadU32()
+	c.Features = uint64(hi)<<32 | uint64(lo)
+	c.PreviewLen, _ = d.ReadU16()
+	return c, nil
+}
+
+// fetchCaps asks the server for its limits.
+func fetchCaps(url string) (serverCaps, error) {
+	resp, status, errMsg := post(url, buildReq(proto.OpCAPS, 0, nil))
+	if status != proto.StatusOK {
+		return serverCaps{}, statusError("CAPS", status, errMsg)
+	}
+	c, err := parseCaps(resp)
+	if err != nil {
+		return serverCaps{}, err
+	}
+	if c.MaxChunk == 0 {
+		return serverCaps{}, fmt.Errorf("CAPS: server reports max_chunk=0")
+	}
+	return c, nil
+}
+
+// chunkSize returns the largest data chunk for a request on path p whose
+// payload carries overhead bytes besides the path string and the data.
+func (c serverCaps) chunkSize(p string, overhead int) int {
+	n := int(c.MaxChunk)
+	if c.MaxPayload > 0 {
+		n = min(n, int(c.MaxPayload)-2-len(p)-overhead)
+	}
+	return n
+}
+
+// statSize returns the size of a file via STAT (type u8, size u32, mtime u32).
+func statSize(url, p string) (uint64, error) {
+	resp, status, errMsg := post(url, buildReq(proto.OpSTAT, 0, buildPathOnly(p)))
+	if status != proto.StatusOK {
+		return 0, statusError("STAT", status, errMsg)
+	}
+	if len(resp) < 5 {
+		return 0, fmt.Errorf("STAT: unexpected payload len=%d", len(resp))
+	}
+	if resp[0] == 1 {
+		return 0, fmt.Errorf("%s: is a directory", p)
+	}
+	return uint64(binary.LittleEndian.Uint32(resp[1:5])), nil
+}
+
+func statusError(op string, status byte, errMsg string) error {
+	if status == statusTransport {
+		return fmt.Errorf("%s failed: %s", op, errMsg)
+	}
+	if errMsg != "" {
+		return fmt.Errorf("%s failed: status=%d (%s)", op, status, errMsg)
+	}
+	return fmt.Errorf("%s failed: status=%d", op, status)
+}
+
+// readFile reads length bytes (0: up to EOF) of p starting at offset with as
+// many READ_RANGE requests as max_chunk requires, and writes them to stdout
+// as raw bytes or as a hex dump.
+//
+// The length is clamped to the file size from STAT first: disk image mounts
+// answer a range past EOF with StatusRangeInvalid instead of a short read.
+func readFile(url, p string, offset, length uint64, hexOut bool) error {
+	caps, err := fetchCaps(url)
+	if err != nil {
+		return err
+	}
+	size, err := statSize(url, p)
+	if err != nil {
+		return err
+	}
+	if offset > size {
+		return fmt.Errorf("offset %d beyond EOF (size %d)", offset, size)
+	}
+	if length == 0 || length > size-offset {
+		length = size - offset
+	}
+
+	var out io.Writer = os.Stdout
+	var hd *hexDumper
+	if hexOut {
+		hd = &hexDumper{w: os.Stdout, off: offset}
+		out = hd
+	}
+	if _, err := readRangeTo(url, p, offset, offset+length, caps.chunkSize(p, 6), out, nil); err != nil {
+		return err
+	}
+	if hd != nil {
+		return hd.Close()
+	}
+	return nil
+}
+
+// readRangeTo copies [pos, end) of p to w with READ_RANGE requests of at
+// most chunk bytes and returns the number of bytes copied. An empty or short
+// answer means EOF (the file shrank meanwhile) and ends the copy early.
+func readRangeTo(url, p string, pos, end uint64, chunk int, w io.Writer, progress func(done uint64)) (uint64, error) {
+	return chunkLoop(pos, end, chunk, func(pos uint64, n int) (int, error) {
+		resp, status, errMsg := post(url, buildReq(proto.OpREAD_RANGE, 0, buildReadRange(p, uint32(pos), uint16(n))))
+		if status != proto.StatusOK {
+			return 0, statusError(fmt.Sprintf("READ_RANGE @%d", pos), status, errMsg)
+		}
+		if len(resp) > n {
+			return 0, fmt.Errorf("READ_RANGE @%d: got %d bytes, asked for %d", pos, len(resp), n)
+		}
+		if _, err := w.Write(resp); err != nil {
+			return 0, err
+		}
+		return len(resp), nil
+	}, progress)
+}
+
+// chunkLoop calls step for consecutive chunks of [pos, end), each at most
+// chunk bytes, and returns the bytes transferred. step reports how many
+// bytes it moved; less than asked ends the loop. progress (optional) is
+// called with the running total after every chunk.
+func chunkLoop(pos, end uint64, chunk int, step func(pos uint64, n int) (int, error), progress func(done uint64)) (uint64, error) {
+	if chunk <= 0 {
+		return 0, fmt.Errorf("no room for data in a request (path too long for max_payload?)")
+	}
+	var done uint64
+	for pos < end {
+		want := int(min(uint64(chunk), end-pos))
+		got, err := step(pos, want)
+		if err != nil {
+			return done, err
+		}
+		pos += uint64(got)
+		done += uint64(got)
+		if progress != nil {
+			progress(done)
+		}
+		if got < want {
+			break
+		}
+	}
+	return done, nil
+}
+
+// hexDumper writes a canonical hex dump (offset, 16 bytes, ASCII column)
+// with offsets relative to the start of the file.
+type hexDumper struct {
+	w   io.Writer
+	off uint64
+	buf []byte
+}
+
+func (h *hexDumper) Write(p []byte) (int, error) {
+	h.buf = append(h.buf, p...)
+	for len(h.buf) >= 16 {
+		if err := h.line(h.buf[:16]); err != nil {
+			return 0, err
+		}
+		h.buf = h.buf[16:]
+	}
+	return len(p), nil
+}
+
+// Close writes the last, partial line.
+func (h *hexDumper) Close() error {
+	if len(h.buf) == 0 {
+		return nil
+	}
+	err := h.line(h.buf)
+	h.buf = nil
+	return err
+}
+
+func (h *hexDumper) line(b []byte) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%08x  ", h.off)
+	for i := 0; i < 16; i++ {
+		if i < len(b) {
+			fmt.Fprintf(&sb, "%02x ", b[i])
+		} else {
+			sb.WriteString("   ")
+		}
+		if i == 7 {
+			sb.WriteByte(' ')
+		}
+	}
+	sb.WriteString(" |")
+	for _, c := range b {
+		if c < 0x20 || c > 0x7E {
+			c = '.'
+		}
+		sb.WriteByte(c)
+	}
+	sb.WriteString("|\n")
+	h.off += uint64(len(b))
+	_, err := io.WriteString(h.w, sb.String())
+	return err
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+	"wicos64-server/internal/server"
+)
+
+// testServer is a server.New instance behind httptest that counts the
+// requests per opcode.
+type testServer struct {
+	url     string
+	rootAbs string
+
+	mu  sync.Mutex
+	ops map[byte]int
+}
+
+func (ts *testServer) count(op byte) int {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.ops[op]
+}
+
+func startTestServer(t *testing.T, edit func(*config.Config)) *testServer {
+	t.Helper()
+	cfg := config.Default()
+	cfg.BasePath = t.TempDir()
+	cfg.Discovery.Enabled = false
+	if edit != nil {
+		edit(&cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	s := server.New(cfg, "")
+	t.Cleanup(func() { _ = s.Shutdown(context.Background()) })
+	ts := &testServer{rootAbs: cfg.BasePath, ops: make(map[byte]int)}
+	h := s.HTTPHandler()
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if len(body) > 5 {
+			ts.mu.Lock()
+			ts.ops[body[5]]++
+			ts.mu.Unlock()
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		h.ServeHTTP(w, r)
+	}))
+	t.Cleanup(hs.Close)
+	ts.url = hs.URL + cfg.Endpoint + "?token="
+	return ts
+}
+
+// capture returns what fn printed to os.Stdout and os.Stderr.
+func capture(t *testing.T, fn func()) (stdout, stderr string) {
+	t.Helper()
+	pipe := func(f **os.File) (func() string, error) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		old := *f
+		*f = w
+		done := make(chan string)
+		go func() {
+			b, _ := io.ReadAll(r)
+			r.Close()
+			done <- string(b)
+		}()
+		return func() string {
+			*f = old
+			w.Close()
+			return <-done
+		}, nil
+	}
+	endOut, err := pipe(&os.Stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	endErr, err := pipe(&os.Stderr)
+	if err != nil {
+		endOut()
+		t.Fatal(err)
+	}
+	defer func() {
+		stdout, stderr = endOut(), endErr()
+	}()
+	fn()
+	return
+}
+
+// runTool runs one subcommand and returns its exit code and output.
+func runTool(t *testing.T, url string, args ...string) (code int, stdout, stderr string) {
+	t.Helper()
+	stdout, stderr = capture(t, func() { code = run(url, args) })
+	return code, stdout, stderr
+}
+
+func TestReadSubcommand(t *testing.T) {
+	// 32-byte chunks, so most reads below take several requests.
+	ts := startTestServer(t, func(c *config.Config) {
+		c.MaxChunk = 32
+		c.SearchPreviewBytes = 16
+	})
+	var data []byte
+	for i := 0; i < 100; i++ {
+		data = append(data, byte('A'+i%26))
+	}
+	// Written through the tool, one chunk per APPEND.
+	for off := 0; off < len(data); off += 25 {
+		part := string(data[off : off+25])
+		if code, out, _ := runTool(t, ts.url, "append", "/F.SEQ", part); code != 0 || out != "OK\n" {
+			t.Fatalf("append = %d %q", code, out)
+		}
+	}
+
+	read := func(args ...string) (int, string, string) {
+		return runTool(t, ts.url, append([]string{"read"}, args...)...)
+	}
+
+	cases := []struct {
+		args  []string
+		want  []byte
+		reads int
+	}{
+		{[]string{"/F.SEQ"}, data, 4},
+		{[]string{"/F.SEQ", "10", "5"}, data[10:15], 1},
+		{[]string{"/F.SEQ", "0x10", "40"}, data[16:56], 2},
+		{[]string{"/F.SEQ", "90", "50"}, data[90:], 1},  // clamped to EOF
+		{[]string{"/F.SEQ", "100"}, nil, 0},             // empty at EOF
+		{[]string{"/F.SEQ", "0", "100"}, data, 4},       // exactly the file
+		{[]string{"/F.SEQ", "31", "2"}, data[31:33], 1}, // across a chunk edge
+	}
+	for _, tc := range cases {
+		before := ts.count(proto.OpREAD_RANGE)
+		code, out, _ := read(tc.args...)
+		if code != 0 || out != string(tc.want) {
+			t.Errorf("read %v = %d %q, want %q", tc.args, code, out, tc.want)
+		}
+		if n := ts.count(proto.OpREAD_RANGE) - before; n != tc.reads {
+			t.Errorf("read %v took %d READ_RANGE requests, want %d", tc.args, n, tc.reads)
+		}
+	}
+
+	code, out, _ := read("/F.SEQ", "20", "20", "--hex")
+	want := "00000014  55 56 57 58 59 5a 41 42  43 44 45 46 47 48 49 4a  |UVWXYZABCDEFGHIJ|\n" +
+		"00000024  4b 4c 4d 4e                                       |KLMN|\n"
+	if code != 0 || out != want {
+		t.Errorf("read --hex = %d\n%s\nwant\n%s", code, out, want)
+	}
+
+	if code, _, errOut := read("/F.SEQ", "101"); code != 1 || errOut != "offset 101 beyond EOF (size 100)\n" {
+		t.Errorf("read past EOF = %d %q", code, errOut)
+	}
+	if code, _, errOut := read("/MISSING"); code != 1 || !strings.Contains(errOut, "not found") {
+		t.Errorf("read of a missing file = %d %q", code, errOut)
+	}
+	if code, _, _ := read("/F.SEQ", "x"); code != 2 {
+		t.Errorf("read with a bad offset exit code %d, want 2", code)
+	}
+}
+
+// Disk image mounts answer READ_RANGE past EOF with RANGE_INVALID, so the
+// tool must stop at the size STAT reports.
+func TestReadSubcommandDiskImage(t *testing.T) {
+	ts := startTestServer(t, func(c *config.Config) {
+		c.MaxChunk = 32
+		c.SearchPreviewBytes = 16
+		c.DiskImagesEnabled = true
+		c.DiskImagesWriteEnabled = true
+	})
+	mk := proto.NewEncoder(32)
+	_ = mk.WriteString("/D.D64")
+	mk.WriteU8(proto.ImageKindD64)
+	_ = mk.WriteString("TEST")
+	_ = mk.WriteString("")
+	if _, st, msg := post(ts.url, buildReq(proto.OpMKIMAGE, 0, mk.Bytes())); st != proto.StatusOK {
+		t.Fatalf("MKIMAGE = %d (%s)", st, msg)
+	}
+	data := strings.Repeat("0123456789", 7)
+	for off := 0; off < len(data); off += 10 {
+		if _, st, msg := post(ts.url, buildReq(proto.OpWRITE_RANGE, proto.FlagWR_CREATE, buildWriteRange("/D.D64/P", uint32(off), []byte(data[off:off+10])))); st != proto.StatusOK {
+			t.Fatalf("WRITE_RANGE @%d = %d (%s)", off, st, msg)
+		}
+	}
+	if code, out, _ := runTool(t, ts.url, "read", "/D.D64/P", "60", "1000"); code != 0 || out != data[60:] {
+		t.Fatalf("read inside the image = %d %q, want %q", code, out, data[60:])
+	}
+
+	// stat marks answers served from an image.
+	if err := os.WriteFile(filepath.Join(ts.rootAbs, "H"), []byte("h"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if code, out, _ := runTool(t, ts.url, "stat", "/D.D64/P"); code != 0 || !strings.HasSuffix(out, "(from image)\n") {
+		t.Fatalf("stat inside the image = %d %q", code, out)
+	}
+	if code, out, _ := runTool(t, ts.url, "stat", "/H"); code != 0 || strings.Contains(out, "from image") {
+		t.Fatalf("stat of a host file = %d %q", code, out)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"wicos64-server/internal/proto"
+)
+
+// shellHistoryMax bounds the in-memory history of the shell.
+const shellHistoryMax = 1000
+
+// shellPathArgs lists, per subcommand, the positional arguments that are
+// remote paths and get resolved against the shell's current directory.
+var shellPathArgs = map[string][]int{
+	"ls":     {1},
+	"stat":   {1},
+	"read":   {1},
+	"append": {1},
+	"hash":   {1},
+	"search": {1},
+	"cp":     {1, 2},
+	"mv":     {1, 2},
+	"rm":     {1},
+	"mkdir":  {1},
+	"get":    {1},
+	"put":    {2},
+}
+
+// shell is an interactive session: the subcommands of run against one URL,
+// plus a current directory for relative paths and a command history.
+type shell struct {
+	url     string
+	cwd     string
+	history []string
+}
+
+// runShell reads commands from in until EOF or exit.
+func runShell(url string, in io.Reader) {
+	sh := &shell{url: url, cwd: "/"}
+	sc := bufio.NewScanner(in)
+	sc.Buffer(make([]byte, 0, 4096), 64*1024)
+	for {
+		fmt.Printf("w64:%s> ", sh.cwd)
+		if !sc.Scan() {
+			fmt.Println()
+			return
+		}
+		if !sh.exec(sc.Text()) {
+			return
+		}
+	}
+}
+
+// exec runs one input line. It returns false when the session should end.
+func (sh *shell) exec(line string) bool {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return true
+	}
+	if strings.HasPrefix(line, "!") {
+		prev, err := sh.recall(line)
+		if err != nil {
+			fmt.Println(err)
+			return true
+		}
+		line = prev
+		fmt.Println(line)
+	}
+	sh.history = append(sh.history, line)
+	if len(sh.history) > shellHistoryMax {
+		sh.history = sh.history[len(sh.history)-shellHistoryMax:]
+	}
+
+	args, err := splitLine(line)
+	if err != nil {
+		fmt.Println(err)
+		return true
+	}
+	cmd := strings.ToLower(args[0])
+	args[0] = cmd
+	switch cmd {
+	case "exit", "quit":
+		return false
+	case "help", "?":
+		shellHelp()
+	case "pwd":
+		fmt.Println(sh.cwd)
+	case "url":
+		fmt.Println(sh.url)
+	case "history":
+		for i, h := range sh.history {
+			fmt.Printf("%5d  %s\n", i+1, h)
+		}
+	case "cd":
+		dir := "/"
+		if len(args) >= 2 {
+			dir = sh.resolve(args[1])
+		}
+		if err := sh.chdir(dir); err != nil {
+			fmt.Println(err)
+		}
+	case "shell":
+		fmt.Println("already in the shell")
+	default:
+		if cmd == "ls" {
+			// Options go last so the path stays at index 1.
+			var plain, opts []string
+			for _, a := range args {
+				if strings.HasPrefix(a, "--") {
+					opts = append(opts, a)
+				} else {
+					plain = append(plain, a)
+				}
+			}
+			if len(plain) == 1 {
+				plain = append(plain, sh.cwd)
+			}
+			args = append(plain, opts...)
+		}
+		if idx, ok := shellPathArgs[cmd]; ok {
+			sh.resolveArgs(args, idx)
+		}
+		run(sh.url, args)
+	}
+	return true
+}
+
+// recall expands !! (last command) and !n (history entry n).
+func (sh *shell) recall(ref string) (string, error) {
+	if len(sh.history) == 0 {
+		return "", fmt.Errorf("%s: history is empty", ref)
+	}
+	if ref == "!!" {
+		return sh.history[len(sh.history)-1], nil
+	}
+	n, err := strconv.Atoi(ref[1:])
+	if err != nil || n < 1 || n > len(sh.history) {
+		return "", fmt.Errorf("%s: no such history entry", ref)
+	}
+	return sh.history[n-1], nil
+}
+
+// resolve makes p absolute against the current directory.
+func (sh *shell) resolve(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		p = sh.cwd + "/" + p
+	}
+	return path.Clean(p)
+}
+
+// resolveArgs resolves the positional arguments listed in idx in place.
+// Switches (-x, --xyz) are not counted as positions.
+func (sh *shell) resolveArgs(args []string, idx []int) {
+	pos := 0
+	for i, a := range args {
+		if i > 0 && len(a) > 1 && strings.HasPrefix(a, "-") {
+			continue
+		}
+		for _, want := range idx {
+			if pos == want {
+				args[i] = sh.resolve(a)
+			}
+		}
+		pos++
+	}
+}
+
+// chdir changes the current directory after checking with STAT that dir
+// exists and is a directory.
+func (sh *shell) chdir(dir string) error {
+	resp, status, errMsg := post(sh.url, buildReq(proto.OpSTAT, 0, buildPathOnly(dir)))
+	if status != proto.StatusOK {
+		return statusError("cd "+dir, status, errMsg)
+	}
+	if len(resp) < 1 || resp[0] != 1 {
+		return fmt.Errorf("cd %s: not a directory", dir)
+	}
+	sh.cwd = dir
+	return nil
+}
+
+// splitLine splits a command line at blanks. Single or double quotes group
+// words ("hello world"); there are no escapes.
+func splitLine(line string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	inWord := false
+	var quote rune
+	for _, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				args = append(args, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inWord {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+func shellHelp() {
+	fmt.Println("Commands (paths are relative to the current directory unless they start with /):")
+	fmt.Println("  ls [path] [start_index] [max_entries] [--sort=name|size|mtime|type] [--reverse]")
+	fmt.Println("  stat <path>")
+	fmt.Println("  read <path> [offset] [length] [--hex]")
+	fmt.Println("  get <remote> <localfile> [--restart] [--if-changed]")
+	fmt.Println("  put <localfile> <remote> [--force]")
+	fmt.Println("  append <path> <text>          (quote text with blanks)")
+	fmt.Println("  hash <path> [crc32|sha256|crc16]")
+	fmt.Println("  search <base_path> <query> [start_index] [max_results] [max_scan_bytes] [flags]")
+	fmt.Println("  mkdir <path> [-p]")
+	fmt.Println("  rm <path> [--dry-run]")
+	fmt.Println("  cp <src> <dst> [--force] [--recursive] [--dry-run]")
+	fmt.Println("  mv <src> <dst> [--force] [--dry-run]")
+	fmt.Println("  caps | ping | motd | echo <delay_ms> [text]")
+	fmt.Println("Shell:")
+	fmt.Println("  cd [path]   pwd   url   history   !! (repeat last)   !n (repeat entry n)   help   exit")
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestShellScript(t *testing.T) {
+	ts := startTestServer(t, nil)
+	script := strings.Join([]string{
+		"mkdir GAMES",
+		"cd GAMES",
+		"pwd",
+		`append HELLO.TXT "hi there"`,
+		"read HELLO.TXT",
+		"stat /GAMES/HELLO.TXT",
+		"cp HELLO.TXT COPY.TXT",
+		"mv COPY.TXT ../MOVED.TXT",
+		"cd ..",
+		"ls --sort=name",
+		"rm MOVED.TXT",
+		"cd NOPE",
+		"cd GAMES/HELLO.TXT",
+		"pwd",
+		"!4",
+		"!!",
+		"!99",
+		"history",
+		"exit",
+		"pwd",
+	}, "\n")
+	out, _ := capture(t, func() { runShell(ts.url, strings.NewReader(script)) })
+
+	// Each command's output follows the prompt that read it.
+	var got []string
+	for _, part := range strings.Split(out, "w64:")[1:] {
+		got = append(got, strings.TrimRight(part, "\n"))
+	}
+	want := []string{
+		"/> OK",
+		"/> ",
+		"/GAMES> /GAMES",
+		"/GAMES> OK",
+		"/GAMES> hi there",
+		"", // stat: checked below
+		"/GAMES> OK",
+		"/GAMES> OK",
+		"/GAMES> ",
+		"", // ls: checked below
+		"/> OK",
+		"/> cd /NOPE failed: status=1 (not found)",
+		"/> cd /GAMES/HELLO.TXT: not a directory",
+		"/> /",
+		"/> append HELLO.TXT \"hi there\"\nOK",
+		"/> append HELLO.TXT \"hi there\"\nOK",
+		"/> !99: no such history entry",
+		"", // history: checked below
+		"/> ",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("shell printed %d prompts, want %d:\n%s", len(got), len(want), out)
+	}
+	for i := range want {
+		if want[i] != "" && got[i] != want[i] {
+			t.Errorf("command %d printed %q, want %q", i+1, got[i], want[i])
+		}
+	}
+	if !strings.HasPrefix(got[5], "/GAMES> type=FILE size=8 ") {
+		t.Errorf("stat printed %q", got[5])
+	}
+	for _, name := range []string{"name=GAMES", "name=MOVED.TXT", "next_index=END"} {
+		if !strings.Contains(got[9], name) {
+			t.Errorf("ls output lacks %q:\n%s", name, got[9])
+		}
+	}
+	// Relative paths resolved against the current directory, including
+	// the ones repeated from history (which ran in / by then).
+	b, err := os.ReadFile(filepath.Join(ts.rootAbs, "HELLO.TXT"))
+	if err != nil || string(b) != "hi therehi there" {
+		t.Errorf("/HELLO.TXT = %q, %v", b, err)
+	}
+	if b, err := os.ReadFile(filepath.Join(ts.rootAbs, "GAMES", "HELLO.TXT")); err != nil || string(b) != "hi there" {
+		t.Errorf("/GAMES/HELLO.TXT = %q, %v", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(ts.rootAbs, "MOVED.TXT")); err == nil {
+		t.Error("rm MOVED.TXT left the file")
+	}
+	// !n is stored expanded; a failed recall is not stored at all.
+	hist := strings.Split(strings.TrimPrefix(got[17], "/> "), "\n")
+	if len(hist) != 17 || strings.TrimSpace(hist[0]) != "1  mkdir GAMES" || strings.TrimSpace(hist[14]) != `15  append HELLO.TXT "hi there"` || strings.TrimSpace(hist[16]) != "17  history" {
+		t.Errorf("history =\n%s", strings.Join(hist, "\n"))
+	}
+}
+
+func TestShellResolve(t *testing.T) {
+	sh := &shell{cwd: "/GAMES"}
+	for in, want := range map[string]string{
+		"A.PRG":      "/GAMES/A.PRG",
+		"../A.PRG":   "/A.PRG",
+		"/X/./Y/..":  "/X",
+		"../../..":   "/",
+		"SUB/":       "/GAMES/SUB",
+		"./SUB//B.S": "/GAMES/SUB/B.S",
+	} {
+		if got := sh.resolve(in); got != want {
+			t.Errorf("resolve(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	args := []string{"cp", "--force", "A", "-x", "B", "C"}
+	sh.resolveArgs(args, []int{1, 2})
+	if want := []string{"cp", "--force", "/GAMES/A", "-x", "/GAMES/B", "C"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("resolveArgs = %q, want %q", args, want)
+	}
+}
+
+func TestSplitLine(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want []string
+	}{
+		{"ls  /A\t B", []string{"ls", "/A", "B"}},
+		{`append F "hello world"`, []string{"append", "F", "hello world"}},
+		{`append F 'say "hi"'`, []string{"append", "F", `say "hi"`}},
+		{`append F ""`, []string{"append", "F", ""}},
+		{`a"b c"d`, []string{"ab cd"}},
+	} {
+		got, err := splitLine(tc.in)
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("splitLine(%q) = %q, %v; want %q", tc.in, got, err, tc.want)
+		}
+	}
+	if _, err := splitLine(`append F "open`); err == nil {
+		t.Error("unterminated quote accepted")
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"wicos64-server/internal/proto"
+)
+
+// getFile downloads remote to local in max_chunk sized READ_RANGE requests.
+// An existing local file is taken as the start of an interrupted download
+// and continued from its size; restart starts over instead. With ifChanged
+// the local file is a cached copy: it is kept if the server reports the same
+// CRC32 (READ_RANGE FlagR_IF_HASH), otherwise downloaded again.
+func getFile(url, remote, local string, restart, ifChanged bool) error {
+	caps, err := fetchCaps(url)
+	if err != nil {
+		return err
+	}
+	size, err := statSize(url, remote)
+	if err != nil {
+		return err
+	}
+	if ifChanged {
+		same, err := cachedCopyCurrent(url, caps, remote, local, size)
+		if err != nil {
+			return err
+		}
+		if same {
+			fmt.Fprintf(os.Stderr, "%s unchanged\n", local)
+			return nil
+		}
+		restart = true
+	}
+
+	mode := os.O_WRONLY | os.O_CREATE
+	if restart {
+		mode |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(local, mode, 0o644)
+	if err != nil {
+		return err
+	}
+	have, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	start := uint64(have)
+	if start > size {
+		f.Close()
+		return fmt.Errorf("%s is larger than %s (%d > %d bytes); use --restart", local, remote, start, size)
+	}
+	if start > 0 {
+		fmt.Fprintf(os.Stderr, "resuming at %d\n", start)
+	}
+
+	p := newProgress(size)
+	n, err := readRangeTo(url, remote, start, size, caps.chunkSize(remote, 6), f, func(done uint64) { p.update(start + done) })
+	p.done()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if start+n < size {
+		return fmt.Errorf("%s shrank during the download (%d of %d bytes)", remote, start+n, size)
+	}
+	return nil
+}
+
+// cachedCopyCurrent reports whether local has the size and CRC32 of remote.
+// It sends a zero-length READ_RANGE with FlagR_IF_HASH, so no data is
+// transferred either way.
+func cachedCopyCurrent(url string, caps serverCaps, remote, local string, size uint64) (bool, error) {
+	if caps.Features&proto.FeatIF_HASH == 0 {
+		return false, nil
+	}
+	f, err := os.Open(local)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	h := crc32.NewIEEE()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return false, err
+	}
+	if uint64(n) != size {
+		return false, nil
+	}
+	e := proto.NewEncoder(12 + len(remote))
+	_ = e.WriteString(remote)
+	e.WriteU32(0)
+	e.WriteU16(0)
+	e.WriteU32(h.Sum32())
+	_, status, flags, errMsg := postFlags(url, buildReq(proto.OpREAD_RANGE, proto.FlagR_IF_HASH, e.Bytes()))
+	if status != proto.StatusOK {
+		return false, statusError("READ_RANGE", status, errMsg)
+	}
+	return flags&proto.RespNotModified != 0, nil
+}
+
+// putFile uploads local to remote in max_chunk sized WRITE_RANGE requests.
+// The first request creates/truncates the file; replacing an existing
+// non-empty file needs force (OVERWRITE).
+func putFile(url, local, remote string, force, staged bool) error {
+	caps, err := fetchCaps(url)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if st.IsDir() {
+		return fmt.Errorf("%s: is a directory", local)
+	}
+	size := uint64(st.Size())
+	if size > 0xFFFFFFFF {
+		return fmt.Errorf("%s: too large for W64F (u32 offsets)", local)
+	}
+
+	first := byte(proto.FlagWR_CREATE | proto.FlagWR_TRUNCATE)
+	if force {
+		first |= proto.FlagWR_OVERWRITE
+	}
+	// --staged: the server writes into a hidden copy and only replaces the
+	// file on the final (empty) COMMIT chunk.
+	var stage byte
+	if staged {
+		stage = proto.FlagWR_STAGED
+	}
+	write := func(pos uint64, data []byte) error {
+		fl := stage
+		if pos == 0 {
+			fl |= first
+		}
+		if staged && data == nil {
+			fl |= proto.FlagWR_COMMIT
+		}
+		req := buildWriteRange(remote, uint32(pos), data)
+		resp, status, errMsg := post(url, buildReq(proto.OpWRITE_RANGE, fl, req))
+		if status == proto.StatusAlreadyExists && force && len(resp) == 6 {
+			// overwrite_confirm: --force is the confirmation, send it back.
+			req = binary.LittleEndian.AppendUint32(req, binary.LittleEndian.Uint32(resp))
+			_, status, errMsg = post(url, buildReq(proto.OpWRITE_RANGE, fl|proto.FlagWR_CONFIRM, req))
+		}
+		if status != proto.StatusOK {
+			if status == proto.StatusAlreadyExists || (status == proto.StatusAccessDenied && !force) {
+				errMsg += "; use --force to replace it"
+			}
+			return statusError(fmt.Sprintf("WRITE_RANGE @%d", pos), status, errMsg)
+		}
+		return nil
+	}
+	if size == 0 {
+		return write(0, nil)
+	}
+
+	p := newProgress(size)
+	overhead := 6
+	if force {
+		overhead += 4 // room for an overwrite confirm
+	}
+	buf := make([]byte, caps.chunkSize(remote, overhead))
+	n, err := chunkLoop(0, size, len(buf), func(pos uint64, n int) (int, error) {
+		got, err := io.ReadFull(f, buf[:n])
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		if got == 0 {
+			return 0, nil
+		}
+		return got, write(pos, buf[:got])
+	}, p.update)
+	p.done()
+	if err != nil {
+		return err
+	}
+	if n < size {
+		return fmt.Errorf("%s shrank during the upload (%d of %d bytes)", local, n, size)
+	}
+	if staged {
+		return write(size, nil)
+	}
+	return nil
+}
+
+// progress prints a single, rewritten status line to stderr.
+type progress struct {
+	total uint64
+	shown bool
+}
+
+func newProgress(total uint64) *progress { return &progress{total: total} }
+
+func (p *progress) update(done uint64) {
+	pct := uint64(100)
+	if p.total > 0 {
+		pct = done * 100 / p.total
+	}
+	fmt.Fprintf(os.Stderr, "\r%d/%d bytes (%d%%)", done, p.total, pct)
+	p.shown = true
+}
+
+func (p *progress) done() {
+	if p.shown {
+		fmt.Fprintln(os.Stderr)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+func TestPutGetRoundTrip(t *testing.T) {
+	ts := startTestServer(t, func(c *config.Config) {
+		c.MaxChunk = 64
+		c.SearchPreviewBytes = 16
+	})
+	dir := t.TempDir()
+	data := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(data)
+	local := filepath.Join(dir, "UP.BIN")
+	if err := os.WriteFile(local, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	remote := func() []byte {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join(ts.rootAbs, "GAMES", "UP.BIN"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	if _, st, msg := post(ts.url, buildReq(proto.OpMKDIR, 0, buildPathOnly("/GAMES"))); st != proto.StatusOK {
+		t.Fatalf("MKDIR = %d (%s)", st, msg)
+	}
+
+	// One WRITE_RANGE per max_chunk (64) bytes: 16.
+	code, _, errOut := runTool(t, ts.url, "put", local, "/GAMES/UP.BIN")
+	if code != 0 || !bytes.Equal(remote(), data) {
+		t.Fatalf("put = %d %q", code, errOut)
+	}
+	if n := ts.count(proto.OpWRITE_RANGE); n != 16 {
+		t.Errorf("put took %d WRITE_RANGE requests, want 16", n)
+	}
+	if !strings.HasSuffix(errOut, "1000/1000 bytes (100%)\n") {
+		t.Errorf("put progress = %q", errOut)
+	}
+
+	// An existing file is only replaced with --force.
+	short := filepath.Join(dir, "SHORT.BIN")
+	if err := os.WriteFile(short, []byte("short"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	code, _, errOut = runTool(t, ts.url, "put", short, "/GAMES/UP.BIN")
+	if code != 1 || !strings.Contains(errOut, "use --force") || !bytes.Equal(remote(), data) {
+		t.Fatalf("put over an existing file = %d %q", code, errOut)
+	}
+	if code, _, errOut := runTool(t, ts.url, "put", short, "/GAMES/UP.BIN", "--force"); code != 0 || string(remote()) != "short" {
+		t.Fatalf("put --force = %d %q, remote %q", code, errOut, remote())
+	}
+	if code, _, errOut := runTool(t, ts.url, "put", local, "/GAMES/UP.BIN", "--force"); code != 0 || !bytes.Equal(remote(), data) {
+		t.Fatalf("put --force back = %d %q", code, errOut)
+	}
+	empty := filepath.Join(dir, "EMPTY")
+	if err := os.WriteFile(empty, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if code, _, errOut := runTool(t, ts.url, "put", empty, "/EMPTY"); code != 0 {
+		t.Fatalf("put of an empty file = %d %q", code, errOut)
+	}
+	if fi, err := os.Stat(filepath.Join(ts.rootAbs, "EMPTY")); err != nil || fi.Size() != 0 {
+		t.Fatalf("empty upload: %v", err)
+	}
+
+	down := filepath.Join(dir, "DOWN.BIN")
+	readsBefore := ts.count(proto.OpREAD_RANGE)
+	if code, _, errOut := runTool(t, ts.url, "get", "/GAMES/UP.BIN", down); code != 0 {
+		t.Fatalf("get = %d %q", code, errOut)
+	}
+	if got, _ := os.ReadFile(down); !bytes.Equal(got, data) {
+		t.Fatal("downloaded file differs")
+	}
+	if n := ts.count(proto.OpREAD_RANGE) - readsBefore; n != 16 {
+		t.Errorf("get took %d READ_RANGE requests, want 16", n)
+	}
+
+	// A partial local file is continued: 700 bytes left, 11 chunks.
+	if err := os.WriteFile(down, data[:300], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	readsBefore = ts.count(proto.OpREAD_RANGE)
+	code, _, errOut = runTool(t, ts.url, "get", "/GAMES/UP.BIN", down)
+	if code != 0 || !strings.HasPrefix(errOut, "resuming at 300\n") {
+		t.Fatalf("resumed get = %d %q", code, errOut)
+	}
+	if got, _ := os.ReadFile(down); !bytes.Equal(got, data) {
+		t.Fatal("resumed download differs")
+	}
+	if n := ts.count(proto.OpREAD_RANGE) - readsBefore; n != 11 {
+		t.Errorf("resumed get took %d READ_RANGE requests, want 11", n)
+	}
+
+	// A local file larger than the remote one is not a partial download.
+	if err := os.WriteFile(down, append(data, 'x'), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	code, _, errOut = runTool(t, ts.url, "get", "/GAMES/UP.BIN", down)
+	if code != 1 || !strings.Contains(errOut, "use --restart") {
+		t.Fatalf("get into a larger file = %d %q", code, errOut)
+	}
+	if code, _, errOut := runTool(t, ts.url, "get", "/GAMES/UP.BIN", down, "--restart"); code != 0 {
+		t.Fatalf("get --restart = %d %q", code, errOut)
+	}
+	if got, _ := os.ReadFile(down); !bytes.Equal(got, data) {
+		t.Fatal("restarted download differs")
+	}
+
+	if code, _, _ := runTool(t, ts.url, "get", "/MISSING", filepath.Join(dir, "M")); code != 1 {
+		t.Errorf("get of a missing file exit code %d, want 1", code)
+	}
+	if code, _, _ := runTool(t, ts.url, "put", filepath.Join(dir, "NOPE"), "/NOPE"); code != 1 {
+		t.Errorf("put of a missing local file exit code %d, want 1", code)
+	}
+}
+
+func TestChunkLoop(t *testing.T) {
+	var calls [][2]uint64
+	step := func(pos uint64, n int) (int, error) {
+		calls = append(calls, [2]uint64{pos, uint64(n)})
+		if pos >= 20 {
+			return 3, nil // short: EOF
+		}
+		return n, nil
+	}
+	var last uint64
+	done, err := chunkLoop(5, 40, 8, step, func(d uint64) { last = d })
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][2]uint64{{5, 8}, {13, 8}, {21, 8}}
+	if len(calls) != len(want) {
+		t.Fatalf("calls = %v, want %v", calls, want)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Fatalf("calls = %v, want %v", calls, want)
+		}
+	}
+	if done != 19 || last != 19 {
+		t.Errorf("done = %d, progress %d; want 19", done, last)
+	}
+	if _, err := chunkLoop(0, 10, 0, step, nil); err == nil {
+		t.Error("chunkLoop with no room for data succeeded")
+	}
+}
+
+func TestPutStaged(t *testing.T) {
+	ts := startTestServer(t, func(c *config.Config) {
+		c.MaxChunk = 64
+		c.SearchPreviewBytes = 16
+	})
+	remote := filepath.Join(ts.rootAbs, "F.BIN")
+	if err := os.WriteFile(remote, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("staged!"), 30)
+	local := filepath.Join(t.TempDir(), "F.BIN")
+	if err := os.WriteFile(local, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// 210 bytes: 4 chunks and the empty COMMIT.
+	if code, _, errOut := runTool(t, ts.url, "put", local, "/F.BIN", "--staged", "--force"); code != 0 {
+		t.Fatalf("put --staged = %d %q", code, errOut)
+	}
+	if n := ts.count(proto.OpWRITE_RANGE); n != 5 {
+		t.Errorf("put --staged took %d WRITE_RANGE requests, want 5", n)
+	}
+	if got, _ := os.ReadFile(remote); !bytes.Equal(got, data) {
+		t.Fatalf("remote after put --staged = %q", got)
+	}
+	if m, _ := filepath.Glob(filepath.Join(ts.rootAbs, ".wicos64-staged-*")); len(m) != 0 {
+		t.Fatalf("staging copies left: %v", m)
+	}
+}
+
+func TestGetIfChanged(t *testing.T) {
+	ts := startTestServer(t, func(c *config.Config) {
+		c.MaxChunk = 64
+		c.SearchPreviewBytes = 16
+	})
+	data := bytes.Repeat([]byte("cache me "), 50)
+	if err := os.WriteFile(filepath.Join(ts.rootAbs, "F.BIN"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	local := filepath.Join(t.TempDir(), "F.BIN")
+
+	// No cached copy yet: a normal download (450 bytes, 8 chunks).
+	if code, _, errOut := runTool(t, ts.url, "get", "/F.BIN", local, "--if-changed"); code != 0 {
+		t.Fatalf("first get = %d %q", code, errOut)
+	}
+	if got, _ := os.ReadFile(local); !bytes.Equal(got, data) {
+		t.Fatal("first download differs")
+	}
+
+	// An unchanged copy costs one empty READ_RANGE.
+	before := ts.count(proto.OpREAD_RANGE)
+	code, _, errOut := runTool(t, ts.url, "get", "/F.BIN", local, "--if-changed")
+	if code != 0 || !strings.Contains(errOut, "unchanged") {
+		t.Fatalf("get of an unchanged file = %d %q", code, errOut)
+	}
+	if n := ts.count(proto.OpREAD_RANGE) - before; n != 1 {
+		t.Errorf("unchanged get took %d READ_RANGE requests, want 1", n)
+	}
+
+	// Same size, other content: downloaded again from the start.
+	changed := bytes.ToUpper(data)
+	if err := os.WriteFile(filepath.Join(ts.rootAbs, "F.BIN"), changed, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	before = ts.count(proto.OpREAD_RANGE)
+	if code, _, errOut := runTool(t, ts.url, "get", "/F.BIN", local, "--if-changed"); code != 0 || strings.Contains(errOut, "unchanged") {
+		t.Fatalf("get of a changed file = %d %q", code, errOut)
+	}
+	if got, _ := os.ReadFile(local); !bytes.Equal(got, changed) {
+		t.Fatal("changed file not downloaded again")
+	}
+	if n := ts.count(proto.OpREAD_RANGE) - before; n != 1+8 {
+		t.Errorf("changed get took %d READ_RANGE requests, want 9", n)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -11,9 +12,12 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"wicos64-server/internal/config"
@@ -81,29 +85,53 @@ func main() {
 		os.Exit(1)
 	}
 
+	var certFile, keyFile string
+	if cfg.TLSEnabled() {
+		certFile, keyFile, err = resolveTLSFiles(cfg, configPath)
+		if err != nil {
+			log.Printf("FATAL: TLS setup: %v", err)
+			fmt.Fprintln(os.Stderr, "TLS setup failed:", err)
+			os.Exit(1)
+		}
+	}
+
 	srv := server.New(cfg, configPath)
 
 	log.Printf("WiCOS64 backend %s", version.Get().String())
 	log.Printf("Config: %s", configPath)
-	log.Printf("Listening on %s%s", cfg.Listen, cfg.Endpoint)
+	listeners := cfg.ListenerList()
+	for _, l := range listeners {
+		log.Printf("Listening on %s (%s)", l.Addr, serveDesc(l))
+	}
+	apiListen := cfg.ListenAddr(config.ServeAPI)
+	log.Printf("API endpoint: %s%s", apiListen, cfg.Endpoint)
+	for _, ep := range cfg.TokenEndpoints() {
+		log.Printf("Token endpoint: %s%s", apiListen, ep)
+	}
+	if certFile != "" {
+		log.Printf("TLS: %s", certFile)
+	}
 	log.Printf("Base path: %s", cfg.BasePath)
+	adminListen := cfg.ListenAddr(config.ServeAdmin)
 	if cfg.EnableAdminUI {
-		log.Printf("Admin UI: %s (localhost-only by default)", adminURLFromListen(cfg.Listen)+"/admin")
+		log.Printf("Admin UI: %s (localhost-only by default)", adminURLFromListen(adminListen, certFile != "")+"/admin")
 	}
 
-	h := srv.HTTPHandler()
-
-	// Bind first (so we can fail early), then serve.
-	ln, err := net.Listen("tcp", cfg.Listen)
-	if err != nil {
-		log.Printf("FATAL: listen %q failed: %v", cfg.Listen, err)
-		fmt.Fprintln(os.Stderr, "Listen failed:", err)
-		os.Exit(1)
+	// Bind all listeners first (so we can fail early), then serve.
+	lns := make([]net.Listener, 0, len(listeners))
+	for _, l := range listeners {
+		ln, err := net.Listen("tcp", l.Addr)
+		if err != nil {
+			log.Printf("FATAL: listen %q failed: %v", l.Addr, err)
+			fmt.Fprintln(os.Stderr, "Listen failed:", err)
+			os.Exit(1)
+		}
+		lns = append(lns, ln)
 	}
 
 	// Optionally open the admin UI after the server is up.
 	if openAdmin && cfg.EnableAdminUI {
-		url := adminURLFromListen(cfg.Listen) + "/admin"
+		url := adminURLFromListen(adminListen, certFile != "") + "/admin"
 		go func() {
 			// Small delay so the listener has time to accept.
 			time.Sleep(250 * time.Millisecond)
@@ -111,10 +139,63 @@ func main() {
 		}()
 	}
 
-	// Serve forever.
-	if err := http.Serve(ln, h); err != nil {
+	// Serve until SIGINT/SIGTERM or an admin shutdown request.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	servers := make([]*http.Server, len(listeners))
+	serveErr := make(chan error, len(listeners))
+	for i, l := range listeners {
+		hs := &http.Server{Handler: srv.HTTPHandlerFor(l)}
+		servers[i] = hs
+		ln := lns[i]
+		go func() {
+			if certFile != "" {
+				serveErr <- hs.ServeTLS(ln, certFile, keyFile)
+			} else {
+				serveErr <- hs.Serve(ln)
+			}
+		}()
+	}
+	select {
+	case err := <-serveErr:
 		log.Fatal(err)
+	case <-ctx.Done():
+	case <-srv.ShutdownRequested():
+	}
+	stop()
+
+	// Drain in-flight requests: a write or disk image rewrite that has
+	// started is allowed to finish. A second signal exits at once.
+	log.Printf("Shutting down (waiting up to %s for running requests)", shutdownTimeout)
+	sctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, hs := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := hs.Shutdown(sctx); err != nil {
+				log.Printf("shutdown: %v", err)
+			}
+		}()
 	}
+	wg.Wait()
+	if err := srv.Shutdown(sctx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+	log.Printf("Stopped")
+}
+
+// shutdownTimeout bounds how long a shutdown waits for in-flight requests
+// and async copies.
+const shutdownTimeout = 30 * time.Second
+
+// serveDesc lists the handler groups of a listener for the startup log.
+func serveDesc(l config.ListenerConfig) string {
+	if len(l.Serve) == 0 {
+		return config.ServeAll
+	}
+	return strings.Join(l.Serve, ", ")
 }
 
 func safeExeDir() string {
@@ -246,14 +327,18 @@ func setupLogFile(path string) error {
 	return nil
 }
 
-func adminURLFromListen(listen string) string {
+func adminURLFromListen(listen string, tls bool) string {
+	scheme := "http://"
+	if tls {
+		scheme = "https://"
+	}
 	host, port, err := net.SplitHostPort(listen)
 	if err != nil {
 		// Best-effort fallback.
 		if strings.HasPrefix(listen, ":") {
-			return "http://127.0.0.1" + listen
+			return scheme + "127.0.0.1" + listen
 		}
-		return "http://127.0.0.1:8080"
+		return scheme + "127.0.0.1:8080"
 	}
 	// If Listen binds to all interfaces, keep the admin URL on localhost.
 	if host == "" || host == "0.0.0.0" || host == "::" {
@@ -263,7 +348,7 @@ func adminURLFromListen(listen string) string {
 	if host == "localhost" {
 		host = "127.0.0.1"
 	}
-	return "http://" + net.JoinHostPort(host, port)
+	return scheme + net.JoinHostPort(host, port)
 }
 
 func openBrowser(url string) error {
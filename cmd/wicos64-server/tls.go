@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"wicos64-server/internal/config"
+)
+
+// resolveTLSFiles returns the certificate and key files to serve with.
+//
+// Without configured paths, tls_auto_self_signed uses tls-cert.pem and
+// tls-key.pem next to the config file. If auto mode is on and neither file
+// exists yet, a self-signed certificate for the listen host is generated and
+// written there, so the same certificate (and fingerprint) is reused on the
+// next start.
+func resolveTLSFiles(cfg config.Config, configPath string) (certFile, keyFile string, err error) {
+	certFile, keyFile = cfg.TLSCertFile, cfg.TLSKeyFile
+	if certFile == "" && keyFile == "" {
+		dir := filepath.Dir(configPath)
+		certFile = filepath.Join(dir, "tls-cert.pem")
+		keyFile = filepath.Join(dir, "tls-key.pem")
+	}
+	if !cfg.TLSAutoSelfSigned {
+		return certFile, keyFile, nil
+	}
+	haveCert, haveKey := exists(certFile), exists(keyFile)
+	if haveCert && haveKey {
+		return certFile, keyFile, nil
+	}
+	if haveCert != haveKey {
+		return "", "", fmt.Errorf("only one of %s and %s exists; remove it to regenerate", certFile, keyFile)
+	}
+	var listens []string
+	for _, l := range cfg.ListenerList() {
+		listens = append(listens, l.Addr)
+	}
+	if err := writeSelfSignedCert(certFile, keyFile, listens); err != nil {
+		return "", "", err
+	}
+	log.Printf("TLS: generated self-signed certificate %s", certFile)
+	return certFile, keyFile, nil
+}
+
+// writeSelfSignedCert creates an ECDSA P-256 certificate valid for 10 years.
+// It covers the listen hosts, or localhost plus this machine's hostname and
+// interface addresses for listeners on all interfaces.
+func writeSelfSignedCert(certFile, keyFile string, listens []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		return err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "wicos64-server", Organization: []string{"WiCOS64"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	seen := map[string]bool{}
+	for _, listen := range listens {
+		for _, h := range tlsHostsForListen(listen) {
+			if seen[h] {
+				continue
+			}
+			seen[h] = true
+			if ip := net.ParseIP(h); ip != nil {
+				tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+			} else {
+				tmpl.DNSNames = append(tmpl.DNSNames, h)
+			}
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(certFile), 0o755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(keyFile), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+}
+
+func tlsHostsForListen(listen string) []string {
+	host, _, err := net.SplitHostPort(listen)
+	if err == nil && host != "" && host != "0.0.0.0" && host != "::" {
+		return []string{host}
+	}
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if hn, err := os.Hostname(); err == nil && hn != "" {
+		hosts = append(hosts, hn)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if ipn, ok := a.(*net.IPNet); ok && !ipn.IP.IsLoopback() && !ipn.IP.IsLinkLocalUnicast() {
+				hosts = append(hosts, ipn.IP.String())
+			}
+		}
+	}
+	return hosts
+}
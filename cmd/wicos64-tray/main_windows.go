@@ -459,8 +459,9 @@ func (a *trayApp) refreshConfig() error {
 	a.adminUser = cfg.AdminUser
 	a.adminPass = cfg.AdminPassword
 
-	baseAdmin := baseURLForAdmin(cfg.Listen, cfg.AdminAllowRemote)
-	baseRaw := baseURLFromListenRaw(cfg.Listen)
+	adminListen := cfg.ListenAddr(config.ServeAdmin)
+	baseAdmin := baseURLForAdmin(adminListen, cfg.AdminAllowRemote)
+	baseRaw := baseURLFromListenRaw(adminListen)
 	a.baseURL = baseAdmin
 	a.altBaseURL = baseRaw
 	a.adminURL = baseAdmin + "/admin"
@@ -681,11 +682,16 @@ func (a *trayApp) adminPost(path string) error {
 	p := a.adminPass
 	a.mu.Unlock()
 
+	c := &http.Client{Timeout: 2 * time.Second}
 	req, _ := http.NewRequest(http.MethodPost, url, nil)
 	if p != "" {
 		req.SetBasicAuth(u, p)
 	}
-	c := &http.Client{Timeout: 2 * time.Second}
+	// Mutating admin endpoints require the server's CSRF token (best-effort:
+	// older servers don't have /api/csrf and don't check it).
+	if tok := adminCSRFToken(c, base, u, p); tok != "" {
+		req.Header.Set("X-W64-CSRF", tok)
+	}
 	resp, err := c.Do(req)
 	if err != nil {
 		return err
@@ -697,6 +703,28 @@ func (a *trayApp) adminPost(path string) error {
 	return fmt.Errorf("admin request failed: %s", resp.Status)
 }
 
+func adminCSRFToken(c *http.Client, base, user, pass string) string {
+	req, _ := http.NewRequest(http.MethodGet, base+"/admin/api/csrf", nil)
+	if pass != "" {
+		req.SetBasicAuth(user, pass)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
+	var out struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&out); err != nil {
+		return ""
+	}
+	return out.Token
+}
+
 func (a *trayApp) bestBaseURL() string {
 	a.mu.Lock()
 	base := a.baseURL
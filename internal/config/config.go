@@ -3,8 +3,11 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -18,10 +21,13 @@ type TokenEntry struct {
 	Token             string `json:"token"`
 	Name              string `json:"name,omitempty"`
 	Root              string `json:"root,omitempty"`
+	PathPrefix        string `json:"path_prefix,omitempty"` // home dir inside Root, e.g. "/userA"; the token's "/"
 	Enabled           *bool  `json:"enabled,omitempty"`
 	ReadOnly          bool   `json:"read_only,omitempty"`
 	QuotaBytes        uint64 `json:"quota_bytes,omitempty"`
 	MaxFileBytes      uint64 `json:"max_file_bytes,omitempty"`
+	MaxFiles          uint64 `json:"max_files,omitempty"`          // files + dirs under root (inode quota), 0 = unlimited
+	ReadOnlyWhenFull  bool   `json:"readonly_when_full,omitempty"` // writes fail with QUOTA_FULL while used >= quota
 	DiskImagesEnabled *bool  `json:"disk_images_enabled,omitempty"`
 	// DiskImagesWriteEnabled overrides the global disk_images_write_enabled for this token.
 	// If omitted, the global setting is used.
@@ -36,6 +42,29 @@ type TokenEntry struct {
 	// a disk image would silently become a normal file by dropping its extension,
 	// or where a normal file would become a mounted disk image by adding one.
 	DiskImagesAllowRenameConvert *bool `json:"disk_images_allow_rename_convert,omitempty"`
+	// RateLimitPerSec overrides the global rate_limit_per_sec for this token.
+	// If omitted (0), the global setting is used.
+	RateLimitPerSec float64 `json:"rate_limit_per_sec,omitempty"`
+	// MaxBytesPerSec overrides the global max_bytes_per_sec for this token.
+	// If omitted (0), the global setting is used.
+	MaxBytesPerSec uint64 `json:"max_bytes_per_sec,omitempty"`
+	// AllowCIDRs restricts the token to client IPs in these networks (empty = any).
+	// DenyCIDRs rejects client IPs in these networks and takes precedence.
+	// A bare IP address is treated as a single-host network.
+	AllowCIDRs []string `json:"allow_cidrs,omitempty"`
+	DenyCIDRs  []string `json:"deny_cidrs,omitempty"`
+	// Optional validity window (unix seconds, 0 = open). The token is accepted
+	// from ValidFromUnix up to and including ValidUntilUnix.
+	ValidFromUnix  int64 `json:"valid_from_unix,omitempty"`
+	ValidUntilUnix int64 `json:"valid_until_unix,omitempty"`
+	// Endpoint binds the token to one RPC endpoint path (e.g.
+	// "/tenant-a/api"): requests on any other path are rejected. It is served
+	// in addition to the global endpoint; new paths need a restart.
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Parsed AllowCIDRs/DenyCIDRs (filled by Validate).
+	allowNets []*net.IPNet
+	denyNets  []*net.IPNet
 }
 
 // TokenContext is the resolved on-disk root and effective policy for a request.
@@ -43,13 +72,82 @@ type TokenContext struct {
 	Root                         string
 	Name                         string
 	ReadOnly                     bool
+	ReadOnlyWhenFull             bool
+	RateLimitPerSec              float64
+	MaxBytesPerSec               uint64
 	QuotaBytes                   uint64
 	MaxFileBytes                 uint64
+	MaxFiles                     uint64
 	DiskImagesEnabled            bool
 	DiskImagesWriteEnabled       bool
 	DiskImagesAutoResizeEnabled  bool
 	DiskImagesAllowRenameConvert bool
 	Legacy                       bool
+
+	// Client IP filter (nil = any client).
+	AllowNets []*net.IPNet
+	DenyNets  []*net.IPNet
+
+	// Validity window (unix seconds, 0 = open).
+	ValidFromUnix  int64
+	ValidUntilUnix int64
+
+	// RPC endpoint path the token is bound to ("" = any).
+	Endpoint string
+}
+
+// TokenPending reports whether a token with the given valid_from_unix is not
+// valid yet at now (unix seconds).
+func TokenPending(validFrom, now int64) bool {
+	return validFrom > 0 && now < validFrom
+}
+
+// TokenExpired reports whether a token with the given valid_until_unix has
+// expired at now (unix seconds).
+func TokenExpired(validUntil, now int64) bool {
+	return validUntil > 0 && now > validUntil
+}
+
+// TrustedProxy reports whether ip is listed in trusted_proxies.
+func (c Config) TrustedProxy(ip net.IP) bool {
+	for _, n := range c.trustedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IPAllowed reports whether a client IP passes the token's allow/deny lists.
+// The deny list wins; an empty allow list allows any IP. An unknown (nil) IP
+// only passes when no lists are configured.
+func (t TokenContext) IPAllowed(ip net.IP) bool {
+	if len(t.AllowNets) == 0 && len(t.DenyNets) == 0 {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	for _, n := range t.DenyNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(t.AllowNets) == 0 {
+		return true
+	}
+	for _, n := range t.AllowNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// EndpointAllowed reports whether a request that arrived on the RPC endpoint
+// path ep may use this token.
+func (t TokenContext) EndpointAllowed(ep string) bool {
+	return t.Endpoint == "" || t.Endpoint == ep
 }
 
 // BootstrapConfig controls an optional LAN-only bootstrap endpoint that can
@@ -86,6 +184,12 @@ type BootstrapConfig struct {
 	// Keys are case-insensitive and will be normalized to UPPER_SNAKE_CASE on
 	// load. Values must not contain newlines.
 	MacExtra map[string]map[string]string `json:"mac_extra,omitempty"`
+	// HMACKey signs bootstrap responses when set: a SIG=<hex> line with the
+	// HMAC-SHA256 (key = the UTF-8 bytes of this string) over all preceding
+	// response lines is added before END. A client supplied nonce= is echoed
+	// as NONCE= inside the signed part, so a recorded answer cannot be
+	// replayed for another request.
+	HMACKey string `json:"hmac_key,omitempty"`
 }
 
 // DiscoveryConfig controls the optional UDP LAN discovery responder.
@@ -104,6 +208,62 @@ type DiscoveryConfig struct {
 	LanOnly bool `json:"lan_only"`
 	// RateLimitPerSec is a simple per-source-IP limit to reduce spam (default: 5).
 	RateLimitPerSec int `json:"rate_limit_per_sec"`
+	// IPv6 also answers WDP1 probes on [::]:udp_port (clients send to the
+	// all-nodes group ff02::1). The offer then carries the v6 address and a
+	// bootstrap URL with a bracketed host (default: true).
+	IPv6 bool `json:"ipv6"`
+}
+
+// ListenerConfig is one entry of listeners.
+type ListenerConfig struct {
+	// Addr is the listen address, e.g. ":8080" or "127.0.0.1:8081".
+	Addr string `json:"addr"`
+	// Serve lists the handler groups on this address: "api" (W64F
+	// endpoints, caps.json, HTTP files, metrics), "admin", "bootstrap" or
+	// "all". Empty = all. The health endpoints are served everywhere.
+	Serve []string `json:"serve"`
+}
+
+// Handler groups of ListenerConfig.Serve.
+const (
+	ServeAll       = "all"
+	ServeAPI       = "api"
+	ServeAdmin     = "admin"
+	ServeBootstrap = "bootstrap"
+)
+
+// Serves reports whether the listener serves the handler group kind.
+func (l ListenerConfig) Serves(kind string) bool {
+	if len(l.Serve) == 0 {
+		return true
+	}
+	for _, v := range l.Serve {
+		if v == ServeAll || v == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// ListenerList returns the listeners to bind: listeners, or a single one on
+// listen that serves everything.
+func (c Config) ListenerList() []ListenerConfig {
+	if len(c.Listeners) > 0 {
+		return c.Listeners
+	}
+	return []ListenerConfig{{Addr: c.Listen, Serve: []string{ServeAll}}}
+}
+
+// ListenAddr returns the address of the first listener that serves kind, or
+// listen when none does. Used for URLs shown to users and the discovery
+// offer.
+func (c Config) ListenAddr(kind string) string {
+	for _, l := range c.Listeners {
+		if l.Serves(kind) {
+			return l.Addr
+		}
+	}
+	return c.Listen
 }
 
 // CompatConfig contains optional compatibility toggles.
@@ -139,10 +299,37 @@ type Config struct {
 	Listen string `json:"listen"`
 	// Endpoint path, e.g. "/wicos64/api".
 	Endpoint string `json:"endpoint"`
+	// Optional listeners that each serve a part of the handlers, e.g. the
+	// API on ":8080" for the LAN and the admin UI on "127.0.0.1:8081" only.
+	// When set, listen is not bound; when empty, listen serves everything.
+	Listeners []ListenerConfig `json:"listeners,omitempty"`
+
+	// Optional HTTPS. If tls_cert_file/tls_key_file are set (both, PEM), the
+	// server only speaks TLS. With tls_auto_self_signed a self-signed
+	// certificate is generated on first start and stored next to the config
+	// (or at the configured paths) when the files do not exist yet.
+	TLSCertFile       string `json:"tls_cert_file"`
+	TLSKeyFile        string `json:"tls_key_file"`
+	TLSAutoSelfSigned bool   `json:"tls_auto_self_signed"`
+
+	// TrustedProxies lists reverse proxies (CIDRs or bare IPs) whose
+	// X-Forwarded-For / X-Real-IP headers are believed. The client IP is then
+	// the right-most X-Forwarded-For hop that is not a trusted proxy. It
+	// feeds the logs, the admin localhost check and tokens[].allow_cidrs.
+	// Empty = headers are ignored and the TCP peer is the client.
+	TrustedProxies []string `json:"trusted_proxies"`
+	trustedNets    []*net.IPNet
 
 	// BasePath is the directory that contains per-token roots (unless an entry in TokenRoots / Tokens is absolute).
 	BasePath string `json:"base_path"`
 
+	// AllowSymlinkTargets lists absolute directories that symlinks inside a
+	// token root may point into. Such a link is followed by the read side
+	// (LS, STAT, READ_RANGE, HASH, ...) when its fully resolved target lies
+	// within one of these directories and within the token root. Writes
+	// through symlinks stay forbidden. Empty = symlinks are never followed.
+	AllowSymlinkTargets []string `json:"allow_symlink_targets,omitempty"`
+
 	// --- Legacy token configuration (still supported) ---
 
 	// Token is a convenience field for single-user setups. If set, this token maps to BasePath.
@@ -162,6 +349,30 @@ type Config struct {
 	GlobalReadOnly     bool   `json:"global_read_only"`
 	GlobalQuotaBytes   uint64 `json:"global_quota_bytes"`
 	GlobalMaxFileBytes uint64 `json:"global_max_file_bytes"`
+	GlobalMaxFiles     uint64 `json:"global_max_files"`
+
+	// If true, quota usage counts disk images (.d64/.d71/.d81) by the blocks
+	// allocated in their BAM instead of their file size, so mostly empty
+	// images do not eat the quota. Costs an image parse per changed image on
+	// rescans (results are cached by size/mtime); off by default.
+	QuotaLogicalImageUsage bool `json:"quota_logical_image_usage"`
+
+	// UsageCachePath, if set, is a JSON file the per-root quota usage is saved
+	// to every minute and loaded from on startup, so the first quota check
+	// after a restart does not have to walk the whole root. Restored values
+	// are used once and rescanned in the background. Empty = off.
+	UsageCachePath string `json:"usage_cache_path"`
+
+	// Default per-token request rate limit (requests per second, token bucket
+	// with a burst of one second's worth). Requests over the limit get BUSY.
+	// tokens[].rate_limit_per_sec overrides it; 0 = unlimited.
+	RateLimitPerSec float64 `json:"rate_limit_per_sec"`
+
+	// Default per-token bandwidth limit in bytes per second, request and
+	// response bytes together (RPC, JSON ops and the HTTP file bridge).
+	// Answers are delayed, not refused, so a bulk transfer cannot starve the
+	// other clients. tokens[].max_bytes_per_sec overrides it; 0 = unlimited.
+	MaxBytesPerSec uint64 `json:"max_bytes_per_sec"`
 
 	// --- Limits advertised via CAPS and enforced by the server ---
 	MaxPayload uint16 `json:"max_payload"`
@@ -170,6 +381,12 @@ type Config struct {
 	MaxName    uint16 `json:"max_name"`
 	MaxEntries uint16 `json:"max_entries"`
 
+	// TruncateOversized keeps small max_payload setups navigable: when not even
+	// the first entry of an LS page fits, that entry is returned with its name
+	// shortened (entry type flag 0x80, proto.LSEntryTruncated) instead of
+	// TOO_LARGE; SEARCH shortens the preview of a first hit that does not fit.
+	TruncateOversized bool `json:"truncate_oversized"`
+
 	// Feature toggles.
 	EnableMkdirParents   bool `json:"enable_mkdir_parents"`
 	EnableRmdirRecursive bool `json:"enable_rmdir_recursive"`
@@ -177,12 +394,117 @@ type Config struct {
 	EnableOverwrite      bool `json:"enable_overwrite"`
 	EnableErrMsg         bool `json:"enable_errmsg"`
 
+	// OverwriteConfirm makes replacing an existing file a two-step write: a
+	// WRITE_RANGE with TRUNCATE+OVERWRITE on a non-empty file (or an existing
+	// file inside a disk image) first answers ALREADY_EXISTS with a confirm
+	// value, which the client must send back with FlagWR_CONFIRM within
+	// OverwriteConfirmSec (default 30).
+	OverwriteConfirm    bool `json:"overwrite_confirm"`
+	OverwriteConfirmSec int  `json:"overwrite_confirm_sec"`
+
+	// DebugShortRequest answers a body too short for the W64F header, or one
+	// with a bad magic, with a BAD_REQUEST frame (op_echo 0xFF) that always
+	// carries an error message, and logs the received bytes in hex. Off by
+	// default: a short body gets a bare HTTP 400 as the spec allows.
+	DebugShortRequest bool `json:"debug_short_request"`
+
+	// Ops refused for every token with NOT_SUPPORTED ("op disabled by
+	// policy"), by name ("SEARCH") or hex opcode ("0x0B"). CAPS does not
+	// advertise their feature bits. CAPS itself cannot be disabled.
+	DisabledOps []string `json:"disabled_ops,omitempty"`
+
+	// Bounds for recursive directory operations (CP -r, the MV copy fallback,
+	// RMDIR -r and replacing a directory on overwrite). The tree is checked
+	// before anything is changed; exceeding a bound fails the op with
+	// INVALID_PATH (depth) or TOO_LARGE (files/bytes). 0 = unlimited.
+	MaxTreeDepth int    `json:"max_tree_depth"`
+	MaxTreeFiles uint64 `json:"max_tree_files"`
+	MaxTreeBytes uint64 `json:"max_tree_bytes"`
+
+	// Largest file SEARCH scans in regex mode (FlagS_REGEX). A regex cannot
+	// be matched across read chunks, so these files are read whole (still
+	// bounded by max_scan_bytes); larger files are skipped. 0 = no own limit.
+	SearchRegexMaxBytes uint64 `json:"search_regex_max_file_bytes"`
+
+	// SEARCH scan budget: the default when a request sends max_scan_bytes=0,
+	// and the cap a request's max_scan_bytes is clamped to. Lower the cap on
+	// low-RAM hosts (regex mode reads whole files up to the budget).
+	SearchDefaultScanBytes uint32 `json:"search_default_scan_bytes"`
+	SearchMaxScanBytes     uint32 `json:"search_max_scan_bytes"`
+
+	// Bytes of file content returned with each SEARCH hit (starting at the
+	// match). CAPS reports the value as preview_len. Must be <= max_chunk.
+	SearchPreviewBytes uint16 `json:"search_preview_bytes"`
+
+	// Largest file READ_RANGE FlagR_IF_HASH computes a CRC32 for. Larger
+	// files are read as if the flag was not set.
+	IfHashMaxBytes uint32 `json:"if_hash_max_bytes"`
+
+	// File extensions (e.g. ".TXT", ".SEQ") for which WRITE_RANGE drops a
+	// leading UTF-8/UTF-16 byte order mark as if FlagWR_STRIP_BOM was set.
+	// Only the first chunk (offset 0) is inspected. Empty = only on request.
+	StripBOMExtensions []string `json:"strip_bom_extensions,omitempty"`
+
+	// If true, TOKEN_NAMES returns the names (never the secrets) of all
+	// configured tokens, e.g. for a "which device are you?" picker.
+	// Off by default for privacy.
+	ExposeTokenNames bool `json:"expose_token_names"`
+
+	// If true, the diagnostic ECHO op is available: it returns its payload
+	// after an optional, capped server-side delay so clients can measure
+	// round trips and exercise their timeout/retry logic. Off by default.
+	EnableEcho bool `json:"enable_echo"`
+
+	// CompressResponses deflates successful response payloads of at least
+	// CompressMinBytes (default 128) for clients that set
+	// proto.ReqAcceptCompressed in the request header, when that makes them
+	// smaller. CAPS advertises FeatCOMPRESS. Off by default.
+	CompressResponses bool   `json:"compress_responses"`
+	CompressMinBytes  uint16 `json:"compress_min_bytes"`
+
+	// RPCMethodHelp answers non-POST requests to the RPC endpoint (e.g. the
+	// URL opened in a browser) with a short usage note instead of a bare 405.
+	RPCMethodHelp bool `json:"rpc_method_help"`
+
+	// If true, GET /wicos64/caps.json returns the CAPS data (limits, feature
+	// bits and names) as JSON for tooling. Without ?token= it shows the
+	// global view; with a valid token, that token's view. Off by default.
+	EnableCapsJSON bool `json:"enable_caps_json"`
+	// If true, GET /wicos64/files/<path>?token=... downloads files from the
+	// token's root over plain HTTP (browsers, curl; Range supported) and lists
+	// directories (HTML, or JSON with &format=json); PUT/POST uploads a file
+	// (&parents=1 creates missing directories, &overwrite=1 confirms replacing
+	// a file). Off by default.
+	EnableHTTPFiles bool `json:"enable_http_files"`
+	// If true, POST /wicos64/jsonrpc runs single ops from a JSON body (e.g.
+	// {"op":"ls","path":"/"}) and answers with the decoded result as JSON,
+	// for shell scripts. Token via ?token= or the body. Off by default.
+	EnableJSONRPC bool `json:"enable_jsonrpc"`
+	// If enabled, GET /metrics serves request counters in the Prometheus text
+	// format. Access follows the admin rules (localhost-only unless
+	// admin_allow_remote, admin_password as BasicAuth).
+	MetricsEnabled bool `json:"metrics_enabled"`
+
+	// Lock files created by LOCK older than this are considered stale and may
+	// be taken over by another holder. 0 = locks never expire.
+	LockTTLSec int `json:"lock_ttl_sec"`
+
 	// If true, create recommended base directories (/bin,/usr,/etc,/.tmp) inside each token root.
 	CreateRecommendedDirs bool `json:"create_recommended_dirs"`
 
 	// Optional build/name string exposed via CAPS.server_name.
 	ServerName string `json:"server_name"`
 
+	// Optional message of the day (e.g. "Maintenance Sunday 9pm"), returned
+	// by the MOTD op so clients can show it on connect. Empty = no MOTD.
+	// Truncated to fit max_payload.
+	Motd string `json:"motd"`
+
+	// If true, the server polls its config file and reloads it after external
+	// edits (like the admin "reload" button). Listen/endpoint changes still
+	// need a restart. A file that fails to load is logged and ignored.
+	ConfigWatch bool `json:"config_watch"`
+
 	// --- Optional Admin UI (local configuration / live log) ---
 	//
 	// The admin UI is a small web dashboard served by the same process.
@@ -192,9 +514,33 @@ type Config struct {
 	AdminUser        string `json:"admin_user"`
 	AdminPassword    string `json:"admin_password"`
 
+	// AdminCSRFEnabled requires a per-process CSRF token on all mutating admin
+	// requests (protects the localhost UI against drive-by POSTs from other pages).
+	AdminCSRFEnabled bool `json:"admin_csrf_enabled"`
+
 	// LogRequests controls whether the server collects a per-request log.
 	LogRequests bool `json:"log_requests"`
 
+	// AuditLogPath, if set, appends every request log entry as a JSON line to
+	// this file (independent of log_requests and the in-memory ring). The file
+	// is rotated to .1, .2, ... (AuditLogKeep files) once it would exceed
+	// AuditLogMaxBytes (0 = never), and reopened on SIGHUP and config reload so
+	// external logrotate works too.
+	AuditLogPath     string `json:"audit_log_path"`
+	AuditLogMaxBytes int64  `json:"audit_log_max_bytes"`
+	AuditLogKeep     int    `json:"audit_log_keep"`
+
+	// AuditLogDir, if set, appends each mutating op (write, delete, rename,
+	// copy, mkdir, ... including disk image writes) to a per-token JSONL file
+	// <dir>/<token_id>.jsonl ("noauth.jsonl" without auth): timestamp, token,
+	// op, path/args and result, never file contents. Rotation follows
+	// AuditLogMaxBytes/AuditLogKeep.
+	AuditLogDir string `json:"audit_log_dir,omitempty"`
+
+	// AdminStreamBatchMs coalesces live log (SSE) entries into periodic flushes.
+	// 0 = push every entry immediately.
+	AdminStreamBatchMs int `json:"admin_stream_batch_ms"`
+
 	// --- Optional LAN-only bootstrap (API URL + per-MAC token) ---
 	Bootstrap BootstrapConfig `json:"bootstrap"`
 
@@ -205,18 +551,48 @@ type Config struct {
 	Compat CompatConfig `json:"compat"`
 
 	// --- Optional disk image mounting ---
-	// If enabled, supported disk image files (currently: .d64/.d71/.d81) are
-	// exposed as virtual directories. Example:
+	// If enabled, supported disk image files (currently: .d64/.d71/.d81, and
+	// .t64 tapes read-only) are exposed as virtual directories. Example:
 	//   /games/collection.d64/LOADER
 	DiskImagesEnabled bool `json:"disk_images_enabled"`
 	// If enabled, disk images are writable (SAVE/WRITE operations) via the
 	// virtual directory view. This is potentially destructive and therefore
 	// disabled by default.
 	DiskImagesWriteEnabled bool `json:"disk_images_write_enabled"`
+	// If enabled, .zip archives are exposed as read-only virtual directories
+	// (nested folders included), e.g. /bundles/demos.zip/GROUP/INTRO.PRG.
+	// While enabled, a .zip can no longer be read as a plain file over the
+	// W64F protocol, hence off by default.
+	ZipMountEnabled bool `json:"zip_mount_enabled"`
 	// If enabled, the server may automatically resize disk image subdirs/
 	// partitions (primarily relevant for .d81) when they run out of space.
 	// This can be I/O-heavy and may rewrite the image.
 	DiskImagesAutoResizeEnabled bool `json:"disk_images_auto_resize_enabled"`
+	// If enabled, image files are checked by size and header signature before
+	// they are mounted. A file whose content does not match its extension
+	// (e.g. a D81 named .d64) is rejected with a clear error instead of being
+	// misparsed.
+	DiskImageDetectByContent bool `json:"disk_image_detect_by_content"`
+	// If enabled, every mutating disk-image operation (write, delete, rename,
+	// mkdir/rmdir, import) is recorded with image, inner path, resulting free
+	// blocks and repack state in a dedicated ring (admin /api/imagelog).
+	LogDiskImageOps bool `json:"log_disk_image_ops"`
+	// How often the final rename of an image rewrite (e.g. a D81 repack) is
+	// retried, with backoff starting at 50ms, before the write fails. Absorbs
+	// transient locks by virus scanners/indexers on Windows. 0 = no retries.
+	DiskImageReplaceRetries int `json:"disk_image_replace_retries"`
+	// Upper bound for disk images parsed at the same time (each parse reads
+	// the whole image into memory). Further opens wait up to 2s, then fail
+	// with BUSY. Cached images are not affected. 0 = unlimited.
+	MaxConcurrentImageParses int `json:"max_concurrent_image_parses"`
+	// If enabled, the server keeps a small sidecar index per disk image in
+	// ImageChangeIndexDir, recording when each inner file was last changed
+	// through this server (op IMAGE_CHANGES). CBM images carry no per-file
+	// timestamps, so this is the only way for sync tools to find changed files.
+	ImageChangeIndex bool `json:"image_change_index"`
+	// Directory for the sidecar index files (outside of the token roots).
+	// Default: ./wicos64-image-index
+	ImageChangeIndexDir string `json:"image_change_index_dir"`
 
 	// --- Optional housekeeping ---
 	TmpCleanupEnabled         bool `json:"tmp_cleanup_enabled"`
@@ -224,11 +600,29 @@ type Config struct {
 	TmpCleanupMaxAgeSec       int  `json:"tmp_cleanup_max_age_sec"`
 	TmpCleanupDeleteEmptyDirs bool `json:"tmp_cleanup_delete_empty_dirs"`
 
+	// A staged write (WRITE_RANGE FlagWR_STAGED) that gets no chunk for this
+	// long is abandoned; the maintenance loop deletes its staging copy.
+	// Default 600 (10 minutes), minimum 60.
+	StagedWriteTimeoutSec int `json:"staged_write_timeout_sec"`
+
 	// --- Optional "trash" (recycle bin) ---
 	// If enabled, RM/RMDIR and overwrite behavior (CP/MV with overwrite) moves
 	// the existing destination into TrashDir instead of deleting it permanently.
 	TrashEnabled bool   `json:"trash_enabled"`
 	TrashDir     string `json:"trash_dir"`
+	// Optional W64 path patterns that select trash vs. hard delete. Patterns
+	// without "/" match the file name (e.g. "*.TMP"); patterns with "/" match
+	// the whole path from the root, where "**" spans any number of segments
+	// (e.g. "/CACHE/**"). Matching is case-insensitive. A path matching an
+	// exclude pattern is deleted permanently; if TrashOnlyPatterns is set, only
+	// matching paths go to the trash.
+	TrashExcludePatterns []string `json:"trash_exclude_patterns,omitempty"`
+	TrashOnlyPatterns    []string `json:"trash_only_patterns,omitempty"`
+
+	// TrashEvictOnQuota purges the oldest trash entries when a write would
+	// exceed the quota and the trash holds enough bytes to make it fit, so
+	// deleting a file to make room works without waiting for the cleanup.
+	TrashEvictOnQuota bool `json:"trash_evict_on_quota"`
 
 	// Optional trash cleanup (delete old entries under TrashDir).
 	TrashCleanupEnabled         bool `json:"trash_cleanup_enabled"`
@@ -239,32 +633,48 @@ type Config struct {
 
 func Default() Config {
 	return Config{
-		Listen:                ":8080",
-		Endpoint:              "/wicos64/api",
-		BasePath:              "./wicos64-data",
-		Token:                 "",
-		TokenRoots:            map[string]string{},
-		Tokens:                nil,
-		GlobalReadOnly:        false,
-		GlobalQuotaBytes:      0,
-		GlobalMaxFileBytes:    0,
-		MaxPayload:            16384,
-		MaxChunk:              4096,
-		MaxPath:               255,
-		MaxName:               64,
-		MaxEntries:            50,
-		EnableMkdirParents:    true,
-		EnableRmdirRecursive:  true,
-		EnableCpRecursive:     true,
-		EnableOverwrite:       true,
-		EnableErrMsg:          true,
-		CreateRecommendedDirs: true,
-		ServerName:            "wicos64-go-backend",
-		EnableAdminUI:         true,
-		AdminAllowRemote:      false,
-		AdminUser:             "admin",
-		AdminPassword:         "",
-		LogRequests:           true,
+		Listen:                 ":8080",
+		Endpoint:               "/wicos64/api",
+		BasePath:               "./wicos64-data",
+		Token:                  "",
+		TokenRoots:             map[string]string{},
+		Tokens:                 nil,
+		GlobalReadOnly:         false,
+		GlobalQuotaBytes:       0,
+		GlobalMaxFileBytes:     0,
+		GlobalMaxFiles:         0,
+		MaxPayload:             16384,
+		MaxChunk:               4096,
+		MaxPath:                255,
+		MaxName:                64,
+		MaxEntries:             50,
+		EnableMkdirParents:     true,
+		EnableRmdirRecursive:   true,
+		EnableCpRecursive:      true,
+		EnableOverwrite:        true,
+		EnableErrMsg:           true,
+		CompressMinBytes:       128,
+		CreateRecommendedDirs:  true,
+		LockTTLSec:             300,
+		MaxTreeDepth:           64,
+		MaxTreeFiles:           100000,
+		SearchRegexMaxBytes:    1024 * 1024,
+		SearchDefaultScanBytes: 4 << 20,
+		SearchMaxScanBytes:     32 << 20,
+		SearchPreviewBytes:     32,
+		IfHashMaxBytes:         16 << 20,
+		ServerName:             "wicos64-go-backend",
+		EnableAdminUI:          true,
+		AdminAllowRemote:       false,
+		AdminUser:              "admin",
+		AdminPassword:          "",
+		AdminCSRFEnabled:       true,
+		LogRequests:            true,
+		AdminStreamBatchMs:     250,
+		AuditLogMaxBytes:       10 << 20,
+		AuditLogKeep:           2,
+		RPCMethodHelp:          true,
+		TruncateOversized:      true,
 		Bootstrap: BootstrapConfig{
 			Enabled:          false,
 			AllowGET:         true,
@@ -279,6 +689,7 @@ func Default() Config {
 			UDPPort:         6464,
 			LanOnly:         true,
 			RateLimitPerSec: 5,
+			IPv6:            true,
 		},
 		Compat: CompatConfig{
 			FallbackPRGExtension: true,
@@ -289,6 +700,11 @@ func Default() Config {
 		TmpCleanupIntervalSec:     15 * 60,      // 15 minutes
 		TmpCleanupMaxAgeSec:       24 * 60 * 60, // 24 hours
 		TmpCleanupDeleteEmptyDirs: true,
+		StagedWriteTimeoutSec:     10 * 60,
+		OverwriteConfirmSec:       30,
+
+		DiskImageReplaceRetries:  4, // keep in sync with diskimage.DefaultReplaceRetries
+		MaxConcurrentImageParses: 4,
 
 		TrashEnabled: false,
 		TrashDir:     ".TRASH",
@@ -325,12 +741,43 @@ func (c *Config) Validate() error {
 	if c.Endpoint == "" {
 		c.Endpoint = "/wicos64/api"
 	}
+	addrs := map[string]bool{}
+	for i := range c.Listeners {
+		l := &c.Listeners[i]
+		l.Addr = strings.TrimSpace(l.Addr)
+		if l.Addr == "" {
+			return fmt.Errorf("listeners[%d]: addr is empty", i)
+		}
+		if addrs[l.Addr] {
+			return fmt.Errorf("listeners[%d]: duplicate addr %q", i, l.Addr)
+		}
+		addrs[l.Addr] = true
+		for j, v := range l.Serve {
+			v = strings.ToLower(strings.TrimSpace(v))
+			switch v {
+			case ServeAll, ServeAPI, ServeAdmin, ServeBootstrap:
+			default:
+				return fmt.Errorf("listeners[%d].serve: unknown value %q (api, admin, bootstrap, all)", i, l.Serve[j])
+			}
+			l.Serve[j] = v
+		}
+	}
 	if !strings.HasPrefix(c.Endpoint, "/") {
 		return fmt.Errorf("endpoint must start with '/'")
 	}
 	if c.BasePath == "" {
 		c.BasePath = "./wicos64-data"
 	}
+	for i, p := range c.AllowSymlinkTargets {
+		p = strings.TrimSpace(p)
+		if !filepath.IsAbs(p) {
+			return fmt.Errorf("allow_symlink_targets[%d]: %q is not an absolute path", i, p)
+		}
+		c.AllowSymlinkTargets[i] = filepath.Clean(p)
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return fmt.Errorf("tls_cert_file and tls_key_file must be set together")
+	}
 	if c.MaxPath == 0 {
 		c.MaxPath = 255
 	}
@@ -349,12 +796,70 @@ func (c *Config) Validate() error {
 	if c.MaxChunk > c.MaxPayload {
 		return fmt.Errorf("max_chunk (%d) must be <= max_payload (%d)", c.MaxChunk, c.MaxPayload)
 	}
+	if c.CompressMinBytes == 0 {
+		c.CompressMinBytes = 128
+	}
 	if c.ServerName == "" {
 		c.ServerName = "wicos64-go-backend"
 	}
 	if c.AdminUser == "" {
 		c.AdminUser = "admin"
 	}
+	if c.LockTTLSec < 0 {
+		c.LockTTLSec = 0
+	}
+	if c.MaxTreeDepth < 0 {
+		c.MaxTreeDepth = 0
+	}
+	if c.SearchMaxScanBytes == 0 {
+		c.SearchMaxScanBytes = 32 << 20
+	}
+	if c.SearchDefaultScanBytes == 0 {
+		c.SearchDefaultScanBytes = 4 << 20
+	}
+	if c.SearchDefaultScanBytes > c.SearchMaxScanBytes {
+		c.SearchDefaultScanBytes = c.SearchMaxScanBytes
+	}
+	if c.SearchPreviewBytes == 0 {
+		c.SearchPreviewBytes = 32
+	}
+	if c.IfHashMaxBytes == 0 {
+		c.IfHashMaxBytes = 16 << 20
+	}
+	if c.SearchPreviewBytes > c.MaxChunk {
+		return fmt.Errorf("search_preview_bytes (%d) must be <= max_chunk (%d)", c.SearchPreviewBytes, c.MaxChunk)
+	}
+	if c.DiskImageReplaceRetries < 0 {
+		c.DiskImageReplaceRetries = 0
+	}
+	if c.MaxConcurrentImageParses < 0 {
+		c.MaxConcurrentImageParses = 0
+	}
+	c.ImageChangeIndexDir = strings.TrimSpace(c.ImageChangeIndexDir)
+	if c.ImageChangeIndexDir == "" {
+		c.ImageChangeIndexDir = "./wicos64-image-index"
+	}
+	if c.RateLimitPerSec < 0 {
+		c.RateLimitPerSec = 0
+	}
+	c.AuditLogPath = strings.TrimSpace(c.AuditLogPath)
+	c.UsageCachePath = strings.TrimSpace(c.UsageCachePath)
+	c.AuditLogDir = strings.TrimSpace(c.AuditLogDir)
+	if c.AuditLogMaxBytes < 0 {
+		c.AuditLogMaxBytes = 0
+	}
+	if c.AuditLogKeep < 0 {
+		c.AuditLogKeep = 0
+	}
+	if c.AuditLogKeep > 20 {
+		c.AuditLogKeep = 20
+	}
+	if c.AdminStreamBatchMs < 0 {
+		c.AdminStreamBatchMs = 0
+	}
+	if c.AdminStreamBatchMs > 5000 {
+		c.AdminStreamBatchMs = 5000
+	}
 
 	// Housekeeping defaults (only if enabled).
 	if c.TmpCleanupEnabled {
@@ -373,6 +878,27 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	if c.StagedWriteTimeoutSec <= 0 {
+		c.StagedWriteTimeoutSec = 10 * 60
+	}
+	if c.StagedWriteTimeoutSec < 60 {
+		c.StagedWriteTimeoutSec = 60
+	}
+	if c.OverwriteConfirmSec <= 0 {
+		c.OverwriteConfirmSec = 30
+	}
+	if c.OverwriteConfirmSec > 0xFFFF {
+		c.OverwriteConfirmSec = 0xFFFF
+	}
+
+	ops := c.DisabledOps[:0]
+	for _, op := range c.DisabledOps {
+		if op = strings.TrimSpace(op); op != "" {
+			ops = append(ops, op)
+		}
+	}
+	c.DisabledOps = ops
+
 	// Trash defaults/validation.
 	c.TrashDir = strings.TrimSpace(c.TrashDir)
 	if c.TrashDir == "" {
@@ -384,6 +910,13 @@ func (c *Config) Validate() error {
 	if c.TrashDir == "." || c.TrashDir == ".." {
 		return fmt.Errorf("trash_dir must not be '.' or '..'")
 	}
+	for _, list := range [][]string{c.TrashExcludePatterns, c.TrashOnlyPatterns} {
+		for _, p := range list {
+			if _, err := path.Match(strings.ReplaceAll(p, "**", "*"), ""); err != nil {
+				return fmt.Errorf("invalid trash pattern %q: %w", p, err)
+			}
+		}
+	}
 	if c.TrashCleanupEnabled {
 		if c.TrashCleanupIntervalSec <= 0 {
 			c.TrashCleanupIntervalSec = 6 * 60 * 60
@@ -469,9 +1002,15 @@ func (c *Config) Validate() error {
 		c.Discovery.RateLimitPerSec = 0
 	}
 
+	var err error
+	if c.trustedNets, err = parseCIDRs(c.TrustedProxies); err != nil {
+		return fmt.Errorf("trusted_proxies: %w", err)
+	}
+
 	// Validate tokens list (if present).
 	seen := map[string]struct{}{}
-	for _, t := range c.Tokens {
+	for i := range c.Tokens {
+		t := &c.Tokens[i]
 		if strings.TrimSpace(t.Token) == "" {
 			continue
 		}
@@ -479,11 +1018,129 @@ func (c *Config) Validate() error {
 			return fmt.Errorf("duplicate token in tokens[]")
 		}
 		seen[t.Token] = struct{}{}
+
+		var err error
+		if t.allowNets, err = parseCIDRs(t.AllowCIDRs); err != nil {
+			return fmt.Errorf("tokens[%d].allow_cidrs: %w", i, err)
+		}
+		if t.denyNets, err = parseCIDRs(t.DenyCIDRs); err != nil {
+			return fmt.Errorf("tokens[%d].deny_cidrs: %w", i, err)
+		}
+		if t.ValidFromUnix < 0 || t.ValidUntilUnix < 0 {
+			return fmt.Errorf("tokens[%d]: valid_from_unix/valid_until_unix must not be negative", i)
+		}
+		if t.ValidFromUnix > 0 && t.ValidUntilUnix > 0 && t.ValidFromUnix > t.ValidUntilUnix {
+			return fmt.Errorf("tokens[%d]: valid_from_unix is after valid_until_unix", i)
+		}
+		if err := validateTokenEndpoint(t.Endpoint); err != nil {
+			return fmt.Errorf("tokens[%d].endpoint: %w", i, err)
+		}
+		if t.PathPrefix, err = cleanPathPrefix(t.PathPrefix); err != nil {
+			return fmt.Errorf("tokens[%d].path_prefix: %w", i, err)
+		}
 	}
 
 	return nil
 }
 
+// cleanPathPrefix normalizes a tokens[].path_prefix to "/a/b" ("" = none).
+// ".." segments are refused rather than cleaned away, so a typo cannot
+// silently point the token at a different directory.
+func cleanPathPrefix(p string) (string, error) {
+	p = strings.TrimSpace(strings.ReplaceAll(p, "\\", "/"))
+	if p == "" {
+		return "", nil
+	}
+	for _, seg := range strings.Split(p, "/") {
+		if seg == ".." {
+			return "", fmt.Errorf("%q must not contain '..'", p)
+		}
+		if strings.ContainsAny(seg, ":\x00") {
+			return "", fmt.Errorf("invalid character in %q", p)
+		}
+	}
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return "", nil
+	}
+	return p, nil
+}
+
+// Paths served by other handlers, which a token endpoint must not shadow.
+var reservedEndpointPaths = []string{"/", "/healthz", "/metrics", "/wicos64/bootstrap", "/wicos64/caps.json"}
+
+// validateTokenEndpoint checks a tokens[].endpoint value ("" = unbound). It
+// must be a clean absolute path without a trailing slash (exact mux match)
+// that does not collide with the admin UI or the other fixed handlers.
+func validateTokenEndpoint(ep string) error {
+	if ep == "" {
+		return nil
+	}
+	if !strings.HasPrefix(ep, "/") {
+		return fmt.Errorf("must start with '/'")
+	}
+	if strings.ContainsAny(ep, "?#{} \t") {
+		return fmt.Errorf("invalid character in %q", ep)
+	}
+	if path.Clean(ep) != ep || strings.HasSuffix(ep, "/") {
+		return fmt.Errorf("%q is not a clean path without trailing '/'", ep)
+	}
+	for _, p := range reservedEndpointPaths {
+		if ep == p {
+			return fmt.Errorf("%q is reserved", ep)
+		}
+	}
+	if ep == "/admin" || strings.HasPrefix(ep, "/admin/") || strings.HasPrefix(ep, "/wicos64/files/") {
+		return fmt.Errorf("%q is reserved", ep)
+	}
+	return nil
+}
+
+// TokenEndpoints returns the distinct tokens[].endpoint paths other than the
+// global endpoint, sorted.
+func (c Config) TokenEndpoints() []string {
+	seen := map[string]bool{c.Endpoint: true}
+	var out []string
+	for _, t := range c.Tokens {
+		if t.Endpoint != "" && !seen[t.Endpoint] {
+			seen[t.Endpoint] = true
+			out = append(out, t.Endpoint)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// parseCIDRs parses a list of CIDRs. Bare IPs become /32 (IPv4) or /128
+// (IPv6) networks.
+func parseCIDRs(list []string) ([]*net.IPNet, error) {
+	var out []*net.IPNet
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %q", s)
+			}
+			bits := 128
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			out = append(out, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", s)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
 func minNonZero(a, b uint64) uint64 {
 	if a == 0 {
 		return b
@@ -570,7 +1227,7 @@ func normalizeBootstrapKVKey(s string) (string, bool) {
 
 func isReservedBootstrapKVKey(k string) bool {
 	switch k {
-	case "WICOS64CFG", "END", "API_URL", "TOKEN", "RO", "MAC", "SERVER_NAME", "QUOTA_BYTES", "MAX_FILE_BYTES":
+	case "WICOS64CFG", "END", "API_URL", "TOKEN", "RO", "MAC", "SERVER_NAME", "QUOTA_BYTES", "MAX_FILE_BYTES", "NONCE", "SIG":
 		return true
 	default:
 		return false
@@ -602,6 +1259,12 @@ func (c Config) ResolveTokenContext(token string) (TokenContext, bool) {
 			} else if !filepath.IsAbs(root) {
 				root = filepath.Join(c.BasePath, root)
 			}
+			if t.PathPrefix != "" {
+				// Tokens sharing a root each see their own "/". Validate
+				// cleaned the prefix, so it stays inside root; the sandbox
+				// checks then treat <root>/<prefix> as the token root.
+				root = filepath.Join(root, filepath.FromSlash(t.PathPrefix))
+			}
 			diskImages := c.DiskImagesEnabled
 			if t.DiskImagesEnabled != nil {
 				diskImages = *t.DiskImagesEnabled
@@ -622,13 +1285,22 @@ func (c Config) ResolveTokenContext(token string) (TokenContext, bool) {
 				Root:                         root,
 				Name:                         t.Name,
 				ReadOnly:                     c.GlobalReadOnly || t.ReadOnly,
+				ReadOnlyWhenFull:             t.ReadOnlyWhenFull,
+				RateLimitPerSec:              c.rateLimitFor(t.RateLimitPerSec),
+				MaxBytesPerSec:               c.bandwidthFor(t.MaxBytesPerSec),
 				QuotaBytes:                   minNonZero(t.QuotaBytes, c.GlobalQuotaBytes),
 				MaxFileBytes:                 minNonZero(t.MaxFileBytes, c.GlobalMaxFileBytes),
+				MaxFiles:                     minNonZero(t.MaxFiles, c.GlobalMaxFiles),
 				Legacy:                       false,
 				DiskImagesEnabled:            diskImages,
 				DiskImagesWriteEnabled:       diskImagesWrite,
 				DiskImagesAutoResizeEnabled:  diskImagesAutoResize,
 				DiskImagesAllowRenameConvert: allowRenameConvert,
+				AllowNets:                    t.allowNets,
+				DenyNets:                     t.denyNets,
+				ValidFromUnix:                t.ValidFromUnix,
+				ValidUntilUnix:               t.ValidUntilUnix,
+				Endpoint:                     t.Endpoint,
 			}, true
 		}
 		return TokenContext{}, false
@@ -641,9 +1313,9 @@ func (c Config) ResolveTokenContext(token string) (TokenContext, bool) {
 			return TokenContext{}, false
 		}
 		if filepath.IsAbs(r) {
-			return TokenContext{Root: r, ReadOnly: c.GlobalReadOnly, QuotaBytes: c.GlobalQuotaBytes, MaxFileBytes: c.GlobalMaxFileBytes, DiskImagesEnabled: c.DiskImagesEnabled, DiskImagesWriteEnabled: c.DiskImagesWriteEnabled, DiskImagesAutoResizeEnabled: c.DiskImagesAutoResizeEnabled, Legacy: true}, true
+			return TokenContext{Root: r, ReadOnly: c.GlobalReadOnly, QuotaBytes: c.GlobalQuotaBytes, MaxFileBytes: c.GlobalMaxFileBytes, MaxFiles: c.GlobalMaxFiles, DiskImagesEnabled: c.DiskImagesEnabled, DiskImagesWriteEnabled: c.DiskImagesWriteEnabled, DiskImagesAutoResizeEnabled: c.DiskImagesAutoResizeEnabled, RateLimitPerSec: c.RateLimitPerSec, MaxBytesPerSec: c.MaxBytesPerSec, Legacy: true}, true
 		}
-		return TokenContext{Root: filepath.Join(c.BasePath, r), ReadOnly: c.GlobalReadOnly, QuotaBytes: c.GlobalQuotaBytes, MaxFileBytes: c.GlobalMaxFileBytes, DiskImagesEnabled: c.DiskImagesEnabled, DiskImagesWriteEnabled: c.DiskImagesWriteEnabled, DiskImagesAutoResizeEnabled: c.DiskImagesAutoResizeEnabled, Legacy: true}, true
+		return TokenContext{Root: filepath.Join(c.BasePath, r), ReadOnly: c.GlobalReadOnly, QuotaBytes: c.GlobalQuotaBytes, MaxFileBytes: c.GlobalMaxFileBytes, MaxFiles: c.GlobalMaxFiles, DiskImagesEnabled: c.DiskImagesEnabled, DiskImagesWriteEnabled: c.DiskImagesWriteEnabled, DiskImagesAutoResizeEnabled: c.DiskImagesAutoResizeEnabled, RateLimitPerSec: c.RateLimitPerSec, MaxBytesPerSec: c.MaxBytesPerSec, Legacy: true}, true
 	}
 
 	// Legacy single token mapping.
@@ -651,11 +1323,37 @@ func (c Config) ResolveTokenContext(token string) (TokenContext, bool) {
 		if token != c.Token {
 			return TokenContext{}, false
 		}
-		return TokenContext{Root: c.BasePath, ReadOnly: c.GlobalReadOnly, QuotaBytes: c.GlobalQuotaBytes, MaxFileBytes: c.GlobalMaxFileBytes, DiskImagesEnabled: c.DiskImagesEnabled, DiskImagesWriteEnabled: c.DiskImagesWriteEnabled, DiskImagesAutoResizeEnabled: c.DiskImagesAutoResizeEnabled, Legacy: true}, true
+		return TokenContext{Root: c.BasePath, ReadOnly: c.GlobalReadOnly, QuotaBytes: c.GlobalQuotaBytes, MaxFileBytes: c.GlobalMaxFileBytes, MaxFiles: c.GlobalMaxFiles, DiskImagesEnabled: c.DiskImagesEnabled, DiskImagesWriteEnabled: c.DiskImagesWriteEnabled, DiskImagesAutoResizeEnabled: c.DiskImagesAutoResizeEnabled, RateLimitPerSec: c.RateLimitPerSec, MaxBytesPerSec: c.MaxBytesPerSec, Legacy: true}, true
 	}
 
 	// No auth (NOT RECOMMENDED) – treat everything as one root.
-	return TokenContext{Root: c.BasePath, ReadOnly: c.GlobalReadOnly, QuotaBytes: c.GlobalQuotaBytes, MaxFileBytes: c.GlobalMaxFileBytes, DiskImagesEnabled: c.DiskImagesEnabled, DiskImagesWriteEnabled: c.DiskImagesWriteEnabled, DiskImagesAutoResizeEnabled: c.DiskImagesAutoResizeEnabled, Legacy: true}, true
+	return TokenContext{Root: c.BasePath, ReadOnly: c.GlobalReadOnly, QuotaBytes: c.GlobalQuotaBytes, MaxFileBytes: c.GlobalMaxFileBytes, MaxFiles: c.GlobalMaxFiles, DiskImagesEnabled: c.DiskImagesEnabled, DiskImagesWriteEnabled: c.DiskImagesWriteEnabled, DiskImagesAutoResizeEnabled: c.DiskImagesAutoResizeEnabled, RateLimitPerSec: c.RateLimitPerSec, MaxBytesPerSec: c.MaxBytesPerSec, Legacy: true}, true
+}
+
+// TLSEnabled reports whether the server listens with HTTPS.
+func (c Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" || c.TLSAutoSelfSigned
+}
+
+// rateLimitFor returns the effective rate limit for a token entry: its own
+// value if set, otherwise the global default.
+func (c Config) rateLimitFor(tokenRate float64) float64 {
+	if tokenRate > 0 {
+		return tokenRate
+	}
+	if c.RateLimitPerSec > 0 {
+		return c.RateLimitPerSec
+	}
+	return 0
+}
+
+// bandwidthFor returns the effective bandwidth limit for a token entry: its
+// own value if set, otherwise the global default.
+func (c Config) bandwidthFor(tokenBytes uint64) uint64 {
+	if tokenBytes > 0 {
+		return tokenBytes
+	}
+	return c.MaxBytesPerSec
 }
 
 // ResolveTokenRoot returns the absolute on-disk root path for the given token.
@@ -672,3 +1370,18 @@ func (c Config) ResolveTokenRoot(token string) (root string, ok bool) {
 func EnsureRoot(path string) error {
 	return os.MkdirAll(path, 0o755)
 }
+
+// StripBOMFor reports whether writes to the W64 path p strip a leading BOM by
+// default (see StripBOMExtensions).
+func (c Config) StripBOMFor(p string) bool {
+	ext := path.Ext(p)
+	if ext == "" {
+		return false
+	}
+	for _, e := range c.StripBOMExtensions {
+		if strings.EqualFold(strings.TrimSpace(e), ext) {
+			return true
+		}
+	}
+	return false
+}
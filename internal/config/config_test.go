@@ -0,0 +1,153 @@
+package config
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCleanPathPrefix(t *testing.T) {
+	tests := []struct {
+		in, want string
+		err      bool
+	}{
+		{"", "", false},
+		{"/", "", false},
+		{"userA", "/userA", false},
+		{" /userA/ ", "/userA", false},
+		{`\users\a`, "/users/a", false},
+		{"/a//./b", "/a/b", false},
+		{"..", "", true},
+		{"/../x", "", true},
+		{"/a/../../x", "", true},
+		{`a\..\..`, "", true},
+		{"C:/x", "", true},
+		{"a\x00b", "", true},
+	}
+	for _, tt := range tests {
+		got, err := cleanPathPrefix(tt.in)
+		if got != tt.want || (err != nil) != tt.err {
+			t.Errorf("cleanPathPrefix(%q) = %q, %v, want %q, err=%v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestResolveTokenContextPathPrefix(t *testing.T) {
+	c := Default()
+	c.BasePath = t.TempDir()
+	c.Tokens = []TokenEntry{
+		{Token: "A", Root: "shared", PathPrefix: "/userA"},
+		{Token: "B", Root: "shared", PathPrefix: `userB\`},
+		{Token: "C", Root: "shared"},
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	shared := filepath.Join(c.BasePath, "shared")
+	for token, want := range map[string]string{
+		"A": filepath.Join(shared, "userA"),
+		"B": filepath.Join(shared, "userB"),
+		"C": shared,
+	} {
+		ctx, ok := c.ResolveTokenContext(token)
+		if !ok || ctx.Root != want {
+			t.Errorf("token %s: root = %q, %v, want %q", token, ctx.Root, ok, want)
+		}
+	}
+
+	for _, prefix := range []string{"..", "/userA/../../etc", `..\..`} {
+		c := c
+		c.Tokens = []TokenEntry{{Token: "X", Root: "shared", PathPrefix: prefix}}
+		if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "path_prefix") {
+			t.Errorf("path_prefix %q: Validate = %v, want a path_prefix error", prefix, err)
+		}
+	}
+}
+
+func TestIPAllowed(t *testing.T) {
+	resolve := func(t *testing.T, allow, deny []string) (TokenContext, error) {
+		c := Default()
+		c.BasePath = t.TempDir()
+		c.Tokens = []TokenEntry{{Token: "T", AllowCIDRs: allow, DenyCIDRs: deny}}
+		if err := c.Validate(); err != nil {
+			return TokenContext{}, err
+		}
+		ctx, _ := c.ResolveTokenContext("T")
+		return ctx, nil
+	}
+
+	tests := []struct {
+		name        string
+		allow, deny []string
+		ip          string
+		want        bool
+	}{
+		{"no lists", nil, nil, "203.0.113.7", true},
+		{"no lists, unknown ip", nil, nil, "", true},
+		{"v4 cidr hit", []string{"192.168.1.0/24"}, nil, "192.168.1.42", true},
+		{"v4 cidr miss", []string{"192.168.1.0/24"}, nil, "192.168.2.1", false},
+		{"v4-mapped v6 client", []string{"192.168.1.0/24"}, nil, "::ffff:192.168.1.42", true},
+		{"v6 cidr hit", []string{"2001:db8::/32"}, nil, "2001:db8:1::5", true},
+		{"v6 cidr miss", []string{"2001:db8::/32"}, nil, "2001:db9::5", false},
+		{"v4 list, v6 client", []string{"0.0.0.0/0"}, nil, "2001:db8::1", false},
+		{"bare v4", []string{" 10.0.0.5 "}, nil, "10.0.0.5", true},
+		{"bare v4 neighbour", []string{"10.0.0.5"}, nil, "10.0.0.6", false},
+		{"bare v6", []string{"fe80::1"}, nil, "fe80::1", true},
+		{"bare v6 neighbour", []string{"fe80::1"}, nil, "fe80::2", false},
+		{"deny wins", []string{"10.0.0.0/8"}, []string{"10.1.0.0/16"}, "10.1.2.3", false},
+		{"deny only", nil, []string{"10.1.0.0/16"}, "10.2.0.1", true},
+		{"unknown ip with lists", []string{"10.0.0.0/8"}, nil, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, err := resolve(t, tt.allow, tt.deny)
+			if err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			if got := ctx.IPAllowed(net.ParseIP(tt.ip)); got != tt.want {
+				t.Fatalf("IPAllowed(%q) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "2001:db8::/129", "10.0.0", "nope/8", "fe80::1::2"} {
+		if _, err := resolve(t, []string{bad}, nil); err == nil || !strings.Contains(err.Error(), "allow_cidrs") {
+			t.Errorf("allow_cidrs %q: Validate = %v, want an error", bad, err)
+		}
+		if _, err := resolve(t, nil, []string{bad}); err == nil || !strings.Contains(err.Error(), "deny_cidrs") {
+			t.Errorf("deny_cidrs %q: Validate = %v, want an error", bad, err)
+		}
+	}
+}
+
+func TestValidateListeners(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ls   []ListenerConfig
+	}{
+		{"empty addr", []ListenerConfig{{Addr: " "}}},
+		{"duplicate addr", []ListenerConfig{{Addr: ":8080"}, {Addr: ":8080", Serve: []string{"admin"}}}},
+		{"unknown group", []ListenerConfig{{Addr: ":8080", Serve: []string{"web"}}}},
+	} {
+		c := Default()
+		c.BasePath = t.TempDir()
+		c.Listeners = tc.ls
+		if err := c.Validate(); err == nil {
+			t.Errorf("%s: Validate accepted %+v", tc.name, tc.ls)
+		}
+	}
+
+	c := Default()
+	c.BasePath = t.TempDir()
+	c.Listeners = []ListenerConfig{{Addr: " :8080 ", Serve: []string{" API "}}, {Addr: "127.0.0.1:8081", Serve: []string{"admin", "bootstrap"}}}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if l := c.Listeners[0]; l.Addr != ":8080" || !l.Serves(ServeAPI) || l.Serves(ServeAdmin) {
+		t.Fatalf("listeners[0] = %+v", l)
+	}
+	if got := c.ListenAddr(ServeBootstrap); got != "127.0.0.1:8081" {
+		t.Fatalf("ListenAddr(bootstrap) = %q", got)
+	}
+}
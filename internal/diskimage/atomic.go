@@ -1,8 +1,15 @@
 package diskimage
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"wicos64-server/internal/proto"
 )
 
 // writeFileAtomic writes data to path atomically (best effort).
@@ -34,9 +41,71 @@ func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
 	// Ignore chmod errors on platforms that don't support it well.
 	_ = os.Chmod(tmpName, perm)
 
-	if err := os.Rename(tmpName, path); err != nil {
+	if err := replaceFile(tmpName, path); err != nil {
 		return err
 	}
 	ok = true
 	return nil
 }
+
+// Replacing an image file can fail transiently, e.g. while a virus scanner or
+// indexer holds it open on Windows. replaceFile retries such renames.
+var (
+	replaceRetries atomic.Int32 // extra attempts after the first one
+
+	// renameFile is os.Rename; a variable so a failing rename can be simulated.
+	renameFile = os.Rename
+)
+
+// replaceRetryBaseDelay is the wait before the first retry; it doubles per attempt.
+const replaceRetryBaseDelay = 50 * time.Millisecond
+
+// DefaultReplaceRetries is the default of SetReplaceRetries.
+const DefaultReplaceRetries = 4
+
+func init() { replaceRetries.Store(DefaultReplaceRetries) }
+
+// SetReplaceRetries sets how often the final rename of an image rewrite is
+// retried (with backoff from 50ms) before the write fails. 0 disables retries.
+func SetReplaceRetries(n int) {
+	if n < 0 {
+		n = 0
+	}
+	replaceRetries.Store(int32(n))
+}
+
+// replaceFile renames tmp over path, retrying transient failures.
+func replaceFile(tmp, path string) error {
+	retries := int(replaceRetries.Load())
+	delay := replaceRetryBaseDelay
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = renameFile(tmp, path); err == nil {
+			return nil
+		}
+		if errors.Is(err, fs.ErrNotExist) || attempt >= retries {
+			break
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+	// The message reaches clients; keep host paths out of it.
+	var le *os.LinkError
+	if errors.As(err, &le) {
+		err = le.Err
+	}
+	if retries > 0 {
+		return fmt.Errorf("replacing %s failed after %d attempts: %w", filepath.Base(path), retries+1, err)
+	}
+	return fmt.Errorf("replacing %s failed: %w", filepath.Base(path), err)
+}
+
+// imageWriteErr turns a failed image write (atomicWriteFile) into an INTERNAL
+// status error that says why, without the host paths of the temp file.
+func imageWriteErr(err error) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		err = fmt.Errorf("%s temp file: %w", pe.Op, pe.Err)
+	}
+	return newStatusErr(proto.StatusInternal, "failed to write image: "+err.Error())
+}
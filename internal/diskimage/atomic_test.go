@@ -0,0 +1,100 @@
+package diskimage
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"wicos64-server/internal/proto"
+)
+
+// failRenames makes the next n renames fail with err and counts all calls.
+func failRenames(t *testing.T, n int, err error) *int {
+	t.Helper()
+	calls := 0
+	renameFile = func(oldpath, newpath string) error {
+		calls++
+		if calls <= n {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+		}
+		return os.Rename(oldpath, newpath)
+	}
+	SetReplaceRetries(2)
+	t.Cleanup(func() {
+		renameFile = os.Rename
+		SetReplaceRetries(DefaultReplaceRetries)
+	})
+	return &calls
+}
+
+func newBlankD81(t *testing.T) string {
+	t.Helper()
+	b, err := blankImage(KindD81, []byte("TEST"), []byte("01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writeImage(t, "T.D81", b)
+}
+
+func TestReplaceFileRetriesTransientFailure(t *testing.T) {
+	p := newBlankD81(t)
+	calls := failRenames(t, 1, fs.ErrPermission)
+	if err := MkdirDirD81(p, "SUB", false); err != nil {
+		t.Fatalf("MkdirDirD81 with one failed rename: %v", err)
+	}
+	if *calls != 2 {
+		t.Fatalf("%d renames, want 2", *calls)
+	}
+	img, err := LoadD81(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(img.Files) != 1 || img.Files[0].Name != "SUB" {
+		t.Fatalf("image root after the retried write = %+v", img.Files)
+	}
+}
+
+func TestReplaceFileGivesUp(t *testing.T) {
+	p := newBlankD81(t)
+	before, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := failRenames(t, 100, fs.ErrPermission)
+	err = MkdirDirD81(p, "SUB", false)
+	// The errmsg says why, without host paths.
+	var se *StatusError
+	if !errors.As(err, &se) || se.Status() != proto.StatusInternal || se.Error() != "failed to write image: replacing T.D81 failed after 3 attempts: permission denied" {
+		t.Fatalf("MkdirDirD81 with failing renames: %v", err)
+	}
+	if *calls != 3 {
+		t.Fatalf("%d renames, want 3", *calls)
+	}
+	after, _ := os.ReadFile(p)
+	if string(after) != string(before) {
+		t.Fatal("image changed by a failed write")
+	}
+	// No temp file is left behind.
+	ents, _ := os.ReadDir(filepath.Dir(p))
+	if len(ents) != 1 {
+		t.Fatalf("directory holds %d entries after the failed write", len(ents))
+	}
+}
+
+func TestReplaceFileDoesNotRetryMissingFiles(t *testing.T) {
+	dir := t.TempDir()
+	calls := failRenames(t, 0, nil)
+	err := replaceFile(filepath.Join(dir, "NOPE"), filepath.Join(dir, "X.D81"))
+	if !errors.Is(err, fs.ErrNotExist) || *calls != 1 {
+		t.Fatalf("replaceFile of a missing temp file = %v after %d renames", err, *calls)
+	}
+
+	SetReplaceRetries(0)
+	*calls = 0
+	renameFile = func(string, string) error { *calls++; return fs.ErrPermission }
+	if err := replaceFile("a", filepath.Join(dir, "X.D81")); err == nil || err.Error() != "replacing X.D81 failed: permission denied" || *calls != 1 {
+		t.Fatalf("replaceFile without retries = %v after %d renames", err, *calls)
+	}
+}
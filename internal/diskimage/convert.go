@@ -0,0 +1,286 @@
+package diskimage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"wicos64-server/internal/proto"
+)
+
+// Capacity of a freshly formatted image (blocks free, directory entries).
+var convertCapacity = map[string][2]int{
+	KindD64: {664, 144},
+	KindD71: {1328, 144},
+	KindD81: {3160, 296},
+}
+
+// ConvertImage copies all files of the D64/D71/D81 image at srcPath into a
+// freshly formatted image of kind dstKind, written to dstPath (created or
+// truncated). The disk name and ID are taken over from the source.
+//
+// Files keep their directory order and their type (SEQ, PRG, USR). REL files
+// and D81 partitions have no equivalent in the plain writers and are refused
+// with StatusNotSupported; contents that do not fit into the new image fail
+// with StatusTooLarge. On failure dstPath is removed.
+//
+// It returns the number of files copied.
+func ConvertImage(srcPath, dstPath, dstKind string) (files int, err error) {
+	defer trackOp("CONVERT", srcPath, "", dstPath)(&err)
+
+	srcKind, err := DetectKind(srcPath)
+	if err != nil {
+		return 0, newStatusErr(proto.StatusBadRequest, err.Error())
+	}
+	if _, ok := convertCapacity[srcKind]; !ok {
+		return 0, newStatusErr(proto.StatusNotSupported, fmt.Sprintf("cannot convert %s images", srcKind))
+	}
+	capacity, ok := convertCapacity[dstKind]
+	if !ok {
+		return 0, newStatusErr(proto.StatusNotSupported, fmt.Sprintf("cannot convert to %s", dstKind))
+	}
+	if srcKind == dstKind {
+		return 0, newStatusErr(proto.StatusBadRequest, fmt.Sprintf("source is already a %s image", srcKind))
+	}
+
+	// The directory order is kept (it matters for LOAD"*"), so use Files
+	// rather than SortedEntries.
+	var entries []*FileEntry
+	switch srcKind {
+	case KindD64:
+		img, err := LoadD64(srcPath)
+		if err != nil {
+			return 0, newStatusErr(proto.StatusBadRequest, err.Error())
+		}
+		entries = img.Files
+	case KindD71:
+		img, err := LoadD71(srcPath)
+		if err != nil {
+			return 0, newStatusErr(proto.StatusBadRequest, err.Error())
+		}
+		entries = img.Files
+	case KindD81:
+		img, err := LoadD81(srcPath)
+		if err != nil {
+			return 0, newStatusErr(proto.StatusBadRequest, err.Error())
+		}
+		entries = img.Files
+	}
+
+	blocks := 0
+	for _, fe := range entries {
+		switch fe.Type {
+		case 4:
+			return 0, newStatusErr(proto.StatusNotSupported, fmt.Sprintf("REL file %q cannot be converted", fe.Name))
+		case 5, 6:
+			return 0, newStatusErr(proto.StatusNotSupported, fmt.Sprintf("subdirectory %q cannot be converted", fe.Name))
+		}
+		blocks += d81SectorsForFile(int(fe.Size))
+	}
+	if blocks > capacity[0] {
+		return 0, newStatusErr(proto.StatusTooLarge, fmt.Sprintf("%d blocks do not fit into a %s image (%d blocks free)", blocks, dstKind, capacity[0]))
+	}
+	if len(entries) > capacity[1] {
+		return 0, newStatusErr(proto.StatusTooLarge, fmt.Sprintf("%d files do not fit into a %s directory (%d entries)", len(entries), dstKind, capacity[1]))
+	}
+
+	name, id, err := readDiskNameID(srcPath, srcKind)
+	if err != nil {
+		return 0, err
+	}
+	blank, err := blankImage(dstKind, name, id)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.WriteFile(dstPath, blank, 0o644); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(dstPath)
+		}
+	}()
+
+	write := WriteFileRangeD64
+	switch dstKind {
+	case KindD71:
+		write = WriteFileRangeD71
+	case KindD81:
+		write = WriteFileRangeD81
+	}
+	types := make([]byte, 0, len(entries))
+	for _, fe := range entries {
+		data, err := ReadFileRange(srcPath, fe, 0, fe.Size)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", fe.Name, err)
+		}
+		// A '/' would address a D81 partition (D64/D71 refuse it).
+		name := strings.NewReplacer("/", "_", "\\", "_").Replace(fe.Name)
+		if _, err := write(dstPath, name, 0, data, true, true, false); err != nil {
+			var se *StatusError
+			if errors.As(err, &se) {
+				return 0, newStatusErr(se.Status(), fmt.Sprintf("%s: %s", fe.Name, se.Error()))
+			}
+			return 0, fmt.Errorf("%s: %w", fe.Name, err)
+		}
+		types = append(types, fe.Type)
+	}
+
+	if err := setRootDirTypes(dstPath, dstKind, types); err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// readDiskNameID returns the raw (PETSCII) disk name and ID of an image.
+func readDiskNameID(path, kind string) (name, id []byte, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	hdr := make([]byte, sectorSize)
+	if kind == KindD81 {
+		if _, err := f.ReadAt(hdr, d81HeaderOffset); err != nil {
+			return nil, nil, err
+		}
+		return hdr[0x04:0x14], hdr[0x16:0x18], nil
+	}
+	if _, err := f.ReadAt(hdr, d64HeaderOffset); err != nil {
+		return nil, nil, err
+	}
+	return hdr[0x90:0xA0], hdr[0xA2:0xA4], nil
+}
+
+// blankImage returns a freshly formatted image of the given kind (35 tracks
+// for D64, double-sided for D71). name (up to 16 bytes) and id (2 bytes) are
+// PETSCII and padded with shifted spaces.
+func blankImage(kind string, name, id []byte) ([]byte, error) {
+	pad := func(b []byte) {
+		for i := range b {
+			b[i] = 0xA0
+		}
+	}
+
+	switch kind {
+	case KindD64, KindD71:
+		tracks := 35
+		if kind == KindD71 {
+			tracks = d71Tracks
+		}
+		trackOff := make([]int, tracks+2)
+		for t := 1; t <= tracks; t++ {
+			trackOff[t+1] = trackOff[t] + sectorsOnD64Track((t-1)%35+1)*sectorSize
+		}
+		img := make([]byte, trackOff[tracks+1])
+		sector := func(t, s int) []byte {
+			off := trackOff[t] + s*sectorSize
+			return img[off : off+sectorSize]
+		}
+
+		bam := sector(18, 0)
+		bam[0], bam[1], bam[2] = 18, 1, 0x41
+		if kind == KindD71 {
+			bam[3] = 0x80 // double-sided
+		}
+		pad(bam[0x90:0xAB])
+		copy(bam[0x90:0xA0], name)
+		copy(bam[0xA2:0xA4], id)
+		bam[0xA5], bam[0xA6] = '2', 'A'
+
+		dir := sector(18, 1)
+		dir[0], dir[1] = 0, 0xFF
+
+		for t := 1; t <= tracks; t++ {
+			n := sectorsOnD64Track((t-1)%35 + 1)
+			bits := uint32(1)<<n - 1
+			switch t {
+			case 18:
+				bits &^= 0x03 // BAM + first directory sector
+			case 53:
+				bits = 0 // 1571: BAM of side 1, whole track reserved
+			}
+			free := 0
+			for b := bits; b != 0; b &= b - 1 {
+				free++
+			}
+			var bitmap []byte
+			if t <= 35 {
+				bam[4*t] = byte(free)
+				bitmap = bam[4*t+1 : 4*t+4]
+			} else {
+				bam[0xDD+t-36] = byte(free)
+				bitmap = sector(53, 0)[(t-36)*3 : (t-36)*3+3]
+			}
+			bitmap[0], bitmap[1], bitmap[2] = byte(bits), byte(bits>>8), byte(bits>>16)
+		}
+		return img, nil
+
+	case KindD81:
+		img := make([]byte, d81Size)
+		hdr := make([]byte, sectorSize)
+		pad(hdr[0x04:0x1D])
+		copy(hdr[0x04:0x14], name)
+		copy(hdr[0x16:0x18], id)
+		hdr[0x19], hdr[0x1A] = '3', 'D'
+
+		for s, link := range [][2]byte{{d81DirTrack, 2}, {0, 0xFF}} {
+			bam := d81ReadSector(img, d81DirTrack, s+1)
+			bam[0], bam[1] = link[0], link[1]
+			bam[2], bam[3] = 'D', 0xBB
+			copy(bam[4:6], id)
+			bam[6] = 0xC0
+		}
+		if err := initD81Root(img, hdr); err != nil {
+			return nil, err
+		}
+		return img, nil
+	}
+	return nil, newStatusErr(proto.StatusNotSupported, fmt.Sprintf("cannot format %s images", kind))
+}
+
+// setRootDirTypes sets the file type of the used root directory entries of
+// the image at path, in directory order. The writers always create PRG files.
+func setRootDirTypes(path, kind string, types []byte) error {
+	img, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	sector := func(t, s int) []byte {
+		if kind == KindD81 {
+			if t < 1 || t > d81Tracks || s >= d81SectorsPerTrack {
+				return nil
+			}
+			return d81ReadSector(img, t, s)
+		}
+		off := 0
+		for tt := 1; tt < t; tt++ {
+			off += sectorsOnD64Track((tt-1)%35+1) * sectorSize
+		}
+		if t < 1 || s >= sectorsOnD64Track((t-1)%35+1) || off+(s+1)*sectorSize > len(img) {
+			return nil
+		}
+		return img[off+s*sectorSize : off+(s+1)*sectorSize]
+	}
+
+	t, s := 18, 1
+	if kind == KindD81 {
+		t, s = d81DirTrack, d81DirSector
+	}
+	for i := 0; t != 0 && i < len(types); {
+		buf := sector(t, s)
+		if buf == nil {
+			return newStatusErr(proto.StatusInternal, fmt.Sprintf("invalid directory link %d/%d", t, s))
+		}
+		for slot := 0; slot < 8 && i < len(types); slot++ {
+			if buf[slot*32+2] == 0 {
+				continue
+			}
+			buf[slot*32+2] = 0x80 | types[i]
+			i++
+		}
+		t, s = int(buf[0]), int(buf[1])
+	}
+	return os.WriteFile(path, img, 0o644)
+}
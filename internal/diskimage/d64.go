@@ -18,7 +18,8 @@ import (
 //
 // Notes:
 //   - Subdirectories are not supported (1541 directories are flat).
-//   - REL files are parsed like any other file (no REL-side-sector support).
+//   - REL files are read through their side sectors (see rel.go); they are
+//     read-only.
 //   - Error-info variants (".d64 with error bytes") are accepted; the error
 //     bytes are ignored.
 
@@ -49,6 +50,15 @@ type FileEntry struct {
 	StartSector byte
 	Sectors     []SectorRef
 	starts      []uint64 // cumulative byte offsets per sector (same length as Sectors)
+	RecordLen   byte     // REL record length (0 for other types)
+
+	// Tape images (.t64) have no sector chain. The data is one contiguous
+	// block at DataOffset; Header (the load address of a PRG) is served in
+	// front of it. LoadAddress/EndAddress are the tape record's addresses.
+	DataOffset  int64
+	Header      []byte
+	LoadAddress uint16
+	EndAddress  uint16
 }
 
 type D64 struct {
@@ -87,7 +97,12 @@ func LoadD64(path string) (*D64, error) {
 		}
 	}
 
+	release, err := acquireParse()
+	if err != nil {
+		return nil, err
+	}
 	img, err := parseD64(path, fi.ModTime(), fi.Size())
+	release()
 	if err != nil {
 		return nil, err
 	}
@@ -208,7 +223,7 @@ func parseD64(path string, modTime time.Time, fileSize int64) (*D64, error) {
 			}
 			blocks := binary.LittleEndian.Uint16(slot[30:32])
 
-			sectors, size, starts, err := parseFileChain(f, sectorOff, tracks, int(startT), int(startS), blocks)
+			sectors, size, starts, recLen, err := parseFileEntryChain(f, sectorOff, tracks, slot, blocks)
 			if err != nil {
 				// If parsing fails, skip the entry rather than rejecting the entire image.
 				continue
@@ -223,6 +238,7 @@ func parseD64(path string, modTime time.Time, fileSize int64) (*D64, error) {
 				StartSector: startS,
 				Sectors:     sectors,
 				starts:      starts,
+				RecordLen:   recLen,
 			}
 
 			// Disambiguate duplicate names.
@@ -344,8 +360,9 @@ func parseFileChain(
 		t = nextT
 		s = nextS
 
-		// Safety cap (avoid infinite loops on broken images).
-		if len(sectors) > 2000 {
+		// Safety cap (avoid infinite loops on broken images); no file can
+		// be longer than the largest supported image (.d82).
+		if len(sectors) > d82TotalSectors {
 			return nil, 0, nil, errors.New("chain too long")
 		}
 	}
@@ -425,7 +442,7 @@ func (img *D64) SortedEntries() []*FileEntry {
 }
 
 // ReadFileRange reads a byte range from a file entry inside an image.
-// It uses the pre-parsed sector chain.
+// It uses the pre-parsed sector chain (or the contiguous block of a tape entry).
 func ReadFileRange(imgPath string, fe *FileEntry, offset, length uint64) ([]byte, error) {
 	if fe == nil {
 		return nil, errors.New("nil file")
@@ -439,6 +456,9 @@ func ReadFileRange(imgPath string, fe *FileEntry, offset, length uint64) ([]byte
 	if offset+length > fe.Size {
 		return nil, errors.New("range out of range")
 	}
+	if fe.DataOffset > 0 {
+		return readContiguousRange(imgPath, fe, offset, length)
+	}
 
 	f, err := os.Open(imgPath)
 	if err != nil {
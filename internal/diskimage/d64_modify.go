@@ -14,7 +14,8 @@ import (
 //
 // D64 has no subdirectories; fileName must not contain '/'. The sector chain is
 // freed in the BAM and the directory entry is cleared.
-func DeleteFileD64(imgPath string, fileName string) error {
+func DeleteFileD64(imgPath string, fileName string) (err error) {
+	defer trackOp("DELETE", imgPath, fileName, "")(&err)
 	nameKey := strings.ToUpper(strings.TrimSpace(fileName))
 	if nameKey == "" {
 		return newStatusErr(proto.StatusBadRequest, "empty filename")
@@ -234,7 +235,8 @@ func DeleteFileD64(imgPath string, fileName string) error {
 //
 // If allowOverwrite is true and the destination exists, the destination file
 // will be deleted first.
-func RenameFileD64(imgPath string, oldName string, newName string, allowOverwrite bool) error {
+func RenameFileD64(imgPath string, oldName string, newName string, allowOverwrite bool) (err error) {
+	defer trackOp("RENAME", imgPath, oldName, newName)(&err)
 	srcKey := strings.ToUpper(strings.TrimSpace(oldName))
 	dstKey := strings.ToUpper(strings.TrimSpace(newName))
 	if srcKey == "" || dstKey == "" {
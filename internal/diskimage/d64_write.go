@@ -58,7 +58,8 @@ func sectorsPerTrack(track int) int {
 //   - Creating a new file requires create=true.
 //
 // The function updates BAM, directory entry and sector chain.
-func WriteFileRangeD64(imgPath string, fileName string, offset uint32, data []byte, truncate bool, create bool, allowOverwrite bool) (uint32, error) {
+func WriteFileRangeD64(imgPath string, fileName string, offset uint32, data []byte, truncate bool, create bool, allowOverwrite bool) (_ uint32, err error) {
+	defer trackOp("WRITE", imgPath, fileName, "")(&err)
 	if fileName == "" {
 		return 0, newStatusErr(proto.StatusBadRequest, "empty inner file name")
 	}
@@ -195,6 +196,7 @@ func WriteFileRangeD64(imgPath string, fileName string, offset uint32, data []by
 	var existing *dirSlot
 	var free *dirSlot
 	var existingStartT, existingStartS byte
+	var existingType byte
 	var lastDirT, lastDirS byte
 
 	dirT := byte(18)
@@ -223,15 +225,25 @@ func WriteFileRangeD64(imgPath string, fileName string, offset uint32, data []by
 					existing = &dirSlot{track: dirT, sector: dirS, index: i}
 					existingStartT = sec[off+1]
 					existingStartS = sec[off+2]
+					existingType = ft
 				}
 			}
 		}
 		dirT, dirS = nextT, nextS
 	}
 
+	if existing != nil && existingType&0x07 == relTypeCode {
+		return 0, newStatusErr(proto.StatusNotSupported, "REL files are read-only")
+	}
+
 	// Helper to allocate a free data sector.
+	// Track 18 holds the BAM and directory; like CBM DOS, never put file data
+	// there so the directory can still grow.
 	allocSector := func() (byte, byte, error) {
 		for t := 1; t <= tracks; t++ {
+			if t == 18 {
+				continue
+			}
 			sp := sectorsPerTrack(t)
 			for sct := 0; sct < sp; sct++ {
 				if bamIsFree(t, sct) {
@@ -62,7 +62,13 @@ func LoadD71(path string) (*D71, error) {
 		}
 	}
 
+	release, err := acquireParse()
+	if err != nil {
+		// Not cached: a busy server is no property of the image.
+		return nil, err
+	}
 	img, err := parseD71(path, st)
+	release()
 	d71Cache.Store(path, cacheEntryD71{
 		modTime: mt,
 		size:    sz,
@@ -211,21 +217,19 @@ func parseD71(path string, st os.FileInfo) (*D71, error) {
 			case 0: // DEL
 				continue
 			case 1:
-				typ = 1
+				typ = 1 // SEQ
 			case 2:
-				typ = 2 // SEQ
+				typ = 2 // PRG
 			case 3:
-				typ = 3 // PRG
+				typ = 3 // USR
 			case 4:
-				typ = 4 // USR
-			case 5:
-				typ = 5 // REL (ignored for now)
+				typ = 4 // REL
 			default:
 				typ = 0
 			}
 
 			// Parse file chain (tolerant: skip broken entries).
-			chain, size, starts, err := parseFileChain(f, sectorOff, tracks, startT, startS, blocks)
+			chain, size, starts, recLen, err := parseFileEntryChain(f, sectorOff, tracks, slot, blocks)
 			if err != nil {
 				continue
 			}
@@ -239,6 +243,7 @@ func parseD71(path string, st os.FileInfo) (*D71, error) {
 				StartSector: byte(startS),
 				Sectors:     chain,
 				starts:      starts,
+				RecordLen:   recLen,
 			}
 
 			keyName := strings.ToUpper(fe.Name)
@@ -13,7 +13,8 @@ import (
 
 // DeleteFileD71 removes a file from a .d71 (1571) disk image and frees its blocks.
 // Root directory only.
-func DeleteFileD71(imgPath, fileName string) error {
+func DeleteFileD71(imgPath, fileName string) (err error) {
+	defer trackOp("DELETE", imgPath, fileName, "")(&err)
 	normName, err := sanitizeD64Name(fileName)
 	if err != nil {
 		return err
@@ -214,7 +215,8 @@ func DeleteFileD71(imgPath, fileName string) error {
 
 // RenameFileD71 renames a file inside a .d71 (1571) disk image.
 // Root directory only.
-func RenameFileD71(imgPath, oldName, newName string, allowOverwrite bool) error {
+func RenameFileD71(imgPath, oldName, newName string, allowOverwrite bool) (err error) {
+	defer trackOp("RENAME", imgPath, oldName, newName)(&err)
 	oldNorm, err := sanitizeD64Name(oldName)
 	if err != nil {
 		return err
@@ -20,7 +20,8 @@ import (
 //   - If truncate==false, data is written starting at offset (file grows if needed).
 //
 // Returned value is the number of bytes written.
-func WriteFileRangeD71(imgPath, fileName string, offset uint32, data []byte, truncate, create, allowOverwrite bool) (uint32, error) {
+func WriteFileRangeD71(imgPath, fileName string, offset uint32, data []byte, truncate, create, allowOverwrite bool) (_ uint32, err error) {
+	defer trackOp("WRITE", imgPath, fileName, "")(&err)
 	normName, err := sanitizeD64Name(fileName)
 	if err != nil {
 		return 0, err
@@ -183,6 +184,10 @@ func WriteFileRangeD71(imgPath, fileName string, offset uint32, data []byte, tru
 			maxTracks = 35
 		}
 		for t := 1; t <= maxTracks; t++ {
+			// Track 18 holds the BAM and directory; keep it for directory growth.
+			if t == 18 {
+				continue
+			}
 			spt := sectorsPerTrack(t)
 			for sct := 0; sct < spt; sct++ {
 				if bamIsFree(t, sct) {
@@ -271,6 +276,10 @@ func WriteFileRangeD71(imgPath, fileName string, offset uint32, data []byte, tru
 		return nil
 	}
 
+	if fileExists && foundType&0x07 == relTypeCode {
+		return 0, newStatusErr(proto.StatusNotSupported, "REL files are read-only")
+	}
+
 	// If truncating an existing file, require overwrite permission and free its chain.
 	if fileExists && truncate {
 		if !allowOverwrite {
@@ -118,7 +118,12 @@ func LoadD81(path string) (*D81, error) {
 		}
 	}
 
+	release, err := acquireParse()
+	if err != nil {
+		return nil, err
+	}
 	img, err := parseD81(path, st)
+	release()
 	if err != nil {
 		return nil, err
 	}
@@ -214,10 +219,11 @@ func parseD81(path string, st os.FileInfo) (*D81, error) {
 				sectors []SectorRef
 				size    uint64
 				starts  []uint64
+				recLen  byte
 			)
 			if typeCode != 6 && typeCode != 5 {
 				var err error
-				sectors, size, starts, err = parseFileChain(f, sectorOff, d81Tracks, int(startT), int(startS), blocks)
+				sectors, size, starts, recLen, err = parseFileEntryChain(f, sectorOff, d81Tracks, slot, blocks)
 				if err != nil {
 					// Be tolerant: skip individual entries that have a broken chain.
 					continue
@@ -232,6 +238,7 @@ func parseD81(path string, st os.FileInfo) (*D81, error) {
 				Blocks:      blocks,
 				Size:        size,
 				Sectors:     sectors,
+				RecordLen:   recLen,
 			}
 			fe.starts = starts
 
@@ -422,7 +429,7 @@ func (img *D81) readDir(startTrack, startSector byte) ([]*FileEntry, map[string]
 			// For directories/partitions (type=6 DIR, type=5 CBM), we keep it light and only store the
 			// start T/S for navigation.
 			if typeCode != 0x06 && typeCode != 0x05 {
-				sectors, size, starts, err := parseFileChain(f, sectorOff, d81Tracks, int(startT), int(startS), blocks)
+				sectors, size, starts, recLen, err := parseFileEntryChain(f, sectorOff, d81Tracks, slot, blocks)
 				if err != nil {
 					// Be robust: skip broken entries instead of rejecting the whole directory.
 					continue
@@ -430,6 +437,7 @@ func (img *D81) readDir(startTrack, startSector byte) ([]*FileEntry, map[string]
 				fe.Size = size
 				fe.Sectors = sectors
 				fe.starts = starts
+				fe.RecordLen = recLen
 			}
 
 			files = append(files, fe)
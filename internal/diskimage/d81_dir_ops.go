@@ -46,7 +46,8 @@ func splitD81DirPath(innerDir string) ([]string, error) {
 // This rebuilds (re-packs) the image so that partitions remain contiguous.
 //
 // parents behaves like "mkdir -p" inside the image.
-func MkdirDirD81(imgPath, innerDir string, parents bool) error {
+func MkdirDirD81(imgPath, innerDir string, parents bool) (err error) {
+	defer trackOp("MKDIR", imgPath, innerDir, "")(&err)
 	st, err := os.Stat(imgPath)
 	if err != nil {
 		return newStatusErr(proto.StatusNotFound, "image not found")
@@ -126,7 +127,7 @@ func MkdirDirD81(imgPath, innerDir string, parents bool) error {
 		return err
 	}
 	if err := atomicWriteFile(imgPath, newImg, perm); err != nil {
-		return newStatusErr(proto.StatusInternal, "failed to write image")
+		return imageWriteErr(err)
 	}
 	d81Cache.Delete(imgPath)
 	return nil
@@ -137,7 +138,8 @@ func MkdirDirD81(imgPath, innerDir string, parents bool) error {
 // If recursive is false, the directory must be empty (no files and no subdirectories).
 //
 // This rebuilds (re-packs) the image so that partitions remain contiguous.
-func RmdirDirD81(imgPath, innerDir string, recursive bool) error {
+func RmdirDirD81(imgPath, innerDir string, recursive bool) (err error) {
+	defer trackOp("RMDIR", imgPath, innerDir, "")(&err)
 	st, err := os.Stat(imgPath)
 	if err != nil {
 		return newStatusErr(proto.StatusNotFound, "image not found")
@@ -217,7 +219,7 @@ func RmdirDirD81(imgPath, innerDir string, recursive bool) error {
 		return err
 	}
 	if err := atomicWriteFile(imgPath, newImg, perm); err != nil {
-		return newStatusErr(proto.StatusInternal, "failed to write image")
+		return imageWriteErr(err)
 	}
 	d81Cache.Delete(imgPath)
 	return nil
@@ -233,7 +235,8 @@ func RmdirDirD81(imgPath, innerDir string, recursive bool) error {
 // For safety, moving a directory across different parent partitions is not supported
 // (it would require physically relocating the partition). Only renames within the
 // same parent are allowed.
-func RenameDirD81(imgPath, oldDirPath, newDirPath string, allowOverwrite bool) error {
+func RenameDirD81(imgPath, oldDirPath, newDirPath string, allowOverwrite bool) (err error) {
+	defer trackOp("RENAME_DIR", imgPath, oldDirPath, newDirPath)(&err)
 	if strings.ContainsAny(oldDirPath, "*?") || strings.ContainsAny(newDirPath, "*?") {
 		return newStatusErr(proto.StatusBadRequest, "wildcards are not allowed")
 	}
@@ -369,7 +372,7 @@ func RenameDirD81(imgPath, oldDirPath, newDirPath string, allowOverwrite bool) e
 	}
 
 	if err := atomicWriteFile(imgPath, img, perm); err != nil {
-		return newStatusErr(proto.StatusInternal, "failed to write image")
+		return imageWriteErr(err)
 	}
 	d81Cache.Delete(imgPath)
 	return nil
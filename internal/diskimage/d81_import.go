@@ -22,7 +22,8 @@ import (
 //
 // When allowOverwrite is true and the destination already exists (file or
 // directory), it is replaced.
-func ImportDirD81(imgPath string, dstInnerDir string, srcDirAbs string, allowOverwrite bool, fallbackPRG bool, maxFileBytes uint64) error {
+func ImportDirD81(imgPath string, dstInnerDir string, srcDirAbs string, allowOverwrite bool, fallbackPRG bool, maxFileBytes uint64) (err error) {
+	defer trackOp("IMPORT", imgPath, dstInnerDir, "")(&err)
 	dstInnerDir = strings.Trim(strings.TrimSpace(dstInnerDir), "/")
 	if dstInnerDir == "" {
 		return newStatusErr(proto.StatusBadRequest, "empty destination directory")
@@ -10,7 +10,8 @@ import (
 // DeleteFileD81 deletes a regular file from a .D81 image (1581).
 //
 // Supports nested "subdirectories" (which are 1581 partitions) via paths like "UTILS/FILE".
-func DeleteFileD81(imgPath, innerPath string) error {
+func DeleteFileD81(imgPath, innerPath string) (err error) {
+	defer trackOp("DELETE", imgPath, innerPath, "")(&err)
 	if strings.ContainsAny(innerPath, "*?") {
 		return newStatusErr(proto.StatusBadRequest, "wildcards are not allowed")
 	}
@@ -65,7 +66,7 @@ func DeleteFileD81(imgPath, innerPath string) error {
 	clearD81DirSlot(img, loc)
 
 	if err := atomicWriteFile(imgPath, img, perm); err != nil {
-		return newStatusErr(proto.StatusInternal, "failed to write image")
+		return imageWriteErr(err)
 	}
 	d81Cache.Delete(imgPath)
 	return nil
@@ -190,7 +191,8 @@ func moveFileD81AcrossPartitionsBytes(img []byte, oldDir []string, oldLeaf strin
 // Supports nested partition paths:
 //   - rename within the same partition: in-place directory entry update
 //   - move across partitions: copy+delete inside the image (with repack fallback on "disk full")
-func RenameFileD81(imgPath, oldPath, newPath string, allowOverwrite bool) error {
+func RenameFileD81(imgPath, oldPath, newPath string, allowOverwrite bool) (err error) {
+	defer trackOp("RENAME", imgPath, oldPath, newPath)(&err)
 	if strings.ContainsAny(oldPath, "*?") || strings.ContainsAny(newPath, "*?") {
 		return newStatusErr(proto.StatusBadRequest, "wildcards are not allowed")
 	}
@@ -279,7 +281,7 @@ func RenameFileD81(imgPath, oldPath, newPath string, allowOverwrite bool) error
 		copy(slot[5:21], nb)
 
 		if err := atomicWriteFile(imgPath, img, perm); err != nil {
-			return newStatusErr(proto.StatusInternal, "failed to write image")
+			return imageWriteErr(err)
 		}
 		d81Cache.Delete(imgPath)
 		return nil
@@ -295,7 +297,7 @@ func RenameFileD81(imgPath, oldPath, newPath string, allowOverwrite bool) error
 	}
 
 	if err := atomicWriteFile(imgPath, img, perm); err != nil {
-		return newStatusErr(proto.StatusInternal, "failed to write image")
+		return imageWriteErr(err)
 	}
 	d81Cache.Delete(imgPath)
 	return nil
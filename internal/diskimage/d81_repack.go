@@ -424,6 +424,14 @@ func d81NameKey(name string) string {
 }
 
 func formatD81Root(img []byte, headerTemplate []byte) error {
+	// Every repack formats a fresh root; count it for OpEvent.Repacked.
+	repackCount.Add(1)
+	return initD81Root(img, headerTemplate)
+}
+
+// initD81Root writes the root header, an empty directory chain and a BAM
+// with everything but the directory track free.
+func initD81Root(img []byte, headerTemplate []byte) error {
 	if int64(len(img)) < d81BytesNoErrorInfo {
 		return newStatusErr(proto.StatusBadRequest, "invalid d81 image")
 	}
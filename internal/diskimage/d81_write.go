@@ -43,8 +43,21 @@ type d81DirSlotLoc struct {
 }
 
 // WriteFileRangeD81 writes into a .d81 image at imgPath.
-// innerPath may contain nested partitions separated by '/'.
-func WriteFileRangeD81(imgPath, innerPath string, offset uint32, data []byte, truncate, create, allowOverwrite bool) (uint32, error) {
+// innerPath may contain nested partitions separated by '/'. A partition that
+// runs out of space is grown by repacking the image.
+func WriteFileRangeD81(imgPath, innerPath string, offset uint32, data []byte, truncate, create, allowOverwrite bool) (_ uint32, err error) {
+	defer trackOp("WRITE", imgPath, innerPath, "")(&err)
+	return writeFileRangeD81(imgPath, innerPath, offset, data, truncate, create, allowOverwrite, true)
+}
+
+// WriteFileRangeD81NoResize is WriteFileRangeD81 without the repack: a
+// partition that runs out of space fails with TOO_LARGE ("disk full").
+func WriteFileRangeD81NoResize(imgPath, innerPath string, offset uint32, data []byte, truncate, create, allowOverwrite bool) (_ uint32, err error) {
+	defer trackOp("WRITE", imgPath, innerPath, "")(&err)
+	return writeFileRangeD81(imgPath, innerPath, offset, data, truncate, create, allowOverwrite, false)
+}
+
+func writeFileRangeD81(imgPath, innerPath string, offset uint32, data []byte, truncate, create, allowOverwrite, resize bool) (uint32, error) {
 	if truncate && offset != 0 {
 		return 0, newStatusErr(proto.StatusBadRequest, "truncate requires offset=0")
 	}
@@ -82,14 +95,14 @@ func WriteFileRangeD81(imgPath, innerPath string, offset uint32, data []byte, tr
 	newSize, err := writeFileRangeD81Bytes(img, ctx, leaf, offset, data, truncate, create, allowOverwrite)
 	if err == nil {
 		if err := atomicWriteFile(imgPath, img, perm); err != nil {
-			return 0, newStatusErr(proto.StatusInternal, "failed to write image")
+			return 0, imageWriteErr(err)
 		}
 		d81Cache.Delete(imgPath)
 		return newSize, nil
 	}
 
 	// Auto-resize & repack when writing inside a partition runs out of space.
-	if isDiskFullStatus(err) && len(dirParts) > 0 {
+	if resize && isDiskFullStatus(err) && len(dirParts) > 0 {
 		return repackD81ForWrite(imgPath, origImg, innerPath, offset, data, truncate, create, allowOverwrite, perm)
 	}
 
@@ -187,6 +200,9 @@ func writeFileRangeD81Bytes(img []byte, ctx d81FSContext, name string, offset ui
 	if loc.found && (loc.entryTypeCode == 5 || loc.entryTypeCode == 6) {
 		return 0, newStatusErr(proto.StatusIsADir, "path is a directory")
 	}
+	if loc.found && loc.entryTypeCode == relTypeCode {
+		return 0, newStatusErr(proto.StatusNotSupported, "REL files are read-only")
+	}
 
 	exists := loc.found
 	// Preserve original file type when overwriting.
@@ -278,7 +294,7 @@ func atomicWriteFile(path string, data []byte, perm fs.FileMode) error {
 		return err
 	}
 	// Best-effort: on rename failure, try to remove the temp file.
-	if err := os.Rename(tmp, path); err != nil {
+	if err := replaceFile(tmp, path); err != nil {
 		_ = os.Remove(tmp)
 		return err
 	}
@@ -0,0 +1,277 @@
+package diskimage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// D82 represents a parsed Commodore 8250 disk image (.d82), read-only.
+//
+// Layout (CBM DOS 2.7, double-sided 8050 format):
+//   - 154 tracks, 77 per side; side 2 (tracks 78-154) repeats the zones of
+//     side 1: tracks 1-39 have 29 sectors, 40-53 27, 54-64 25, 65-77 23.
+//     4166 sectors in total (1066496 bytes).
+//   - Header at 39/0: link to the first BAM sector, disk name at 0x06, disk
+//     ID at 0x18, DOS type "2C" at 0x1B.
+//   - BAM: four sectors on track 38 (38/0, 38/3, 38/6, 38/9), chained, each
+//     covering 50 tracks with 5 bytes per track (free count + 32-bit bitmap)
+//     from offset 6.
+//   - Directory: chain starting at 39/1, 8 entries of 32 bytes per sector
+//     (same entry layout as the 1541).
+//
+// Like D64/D71 the namespace is flat. Error-information bytes are ignored.
+type D82 struct {
+	Path    string
+	ModTime time.Time
+	Size    int64 // byte size without error bytes
+
+	Files  []*FileEntry
+	byName map[string]*FileEntry
+}
+
+const (
+	d82Tracks          = 154
+	d82TotalSectors    = 4166
+	d82DirTrack        = 39
+	d82BAMTrack        = 38
+	d82BAMSectors      = 4  // 38/0, 38/3, 38/6, 38/9
+	d82BAMTracksPerSec = 50 // tracks covered by one BAM sector
+)
+
+// d82SectorsOnTrack returns the sector count of an 8250 track (1..154).
+func d82SectorsOnTrack(track int) int {
+	if track > 77 {
+		track -= 77
+	}
+	switch {
+	case track >= 1 && track <= 39:
+		return 29
+	case track >= 40 && track <= 53:
+		return 27
+	case track >= 54 && track <= 64:
+		return 25
+	case track >= 65 && track <= 77:
+		return 23
+	default:
+		return 0
+	}
+}
+
+// d82SectorOffset returns the byte offset of track/sector in a .d82 image.
+func d82SectorOffset(track, sector int) (int64, error) {
+	if track < 1 || track > d82Tracks {
+		return 0, fmt.Errorf("track out of range: %d", track)
+	}
+	if sector < 0 || sector >= d82SectorsOnTrack(track) {
+		return 0, fmt.Errorf("sector out of range: t=%d s=%d", track, sector)
+	}
+	var off int64
+	for t := 1; t < track; t++ {
+		off += int64(d82SectorsOnTrack(t)) * sectorSize
+	}
+	return off + int64(sector)*sectorSize, nil
+}
+
+// d82HeaderOffset is the offset of the header sector (39/0).
+var d82HeaderOffset, _ = d82SectorOffset(d82DirTrack, 0)
+
+func detectD82Layout(fileSize int64) (sizeBytes int64, tracks int, err error) {
+	if fileSize <= 0 {
+		return 0, 0, errors.New("empty image")
+	}
+	var sectors int64
+	switch {
+	case fileSize%257 == 0:
+		// with per-sector error bytes
+		sectors = fileSize / 257
+		sizeBytes = sectors * sectorSize
+	case fileSize%256 == 0:
+		sectors = fileSize / 256
+		sizeBytes = fileSize
+	default:
+		return 0, 0, fmt.Errorf("unsupported image size %d (not divisible by 256/257)", fileSize)
+	}
+	if sectors != d82TotalSectors {
+		return 0, 0, fmt.Errorf("unsupported D82 sector count %d (expected %d)", sectors, d82TotalSectors)
+	}
+	return sizeBytes, d82Tracks, nil
+}
+
+type cacheEntryD82 struct {
+	modTime time.Time
+	size    int64
+	img     *D82
+	err     error
+}
+
+var d82Cache sync.Map // map[string]cacheEntryD82
+
+// LoadD82 parses a .d82 image and caches the parsed directory for faster
+// repeat access.
+func LoadD82(path string) (*D82, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if st.IsDir() {
+		return nil, fmt.Errorf("not a file")
+	}
+
+	mt := st.ModTime()
+	sz := st.Size()
+	if v, ok := d82Cache.Load(path); ok {
+		ce := v.(cacheEntryD82)
+		if ce.modTime.Equal(mt) && ce.size == sz {
+			return ce.img, ce.err
+		}
+	}
+
+	release, err := acquireParse()
+	if err != nil {
+		return nil, err
+	}
+	img, err := parseD82(path, st)
+	release()
+	d82Cache.Store(path, cacheEntryD82{modTime: mt, size: sz, img: img, err: err})
+	return img, err
+}
+
+func parseD82(path string, st os.FileInfo) (*D82, error) {
+	sizeBytes, tracks, err := detectD82Layout(st.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img := &D82{
+		Path:    path,
+		ModTime: st.ModTime(),
+		Size:    sizeBytes,
+		Files:   []*FileEntry{},
+		byName:  map[string]*FileEntry{},
+	}
+
+	buf := make([]byte, sectorSize)
+	nextT, nextS := d82DirTrack, 1
+	seen := make(map[uint16]struct{}, 64) // detect loops (track<<8|sector)
+	for nextT != 0 {
+		key := uint16(nextT)<<8 | uint16(nextS)
+		if _, ok := seen[key]; ok {
+			break
+		}
+		seen[key] = struct{}{}
+
+		off, err := d82SectorOffset(nextT, nextS)
+		if err != nil {
+			return nil, fmt.Errorf("read dir sector: %w", err)
+		}
+		if _, err := f.ReadAt(buf, off); err != nil {
+			return nil, fmt.Errorf("read dir sector: %w", err)
+		}
+		nextT, nextS = int(buf[0]), int(buf[1])
+
+		for i := 0; i < 8; i++ {
+			slot := buf[i*32 : (i+1)*32]
+			typeCode := slot[2] & 0x07
+			if slot[2] == 0x00 || typeCode == 0x00 {
+				continue
+			}
+
+			startT, startS := slot[3], slot[4]
+			name := petsciiToASCIIName(slot[5:21])
+			if name == "" {
+				name = "NONAME"
+			}
+			blocks := binary.LittleEndian.Uint16(slot[30:32])
+
+			sectors, size, starts, recLen, err := parseFileEntryChain(f, d82SectorOffset, tracks, slot, blocks)
+			if err != nil {
+				// Skip broken entries rather than rejecting the whole image.
+				continue
+			}
+
+			fe := &FileEntry{
+				Name:        name,
+				Type:        typeCode,
+				Size:        size,
+				Blocks:      blocks,
+				StartTrack:  startT,
+				StartSector: startS,
+				Sectors:     sectors,
+				starts:      starts,
+				RecordLen:   recLen,
+			}
+
+			keyName := strings.ToUpper(fe.Name)
+			if _, exists := img.byName[keyName]; exists {
+				// Disambiguate duplicates by appending ~n.
+				for n := 2; n < 100; n++ {
+					alt := fmt.Sprintf("%s~%d", keyName, n)
+					if _, ok := img.byName[alt]; !ok {
+						fe.Name = alt
+						keyName = alt
+						break
+					}
+				}
+			}
+			img.byName[keyName] = fe
+			img.Files = append(img.Files, fe)
+		}
+	}
+	return img, nil
+}
+
+// d82BlockCounts returns the free and total data blocks of a raw .d82 image
+// from its BAM. The directory track and the BAM sectors are not counted.
+func d82BlockCounts(img []byte) (free, total int, err error) {
+	for i := 0; i < d82BAMSectors; i++ {
+		off, _ := d82SectorOffset(d82BAMTrack, 3*i)
+		if off+sectorSize > int64(len(img)) {
+			return 0, 0, errors.New("d82 image too short")
+		}
+		bam := img[off : off+sectorSize]
+		for j := 0; j < d82BAMTracksPerSec; j++ {
+			t := i*d82BAMTracksPerSec + j + 1
+			if t > d82Tracks {
+				break
+			}
+			if t == d82DirTrack {
+				continue
+			}
+			free += int(bam[6+5*j])
+			total += d82SectorsOnTrack(t)
+		}
+	}
+	return free, total - d82BAMSectors, nil
+}
+
+func (img *D82) Lookup(name string) (*FileEntry, bool) {
+	if img == nil {
+		return nil, false
+	}
+	fe, ok := img.byName[strings.ToUpper(name)]
+	return fe, ok
+}
+
+func (img *D82) SortedEntries() []*FileEntry {
+	if img == nil {
+		return nil
+	}
+	out := make([]*FileEntry, 0, len(img.Files))
+	out = append(out, img.Files...)
+	sort.Slice(out, func(i, j int) bool {
+		return strings.ToUpper(out[i].Name) < strings.ToUpper(out[j].Name)
+	})
+	return out
+}
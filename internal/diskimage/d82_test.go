@@ -0,0 +1,217 @@
+package diskimage
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// testImage builds raw disk images sector by sector.
+type testImage struct {
+	b   []byte
+	off func(track, sector int) (int64, error)
+}
+
+type ts struct{ t, s int }
+
+func (img testImage) sector(t *testing.T, track, sector int) []byte {
+	t.Helper()
+	off, err := img.off(track, sector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img.b[off : off+sectorSize]
+}
+
+// writeChain stores data in the given sectors, linked in order. The last
+// sector holds the used byte count in byte 1, like the writers in this
+// package.
+func (img testImage) writeChain(t *testing.T, chain []ts, data []byte) {
+	t.Helper()
+	for i, c := range chain {
+		sec := img.sector(t, c.t, c.s)
+		n := copy(sec[2:], data)
+		data = data[n:]
+		if i+1 < len(chain) {
+			sec[0], sec[1] = byte(chain[i+1].t), byte(chain[i+1].s)
+		} else {
+			sec[0], sec[1] = 0, byte(n)
+		}
+	}
+	if len(data) != 0 {
+		t.Fatalf("chain too short by %d bytes", len(data))
+	}
+}
+
+// dirEntry fills directory slot i of the sector at dir.
+func (img testImage) dirEntry(t *testing.T, dir ts, i int, cbmType byte, name string, start ts, blocks uint16) []byte {
+	t.Helper()
+	slot := img.sector(t, dir.t, dir.s)[i*32 : (i+1)*32]
+	slot[2], slot[3], slot[4] = cbmType, byte(start.t), byte(start.s)
+	copy(slot[5:21], bytes.Repeat([]byte{0xA0}, 16))
+	copy(slot[5:21], name)
+	slot[30], slot[31] = byte(blocks), byte(blocks>>8)
+	return slot
+}
+
+func newTestD82() testImage {
+	return testImage{b: make([]byte, d82TotalSectors*sectorSize), off: d82SectorOffset}
+}
+
+func parseTestD82(t *testing.T, b []byte) (*D82, string, error) {
+	t.Helper()
+	p := writeImage(t, "DISK.D82", b)
+	fi, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := parseD82(p, fi)
+	return img, p, err
+}
+
+func TestD82Layout(t *testing.T) {
+	total := 0
+	for tr := 1; tr <= d82Tracks; tr++ {
+		total += d82SectorsOnTrack(tr)
+	}
+	if total != d82TotalSectors {
+		t.Fatalf("%d sectors, want %d", total, d82TotalSectors)
+	}
+	for _, tt := range []struct {
+		t, s int
+		want int64
+	}{
+		{1, 0, 0},
+		{1, 28, 28 * 256},
+		{40, 0, 39 * 29 * 256},
+		{78, 0, 2083 * 256},
+		{154, 22, (d82TotalSectors - 1) * 256},
+	} {
+		if got, err := d82SectorOffset(tt.t, tt.s); err != nil || got != tt.want {
+			t.Errorf("d82SectorOffset(%d, %d) = %d, %v, want %d", tt.t, tt.s, got, err, tt.want)
+		}
+	}
+	for _, bad := range []ts{{0, 0}, {155, 0}, {1, 29}, {77, 23}, {1, -1}} {
+		if _, err := d82SectorOffset(bad.t, bad.s); err == nil {
+			t.Errorf("d82SectorOffset(%d, %d): want error", bad.t, bad.s)
+		}
+	}
+}
+
+func TestParseD82(t *testing.T) {
+	img := newTestD82()
+	dir := ts{d82DirTrack, 1}
+	img.sector(t, dir.t, dir.s)[1] = 0xFF
+
+	prg := make([]byte, 300)
+	for i := range prg {
+		prg[i] = byte(i)
+	}
+	img.writeChain(t, []ts{{1, 0}, {1, 1}}, prg)
+	img.dirEntry(t, dir, 0, 0x82, "PROGRAM", ts{1, 0}, 2)
+	img.writeChain(t, []ts{{78, 0}}, []byte("side two"))
+	img.dirEntry(t, dir, 1, 0x81, "NOTES", ts{78, 0}, 1)
+	// Broken entries are skipped: a start track past the disk and a loop.
+	img.dirEntry(t, dir, 2, 0x82, "BADTRACK", ts{200, 0}, 1)
+	img.sector(t, 2, 0)[0], img.sector(t, 2, 0)[1] = 2, 0
+	img.dirEntry(t, dir, 3, 0x82, "LOOP", ts{2, 0}, 1)
+	// A deleted entry is not listed.
+	img.dirEntry(t, dir, 4, 0x00, "GONE", ts{1, 0}, 2)
+
+	d, p, err := parseTestD82(t, img.b)
+	if err != nil {
+		t.Fatalf("parseD82: %v", err)
+	}
+	if len(d.Files) != 2 {
+		t.Fatalf("%d files, want 2", len(d.Files))
+	}
+	for _, tt := range []struct {
+		name     string
+		typeCode byte
+		want     []byte
+	}{
+		{"program", 2, prg},
+		{"NOTES", 1, []byte("side two")},
+	} {
+		fe, ok := d.Lookup(tt.name)
+		if !ok {
+			t.Fatalf("%s not found", tt.name)
+		}
+		if fe.Type != tt.typeCode || fe.Size != uint64(len(tt.want)) {
+			t.Fatalf("%s: type %d size %d, want %d %d", tt.name, fe.Type, fe.Size, tt.typeCode, len(tt.want))
+		}
+		got, err := ReadFileRange(p, fe, 0, fe.Size)
+		if err != nil || !bytes.Equal(got, tt.want) {
+			t.Fatalf("%s = % X, %v", tt.name, got, err)
+		}
+	}
+	// A range across the sector boundary.
+	fe, _ := d.Lookup("PROGRAM")
+	if got, err := ReadFileRange(p, fe, 250, 10); err != nil || !bytes.Equal(got, prg[250:260]) {
+		t.Fatalf("PROGRAM[250:260] = % X, %v", got, err)
+	}
+
+	// The same image with per-sector error bytes appended.
+	withErrors := append(append([]byte{}, img.b...), make([]byte, d82TotalSectors)...)
+	if d, _, err := parseTestD82(t, withErrors); err != nil || len(d.Files) != 2 {
+		t.Fatalf("with error bytes: %v", err)
+	}
+}
+
+func TestParseD82Malformed(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		size int
+	}{
+		{"empty", 0},
+		{"odd size", 1000},
+		{"one sector short", (d82TotalSectors - 1) * sectorSize},
+		{"D80 sized", 2083 * sectorSize},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := parseTestD82(t, make([]byte, tt.size)); err == nil {
+				t.Fatal("parseD82: want error")
+			}
+		})
+	}
+
+	t.Run("directory link out of range", func(t *testing.T) {
+		img := newTestD82()
+		sec := img.sector(t, d82DirTrack, 1)
+		sec[0], sec[1] = 0xFF, 0
+		if _, _, err := parseTestD82(t, img.b); err == nil {
+			t.Fatal("parseD82: want error")
+		}
+	})
+
+	t.Run("directory loop", func(t *testing.T) {
+		img := newTestD82()
+		sec := img.sector(t, d82DirTrack, 1)
+		sec[0], sec[1] = d82DirTrack, 1
+		img.writeChain(t, []ts{{1, 0}}, []byte("x"))
+		img.dirEntry(t, ts{d82DirTrack, 1}, 0, 0x82, "A", ts{1, 0}, 1)
+		d, _, err := parseTestD82(t, img.b)
+		if err != nil || len(d.Files) != 1 {
+			t.Fatalf("parseD82 = %v, %v", d, err)
+		}
+	})
+
+	t.Run("random directory", func(t *testing.T) {
+		rng := rand.New(rand.NewSource(1))
+		for i := 0; i < 20; i++ {
+			img := newTestD82()
+			rng.Read(img.sector(t, d82DirTrack, 1))
+			rng.Read(img.b[:64*sectorSize])
+			d, p, err := parseTestD82(t, img.b)
+			if err != nil {
+				continue
+			}
+			for _, fe := range d.Files {
+				if _, err := ReadFileRange(p, fe, 0, fe.Size); err != nil {
+					t.Fatalf("%s: %v", fe.Name, err)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package diskimage
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Image kinds returned by DetectKind.
+const (
+	KindD64 = "d64"
+	KindD71 = "d71"
+	KindD81 = "d81"
+	KindT64 = "t64"
+	KindD82 = "d82"
+)
+
+const (
+	// Offset of the header/BAM sector (track 18, sector 0) on 1541/1571 images.
+	d64HeaderOffset = 357 * sectorSize
+	// Offset of the header sector (track 40, sector 0) on 1581 images.
+	d81HeaderOffset = (d81DirTrack - 1) * d81SectorsPerTrack * sectorSize
+)
+
+// DetectKind determines the image type of path from its size and header
+// signature, independent of the file extension.
+//
+// The size selects the candidate layout (the sizes of D64, D71, D81 and D82
+// images do not overlap); the header sector then has to point at the expected
+// directory track (the BAM track for D82). Images that fail either check
+// return an error. T64 tape containers are recognized by their "C64..."
+// signature instead.
+func DetectKind(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	size := st.Size()
+
+	// Tape containers have no fixed size; they are recognized by signature.
+	sig := make([]byte, 3)
+	if _, err := f.ReadAt(sig, 0); err == nil && isT64Signature(sig) {
+		return KindT64, nil
+	}
+
+	var kind string
+	var hdrOff int64
+	var dirTrack byte
+	if _, _, err := detectD82Layout(size); err == nil {
+		kind, hdrOff, dirTrack = KindD82, d82HeaderOffset, d82BAMTrack
+	} else if _, _, err := detectD81Layout(size); err == nil {
+		kind, hdrOff, dirTrack = KindD81, d81HeaderOffset, d81DirTrack
+	} else if _, _, err := detectD71Layout(size); err == nil {
+		kind, hdrOff, dirTrack = KindD71, d64HeaderOffset, 18
+	} else if _, _, err := detectD64Layout(size); err == nil {
+		kind, hdrOff, dirTrack = KindD64, d64HeaderOffset, 18
+	} else {
+		return "", fmt.Errorf("unrecognized disk image size %d", size)
+	}
+
+	hdr := make([]byte, 4)
+	if _, err := f.ReadAt(hdr, hdrOff); err != nil && err != io.EOF {
+		return "", err
+	}
+	if hdr[0] != dirTrack {
+		return "", fmt.Errorf("%s-sized image without a valid header (directory track %d, expected %d)", kind, hdr[0], dirTrack)
+	}
+	if kind == KindD81 && hdr[2] != 'D' {
+		return "", fmt.Errorf("d81-sized image without a valid header (dos version 0x%02X)", hdr[2])
+	}
+	return kind, nil
+}
@@ -0,0 +1,89 @@
+package diskimage
+
+import (
+	"fmt"
+	"os"
+)
+
+// FreeBlocks returns the "blocks free" count of the image at path, as CBM DOS
+// reports it (the directory track is not counted).
+//
+// For D64 only tracks 1-35 are counted (extended-track BAM layouts vary).
+// For D81 the root BAM is used; space inside partitions is not included.
+// For D82 the BAM sectors on track 38 are not counted either.
+func FreeBlocks(path string) (int, error) {
+	free, _, err := BlockCounts(path)
+	return free, err
+}
+
+// UsedBlocks returns the number of allocated data blocks of the image at
+// path, i.e. the blocks counted by FreeBlocks minus the free ones. The
+// directory track is excluded, so a freshly formatted image reports 0.
+func UsedBlocks(path string) (int, error) {
+	free, total, err := BlockCounts(path)
+	if err != nil {
+		return 0, err
+	}
+	if free > total {
+		// Corrupt BAM; do not report negative usage.
+		return 0, nil
+	}
+	return total - free, nil
+}
+
+// BlockCounts returns the free and total data blocks (directory track
+// excluded) of the image at path. Free blocks come from the BAM, so they are
+// what the drive would print as "BLOCKS FREE".
+func BlockCounts(path string) (free, total int, err error) {
+	kind, err := DetectKind(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	img, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	switch kind {
+	case KindD64, KindD71:
+		bam := img[d64HeaderOffset : d64HeaderOffset+sectorSize]
+		for t := 1; t <= 35; t++ {
+			if t == 18 {
+				continue
+			}
+			free += int(bam[4*t])
+			total += sectorsPerTrack(t)
+		}
+		if kind == KindD71 {
+			// Free counts of side 1 (tracks 36-70) live at 0xDD in the side 0 BAM.
+			for t := 36; t <= 70; t++ {
+				if t == 53 {
+					continue
+				}
+				free += int(bam[0xDD+t-36])
+				total += sectorsPerTrack(t - 35)
+			}
+		}
+		return free, total, nil
+	case KindD81:
+		b, err := newD81BAMAt(img, d81DirTrack)
+		if err != nil {
+			return 0, 0, err
+		}
+		for t := 1; t <= d81Tracks; t++ {
+			if t == d81DirTrack {
+				continue
+			}
+			n, err := b.trackFreeCount(t)
+			if err != nil {
+				return 0, 0, err
+			}
+			free += n
+			total += d81SectorsPerTrack
+		}
+		return free, total, nil
+	case KindD82:
+		return d82BlockCounts(img)
+	default:
+		return 0, 0, fmt.Errorf("unsupported image kind %q", kind)
+	}
+}
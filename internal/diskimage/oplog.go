@@ -0,0 +1,78 @@
+package diskimage
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// OpEvent describes a completed mutating operation on an image file.
+// It is passed to the hook installed with SetOpHook.
+type OpEvent struct {
+	Op        string // WRITE, DELETE, RENAME, MKDIR, RMDIR, RENAME_DIR, IMPORT
+	ImagePath string // OS path of the image file
+	Inner     string // path inside the image
+	Target    string // rename target (RENAME/RENAME_DIR only)
+	Repacked  bool   // the image was rebuilt (D81 repack)
+	Err       error
+	Duration  time.Duration
+}
+
+var (
+	opHook      atomic.Pointer[func(OpEvent)]
+	opStartHook atomic.Pointer[func(op, imgPath string)]
+
+	// repackCount counts image rebuilds. It is compared before/after an op to
+	// fill OpEvent.Repacked (best effort if ops run concurrently).
+	repackCount atomic.Uint64
+)
+
+// SetOpHook installs fn to be called after every mutating image operation.
+// Passing nil removes the hook.
+func SetOpHook(fn func(OpEvent)) {
+	if fn == nil {
+		opHook.Store(nil)
+		return
+	}
+	opHook.Store(&fn)
+}
+
+// SetOpStartHook installs fn to be called when a mutating image operation
+// starts; the hook installed with SetOpHook sees it end. Passing nil removes
+// the hook.
+func SetOpStartHook(fn func(op, imgPath string)) {
+	if fn == nil {
+		opStartHook.Store(nil)
+		return
+	}
+	opStartHook.Store(&fn)
+}
+
+// trackOp is deferred by the exported mutating functions:
+//
+//	defer trackOp("WRITE", imgPath, name, "")(&err)
+func trackOp(op, imgPath, inner, target string) func(*error) {
+	startFn := opStartHook.Load()
+	if opHook.Load() == nil && startFn == nil {
+		return func(*error) {}
+	}
+	if startFn != nil {
+		(*startFn)(op, imgPath)
+	}
+	start := time.Now()
+	repacks := repackCount.Load()
+	return func(errp *error) {
+		fn := opHook.Load()
+		if fn == nil {
+			return
+		}
+		(*fn)(OpEvent{
+			Op:        op,
+			ImagePath: imgPath,
+			Inner:     inner,
+			Target:    target,
+			Repacked:  repackCount.Load() != repacks,
+			Err:       *errp,
+			Duration:  time.Since(start),
+		})
+	}
+}
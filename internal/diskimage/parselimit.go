@@ -0,0 +1,74 @@
+package diskimage
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"wicos64-server/internal/proto"
+)
+
+// Parsing an image (cache miss in LoadD64/LoadD71/LoadD81/LoadT64) reads it
+// into memory. SetMaxConcurrentParses bounds how many parses run at once; a
+// load that does not get a slot within parseWait fails with StatusBusy.
+const parseWait = 2 * time.Second
+
+var (
+	parseMu    sync.Mutex
+	parseLimit int
+	parseSlots chan struct{} // nil = unlimited
+
+	parsesInFlight atomic.Int64
+)
+
+// SetMaxConcurrentParses sets the number of images parsed at the same time.
+// 0 means unlimited. Parses already running finish under the old limit.
+func SetMaxConcurrentParses(n int) {
+	if n < 0 {
+		n = 0
+	}
+	parseMu.Lock()
+	defer parseMu.Unlock()
+	if n == parseLimit {
+		return
+	}
+	parseLimit = n
+	if n == 0 {
+		parseSlots = nil
+		return
+	}
+	parseSlots = make(chan struct{}, n)
+}
+
+// ParsesInFlight returns the number of image parses currently running.
+func ParsesInFlight() int {
+	return int(parsesInFlight.Load())
+}
+
+// acquireParse waits for a parse slot. The returned func releases it.
+func acquireParse() (func(), error) {
+	parseMu.Lock()
+	slots := parseSlots
+	parseMu.Unlock()
+
+	if slots != nil {
+		select {
+		case slots <- struct{}{}:
+		default:
+			t := time.NewTimer(parseWait)
+			defer t.Stop()
+			select {
+			case slots <- struct{}{}:
+			case <-t.C:
+				return nil, newStatusErr(proto.StatusBusy, "too many disk images being opened, try again")
+			}
+		}
+	}
+	parsesInFlight.Add(1)
+	return func() {
+		parsesInFlight.Add(-1)
+		if slots != nil {
+			<-slots
+		}
+	}, nil
+}
@@ -0,0 +1,128 @@
+package diskimage
+
+import (
+	"errors"
+	"os"
+)
+
+// REL (relative) files.
+//
+// Besides the data chain, a REL file has a chain of side sectors that index
+// its data blocks, so the drive can seek to a record:
+//   - the directory entry holds the first side sector at 0x15/0x16 and the
+//     record length at 0x17;
+//   - a side sector holds its number within a group (byte 2, 0..5), the
+//     record length (byte 3), the track/sector of the six side sectors of
+//     its group (bytes 4..15) and the track/sector of up to 120 data blocks
+//     (bytes 16..255, in file order);
+//   - on the 1581 the directory entry points to a super side sector instead
+//     (byte 2 = 0xFE, bytes 3.. list the first side sector of each group).
+//     Its link is the first side sector, so the side sectors still form one
+//     chain.
+//
+// The data blocks listed by the side sectors are the file content; the last
+// one ends like any other chain. Side sector blocks are part of the blocks
+// count of the directory entry but not of the data.
+const (
+	relTypeCode        = 4
+	relSuperSideMarker = 0xFE
+)
+
+// parseFileEntryChain parses the data of the directory entry slot (32 bytes,
+// file type at slot[2]). REL files are read through their side sectors; if
+// those are broken, the plain data chain is used like for other types.
+func parseFileEntryChain(f *os.File, sectorOff func(track, sector int) (int64, error), tracks int, slot []byte, blocks uint16) ([]SectorRef, uint64, []uint64, byte, error) {
+	startT, startS := int(slot[3]), int(slot[4])
+	if slot[2]&0x07 == relTypeCode {
+		if sectors, size, starts, recLen, err := parseRELChain(f, sectorOff, tracks, slot); err == nil {
+			return sectors, size, starts, recLen, nil
+		}
+	}
+	sectors, size, starts, err := parseFileChain(f, sectorOff, tracks, startT, startS, blocks)
+	return sectors, size, starts, 0, err
+}
+
+// parseRELChain collects the data blocks of a REL file from its side
+// sectors and checks them against the data chain.
+func parseRELChain(f *os.File, sectorOff func(track, sector int) (int64, error), tracks int, slot []byte) ([]SectorRef, uint64, []uint64, byte, error) {
+	startT, startS := int(slot[3]), int(slot[4])
+	sideT, sideS := int(slot[21]), int(slot[22])
+	recLen := slot[23]
+	if sideT == 0 || startT == 0 {
+		return nil, 0, nil, 0, errors.New("REL file without side sectors")
+	}
+
+	type ts struct{ t, s int }
+	var blocks []ts
+	visited := map[uint16]bool{}
+	buf := make([]byte, sectorSize)
+	first := true
+	for t, s := sideT, sideS; t != 0; {
+		if t < 1 || t > tracks {
+			return nil, 0, nil, 0, errors.New("invalid side sector track")
+		}
+		key := uint16(t<<8 | s&0xFF)
+		if visited[key] {
+			return nil, 0, nil, 0, errors.New("loop in side sectors")
+		}
+		visited[key] = true
+		off, err := sectorOff(t, s)
+		if err != nil {
+			return nil, 0, nil, 0, err
+		}
+		if _, err := f.ReadAt(buf, off); err != nil {
+			return nil, 0, nil, 0, err
+		}
+		t, s = int(buf[0]), int(buf[1])
+		if first && buf[2] == relSuperSideMarker {
+			first = false
+			continue
+		}
+		first = false
+		if recLen != 0 && buf[3] != recLen {
+			return nil, 0, nil, 0, errors.New("side sector record length mismatch")
+		}
+		for i := 16; i+1 < sectorSize; i += 2 {
+			if buf[i] == 0 {
+				break
+			}
+			blocks = append(blocks, ts{int(buf[i]), int(buf[i+1])})
+		}
+		if len(blocks) > d82TotalSectors {
+			return nil, 0, nil, 0, errors.New("side sectors too long")
+		}
+	}
+	if len(blocks) == 0 || blocks[0] != (ts{startT, startS}) {
+		return nil, 0, nil, 0, errors.New("side sectors do not start at the data chain")
+	}
+
+	sectors := make([]SectorRef, 0, len(blocks))
+	starts := make([]uint64, 0, len(blocks))
+	var size uint64
+	for i, b := range blocks {
+		off, err := sectorOff(b.t, b.s)
+		if err != nil {
+			return nil, 0, nil, 0, err
+		}
+		if _, err := f.ReadAt(buf[:2], off); err != nil {
+			return nil, 0, nil, 0, err
+		}
+		nextT, nextS := int(buf[0]), int(buf[1])
+		dataLen := dataBytesPerSector
+		if i == len(blocks)-1 {
+			if nextT != 0 {
+				return nil, 0, nil, 0, errors.New("data chain longer than side sectors")
+			}
+			// Same end marker as parseFileChain.
+			if nextS > 0 && nextS < dataBytesPerSector {
+				dataLen = nextS
+			}
+		} else if (ts{nextT, nextS}) != blocks[i+1] {
+			return nil, 0, nil, 0, errors.New("side sectors disagree with the data chain")
+		}
+		starts = append(starts, size)
+		sectors = append(sectors, SectorRef{Track: byte(b.t), Sector: byte(b.s), Offset: off, DataLen: dataLen})
+		size += uint64(dataLen)
+	}
+	return sectors, size, starts, recLen, nil
+}
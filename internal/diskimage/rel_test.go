@@ -0,0 +1,165 @@
+package diskimage
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func newTestD64() testImage {
+	off := func(track, sector int) (int64, error) {
+		var o int64
+		for tr := 1; tr < track; tr++ {
+			o += int64(d64TestSectors(tr)) * sectorSize
+		}
+		return o + int64(sector)*sectorSize, nil
+	}
+	return testImage{b: make([]byte, 683*sectorSize), off: off}
+}
+
+func d64TestSectors(track int) int {
+	switch {
+	case track <= 17:
+		return 21
+	case track <= 24:
+		return 19
+	case track <= 30:
+		return 18
+	default:
+		return 17
+	}
+}
+
+func newTestD81() testImage {
+	off := func(track, sector int) (int64, error) {
+		return int64((track-1)*d81SectorsPerTrack+sector) * sectorSize, nil
+	}
+	return testImage{b: make([]byte, d81TotalSectors*sectorSize), off: off}
+}
+
+// writeREL stores a REL file: the data chain plus side sectors listing its
+// blocks (120 per side sector). With super set, the directory entry points
+// to a 1581 super side sector in front of them.
+func (img testImage) writeREL(t *testing.T, dir ts, slot int, name string, recLen byte, data []byte, chain, sides []ts, super *ts) {
+	t.Helper()
+	img.writeChain(t, chain, data)
+	for i, side := range sides {
+		sec := img.sector(t, side.t, side.s)
+		if i+1 < len(sides) {
+			sec[0], sec[1] = byte(sides[i+1].t), byte(sides[i+1].s)
+		} else {
+			sec[0], sec[1] = 0, 0xFF
+		}
+		sec[2], sec[3] = byte(i), recLen
+		for j, g := range sides {
+			sec[4+2*j], sec[5+2*j] = byte(g.t), byte(g.s)
+		}
+		for j, c := range chain[min(i*120, len(chain)):min((i+1)*120, len(chain))] {
+			sec[16+2*j], sec[17+2*j] = byte(c.t), byte(c.s)
+		}
+	}
+	first := sides[0]
+	if super != nil {
+		sec := img.sector(t, super.t, super.s)
+		sec[0], sec[1] = byte(sides[0].t), byte(sides[0].s)
+		sec[2] = relSuperSideMarker
+		sec[3], sec[4] = byte(sides[0].t), byte(sides[0].s)
+		first = *super
+	}
+	e := img.dirEntry(t, dir, slot, 0x84, name, chain[0], uint16(len(chain)+len(sides)))
+	e[21], e[22], e[23] = byte(first.t), byte(first.s), recLen
+}
+
+func records(n int, recLen byte) []byte {
+	out := make([]byte, 0, n*int(recLen))
+	for i := 0; i < n; i++ {
+		rec := bytes.Repeat([]byte{0}, int(recLen))
+		rec[0] = byte(i)
+		copy(rec[1:], "RECORD")
+		out = append(out, rec...)
+	}
+	return out
+}
+
+func TestRELInD64(t *testing.T) {
+	img := newTestD64()
+	dir := ts{18, 1}
+	img.sector(t, dir.t, dir.s)[1] = 0xFF
+	data := records(30, 10)
+	chain := []ts{{17, 0}, {17, 1}}
+	img.writeREL(t, dir, 0, "DB", 10, data, chain, []ts{{17, 10}}, nil)
+
+	// Broken side sectors fall back to the plain data chain.
+	img.writeREL(t, dir, 1, "WRONGLEN", 10, data, []ts{{16, 0}, {16, 1}}, []ts{{16, 10}}, nil)
+	img.sector(t, 16, 10)[3] = 20
+	img.writeREL(t, dir, 2, "WRONGBLK", 10, data, []ts{{15, 0}, {15, 1}}, []ts{{15, 10}}, nil)
+	img.sector(t, 15, 10)[18] = 15
+	img.sector(t, 15, 10)[19] = 5
+	img.writeREL(t, dir, 3, "SIDELOOP", 10, data, []ts{{14, 0}, {14, 1}}, []ts{{14, 10}}, nil)
+	img.sector(t, 14, 10)[0], img.sector(t, 14, 10)[1] = 14, 10
+
+	p := writeImage(t, "DISK.D64", img.b)
+	fi, _ := os.Stat(p)
+	d, err := parseD64(p, fi.ModTime(), fi.Size())
+	if err != nil {
+		t.Fatalf("parseD64: %v", err)
+	}
+	for _, tt := range []struct {
+		name   string
+		recLen byte
+	}{
+		{"DB", 10},
+		{"WRONGLEN", 0},
+		{"WRONGBLK", 0},
+		{"SIDELOOP", 0},
+	} {
+		fe, ok := d.Lookup(tt.name)
+		if !ok {
+			t.Fatalf("%s not found", tt.name)
+		}
+		if fe.Type != relTypeCode || fe.RecordLen != tt.recLen || fe.Size != uint64(len(data)) {
+			t.Fatalf("%s: type %d reclen %d size %d, want %d %d %d", tt.name, fe.Type, fe.RecordLen, fe.Size, relTypeCode, tt.recLen, len(data))
+		}
+		got, err := ReadFileRange(p, fe, 0, fe.Size)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("%s: content differs (%v)", tt.name, err)
+		}
+	}
+	// Record 25 straddles the sector boundary at 254.
+	fe, _ := d.Lookup("DB")
+	if got, err := ReadFileRange(p, fe, 250, 10); err != nil || !bytes.Equal(got, data[250:260]) || got[0] != 25 {
+		t.Fatalf("record 25 = % X, %v", got, err)
+	}
+}
+
+func TestRELInD81SuperSideSector(t *testing.T) {
+	img := newTestD81()
+	dir := ts{d81DirTrack, d81DirSector}
+	img.sector(t, dir.t, dir.s)[1] = 0xFF
+
+	// 130 data blocks need two side sectors.
+	var chain []ts
+	for i := 0; i < 130; i++ {
+		chain = append(chain, ts{1 + i/d81SectorsPerTrack, i % d81SectorsPerTrack})
+	}
+	data := records(130*254/127, 127)
+	img.writeREL(t, dir, 0, "BIG", 127, data, chain, []ts{{10, 0}, {10, 1}}, &ts{10, 2})
+
+	p := writeImage(t, "DISK.D81", img.b)
+	fi, _ := os.Stat(p)
+	d, err := parseD81(p, fi)
+	if err != nil {
+		t.Fatalf("parseD81: %v", err)
+	}
+	fe, ok := d.Lookup("BIG")
+	if !ok {
+		t.Fatal("BIG not found")
+	}
+	if fe.RecordLen != 127 || fe.Size != uint64(len(data)) || len(fe.Sectors) != 130 {
+		t.Fatalf("reclen %d size %d sectors %d", fe.RecordLen, fe.Size, len(fe.Sectors))
+	}
+	got, err := ReadFileRange(p, fe, 0, fe.Size)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("content differs (%v)", err)
+	}
+}
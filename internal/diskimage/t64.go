@@ -0,0 +1,270 @@
+package diskimage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// T64 represents a parsed tape image container (.t64).
+//
+// A T64 file is a 64 byte header, a directory of 32 byte records and the file
+// data. There are no sectors: every file is one contiguous block, so entries
+// carry DataOffset instead of a sector chain. Access is read-only.
+//
+// Notes:
+//   - Only normal tape file records (entry type 1) are exposed; memory
+//     snapshots and free slots are skipped.
+//   - Many tools write a wrong end address, so the data length is clamped to
+//     the bytes actually available before the next file (or EOF).
+//   - PRG files are served with their load address in front, like a .PRG on
+//     disk.
+
+const (
+	t64HeaderSize = 64
+	t64RecordSize = 32
+	t64MaxRecords = 4096
+)
+
+// T64 is a parsed .t64 image.
+type T64 struct {
+	Path     string
+	ModTime  time.Time
+	TapeName string
+
+	Files  []*FileEntry
+	byName map[string]*FileEntry // upper-name -> entry
+}
+
+type cacheEntryT64 struct {
+	modTime time.Time
+	size    int64
+	img     *T64
+}
+
+var t64Cache sync.Map // map[string]cacheEntryT64
+
+// isT64Signature reports whether hdr starts like a T64 header ("C64 tape
+// image file", "C64S tape file", ...).
+func isT64Signature(hdr []byte) bool {
+	return len(hdr) >= 3 && string(hdr[:3]) == "C64"
+}
+
+// LoadT64 loads and parses a .t64 image with a small in-memory cache.
+// Cache is invalidated when mtime or size changes.
+func LoadT64(path string) (*T64, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, fmt.Errorf("not a file")
+	}
+
+	if v, ok := t64Cache.Load(path); ok {
+		ce := v.(cacheEntryT64)
+		if ce.modTime.Equal(fi.ModTime()) && ce.size == fi.Size() {
+			return ce.img, nil
+		}
+	}
+
+	release, err := acquireParse()
+	if err != nil {
+		return nil, err
+	}
+	img, err := parseT64(path, fi.ModTime(), fi.Size())
+	release()
+	if err != nil {
+		return nil, err
+	}
+	t64Cache.Store(path, cacheEntryT64{modTime: fi.ModTime(), size: fi.Size(), img: img})
+	return img, nil
+}
+
+type t64Record struct {
+	cbmType    byte
+	start, end uint16
+	offset     int64
+	name       string
+}
+
+func parseT64(path string, modTime time.Time, fileSize int64) (*T64, error) {
+	if fileSize < t64HeaderSize {
+		return nil, errors.New("t64 image too small")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hdr := make([]byte, t64HeaderSize)
+	if _, err := f.ReadAt(hdr, 0); err != nil {
+		return nil, err
+	}
+	if !isT64Signature(hdr) {
+		return nil, errors.New("missing t64 signature")
+	}
+	maxEntries := int(binary.LittleEndian.Uint16(hdr[0x22:0x24]))
+	if maxEntries == 0 {
+		// Some writers leave the directory size at 0; assume one record.
+		maxEntries = 1
+	}
+	if maxEntries > t64MaxRecords {
+		return nil, fmt.Errorf("unsupported t64 directory size (%d)", maxEntries)
+	}
+	dirEnd := int64(t64HeaderSize + maxEntries*t64RecordSize)
+	if dirEnd > fileSize {
+		return nil, errors.New("t64 directory exceeds image size")
+	}
+	dir := make([]byte, dirEnd-t64HeaderSize)
+	if _, err := f.ReadAt(dir, t64HeaderSize); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	img := &T64{
+		Path:     path,
+		ModTime:  modTime,
+		TapeName: petsciiToASCIIName(hdr[0x28:0x40]),
+		byName:   map[string]*FileEntry{},
+	}
+
+	recs := make([]t64Record, 0, maxEntries)
+	for i := 0; i < maxEntries; i++ {
+		rec := dir[i*t64RecordSize : (i+1)*t64RecordSize]
+		if rec[0] != 1 {
+			continue
+		}
+		offset := int64(binary.LittleEndian.Uint32(rec[8:12]))
+		if offset < dirEnd || offset >= fileSize {
+			// Skip broken records rather than rejecting the entire image.
+			continue
+		}
+		name := petsciiToASCIIName(rec[16:32])
+		if name == "" {
+			name = "NONAME"
+		}
+		recs = append(recs, t64Record{
+			cbmType: rec[1],
+			start:   binary.LittleEndian.Uint16(rec[2:4]),
+			end:     binary.LittleEndian.Uint16(rec[4:6]),
+			offset:  offset,
+			name:    name,
+		})
+	}
+
+	// Each file can extend at most to the next file's data (or EOF).
+	offsets := make([]int64, 0, len(recs)+1)
+	for _, r := range recs {
+		offsets = append(offsets, r.offset)
+	}
+	offsets = append(offsets, fileSize)
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	for _, r := range recs {
+		limit := fileSize
+		if i := sort.Search(len(offsets), func(i int) bool { return offsets[i] > r.offset }); i < len(offsets) {
+			limit = offsets[i]
+		}
+		avail := uint64(limit - r.offset)
+		length := uint64(0)
+		if r.end > r.start {
+			length = uint64(r.end - r.start)
+		}
+		if length == 0 || length > avail {
+			length = avail
+		}
+
+		// Only a "closed" CBM type (0x81 SEQ, 0x82 PRG, ...) is meaningful;
+		// many tape tools write 0x00 or 0x01 for plain programs.
+		typeCode := byte(2)
+		if r.cbmType&0x80 != 0 && r.cbmType&0x07 != 0 {
+			typeCode = r.cbmType & 0x07
+		}
+		fe := &FileEntry{
+			Name:        r.name,
+			Type:        typeCode,
+			Size:        length,
+			LoadAddress: r.start,
+			EndAddress:  r.end,
+			DataOffset:  r.offset,
+		}
+		if typeCode == 2 {
+			fe.Header = []byte{byte(r.start), byte(r.start >> 8)}
+			fe.Size += 2
+		}
+		fe.Blocks = uint16(minU64((fe.Size+dataBytesPerSector-1)/dataBytesPerSector, 0xFFFF))
+
+		// Disambiguate duplicate names.
+		key := strings.ToUpper(fe.Name)
+		if _, exists := img.byName[key]; exists {
+			for n := 2; ; n++ {
+				cand := fmt.Sprintf("%s~%d", fe.Name, n)
+				key = strings.ToUpper(cand)
+				if _, exists2 := img.byName[key]; !exists2 {
+					fe.Name = cand
+					break
+				}
+			}
+		}
+		img.Files = append(img.Files, fe)
+		img.byName[key] = fe
+	}
+	return img, nil
+}
+
+// Lookup returns a file entry by name (case-insensitive).
+func (img *T64) Lookup(name string) (*FileEntry, bool) {
+	if img == nil {
+		return nil, false
+	}
+	fe, ok := img.byName[strings.ToUpper(name)]
+	return fe, ok
+}
+
+// SortedEntries returns entries sorted by name.
+func (img *T64) SortedEntries() []*FileEntry {
+	if img == nil {
+		return nil
+	}
+	out := make([]*FileEntry, 0, len(img.Files))
+	out = append(out, img.Files...)
+	sort.Slice(out, func(i, j int) bool {
+		return strings.ToUpper(out[i].Name) < strings.ToUpper(out[j].Name)
+	})
+	return out
+}
+
+// readContiguousRange serves a range of a tape entry: Header first, then the
+// data block at DataOffset.
+func readContiguousRange(imgPath string, fe *FileEntry, offset, length uint64) ([]byte, error) {
+	out := make([]byte, 0, length)
+	hdrLen := uint64(len(fe.Header))
+	if offset < hdrLen {
+		take := minU64(hdrLen-offset, length)
+		out = append(out, fe.Header[offset:offset+take]...)
+		offset += take
+		length -= take
+	}
+	if length == 0 {
+		return out, nil
+	}
+
+	f, err := os.Open(imgPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, length)
+	if n, err := f.ReadAt(buf, fe.DataOffset+int64(offset-hdrLen)); n != len(buf) {
+		return nil, fmt.Errorf("short read: %v", err)
+	}
+	return append(out, buf...), nil
+}
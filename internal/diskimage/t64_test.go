@@ -0,0 +1,167 @@
+package diskimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type t64File struct {
+	entryType, cbmType byte
+	start, end         uint16
+	name               string
+	data               []byte
+}
+
+// buildT64 returns a tape image with one directory record per file and the
+// data blocks in order after the directory.
+func buildT64(files []t64File) []byte {
+	hdr := make([]byte, t64HeaderSize)
+	copy(hdr, "C64 tape image file")
+	binary.LittleEndian.PutUint16(hdr[0x20:], 0x0101)
+	binary.LittleEndian.PutUint16(hdr[0x22:], uint16(len(files)))
+	binary.LittleEndian.PutUint16(hdr[0x24:], uint16(len(files)))
+	copy(hdr[0x28:0x40], bytes.Repeat([]byte{' '}, 24))
+	copy(hdr[0x28:], "TESTTAPE")
+
+	dir := make([]byte, len(files)*t64RecordSize)
+	var data []byte
+	off := len(hdr) + len(dir)
+	for i, f := range files {
+		rec := dir[i*t64RecordSize:]
+		rec[0], rec[1] = f.entryType, f.cbmType
+		binary.LittleEndian.PutUint16(rec[2:], f.start)
+		binary.LittleEndian.PutUint16(rec[4:], f.end)
+		binary.LittleEndian.PutUint32(rec[8:], uint32(off+len(data)))
+		copy(rec[16:32], bytes.Repeat([]byte{0xA0}, 16))
+		copy(rec[16:32], f.name)
+		data = append(data, f.data...)
+	}
+	return append(append(hdr, dir...), data...)
+}
+
+func writeImage(t *testing.T, name string, b []byte) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestParseT64(t *testing.T) {
+	game := []byte("GAME DATA!")
+	img := buildT64([]t64File{
+		{1, 0x82, 0x0801, 0x0801 + uint16(len(game)), "GAME", game},
+		{1, 0x81, 0, 5, "NOTES", []byte("hello")},
+		// Memory snapshot: skipped.
+		{3, 0x00, 0x0000, 0x0004, "SNAP", []byte("SNAP")},
+		// Wrong end address: clamped to the bytes before EOF.
+		{1, 0x00, 0xC000, 0xFFFF, "GAME", []byte("xyz")},
+	})
+	p := writeImage(t, "TAPE.T64", img)
+	tape, err := LoadT64(p)
+	if err != nil {
+		t.Fatalf("LoadT64: %v", err)
+	}
+	if tape.TapeName != "TESTTAPE" {
+		t.Errorf("TapeName = %q", tape.TapeName)
+	}
+	if len(tape.Files) != 3 {
+		t.Fatalf("%d files, want 3", len(tape.Files))
+	}
+	if _, ok := tape.Lookup("snap"); ok {
+		t.Error("snapshot record is listed")
+	}
+
+	tests := []struct {
+		name     string
+		typeCode byte
+		want     []byte
+	}{
+		{"game", 2, append([]byte{0x01, 0x08}, game...)},
+		{"NOTES", 1, []byte("hello")},
+		{"GAME~2", 2, append([]byte{0x00, 0xC0}, "xyz"...)},
+	}
+	for _, tt := range tests {
+		fe, ok := tape.Lookup(tt.name)
+		if !ok {
+			t.Fatalf("%s not found", tt.name)
+		}
+		if fe.Type != tt.typeCode || fe.Size != uint64(len(tt.want)) {
+			t.Fatalf("%s: type %d size %d, want %d %d", tt.name, fe.Type, fe.Size, tt.typeCode, len(tt.want))
+		}
+		got, err := ReadFileRange(p, fe, 0, fe.Size)
+		if err != nil || !bytes.Equal(got, tt.want) {
+			t.Fatalf("%s = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+		// A range that straddles the load address and the data.
+		if fe.Size > 3 {
+			got, err := ReadFileRange(p, fe, 1, 2)
+			if err != nil || !bytes.Equal(got, tt.want[1:3]) {
+				t.Fatalf("%s[1:3] = % X, %v, want % X", tt.name, got, err, tt.want[1:3])
+			}
+		}
+	}
+	if fe, _ := tape.Lookup("NOTES"); fe != nil {
+		if _, err := ReadFileRange(p, fe, 3, 5); err == nil {
+			t.Error("read past the end: want error")
+		}
+	}
+}
+
+func TestParseT64Malformed(t *testing.T) {
+	valid := buildT64([]t64File{{1, 0x82, 0x0801, 0x0805, "A", []byte("abcd")}})
+	badSig := append([]byte{}, valid...)
+	copy(badSig, "XYZ")
+	hugeDir := append([]byte{}, valid...)
+	binary.LittleEndian.PutUint16(hugeDir[0x22:], t64MaxRecords+1)
+	dirPastEOF := append([]byte{}, valid...)
+	binary.LittleEndian.PutUint16(dirPastEOF[0x22:], 200)
+
+	for _, tt := range []struct {
+		name string
+		img  []byte
+	}{
+		{"empty", nil},
+		{"short header", valid[:40]},
+		{"bad signature", badSig},
+		{"directory too large", hugeDir},
+		{"directory past EOF", dirPastEOF},
+		{"header only", valid[:t64HeaderSize]},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := writeImage(t, "BAD.T64", tt.img)
+			fi, _ := os.Stat(p)
+			if _, err := parseT64(p, fi.ModTime(), fi.Size()); err == nil {
+				t.Fatal("parseT64: want error")
+			}
+		})
+	}
+
+	// A record pointing outside the image is dropped, not fatal.
+	broken := append([]byte{}, valid...)
+	binary.LittleEndian.PutUint32(broken[t64HeaderSize+8:], 0xFFFFFF)
+	p := writeImage(t, "BROKEN.T64", broken)
+	fi, _ := os.Stat(p)
+	if img, err := parseT64(p, fi.ModTime(), fi.Size()); err != nil || len(img.Files) != 0 {
+		t.Fatalf("record past EOF: %v, %v", img, err)
+	}
+
+	// No truncation of a valid image may panic.
+	for n := 0; n <= len(valid); n++ {
+		p := writeImage(t, "CUT.T64", valid[:n])
+		fi, _ := os.Stat(p)
+		img, err := parseT64(p, fi.ModTime(), fi.Size())
+		if err != nil {
+			continue
+		}
+		for _, fe := range img.Files {
+			if _, err := ReadFileRange(p, fe, 0, fe.Size); err != nil {
+				t.Fatalf("cut at %d: %s: %v", n, fe.Name, err)
+			}
+		}
+	}
+}
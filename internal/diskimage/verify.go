@@ -0,0 +1,249 @@
+package diskimage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+
+	"wicos64-server/internal/proto"
+)
+
+// VerifyReport is the result of Verify.
+//
+// Problems lists every inconsistency found, in the order they were found:
+// directory chain errors first, then file chains, then the BAM. Files is the
+// number of directory entries checked.
+type VerifyReport struct {
+	Kind     string
+	Files    int
+	Problems []string
+}
+
+// Verify checks the directory, the file chains and the BAM of a D64 or D71
+// image (like the VALIDATE command of CBM DOS, but without fixing anything).
+//
+// Reported problems:
+//   - invalid track/sector links (directory, file chains, REL side sectors)
+//   - directory entries pointing past the disk
+//   - loops and cross-linked sectors
+//   - block counts in the directory that differ from the chain length
+//   - sectors in use but marked free, and allocated sectors nothing refers to
+//   - BAM free counts that do not match the bitmap
+//
+// For D64 only tracks 1-35 are checked against the BAM (extended-track BAM
+// layouts vary). For D71 the reserved track 53 is not checked for
+// unreferenced sectors.
+func Verify(path string) (*VerifyReport, error) {
+	kind, err := DetectKind(path)
+	if err != nil {
+		return nil, newStatusErr(proto.StatusBadRequest, err.Error())
+	}
+	if kind != KindD64 && kind != KindD71 {
+		return nil, newStatusErr(proto.StatusNotSupported, fmt.Sprintf("verify is not supported for %s images", kind))
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	var sizeBytes int64
+	var tracks int
+	if kind == KindD71 {
+		sizeBytes, tracks, err = detectD71Layout(fi.Size())
+	} else {
+		sizeBytes, tracks, err = detectD64Layout(fi.Size())
+	}
+	if err != nil {
+		return nil, newStatusErr(proto.StatusBadRequest, err.Error())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) < sizeBytes {
+		return nil, newStatusErr(proto.StatusBadRequest, "disk image truncated")
+	}
+
+	v := &verifier{
+		rep:    &VerifyReport{Kind: kind},
+		data:   data[:sizeBytes],
+		tracks: tracks,
+		d71:    kind == KindD71,
+		owner:  map[[2]int]string{},
+	}
+	v.run()
+	return v.rep, nil
+}
+
+type verifier struct {
+	rep    *VerifyReport
+	data   []byte
+	tracks int
+	d71    bool
+
+	trackOff []int64
+	owner    map[[2]int]string // sector in use -> who uses it
+}
+
+func (v *verifier) problem(format string, args ...any) {
+	v.rep.Problems = append(v.rep.Problems, fmt.Sprintf(format, args...))
+}
+
+// sectors returns the number of sectors on track t (0 if t is not on the disk).
+func (v *verifier) sectors(t int) int {
+	if t < 1 || t > v.tracks {
+		return 0
+	}
+	if v.d71 && t > 35 {
+		t -= 35
+	}
+	return sectorsPerTrack(t)
+}
+
+func (v *verifier) valid(t, s int) bool {
+	return s >= 0 && s < v.sectors(t)
+}
+
+func (v *verifier) sector(t, s int) []byte {
+	off := v.trackOff[t] + int64(s)*sectorSize
+	return v.data[off : off+sectorSize]
+}
+
+// claim marks t/s as used by who. It reports (and returns false for) a
+// sector that is already in use.
+func (v *verifier) claim(t, s int, who string) bool {
+	k := [2]int{t, s}
+	if prev, ok := v.owner[k]; ok {
+		if prev == who {
+			v.problem("%s: chain loops at %d/%d", who, t, s)
+		} else {
+			v.problem("%d/%d cross-linked (%s, %s)", t, s, prev, who)
+		}
+		return false
+	}
+	v.owner[k] = who
+	return true
+}
+
+// chain follows a sector chain from t/s and returns the number of sectors
+// claimed.
+func (v *verifier) chain(t, s int, who string) int {
+	n := 0
+	for t != 0 {
+		if !v.valid(t, s) {
+			v.problem("%s: invalid link %d/%d", who, t, s)
+			return n
+		}
+		if !v.claim(t, s, who) {
+			return n
+		}
+		n++
+		buf := v.sector(t, s)
+		t, s = int(buf[0]), int(buf[1])
+	}
+	return n
+}
+
+func (v *verifier) run() {
+	v.trackOff = make([]int64, v.tracks+1)
+	var cum int64
+	for t := 1; t <= v.tracks; t++ {
+		v.trackOff[t] = cum
+		cum += int64(v.sectors(t)) * sectorSize
+	}
+
+	v.claim(18, 0, "BAM")
+	if v.d71 {
+		v.claim(53, 0, "BAM")
+	}
+	v.directory()
+	v.bam()
+}
+
+// directory walks the directory chain (18/1) and the chains of all files.
+func (v *verifier) directory() {
+	t, s := 18, 1
+	for t != 0 {
+		if !v.valid(t, s) {
+			v.problem("directory: invalid link %d/%d", t, s)
+			return
+		}
+		if !v.claim(t, s, "directory") {
+			return
+		}
+		buf := v.sector(t, s)
+		for i := 0; i < 8; i++ {
+			slot := buf[i*32 : (i+1)*32]
+			if slot[2] == 0x00 {
+				continue
+			}
+			v.file(slot)
+		}
+		t, s = int(buf[0]), int(buf[1])
+	}
+}
+
+func (v *verifier) file(slot []byte) {
+	v.rep.Files++
+	name := petsciiToASCIIName(slot[5:21])
+	if name == "" {
+		name = "NONAME"
+	}
+	name = `"` + name + `"`
+	startT, startS := int(slot[3]), int(slot[4])
+	if !v.valid(startT, startS) {
+		v.problem("%s: start %d/%d is outside the disk", name, startT, startS)
+		return
+	}
+	n := v.chain(startT, startS, name)
+
+	if slot[2]&0x07 == 4 {
+		// REL: the side sectors are a chain of their own.
+		sideT, sideS := int(slot[21]), int(slot[22])
+		if sideT != 0 {
+			n += v.chain(sideT, sideS, name+" (side sectors)")
+		}
+	}
+
+	blocks := int(binary.LittleEndian.Uint16(slot[30:32]))
+	if blocks != n {
+		v.problem("%s: directory says %d blocks, chain has %d", name, blocks, n)
+	}
+}
+
+// bam compares the BAM with the sectors found in use.
+func (v *verifier) bam() {
+	bam0 := v.sector(18, 0)
+	var bam1 []byte
+	last := 35
+	if v.d71 && bam0[3]&0x80 != 0 {
+		bam1 = v.sector(53, 0)
+		last = 70
+	}
+	for t := 1; t <= last; t++ {
+		var count byte
+		var bitmap []byte
+		if t <= 35 {
+			off := 4 * t
+			count, bitmap = bam0[off], bam0[off+1:off+4]
+		} else {
+			count, bitmap = bam0[0xDD+t-36], bam1[(t-36)*3:(t-36)*3+3]
+		}
+		free := 0
+		for s := 0; s < v.sectors(t); s++ {
+			isFree := bitmap[s/8]&(1<<(s%8)) != 0
+			who, used := v.owner[[2]int{t, s}]
+			switch {
+			case isFree:
+				free++
+				if used {
+					v.problem("%d/%d used by %s but marked free", t, s, who)
+				}
+			case !used && t != 53:
+				v.problem("%d/%d allocated but unreferenced", t, s)
+			}
+		}
+		if int(count) != free {
+			v.problem("track %d: BAM free count %d, bitmap has %d", t, count, free)
+		}
+	}
+}
@@ -22,6 +22,23 @@ var ErrSymlinkNotAllowed = errors.New("symlink not allowed")
 // case-insensitive way so that pre-existing files with different casing remain
 // accessible.
 func ToOSPath(rootAbs string, normalized string) (string, error) {
+	return toOSPath(rootAbs, normalized, nil)
+}
+
+// ToOSPathRead is ToOSPath for read access: symlinks that SymlinkAllowed
+// accepts for allowTargets are resolved through like directories, so the
+// names behind them are matched case-insensitively too. With an empty
+// allowTargets it is ToOSPath.
+func ToOSPathRead(rootAbs string, normalized string, allowTargets []string) (string, error) {
+	if len(allowTargets) == 0 {
+		return ToOSPath(rootAbs, normalized)
+	}
+	return toOSPath(rootAbs, normalized, func(link string) bool {
+		return SymlinkAllowed(rootAbs, link, allowTargets)
+	})
+}
+
+func toOSPath(rootAbs string, normalized string, follow func(link string) bool) (string, error) {
 	cleanRoot := filepath.Clean(rootAbs)
 	if normalized == "" || normalized == "/" {
 		return cleanRoot, nil
@@ -75,8 +92,8 @@ func ToOSPath(rootAbs string, normalized string) (string, error) {
 			return ensureWithinRoot(cleanRoot, p)
 		}
 
-		// Never follow symlinks during resolution.
-		if fi.Mode()&os.ModeSymlink != 0 {
+		// Never follow symlinks during resolution, unless whitelisted.
+		if fi.Mode()&os.ModeSymlink != 0 && (follow == nil || !follow(next)) {
 			// Do not traverse deeper; return a path that still contains the symlink.
 			rest := filepath.FromSlash(strings.Join(segs[i+1:], "/"))
 			p := next
@@ -86,6 +103,13 @@ func ToOSPath(rootAbs string, normalized string) (string, error) {
 			return ensureWithinRoot(cleanRoot, p)
 		}
 
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if fi, err = os.Stat(next); err != nil {
+				rest := filepath.FromSlash(strings.Join(segs[i+1:], "/"))
+				return ensureWithinRoot(cleanRoot, filepath.Join(next, rest))
+			}
+		}
+
 		// If we need to traverse further, this must be a directory.
 		if i < len(segs)-1 && !fi.IsDir() {
 			rest := filepath.FromSlash(strings.Join(segs[i+1:], "/"))
@@ -148,6 +172,79 @@ func LstatNoSymlink(rootAbs, absPath string, allowMissingLast bool) error {
 	return nil
 }
 
+// LstatAllowSymlinks is LstatNoSymlink for read access: a symlink on the
+// way is accepted if SymlinkAllowed reports its target as whitelisted in
+// allowTargets. Any other symlink is rejected with ErrSymlinkNotAllowed.
+// Never use it for paths that are written to.
+func LstatAllowSymlinks(rootAbs, absPath string, allowTargets []string) error {
+	if len(allowTargets) == 0 {
+		return LstatNoSymlink(rootAbs, absPath, false)
+	}
+	cleanRoot := filepath.Clean(rootAbs)
+	cleanP := filepath.Clean(absPath)
+	rel, err := filepath.Rel(cleanRoot, cleanP)
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return nil
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	cur := cleanRoot
+	for _, part := range parts {
+		if part == "" || part == "." {
+			continue
+		}
+		cur = filepath.Join(cur, part)
+		fi, err := os.Lstat(cur)
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 && !SymlinkAllowed(cleanRoot, cur, allowTargets) {
+			return ErrSymlinkNotAllowed
+		}
+	}
+	return nil
+}
+
+// SymlinkAllowed reports whether the symlink at link may be followed for
+// reading: its target, resolved through all further links, must exist and
+// lie within one of allowTargets and within rootAbs. The prefixes and the
+// root are compared after resolving their own symlinks as well, so a link
+// cannot reach outside them by any chain of links.
+func SymlinkAllowed(rootAbs, link string, allowTargets []string) bool {
+	if len(allowTargets) == 0 {
+		return false
+	}
+	target, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		return false
+	}
+	realRoot, err := filepath.EvalSymlinks(rootAbs)
+	if err != nil || !within(realRoot, target) {
+		return false
+	}
+	for _, prefix := range allowTargets {
+		realPrefix, err := filepath.EvalSymlinks(prefix)
+		if err != nil {
+			continue
+		}
+		if within(realPrefix, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// within reports whether p is dir or lies below it (both clean and absolute).
+func within(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
 type StatInfo struct {
 	Exists    bool
 	IsDir     bool
@@ -187,6 +284,12 @@ func EnsureParents(p string) error {
 
 // CopyFile copies a file from src to dst (overwriting dst). It creates parent directories.
 func CopyFile(src, dst string) error {
+	return CopyFileProgress(src, dst, nil)
+}
+
+// CopyFileProgress is CopyFile, calling progress (if non-nil) with the number
+// of bytes written after each chunk.
+func CopyFileProgress(src, dst string, progress func(n int64)) error {
 	if err := EnsureParents(dst); err != nil {
 		return err
 	}
@@ -204,7 +307,11 @@ func CopyFile(src, dst string) error {
 		_ = out.Close()
 	}()
 
-	if _, err := io.Copy(out, in); err != nil {
+	var w io.Writer = out
+	if progress != nil {
+		w = progressWriter{w: out, progress: progress}
+	}
+	if _, err := io.Copy(w, in); err != nil {
 		return err
 	}
 	// Best-effort flush.
@@ -219,7 +326,35 @@ func CopyFile(src, dst string) error {
 
 // CopyDirRecursive copies a directory tree from srcDir to dstDir.
 // It creates dstDir if missing, and copies files. Symlinks are not followed (they are rejected).
-func CopyDirRecursive(srcDir, dstDir string) error {
+// The source tree is checked against lim (see CheckTree) before anything is copied.
+func CopyDirRecursive(srcDir, dstDir string, lim TreeLimits) error {
+	return CopyDirRecursiveProgress(srcDir, dstDir, lim, nil)
+}
+
+// CopyDirRecursiveProgress is CopyDirRecursive with a progress callback (see
+// CopyFileProgress).
+func CopyDirRecursiveProgress(srcDir, dstDir string, lim TreeLimits, progress func(n int64)) error {
+	if err := CheckTree(srcDir, lim); err != nil {
+		return err
+	}
+	return copyDir(srcDir, dstDir, 0, lim.MaxDepth, progress)
+}
+
+// progressWriter reports every successful write to progress.
+type progressWriter struct {
+	w        io.Writer
+	progress func(n int64)
+}
+
+func (pw progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	if n > 0 {
+		pw.progress(int64(n))
+	}
+	return n, err
+}
+
+func copyDir(srcDir, dstDir string, depth, maxDepth int, progress func(n int64)) error {
 	entries, err := os.ReadDir(srcDir)
 	if err != nil {
 		return err
@@ -235,15 +370,19 @@ func CopyDirRecursive(srcDir, dstDir string) error {
 			return err
 		}
 		if info.Mode()&os.ModeSymlink != 0 {
-			return fmt.Errorf("symlink not allowed")
+			return ErrSymlinkNotAllowed
 		}
 		if info.IsDir() {
-			if err := CopyDirRecursive(src, dst); err != nil {
+			// Re-checked while copying in case the tree changed after CheckTree.
+			if maxDepth > 0 && depth+1 > maxDepth {
+				return ErrTreeTooDeep
+			}
+			if err := copyDir(src, dst, depth+1, maxDepth, progress); err != nil {
 				return err
 			}
 			continue
 		}
-		if err := CopyFile(src, dst); err != nil {
+		if err := CopyFileProgress(src, dst, progress); err != nil {
 			return err
 		}
 	}
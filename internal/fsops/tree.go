@@ -0,0 +1,94 @@
+package fsops
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrTreeTooDeep  = errors.New("directory tree too deep")
+	ErrTreeTooLarge = errors.New("directory tree too large")
+	ErrTreeCycle    = errors.New("directory cycle detected")
+)
+
+// TreeLimits bounds recursive directory operations. Zero fields are unlimited.
+type TreeLimits struct {
+	MaxDepth int    // directory levels below the top directory
+	MaxFiles uint64 // files and directories, not counting the top directory
+	MaxBytes uint64 // total size of all files
+}
+
+// CheckTree walks dir without modifying it and verifies it stays within lim.
+// Symlinks are rejected (ErrSymlinkNotAllowed). A directory that is the same
+// as one of its ancestors (bind mounts, junctions) yields ErrTreeCycle.
+func CheckTree(dir string, lim TreeLimits) error {
+	top, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	var files, bytes uint64
+	ancestors := []os.FileInfo{top}
+
+	var walk func(dir string, depth int) error
+	walk = func(dir string, depth int) error {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			info, err := e.Info()
+			if err != nil {
+				return err
+			}
+			if info.Mode()&os.ModeSymlink != 0 {
+				return ErrSymlinkNotAllowed
+			}
+			files++
+			if lim.MaxFiles > 0 && files > lim.MaxFiles {
+				return ErrTreeTooLarge
+			}
+			if !info.IsDir() {
+				bytes += uint64(info.Size())
+				if lim.MaxBytes > 0 && bytes > lim.MaxBytes {
+					return ErrTreeTooLarge
+				}
+				continue
+			}
+			if lim.MaxDepth > 0 && depth+1 > lim.MaxDepth {
+				return ErrTreeTooDeep
+			}
+			for _, a := range ancestors {
+				if os.SameFile(a, info) {
+					return ErrTreeCycle
+				}
+			}
+			ancestors = append(ancestors, info)
+			err = walk(filepath.Join(dir, e.Name()), depth+1)
+			ancestors = ancestors[:len(ancestors)-1]
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(dir, 0)
+}
+
+// RemoveTree removes p like os.RemoveAll, after checking a directory tree
+// against lim with CheckTree. Files are removed directly.
+func RemoveTree(p string, lim TreeLimits) error {
+	fi, err := os.Lstat(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if fi.IsDir() {
+		if err := CheckTree(p, lim); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(p)
+}
@@ -0,0 +1,54 @@
+// Package petscii converts Commodore character encodings for display on
+// modern terminals.
+package petscii
+
+// Screen codes are the values stored in C64 screen memory. They differ from
+// PETSCII: 0x00-0x1F are "@", letters and "[£]↑←", 0x20-0x3F match ASCII, and
+// bit 7 selects reverse video. The meaning of 0x01-0x1A and 0x41-0x5A depends
+// on the active character set.
+var (
+	screenUpper [128]byte // upper case / graphics character set (power-on default)
+	screenLower [128]byte // lower / upper case character set
+)
+
+func init() {
+	for i := range screenUpper {
+		screenUpper[i] = '.'
+		screenLower[i] = '.'
+	}
+	for c := 0x20; c <= 0x3F; c++ {
+		screenUpper[c] = byte(c)
+		screenLower[c] = byte(c)
+	}
+	for c := 0x01; c <= 0x1A; c++ {
+		screenUpper[c] = byte('A' + c - 1)
+		screenLower[c] = byte('a' + c - 1)
+		screenLower[0x40+c] = byte('A' + c - 1)
+	}
+	for _, t := range []*[128]byte{&screenUpper, &screenLower} {
+		t[0x00] = '@'
+		t[0x1B] = '['
+		t[0x1C] = '#' // pound sign
+		t[0x1D] = ']'
+		t[0x1E] = '^' // up arrow
+		t[0x1F] = '_' // left arrow
+		t[0x40] = '-' // horizontal line
+		t[0x5B] = '+' // crossed lines
+		t[0x5D] = '|' // vertical line
+		t[0x60] = ' ' // shifted space
+	}
+}
+
+// ScreenCodesToASCII translates screen codes to printable ASCII in place,
+// one byte per byte, so offsets are preserved. Reverse video characters map
+// like their normal counterparts; graphics characters become '.'. lower
+// selects the lower/upper case character set.
+func ScreenCodesToASCII(b []byte, lower bool) {
+	t := &screenUpper
+	if lower {
+		t = &screenLower
+	}
+	for i, c := range b {
+		b[i] = t[c&0x7F]
+	}
+}
@@ -16,6 +16,7 @@ const (
 	StatusBusy          byte = 11
 	StatusBadRequest    byte = 12
 	StatusInternal      byte = 13
+	StatusQuotaFull     byte = 14 // token is read-only until usage drops below quota
 )
 
 // Backwards-compatible aliases (older internal code used shorter names).
@@ -26,26 +27,150 @@ const (
 	StatusBadPath = StatusInvalidPath
 )
 
-// Feature bits (CAPS.features_lo)
+// Feature bits. Bits 0-31 are CAPS.features_lo, bits 32-63 CAPS.features_hi.
 const (
-	FeatSTATFS          uint32 = 1 << 0
-	FeatAPPEND          uint32 = 1 << 1
-	FeatSEARCH          uint32 = 1 << 2
-	FeatHASH_CRC32      uint32 = 1 << 3
-	FeatHASH_SHA1       uint32 = 1 << 4
-	FeatMKDIR_PARENTS   uint32 = 1 << 5
-	FeatRMDIR_RECURSIVE uint32 = 1 << 6
-	FeatCP_RECURSIVE    uint32 = 1 << 7
-	FeatOVERWRITE       uint32 = 1 << 8
-	FeatERRMSG          uint32 = 1 << 9
+	FeatSTATFS            uint64 = 1 << 0
+	FeatAPPEND            uint64 = 1 << 1
+	FeatSEARCH            uint64 = 1 << 2
+	FeatHASH_CRC32        uint64 = 1 << 3
+	FeatHASH_SHA1         uint64 = 1 << 4
+	FeatMKDIR_PARENTS     uint64 = 1 << 5
+	FeatRMDIR_RECURSIVE   uint64 = 1 << 6
+	FeatCP_RECURSIVE      uint64 = 1 << 7
+	FeatOVERWRITE         uint64 = 1 << 8
+	FeatERRMSG            uint64 = 1 << 9
+	FeatDIRMTIME          uint64 = 1 << 10
+	FeatSTRINGS           uint64 = 1 << 11
+	FeatTREE              uint64 = 1 << 12
+	FeatREADONLY          uint64 = 1 << 13 // state bit: token currently read-only (read_only or quota full)
+	FeatREAD_TAIL         uint64 = 1 << 14
+	FeatTOKEN_NAMES       uint64 = 1 << 15
+	FeatHASH_SHA256       uint64 = 1 << 16
+	FeatTOUCH             uint64 = 1 << 17
+	FeatMKTEMP            uint64 = 1 << 18
+	FeatBATCH             uint64 = 1 << 19
+	FeatECHO              uint64 = 1 << 20 // diagnostic (latency/timeout testing)
+	FeatCOMPRESS          uint64 = 1 << 21 // compress_responses: ReqAcceptCompressed + RespCompressed
+	FeatLOCK              uint64 = 1 << 22 // LOCK + UNLOCK
+	FeatCOPY_RANGE        uint64 = 1 << 23
+	FeatMOTD              uint64 = 1 << 24 // a motd is configured
+	FeatSAMEFILE          uint64 = 1 << 25
+	FeatLS_TREE           uint64 = 1 << 26
+	FeatSTATFS_QUOTA      uint64 = 1 << 27 // STATFS reports the token quota, not the host disk
+	FeatIMAGE_CHANGES     uint64 = 1 << 28 // image_change_index
+	FeatEXISTS_EXACT      uint64 = 1 << 29
+	FeatSCREENCODE        uint64 = 1 << 30
+	FeatCP_ASYNC          uint64 = 1 << 31 // CP FlagCP_ASYNC + PROGRESS
+	FeatVERIFY            uint64 = 1 << 32 // D64/D71 image check
+	FeatIMAGE_CONVERT     uint64 = 1 << 33 // CP FlagCP_CONVERT
+	FeatTRASH             uint64 = 1 << 34 // trash_enabled: TRASH_LS + TRASH_RESTORE
+	FeatDIRHASH           uint64 = 1 << 35
+	FeatWRITE_SIZE        uint64 = 1 << 36 // WRITE_RANGE FlagWR_WANT_SIZE
+	FeatSTAT_MANY         uint64 = 1 << 37
+	FeatHASH_CRC16        uint64 = 1 << 38 // HASH FlagH_CRC16
+	FeatMKIMAGE           uint64 = 1 << 39
+	FeatSTAGED_WRITE      uint64 = 1 << 40 // WRITE_RANGE FlagWR_STAGED + FlagWR_COMMIT
+	FeatWHOAMI            uint64 = 1 << 41
+	FeatDRY_RUN           uint64 = 1 << 42 // FlagDRY_RUN on RM/RMDIR/CP/MV
+	FeatOVERWRITE_CONFIRM uint64 = 1 << 43 // overwrite_confirm: WRITE_RANGE FlagWR_CONFIRM
+	FeatIF_HASH           uint64 = 1 << 44 // READ_RANGE FlagR_IF_HASH + RespNotModified
+	FeatLS_SORT           uint64 = 1 << 45 // LS FlagLS_SORT_* + FlagLS_REVERSE
+	FeatIMAGES            uint64 = 1 << 46 // IMAGES (disk images)
 )
 
+// FeatureNames maps the feature bits to their names, in bit order (for tools
+// and JSON output).
+var FeatureNames = []struct {
+	Bit  uint64
+	Name string
+}{
+	{FeatSTATFS, "STATFS"},
+	{FeatAPPEND, "APPEND"},
+	{FeatSEARCH, "SEARCH"},
+	{FeatHASH_CRC32, "HASH_CRC32"},
+	{FeatHASH_SHA1, "HASH_SHA1"},
+	{FeatMKDIR_PARENTS, "MKDIR_PARENTS"},
+	{FeatRMDIR_RECURSIVE, "RMDIR_RECURSIVE"},
+	{FeatCP_RECURSIVE, "CP_RECURSIVE"},
+	{FeatOVERWRITE, "OVERWRITE"},
+	{FeatERRMSG, "ERRMSG"},
+	{FeatDIRMTIME, "DIRMTIME"},
+	{FeatSTRINGS, "STRINGS"},
+	{FeatTREE, "TREE"},
+	{FeatREADONLY, "READONLY"},
+	{FeatREAD_TAIL, "READ_TAIL"},
+	{FeatTOKEN_NAMES, "TOKEN_NAMES"},
+	{FeatHASH_SHA256, "HASH_SHA256"},
+	{FeatTOUCH, "TOUCH"},
+	{FeatMKTEMP, "MKTEMP"},
+	{FeatBATCH, "BATCH"},
+	{FeatECHO, "ECHO"},
+	{FeatCOMPRESS, "COMPRESS"},
+	{FeatLOCK, "LOCK"},
+	{FeatCOPY_RANGE, "COPY_RANGE"},
+	{FeatMOTD, "MOTD"},
+	{FeatSAMEFILE, "SAMEFILE"},
+	{FeatLS_TREE, "LS_TREE"},
+	{FeatSTATFS_QUOTA, "STATFS_QUOTA"},
+	{FeatIMAGE_CHANGES, "IMAGE_CHANGES"},
+	{FeatEXISTS_EXACT, "EXISTS_EXACT"},
+	{FeatSCREENCODE, "SCREENCODE"},
+	{FeatCP_ASYNC, "CP_ASYNC"},
+	{FeatVERIFY, "VERIFY"},
+	{FeatIMAGE_CONVERT, "IMAGE_CONVERT"},
+	{FeatTRASH, "TRASH"},
+	{FeatDIRHASH, "DIRHASH"},
+	{FeatWRITE_SIZE, "WRITE_SIZE"},
+	{FeatSTAT_MANY, "STAT_MANY"},
+	{FeatHASH_CRC16, "HASH_CRC16"},
+	{FeatMKIMAGE, "MKIMAGE"},
+	{FeatSTAGED_WRITE, "STAGED_WRITE"},
+	{FeatWHOAMI, "WHOAMI"},
+	{FeatDRY_RUN, "DRY_RUN"},
+	{FeatOVERWRITE_CONFIRM, "OVERWRITE_CONFIRM"},
+	{FeatIF_HASH, "IF_HASH"},
+	{FeatLS_SORT, "LS_SORT"},
+	{FeatIMAGES, "IMAGES"},
+}
+
+// LSSortNames names the LS sort keys (FlagLS_SORT_*) by value, for tools.
+var LSSortNames = []string{"name", "size", "mtime", "type"}
+
 // Flags (op-specific)
 const (
+	// RM, RMDIR, CP and MV: run all checks but change nothing; the response
+	// lists the affected paths (count u16, listed u16, paths).
+	FlagDRY_RUN = 1 << 7
+
 	// WRITE_RANGE flags
 	FlagWR_TRUNCATE  = 1 << 0
 	FlagWR_CREATE    = 1 << 1
 	FlagWR_OVERWRITE = 1 << 2
+	// Drop a leading UTF-8/UTF-16 BOM. Only the first chunk (offset 0) is
+	// inspected; later offsets of the same file are shifted by the server.
+	FlagWR_STRIP_BOM = 1 << 3
+	// Answer with new_size u32 + written u32 instead of the legacy payload
+	// (empty for files, written u32 inside disk images).
+	FlagWR_WANT_SIZE = 1 << 4
+	// Write into a hidden staging copy instead of the file itself. The first
+	// staged chunk must be offset 0 with TRUNCATE; FlagWR_COMMIT on the last
+	// chunk (which may be empty) renames the copy over the file, so an
+	// interrupted rewrite leaves the old file intact.
+	FlagWR_STAGED = 1 << 5
+	FlagWR_COMMIT = 1 << 6
+	// With overwrite_confirm, replacing an existing file answers
+	// ALREADY_EXISTS with confirm u32 + valid_sec u16. The client repeats the
+	// chunk in time with this flag and the confirm u32 appended after data.
+	FlagWR_CONFIRM = 1 << 7
+
+	// LS flags: sort order, applied before start_index. Equal keys are
+	// ordered by name. Keys 4-7 are reserved (BAD_REQUEST).
+	FlagLS_SORT_MASK  = 0x07
+	FlagLS_SORT_NAME  = 0      // name A-Z (default)
+	FlagLS_SORT_SIZE  = 1      // largest first
+	FlagLS_SORT_MTIME = 2      // newest first
+	FlagLS_SORT_TYPE  = 3      // directories first
+	FlagLS_REVERSE    = 1 << 3 // reverse the key: Z-A, smallest/oldest/files first
 
 	// MKDIR flags
 	FlagMK_PARENTS = 1 << 0
@@ -56,6 +181,8 @@ const (
 	// CP flags
 	FlagCP_OVERWRITE = 1 << 0
 	FlagCP_RECURSIVE = 1 << 1
+	FlagCP_ASYNC     = 1 << 2 // answer with an op id at once, poll PROGRESS
+	FlagCP_CONVERT   = 1 << 3 // src image -> new dst image of another type (by extension)
 
 	// MV flags
 	FlagMV_OVERWRITE = 1 << 0
@@ -68,8 +195,78 @@ const (
 	FlagS_CASE_INSENSITIVE = 1 << 0
 	FlagS_RECURSIVE        = 1 << 1
 	FlagS_WHOLE_WORD       = 1 << 2
+	FlagS_REGEX            = 1 << 3 // query is an RE2 regular expression
+	FlagS_NAMES            = 1 << 4 // match file names, not contents
+	FlagS_INTO_IMAGES      = 1 << 5 // search the files inside mounted disk images
 
 	// HASH flags
-	// Bit0 ALGO: 0=CRC32, 1=SHA1
-	FlagH_ALGO = 1 << 0
+	// No flag or bit0 (ALGO, formerly reserved for SHA1): CRC32, 4-byte response.
+	// Bit1 SHA256: SHA-256, 32-byte response.
+	// Bit2 CRC16: CRC-16/CCITT-FALSE (poly 0x1021, init 0xFFFF), u16 response.
+	FlagH_ALGO   = 1 << 0
+	FlagH_SHA256 = 1 << 1
+	FlagH_CRC16  = 1 << 2
+
+	// TREE flags
+	FlagTR_FILES = 1 << 0 // include files (default: directories only)
+
+	// TOUCH flags
+	FlagT_CREATE = 1 << 1 // create a missing (empty) file, like FlagAP_CREATE
+
+	// BATCH flags
+	FlagB_CONTINUE = 1 << 0 // keep going after a failed sub-op
+
+	// UNLOCK flags
+	FlagUL_FORCE = 1 << 0 // remove the lock even if another token holds it
+
+	// LS_TREE flags
+	FlagLT_IMAGES = 1 << 0 // descend into mounted disk images
+
+	// READ_RANGE flags
+	FlagR_SCREENCODE = 1 << 0 // translate C64 screen codes to ASCII (1:1)
+	FlagR_SC_LOWER   = 1 << 1 // with SCREENCODE: lower/upper case character set
+	// The client appends the CRC32 (u32) of its cached copy after length. If
+	// the file's CRC32 (as HASH) matches, the answer is OK with no data and
+	// RespNotModified set. Files above if_hash_max_bytes are always read.
+	FlagR_IF_HASH = 1 << 2
+
+	// DIRHASH flags
+	FlagDH_RECURSIVE = 1 << 0 // include subdirectories (bounded by max_tree_*)
+
+	// TRASH_RESTORE flags
+	FlagTRR_OVERWRITE = 1 << 0 // replace an existing file (it goes to the trash)
+
+	// MKIMAGE flags
+	FlagMI_OVERWRITE = 1 << 0 // replace an existing file
+
+	// IMAGES flags
+	FlagIM_RECURSIVE = 1 << 0 // include subdirectories (bounded by max_tree_*)
+)
+
+// Image kinds of MKIMAGE (D64, D71, D81) and IMAGES (all).
+const (
+	ImageKindD64 byte = 1
+	ImageKindD71 byte = 2
+	ImageKindD81 byte = 3
+	ImageKindT64 byte = 4
+	ImageKindD82 byte = 5
+)
+
+// IMAGES entry flags.
+const (
+	ImgBusy   = 1 << 0 // an image write (e.g. a D81 repack) is running
+	ImgLocked = 1 << 1 // a write holds or waits for the image's path lock
+)
+
+// WHOAMI response flags.
+const (
+	WhoReadOnly        = 1 << 0 // writes are refused (read_only or quota full)
+	WhoQuotaFull       = 1 << 1 // readonly_when_full and the quota is reached
+	WhoDiskImages      = 1 << 2 // disk images are mounted as directories
+	WhoDiskImagesWrite = 1 << 3 // files inside disk images can be written
 )
+
+// LSEntryTruncated is set in the type byte of an LS entry whose name was
+// shortened so that the entry fits max_payload (config truncate_oversized).
+// The low bits still carry the type (0 = file, 1 = dir).
+const LSEntryTruncated = 1 << 7
@@ -28,6 +28,33 @@ const (
 	OpPING        = 0x0D // legacy optional
 	OpCAPS        = 0x0E
 	OpSTATFS      = 0x0F
+	OpDIRMTIME    = 0x10 // optional
+	OpSTRINGS     = 0x11 // optional
+	OpTREE        = 0x12 // optional
+	OpREAD_TAIL   = 0x13 // optional
+	OpTOKEN_NAMES = 0x14 // optional (expose_token_names)
+	OpTOUCH       = 0x15 // optional
+	OpMKTEMP      = 0x16 // optional
+	OpBATCH       = 0x17 // optional
+	OpECHO        = 0x18 // diagnostic, optional (enable_echo)
+	OpLOCK        = 0x19 // optional
+	OpUNLOCK      = 0x1A // optional
+	OpCOPY_RANGE  = 0x1B // optional
+	OpMOTD        = 0x1C // optional (motd)
+	OpSAMEFILE    = 0x1D // optional
+	OpLS_TREE     = 0x1E // optional
+
+	OpIMAGE_CHANGES = 0x1F // optional (image_change_index)
+	OpEXISTS_EXACT  = 0x20 // optional
+	OpPROGRESS      = 0x21 // optional (async CP)
+	OpVERIFY        = 0x22 // optional (disk images)
+	OpTRASH_LS      = 0x23 // optional (trash_enabled)
+	OpDIRHASH       = 0x24 // optional
+	OpTRASH_RESTORE = 0x25 // optional (trash_enabled)
+	OpSTAT_MANY     = 0x26 // optional
+	OpMKIMAGE       = 0x27 // optional (disk images, write enabled)
+	OpWHOAMI        = 0x28 // optional
+	OpIMAGES        = 0x29 // optional (disk images)
 )
 
 // ReqHeader is the fixed 10-byte request header.
@@ -57,8 +84,28 @@ func ParseReqHeader(body []byte) (ReqHeader, bool, error) {
 	return h, true, nil
 }
 
+// Request header reserved byte (byte 7) bits. All other bits must be 0.
+const (
+	// ReqAcceptCompressed: the client can inflate RespCompressed payloads.
+	ReqAcceptCompressed = 1 << 0
+)
+
+// Response header flags (byte 7, reserved in requests).
+const (
+	// RespCompressed: the payload is orig_len u16 followed by a raw DEFLATE
+	// stream (RFC 1951) that inflates to orig_len bytes. Only sent to
+	// requests with ReqAcceptCompressed.
+	RespCompressed = 1 << 0
+	// RespFromImage: the path of the request resolved inside a mounted disk
+	// image (D64/D71/D81/T64/D82), not on the host filesystem.
+	RespFromImage = 1 << 1
+	// RespNotModified: READ_RANGE with FlagR_IF_HASH, the client's cached
+	// copy is current; the payload is empty.
+	RespNotModified = 1 << 2
+)
+
 // BuildResponse builds a full W64F response body (10-byte header + payload).
-func BuildResponse(version, opEcho, status byte, payload []byte) ([]byte, error) {
+func BuildResponse(version, opEcho, status, flags byte, payload []byte) ([]byte, error) {
 	if len(payload) > 0xFFFF {
 		return nil, fmt.Errorf("response payload too large: %d", len(payload))
 	}
@@ -67,7 +114,7 @@ func BuildResponse(version, opEcho, status byte, payload []byte) ([]byte, error)
 	out[4] = version
 	out[5] = opEcho
 	out[6] = status
-	out[7] = 0
+	out[7] = flags
 	binary.LittleEndian.PutUint16(out[8:10], uint16(len(payload)))
 	copy(out[HeaderSize:], payload)
 	return out, nil
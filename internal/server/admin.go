@@ -28,6 +28,7 @@ const adminHTML = `<!doctype html>
 <html>
 <head>
 <meta charset="utf-8">
+<meta name="w64-csrf" content="{{CSRF_TOKEN}}">
 <meta name="viewport" content="width=device-width, initial-scale=1">
 <title>WiCOS64 Remote Storage — Admin</title>
 <style>
@@ -286,10 +287,14 @@ canvas { width: 100% !important; height: 200px !important; }
       <div class="flex">
         <button class="ok" onclick="actionReload()">SOFT RESTART (Reload Config)</button>
         <button onclick="actionCleanup()">Run .TMP Cleanup</button>
+        <button onclick="actionTrashCleanup()">Run Trash Cleanup</button>
         <button onclick="actionSelfTest()">Self-Test</button>
         <button onclick="actionStatsReset()">Reset Stats</button>
         <button class="danger" onclick="actionLogsClear()">Clear Logs</button>
         <button onclick="actionLogsExport()">Export Logs</button>
+        <button onclick="actionLogsExportAggregate()">Export per Minute (CSV)</button>
+        <button onclick="window.open('/admin/api/imagelog', '_blank')">Disk Image Op Log</button>
+        <button onclick="window.open('/admin/api/images', '_blank')">Busy Disk Images</button>
       </div>
       <div style="margin-top:10px" class="small">
         Hint: Admin UI is offline (no CDN). Charts use embedded Chart.js.
@@ -363,11 +368,23 @@ canvas { width: 100% !important; height: 200px !important; }
             <label class="small">Root<br><input id="tokRoot" placeholder="/"></label>
             <label class="small">Quota (bytes, 0=off)<br><input id="tokQuota" placeholder="0"></label>
             <label class="small">Max file (bytes, 0=off)<br><input id="tokMaxFile" placeholder="0"></label>
+            <label class="small">Max files (count, 0=off)<br><input id="tokMaxFiles" placeholder="0"></label>
+            <label class="small">Rate limit (req/s, 0=global)<br><input id="tokRateLimit" placeholder="0"></label>
+            <label class="small">Bandwidth (bytes/s, 0=global)<br><input id="tokMaxBps" placeholder="0"></label>
+          </div>
+          <div class="flex">
+            <label class="small">Allow CIDRs (comma, empty=any)<br><input id="tokAllowCIDRs" placeholder="192.168.1.0/24"></label>
+            <label class="small">Deny CIDRs (comma)<br><input id="tokDenyCIDRs" placeholder=""></label>
+            <label class="small">Valid from (unix, 0=open)<br><input id="tokValidFrom" placeholder="0"></label>
+            <label class="small">Valid until (unix, 0=open)<br><input id="tokValidUntil" placeholder="0"></label>
+            <label class="small">Endpoint (empty=any, restart for new paths)<br><input id="tokEndpoint" placeholder="/tenant/api"></label>
+            <label class="small">Home dir in root (empty=root)<br><input id="tokPathPrefix" placeholder="/userA"></label>
           </div>
           <div class="flex">
             <label class="small"><input type="checkbox" id="tokEnabled" checked> Enabled</label>
             <label class="small"><input type="checkbox" id="tokReadOnly"> Read-only</label>
-            <label class="small">Disk images (.D64/.D71/.D81)<br><select id="tokDiskImages"><option value="">(inherit)</option><option value="true">true</option><option value="false">false</option></select></label>
+            <label class="small"><input type="checkbox" id="tokROWhenFull"> Read-only when quota full</label>
+            <label class="small">Disk images (.D64/.D71/.D81/.T64)<br><select id="tokDiskImages"><option value="">(inherit)</option><option value="true">true</option><option value="false">false</option></select></label>
             <label class="small">Disk images write (.D64/.D71/.D81)<br><select id="tokDiskImagesWrite"><option value="">(inherit)</option><option value="true">true</option><option value="false">false</option></select></label>
 				<label class="small">Disk images auto-resize (D81 subdirs)<br><select id="tokDiskImagesAutoResize"><option value="">(inherit)</option><option value="true">true</option><option value="false">false</option></select></label>
 				<label class="small"><input type="checkbox" id="tokDiskImagesRenameConvert"> Allow rename convert (.D64/.D71/.D81)</label>
@@ -405,6 +422,32 @@ canvas { width: 100% !important; height: 200px !important; }
           <option value="0A">MV</option>
           <option value="0B">SEARCH</option>
           <option value="0C">HASH</option>
+          <option value="10">DIRMTIME</option>
+          <option value="11">STRINGS</option>
+          <option value="12">TREE</option>
+          <option value="13">READ_TAIL</option>
+          <option value="14">TOKEN_NAMES</option>
+          <option value="15">TOUCH</option>
+          <option value="16">MKTEMP</option>
+          <option value="17">BATCH</option>
+          <option value="18">ECHO</option>
+          <option value="19">LOCK</option>
+          <option value="1A">UNLOCK</option>
+          <option value="1B">COPY_RANGE</option>
+          <option value="1C">MOTD</option>
+          <option value="1D">SAMEFILE</option>
+          <option value="1E">LS_TREE</option>
+          <option value="1F">IMAGE_CHANGES</option>
+          <option value="20">EXISTS_EXACT</option>
+          <option value="21">PROGRESS</option>
+          <option value="22">VERIFY</option>
+          <option value="23">TRASH_LS</option>
+          <option value="24">DIRHASH</option>
+          <option value="25">TRASH_RESTORE</option>
+          <option value="26">STAT_MANY</option>
+          <option value="27">MKIMAGE</option>
+          <option value="28">WHOAMI</option>
+          <option value="29">IMAGES</option>
         </select>
       </label>
       <label>Errors <input type="checkbox" id="fErr" /></label>
@@ -497,8 +540,12 @@ canvas { width: 100% !important; height: 200px !important; }
 			<div class="grid2">
 				<label class="small">Listen<br><input id="cfgListen" placeholder=":8080"></label>
 				<label class="small">Endpoint<br><input id="cfgEndpoint" placeholder="/wicos64/api"></label>
+				<label class="small">TLS cert file (PEM, restart)<br><input id="cfgTLSCert" placeholder="(none)"></label>
+				<label class="small">TLS key file (PEM, restart)<br><input id="cfgTLSKey" placeholder="(none)"></label>
+				<label class="small">TLS self-signed (auto)<br><select id="cfgTLSAuto"><option value="false">false</option><option value="true">true</option></select></label>
 				<label class="small">Base path (storage root)<br><input id="cfgBasePath" placeholder="./data"></label>
 				<label class="small">Server name<br><input id="cfgServerName" placeholder="WiCOS64 Remote Storage"></label>
+				<label class="small">MOTD (shown on connect)<br><input id="cfgMotd" placeholder="(none)"></label>
 				<label class="small">Legacy token (optional)<br><input id="cfgLegacyToken" placeholder="CHANGE-ME"></label>
 			</div>
 		</details>
@@ -509,10 +556,15 @@ canvas { width: 100% !important; height: 200px !important; }
 				<label class="small">Max payload (bytes)<br><input id="cfgMaxPayload" type="number" min="0"></label>
 				<label class="small">Max chunk (bytes)<br><input id="cfgMaxChunk" type="number" min="0"></label>
 				<label class="small">Max entries (LS/SEARCH)<br><input id="cfgMaxEntries" type="number" min="0"></label>
+				<label class="small">Truncate oversized LS/SEARCH entries<br><select id="cfgTruncOversized"><option value="true">true</option><option value="false">false</option></select></label>
 				<label class="small">Max path length<br><input id="cfgMaxPath" type="number" min="0"></label>
 				<label class="small">Max name length<br><input id="cfgMaxName" type="number" min="0"></label>
 				<label class="small">Global max file (bytes, 0=off)<br><input id="cfgGlobalMaxFile" type="number" min="0"></label>
+				<label class="small">Global max files (count, 0=off)<br><input id="cfgGlobalMaxFiles" type="number" min="0"></label>
+				<label class="small">Rate limit per token (req/s, 0=off)<br><input id="cfgRateLimit" type="number" min="0" step="any"></label>
+				<label class="small">Bandwidth per token (bytes/s, 0=off)<br><input id="cfgMaxBps" type="number" min="0"></label>
 				<label class="small">Global quota (bytes, 0=off)<br><input id="cfgGlobalQuota" type="number" min="0"></label>
+				<label class="small">quota: count images by used blocks<br><select id="cfgQuotaLogicalImages"><option value="false">false</option><option value="true">true</option></select></label>
 				<label class="small">Global read-only<br>
 					<select id="cfgGlobalReadOnly">
 						<option value="false">false</option>
@@ -529,12 +581,35 @@ canvas { width: 100% !important; height: 200px !important; }
 				<label class="small">rmdir recursive<br><select id="cfgRmdirRecursive"><option value="true">true</option><option value="false">false</option></select></label>
 				<label class="small">cp recursive<br><select id="cfgCpRecursive"><option value="true">true</option><option value="false">false</option></select></label>
 				<label class="small">overwrite allowed<br><select id="cfgOverwrite"><option value="true">true</option><option value="false">false</option></select></label>
+				<label class="small">overwrite needs confirm<br><select id="cfgOverwriteConfirm"><option value="false">false</option><option value="true">true</option></select></label>
+				<label class="small">overwrite confirm window (sec)<br><input id="cfgOverwriteConfirmSec" type="number" min="1"></label>
 				<label class="small">error messages in response<br><select id="cfgErrMsg"><option value="true">true</option><option value="false">false</option></select></label>
+				<label class="small">debug short/garbled requests<br><select id="cfgDebugShortReq"><option value="false">false</option><option value="true">true</option></select></label>
+				<label class="small">strip BOM for extensions<br><input id="cfgStripBOM" placeholder=".TXT, .CSV"></label>
+				<label class="small">disabled ops (name or hex)<br><input id="cfgDisabledOps" placeholder="SEARCH, WRITE_RANGE, 0x0A"></label>
+				<label class="small">expose token names (device picker)<br><select id="cfgExposeTokenNames"><option value="false">false</option><option value="true">true</option></select></label>
+				<label class="small">ECHO op (diagnostic)<br><select id="cfgEnableEcho"><option value="false">false</option><option value="true">true</option></select></label>
+				<label class="small">compress responses (deflate)<br><select id="cfgCompress"><option value="false">false</option><option value="true">true</option></select></label>
+				<label class="small">compress from payload size (bytes)<br><input id="cfgCompressMin" type="number" min="1" max="65535"></label>
+				<label class="small">CAPS as JSON (/wicos64/caps.json)<br><select id="cfgCapsJSON"><option value="false">false</option><option value="true">true</option></select></label>
+				<label class="small">usage note on GET to RPC endpoint<br><select id="cfgRPCMethodHelp"><option value="true">true</option><option value="false">false</option></select></label>
+				<label class="small">HTTP file access (/wicos64/files/)<br><select id="cfgHTTPFiles"><option value="false">false</option><option value="true">true</option></select></label>
+				<label class="small">JSON ops for scripts (/wicos64/jsonrpc)<br><select id="cfgJSONRPC"><option value="false">false</option><option value="true">true</option></select></label>
+				<label class="small">Prometheus metrics (/metrics)<br><select id="cfgMetrics"><option value="false">false</option><option value="true">true</option></select></label>
+				<label class="small">reload config on file change<br><select id="cfgConfigWatch"><option value="false">false</option><option value="true">true</option></select></label>
 				<label class="small">create recommended dirs<br><select id="cfgRecDirs"><option value="true">true</option><option value="false">false</option></select></label>
 				<label class="small">log requests<br><select id="cfgLogRequests"><option value="true">true</option><option value="false">false</option></select></label>
-				<label class="small">disk images (.D64/.D71/.D81)<br><select id="cfgDiskImages"><option value="true">true</option><option value="false">false</option></select></label>
+				<label class="small">audit log file (JSONL, empty=off)<br><input id="cfgAuditLogPath" placeholder="./logs/audit.jsonl"></label>
+				<label class="small">per-token audit dir (mutating ops, empty=off)<br><input id="cfgAuditLogDir" placeholder="./logs/audit-tokens"></label>
+				<label class="small">audit log rotate at (bytes, 0=never)<br><input id="cfgAuditLogMax" type="number" min="0"></label>
+				<label class="small">audit log rotated files kept<br><input id="cfgAuditLogKeep" type="number" min="0" max="20"></label>
+				<label class="small">disk images (.D64/.D71/.D81/.T64)<br><select id="cfgDiskImages"><option value="true">true</option><option value="false">false</option></select></label>
 				<label class="small">disk images writable (.D64/.D71/.D81)<br><select id="cfgDiskImagesWrite"><option value="false">false</option><option value="true">true</option></select></label>
+				<label class="small">.ZIP archives as read-only dirs<br><select id="cfgZipMount"><option value="false">false</option><option value="true">true</option></select></label>
 				<label class="small">disk images auto-resize (D81 subdirs)<br><select id="cfgDiskImagesAutoResize"><option value="false">false</option><option value="true">true</option></select></label>
+				<label class="small">detect image type by content<br><select id="cfgDiskImageDetect"><option value="false">false</option><option value="true">true</option></select></label>
+				<label class="small">log disk image ops (detailed)<br><select id="cfgLogDiskImageOps"><option value="false">false</option><option value="true">true</option></select></label>
+				<label class="small">image change index<br><select id="cfgImageChangeIndex"><option value="false">false</option><option value="true">true</option></select></label>
 			</div>
 		</details>
 
@@ -565,6 +640,7 @@ canvas { width: 100% !important; height: 200px !important; }
 					</select>
 				</label>
 				<label class="small">config token<br><input id="cfgBsToken" placeholder="CFG-1234"></label>
+				<label class="small">HMAC key (SIG= line, empty=off)<br><input id="cfgBsHMACKey" placeholder="(none)"></label>
 			</div>
 			<div style="height:6px"></div>
 			<div class="flex small">
@@ -595,6 +671,7 @@ canvas { width: 100% !important; height: 200px !important; }
 				<label class="small">UDP port<br><input id="cfgDiscPort" type="number" min="1" max="65535"></label>
 				<label class="small">LAN only<br><select id="cfgDiscLanOnly"><option value="true">true</option><option value="false">false</option></select></label>
 				<label class="small">rate limit (/sec, 0=off)<br><input id="cfgDiscRate" type="number" min="0"></label>
+				<label class="small">IPv6 (restart)<br><select id="cfgDiscIPv6"><option value="true">true</option><option value="false">false</option></select></label>
 			</div>
 			<div class="small" style="margin-top:6px; opacity:0.85;">
 				Listens on UDP port 6464 and answers WDP1 discovery packets so a C64 can find the HTTP bootstrap endpoint automatically.
@@ -608,16 +685,21 @@ canvas { width: 100% !important; height: 200px !important; }
 				<label class="small">tmp interval (sec)<br><input id="cfgTmpInt" type="number" min="0"></label>
 				<label class="small">tmp max age (sec)<br><input id="cfgTmpAge" type="number" min="0"></label>
 				<label class="small">tmp delete empty dirs<br><select id="cfgTmpEmpty"><option value="true">true</option><option value="false">false</option></select></label>
+				<label class="small">staged write timeout (sec)<br><input id="cfgStagedTimeout" type="number" min="60"></label>
 			</div>
 			<hr>
 			<div class="grid3">
 				<label class="small">trash enabled<br><select id="cfgTrash"><option value="true">true</option><option value="false">false</option></select></label>
 				<label class="small">trash dir<br><input id="cfgTrashDir" placeholder="./trash"></label>
+				<label class="small">trash exclude patterns (hard delete)<br><input id="cfgTrashExclude" placeholder="*.TMP, /CACHE/**"></label>
+				<label class="small">trash only patterns (empty = all)<br><input id="cfgTrashOnly" placeholder=""></label>
 				<label class="small">trash cleanup enabled<br><select id="cfgTrashCleanup"><option value="true">true</option><option value="false">false</option></select></label>
 				<label class="small">trash interval (sec)<br><input id="cfgTrashInt" type="number" min="0"></label>
 				<label class="small">trash max age (sec)<br><input id="cfgTrashAge" type="number" min="0"></label>
 				<label class="small">trash delete empty dirs<br><select id="cfgTrashEmpty"><option value="true">true</option><option value="false">false</option></select></label>
 			</div>
+			<hr>
+			<div class="small" id="maintStatus">-</div>
 		</details>
 
 		<details>
@@ -665,6 +747,14 @@ function fmtBytes(n){
   return s + ' ' + u[i];
 }
 
+function fmtDur(sec){
+  sec = Math.max(0, Math.floor(Number(sec) || 0));
+  if (sec >= 86400) return Math.floor(sec/86400) + 'd' + Math.floor((sec%86400)/3600) + 'h';
+  if (sec >= 3600) return Math.floor(sec/3600) + 'h' + Math.floor((sec%3600)/60) + 'm';
+  if (sec >= 60) return Math.floor(sec/60) + 'm' + (sec%60) + 's';
+  return sec + 's';
+}
+
 function maskTok(t){
   if (!t) return '';
   t = String(t);
@@ -750,6 +840,7 @@ function cfgSetBoolSel(id, v){
 }
 
 function cfgGetStr(id){ return (el(id).value || '').trim(); }
+function cfgGetList(id){ return String(el(id).value || '').split(',').map(function(x){ return x.trim(); }).filter(function(x){ return x !== ''; }); }
 function cfgGetNum(id){
   var s = (el(id).value || '').trim();
   if (s === '') return 0;
@@ -884,29 +975,61 @@ function cfgApplyObjToForm(obj){
 
     cfgSetVal('cfgListen', obj.listen);
     cfgSetVal('cfgEndpoint', obj.endpoint);
+    cfgSetVal('cfgTLSCert', obj.tls_cert_file || '');
+    cfgSetVal('cfgTLSKey', obj.tls_key_file || '');
+    cfgSetBoolSel('cfgTLSAuto', obj.tls_auto_self_signed === true);
     cfgSetVal('cfgBasePath', obj.base_path);
     cfgSetVal('cfgServerName', obj.server_name);
+    cfgSetVal('cfgMotd', obj.motd || '');
     cfgSetVal('cfgLegacyToken', obj.token);
 
     cfgSetVal('cfgMaxPayload', obj.max_payload);
     cfgSetVal('cfgMaxChunk', obj.max_chunk);
     cfgSetVal('cfgMaxEntries', obj.max_entries);
+    cfgSetBoolSel('cfgTruncOversized', obj.truncate_oversized !== false);
     cfgSetVal('cfgMaxPath', obj.max_path);
     cfgSetVal('cfgMaxName', obj.max_name);
     cfgSetVal('cfgGlobalMaxFile', obj.global_max_file_bytes);
+    cfgSetVal('cfgGlobalMaxFiles', obj.global_max_files);
+    cfgSetVal('cfgRateLimit', obj.rate_limit_per_sec || 0);
+    cfgSetVal('cfgMaxBps', obj.max_bytes_per_sec || 0);
     cfgSetVal('cfgGlobalQuota', obj.global_quota_bytes);
+    cfgSetBoolSel('cfgQuotaLogicalImages', obj.quota_logical_image_usage === true);
     cfgSetBoolSel('cfgGlobalReadOnly', obj.global_read_only);
 
     cfgSetBoolSel('cfgMkdirParents', obj.enable_mkdir_parents);
     cfgSetBoolSel('cfgRmdirRecursive', obj.enable_rmdir_recursive);
     cfgSetBoolSel('cfgCpRecursive', obj.enable_cp_recursive);
     cfgSetBoolSel('cfgOverwrite', obj.enable_overwrite);
+    cfgSetBoolSel('cfgOverwriteConfirm', obj.overwrite_confirm);
+    cfgSetVal('cfgOverwriteConfirmSec', obj.overwrite_confirm_sec);
+    cfgSetVal('cfgStripBOM', (obj.strip_bom_extensions || []).join(', '));
+    cfgSetVal('cfgDisabledOps', (obj.disabled_ops || []).join(', '));
     cfgSetBoolSel('cfgErrMsg', obj.enable_errmsg);
+    cfgSetBoolSel('cfgDebugShortReq', obj.debug_short_request === true);
+    cfgSetBoolSel('cfgExposeTokenNames', obj.expose_token_names === true);
+    cfgSetBoolSel('cfgEnableEcho', obj.enable_echo === true);
+    cfgSetBoolSel('cfgCompress', obj.compress_responses === true);
+    cfgSetVal('cfgCompressMin', obj.compress_min_bytes);
+    cfgSetBoolSel('cfgConfigWatch', obj.config_watch === true);
+    cfgSetBoolSel('cfgCapsJSON', obj.enable_caps_json === true);
+    cfgSetBoolSel('cfgHTTPFiles', obj.enable_http_files === true);
+    cfgSetBoolSel('cfgJSONRPC', obj.enable_jsonrpc === true);
+    cfgSetBoolSel('cfgRPCMethodHelp', obj.rpc_method_help !== false);
+    cfgSetBoolSel('cfgMetrics', obj.metrics_enabled === true);
     cfgSetBoolSel('cfgRecDirs', obj.create_recommended_dirs);
     cfgSetBoolSel('cfgLogRequests', obj.log_requests);
+    cfgSetVal('cfgAuditLogPath', obj.audit_log_path);
+    cfgSetVal('cfgAuditLogDir', obj.audit_log_dir);
+    cfgSetVal('cfgAuditLogMax', obj.audit_log_max_bytes);
+    cfgSetVal('cfgAuditLogKeep', obj.audit_log_keep);
 				cfgSetBoolSel('cfgDiskImages', obj.disk_images_enabled !== false);
 				cfgSetBoolSel('cfgDiskImagesWrite', obj.disk_images_write_enabled === true);
+				cfgSetBoolSel('cfgZipMount', obj.zip_mount_enabled === true);
 				cfgSetBoolSel('cfgDiskImagesAutoResize', obj.disk_images_auto_resize_enabled === true);
+				cfgSetBoolSel('cfgDiskImageDetect', obj.disk_image_detect_by_content === true);
+				cfgSetBoolSel('cfgLogDiskImageOps', obj.log_disk_image_ops === true);
+				cfgSetBoolSel('cfgImageChangeIndex', obj.image_change_index === true);
 
     cfgSetBoolSel('cfgEnableAdmin', obj.enable_admin_ui);
     cfgSetBoolSel('cfgAdminRemote', obj.admin_allow_remote);
@@ -920,6 +1043,7 @@ function cfgApplyObjToForm(obj){
     cfgSetBoolSel('cfgBsLanOnly', bs.lan_only);
     cfgSetVal('cfgBsToken', bs.token);
     cfgSetVal('cfgBsPolicy', bs.unknown_mac_policy || 'deny');
+    cfgSetVal('cfgBsHMACKey', bs.hmac_key);
     cfgBsClearRows();
     cfgBsExtraClearRows();
     if (bs.mac_tokens){
@@ -952,14 +1076,18 @@ function cfgApplyObjToForm(obj){
     cfgSetVal('cfgDiscPort', disc.udp_port);
     cfgSetBoolSel('cfgDiscLanOnly', disc.lan_only);
     cfgSetVal('cfgDiscRate', disc.rate_limit_per_sec);
+    cfgSetBoolSel('cfgDiscIPv6', disc.ipv6);
 
     cfgSetBoolSel('cfgTmpCleanup', obj.tmp_cleanup_enabled);
     cfgSetVal('cfgTmpInt', obj.tmp_cleanup_interval_sec);
     cfgSetVal('cfgTmpAge', obj.tmp_cleanup_max_age_sec);
     cfgSetBoolSel('cfgTmpEmpty', obj.tmp_cleanup_delete_empty_dirs);
+    cfgSetVal('cfgStagedTimeout', obj.staged_write_timeout_sec);
 
     cfgSetBoolSel('cfgTrash', obj.trash_enabled);
     cfgSetVal('cfgTrashDir', obj.trash_dir);
+    cfgSetVal('cfgTrashExclude', (obj.trash_exclude_patterns || []).join(', '));
+    cfgSetVal('cfgTrashOnly', (obj.trash_only_patterns || []).join(', '));
     cfgSetBoolSel('cfgTrashCleanup', obj.trash_cleanup_enabled);
     cfgSetVal('cfgTrashInt', obj.trash_cleanup_interval_sec);
     cfgSetVal('cfgTrashAge', obj.trash_cleanup_max_age_sec);
@@ -979,29 +1107,61 @@ function cfgApplyFormToObj(obj){
 
   obj.listen = cfgGetStr('cfgListen');
   obj.endpoint = cfgGetStr('cfgEndpoint');
+  obj.tls_cert_file = cfgGetStr('cfgTLSCert');
+  obj.tls_key_file = cfgGetStr('cfgTLSKey');
+  obj.tls_auto_self_signed = cfgGetBoolSel('cfgTLSAuto');
   obj.base_path = cfgGetStr('cfgBasePath');
   obj.server_name = cfgGetStr('cfgServerName');
+  obj.motd = cfgGetStr('cfgMotd');
   obj.token = cfgGetStr('cfgLegacyToken');
 
   obj.max_payload = cfgGetNum('cfgMaxPayload');
   obj.max_chunk = cfgGetNum('cfgMaxChunk');
   obj.max_entries = cfgGetNum('cfgMaxEntries');
+  obj.truncate_oversized = cfgGetBoolSel('cfgTruncOversized');
   obj.max_path = cfgGetNum('cfgMaxPath');
   obj.max_name = cfgGetNum('cfgMaxName');
   obj.global_max_file_bytes = cfgGetNum('cfgGlobalMaxFile');
+  obj.global_max_files = cfgGetNum('cfgGlobalMaxFiles');
+  obj.rate_limit_per_sec = parseFloat(el('cfgRateLimit').value || '0') || 0;
+  obj.max_bytes_per_sec = parseInt(el('cfgMaxBps').value || '0', 10) || 0;
   obj.global_quota_bytes = cfgGetNum('cfgGlobalQuota');
+  obj.quota_logical_image_usage = cfgGetBoolSel('cfgQuotaLogicalImages');
   obj.global_read_only = cfgGetBoolSel('cfgGlobalReadOnly');
 
   obj.enable_mkdir_parents = cfgGetBoolSel('cfgMkdirParents');
   obj.enable_rmdir_recursive = cfgGetBoolSel('cfgRmdirRecursive');
   obj.enable_cp_recursive = cfgGetBoolSel('cfgCpRecursive');
   obj.enable_overwrite = cfgGetBoolSel('cfgOverwrite');
+  obj.overwrite_confirm = cfgGetBoolSel('cfgOverwriteConfirm');
+  obj.overwrite_confirm_sec = cfgGetNum('cfgOverwriteConfirmSec');
+  obj.strip_bom_extensions = cfgGetList('cfgStripBOM');
+  obj.disabled_ops = cfgGetList('cfgDisabledOps');
   obj.enable_errmsg = cfgGetBoolSel('cfgErrMsg');
+  obj.debug_short_request = cfgGetBoolSel('cfgDebugShortReq');
+  obj.expose_token_names = cfgGetBoolSel('cfgExposeTokenNames');
+  obj.enable_echo = cfgGetBoolSel('cfgEnableEcho');
+  obj.compress_responses = cfgGetBoolSel('cfgCompress');
+  obj.compress_min_bytes = cfgGetNum('cfgCompressMin');
+  obj.config_watch = cfgGetBoolSel('cfgConfigWatch');
+  obj.enable_caps_json = cfgGetBoolSel('cfgCapsJSON');
+  obj.enable_http_files = cfgGetBoolSel('cfgHTTPFiles');
+  obj.enable_jsonrpc = cfgGetBoolSel('cfgJSONRPC');
+  obj.rpc_method_help = cfgGetBoolSel('cfgRPCMethodHelp');
+  obj.metrics_enabled = cfgGetBoolSel('cfgMetrics');
   obj.create_recommended_dirs = cfgGetBoolSel('cfgRecDirs');
   obj.log_requests = cfgGetBoolSel('cfgLogRequests');
+  obj.audit_log_path = cfgGetStr('cfgAuditLogPath');
+  obj.audit_log_dir = cfgGetStr('cfgAuditLogDir');
+  obj.audit_log_max_bytes = cfgGetNum('cfgAuditLogMax');
+  obj.audit_log_keep = cfgGetNum('cfgAuditLogKeep');
   obj.disk_images_enabled = cfgGetBoolSel('cfgDiskImages');
   obj.disk_images_write_enabled = cfgGetBoolSel('cfgDiskImagesWrite');
+  obj.zip_mount_enabled = cfgGetBoolSel('cfgZipMount');
   obj.disk_images_auto_resize_enabled = cfgGetBoolSel('cfgDiskImagesAutoResize');
+  obj.disk_image_detect_by_content = cfgGetBoolSel('cfgDiskImageDetect');
+  obj.log_disk_image_ops = cfgGetBoolSel('cfgLogDiskImageOps');
+  obj.image_change_index = cfgGetBoolSel('cfgImageChangeIndex');
 
   obj.enable_admin_ui = cfgGetBoolSel('cfgEnableAdmin');
   obj.admin_allow_remote = cfgGetBoolSel('cfgAdminRemote');
@@ -1015,6 +1175,7 @@ function cfgApplyFormToObj(obj){
   obj.bootstrap.lan_only = cfgGetBoolSel('cfgBsLanOnly');
   obj.bootstrap.token = cfgGetStr('cfgBsToken');
   obj.bootstrap.unknown_mac_policy = (el('cfgBsPolicy').value || 'deny');
+  obj.bootstrap.hmac_key = cfgGetStr('cfgBsHMACKey');
   obj.bootstrap.mac_tokens = cfgBsGetMap();
   obj.bootstrap.mac_extra = cfgBsExtraGetMap();
 
@@ -1023,14 +1184,18 @@ function cfgApplyFormToObj(obj){
   obj.discovery.udp_port = cfgGetNum('cfgDiscPort');
   obj.discovery.lan_only = cfgGetBoolSel('cfgDiscLanOnly');
   obj.discovery.rate_limit_per_sec = cfgGetNum('cfgDiscRate');
+  obj.discovery.ipv6 = cfgGetBoolSel('cfgDiscIPv6');
 
   obj.tmp_cleanup_enabled = cfgGetBoolSel('cfgTmpCleanup');
   obj.tmp_cleanup_interval_sec = cfgGetNum('cfgTmpInt');
   obj.tmp_cleanup_max_age_sec = cfgGetNum('cfgTmpAge');
   obj.tmp_cleanup_delete_empty_dirs = cfgGetBoolSel('cfgTmpEmpty');
+  obj.staged_write_timeout_sec = cfgGetNum('cfgStagedTimeout');
 
   obj.trash_enabled = cfgGetBoolSel('cfgTrash');
   obj.trash_dir = cfgGetStr('cfgTrashDir');
+  obj.trash_exclude_patterns = cfgGetList('cfgTrashExclude');
+  obj.trash_only_patterns = cfgGetList('cfgTrashOnly');
   obj.trash_cleanup_enabled = cfgGetBoolSel('cfgTrashCleanup');
   obj.trash_cleanup_interval_sec = cfgGetNum('cfgTrashInt');
   obj.trash_cleanup_max_age_sec = cfgGetNum('cfgTrashAge');
@@ -1140,14 +1305,28 @@ function tokRefresh(){
   sel.onchange = tokSelect;
 }
 
+function tokSplitList(v){
+  return String(v || '').split(/[\s,]+/).filter(function(x){ return x !== ''; });
+}
+
 function tokClearForm(){
   el('tokName').value = '';
   el('tokToken').value = '';
   el('tokRoot').value = '/';
   el('tokQuota').value = '0';
   el('tokMaxFile').value = '0';
+  el('tokMaxFiles').value = '0';
+  el('tokRateLimit').value = '0';
+  el('tokMaxBps').value = '0';
+  el('tokAllowCIDRs').value = '';
+  el('tokDenyCIDRs').value = '';
+  el('tokValidFrom').value = '0';
+  el('tokValidUntil').value = '0';
+  el('tokEndpoint').value = '';
+  el('tokPathPrefix').value = '';
   el('tokEnabled').checked = true;
   el('tokReadOnly').checked = false;
+  el('tokROWhenFull').checked = false;
 			el('tokDiskImages').value = '';
 			el('tokDiskImagesWrite').value = '';
   el('tokDiskImagesAutoResize').value = '';
@@ -1174,8 +1353,18 @@ function tokSelect(){
   el('tokRoot').value = t.root || '/';
   el('tokQuota').value = String(t.quota_bytes || 0);
   el('tokMaxFile').value = String(t.max_file_bytes || 0);
+  el('tokMaxFiles').value = String(t.max_files || 0);
+  el('tokRateLimit').value = String(t.rate_limit_per_sec || 0);
+  el('tokMaxBps').value = String(t.max_bytes_per_sec || 0);
+  el('tokAllowCIDRs').value = (t.allow_cidrs || []).join(', ');
+  el('tokDenyCIDRs').value = (t.deny_cidrs || []).join(', ');
+  el('tokValidFrom').value = String(t.valid_from_unix || 0);
+  el('tokValidUntil').value = String(t.valid_until_unix || 0);
+  el('tokEndpoint').value = t.endpoint || '';
+  el('tokPathPrefix').value = t.path_prefix || '';
   el('tokEnabled').checked = (t.enabled !== false);
   el('tokReadOnly').checked = (t.read_only === true);
+  el('tokROWhenFull').checked = (t.readonly_when_full === true);
 
   // Per-token disk image toggle (inherit / true / false)
   if (t.disk_images_enabled === true) el('tokDiskImages').value = 'true';
@@ -1238,6 +1427,25 @@ async function tokSave(){
     quota_bytes: parseInt(el('tokQuota').value || '0', 10) || 0,
     max_file_bytes: parseInt(el('tokMaxFile').value || '0', 10) || 0,
   };
+  var mf = parseInt(el('tokMaxFiles').value || '0', 10) || 0;
+  if (mf > 0) t.max_files = mf;
+  var rl = parseFloat(el('tokRateLimit').value || '0') || 0;
+  if (rl > 0) t.rate_limit_per_sec = rl;
+  var bw = parseInt(el('tokMaxBps').value || '0', 10) || 0;
+  if (bw > 0) t.max_bytes_per_sec = bw;
+  var ac = tokSplitList(el('tokAllowCIDRs').value);
+  if (ac.length) t.allow_cidrs = ac;
+  var dc = tokSplitList(el('tokDenyCIDRs').value);
+  if (dc.length) t.deny_cidrs = dc;
+  var vf = parseInt(el('tokValidFrom').value || '0', 10) || 0;
+  if (vf > 0) t.valid_from_unix = vf;
+  var vu = parseInt(el('tokValidUntil').value || '0', 10) || 0;
+  if (vu > 0) t.valid_until_unix = vu;
+  var ep = (el('tokEndpoint').value || '').trim();
+  if (ep) t.endpoint = ep;
+  var pp = (el('tokPathPrefix').value || '').trim();
+  if (pp) t.path_prefix = pp;
+  if (el('tokROWhenFull').checked) t.readonly_when_full = true;
 
   var di = el('tokDiskImages').value;
   if (di === 'true') t.disk_images_enabled = true;
@@ -1331,6 +1539,7 @@ var logEntries = [];
 var selectedLogID = null;
 var selectedLogEntry = null;
 var logEventSrc = null;
+var logWSFailed = false; // WebSocket never connected: stay on SSE
 
 function setStatus(msg, cls){
   var s = el('statusMsg');
@@ -1379,11 +1588,16 @@ async function jget(url){
   }
 }
 
+function csrfToken(){
+  var m = document.querySelector('meta[name="w64-csrf"]');
+  return m ? (m.getAttribute('content') || '') : '';
+}
+
 async function jpost(url, bodyObj){
   try{
     var res = await fetch(url, {
       method:'POST',
-      headers:{'Content-Type':'application/json'},
+      headers:{'Content-Type':'application/json', 'X-W64-CSRF': csrfToken()},
       body: bodyObj ? JSON.stringify(bodyObj) : '{}'
     });
     var txt = await res.text();
@@ -1490,6 +1704,16 @@ function renderTokens(data){
 
     var flags = [];
     if (t.read_only) flags.push('RO');
+    if (t.readonly_when_full) flags.push((t.quota_bytes && t.used_bytes >= t.quota_bytes) ? 'RO:FULL' : 'RO@FULL');
+    if (t.expired) flags.push('EXPIRED');
+    else if (t.pending) flags.push('PENDING');
+    else if (t.remaining_sec !== undefined) flags.push('VALID:' + fmtDur(t.remaining_sec));
+    if (t.allow_cidrs && t.allow_cidrs.length) flags.push('IP+:' + t.allow_cidrs.join(','));
+    if (t.deny_cidrs && t.deny_cidrs.length) flags.push('IP-:' + t.deny_cidrs.join(','));
+    if (t.endpoint) flags.push('EP:' + t.endpoint);
+    if (t.path_prefix) flags.push('HOME:' + t.path_prefix);
+    if (t.rate_limit_per_sec) flags.push('RATE:' + (t.rate_bucket !== undefined ? Math.floor(t.rate_bucket) + '/' : '') + t.rate_limit_per_sec + '/s');
+    if (t.max_bytes_per_sec) flags.push('BW:' + fmtBytes(t.max_bytes_per_sec) + '/s');
     if (t.ignored) flags.push('IGNORED');
     if (t.enabled === false) flags.push('DISABLED');
 
@@ -1514,6 +1738,7 @@ function renderTokens(data){
 
     var quotaCell = q;
     if (t.max_file_bytes) quotaCell += '<div class="small">max_file=' + fmtBytes(t.max_file_bytes) + '</div>';
+    if (t.max_files) quotaCell += '<div class="small">files=' + (t.used_files || 0) + '/' + t.max_files + '</div>';
 
     var usedCell = usedBadge + used;
     if (t.remaining_bytes !== undefined) usedCell += '<div class="small">left=' + fmtBytes(t.remaining_bytes) + '</div>';
@@ -1733,7 +1958,12 @@ function opsLineFromLog(e){
     case 0x0D: return 'ping';
     case 0x0F: return 'statfs ' + path;
     case 0x01: {
-      var line = 'ls ' + path;
+      var line = 'ls ';
+      ['SIZE', 'MTIME', 'TYPE'].forEach(function(k){
+        if(fset['SORT_' + k]) line += '--sort=' + k.toLowerCase() + ' ';
+      });
+      if(fset['REVERSE']) line += '-r ';
+      line += path;
       // only add range if present in info
       if(kv.start !== undefined || kv.max !== undefined){
         line += ' ' + start + ' ' + max;
@@ -1741,11 +1971,20 @@ function opsLineFromLog(e){
       return line;
     }
     case 0x02: return 'stat ' + path;
-    case 0x03: return 'read ' + path + ' ' + off + ' ' + len;
+    case 0x03: {
+      var opts = '';
+      if(fset['SC_LOWER']) opts += ' -l';
+      else if(fset['SCREENCODE']) opts += ' -s';
+      return 'read' + opts + ' ' + path + ' ' + off + ' ' + len;
+    }
     case 0x04: {
       var opts = '';
       if(fset['CREATE']) opts += ' -c';
       if(fset['TRUNCATE']) opts += ' -t';
+      if(fset['STRIP_BOM']) opts += ' -b';
+      if(fset['WANT_SIZE']) opts += ' -s';
+      if(fset['STAGED']) opts += ' --staged';
+      if(fset['COMMIT']) opts += ' --commit';
       return 'write' + opts + ' ' + path + ' ' + off;
     }
     case 0x05: {
@@ -1761,13 +2000,17 @@ function opsLineFromLog(e){
     case 0x07: {
       var opts = '';
       if(fset['RECURSIVE']) opts += ' -r';
+      if(fset['DRY_RUN']) opts += ' -n';
       return 'rmdir' + opts + ' ' + path;
     }
-    case 0x08: return 'rm ' + path;
+    case 0x08: return 'rm' + (fset['DRY_RUN'] ? ' -n' : '') + ' ' + path;
     case 0x09: {
       var opts = '';
       if(fset['OVERWRITE']) opts += ' -o';
       if(fset['RECURSIVE']) opts += ' -r';
+      if(fset['ASYNC']) opts += ' -a';
+      if(fset['CONVERT']) opts += ' -c';
+      if(fset['DRY_RUN']) opts += ' -n';
       if(!src) src = kv.from || kv.src_path || '';
       if(!dst) dst = kv.to || kv.dst_path || '';
       if(!src) src = '"/"';
@@ -1777,6 +2020,7 @@ function opsLineFromLog(e){
     case 0x0A: {
       var opts = '';
       if(fset['OVERWRITE']) opts += ' -o';
+      if(fset['DRY_RUN']) opts += ' -n';
       if(!src) src = kv.from || kv.src_path || '';
       if(!dst) dst = kv.to || kv.dst_path || '';
       if(!src) src = '"/"';
@@ -1784,11 +2028,14 @@ function opsLineFromLog(e){
       return 'mv' + opts + ' ' + src + ' ' + dst;
     }
     case 0x0B: {
-      // SEARCH flags: CI|RECURSIVE|WHOLE
+      // SEARCH flags: CI|RECURSIVE|WHOLE|REGEX|NAMES|IMAGES
       var sflags = 0;
       if(fset['CI'] || fset['CASE_INSENSITIVE']) sflags |= 1;
       if(fset['RECURSIVE']) sflags |= 2;
       if(fset['WHOLE'] || fset['WHOLE_WORD']) sflags |= 4;
+      if(fset['REGEX']) sflags |= 8;
+      if(fset['NAMES']) sflags |= 16;
+      if(fset['IMAGES']) sflags |= 32;
 
       var line = 'search ' + (kv.base || path) + ' ' + q;
       if(kv.start !== undefined || kv.max !== undefined || kv.scan !== undefined){
@@ -1801,13 +2048,57 @@ function opsLineFromLog(e){
     }
     case 0x0C: {
       var hflags = 0;
-      if((kv.algo||'').toUpperCase() === 'SHA1') hflags |= 1;
+      if((kv.algo||'').toUpperCase() === 'SHA256') hflags |= 2;
+      if((kv.algo||'').toUpperCase() === 'CRC16') hflags |= 4;
       var line = 'hash ' + path;
       if(hflags){
         line += ' -f ' + hexByte(hflags);
       }
       return line;
     }
+    case 0x10: return 'dirmtime ' + path;
+    case 0x11: return 'strings ' + path + ' ' + off;
+    case 0x12: {
+      var opts = '';
+      if(fset['FILES']) opts += ' -a';
+      return 'tree' + opts + ' ' + path;
+    }
+    case 0x13: return 'tail ' + path + ' ' + len;
+    case 0x14: return 'names ' + start + ' ' + max;
+    case 0x15: {
+      var opts = '';
+      if(fset['CREATE']) opts += ' -c';
+      return 'touch' + opts + ' ' + path + ' ' + (kv.mtime || '0');
+    }
+    case 0x16: return 'mktemp ' + (kv.prefix || '') + ' ' + (kv.suffix || '');
+    case 0x18: return 'echo ' + (parseInt(kv.delay || '0', 10) || 0);
+    case 0x19: return 'lock ' + path;
+    case 0x1A: return 'unlock' + (fset['FORCE'] ? ' -f' : '') + ' ' + path;
+    case 0x1B: {
+      var opts = '';
+      if(fset['CREATE']) opts += ' -c';
+      if(fset['TRUNCATE']) opts += ' -t';
+      if(fset['OVERWRITE']) opts += ' -o';
+      return 'copyrange' + opts + ' ' + src + ' ' + (kv.src_off || 0) + ' ' + dst + ' ' + (kv.dst_off || 0) + ' ' + len;
+    }
+    case 0x1C: return 'motd';
+    case 0x1D: return 'samefile ' + src + ' ' + dst;
+    case 0x1E: {
+      var opts = '';
+      if(fset['IMAGES']) opts += ' -i';
+      return 'lstree' + opts + ' ' + path + ' ' + (kv.depth || 0) + ' ' + start + ' ' + max;
+    }
+    case 0x1F: return 'imgchanges ' + path + ' ' + (kv.since || 0) + ' ' + start;
+    case 0x20: return 'exists ' + path;
+    case 0x21: return 'progress ' + (kv.id || 0);
+    case 0x22: return 'verify ' + path;
+    case 0x23: return 'trash-ls';
+    case 0x24: return 'dirhash' + (fset['RECURSIVE'] ? ' -r' : '') + ' ' + path;
+    case 0x25: return 'trash-restore' + (fset['OVERWRITE'] ? ' -o' : '') + ' ' + (kv.id || '') + (kv.to && kv.to !== '(original)' ? ' ' + kv.to : '');
+    case 0x26: return 'statmany ' + (kv.paths || path).split(',').filter(function(p){ return p && p !== '...'; }).join(' ');
+    case 0x27: return 'mkimage ' + (fset['OVERWRITE'] ? '-o ' : '') + path + ' ' + (kv.kind || 'd64').toLowerCase() + ' ' + (kv.name || '""') + ' ' + (kv.id || '""');
+    case 0x28: return 'whoami';
+    case 0x29: return 'images' + (fset['RECURSIVE'] ? ' -r' : '') + ' ' + path + ' ' + (kv.start || 0);
   }
 
   // Fallback: map by op_name if available
@@ -1892,41 +2183,63 @@ async function reloadLogs(){
 }
 
 function startLogStream(){
-  if (logEventSrc){ try{ logEventSrc.close(); } catch(e){} }
-  logEventSrc = new EventSource('/admin/stream/logs');
+  if (logEventSrc){ try{ logEventSrc.close(); } catch(e){} logEventSrc = null; }
 
-  logEventSrc.onmessage = function(ev){
+  // WebSocket first (some proxies buffer text/event-stream), SSE as fallback.
+  if (window.WebSocket && !logWSFailed){
+    var ws = null;
     try{
-      var e = JSON.parse(ev.data);
-      var qs = currentLogFilter();
-      var params = new URLSearchParams(qs);
-
-      if (params.get('errors')==='1' && e.status===0) return;
-      var op = params.get('op');
-      if (op){ var n = parseInt(op,16); if (e.op !== n) return; }
-      var ip = params.get('ip');
-      if (ip && String(e.remote_ip||'').indexOf(ip) === -1) return;
-      var q = params.get('q');
-      if (q){
-        var hay = String(e.info||'') + '\n' + String(e.req_preview||'') + '\n' + String(e.resp_preview||'');
-        if (hay.toLowerCase().indexOf(q.toLowerCase()) === -1) return;
-      }
-
-      logEntries.push(e);
-      var lim = parseInt(params.get('limit') || '300', 10);
-      while (logEntries.length > lim) logEntries.shift();
-      renderLogList();
-
-    } catch(err){
-      // ignore
+      ws = new WebSocket((location.protocol === 'https:' ? 'wss://' : 'ws://') + location.host + '/admin/stream/logs/ws');
+    } catch(e){ ws = null; }
+    if (ws){
+      var opened = false;
+      ws.onopen = function(){ opened = true; };
+      ws.onmessage = function(ev){ onLogStreamEntry(ev.data); };
+      ws.onclose = function(){
+        if (logEventSrc !== ws) return; // replaced by a restart
+        if (!opened) logWSFailed = true;
+        el('logMeta').textContent = 'stream: reconnecting…';
+        setTimeout(function(){ if (logEventSrc === ws) startLogStream(); }, opened ? 2000 : 0);
+      };
+      logEventSrc = ws;
+      return;
     }
-  };
+  }
 
+  logEventSrc = new EventSource('/admin/stream/logs');
+  logEventSrc.onmessage = function(ev){ onLogStreamEntry(ev.data); };
   logEventSrc.onerror = function(){
     el('logMeta').textContent = 'stream: reconnecting…';
   };
 }
 
+function onLogStreamEntry(data){
+  try{
+    var e = JSON.parse(data);
+    var qs = currentLogFilter();
+    var params = new URLSearchParams(qs);
+
+    if (params.get('errors')==='1' && e.status===0) return;
+    var op = params.get('op');
+    if (op){ var n = parseInt(op,16); if (e.op !== n) return; }
+    var ip = params.get('ip');
+    if (ip && String(e.remote_ip||'').indexOf(ip) === -1) return;
+    var q = params.get('q');
+    if (q){
+      var hay = String(e.info||'') + '\n' + String(e.req_preview||'') + '\n' + String(e.resp_preview||'');
+      if (hay.toLowerCase().indexOf(q.toLowerCase()) === -1) return;
+    }
+
+    logEntries.push(e);
+    var lim = parseInt(params.get('limit') || '300', 10);
+    while (logEntries.length > lim) logEntries.shift();
+    renderLogList();
+
+  } catch(err){
+    // ignore
+  }
+}
+
 // ---- OPS PLAYGROUND ------------------------------------------------------
 function opsSetTokens(data){
   var sel = el('opsToken');
@@ -2181,6 +2494,35 @@ async function actionCleanup(){
   setActionOut(r[1]);
   flash(r[0] ? 'cleanup done' : 'cleanup failed', r[0] ? 'good' : 'bad');
   await loadTokens();
+  await loadMaintenance();
+}
+
+async function actionTrashCleanup(){
+  setStatus('running trash cleanup…', 'warn');
+  toast('Running trash cleanup…', 'warn', 1200);
+  var r = await jpost('/admin/api/cleanup/trash', null);
+  setActionOut(r[1]);
+  flash(r[0] ? 'trash cleanup done' : 'trash cleanup failed', r[0] ? 'good' : 'bad');
+  await loadTokens();
+  await loadMaintenance();
+}
+
+async function loadMaintenance(){
+  var r = await jget('/admin/api/maintenance');
+  if (!r[0] || !r[1] || !r[1].tasks) return;
+  var now = r[1].ts_unix;
+  var names = {tmp_cleanup: 'tmp cleanup', trash_cleanup: 'trash cleanup'};
+  var lines = r[1].tasks.map(function(t){
+    var s = (names[t.name] || t.name) + ' (' + (t.enabled ? 'every ' + fmtDur(t.interval_sec) : 'disabled') + '): ';
+    var next = t.next_run_unix ? ', next in ' + fmtDur(Math.max(0, t.next_run_unix - now)) : '';
+    var lr = t.last_run;
+    if (!lr) return s + 'never run' + next;
+    s += 'last ' + fmtDur(now - lr.at_unix) + ' ago, freed ' + fmtBytes(lr.freed_bytes) +
+      ' (' + lr.deleted_files + ' files, ' + lr.deleted_dirs + ' dirs)';
+    if (lr.errors) s += ', ' + lr.errors + ' error(s): ' + lr.last_error;
+    return s + next;
+  });
+  el('maintStatus').textContent = lines.join(' | ');
 }
 
 async function actionSelfTest(){
@@ -2188,6 +2530,14 @@ async function actionSelfTest(){
   toast('Running self-test…', 'warn', 1200);
   var r = await jpost('/admin/api/selftest', null);
   setActionOut(r[1]);
+  var sum = (r[0] && r[1] && r[1].payload) ? r[1].payload.summary : null;
+  if (sum && (sum.failed || sum.tokens_failed)) {
+    var bad = (r[1].payload.tokens || []).filter(function(t){ return !t.ok; }).map(function(t){
+      return (t.name || t.token_mask) + ': ' + (t.failed_step || t.error || 'failed');
+    });
+    flash('self-test: ' + (sum.failed + sum.tokens_failed) + ' failure(s)' + (bad.length ? ' (' + bad.join('; ') + ')' : ''), 'bad');
+    return;
+  }
   flash(r[0] ? 'self-test done' : 'self-test failed', r[0] ? 'good' : 'bad');
 }
 
@@ -2214,6 +2564,10 @@ function actionLogsExport(){
   window.location = '/admin/api/logs/export?' + currentLogFilter();
 }
 
+function actionLogsExportAggregate(){
+  window.location = '/admin/api/logs/export?aggregate=1&format=csv&' + currentLogFilter();
+}
+
 async function boot(){
   initCharts();
   cfgInitForm();
@@ -2222,6 +2576,7 @@ async function boot(){
   await loadTokens();
   await loadStats();
   await reloadLogs();
+  await loadMaintenance();
   startLogStream();
 
   if(el("opsCmd")){
@@ -2245,6 +2600,7 @@ async function boot(){
   }
   setInterval(loadStats, 2000);
   setInterval(loadTokens, 10000);
+  setInterval(loadMaintenance, 10000);
 }
 
 boot();
@@ -2274,14 +2630,31 @@ func (s *Server) mountAdmin(mux *http.ServeMux) {
 	mux.HandleFunc(adminPath+"/api/reload", s.requireAdmin(s.handleAdminReload))
 	mux.HandleFunc(adminPath+"/api/shutdown", s.requireAdmin(s.handleAdminShutdown))
 	mux.HandleFunc(adminPath+"/api/cleanup/run", s.requireAdmin(s.handleAdminCleanupRun))
+	mux.HandleFunc(adminPath+"/api/cleanup/trash", s.requireAdmin(s.handleAdminCleanupTrash))
+	mux.HandleFunc(adminPath+"/api/maintenance", s.requireAdmin(s.handleAdminMaintenance))
 	mux.HandleFunc(adminPath+"/api/selftest", s.requireAdmin(s.handleAdminSelfTest))
 	mux.HandleFunc(adminPath+"/api/tokens", s.requireAdmin(s.handleAdminTokens))
+	mux.HandleFunc(adminPath+"/api/warnings", s.requireAdmin(s.handleAdminWarnings))
+	mux.HandleFunc(adminPath+"/api/imagelog", s.requireAdmin(s.handleAdminImageLog))
+	mux.HandleFunc(adminPath+"/api/csrf", s.requireAdmin(s.handleAdminCSRF))
 	mux.HandleFunc(adminPath+"/api/logs", s.requireAdmin(s.handleAdminLogs))
 	mux.HandleFunc(adminPath+"/api/logs/export", s.requireAdmin(s.handleAdminLogsExport))
 	mux.HandleFunc(adminPath+"/api/ops/run", s.requireAdmin(s.handleAdminOpsRun))
+	mux.HandleFunc(adminPath+"/api/ops", s.requireAdmin(s.handleAdminOps))
+	mux.HandleFunc(adminPath+"/api/fs/list", s.requireAdmin(s.handleAdminFSList))
+	mux.HandleFunc(adminPath+"/api/fs/read", s.requireAdmin(s.handleAdminFSRead))
+	mux.HandleFunc(adminPath+"/api/fs/delete", s.requireAdmin(s.handleAdminFSDelete))
+	mux.HandleFunc(adminPath+"/api/fs/rename", s.requireAdmin(s.handleAdminFSRename))
+	mux.HandleFunc(adminPath+"/api/images", s.requireAdmin(s.handleAdminImages))
+	mux.HandleFunc(adminPath+"/api/images/create", s.requireAdmin(s.handleAdminImageCreate))
+	mux.HandleFunc(adminPath+"/api/images/upload", s.requireAdmin(s.handleAdminImageUpload))
+	mux.HandleFunc(adminPath+"/api/images/download", s.requireAdmin(s.handleAdminImageDownload))
+	mux.HandleFunc(adminPath+"/api/trash", s.requireAdmin(s.handleAdminTrashList))
+	mux.HandleFunc(adminPath+"/api/trash/restore", s.requireAdmin(s.handleAdminTrashRestore))
 	mux.HandleFunc(adminPath+"/api/logs/clear", s.requireAdmin(s.handleAdminLogsClear))
 	// Stream.
 	mux.HandleFunc(adminPath+"/stream/logs", s.requireAdmin(s.handleAdminLogStream))
+	mux.HandleFunc(adminPath+"/stream/logs/ws", s.requireAdmin(s.handleAdminLogStreamWS))
 }
 
 func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
@@ -2291,40 +2664,57 @@ func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
-		if !cfg.AdminAllowRemote {
-			ip := clientIP(r)
-			if ip == "" {
-				w.WriteHeader(http.StatusForbidden)
-				return
-			}
-			parsed := net.ParseIP(ip)
-			if parsed == nil || !parsed.IsLoopback() {
-				w.WriteHeader(http.StatusForbidden)
-				_, _ = w.Write([]byte("admin UI is localhost-only by default\n"))
-				return
-			}
+		if !adminAccessAllowed(cfg, w, r) {
+			return
 		}
-		// Optional basic auth.
-		if cfg.AdminPassword != "" {
-			u, p, ok := r.BasicAuth()
-			if !ok || u != cfg.AdminUser || p != cfg.AdminPassword {
-				w.Header().Set("WWW-Authenticate", `Basic realm="WiCOS64 Admin"`)
-				w.WriteHeader(http.StatusUnauthorized)
-				return
-			}
+		// CSRF: every state-changing request must echo the token issued with the
+		// admin page (or fetched via GET /admin/api/csrf). Safe methods are unaffected.
+		if cfg.AdminCSRFEnabled && !isSafeHTTPMethod(r.Method) && !s.checkAdminCSRF(r) {
+			writeJSON(w, http.StatusForbidden, adminOKResponse{OK: false, Build: version.Get().String(), TSUnix: time.Now().Unix(), Message: "missing or invalid CSRF token (reload the admin page)"})
+			return
 		}
 		next(w, r)
 	}
 }
 
+// adminAccessAllowed applies the admin access rules (localhost-only unless
+// admin_allow_remote, optional BasicAuth). On failure it writes the response
+// and returns false.
+func adminAccessAllowed(cfg config.Config, w http.ResponseWriter, r *http.Request) bool {
+	if !cfg.AdminAllowRemote {
+		ip := clientIP(cfg, r)
+		if ip == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return false
+		}
+		parsed := net.ParseIP(ip)
+		if parsed == nil || !parsed.IsLoopback() {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte("admin UI is localhost-only by default\n"))
+			return false
+		}
+	}
+	// Optional basic auth.
+	if cfg.AdminPassword != "" {
+		u, p, ok := r.BasicAuth()
+		if !ok || u != cfg.AdminUser || p != cfg.AdminPassword {
+			w.Header().Set("WWW-Authenticate", `Basic realm="WiCOS64 Admin"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return false
+		}
+	}
+	return true
+}
+
 func (s *Server) handleAdminIndex(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != adminPath {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
 	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte(adminHTML))
+	_, _ = w.Write([]byte(strings.Replace(adminHTML, "{{CSRF_TOKEN}}", s.adminCSRF, 1)))
 }
 
 func (s *Server) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
@@ -2337,8 +2727,9 @@ func (s *Server) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
 		w.WriteHeader(http.StatusOK)
 		// Build an example URL that includes the listen port (useful when Listen is ":8080").
+		apiListen := cfg.ListenAddr(config.ServeAPI)
 		apiHostPort := "127.0.0.1"
-		if host, port, err := net.SplitHostPort(cfg.Listen); err == nil {
+		if host, port, err := net.SplitHostPort(apiListen); err == nil {
 			// If Listen binds to all interfaces, still show a localhost example.
 			if host != "" && host != "0.0.0.0" && host != "::" {
 				apiHostPort = host
@@ -2346,7 +2737,7 @@ func (s *Server) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
 			apiHostPort = apiHostPort + ":" + port
 		} else {
 			// Fallback: append Listen as-is.
-			apiHostPort = apiHostPort + cfg.Listen
+			apiHostPort = apiHostPort + apiListen
 		}
 		_ = json.NewEncoder(w).Encode(map[string]any{
 			"build":           version.Get(),
@@ -2382,6 +2773,7 @@ func (s *Server) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
 		// Update runtime config, but keep current listen/endpoint routing to avoid confusion.
 		runtime := posted
 		runtime.Listen = cfg.Listen
+		runtime.Listeners = cfg.Listeners
 		runtime.Endpoint = cfg.Endpoint
 		s.setCfg(runtime)
 		// Save to disk (as posted, so listen/endpoint changes are persisted for next restart).
@@ -2390,12 +2782,14 @@ func (s *Server) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
 			_, _ = w.Write([]byte("failed to save: " + err.Error() + "\n"))
 			return
 		}
-		restartRequired := posted.Listen != cfg.Listen || posted.Endpoint != cfg.Endpoint
+		// Our own save is already applied; keep config_watch from reloading it.
+		s.markConfigSeen()
+		restartRequired := posted.Listen != cfg.Listen || posted.Endpoint != cfg.Endpoint || !sameListeners(posted.Listeners, cfg.Listeners)
 		resp := map[string]any{
 			"ok":               true,
 			"warnings":         configWarnings(runtime),
 			"restart_required": restartRequired,
-			"note":             "Saved. Restart required for listen/endpoint changes.",
+			"note":             "Saved. Restart required for listen/listeners/endpoint changes.",
 		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -2407,6 +2801,20 @@ func (s *Server) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// sameListeners reports whether two listeners configs bind the same
+// addresses with the same handler groups.
+func sameListeners(a, b []config.ListenerConfig) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Addr != b[i].Addr || strings.Join(a[i].Serve, ",") != strings.Join(b[i].Serve, ",") {
+			return false
+		}
+	}
+	return true
+}
+
 func saveConfigJSON(path string, cfg config.Config) error {
 	b, err := json.MarshalIndent(cfg, "", "  ")
 	if err != nil {
@@ -2546,6 +2954,9 @@ func configWarnings(cfg config.Config) []string {
 	if cfg.AdminAllowRemote && cfg.AdminPassword == "" {
 		w = append(w, "admin_allow_remote=true without admin_password – consider enabling BasicAuth")
 	}
+	if cfg.AdminAllowRemote && !cfg.TLSEnabled() {
+		w = append(w, "admin_allow_remote=true without TLS – admin credentials and tokens travel in cleartext")
+	}
 	if cfg.TmpCleanupEnabled {
 		if cfg.TmpCleanupIntervalSec <= 0 {
 			w = append(w, "tmp_cleanup_interval_sec is <= 0 (cleanup will fallback to 900s)")
@@ -2567,6 +2978,12 @@ func configWarnings(cfg config.Config) []string {
 	if cfg.TrashCleanupEnabled && !cfg.TrashEnabled {
 		w = append(w, "trash_cleanup_enabled=true but trash_enabled=false (cleanup will do nothing)")
 	}
+	now := time.Now().Unix()
+	for _, t := range cfg.Tokens {
+		if strings.TrimSpace(t.Token) != "" && config.TokenExpired(t.ValidUntilUnix, now) {
+			w = append(w, fmt.Sprintf("token %q (%s) expired at %s – remove it or extend valid_until_unix", t.Name, maskToken(t.Token), time.Unix(t.ValidUntilUnix, 0).UTC().Format(time.RFC3339)))
+		}
+	}
 
 	if cfg.Bootstrap.Enabled {
 		if strings.TrimSpace(cfg.Bootstrap.Token) == "" {
@@ -2582,6 +2999,7 @@ func configWarnings(cfg config.Config) []string {
 			w = append(w, "bootstrap enabled but no bootstrap.mac_tokens configured")
 		}
 	}
+	w = append(w, disabledOpsWarnings(cfg)...)
 	return w
 }
 
@@ -2609,6 +3027,11 @@ func (s *Server) handleAdminLogsExport(w http.ResponseWriter, r *http.Request) {
 		format = "jsonl"
 	}
 	entries := s.logs.filteredSnapshot(f)
+	if r.URL.Query().Get("aggregate") == "1" {
+		// Compact per-minute export (csv or jsonl) instead of raw entries.
+		writeAggregatedLogs(w, entries, format)
+		return
+	}
 	if format == "text" || format == "txt" {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		w.Header().Set("Content-Disposition", "attachment; filename=logs.txt")
@@ -2648,6 +3071,35 @@ func (s *Server) handleAdminLogStream(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write([]byte(": ok\n\n"))
 	fl.Flush()
 
+	writeEntry := func(e LogEntry) {
+		// One event per entry.
+		// NOTE: Use JSON per line to keep it simple.
+		_, _ = w.Write([]byte("data: "))
+		_, _ = w.Write(e.jsonLine())
+		_, _ = w.Write([]byte("\n\n"))
+	}
+
+	batch := time.Duration(s.cfgSnapshot().AdminStreamBatchMs) * time.Millisecond
+	if batch <= 0 {
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case e, ok := <-ch:
+				if !ok {
+					return
+				}
+				writeEntry(e)
+				fl.Flush()
+			}
+		}
+	}
+
+	// Batched mode: collect entries and flush them together once per tick.
+	// The events stay individual, so the UI does not need to know about it.
+	tick := time.NewTicker(batch)
+	defer tick.Stop()
+	pending := 0
 	for {
 		select {
 		case <-r.Context().Done():
@@ -2656,25 +3108,53 @@ func (s *Server) handleAdminLogStream(w http.ResponseWriter, r *http.Request) {
 			if !ok {
 				return
 			}
-			// One event per entry.
-			// NOTE: Use JSON per line to keep it simple.
-			_, _ = w.Write([]byte("data: "))
-			_, _ = w.Write(e.jsonLine())
-			_, _ = w.Write([]byte("\n\n"))
-			fl.Flush()
+			writeEntry(e)
+			pending++
+		case <-tick.C:
+			if pending > 0 {
+				fl.Flush()
+				pending = 0
+			}
 		}
 	}
 }
 
-func clientIP(r *http.Request) string {
-	// We deliberately do not trust X-Forwarded-For here.
-	// If you want admin access behind a reverse proxy, set AdminAllowRemote=true
-	// and protect with AdminPassword.
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err == nil {
-		return host
+// clientIP returns the client address of r: the TCP peer, or, when the peer
+// is one of cfg.TrustedProxies, the right-most X-Forwarded-For hop that is
+// not a trusted proxy (X-Real-IP if there is no X-Forwarded-For). Without
+// trusted_proxies the headers are ignored, so they cannot be spoofed.
+func clientIP(cfg config.Config, r *http.Request) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = host
+	}
+	peer := net.ParseIP(ip)
+	if peer == nil || !cfg.TrustedProxy(peer) {
+		return ip
+	}
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	if len(hops) == 0 {
+		if rip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); rip != nil {
+			return rip.String()
+		}
+		return ip
+	}
+	// Walk from the proxy towards the client; stop at the first hop that is
+	// not a trusted proxy. A malformed hop ends the walk at the last good one.
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop.String()
+		if !cfg.TrustedProxy(hop) {
+			break
+		}
 	}
-	return r.RemoteAddr
+	return ip
 }
 
 func (s *Server) handleAdminChartJS(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"mime"
+	"net/http"
+	"time"
+
+	"wicos64-server/internal/version"
+)
+
+// adminCSRFHeader carries the CSRF token on mutating admin requests.
+const adminCSRFHeader = "X-W64-CSRF"
+
+func newAdminCSRFToken() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// Extremely unlikely; fall back to a time-based value (still unguessable
+		// enough for a drive-by page that cannot read our responses).
+		return hex.EncodeToString([]byte(time.Now().Format(time.RFC3339Nano)))
+	}
+	return hex.EncodeToString(b[:])
+}
+
+func isSafeHTTPMethod(m string) bool {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+// checkAdminCSRF accepts the token via header, or via a "csrf_token" form field
+// for plain form posts. JSON bodies are never consumed here.
+func (s *Server) checkAdminCSRF(r *http.Request) bool {
+	got := r.Header.Get(adminCSRFHeader)
+	if got == "" {
+		ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if ct == "application/x-www-form-urlencoded" || ct == "multipart/form-data" {
+			got = r.FormValue("csrf_token")
+		}
+	}
+	if got == "" || s.adminCSRF == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(s.adminCSRF)) == 1
+}
+
+// handleAdminCSRF returns the CSRF token for non-browser admin clients (e.g. the
+// tray controller). Cross-origin pages cannot read this response.
+func (s *Server) handleAdminCSRF(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]any{
+		"ok":       true,
+		"build":    version.Get().String(),
+		"ts_unix":  time.Now().Unix(),
+		"header":   adminCSRFHeader,
+		"token":    s.adminCSRF,
+		"required": s.cfgSnapshot().AdminCSRFEnabled,
+	})
+}
@@ -3,10 +3,9 @@ package server
 import (
 	"encoding/json"
 	"net/http"
-	"os"
 	"time"
 
-	"wicos64-server/internal/config"
+	"wicos64-server/internal/diskimage"
 	"wicos64-server/internal/version"
 )
 
@@ -36,7 +35,31 @@ func (s *Server) handleAdminStats(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusOK, StatsSnapshot{StartedUnix: time.Now().Unix(), NowUnix: time.Now().Unix()})
 		return
 	}
-	writeJSON(w, http.StatusOK, s.stats.snapshot())
+	snap := s.stats.snapshot()
+	snap.ImageParsesInFlight = diskimage.ParsesInFlight()
+	writeJSON(w, http.StatusOK, snap)
+}
+
+type adminWarningsResponse struct {
+	OK       bool     `json:"ok"`
+	Build    string   `json:"build"`
+	TSUnix   int64    `json:"ts_unix"`
+	Count    int      `json:"count"`
+	Warnings []string `json:"warnings"`
+}
+
+// handleAdminWarnings exposes the active configuration warnings (same list the
+// dashboard shows) so external monitoring can alert without scraping HTML.
+func (s *Server) handleAdminWarnings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	warn := configWarnings(s.cfgSnapshot())
+	if warn == nil {
+		warn = []string{}
+	}
+	writeJSON(w, http.StatusOK, adminWarningsResponse{OK: true, Build: version.Get().String(), TSUnix: time.Now().Unix(), Count: len(warn), Warnings: warn})
 }
 
 func (s *Server) handleAdminStatsReset(w http.ResponseWriter, r *http.Request) {
@@ -60,22 +83,19 @@ func (s *Server) handleAdminReload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	newCfg, err := config.Load(s.cfgPath)
+	// Keeps network settings stable during a soft reload.
+	newCfg, err := s.reloadConfigFile()
 	if err != nil {
 		writeJSON(w, http.StatusBadRequest, adminOKResponse{OK: false, Build: version.Get().String(), TSUnix: time.Now().Unix(), Message: err.Error()})
 		return
 	}
-	// Keep network settings stable during a soft reload.
-	cur := s.cfgSnapshot()
-	newCfg.Listen = cur.Listen
-	newCfg.Endpoint = cur.Endpoint
-
-	s.setCfg(newCfg)
+	s.markConfigSeen()
 
 	writeJSON(w, http.StatusOK, adminOKResponse{OK: true, Build: version.Get().String(), TSUnix: time.Now().Unix(), Message: "config reloaded", Payload: newCfg, Warnings: configWarnings(newCfg)})
 }
 
-// handleAdminShutdown terminates the server process.
+// handleAdminShutdown terminates the server process. The process shuts down
+// like on SIGTERM (see ShutdownRequested): in-flight requests are drained.
 //
 // This is primarily used by the Windows tray controller to stop the server
 // even if it was started outside of the tray.
@@ -89,11 +109,7 @@ func (s *Server) handleAdminShutdown(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	writeJSON(w, http.StatusOK, adminOKResponse{OK: true, Build: version.Get().String(), TSUnix: time.Now().Unix(), Message: "shutting down"})
-	go func() {
-		// Give the HTTP response a moment to be sent.
-		time.Sleep(200 * time.Millisecond)
-		os.Exit(0)
-	}()
+	s.requestShutdown()
 }
 
 func (s *Server) handleAdminCleanupRun(w http.ResponseWriter, r *http.Request) {
@@ -156,6 +172,40 @@ func (s *Server) handleAdminCleanupRun(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, adminOKResponse{OK: true, Build: version.Get().String(), TSUnix: time.Now().Unix(), Message: "cleanup done", Payload: payload})
 }
 
+// handleAdminCleanupTrash runs the trash cleanup now (same code as the
+// maintenance loop) and reports what it removed.
+func (s *Server) handleAdminCleanupTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := s.cfgSnapshot()
+	if !cfg.TrashEnabled || !cfg.TrashCleanupEnabled {
+		writeJSON(w, http.StatusConflict, adminOKResponse{OK: false, Build: version.Get().String(), TSUnix: time.Now().Unix(), Message: "trash cleanup is disabled (trash_enabled / trash_cleanup_enabled)"})
+		return
+	}
+	start := time.Now()
+	reps := s.runTrashCleanupOnce(cfg)
+	var files, dirs int
+	var freed uint64
+	for _, rep := range reps {
+		files += rep.DeletedFiles
+		dirs += rep.DeletedDirs
+		freed += rep.FreedBytes
+	}
+	payload := map[string]any{
+		"reports": reps,
+		"summary": map[string]any{
+			"roots":         len(reps),
+			"deleted_files": files,
+			"deleted_dirs":  dirs,
+			"freed_bytes":   freed,
+			"duration_ms":   time.Since(start).Milliseconds(),
+		},
+	}
+	writeJSON(w, http.StatusOK, adminOKResponse{OK: true, Build: version.Get().String(), TSUnix: time.Now().Unix(), Message: "trash cleanup done", Payload: payload})
+}
+
 func (s *Server) handleAdminSelfTest(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -170,13 +220,24 @@ func (s *Server) handleAdminSelfTest(w http.ResponseWriter, r *http.Request) {
 			ok++
 		}
 	}
+	toks := s.runTokenSelfTests(cfg)
+	tokOK := 0
+	for _, rep := range toks {
+		if rep.OK {
+			tokOK++
+		}
+	}
 	payload := map[string]any{
 		"reports": reps,
+		"tokens":  toks,
 		"summary": map[string]any{
-			"roots":       len(reps),
-			"ok":          ok,
-			"failed":      len(reps) - ok,
-			"duration_ms": time.Since(start).Milliseconds(),
+			"roots":         len(reps),
+			"ok":            ok,
+			"failed":        len(reps) - ok,
+			"tokens":        len(toks),
+			"tokens_ok":     tokOK,
+			"tokens_failed": len(toks) - tokOK,
+			"duration_ms":   time.Since(start).Milliseconds(),
 		},
 	}
 	writeJSON(w, http.StatusOK, adminOKResponse{OK: true, Build: version.Get().String(), TSUnix: time.Now().Unix(), Message: "selftest done", Payload: payload})
@@ -0,0 +1,363 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+// Admin file manager (used by the Admin UI only).
+//
+// The endpoints act on the root of one token (token_kind/token_id as in the
+// ops playground) and run the normal W64F ops through dispatch, so the
+// sandbox, path locks, read-only and quota rules are exactly those of the
+// RPC endpoint. Changes show up in the Live Log as "admin fs" (reads are
+// not logged: a read of a large file is hundreds of READ_RANGE chunks).
+//
+//	GET  /admin/api/fs/list?token_kind=&token_id=&path=
+//	GET  /admin/api/fs/read?token_kind=&token_id=&path=[&max=]   (raw bytes)
+//	POST /admin/api/fs/delete {token_kind, token_id, path, recursive}
+//	POST /admin/api/fs/rename {token_kind, token_id, from, to, overwrite}
+
+// adminFSReadMax caps the bytes returned by /admin/api/fs/read.
+const adminFSReadMax = 4 * 1024 * 1024
+
+type adminFSEntry struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"` // file|dir
+	Size      uint32 `json:"size"`
+	MTimeUnix uint32 `json:"mtime_unix,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"` // name cut to max_name
+}
+
+type adminFSListResponse struct {
+	OK      bool           `json:"ok"`
+	Path    string         `json:"path"`
+	Entries []adminFSEntry `json:"entries"`
+}
+
+type adminFSRequest struct {
+	TokenKind string `json:"token_kind"`
+	TokenID   string `json:"token_id"`
+
+	Path      string `json:"path"`
+	Recursive bool   `json:"recursive"`
+
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Overwrite bool   `json:"overwrite"`
+}
+
+// adminFSTarget is a resolved token root for the file manager.
+type adminFSTarget struct {
+	cfg     config.Config
+	limits  Limits
+	rootAbs string
+	name    string
+}
+
+func (s *Server) adminFSResolve(kind, id string) (adminFSTarget, int, string) {
+	cfg := s.cfgSnapshot()
+	token, ok := resolveTokenByRef(&cfg, kind, id)
+	if !ok {
+		return adminFSTarget{}, http.StatusBadRequest, "unknown token context"
+	}
+	ctx, ok := cfg.ResolveTokenContext(token)
+	if !ok {
+		return adminFSTarget{}, http.StatusBadRequest, "token not active"
+	}
+	if msg := tokenWindowError(ctx, time.Now()); msg != "" {
+		return adminFSTarget{}, http.StatusBadRequest, msg
+	}
+	rootAbs, err := filepath.Abs(ctx.Root)
+	if err != nil {
+		return adminFSTarget{}, http.StatusInternalServerError, "bad root"
+	}
+	if err := config.EnsureRoot(rootAbs); err != nil {
+		return adminFSTarget{}, http.StatusInternalServerError, "cannot create root"
+	}
+	limits := limitsFromContext(ctx)
+	limits.tokenID = tokenID(token)
+	limits.tokenName = ctx.Name
+	name := ctx.Name
+	if name == "" {
+		name = "token_id=" + tokenID(token)
+	}
+	return adminFSTarget{cfg: cfg, limits: limits, rootAbs: rootAbs, name: name}, 0, ""
+}
+
+// adminFSRun dispatches one op for the file manager; write ops are recorded
+// in the Live Log.
+func (s *Server) adminFSRun(t adminFSTarget, op, flags byte, payload []byte) (byte, []byte, string) {
+	t0 := time.Now()
+	status, resp, errMsg := s.dispatch(t.cfg, t.limits, op, flags, payload, t.rootAbs)
+	if !isWriteOp(op) {
+		return status, resp, errMsg
+	}
+	s.record(t.cfg, LogEntry{
+		TimeUnixMs:  time.Now().UnixMilli(),
+		RemoteIP:    "ADMIN",
+		Op:          op,
+		OpName:      opName(op),
+		Status:      status,
+		StatusName:  statusName(status),
+		ReqBytes:    len(payload),
+		RespBytes:   len(resp),
+		DurationMs:  time.Since(t0).Milliseconds(),
+		HTTPStatus:  200,
+		Info:        "admin fs " + t.name,
+		ReqPreview:  summarizeRequest(t.cfg, op, flags, payload),
+		RespPreview: opsPretty(op, status, resp, errMsg),
+	})
+	return status, resp, errMsg
+}
+
+// writeAdminFSError answers a failed op with a JSON error and an HTTP status
+// that matches the W64F status.
+func writeAdminFSError(w http.ResponseWriter, status byte, msg string) {
+	code := http.StatusInternalServerError
+	switch status {
+	case proto.StatusNotFound:
+		code = http.StatusNotFound
+	case proto.StatusAccessDenied, proto.StatusQuotaFull:
+		code = http.StatusForbidden
+	case proto.StatusAlreadyExists, proto.StatusDirNotEmpty, proto.StatusBusy:
+		code = http.StatusConflict
+	case proto.StatusTooLarge:
+		code = http.StatusRequestEntityTooLarge
+	case proto.StatusNotADir, proto.StatusIsADir, proto.StatusInvalidPath, proto.StatusRangeInvalid, proto.StatusBadRequest, proto.StatusNotSupported:
+		code = http.StatusBadRequest
+	}
+	if msg == "" {
+		msg = statusName(status)
+	}
+	writeJSON(w, code, map[string]any{"ok": false, "status": statusName(status), "error": msg})
+}
+
+// adminFSStat runs STAT and returns (isDir, size).
+func (s *Server) adminFSStat(t adminFSTarget, p string) (bool, uint32, byte, string) {
+	e := proto.NewEncoder(len(p) + 2)
+	if err := e.WriteString(p); err != nil {
+		return false, 0, proto.StatusInvalidPath, err.Error()
+	}
+	st, resp, msg := s.adminFSRun(t, proto.OpSTAT, 0, e.Bytes())
+	if st != proto.StatusOK {
+		return false, 0, st, msg
+	}
+	d := proto.NewDecoder(resp)
+	typ, _ := d.ReadU8()
+	size, err := d.ReadU32()
+	if err != nil {
+		return false, 0, proto.StatusInternal, "short STAT response"
+	}
+	return typ == 1, size, proto.StatusOK, ""
+}
+
+func (s *Server) handleAdminFSList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	t, code, msg := s.adminFSResolve(q.Get("token_kind"), q.Get("token_id"))
+	if code != 0 {
+		http.Error(w, msg, code)
+		return
+	}
+	p := q.Get("path")
+	if p == "" {
+		p = "/"
+	}
+
+	entries, st, errMsg := s.adminFSList(t, p)
+	if st != proto.StatusOK {
+		writeAdminFSError(w, st, errMsg)
+		return
+	}
+	writeJSON(w, http.StatusOK, adminFSListResponse{OK: true, Path: p, Entries: entries})
+}
+
+// adminFSList pages through LS and returns the whole directory p.
+func (s *Server) adminFSList(t adminFSTarget, p string) ([]adminFSEntry, byte, string) {
+	entries := []adminFSEntry{}
+	start := uint16(0)
+	for {
+		e := proto.NewEncoder(len(p) + 6)
+		if err := e.WriteString(p); err != nil {
+			return nil, proto.StatusInvalidPath, err.Error()
+		}
+		e.WriteU16(start)
+		e.WriteU16(0)
+		st, resp, errMsg := s.adminFSRun(t, proto.OpLS, 0, e.Bytes())
+		if st != proto.StatusOK {
+			return nil, st, errMsg
+		}
+		d := proto.NewDecoder(resp)
+		count, _ := d.ReadU16()
+		for i := 0; i < int(count); i++ {
+			typ, _ := d.ReadU8()
+			size, _ := d.ReadU32()
+			mtime, _ := d.ReadU32()
+			name, err := d.ReadString(0xFFFF)
+			if err != nil {
+				return nil, proto.StatusInternal, "short LS response"
+			}
+			ent := adminFSEntry{Name: name, Type: "file", Size: size, MTimeUnix: mtime, Truncated: typ&proto.LSEntryTruncated != 0}
+			if typ&^proto.LSEntryTruncated == 1 {
+				ent.Type = "dir"
+			}
+			entries = append(entries, ent)
+		}
+		next, err := d.ReadU16()
+		if err != nil {
+			return nil, proto.StatusInternal, "short LS response"
+		}
+		if next == 0xFFFF || next <= start {
+			return entries, proto.StatusOK, ""
+		}
+		start = next
+	}
+}
+
+func (s *Server) handleAdminFSRead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	t, code, msg := s.adminFSResolve(q.Get("token_kind"), q.Get("token_id"))
+	if code != 0 {
+		http.Error(w, msg, code)
+		return
+	}
+	p := q.Get("path")
+	limit := uint32(adminFSReadMax)
+	if v := q.Get("max"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			http.Error(w, "invalid max", http.StatusBadRequest)
+			return
+		}
+		limit = uint32(min(n, adminFSReadMax))
+	}
+
+	isDir, size, st, errMsg := s.adminFSStat(t, p)
+	if st != proto.StatusOK {
+		writeAdminFSError(w, st, errMsg)
+		return
+	}
+	if isDir {
+		writeAdminFSError(w, proto.StatusIsADir, "is a directory")
+		return
+	}
+
+	want := min(size, limit)
+	chunk := max(t.cfg.MaxChunk, 1)
+	data := make([]byte, 0, want)
+	for uint32(len(data)) < want {
+		n := uint16(min(uint32(chunk), want-uint32(len(data))))
+		e := proto.NewEncoder(len(p) + 8)
+		_ = e.WriteString(p)
+		e.WriteU32(uint32(len(data)))
+		e.WriteU16(n)
+		st, resp, errMsg := s.adminFSRun(t, proto.OpREAD_RANGE, 0, e.Bytes())
+		if st != proto.StatusOK {
+			writeAdminFSError(w, st, errMsg)
+			return
+		}
+		data = append(data, resp...)
+		if len(resp) < int(n) {
+			break // file shrank meanwhile
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-W64-Size", strconv.FormatUint(uint64(size), 10))
+	if uint32(len(data)) < size {
+		w.Header().Set("X-W64-Truncated", "1")
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+func (s *Server) handleAdminFSDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req adminFSRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	t, code, msg := s.adminFSResolve(req.TokenKind, req.TokenID)
+	if code != 0 {
+		http.Error(w, msg, code)
+		return
+	}
+
+	isDir, _, st, errMsg := s.adminFSStat(t, req.Path)
+	if st != proto.StatusOK {
+		writeAdminFSError(w, st, errMsg)
+		return
+	}
+	e := proto.NewEncoder(len(req.Path) + 2)
+	_ = e.WriteString(req.Path)
+	op, flags := byte(proto.OpRM), byte(0)
+	if isDir {
+		op = proto.OpRMDIR
+		if req.Recursive {
+			flags = proto.FlagRD_RECURSIVE
+		}
+	}
+	if st, _, errMsg := s.adminFSRun(t, op, flags, e.Bytes()); st != proto.StatusOK {
+		writeAdminFSError(w, st, errMsg)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (s *Server) handleAdminFSRename(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req adminFSRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	t, code, msg := s.adminFSResolve(req.TokenKind, req.TokenID)
+	if code != 0 {
+		http.Error(w, msg, code)
+		return
+	}
+
+	e := proto.NewEncoder(len(req.From) + len(req.To) + 4)
+	if err := e.WriteString(req.From); err != nil {
+		writeAdminFSError(w, proto.StatusInvalidPath, err.Error())
+		return
+	}
+	if err := e.WriteString(req.To); err != nil {
+		writeAdminFSError(w, proto.StatusInvalidPath, err.Error())
+		return
+	}
+	var flags byte
+	if req.Overwrite {
+		flags = proto.FlagMV_OVERWRITE
+	}
+	if st, _, errMsg := s.adminFSRun(t, proto.OpMV, flags, e.Bytes()); st != proto.StatusOK {
+		writeAdminFSError(w, st, errMsg)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAdminFSRejectsPathsOutsideRoot(t *testing.T) {
+	s, _, rootAbs := newTestServer(t, nil)
+	outside := t.TempDir()
+	secret := filepath.Join(outside, "SECRET")
+	if err := os.WriteFile(secret, []byte("top secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(rootAbs, "A"), []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rel, err := filepath.Rel(rootAbs, outside)
+	if err != nil {
+		t.Fatal(err)
+	}
+	up := "/" + filepath.ToSlash(rel)
+	haveLink := os.Symlink(outside, filepath.Join(rootAbs, "ESC")) == nil
+
+	get := func(h http.HandlerFunc, p string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/?token_kind=no_auth&path="+url.QueryEscape(p), nil)
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+	post := func(h http.HandlerFunc, req adminFSRequest) *httptest.ResponseRecorder {
+		req.TokenKind = "no_auth"
+		body, _ := json.Marshal(req)
+		r := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+
+	// The same handlers work inside the root.
+	if w := get(s.handleAdminFSRead, "/A"); w.Code != http.StatusOK || w.Body.String() != "a" {
+		t.Fatalf("read /A = %d %q", w.Code, w.Body.String())
+	}
+
+	tests := []struct {
+		name    string
+		symlink bool
+		do      func() *httptest.ResponseRecorder
+	}{
+		{"list ..", false, func() *httptest.ResponseRecorder { return get(s.handleAdminFSList, up) }},
+		{"list ../.. from a subdir", false, func() *httptest.ResponseRecorder { return get(s.handleAdminFSList, "/A/../..") }},
+		{"read ../SECRET", false, func() *httptest.ResponseRecorder { return get(s.handleAdminFSRead, up+"/SECRET") }},
+		{"read host path", false, func() *httptest.ResponseRecorder { return get(s.handleAdminFSRead, secret) }},
+		{"read through a symlink", true, func() *httptest.ResponseRecorder { return get(s.handleAdminFSRead, "/ESC/SECRET") }},
+		{"delete ../SECRET", false, func() *httptest.ResponseRecorder {
+			return post(s.handleAdminFSDelete, adminFSRequest{Path: up + "/SECRET"})
+		}},
+		{"delete outside dir", false, func() *httptest.ResponseRecorder {
+			return post(s.handleAdminFSDelete, adminFSRequest{Path: up, Recursive: true})
+		}},
+		{"rename out of the root", false, func() *httptest.ResponseRecorder {
+			return post(s.handleAdminFSRename, adminFSRequest{From: "/A", To: up + "/A"})
+		}},
+		{"rename into the root", false, func() *httptest.ResponseRecorder {
+			return post(s.handleAdminFSRename, adminFSRequest{From: up + "/SECRET", To: "/SECRET"})
+		}},
+		{"rename over an outside file", false, func() *httptest.ResponseRecorder {
+			return post(s.handleAdminFSRename, adminFSRequest{From: "/A", To: up + "/SECRET", Overwrite: true})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.symlink && !haveLink {
+				t.Skip("symlinks not supported")
+			}
+			w := tt.do()
+			if w.Code == http.StatusOK {
+				t.Fatalf("status 200, body %q", w.Body.String())
+			}
+			if bytes.Contains(w.Body.Bytes(), []byte("top secret")) {
+				t.Fatal("response leaks the outside file")
+			}
+			if b, err := os.ReadFile(secret); err != nil || string(b) != "top secret" {
+				t.Fatalf("outside file changed: %q, %v", b, err)
+			}
+			if _, err := os.Stat(filepath.Join(rootAbs, "A")); err != nil {
+				t.Fatalf("file in the root moved: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(outside, "A")); err == nil {
+				t.Fatal("file moved out of the root")
+			}
+		})
+	}
+}
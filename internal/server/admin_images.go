@@ -0,0 +1,339 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"wicos64-server/internal/fsops"
+	"wicos64-server/internal/pathutil"
+	"wicos64-server/internal/proto"
+)
+
+// Admin disk image endpoints (seeding images from the browser).
+//
+// Like the file manager they act on the root of one token and run the W64F
+// ops through dispatch (MKIMAGE, WRITE_RANGE into the image, LS), so the
+// disk image switches, sandbox, quota and path locks apply unchanged.
+//
+//	POST /admin/api/images/create   {token_kind, token_id, path, kind, name, id, overwrite}
+//	POST /admin/api/images/upload?token_kind=&token_id=&path=&name=[&overwrite=1]   (raw bytes)
+//	GET  /admin/api/images/download?token_kind=&token_id=&path=
+//	GET  /admin/api/images[?token_kind=&token_id=&path=&recursive=1&start=]
+//
+// create and upload answer with the directory of the image afterwards.
+// /images without a token lists the images written since the server
+// started (busy first by time); with a token it scans a directory of that
+// root like the IMAGES op.
+
+// adminImageUploadMax caps the body of /admin/api/images/upload (a full
+// .d81 holds about 800 KB).
+const adminImageUploadMax = 1 << 20
+
+type adminImageCreateRequest struct {
+	TokenKind string `json:"token_kind"`
+	TokenID   string `json:"token_id"`
+
+	Path      string `json:"path"`
+	Kind      string `json:"kind"` // d64|d71|d81
+	Name      string `json:"name"` // disk name
+	ID        string `json:"id"`   // disk ID (2 chars, empty = "00")
+	Overwrite bool   `json:"overwrite"`
+}
+
+type adminImageResponse struct {
+	OK      bool           `json:"ok"`
+	Path    string         `json:"path"`
+	Size    uint32         `json:"size,omitempty"` // create: image bytes; upload: file bytes
+	Entries []adminFSEntry `json:"entries"`
+}
+
+// writableImagePath reports whether p is the root of a .d64/.d71/.d81
+// image (the kinds the server can write).
+func writableImagePath(p string) bool {
+	_, ok := detectDiskImageMountRootPath(pathutil.Canonicalize(p))
+	return ok
+}
+
+// imageFileNameError checks a file name for use inside an image: a single
+// CBM name of at most 16 printable characters (a .PRG suffix is dropped
+// like on WRITE_RANGE), without the characters CBM DOS gives a meaning.
+func imageFileNameError(name string, fallbackPRG bool) string {
+	if strings.TrimSpace(name) == "" {
+		return "file name is empty"
+	}
+	base := normalizeDiskImageLeafName(name, fallbackPRG)
+	if len(base) > 16 {
+		return "file name longer than 16 chars"
+	}
+	if !printableASCII(base) || strings.ContainsAny(base, `/*?:,"`) {
+		return `file name must be printable ASCII without / * ? : , "`
+	}
+	return ""
+}
+
+// writeAdminImageListing answers with the directory of the image p.
+func (s *Server) writeAdminImageListing(w http.ResponseWriter, t adminFSTarget, p string, size uint32) {
+	entries, st, errMsg := s.adminFSList(t, p)
+	if st != proto.StatusOK {
+		writeAdminFSError(w, st, errMsg)
+		return
+	}
+	writeJSON(w, http.StatusOK, adminImageResponse{OK: true, Path: p, Size: size, Entries: entries})
+}
+
+func (s *Server) handleAdminImageCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req adminImageCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	t, code, msg := s.adminFSResolve(req.TokenKind, req.TokenID)
+	if code != 0 {
+		http.Error(w, msg, code)
+		return
+	}
+	kind, ok := imageKindByName(req.Kind)
+	if !ok {
+		writeAdminFSError(w, proto.StatusBadRequest, "kind must be d64, d71 or d81")
+		return
+	}
+
+	e := proto.NewEncoder(len(req.Path) + len(req.Name) + len(req.ID) + 7)
+	if err := e.WriteString(req.Path); err != nil {
+		writeAdminFSError(w, proto.StatusInvalidPath, err.Error())
+		return
+	}
+	e.WriteU8(kind)
+	if err := e.WriteString(req.Name); err != nil {
+		writeAdminFSError(w, proto.StatusBadRequest, err.Error())
+		return
+	}
+	if err := e.WriteString(req.ID); err != nil {
+		writeAdminFSError(w, proto.StatusBadRequest, err.Error())
+		return
+	}
+	var flags byte
+	if req.Overwrite {
+		flags = proto.FlagMI_OVERWRITE
+	}
+	st, resp, errMsg := s.adminFSRun(t, proto.OpMKIMAGE, flags, e.Bytes())
+	if st != proto.StatusOK {
+		writeAdminFSError(w, st, errMsg)
+		return
+	}
+	size, _ := proto.NewDecoder(resp).ReadU32()
+	s.writeAdminImageListing(w, t, req.Path, size)
+}
+
+func (s *Server) handleAdminImageUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	t, code, msg := s.adminFSResolve(q.Get("token_kind"), q.Get("token_id"))
+	if code != 0 {
+		http.Error(w, msg, code)
+		return
+	}
+	imgPath := q.Get("path")
+	name := q.Get("name")
+	if !writableImagePath(imgPath) {
+		writeAdminFSError(w, proto.StatusInvalidPath, "path is not a .d64/.d71/.d81 image")
+		return
+	}
+	if msg := imageFileNameError(name, t.cfg.Compat.FallbackPRGExtension); msg != "" {
+		writeAdminFSError(w, proto.StatusBadRequest, msg)
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, adminImageUploadMax))
+	if err != nil {
+		writeAdminFSError(w, proto.StatusTooLarge, "upload too large")
+		return
+	}
+
+	// Image writes are append-only: the first chunk creates (or with
+	// overwrite replaces) the file, the others extend it.
+	target := strings.TrimSuffix(imgPath, "/") + "/" + name
+	flags := byte(proto.FlagWR_CREATE | proto.FlagWR_TRUNCATE)
+	if q.Get("overwrite") == "1" || q.Get("overwrite") == "true" {
+		flags |= proto.FlagWR_OVERWRITE
+	}
+	chunk := int(max(t.cfg.MaxChunk, 1))
+	off := 0
+	for {
+		n := min(chunk, len(data)-off)
+		e := proto.NewEncoder(len(target) + 8 + n)
+		if err := e.WriteString(target); err != nil {
+			writeAdminFSError(w, proto.StatusInvalidPath, err.Error())
+			return
+		}
+		e.WriteU32(uint32(off))
+		e.WriteU16(uint16(n))
+		e.WriteBytes(data[off : off+n])
+		st, resp, errMsg := s.adminFSRun(t, proto.OpWRITE_RANGE, flags, e.Bytes())
+		if st == proto.StatusAlreadyExists && flags&proto.FlagWR_OVERWRITE != 0 && len(resp) == 6 {
+			// overwrite_confirm: the explicit overwrite=1 is the confirmation.
+			e.WriteBytes(resp[:4])
+			st, _, errMsg = s.adminFSRun(t, proto.OpWRITE_RANGE, flags|proto.FlagWR_CONFIRM, e.Bytes())
+		}
+		if st != proto.StatusOK {
+			writeAdminFSError(w, st, errMsg)
+			return
+		}
+		flags = 0
+		off += n
+		if off >= len(data) {
+			break
+		}
+	}
+	s.writeAdminImageListing(w, t, imgPath, uint32(len(data)))
+}
+
+func (s *Server) handleAdminImageDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	t, code, msg := s.adminFSResolve(q.Get("token_kind"), q.Get("token_id"))
+	if code != 0 {
+		http.Error(w, msg, code)
+		return
+	}
+	p, err := pathutil.Normalize(q.Get("path"), t.cfg.MaxPath, t.cfg.MaxName)
+	if err != nil {
+		writeAdminFSError(w, proto.StatusInvalidPath, err.Error())
+		return
+	}
+	p = pathutil.Canonicalize(p)
+	// The image file itself, not a path inside it (that is /fs/read).
+	if _, mountPath, inner, ok := splitDiskImagePath(p); !ok || inner != "" || mountPath != p {
+		writeAdminFSError(w, proto.StatusInvalidPath, "path is not a disk image")
+		return
+	}
+
+	abs, err := fsops.ToOSPath(t.rootAbs, p)
+	if err != nil {
+		writeAdminFSError(w, proto.StatusInvalidPath, err.Error())
+		return
+	}
+	if err := fsops.LstatNoSymlink(t.rootAbs, abs, false); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			writeAdminFSError(w, proto.StatusNotFound, "not found")
+			return
+		}
+		writeAdminFSError(w, proto.StatusInvalidPath, err.Error())
+		return
+	}
+	f, err := os.Open(abs)
+	if err != nil {
+		writeAdminFSError(w, proto.StatusInternal, err.Error())
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		writeAdminFSError(w, proto.StatusInternal, err.Error())
+		return
+	}
+	if !fi.Mode().IsRegular() {
+		writeAdminFSError(w, proto.StatusIsADir, "not a file")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(p)}))
+	http.ServeContent(w, r, "", fi.ModTime(), f)
+}
+
+// adminImagesMax bounds one page of /admin/api/images with a token.
+const adminImagesMax = 500
+
+type adminImagesResponse struct {
+	OK     bool        `json:"ok"`
+	Path   string      `json:"path"`
+	Images []imageInfo `json:"images"`
+	Next   int         `json:"next_index"` // -1 = no more
+}
+
+type adminActiveImagesResponse struct {
+	OK     bool               `json:"ok"`
+	Active []adminActiveImage `json:"active"`
+}
+
+// adminActiveImage is an image written since the server started.
+type adminActiveImage struct {
+	Image      string `json:"image"` // OS path
+	Busy       bool   `json:"busy"`
+	Locked     bool   `json:"locked"`
+	LastOp     string `json:"last_op"`
+	LastUnixMs int64  `json:"last_unix_ms"`
+}
+
+func (s *Server) handleAdminImages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("token_kind") == "" && q.Get("token_id") == "" {
+		active := []adminActiveImage{}
+		for _, ia := range s.images.snapshot() {
+			active = append(active, adminActiveImage{
+				Image:      ia.path,
+				Busy:       ia.running > 0,
+				Locked:     s.paths.held(pathLockKey(ia.path)),
+				LastOp:     ia.lastOp,
+				LastUnixMs: ia.last.UnixMilli(),
+			})
+		}
+		writeJSON(w, http.StatusOK, adminActiveImagesResponse{OK: true, Active: active})
+		return
+	}
+
+	t, code, msg := s.adminFSResolve(q.Get("token_kind"), q.Get("token_id"))
+	if code != 0 {
+		http.Error(w, msg, code)
+		return
+	}
+	if !t.limits.DiskImagesEnabled {
+		writeAdminFSError(w, proto.StatusNotSupported, "disk images are disabled")
+		return
+	}
+	p := q.Get("path")
+	if p == "" {
+		p = "/"
+	}
+	p, err := pathutil.Normalize(p, t.cfg.MaxPath, t.cfg.MaxName)
+	if err != nil {
+		writeAdminFSError(w, proto.StatusInvalidPath, err.Error())
+		return
+	}
+	p = pathutil.Canonicalize(p)
+	start, _ := strconv.Atoi(q.Get("start"))
+	images, next, st, errMsg := s.collectImages(t.cfg, t.rootAbs, p, q.Get("recursive") == "1", max(start, 0), adminImagesMax)
+	if st != proto.StatusOK {
+		writeAdminFSError(w, st, errMsg)
+		return
+	}
+	if images == nil {
+		images = []imageInfo{}
+	}
+	writeJSON(w, http.StatusOK, adminImagesResponse{OK: true, Path: p, Images: images, Next: next})
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+func TestAdminImageCreateUploadDownload(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, func(c *config.Config) {
+		c.DiskImagesWriteEnabled = true
+		c.MaxChunk = 128
+	})
+	if err := os.Mkdir(filepath.Join(rootAbs, "IMG"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	create := func(req adminImageCreateRequest) *httptest.ResponseRecorder {
+		req.TokenKind = "no_auth"
+		body, _ := json.Marshal(req)
+		w := httptest.NewRecorder()
+		s.handleAdminImageCreate(w, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+		return w
+	}
+	upload := func(p, name, extra string, data []byte) *httptest.ResponseRecorder {
+		q := "/?token_kind=no_auth&path=" + url.QueryEscape(p) + "&name=" + url.QueryEscape(name) + extra
+		w := httptest.NewRecorder()
+		s.handleAdminImageUpload(w, httptest.NewRequest("POST", q, bytes.NewReader(data)))
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder) adminImageResponse {
+		t.Helper()
+		var resp adminImageResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode %q: %v", w.Body.String(), err)
+		}
+		return resp
+	}
+
+	// Create a blank D64: the answer carries the size and an empty directory.
+	w := create(adminImageCreateRequest{Path: "/IMG/GAMES.D64", Kind: "d64", Name: "MY GAMES", ID: "MG"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("create = %d %s", w.Code, w.Body.String())
+	}
+	if resp := decode(w); !resp.OK || resp.Size != 174848 || len(resp.Entries) != 0 {
+		t.Fatalf("create = %+v, want ok, 174848 bytes, no entries", resp)
+	}
+	if fi, err := os.Stat(filepath.Join(rootAbs, "IMG", "GAMES.D64")); err != nil || fi.Size() != 174848 {
+		t.Fatalf("image on disk: %v %v", fi, err)
+	}
+	if w := create(adminImageCreateRequest{Path: "/IMG/GAMES.D64", Kind: "d64"}); w.Code != http.StatusConflict {
+		t.Fatalf("create over an existing image = %d, want 409", w.Code)
+	}
+	if w := create(adminImageCreateRequest{Path: "/IMG/GAMES.D64", Kind: "d64", Overwrite: true}); w.Code != http.StatusOK {
+		t.Fatalf("create with overwrite = %d %s", w.Code, w.Body.String())
+	}
+
+	// Upload a PRG of several max_chunk pieces and read it back through W64F.
+	prg := make([]byte, 700)
+	prg[0], prg[1] = 0x01, 0x08
+	for i := 2; i < len(prg); i++ {
+		prg[i] = byte(i * 7)
+	}
+	w = upload("/IMG/GAMES.D64", "HELLO", "", prg)
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload = %d %s", w.Code, w.Body.String())
+	}
+	resp := decode(w)
+	if !resp.OK || resp.Size != uint32(len(prg)) || len(resp.Entries) != 1 || !strings.HasPrefix(resp.Entries[0].Name, "HELLO") {
+		t.Fatalf("upload = %+v, want ok and one HELLO entry", resp)
+	}
+	readBack := func(name string, size int) []byte {
+		t.Helper()
+		var data []byte
+		for len(data) < size {
+			st, chunk, msg := s.dispatch(cfg, Limits{DiskImagesEnabled: true}, proto.OpREAD_RANGE, 0, encode(func(e *proto.Encoder) {
+				_ = e.WriteString("/IMG/GAMES.D64/" + name)
+				e.WriteU32(uint32(len(data)))
+				e.WriteU16(uint16(min(size-len(data), int(cfg.MaxChunk))))
+			}), rootAbs)
+			if st != proto.StatusOK {
+				t.Fatalf("READ_RANGE %s at %d = %s (%s)", name, len(data), statusName(st), msg)
+			}
+			data = append(data, chunk...)
+		}
+		return data
+	}
+	if got := readBack(resp.Entries[0].Name, len(prg)); !bytes.Equal(got, prg) {
+		t.Fatalf("file in image = %d bytes, want the %d uploaded", len(got), len(prg))
+	}
+
+	// A second upload under the same name needs overwrite=1.
+	if w := upload("/IMG/GAMES.D64", "HELLO", "", []byte{1, 8, 0}); w.Code != http.StatusForbidden {
+		t.Fatalf("upload over an existing file = %d %s, want 403", w.Code, w.Body.String())
+	}
+	if w := upload("/IMG/GAMES.D64", "HELLO", "&overwrite=1", []byte{1, 8, 0x60}); w.Code != http.StatusOK {
+		t.Fatalf("upload with overwrite = %d %s", w.Code, w.Body.String())
+	}
+	if got := readBack(resp.Entries[0].Name, 3); !bytes.Equal(got, []byte{1, 8, 0x60}) {
+		t.Fatalf("file after overwrite = % x", got)
+	}
+
+	// Download returns the image file as an attachment.
+	w = httptest.NewRecorder()
+	s.handleAdminImageDownload(w, httptest.NewRequest("GET", "/?token_kind=no_auth&path=/IMG/GAMES.D64", nil))
+	onDisk, err := os.ReadFile(filepath.Join(rootAbs, "IMG", "GAMES.D64"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), onDisk) {
+		t.Fatalf("download = %d, %d bytes, want the %d on disk", w.Code, w.Body.Len(), len(onDisk))
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename=GAMES.D64` {
+		t.Fatalf("Content-Disposition = %q", cd)
+	}
+}
+
+func TestAdminImageRejects(t *testing.T) {
+	s, _, rootAbs := newTestServer(t, func(c *config.Config) {
+		c.DiskImagesWriteEnabled = true
+	})
+	if err := os.WriteFile(filepath.Join(rootAbs, "PLAIN.TXT"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(adminImageCreateRequest{TokenKind: "no_auth", Path: "/GAMES.D64", Kind: "d64"})
+	w := httptest.NewRecorder()
+	s.handleAdminImageCreate(w, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("create = %d %s", w.Code, w.Body.String())
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		h      http.HandlerFunc
+		target string
+		body   string
+		want   int
+	}{
+		{"create with a bad kind", "POST", s.handleAdminImageCreate, "/",
+			`{"token_kind":"no_auth","path":"/X.D64","kind":"d82"}`, http.StatusBadRequest},
+		{"create over a missing directory", "POST", s.handleAdminImageCreate, "/",
+			`{"token_kind":"no_auth","path":"/NOPE/X.D64","kind":"d64"}`, http.StatusNotFound},
+		{"create with GET", "GET", s.handleAdminImageCreate, "/", "", http.StatusMethodNotAllowed},
+		{"upload with an unknown token kind", "POST", s.handleAdminImageUpload,
+			"/?token_kind=bogus&path=/GAMES.D64&name=A", "x", http.StatusBadRequest},
+		{"upload into a plain file", "POST", s.handleAdminImageUpload,
+			"/?token_kind=no_auth&path=/PLAIN.TXT&name=A", "x", http.StatusBadRequest},
+		{"upload into a T64", "POST", s.handleAdminImageUpload,
+			"/?token_kind=no_auth&path=/X.T64&name=A", "x", http.StatusBadRequest},
+		{"upload with a 17-char name", "POST", s.handleAdminImageUpload,
+			"/?token_kind=no_auth&path=/GAMES.D64&name=ABCDEFGHIJKLMNOPQ", "x", http.StatusBadRequest},
+		{"upload with a wildcard", "POST", s.handleAdminImageUpload,
+			"/?token_kind=no_auth&path=/GAMES.D64&name=A*", "x", http.StatusBadRequest},
+		{"upload without a name", "POST", s.handleAdminImageUpload,
+			"/?token_kind=no_auth&path=/GAMES.D64", "x", http.StatusBadRequest},
+		{"download a plain file", "GET", s.handleAdminImageDownload,
+			"/?token_kind=no_auth&path=/PLAIN.TXT", "", http.StatusBadRequest},
+		{"download a path inside the image", "GET", s.handleAdminImageDownload,
+			"/?token_kind=no_auth&path=/GAMES.D64/A", "", http.StatusBadRequest},
+		{"download a missing image", "GET", s.handleAdminImageDownload,
+			"/?token_kind=no_auth&path=/MISSING.D64", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.h(w, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+			if w.Code != tt.want {
+				t.Fatalf("%s %s = %d %s, want %d", tt.method, tt.target, w.Code, w.Body.String(), tt.want)
+			}
+		})
+	}
+	if _, err := os.Stat(filepath.Join(rootAbs, "X.D64")); err == nil {
+		t.Fatal("a rejected create left an image behind")
+	}
+}
+
+func TestAdminImageWriteDisabled(t *testing.T) {
+	s, _, _ := newTestServer(t, nil) // disk_images_write_enabled defaults to false
+	body, _ := json.Marshal(adminImageCreateRequest{TokenKind: "no_auth", Path: "/GAMES.D64", Kind: "d64"})
+	w := httptest.NewRecorder()
+	s.handleAdminImageCreate(w, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+	if w.Code == http.StatusOK {
+		t.Fatalf("create with image writes disabled = %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAdminImageRoutesRequireAdmin(t *testing.T) {
+	s, _, _ := newTestServer(t, func(c *config.Config) {
+		c.DiskImagesWriteEnabled = true
+	})
+	h := s.HTTPHandler()
+	for _, p := range []string{"/images/create", "/images/upload", "/images/download"} {
+		r := httptest.NewRequest("POST", adminPath+"/api"+p, strings.NewReader("{}"))
+		r.RemoteAddr = "203.0.113.7:1234"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("remote %s = %d, want 403", p, w.Code)
+		}
+	}
+	// Local, but without the CSRF token.
+	r := httptest.NewRequest("POST", adminPath+"/api/images/create", strings.NewReader(`{"token_kind":"no_auth","path":"/X.D64","kind":"d64"}`))
+	r.RemoteAddr = "127.0.0.1:1234"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("create without CSRF = %d, want 403", w.Code)
+	}
+}
@@ -2,10 +2,12 @@ package server
 
 import (
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -88,17 +90,13 @@ func (s *Server) handleAdminOpsRun(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "token not active", http.StatusBadRequest)
 		return
 	}
-
-	limits := Limits{
-		ReadOnly:                     ctx.ReadOnly,
-		QuotaBytes:                   ctx.QuotaBytes,
-		MaxFileBytes:                 ctx.MaxFileBytes,
-		DiskImagesEnabled:            ctx.DiskImagesEnabled,
-		DiskImagesWriteEnabled:       ctx.DiskImagesWriteEnabled,
-		DiskImagesAutoResizeEnabled:  ctx.DiskImagesAutoResizeEnabled,
-		DiskImagesAllowRenameConvert: ctx.DiskImagesAllowRenameConvert,
+	if msg := tokenWindowError(ctx, time.Now()); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
 	}
 
+	limits := limitsFromContext(ctx)
+
 	op, flags, payload, parseErr := parseOpsCLI(req.Line, req.Data, req.DataEnc)
 	if parseErr != nil {
 		http.Error(w, parseErr.Error(), http.StatusBadRequest)
@@ -335,8 +333,28 @@ func parseOpsCLI(line, data, dataEnc string) (byte, byte, []byte, error) {
 
 	case "ls":
 		op = proto.OpLS
+		// ls supports opts: --sort=name|size|mtime|type, -r/--reverse
+		for i := 0; i < len(rest) && strings.HasPrefix(rest[i], "-"); i++ {
+			if v, ok := strings.CutPrefix(rest[i], "--sort="); ok {
+				key := slices.Index(proto.LSSortNames, strings.ToLower(v))
+				if key < 0 {
+					return 0, 0, nil, fmt.Errorf("unknown sort key %q (name, size, mtime, type)", v)
+				}
+				flags |= byte(key)
+				rest = append(rest[:i:i], rest[i+1:]...)
+				break
+			}
+		}
+		var err error
+		rest, err = takeOpts(map[string]byte{
+			"-r":        proto.FlagLS_REVERSE,
+			"--reverse": proto.FlagLS_REVERSE,
+		}, rest)
+		if err != nil {
+			return 0, 0, nil, err
+		}
 		if len(rest) < 1 {
-			return 0, 0, nil, fmt.Errorf("usage: ls <path> [start] [max]")
+			return 0, 0, nil, fmt.Errorf("usage: ls [--sort=name|size|mtime|type] [-r] <path> [start] [max]")
 		}
 		path := rest[0]
 		start := uint16(0)
@@ -370,8 +388,33 @@ func parseOpsCLI(line, data, dataEnc string) (byte, byte, []byte, error) {
 
 	case "read":
 		op = proto.OpREAD_RANGE
+		// read supports opts: -s (screen codes -> ASCII), -l (lower case set),
+		// --if-crc=<crc32> (IF_HASH: skip the read if the file still has it)
+		var ifCRC uint32
+		hasIfCRC := false
+		for i := 0; i < len(rest) && strings.HasPrefix(rest[i], "-"); i++ {
+			if v, ok := strings.CutPrefix(rest[i], "--if-crc="); ok {
+				n, perr := parseU32(v)
+				if perr != nil {
+					return 0, 0, nil, fmt.Errorf("invalid crc: %v", perr)
+				}
+				ifCRC, hasIfCRC = n, true
+				rest = append(rest[:i:i], rest[i+1:]...)
+				break
+			}
+		}
+		var err error
+		rest, err = takeOpts(map[string]byte{
+			"-s":           proto.FlagR_SCREENCODE,
+			"--screencode": proto.FlagR_SCREENCODE,
+			"-l":           proto.FlagR_SCREENCODE | proto.FlagR_SC_LOWER,
+			"--lower":      proto.FlagR_SCREENCODE | proto.FlagR_SC_LOWER,
+		}, rest)
+		if err != nil {
+			return 0, 0, nil, err
+		}
 		if len(rest) != 3 {
-			return 0, 0, nil, fmt.Errorf("usage: read <path> <offset> <len>")
+			return 0, 0, nil, fmt.Errorf("usage: read [-s|-l] [--if-crc=<crc32>] <path> <offset> <len>")
 		}
 		off, perr := parseU32(rest[1])
 		if perr != nil {
@@ -384,23 +427,49 @@ func parseOpsCLI(line, data, dataEnc string) (byte, byte, []byte, error) {
 		e.WriteString(rest[0])
 		e.WriteU32(off)
 		e.WriteU16(ln)
+		if hasIfCRC {
+			flags |= proto.FlagR_IF_HASH
+			e.WriteU32(ifCRC)
+		}
 		payload = e.Bytes()
 
 	case "write":
 		op = proto.OpWRITE_RANGE
-		// write supports opts: -t (truncate), -c (create)
+		// write supports opts: -t (truncate), -c (create), -o (overwrite), -b (strip BOM),
+		// -s (want size), --staged / --commit (staged write), --confirm=<n> (overwrite_confirm)
+		var confirm uint32
+		hasConfirm := false
+		for i := 0; i < len(rest) && strings.HasPrefix(rest[i], "-"); i++ {
+			if v, ok := strings.CutPrefix(rest[i], "--confirm="); ok {
+				n, perr := parseU32(v)
+				if perr != nil {
+					return 0, 0, nil, fmt.Errorf("invalid confirm: %v", perr)
+				}
+				confirm, hasConfirm = n, true
+				rest = append(rest[:i:i], rest[i+1:]...)
+				break
+			}
+		}
 		var err error
 		rest, err = takeOpts(map[string]byte{
-			"-t":         proto.FlagWR_TRUNCATE,
-			"--truncate": proto.FlagWR_TRUNCATE,
-			"-c":         proto.FlagWR_CREATE,
-			"--create":   proto.FlagWR_CREATE,
+			"-o":          proto.FlagWR_OVERWRITE,
+			"--overwrite": proto.FlagWR_OVERWRITE,
+			"-t":          proto.FlagWR_TRUNCATE,
+			"--truncate":  proto.FlagWR_TRUNCATE,
+			"-c":          proto.FlagWR_CREATE,
+			"--create":    proto.FlagWR_CREATE,
+			"-b":          proto.FlagWR_STRIP_BOM,
+			"--strip-bom": proto.FlagWR_STRIP_BOM,
+			"-s":          proto.FlagWR_WANT_SIZE,
+			"--size":      proto.FlagWR_WANT_SIZE,
+			"--staged":    proto.FlagWR_STAGED,
+			"--commit":    proto.FlagWR_COMMIT,
 		}, rest)
 		if err != nil {
 			return 0, 0, nil, err
 		}
 		if len(rest) < 2 {
-			return 0, 0, nil, fmt.Errorf("usage: write [-t] [-c] <path> <offset> [data]")
+			return 0, 0, nil, fmt.Errorf("usage: write [-t] [-c] [-o] [-b] [-s] [--staged] [--commit] [--confirm=<n>] <path> <offset> [data]")
 		}
 		path := rest[0]
 		off, perr := parseU32(rest[1])
@@ -425,6 +494,10 @@ func parseOpsCLI(line, data, dataEnc string) (byte, byte, []byte, error) {
 		e.WriteU32(off)
 		e.WriteU16(uint16(len(bytes)))
 		e.WriteBytes(bytes)
+		if hasConfirm {
+			flags |= proto.FlagWR_CONFIRM
+			e.WriteU32(confirm)
+		}
 		payload = e.Bytes()
 
 	case "append":
@@ -480,44 +553,61 @@ func parseOpsCLI(line, data, dataEnc string) (byte, byte, []byte, error) {
 
 	case "rmdir":
 		op = proto.OpRMDIR
-		// rmdir supports opts: -r
+		// rmdir supports opts: -r, -n
 		var err error
 		rest, err = takeOpts(map[string]byte{
 			"-r":          proto.FlagRD_RECURSIVE,
 			"--recursive": proto.FlagRD_RECURSIVE,
+			"-n":          proto.FlagDRY_RUN,
+			"--dry-run":   proto.FlagDRY_RUN,
 		}, rest)
 		if err != nil {
 			return 0, 0, nil, err
 		}
 		if len(rest) != 1 {
-			return 0, 0, nil, fmt.Errorf("usage: rmdir [-r] <path>")
+			return 0, 0, nil, fmt.Errorf("usage: rmdir [-r] [-n] <path>")
 		}
 		e.WriteString(rest[0])
 		payload = e.Bytes()
 
 	case "rm":
 		op = proto.OpRM
+		// rm supports opts: -n
+		var err error
+		rest, err = takeOpts(map[string]byte{
+			"-n":        proto.FlagDRY_RUN,
+			"--dry-run": proto.FlagDRY_RUN,
+		}, rest)
+		if err != nil {
+			return 0, 0, nil, err
+		}
 		if len(rest) != 1 {
-			return 0, 0, nil, fmt.Errorf("usage: rm <path>")
+			return 0, 0, nil, fmt.Errorf("usage: rm [-n] <path>")
 		}
 		e.WriteString(rest[0])
 		payload = e.Bytes()
 
 	case "cp":
 		op = proto.OpCP
-		// cp supports opts: -o, -r
+		// cp supports opts: -o, -r, -a, -c, -n
 		var err error
 		rest, err = takeOpts(map[string]byte{
 			"-o":          proto.FlagCP_OVERWRITE,
 			"--overwrite": proto.FlagCP_OVERWRITE,
 			"-r":          proto.FlagCP_RECURSIVE,
 			"--recursive": proto.FlagCP_RECURSIVE,
+			"-a":          proto.FlagCP_ASYNC,
+			"--async":     proto.FlagCP_ASYNC,
+			"-c":          proto.FlagCP_CONVERT,
+			"--convert":   proto.FlagCP_CONVERT,
+			"-n":          proto.FlagDRY_RUN,
+			"--dry-run":   proto.FlagDRY_RUN,
 		}, rest)
 		if err != nil {
 			return 0, 0, nil, err
 		}
 		if len(rest) != 2 {
-			return 0, 0, nil, fmt.Errorf("usage: cp [-o] [-r] <src> <dst>")
+			return 0, 0, nil, fmt.Errorf("usage: cp [-o] [-r] [-a] [-c] [-n] <src> <dst>")
 		}
 		e.WriteString(rest[0])
 		e.WriteString(rest[1])
@@ -525,17 +615,19 @@ func parseOpsCLI(line, data, dataEnc string) (byte, byte, []byte, error) {
 
 	case "mv":
 		op = proto.OpMV
-		// mv supports opts: -o
+		// mv supports opts: -o, -n
 		var err error
 		rest, err = takeOpts(map[string]byte{
 			"-o":          proto.FlagMV_OVERWRITE,
 			"--overwrite": proto.FlagMV_OVERWRITE,
+			"-n":          proto.FlagDRY_RUN,
+			"--dry-run":   proto.FlagDRY_RUN,
 		}, rest)
 		if err != nil {
 			return 0, 0, nil, err
 		}
 		if len(rest) != 2 {
-			return 0, 0, nil, fmt.Errorf("usage: mv [-o] <src> <dst>")
+			return 0, 0, nil, fmt.Errorf("usage: mv [-o] [-n] <src> <dst>")
 		}
 		e.WriteString(rest[0])
 		e.WriteString(rest[1])
@@ -543,8 +635,27 @@ func parseOpsCLI(line, data, dataEnc string) (byte, byte, []byte, error) {
 
 	case "search":
 		op = proto.OpSEARCH
+		// search supports opts: -i, -r, -w, -e (regex), -n (names), -m (into images)
+		var err error
+		rest, err = takeOpts(map[string]byte{
+			"-i":            proto.FlagS_CASE_INSENSITIVE,
+			"--ignore-case": proto.FlagS_CASE_INSENSITIVE,
+			"-r":            proto.FlagS_RECURSIVE,
+			"--recursive":   proto.FlagS_RECURSIVE,
+			"-w":            proto.FlagS_WHOLE_WORD,
+			"--word":        proto.FlagS_WHOLE_WORD,
+			"-e":            proto.FlagS_REGEX,
+			"--regex":       proto.FlagS_REGEX,
+			"-n":            proto.FlagS_NAMES,
+			"--names":       proto.FlagS_NAMES,
+			"-m":            proto.FlagS_INTO_IMAGES,
+			"--images":      proto.FlagS_INTO_IMAGES,
+		}, rest)
+		if err != nil {
+			return 0, 0, nil, err
+		}
 		if len(rest) < 2 {
-			return 0, 0, nil, fmt.Errorf("usage: search <base> <query> [start] [max] [maxScan] [-f <flags>]")
+			return 0, 0, nil, fmt.Errorf("usage: search [-i] [-r] [-w] [-e] [-n] [-m] <base> <query> [start] [max] [maxScan] [-f <flags>]")
 		}
 		base := rest[0]
 		query := rest[1]
@@ -577,12 +688,8 @@ func parseOpsCLI(line, data, dataEnc string) (byte, byte, []byte, error) {
 		e.WriteU16(start)
 		e.WriteU16(max)
 		e.WriteU32(maxScan)
-		e.WriteU8(flags) // search flags live in the flags byte for SEARCH.
 		payload = e.Bytes()
 
-		// SEARCH uses payload flags field inside payload, not W64F header flags.
-		flags = 0
-
 	case "hash":
 		op = proto.OpHASH
 		if len(rest) != 1 {
@@ -591,155 +698,649 @@ func parseOpsCLI(line, data, dataEnc string) (byte, byte, []byte, error) {
 		e.WriteString(rest[0])
 		payload = e.Bytes()
 
-	default:
-		return 0, 0, nil, fmt.Errorf("unknown command: %s", cmd)
-	}
-
-	return op, flags, payload, nil
-}
-
-func splitArgs(s string) ([]string, error) {
-	s = strings.TrimSpace(s)
-	if s == "" {
-		return nil, nil
-	}
-
-	var args []string
-	var cur strings.Builder
-	inQuotes := false
-	escaped := false
-
-	flush := func() {
-		if cur.Len() > 0 {
-			args = append(args, cur.String())
-			cur.Reset()
+	case "dirmtime":
+		op = proto.OpDIRMTIME
+		if len(rest) != 1 {
+			return 0, 0, nil, fmt.Errorf("usage: dirmtime <path>")
 		}
-	}
+		e.WriteString(rest[0])
+		payload = e.Bytes()
 
-	for _, r := range s {
-		if escaped {
-			cur.WriteRune(r)
-			escaped = false
-			continue
+	case "strings":
+		op = proto.OpSTRINGS
+		if len(rest) < 1 || len(rest) > 4 {
+			return 0, 0, nil, fmt.Errorf("usage: strings <path> [offset] [minLen] [maxScan]")
 		}
-		switch r {
-		case '\\':
-			escaped = true
-		case '"':
-			inQuotes = !inQuotes
-		case ' ', '\t', '\n', '\r':
-			if inQuotes {
-				cur.WriteRune(r)
-			} else {
-				flush()
+		off := uint32(0)
+		minLen := byte(0)
+		maxScan := uint32(0)
+		if len(rest) >= 2 {
+			v, perr := parseU32(rest[1])
+			if perr != nil {
+				return 0, 0, nil, fmt.Errorf("invalid offset: %v", perr)
 			}
-		default:
-			cur.WriteRune(r)
+			off = v
 		}
-	}
-
-	if escaped {
-		// dangling backslash; keep it.
-		cur.WriteRune('\\')
-	}
-
-	if inQuotes {
-		return nil, fmt.Errorf("unterminated quote")
-	}
-
-	flush()
-	return args, nil
-}
-
-func parseU16(s string) (uint16, error) {
-	v, err := parseUint(s, 16)
-	if err != nil {
-		return 0, err
-	}
-	if v > 0xFFFF {
-		return 0, fmt.Errorf("out of range")
-	}
-	return uint16(v), nil
-}
-
-func parseU32(s string) (uint32, error) {
-	v, err := parseUint(s, 32)
-	if err != nil {
-		return 0, err
-	}
-	if v > 0xFFFFFFFF {
-		return 0, fmt.Errorf("out of range")
-	}
-	return uint32(v), nil
-}
-
-func parseByte(s string) (byte, error) {
-	v, err := parseUint(s, 8)
-	if err != nil {
-		return 0, err
-	}
-	if v > 0xFF {
-		return 0, fmt.Errorf("out of range")
-	}
-	return byte(v), nil
-}
-
-func parseUint(s string, bits int) (uint64, error) {
-	s = strings.TrimSpace(s)
-	if s == "" {
-		return 0, fmt.Errorf("empty")
-	}
+		if len(rest) >= 3 {
+			v, perr := parseByte(rest[2])
+			if perr != nil {
+				return 0, 0, nil, fmt.Errorf("invalid minLen: %v", perr)
+			}
+			minLen = v
+		}
+		if len(rest) >= 4 {
+			v, perr := parseU32(rest[3])
+			if perr != nil {
+				return 0, 0, nil, fmt.Errorf("invalid maxScan: %v", perr)
+			}
+			maxScan = v
+		}
+		e.WriteString(rest[0])
+		e.WriteU32(off)
+		e.WriteU8(minLen)
+		e.WriteU32(maxScan)
+		payload = e.Bytes()
 
-	base := 10
-	if strings.HasPrefix(strings.ToLower(s), "0x") {
-		base = 16
-		s = s[2:]
-	}
+	case "tree":
+		op = proto.OpTREE
+		// tree supports opts: -a (include files)
+		var err error
+		rest, err = takeOpts(map[string]byte{
+			"-a":      proto.FlagTR_FILES,
+			"--files": proto.FlagTR_FILES,
+		}, rest)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		if len(rest) < 1 || len(rest) > 3 {
+			return 0, 0, nil, fmt.Errorf("usage: tree [-a] <path> [depth] [maxNodes]")
+		}
+		depth := byte(0)
+		maxNodes := uint16(0)
+		if len(rest) >= 2 {
+			v, perr := parseByte(rest[1])
+			if perr != nil {
+				return 0, 0, nil, fmt.Errorf("invalid depth: %v", perr)
+			}
+			depth = v
+		}
+		if len(rest) >= 3 {
+			v, perr := parseU16(rest[2])
+			if perr != nil {
+				return 0, 0, nil, fmt.Errorf("invalid maxNodes: %v", perr)
+			}
+			maxNodes = v
+		}
+		e.WriteString(rest[0])
+		e.WriteU8(depth)
+		e.WriteU16(maxNodes)
+		payload = e.Bytes()
 
-	return strconv.ParseUint(s, base, bits)
-}
+	case "tail":
+		op = proto.OpREAD_TAIL
+		if len(rest) < 1 || len(rest) > 2 {
+			return 0, 0, nil, fmt.Errorf("usage: tail <path> [len]")
+		}
+		ln := uint16(0)
+		if len(rest) == 2 {
+			v, perr := parseU16(rest[1])
+			if perr != nil {
+				return 0, 0, nil, fmt.Errorf("invalid len: %v", perr)
+			}
+			ln = v
+		}
+		e.WriteString(rest[0])
+		e.WriteU16(ln)
+		payload = e.Bytes()
 
-func decodeData(s, enc string) ([]byte, error) {
-	enc = strings.ToLower(strings.TrimSpace(enc))
-	if enc == "" {
-		enc = "text"
-	}
+	case "names":
+		op = proto.OpTOKEN_NAMES
+		if len(rest) > 2 {
+			return 0, 0, nil, fmt.Errorf("usage: names [start] [max]")
+		}
+		start := uint16(0)
+		max := uint16(0)
+		if len(rest) >= 1 {
+			v, perr := parseU16(rest[0])
+			if perr != nil {
+				return 0, 0, nil, fmt.Errorf("invalid start: %v", perr)
+			}
+			start = v
+		}
+		if len(rest) >= 2 {
+			v, perr := parseU16(rest[1])
+			if perr != nil {
+				return 0, 0, nil, fmt.Errorf("invalid max: %v", perr)
+			}
+			max = v
+		}
+		e.WriteU16(start)
+		e.WriteU16(max)
+		payload = e.Bytes()
 
-	switch enc {
-	case "text", "utf8", "utf-8":
-		return []byte(s), nil
+	case "touch":
+		op = proto.OpTOUCH
+		// touch supports opts: -c (create)
+		var err error
+		rest, err = takeOpts(map[string]byte{
+			"-c":       proto.FlagT_CREATE,
+			"--create": proto.FlagT_CREATE,
+		}, rest)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		if len(rest) < 1 || len(rest) > 2 {
+			return 0, 0, nil, fmt.Errorf("usage: touch [-c] <path> [mtime]")
+		}
+		mt := uint32(0)
+		if len(rest) == 2 {
+			v, perr := parseU32(rest[1])
+			if perr != nil {
+				return 0, 0, nil, fmt.Errorf("invalid mtime: %v", perr)
+			}
+			mt = v
+		}
+		e.WriteString(rest[0])
+		e.WriteU32(mt)
+		payload = e.Bytes()
 
-	case "hex":
-		cleaned := strings.ReplaceAll(strings.TrimSpace(s), " ", "")
-		cleaned = strings.ReplaceAll(cleaned, "\n", "")
-		cleaned = strings.ReplaceAll(cleaned, "\r", "")
-		cleaned = strings.ReplaceAll(cleaned, "\t", "")
-		if cleaned == "" {
-			return nil, nil
+	case "mktemp":
+		op = proto.OpMKTEMP
+		if len(rest) > 2 {
+			return 0, 0, nil, fmt.Errorf("usage: mktemp [prefix] [suffix]")
 		}
-		if len(cleaned)%2 != 0 {
-			return nil, fmt.Errorf("hex length must be even")
+		prefix, suffix := "", ""
+		if len(rest) >= 1 {
+			prefix = rest[0]
 		}
-		b, err := hex.DecodeString(cleaned)
-		if err != nil {
-			return nil, fmt.Errorf("invalid hex: %v", err)
+		if len(rest) >= 2 {
+			suffix = rest[1]
 		}
-		return b, nil
+		e.WriteString(prefix)
+		e.WriteString(suffix)
+		payload = e.Bytes()
 
-	case "base64", "b64":
-		s = strings.TrimSpace(s)
-		if s == "" {
-			return nil, nil
+	case "echo":
+		op = proto.OpECHO
+		if len(rest) < 1 {
+			return 0, 0, nil, fmt.Errorf("usage: echo <delay_ms> [text]")
 		}
-		b, err := base64.StdEncoding.DecodeString(s)
+		delay, err := parseU16(rest[0])
 		if err != nil {
-			return nil, fmt.Errorf("invalid base64: %v", err)
+			return 0, 0, nil, fmt.Errorf("invalid delay: %v", err)
 		}
-		return b, nil
+		e.WriteU16(delay)
+		e.WriteBytes([]byte(strings.Join(rest[1:], " ")))
+		payload = e.Bytes()
 
-	default:
-		return nil, fmt.Errorf("unknown data_enc: %s", enc)
-	}
+	case "lock":
+		op = proto.OpLOCK
+		if len(rest) != 1 {
+			return 0, 0, nil, fmt.Errorf("usage: lock <path>")
+		}
+		e.WriteString(rest[0])
+		payload = e.Bytes()
+
+	case "unlock":
+		op = proto.OpUNLOCK
+		var err error
+		rest, err = takeOpts(map[string]byte{
+			"-f":      proto.FlagUL_FORCE,
+			"--force": proto.FlagUL_FORCE,
+		}, rest)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		if len(rest) != 1 {
+			return 0, 0, nil, fmt.Errorf("usage: unlock [-f] <path>")
+		}
+		e.WriteString(rest[0])
+		payload = e.Bytes()
+
+	case "copyrange":
+		op = proto.OpCOPY_RANGE
+		// copyrange supports opts: -t (truncate), -c (create), -o (overwrite)
+		var err error
+		rest, err = takeOpts(map[string]byte{
+			"-t":          proto.FlagWR_TRUNCATE,
+			"--truncate":  proto.FlagWR_TRUNCATE,
+			"-c":          proto.FlagWR_CREATE,
+			"--create":    proto.FlagWR_CREATE,
+			"-o":          proto.FlagWR_OVERWRITE,
+			"--overwrite": proto.FlagWR_OVERWRITE,
+		}, rest)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		if len(rest) != 5 {
+			return 0, 0, nil, fmt.Errorf("usage: copyrange [-t] [-c] [-o] <src> <src_off> <dst> <dst_off> <len>")
+		}
+		srcOff, perr := parseU32(rest[1])
+		if perr != nil {
+			return 0, 0, nil, fmt.Errorf("invalid src_off: %v", perr)
+		}
+		dstOff, perr := parseU32(rest[3])
+		if perr != nil {
+			return 0, 0, nil, fmt.Errorf("invalid dst_off: %v", perr)
+		}
+		ln, perr := parseU16(rest[4])
+		if perr != nil {
+			return 0, 0, nil, fmt.Errorf("invalid len: %v", perr)
+		}
+		e.WriteString(rest[0])
+		e.WriteU32(srcOff)
+		e.WriteString(rest[2])
+		e.WriteU32(dstOff)
+		e.WriteU16(ln)
+		payload = e.Bytes()
+
+	case "motd":
+		op = proto.OpMOTD
+		if len(rest) != 0 {
+			return 0, 0, nil, fmt.Errorf("usage: motd")
+		}
+
+	case "samefile":
+		op = proto.OpSAMEFILE
+		if len(rest) != 2 {
+			return 0, 0, nil, fmt.Errorf("usage: samefile <path_a> <path_b>")
+		}
+		e.WriteString(rest[0])
+		e.WriteString(rest[1])
+		payload = e.Bytes()
+
+	case "lstree":
+		op = proto.OpLS_TREE
+		// lstree supports opts: -i (descend into disk images)
+		var err error
+		rest, err = takeOpts(map[string]byte{
+			"-i":       proto.FlagLT_IMAGES,
+			"--images": proto.FlagLT_IMAGES,
+		}, rest)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		if len(rest) < 1 || len(rest) > 4 {
+			return 0, 0, nil, fmt.Errorf("usage: lstree [-i] <path> [depth] [start] [max]")
+		}
+		depth := byte(0)
+		start := uint16(0)
+		max := uint16(0)
+		if len(rest) >= 2 {
+			v, perr := parseByte(rest[1])
+			if perr != nil {
+				return 0, 0, nil, fmt.Errorf("invalid depth: %v", perr)
+			}
+			depth = v
+		}
+		if len(rest) >= 3 {
+			v, perr := parseU16(rest[2])
+			if perr != nil {
+				return 0, 0, nil, fmt.Errorf("invalid start: %v", perr)
+			}
+			start = v
+		}
+		if len(rest) >= 4 {
+			v, perr := parseU16(rest[3])
+			if perr != nil {
+				return 0, 0, nil, fmt.Errorf("invalid max: %v", perr)
+			}
+			max = v
+		}
+		e.WriteString(rest[0])
+		e.WriteU8(depth)
+		e.WriteU16(start)
+		e.WriteU16(max)
+		payload = e.Bytes()
+
+	case "imgchanges":
+		op = proto.OpIMAGE_CHANGES
+		if len(rest) < 1 || len(rest) > 3 {
+			return 0, 0, nil, fmt.Errorf("usage: imgchanges <image> [since_unix] [start]")
+		}
+		since := uint32(0)
+		start := uint16(0)
+		if len(rest) >= 2 {
+			v, perr := parseU32(rest[1])
+			if perr != nil {
+				return 0, 0, nil, fmt.Errorf("invalid since: %v", perr)
+			}
+			since = v
+		}
+		if len(rest) >= 3 {
+			v, perr := parseU16(rest[2])
+			if perr != nil {
+				return 0, 0, nil, fmt.Errorf("invalid start: %v", perr)
+			}
+			start = v
+		}
+		e.WriteString(rest[0])
+		e.WriteU32(since)
+		e.WriteU16(start)
+		payload = e.Bytes()
+
+	case "exists":
+		op = proto.OpEXISTS_EXACT
+		if len(rest) != 1 {
+			return 0, 0, nil, fmt.Errorf("usage: exists <path>")
+		}
+		e.WriteString(rest[0])
+		payload = e.Bytes()
+
+	case "progress":
+		op = proto.OpPROGRESS
+		if len(rest) != 1 {
+			return 0, 0, nil, fmt.Errorf("usage: progress <op_id>")
+		}
+		id, err := strconv.ParseUint(rest[0], 10, 32)
+		if err != nil {
+			return 0, 0, nil, fmt.Errorf("bad op_id: %v", err)
+		}
+		e.WriteU32(uint32(id))
+		payload = e.Bytes()
+
+	case "verify":
+		op = proto.OpVERIFY
+		if len(rest) != 1 {
+			return 0, 0, nil, fmt.Errorf("usage: verify <image>")
+		}
+		e.WriteString(rest[0])
+		payload = e.Bytes()
+
+	case "trash-ls":
+		op = proto.OpTRASH_LS
+		start := uint16(0)
+		max := uint16(0)
+		if len(rest) > 2 {
+			return 0, 0, nil, fmt.Errorf("usage: trash-ls [start] [max]")
+		}
+		if len(rest) >= 1 {
+			v, perr := parseU16(rest[0])
+			if perr != nil {
+				return 0, 0, nil, fmt.Errorf("invalid start: %v", perr)
+			}
+			start = v
+		}
+		if len(rest) == 2 {
+			v, perr := parseU16(rest[1])
+			if perr != nil {
+				return 0, 0, nil, fmt.Errorf("invalid max: %v", perr)
+			}
+			max = v
+		}
+		e.WriteU16(start)
+		e.WriteU16(max)
+		payload = e.Bytes()
+
+	case "dirhash":
+		op = proto.OpDIRHASH
+		// dirhash supports opts: -r
+		var err error
+		rest, err = takeOpts(map[string]byte{
+			"-r":          proto.FlagDH_RECURSIVE,
+			"--recursive": proto.FlagDH_RECURSIVE,
+		}, rest)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		if len(rest) != 1 {
+			return 0, 0, nil, fmt.Errorf("usage: dirhash [-r] <path>")
+		}
+		e.WriteString(rest[0])
+		payload = e.Bytes()
+
+	case "trash-restore":
+		op = proto.OpTRASH_RESTORE
+		var err error
+		rest, err = takeOpts(map[string]byte{
+			"-o":          proto.FlagTRR_OVERWRITE,
+			"--overwrite": proto.FlagTRR_OVERWRITE,
+		}, rest)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		if len(rest) < 1 || len(rest) > 2 {
+			return 0, 0, nil, fmt.Errorf("usage: trash-restore [-o] <id> [path]")
+		}
+		_ = e.WriteString(rest[0])
+		dst := ""
+		if len(rest) == 2 {
+			dst = rest[1]
+		}
+		if err := e.WriteString(dst); err != nil {
+			return 0, 0, nil, err
+		}
+		payload = e.Bytes()
+
+	case "statmany":
+		op = proto.OpSTAT_MANY
+		if len(rest) < 1 {
+			return 0, 0, nil, fmt.Errorf("usage: statmany <path> [path...]")
+		}
+		e.WriteU16(uint16(len(rest)))
+		for _, p := range rest {
+			if err := e.WriteString(p); err != nil {
+				return 0, 0, nil, err
+			}
+		}
+		payload = e.Bytes()
+
+	case "mkimage":
+		op = proto.OpMKIMAGE
+		var err error
+		rest, err = takeOpts(map[string]byte{
+			"-o":          proto.FlagMI_OVERWRITE,
+			"--overwrite": proto.FlagMI_OVERWRITE,
+		}, rest)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		if len(rest) < 2 || len(rest) > 4 {
+			return 0, 0, nil, fmt.Errorf("usage: mkimage [-o] <path> <d64|d71|d81> [name] [id]")
+		}
+		kind, ok := imageKindByName(rest[1])
+		if !ok {
+			return 0, 0, nil, fmt.Errorf("unknown image kind %q (d64, d71 or d81)", rest[1])
+		}
+		name, id := "", ""
+		if len(rest) > 2 {
+			name = rest[2]
+		}
+		if len(rest) > 3 {
+			id = rest[3]
+		}
+		if err := e.WriteString(rest[0]); err != nil {
+			return 0, 0, nil, err
+		}
+		e.WriteU8(kind)
+		_ = e.WriteString(name)
+		_ = e.WriteString(id)
+		payload = e.Bytes()
+
+	case "whoami":
+		op = proto.OpWHOAMI
+		if len(rest) != 0 {
+			return 0, 0, nil, fmt.Errorf("usage: whoami")
+		}
+
+	case "images":
+		op = proto.OpIMAGES
+		var err error
+		rest, err = takeOpts(map[string]byte{
+			"-r":          proto.FlagIM_RECURSIVE,
+			"--recursive": proto.FlagIM_RECURSIVE,
+		}, rest)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		if len(rest) > 2 {
+			return 0, 0, nil, fmt.Errorf("usage: images [-r] [path] [start]")
+		}
+		p := "/"
+		if len(rest) >= 1 {
+			p = rest[0]
+		}
+		start := uint16(0)
+		if len(rest) == 2 {
+			v, perr := parseU16(rest[1])
+			if perr != nil {
+				return 0, 0, nil, fmt.Errorf("invalid start: %v", perr)
+			}
+			start = v
+		}
+		_ = e.WriteString(p)
+		e.WriteU16(start)
+		payload = e.Bytes()
+
+	default:
+		return 0, 0, nil, fmt.Errorf("unknown command: %s", cmd)
+	}
+
+	return op, flags, payload, nil
+}
+
+func splitArgs(s string) ([]string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+
+	var args []string
+	var cur strings.Builder
+	inQuotes := false
+	escaped := false
+
+	flush := func() {
+		if cur.Len() > 0 {
+			args = append(args, cur.String())
+			cur.Reset()
+		}
+	}
+
+	for _, r := range s {
+		if escaped {
+			cur.WriteRune(r)
+			escaped = false
+			continue
+		}
+		switch r {
+		case '\\':
+			escaped = true
+		case '"':
+			inQuotes = !inQuotes
+		case ' ', '\t', '\n', '\r':
+			if inQuotes {
+				cur.WriteRune(r)
+			} else {
+				flush()
+			}
+		default:
+			cur.WriteRune(r)
+		}
+	}
+
+	if escaped {
+		// dangling backslash; keep it.
+		cur.WriteRune('\\')
+	}
+
+	if inQuotes {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+
+	flush()
+	return args, nil
+}
+
+func parseU16(s string) (uint16, error) {
+	v, err := parseUint(s, 16)
+	if err != nil {
+		return 0, err
+	}
+	if v > 0xFFFF {
+		return 0, fmt.Errorf("out of range")
+	}
+	return uint16(v), nil
+}
+
+func parseU32(s string) (uint32, error) {
+	v, err := parseUint(s, 32)
+	if err != nil {
+		return 0, err
+	}
+	if v > 0xFFFFFFFF {
+		return 0, fmt.Errorf("out of range")
+	}
+	return uint32(v), nil
+}
+
+func parseByte(s string) (byte, error) {
+	v, err := parseUint(s, 8)
+	if err != nil {
+		return 0, err
+	}
+	if v > 0xFF {
+		return 0, fmt.Errorf("out of range")
+	}
+	return byte(v), nil
+}
+
+func parseUint(s string, bits int) (uint64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("empty")
+	}
+
+	base := 10
+	if strings.HasPrefix(strings.ToLower(s), "0x") {
+		base = 16
+		s = s[2:]
+	}
+
+	return strconv.ParseUint(s, base, bits)
+}
+
+func decodeData(s, enc string) ([]byte, error) {
+	enc = strings.ToLower(strings.TrimSpace(enc))
+	if enc == "" {
+		enc = "text"
+	}
+
+	switch enc {
+	case "text", "utf8", "utf-8":
+		return []byte(s), nil
+
+	case "hex":
+		cleaned := strings.ReplaceAll(strings.TrimSpace(s), " ", "")
+		cleaned = strings.ReplaceAll(cleaned, "\n", "")
+		cleaned = strings.ReplaceAll(cleaned, "\r", "")
+		cleaned = strings.ReplaceAll(cleaned, "\t", "")
+		if cleaned == "" {
+			return nil, nil
+		}
+		if len(cleaned)%2 != 0 {
+			return nil, fmt.Errorf("hex length must be even")
+		}
+		b, err := hex.DecodeString(cleaned)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex: %v", err)
+		}
+		return b, nil
+
+	case "base64", "b64":
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return nil, nil
+		}
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64: %v", err)
+		}
+		return b, nil
+
+	default:
+		return nil, fmt.Errorf("unknown data_enc: %s", enc)
+	}
 }
 
 type prettyDecoder struct {
@@ -812,7 +1413,16 @@ func (p *prettyDecoder) ReadBytes(n int) []byte {
 	return v
 }
 
+func (p *prettyDecoder) Remaining() int {
+	return p.d.Remaining()
+}
+
 func opsPretty(op byte, status byte, resp []byte, errMsg string) string {
+	if op == proto.OpWRITE_RANGE && status == proto.StatusAlreadyExists && len(resp) == 6 {
+		code := binary.LittleEndian.Uint32(resp)
+		sec := binary.LittleEndian.Uint16(resp[4:])
+		return fmt.Sprintf("%s: repeat within %ds with --confirm=%d", choose(errMsg != "", errMsg, "overwrite needs confirm"), sec, code)
+	}
 	if status != proto.StatusOK {
 		if errMsg != "" {
 			return errMsg
@@ -820,8 +1430,30 @@ func opsPretty(op byte, status byte, resp []byte, errMsg string) string {
 		return fmt.Sprintf("%s (%d)", statusName(status), status)
 	}
 
+	if op == proto.OpREAD_RANGE && errMsg == notModifiedMsg {
+		return "not modified (CRC matches, nothing read)"
+	}
+
 	d := newPrettyDecoder(resp)
 
+	if errMsg == dryRunMsg && isDryRunOp(op) {
+		count := d.ReadU16()
+		listed := d.ReadU16()
+		lines := []string{fmt.Sprintf("dry run, nothing changed: %d path(s) affected", count)}
+		for i := 0; i < int(listed) && d.Err == nil; i++ {
+			if p := d.ReadString(); d.Err == nil {
+				lines = append(lines, "  "+p)
+			}
+		}
+		if d.Err != nil {
+			return fmt.Sprintf("decode error: %v", d.Err)
+		}
+		if int(count) > int(listed) {
+			lines = append(lines, fmt.Sprintf("  ... (+%d more)", int(count)-int(listed)))
+		}
+		return strings.Join(lines, "\n")
+	}
+
 	switch op {
 	case proto.OpCAPS:
 		maxChunk := d.ReadU16()
@@ -829,35 +1461,36 @@ func opsPretty(op byte, status byte, resp []byte, errMsg string) string {
 		maxPath := d.ReadU16()
 		maxName := d.ReadU16()
 		maxEntries := d.ReadU16()
-		feats := d.ReadU32()
+		feats := uint64(d.ReadU32())
 		srvTime := d.ReadU32()
 		srvName := d.ReadString()
 		if d.Err != nil {
 			return fmt.Sprintf("decode error: %v", d.Err)
 		}
+		maxDecompressed := "-"
+		if d.Remaining() >= 2 {
+			maxDecompressed = strconv.Itoa(int(d.ReadU16()))
+		}
+		if d.Remaining() >= 4 {
+			feats |= uint64(d.ReadU32()) << 32
+		}
+		previewLen := "-"
+		if d.Remaining() >= 2 {
+			previewLen = strconv.Itoa(int(d.ReadU16()))
+		}
 
 		var featNames []string
-		add := func(bit uint32, name string) {
-			if feats&bit != 0 {
-				featNames = append(featNames, name)
-			}
-		}
-		add(proto.FeatSTATFS, "STATFS")
-		add(proto.FeatAPPEND, "APPEND")
-		add(proto.FeatSEARCH, "SEARCH")
-		add(proto.FeatHASH_CRC32, "HASH_CRC32")
-		add(proto.FeatHASH_SHA1, "HASH_SHA1")
-		add(proto.FeatMKDIR_PARENTS, "MKDIR_PARENTS")
-		add(proto.FeatRMDIR_RECURSIVE, "RMDIR_RECURSIVE")
-		add(proto.FeatCP_RECURSIVE, "CP_RECURSIVE")
-		add(proto.FeatOVERWRITE, "OVERWRITE")
-		add(proto.FeatERRMSG, "ERRMSG")
+		for _, f := range proto.FeatureNames {
+			if feats&f.Bit != 0 {
+				featNames = append(featNames, f.Name)
+			}
+		}
 
 		t := time.Unix(int64(srvTime), 0).UTC()
 
 		return fmt.Sprintf(
-			"max_chunk=%d\nmax_payload=%d\nmax_path=%d\nmax_name=%d\nmax_entries=%d\nfeatures=0x%08X\nfeatures_list=%s\nserver_time=%s\nserver_name=%s",
-			maxChunk, maxPayload, maxPath, maxName, maxEntries,
+			"max_chunk=%d\nmax_payload=%d\nmax_path=%d\nmax_name=%d\nmax_entries=%d\nmax_decompressed=%s\npreview_len=%s\nfeatures=0x%016X\nfeatures_list=%s\nserver_time=%s\nserver_name=%s",
+			maxChunk, maxPayload, maxPath, maxName, maxEntries, maxDecompressed, previewLen,
 			feats,
 			strings.Join(featNames, ","),
 			t.Format(time.RFC3339),
@@ -887,9 +1520,12 @@ func opsPretty(op byte, status byte, resp []byte, errMsg string) string {
 			}
 			t := time.Unix(int64(mtime), 0).UTC()
 			kind := "F"
-			if typ == 1 {
+			if typ&^proto.LSEntryTruncated == 1 {
 				kind = "D"
 			}
+			if typ&proto.LSEntryTruncated != 0 {
+				name += " (truncated)"
+			}
 			lines = append(lines, fmt.Sprintf("%s %10d %s %s", kind, size, t.Format("2006-01-02 15:04:05"), name))
 		}
 		next := d.ReadU16()
@@ -913,6 +1549,12 @@ func opsPretty(op byte, status byte, resp []byte, errMsg string) string {
 		return fmt.Sprintf("type=%s\nsize=%d\nmtime=%s", kind, size, t.Format(time.RFC3339))
 
 	case proto.OpHASH:
+		if len(resp) == 32 {
+			return fmt.Sprintf("sha256=%x", resp)
+		}
+		if len(resp) == 2 {
+			return fmt.Sprintf("crc16=0x%04X", d.ReadU16())
+		}
 		crc := d.ReadU32()
 		if d.Err != nil {
 			return fmt.Sprintf("decode error: %v", d.Err)
@@ -953,6 +1595,395 @@ func opsPretty(op byte, status byte, resp []byte, errMsg string) string {
 		s = strings.ReplaceAll(s, "\n", "\\n")
 		return fmt.Sprintf("bytes=%d\npreview=%s", len(resp), s)
 
+	case proto.OpDIRMTIME:
+		dirMt := d.ReadU32()
+		maxMt := d.ReadU32()
+		n := d.ReadU16()
+		fl := d.ReadU8()
+		if d.Err != nil {
+			return fmt.Sprintf("decode error: %v", d.Err)
+		}
+		return fmt.Sprintf("dir_mtime=%s\nmax_mtime=%s\nentries=%d\ntruncated=%v",
+			time.Unix(int64(dirMt), 0).UTC().Format(time.RFC3339), time.Unix(int64(maxMt), 0).UTC().Format(time.RFC3339), n, fl&0x01 != 0)
+
+	case proto.OpSTRINGS:
+		cnt := d.ReadU16()
+		lines := make([]string, 0, int(cnt)+2)
+		lines = append(lines, fmt.Sprintf("count=%d", cnt))
+		for i := 0; i < int(cnt); i++ {
+			off := d.ReadU32()
+			ln := d.ReadU16()
+			run := d.ReadBytes(int(ln))
+			if d.Err != nil {
+				return fmt.Sprintf("decode error: %v", d.Err)
+			}
+			lines = append(lines, fmt.Sprintf("%08X  %s", off, string(run)))
+		}
+		next := d.ReadU32()
+		if d.Err == nil {
+			if next == 0xFFFFFFFF {
+				lines = append(lines, "next=END")
+			} else {
+				lines = append(lines, fmt.Sprintf("next=%d", next))
+			}
+		}
+		return strings.Join(lines, "\n")
+
+	case proto.OpTREE:
+		cnt := d.ReadU16()
+		tr := d.ReadU8()
+		lines := make([]string, 0, int(cnt)+1)
+		lines = append(lines, fmt.Sprintf("count=%d truncated=%v", cnt, tr != 0))
+		for i := 0; i < int(cnt); i++ {
+			depth := d.ReadU8()
+			typ := d.ReadU8()
+			children := d.ReadU16()
+			name := d.ReadString()
+			if d.Err != nil {
+				return fmt.Sprintf("decode error: %v", d.Err)
+			}
+			line := strings.Repeat("  ", int(depth)) + name
+			if typ == 1 {
+				line += "/"
+				if children == 0xFFFF {
+					line += " (?)"
+				} else {
+					line += fmt.Sprintf(" (%d)", children)
+				}
+			}
+			lines = append(lines, line)
+		}
+		return strings.Join(lines, "\n")
+
+	case proto.OpREAD_TAIL:
+		if len(resp) < 4 {
+			return fmt.Sprintf("short payload (%d)", len(resp))
+		}
+		off := binary.LittleEndian.Uint32(resp[0:4])
+		prev := resp[4:]
+		if len(prev) > 256 {
+			prev = prev[:256]
+		}
+		s := string(prev)
+		s = strings.ReplaceAll(s, "\r", "\\r")
+		s = strings.ReplaceAll(s, "\n", "\\n")
+		return fmt.Sprintf("start_offset=%d bytes=%d\npreview=%s", off, len(resp)-4, s)
+
+	case proto.OpTOKEN_NAMES:
+		cnt := d.ReadU16()
+		lines := make([]string, 0, int(cnt)+1)
+		for i := 0; i < int(cnt); i++ {
+			en := d.ReadU8()
+			name := d.ReadString()
+			if d.Err != nil {
+				return fmt.Sprintf("decode error: %v", d.Err)
+			}
+			if en == 0 {
+				name += " (disabled)"
+			}
+			lines = append(lines, name)
+		}
+		next := d.ReadU16()
+		if next == 0xFFFF {
+			lines = append(lines, "next_index=END")
+		} else {
+			lines = append(lines, fmt.Sprintf("next_index=%d", next))
+		}
+		return strings.Join(lines, "\n")
+
+	case proto.OpMKTEMP:
+		p := d.ReadString()
+		if d.Err != nil {
+			return fmt.Sprintf("decode error: %v", d.Err)
+		}
+		return "path=" + p
+
+	case proto.OpBATCH:
+		n := d.ReadU8()
+		lines := []string{fmt.Sprintf("count=%d", n)}
+		for i := 0; i < int(n); i++ {
+			ln := d.ReadU16()
+			b := d.ReadBytes(int(ln))
+			if d.Err != nil || len(b) < 1 {
+				return fmt.Sprintf("decode error: %v", d.Err)
+			}
+			lines = append(lines, fmt.Sprintf("[%d] %s (%d bytes)", i, statusName(b[0]), len(b)-1))
+		}
+		return strings.Join(lines, "\n")
+
+	case proto.OpECHO:
+		delay := d.ReadU16()
+		data := d.ReadBytes(len(resp) - 2)
+		if d.Err != nil {
+			return fmt.Sprintf("decode error: %v", d.Err)
+		}
+		return fmt.Sprintf("delay_ms=%d\ndata=%q", delay, data)
+
+	case proto.OpLOCK:
+		ttl := d.ReadU32()
+		if d.Err != nil {
+			return fmt.Sprintf("decode error: %v", d.Err)
+		}
+		if ttl == 0 {
+			return "locked (no expiry)"
+		}
+		return fmt.Sprintf("locked (expires after %ds without refresh)", ttl)
+
+	case proto.OpCOPY_RANGE:
+		n := d.ReadU32()
+		if d.Err != nil {
+			return fmt.Sprintf("decode error: %v", d.Err)
+		}
+		return fmt.Sprintf("copied=%d", n)
+
+	case proto.OpMOTD:
+		m := d.ReadString()
+		if d.Err != nil {
+			return fmt.Sprintf("decode error: %v", d.Err)
+		}
+		if m == "" {
+			return "(no motd)"
+		}
+		return m
+
+	case proto.OpSAMEFILE:
+		same := d.ReadU8()
+		if d.Err != nil {
+			return fmt.Sprintf("decode error: %v", d.Err)
+		}
+		return fmt.Sprintf("same=%t", same != 0)
+
+	case proto.OpLS_TREE:
+		cnt := d.ReadU16()
+		lines := make([]string, 0, int(cnt)+2)
+		lines = append(lines, fmt.Sprintf("count=%d", cnt))
+		for i := 0; i < int(cnt); i++ {
+			typ := d.ReadU8()
+			size := d.ReadU32()
+			_ = d.ReadU32()
+			name := d.ReadString()
+			depth := d.ReadU8()
+			_ = d.ReadString()
+			if d.Err != nil {
+				return fmt.Sprintf("decode error: %v", d.Err)
+			}
+			line := strings.Repeat("  ", int(depth)) + name
+			if typ == 1 {
+				line += "/"
+			} else {
+				line += fmt.Sprintf(" (%d)", size)
+			}
+			lines = append(lines, line)
+		}
+		next := d.ReadU16()
+		if next == 0xFFFF {
+			lines = append(lines, "next_index=END")
+		} else {
+			lines = append(lines, fmt.Sprintf("next_index=%d", next))
+		}
+		return strings.Join(lines, "\n")
+
+	case proto.OpIMAGE_CHANGES:
+		mtime := d.ReadU32()
+		state := d.ReadU8()
+		cnt := d.ReadU16()
+		states := []string{"current", "no index", "stale"}
+		st := fmt.Sprintf("%d", state)
+		if int(state) < len(states) {
+			st = states[state]
+		}
+		lines := make([]string, 0, int(cnt)+2)
+		lines = append(lines, fmt.Sprintf("image_mtime=%d index=%s count=%d", mtime, st, cnt))
+		for i := 0; i < int(cnt); i++ {
+			fl := d.ReadU8()
+			changed := d.ReadU32()
+			p := d.ReadString()
+			if d.Err != nil {
+				return fmt.Sprintf("decode error: %v", d.Err)
+			}
+			line := fmt.Sprintf("%d %s", changed, p)
+			if fl&2 != 0 {
+				line += "/"
+			}
+			if fl&1 != 0 {
+				line += " (deleted)"
+			}
+			lines = append(lines, line)
+		}
+		next := d.ReadU16()
+		if next == 0xFFFF {
+			lines = append(lines, "next_index=END")
+		} else {
+			lines = append(lines, fmt.Sprintf("next_index=%d", next))
+		}
+		return strings.Join(lines, "\n")
+
+	case proto.OpEXISTS_EXACT:
+		typ := d.ReadU8()
+		size := d.ReadU32()
+		mtime := d.ReadU32()
+		if d.Err != nil {
+			return fmt.Sprintf("decode error: %v", d.Err)
+		}
+		if typ == 1 {
+			return fmt.Sprintf("exists: DIR mtime=%d", mtime)
+		}
+		return fmt.Sprintf("exists: FILE size=%d mtime=%d", size, mtime)
+
+	case proto.OpCP:
+		id := d.ReadU32()
+		if d.Err != nil {
+			return fmt.Sprintf("decode error: %v", d.Err)
+		}
+		return fmt.Sprintf("started async copy, op_id=%d (poll: progress %d)", id, id)
+
+	case proto.OpPROGRESS:
+		state := d.ReadU8()
+		st := d.ReadU8()
+		copied := d.ReadU32()
+		total := d.ReadU32()
+		msg := d.ReadString()
+		if d.Err != nil {
+			return fmt.Sprintf("decode error: %v", d.Err)
+		}
+		if state == 0 {
+			return fmt.Sprintf("running: %d/%d bytes", copied, total)
+		}
+		if st != proto.StatusOK {
+			return fmt.Sprintf("done: %s %s (%d/%d bytes)", statusName(st), msg, copied, total)
+		}
+		return fmt.Sprintf("done: OK (%d/%d bytes)", copied, total)
+
+	case proto.OpVERIFY:
+		problems := d.ReadU16()
+		files := d.ReadU16()
+		summary := d.ReadString()
+		if d.Err != nil {
+			return fmt.Sprintf("decode error: %v", d.Err)
+		}
+		return fmt.Sprintf("problems=%d files=%d\n%s", problems, files, summary)
+
+	case proto.OpTRASH_LS:
+		cnt := d.ReadU16()
+		lines := []string{fmt.Sprintf("count=%d", cnt)}
+		for i := 0; i < int(cnt); i++ {
+			et := d.ReadU8()
+			sz := d.ReadU32()
+			deleted := d.ReadU32()
+			id := d.ReadString()
+			p := d.ReadString()
+			if d.Err != nil {
+				break
+			}
+			if et == 1 {
+				p += "/"
+			}
+			lines = append(lines, fmt.Sprintf("%s  %s  %d  %s", id, time.Unix(int64(deleted), 0).UTC().Format(time.RFC3339), sz, p))
+		}
+		next := d.ReadU16()
+		if d.Err != nil {
+			return fmt.Sprintf("decode error: %v", d.Err)
+		}
+		lines = append(lines, fmt.Sprintf("next_index=%d", next))
+		return strings.Join(lines, "\n")
+
+	case proto.OpDIRHASH:
+		lo := d.ReadU32()
+		hi := d.ReadU32()
+		n := d.ReadU32()
+		if d.Err != nil {
+			return fmt.Sprintf("decode error: %v", d.Err)
+		}
+		return fmt.Sprintf("hash=%08x%08x entries=%d", hi, lo, n)
+
+	case proto.OpTRASH_RESTORE:
+		p := d.ReadString()
+		if d.Err != nil {
+			return fmt.Sprintf("decode error: %v", d.Err)
+		}
+		return "restored to " + p
+
+	case proto.OpSTAT_MANY:
+		n := d.ReadU16()
+		lines := []string{fmt.Sprintf("count=%d", n)}
+		for i := 0; i < int(n); i++ {
+			st := d.ReadU8()
+			typ := d.ReadU8()
+			size := d.ReadU32()
+			mtime := d.ReadU32()
+			if d.Err != nil {
+				return fmt.Sprintf("decode error: %v", d.Err)
+			}
+			if st != proto.StatusOK {
+				lines = append(lines, fmt.Sprintf("[%d] %s", i, statusName(st)))
+				continue
+			}
+			kind := "file"
+			if typ == 1 {
+				kind = "dir"
+			}
+			lines = append(lines, fmt.Sprintf("[%d] %s size=%d mtime=%s", i, kind, size, time.Unix(int64(mtime), 0).UTC().Format(time.RFC3339)))
+		}
+		next := d.ReadU16()
+		if d.Err != nil {
+			return fmt.Sprintf("decode error: %v", d.Err)
+		}
+		if next != 0xFFFF {
+			lines = append(lines, fmt.Sprintf("next_index=%d", next))
+		}
+		return strings.Join(lines, "\n")
+
+	case proto.OpMKIMAGE:
+		size := d.ReadU32()
+		if d.Err != nil {
+			return fmt.Sprintf("decode error: %v", d.Err)
+		}
+		return fmt.Sprintf("created, size=%d", size)
+
+	case proto.OpWHOAMI:
+		fl := d.ReadU8()
+		quota := d.ReadU32()
+		used := d.ReadU32()
+		maxFile := d.ReadU32()
+		if d.Err != nil {
+			return fmt.Sprintf("decode error: %v", d.Err)
+		}
+		return fmt.Sprintf("read_only=%t quota_full=%t\nquota=%d used=%d max_file=%d\ndisk_images=%t disk_images_write=%t",
+			fl&proto.WhoReadOnly != 0, fl&proto.WhoQuotaFull != 0, quota, used, maxFile,
+			fl&proto.WhoDiskImages != 0, fl&proto.WhoDiskImagesWrite != 0)
+
+	case proto.OpIMAGES:
+		cnt := d.ReadU16()
+		lines := make([]string, 0, int(cnt)+1)
+		for i := 0; i < int(cnt); i++ {
+			p := d.ReadString()
+			kind := d.ReadU8()
+			size := d.ReadU32()
+			free := d.ReadU16()
+			fl := d.ReadU8()
+			age := d.ReadU32()
+			if d.Err != nil {
+				return fmt.Sprintf("decode error: %v", d.Err)
+			}
+			line := fmt.Sprintf("%s  %s  size=%d free=%s", p, imageKindName(kind), size, choose(free == 0xFFFF, "?", strconv.Itoa(int(free))))
+			if fl&proto.ImgBusy != 0 {
+				line += " BUSY"
+			}
+			if fl&proto.ImgLocked != 0 {
+				line += " LOCKED"
+			}
+			if age != 0xFFFFFFFF {
+				line += fmt.Sprintf(" last_write=%ds ago", age)
+			}
+			lines = append(lines, line)
+		}
+		next := d.ReadU16()
+		if d.Err != nil {
+			return fmt.Sprintf("decode error: %v", d.Err)
+		}
+		lines = append(lines, fmt.Sprintf("count=%d next=%s", cnt, choose(next == 0xFFFF, "(end)", strconv.Itoa(int(next)))))
+		return strings.Join(lines, "\n")
+
 	default:
 		// For most ops the response is empty.
 		if len(resp) == 0 {
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"wicos64-server/internal/config"
+)
+
+func TestClientIP(t *testing.T) {
+	cfg := config.Default()
+	cfg.BasePath = t.TempDir()
+	cfg.TrustedProxies = []string{"10.0.0.0/8", "192.168.1.1"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		realIP string
+		want   string
+	}{
+		{"direct", "203.0.113.7:1234", nil, "", "203.0.113.7"},
+		{"spoofed XFF from an untrusted peer", "203.0.113.7:1234", []string{"1.2.3.4"}, "", "203.0.113.7"},
+		{"spoofed X-Real-IP from an untrusted peer", "203.0.113.7:1234", nil, "1.2.3.4", "203.0.113.7"},
+		{"client behind a trusted proxy", "10.1.2.3:80", []string{"198.51.100.9"}, "", "198.51.100.9"},
+		{"chain of trusted proxies", "10.1.2.3:80", []string{"198.51.100.9, 192.168.1.1", "10.9.9.9"}, "", "198.51.100.9"},
+		{"client-supplied hop before the first untrusted one", "10.1.2.3:80", []string{"1.2.3.4, 198.51.100.9"}, "", "198.51.100.9"},
+		{"malformed hop ends the walk", "10.1.2.3:80", []string{"198.51.100.9, garbage, 10.9.9.9"}, "", "10.9.9.9"},
+		{"malformed last hop keeps the peer", "10.1.2.3:80", []string{"garbage"}, "", "10.1.2.3"},
+		{"X-Real-IP fallback", "10.1.2.3:80", nil, " 198.51.100.9 ", "198.51.100.9"},
+		{"malformed X-Real-IP", "10.1.2.3:80", nil, "nope", "10.1.2.3"},
+		{"XFF wins over X-Real-IP", "10.1.2.3:80", []string{"198.51.100.9"}, "1.2.3.4", "198.51.100.9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := clientIP(cfg, r); got != tt.want {
+				t.Fatalf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
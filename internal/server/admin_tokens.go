@@ -3,12 +3,14 @@ package server
 import (
 	"fmt"
 	"hash/crc32"
+	"math"
 	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 	"time"
+	"wicos64-server/internal/config"
 	"wicos64-server/internal/version"
 )
 
@@ -21,14 +23,32 @@ type adminTokenStatus struct {
 	Ignored     bool   `json:"ignored,omitempty"` // present in config but not effective due to precedence
 	RootAbs     string `json:"root_abs"`
 	ReadOnly    bool   `json:"read_only"`
+	ROWhenFull  bool   `json:"readonly_when_full,omitempty"`
 	QuotaBytes  uint64 `json:"quota_bytes"`
 	MaxFileByte uint64 `json:"max_file_bytes"`
+	MaxFiles    uint64 `json:"max_files,omitempty"`
 
 	UsedBytes      uint64 `json:"used_bytes,omitempty"`
 	TmpBytes       uint64 `json:"tmp_bytes,omitempty"`
 	RemainingBytes uint64 `json:"remaining_bytes,omitempty"`
 	UsedPct        int    `json:"used_pct,omitempty"`
+	UsedFiles      uint64 `json:"used_files,omitempty"`
 	Error          string `json:"error,omitempty"`
+
+	RateLimit      float64  `json:"rate_limit_per_sec,omitempty"`
+	RateBucket     *float64 `json:"rate_bucket,omitempty"`       // requests currently available
+	MaxBytesPerSec uint64   `json:"max_bytes_per_sec,omitempty"` // bandwidth limit
+
+	AllowCIDRs []string `json:"allow_cidrs,omitempty"`
+	DenyCIDRs  []string `json:"deny_cidrs,omitempty"`
+	Endpoint   string   `json:"endpoint,omitempty"`
+	PathPrefix string   `json:"path_prefix,omitempty"`
+
+	ValidFrom    int64  `json:"valid_from_unix,omitempty"`
+	ValidUntil   int64  `json:"valid_until_unix,omitempty"`
+	Pending      bool   `json:"pending,omitempty"`       // before valid_from_unix
+	Expired      bool   `json:"expired,omitempty"`       // after valid_until_unix
+	RemainingSec *int64 `json:"remaining_sec,omitempty"` // until valid_until_unix (0 once expired)
 }
 
 type adminTokensResponse struct {
@@ -68,7 +88,14 @@ func (s *Server) handleAdminTokens(w http.ResponseWriter, r *http.Request) {
 		if t.Enabled != nil {
 			enabled = *t.Enabled
 		}
-		st := adminTokenStatus{Kind: "token", Name: t.Name, TokenMask: maskToken(t.Token), TokenID: tokenID(t.Token), Enabled: enabled}
+		st := adminTokenStatus{Kind: "token", Name: t.Name, TokenMask: maskToken(t.Token), TokenID: tokenID(t.Token), Enabled: enabled, ROWhenFull: t.ReadOnlyWhenFull, AllowCIDRs: t.AllowCIDRs, DenyCIDRs: t.DenyCIDRs, Endpoint: t.Endpoint, PathPrefix: t.PathPrefix}
+		st.ValidFrom, st.ValidUntil = t.ValidFromUnix, t.ValidUntilUnix
+		st.Pending = config.TokenPending(t.ValidFromUnix, resp.TSUnix)
+		st.Expired = config.TokenExpired(t.ValidUntilUnix, resp.TSUnix)
+		if t.ValidUntilUnix > 0 {
+			rem := max(t.ValidUntilUnix-resp.TSUnix, 0)
+			st.RemainingSec = &rem
+		}
 		ctx, ok := cfg.ResolveTokenContext(t.Token)
 		if ok {
 			rootAbs, err := filepath.Abs(ctx.Root)
@@ -81,6 +108,9 @@ func (s *Server) handleAdminTokens(w http.ResponseWriter, r *http.Request) {
 			st.ReadOnly = ctx.ReadOnly
 			st.QuotaBytes = ctx.QuotaBytes
 			st.MaxFileByte = ctx.MaxFileBytes
+			st.MaxFiles = ctx.MaxFiles
+			st.RateLimit = ctx.RateLimitPerSec
+			st.MaxBytesPerSec = ctx.MaxBytesPerSec
 		} else {
 			// Disabled token or mismatch; still show configured root.
 			rootAbs, err := filepath.Abs(t.Root)
@@ -92,6 +122,7 @@ func (s *Server) handleAdminTokens(w http.ResponseWriter, r *http.Request) {
 			st.ReadOnly = t.ReadOnly || cfg.GlobalReadOnly
 			st.QuotaBytes = minNonZeroU64(t.QuotaBytes, cfg.GlobalQuotaBytes)
 			st.MaxFileByte = minNonZeroU64(t.MaxFileBytes, cfg.GlobalMaxFileBytes)
+			st.MaxFiles = minNonZeroU64(t.MaxFiles, cfg.GlobalMaxFiles)
 			st.Ignored = !enabled
 		}
 		out = append(out, st)
@@ -119,6 +150,9 @@ func (s *Server) handleAdminTokens(w http.ResponseWriter, r *http.Request) {
 				st.ReadOnly = ctx.ReadOnly
 				st.QuotaBytes = ctx.QuotaBytes
 				st.MaxFileByte = ctx.MaxFileBytes
+				st.MaxFiles = ctx.MaxFiles
+				st.RateLimit = ctx.RateLimitPerSec
+				st.MaxBytesPerSec = ctx.MaxBytesPerSec
 			} else {
 				rootAbs, err := filepath.Abs(root)
 				if err == nil {
@@ -129,6 +163,8 @@ func (s *Server) handleAdminTokens(w http.ResponseWriter, r *http.Request) {
 				st.ReadOnly = cfg.GlobalReadOnly
 				st.QuotaBytes = cfg.GlobalQuotaBytes
 				st.MaxFileByte = cfg.GlobalMaxFileBytes
+				st.MaxFiles = cfg.GlobalMaxFiles
+				st.MaxFiles = cfg.GlobalMaxFiles
 			}
 			out = append(out, st)
 		}
@@ -149,6 +185,9 @@ func (s *Server) handleAdminTokens(w http.ResponseWriter, r *http.Request) {
 			st.ReadOnly = ctx.ReadOnly
 			st.QuotaBytes = ctx.QuotaBytes
 			st.MaxFileByte = ctx.MaxFileBytes
+			st.MaxFiles = ctx.MaxFiles
+			st.RateLimit = ctx.RateLimitPerSec
+			st.MaxBytesPerSec = ctx.MaxBytesPerSec
 		} else {
 			// If ignored, still show base.
 			rootAbs, _ := filepath.Abs(cfg.BasePath)
@@ -156,6 +195,7 @@ func (s *Server) handleAdminTokens(w http.ResponseWriter, r *http.Request) {
 			st.ReadOnly = cfg.GlobalReadOnly
 			st.QuotaBytes = cfg.GlobalQuotaBytes
 			st.MaxFileByte = cfg.GlobalMaxFileBytes
+			st.MaxFiles = cfg.GlobalMaxFiles
 		}
 		out = append(out, st)
 	}
@@ -174,6 +214,9 @@ func (s *Server) handleAdminTokens(w http.ResponseWriter, r *http.Request) {
 		st.ReadOnly = cfg.GlobalReadOnly
 		st.QuotaBytes = cfg.GlobalQuotaBytes
 		st.MaxFileByte = cfg.GlobalMaxFileBytes
+		st.MaxFiles = cfg.GlobalMaxFiles
+		st.RateLimit = cfg.RateLimitPerSec
+		st.MaxBytesPerSec = cfg.MaxBytesPerSec
 		out = append(out, st)
 	}
 
@@ -199,13 +242,18 @@ func (s *Server) handleAdminTokens(w http.ResponseWriter, r *http.Request) {
 		// .TMP share (best-effort).
 		tmpAbs := filepath.Join(st.RootAbs, ".TMP")
 		if fi, terr := os.Lstat(tmpAbs); terr == nil && fi != nil {
-			if tbytes, _, terr2 := pathSizeBytes(tmpAbs); terr2 == nil {
+			if tbytes, _, terr2 := pathSizeBytes(cfg, st.RootAbs, tmpAbs); terr2 == nil {
 				tmpByRoot[st.RootAbs] = tbytes
 			}
 		}
 	}
 
+	now := time.Now()
 	for i := range out {
+		if out[i].RateLimit > 0 {
+			lvl := math.Round(s.rate.level(out[i].TokenID, out[i].RateLimit, now)*100) / 100
+			out[i].RateBucket = &lvl
+		}
 		root := out[i].RootAbs
 		if root == "" {
 			continue
@@ -221,6 +269,11 @@ func (s *Server) handleAdminTokens(w http.ResponseWriter, r *http.Request) {
 				out[i].UsedPct = int((out[i].UsedBytes * 100) / out[i].QuotaBytes)
 			}
 		}
+		if out[i].MaxFiles > 0 {
+			if n, err := s.rootFileCount(root); err == nil {
+				out[i].UsedFiles = n
+			}
+		}
 		if out[i].Error == "" {
 			out[i].Error = errByRoot[root]
 		}
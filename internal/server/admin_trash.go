@@ -0,0 +1,96 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"wicos64-server/internal/proto"
+)
+
+// Admin trash view for one token root (token_kind/token_id as in the file
+// manager). Restores run TRASH_RESTORE through dispatch like the file manager
+// ops, so they show up in the Live Log and follow read-only/overwrite rules.
+//
+//	GET  /admin/api/trash?token_kind=&token_id=
+//	POST /admin/api/trash/restore {token_kind, token_id, id, path, overwrite}
+//	     (path empty = original location)
+
+type adminTrashEntry struct {
+	ID          string `json:"id"`
+	Path        string `json:"path"`
+	Type        string `json:"type"` // file|dir
+	Size        uint64 `json:"size"`
+	DeletedUnix int64  `json:"deleted_unix"`
+}
+
+type adminTrashRestoreRequest struct {
+	TokenKind string `json:"token_kind"`
+	TokenID   string `json:"token_id"`
+	ID        string `json:"id"`
+	Path      string `json:"path"`
+	Overwrite bool   `json:"overwrite"`
+}
+
+func (s *Server) handleAdminTrashList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	t, code, msg := s.adminFSResolve(q.Get("token_kind"), q.Get("token_id"))
+	if code != 0 {
+		http.Error(w, msg, code)
+		return
+	}
+	entries, err := trashEntries(t.cfg, t.rootAbs)
+	if err != nil {
+		writeAdminFSError(w, proto.StatusInternal, err.Error())
+		return
+	}
+	out := make([]adminTrashEntry, 0, len(entries))
+	for _, te := range entries {
+		e := adminTrashEntry{ID: te.ID, Path: te.Path, Type: "file", Size: te.Size, DeletedUnix: te.Deleted.Unix()}
+		if te.IsDir {
+			e.Type = "dir"
+		}
+		out = append(out, e)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "trash_enabled": t.cfg.TrashEnabled, "entries": out})
+}
+
+func (s *Server) handleAdminTrashRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req adminTrashRestoreRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	t, code, msg := s.adminFSResolve(req.TokenKind, req.TokenID)
+	if code != 0 {
+		http.Error(w, msg, code)
+		return
+	}
+
+	e := proto.NewEncoder(len(req.ID) + len(req.Path) + 4)
+	_ = e.WriteString(req.ID)
+	if err := e.WriteString(req.Path); err != nil {
+		writeAdminFSError(w, proto.StatusInvalidPath, err.Error())
+		return
+	}
+	var flags byte
+	if req.Overwrite {
+		flags = proto.FlagTRR_OVERWRITE
+	}
+	st, resp, errMsg := s.adminFSRun(t, proto.OpTRASH_RESTORE, flags, e.Bytes())
+	if st != proto.StatusOK {
+		writeAdminFSError(w, st, errMsg)
+		return
+	}
+	p, _ := proto.NewDecoder(resp).ReadString(0xFFFF)
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "path": p})
+}
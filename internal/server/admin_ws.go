@@ -0,0 +1,272 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Minimal RFC 6455 server side for the live log (/admin/stream/logs/ws), for
+// proxies that buffer text/event-stream. Only what the stream needs: the
+// handshake, unfragmented text frames to the client, ping/pong and close.
+
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xA
+
+	// wsMaxClientFrame bounds frames read from the client (it has nothing to
+	// send but control frames).
+	wsMaxClientFrame = 4096
+	wsPingInterval   = 30 * time.Second
+	wsWriteTimeout   = 10 * time.Second
+)
+
+// wsAcceptKey computes Sec-WebSocket-Accept for a Sec-WebSocket-Key.
+func wsAcceptKey(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// headerHasToken reports whether a comma separated header contains tok
+// (case-insensitive), e.g. Connection: keep-alive, Upgrade.
+func headerHasToken(h http.Header, name, tok string) bool {
+	for _, v := range h.Values(name) {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), tok) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsConn is a server side WebSocket connection. Writes are serialized, as
+// the reader answers pings while the stream writes entries.
+type wsConn struct {
+	c  net.Conn
+	br *bufio.Reader
+
+	mu sync.Mutex
+	bw *bufio.Writer
+}
+
+// wsUpgrade performs the handshake. On failure it writes the HTTP error and
+// returns nil.
+func wsUpgrade(w http.ResponseWriter, r *http.Request) *wsConn {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") || key == "" {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return nil
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil
+	}
+	// Browsers do not apply CORS to WebSockets: only accept pages served by
+	// this host (the CSRF token does not cover GET).
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || !strings.EqualFold(u.Host, r.Host) {
+			http.Error(w, "cross-origin websocket rejected", http.StatusForbidden)
+			return nil
+		}
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil
+	}
+	c, rw, err := hj.Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	_ = c.SetDeadline(time.Time{})
+	ws := &wsConn{c: c, br: rw.Reader, bw: rw.Writer}
+	_, _ = ws.bw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n\r\n")
+	if err := ws.flush(); err != nil {
+		_ = c.Close()
+		return nil
+	}
+	return ws
+}
+
+// writeFrame buffers one unmasked, unfragmented frame. Call flush to send.
+func (ws *wsConn) writeFrame(op byte, payload []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	var hdr [10]byte
+	hdr[0] = 0x80 | op // FIN
+	n := 2
+	switch l := len(payload); {
+	case l < 126:
+		hdr[1] = byte(l)
+	case l <= 0xFFFF:
+		hdr[1] = 126
+		binary.BigEndian.PutUint16(hdr[2:4], uint16(l))
+		n = 4
+	default:
+		hdr[1] = 127
+		binary.BigEndian.PutUint64(hdr[2:10], uint64(l))
+		n = 10
+	}
+	if _, err := ws.bw.Write(hdr[:n]); err != nil {
+		return err
+	}
+	_, err := ws.bw.Write(payload)
+	return err
+}
+
+func (ws *wsConn) flush() error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	_ = ws.c.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return ws.bw.Flush()
+}
+
+// send writes and flushes one frame.
+func (ws *wsConn) send(op byte, payload []byte) error {
+	if err := ws.writeFrame(op, payload); err != nil {
+		return err
+	}
+	return ws.flush()
+}
+
+// readLoop reads client frames until the connection fails or the client
+// closes it: pings are answered, a close frame is echoed, data is dropped.
+func (ws *wsConn) readLoop() error {
+	var hdr [2]byte
+	for {
+		if _, err := io.ReadFull(ws.br, hdr[:]); err != nil {
+			return err
+		}
+		op := hdr[0] & 0x0F
+		masked := hdr[1]&0x80 != 0
+		l := uint64(hdr[1] & 0x7F)
+		switch l {
+		case 126:
+			var b [2]byte
+			if _, err := io.ReadFull(ws.br, b[:]); err != nil {
+				return err
+			}
+			l = uint64(binary.BigEndian.Uint16(b[:]))
+		case 127:
+			var b [8]byte
+			if _, err := io.ReadFull(ws.br, b[:]); err != nil {
+				return err
+			}
+			l = binary.BigEndian.Uint64(b[:])
+		}
+		if !masked || l > wsMaxClientFrame {
+			_ = ws.send(wsOpClose, []byte{0x03, 0xEA}) // 1002 protocol error
+			return errors.New("websocket protocol error")
+		}
+		var mask [4]byte
+		if _, err := io.ReadFull(ws.br, mask[:]); err != nil {
+			return err
+		}
+		payload := make([]byte, l)
+		if _, err := io.ReadFull(ws.br, payload); err != nil {
+			return err
+		}
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+		switch op {
+		case wsOpPing:
+			if err := ws.send(wsOpPong, payload); err != nil {
+				return err
+			}
+		case wsOpClose:
+			_ = ws.send(wsOpClose, payload[:min(len(payload), 2)])
+			return io.EOF
+		}
+	}
+}
+
+// handleAdminLogStreamWS is the WebSocket variant of handleAdminLogStream:
+// one text frame (LogEntry JSON) per entry, with the same batching.
+func (s *Server) handleAdminLogStreamWS(w http.ResponseWriter, r *http.Request) {
+	ws := wsUpgrade(w, r)
+	if ws == nil {
+		return
+	}
+	defer ws.c.Close()
+
+	ch, cancel := s.logs.subscribe()
+	defer cancel()
+
+	// A hijacked connection is not watched by net/http any more: the reader
+	// notices the client going away.
+	done := make(chan struct{})
+	go func() {
+		_ = ws.readLoop()
+		close(done)
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	batch := time.Duration(s.cfgSnapshot().AdminStreamBatchMs) * time.Millisecond
+	var tickC <-chan time.Time
+	if batch > 0 {
+		tick := time.NewTicker(batch)
+		defer tick.Stop()
+		tickC = tick.C
+	}
+	pending := 0
+	for {
+		select {
+		case <-done:
+			return
+		case <-r.Context().Done():
+			_ = ws.send(wsOpClose, []byte{0x03, 0xE9}) // 1001 going away
+			return
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := ws.writeFrame(wsOpText, e.jsonLine()); err != nil {
+				return
+			}
+			if tickC == nil {
+				if err := ws.flush(); err != nil {
+					return
+				}
+			} else {
+				pending++
+			}
+		case <-tickC:
+			if pending > 0 {
+				if err := ws.flush(); err != nil {
+					return
+				}
+				pending = 0
+			}
+		case <-ping.C:
+			if err := ws.send(wsOpPing, nil); err != nil {
+				return
+			}
+		}
+	}
+}
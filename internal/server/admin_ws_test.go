@@ -0,0 +1,62 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWSUpgradeOrigin(t *testing.T) {
+	s, _, _ := newTestServer(t, nil)
+	srv := httptest.NewServer(http.HandlerFunc(s.handleAdminLogStreamWS))
+	defer srv.Close()
+	host := srv.Listener.Addr().String()
+
+	const key = "dGhlIHNhbXBsZSBub25jZQ=="
+	tests := []struct {
+		name   string
+		origin string
+		code   int
+	}{
+		{"same origin", "http://" + host, http.StatusSwitchingProtocols},
+		{"no origin", "", http.StatusSwitchingProtocols},
+		{"cross origin", "http://evil.example", http.StatusForbidden},
+		{"same host, other port", "http://127.0.0.1:1", http.StatusForbidden},
+		{"malformed origin", "http://[::1", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := net.Dial("tcp", host)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+			req := "GET /admin/stream/logs/ws HTTP/1.1\r\nHost: " + host +
+				"\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: " + key + "\r\n"
+			if tt.origin != "" {
+				req += "Origin: " + tt.origin + "\r\n"
+			}
+			if _, err := c.Write([]byte(req + "\r\n")); err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.code {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.code)
+			}
+			if tt.code == http.StatusSwitchingProtocols {
+				// RFC 6455 section 1.3 sample key.
+				if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+					t.Fatalf("Sec-WebSocket-Accept = %q", got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	auditQueueSize     = 4096
+	auditFlushInterval = time.Second
+	auditRetryInterval = 10 * time.Second
+)
+
+// auditSettings is the part of the config the audit writer needs.
+type auditSettings struct {
+	path     string
+	maxBytes int64
+	keep     int
+}
+
+// auditLog appends JSON lines to one file (audit_log_path, or one per-token
+// file below audit_log_dir). Lines are queued and written by a single
+// goroutine, so the RPC path never waits for disk I/O; when the queue is full,
+// lines are dropped and counted.
+type auditLog struct {
+	lines   chan []byte
+	setCh   chan auditSettings
+	reopen  chan struct{}
+	flushCh chan chan struct{}
+	dropped atomic.Uint64
+}
+
+func newAuditLog(st auditSettings) *auditLog {
+	a := &auditLog{
+		lines:   make(chan []byte, auditQueueSize),
+		setCh:   make(chan auditSettings, 1),
+		reopen:  make(chan struct{}, 1),
+		flushCh: make(chan chan struct{}),
+	}
+	go a.run(st)
+	return a
+}
+
+// watchAuditSignals reopens all audit log files on SIGHUP.
+func (s *Server) watchAuditSignals() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			s.audit.requestReopen()
+			s.tokenAudit.reopenAll()
+		}
+	}()
+}
+
+// write queues one entry without blocking.
+func (a *auditLog) write(le LogEntry) {
+	a.writeLine(le.jsonLine())
+}
+
+// writeLine queues one JSON line (without the trailing newline).
+func (a *auditLog) writeLine(b []byte) {
+	select {
+	case a.lines <- append(b, '\n'):
+	default:
+		a.dropped.Add(1)
+	}
+}
+
+// configure applies new settings; the file is always reopened, so a config
+// reload also picks up a file moved away by external rotation.
+func (a *auditLog) configure(st auditSettings) {
+	for {
+		select {
+		case a.setCh <- st:
+			return
+		default:
+			// Replace a pending, not yet applied update.
+			select {
+			case <-a.setCh:
+			default:
+			}
+		}
+	}
+}
+
+func (a *auditLog) requestReopen() {
+	select {
+	case a.reopen <- struct{}{}:
+	default:
+	}
+}
+
+// flush writes all queued entries to disk (used before exiting). It gives up
+// after timeout.
+func (a *auditLog) flush(timeout time.Duration) {
+	done := make(chan struct{})
+	select {
+	case a.flushCh <- done:
+		select {
+		case <-done:
+		case <-time.After(timeout):
+		}
+	case <-time.After(timeout):
+	}
+}
+
+func (a *auditLog) run(st auditSettings) {
+	var (
+		f       *os.File
+		bw      *bufio.Writer
+		size    int64
+		lastErr time.Time
+	)
+	closeFile := func() {
+		if f == nil {
+			return
+		}
+		if err := bw.Flush(); err != nil {
+			log.Printf("audit log: %s: %v", st.path, err)
+		}
+		_ = f.Close()
+		f, bw = nil, nil
+	}
+	open := func() bool {
+		if f != nil {
+			return true
+		}
+		if st.path == "" || time.Since(lastErr) < auditRetryInterval {
+			return false
+		}
+		var err error
+		if err = os.MkdirAll(filepath.Dir(st.path), 0o755); err == nil {
+			f, err = os.OpenFile(st.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		}
+		if err == nil {
+			var fi os.FileInfo
+			if fi, err = f.Stat(); err == nil {
+				size = fi.Size()
+			} else {
+				_ = f.Close()
+				f = nil
+			}
+		}
+		if err != nil {
+			lastErr = time.Now()
+			log.Printf("audit log: %v (retrying in %s)", err, auditRetryInterval)
+			return false
+		}
+		bw = bufio.NewWriterSize(f, 64<<10)
+		return true
+	}
+	rotate := func() {
+		closeFile()
+		if st.keep == 0 {
+			_ = os.Remove(st.path)
+		} else {
+			for i := st.keep - 1; i >= 1; i-- {
+				_ = os.Rename(fmt.Sprintf("%s.%d", st.path, i), fmt.Sprintf("%s.%d", st.path, i+1))
+			}
+			if err := os.Rename(st.path, st.path+".1"); err != nil {
+				log.Printf("audit log: rotate %s: %v", st.path, err)
+			}
+		}
+		open()
+	}
+	writeLine := func(line []byte) {
+		if !open() {
+			return
+		}
+		if st.maxBytes > 0 && size > 0 && size+int64(len(line)) > st.maxBytes {
+			rotate()
+			if f == nil {
+				return
+			}
+		}
+		n, err := bw.Write(line)
+		size += int64(n)
+		if err != nil {
+			log.Printf("audit log: %s: %v", st.path, err)
+			closeFile()
+			lastErr = time.Now()
+		}
+	}
+
+	tick := time.NewTicker(auditFlushInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case line := <-a.lines:
+			writeLine(line)
+		case next := <-a.setCh:
+			closeFile()
+			st = next
+			lastErr = time.Time{}
+		case <-a.reopen:
+			closeFile()
+			lastErr = time.Time{}
+		case done := <-a.flushCh:
+			for drained := false; !drained; {
+				select {
+				case line := <-a.lines:
+					writeLine(line)
+				default:
+					drained = true
+				}
+			}
+			if f != nil {
+				_ = bw.Flush()
+			}
+			close(done)
+		case <-tick.C:
+			if f != nil {
+				if err := bw.Flush(); err != nil {
+					log.Printf("audit log: %s: %v", st.path, err)
+				}
+			}
+			if n := a.dropped.Swap(0); n > 0 {
+				log.Printf("audit log: dropped %d entries (queue full)", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+// readJSONL decodes every line of a JSONL file.
+func readJSONL(t *testing.T, path string) []LogEntry {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []LogEntry
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		var le LogEntry
+		if err := json.Unmarshal(sc.Bytes(), &le); err != nil {
+			t.Fatalf("%s: %v in %q", path, err, sc.Text())
+		}
+		out = append(out, le)
+	}
+	return out
+}
+
+func TestAuditLogRecordsRequests(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "audit.jsonl")
+	s, _, _ := newTestServer(t, func(c *config.Config) { c.AuditLogPath = path })
+
+	rpcStatus(t, s, "", proto.OpSTAT, 0, pathPayload("/"))
+	rpcStatus(t, s, "", proto.OpSTAT, 0, pathPayload("/MISSING"))
+	rpcStatus(t, s, "", proto.OpWRITE_RANGE, proto.FlagWR_CREATE, writeRangePayload(t, "/F", 0, []byte("hi")))
+	s.audit.flush(time.Second)
+
+	got := readJSONL(t, path)
+	want := []struct{ op, status string }{
+		{"STAT", "OK"},
+		{"STAT", "NOT_FOUND"},
+		{"WRITE_RANGE", "OK"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("%d audit lines, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		if got[i].OpName != w.op || got[i].StatusName != w.status || got[i].ReqBytes == 0 {
+			t.Errorf("line %d = %s %s (%d bytes), want %s %s", i, got[i].OpName, got[i].StatusName, got[i].ReqBytes, w.op, w.status)
+		}
+	}
+}
+
+func TestAuditLogRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	a := newAuditLog(auditSettings{path: path, maxBytes: 200, keep: 2})
+	for i := 0; i < 12; i++ {
+		a.write(LogEntry{ID: uint64(i), OpName: "LS", StatusName: "OK"})
+	}
+	// Lines still queued at flush time are rotated like the others.
+	a.flush(time.Second)
+
+	var ids []uint64
+	for _, p := range []string{path + ".2", path + ".1", path} {
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() > 200 {
+			t.Errorf("%s is %d bytes, over audit_log_max_bytes", p, fi.Size())
+		}
+		for _, le := range readJSONL(t, p) {
+			ids = append(ids, le.ID)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 exists with audit_log_keep=2", path)
+	}
+	// The kept files hold the newest entries, in order, ending with the last.
+	for i := 1; i < len(ids); i++ {
+		if ids[i] != ids[i-1]+1 {
+			t.Fatalf("entry ids across rotated files = %v", ids)
+		}
+	}
+	if len(ids) == 0 || ids[len(ids)-1] != 11 {
+		t.Fatalf("entry ids across rotated files = %v, want ending in 11", ids)
+	}
+
+	// A reopen after external rotation starts a new file.
+	if err := os.Rename(path, path+".moved"); err != nil {
+		t.Fatal(err)
+	}
+	a.requestReopen()
+	a.write(LogEntry{ID: 99, OpName: "LS", StatusName: "OK"})
+	a.flush(time.Second)
+	if got := readJSONL(t, path); len(got) != 1 || got[0].ID != 99 {
+		t.Fatalf("after reopen %s = %+v", path, got)
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// throttle delays the caller until the token's byte budget
+// (max_bytes_per_sec, see Limits.MaxBytesPerSec) covers n more bytes. The
+// bucket holds one second's worth, so short requests pass at once; a bulk
+// transfer runs at the configured rate. It is a no-op when no limit is set
+// and returns early when the server shuts down. No lock is held while it
+// sleeps.
+func (s *Server) throttle(limits Limits, n int) {
+	if limits.MaxBytesPerSec == 0 || n <= 0 {
+		return
+	}
+	if d := s.bandwidth.take(limits.tokenID, float64(limits.MaxBytesPerSec), float64(n), time.Now()); d > 0 {
+		s.sleep(d)
+	}
+}
+
+// throttleChunk is the largest piece a throttled stream passes at once: a
+// tenth of a second's worth (at least 512 bytes), so a large write is shaped
+// smoothly instead of in one long pause.
+func throttleChunk(limits Limits) int {
+	return int(max(limits.MaxBytesPerSec/10, 512))
+}
+
+// throttledWriter shapes the response body of the HTTP file bridge.
+// Embedding only http.ResponseWriter hides io.ReaderFrom, so copies (e.g.
+// in http.ServeContent) go through Write.
+type throttledWriter struct {
+	http.ResponseWriter
+	s      *Server
+	limits Limits
+}
+
+func (w throttledWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		k := min(len(p), throttleChunk(w.limits))
+		w.s.throttle(w.limits, k)
+		m, err := w.ResponseWriter.Write(p[:k])
+		n += m
+		if err != nil {
+			return n, err
+		}
+		p = p[k:]
+	}
+	return n, nil
+}
+
+// throttledReader shapes an upload body like throttledWriter.
+type throttledReader struct {
+	io.ReadCloser
+	s      *Server
+	limits Limits
+}
+
+func (r throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk(r.limits) {
+		p = p[:throttleChunk(r.limits)]
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.s.throttle(r.limits, n)
+	return n, err
+}
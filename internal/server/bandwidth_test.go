@@ -0,0 +1,98 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+func TestBandwidthHTTPFiles(t *testing.T) {
+	s, _, base := newTestServer(t, func(c *config.Config) {
+		c.EnableHTTPFiles = true
+		c.Tokens = []config.TokenEntry{
+			{Token: "SLOW", MaxBytesPerSec: 20000},
+			{Token: "FAST"},
+		}
+	})
+	data := bytes.Repeat([]byte("0123456789abcdef"), 2500) // 40000 bytes
+	if err := os.WriteFile(filepath.Join(base, "BIG.BIN"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	hs := httptest.NewServer(s.HTTPHandler())
+	defer hs.Close()
+
+	get := func(token string) time.Duration {
+		t.Helper()
+		start := time.Now()
+		resp, err := http.Get(hs.URL + httpFilesPrefix + "BIG.BIN?token=" + token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK || !bytes.Equal(body, data) {
+			t.Fatalf("GET as %s = %d, %d bytes, %v", token, resp.StatusCode, len(body), err)
+		}
+		return time.Since(start)
+	}
+
+	// The first second's worth (20000 bytes) passes at once, the other
+	// 20000 take a second.
+	if d := get("SLOW"); d < 800*time.Millisecond {
+		t.Errorf("40000 bytes at 20000 B/s took %v, want about 1s", d)
+	}
+	if d := get("FAST"); d > 500*time.Millisecond {
+		t.Errorf("unthrottled download took %v", d)
+	}
+}
+
+func TestBandwidthRPC(t *testing.T) {
+	const rate = 5000
+	s, _, base := newTestServer(t, func(c *config.Config) {
+		c.MaxBytesPerSec = rate // global default
+		c.Tokens = []config.TokenEntry{{Token: "T"}, {Token: "OWN", MaxBytesPerSec: 123456}}
+	})
+	if err := os.WriteFile(filepath.Join(base, "F"), make([]byte, 1000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Request and response bytes both come out of the bucket: 1000 written,
+	// 1000 read, plus headers and paths.
+	if st, _ := rpc(t, s, "T", proto.OpWRITE_RANGE, proto.FlagWR_CREATE, writeRangePayload(t, "/G", 0, make([]byte, 1000))); st != proto.StatusOK {
+		t.Fatalf("WRITE_RANGE = %s", statusName(st))
+	}
+	if st, resp := rpc(t, s, "T", proto.OpREAD_RANGE, 0, encode(func(e *proto.Encoder) {
+		_ = e.WriteString("/F")
+		e.WriteU32(0)
+		e.WriteU16(1000)
+	})); st != proto.StatusOK || len(resp) != 1000 {
+		t.Fatalf("READ_RANGE = %s, %d bytes", statusName(st), len(resp))
+	}
+	if left := s.bandwidth.level(tokenID("T"), rate, time.Now()); left > rate-2000 || left < rate-2200 {
+		t.Fatalf("bucket after 2000 data bytes = %.0f, want %d less headers and paths", left, rate-2000)
+	}
+
+	// The admin tokens view shows the effective limits.
+	w := httptest.NewRecorder()
+	s.handleAdminTokens(w, httptest.NewRequest("GET", "/", nil))
+	var resp adminTokensResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]uint64{}
+	for _, st := range resp.Tokens {
+		got[st.TokenMask] = st.MaxBytesPerSec
+	}
+	if len(got) != 2 || got[maskToken("T")] != rate || got[maskToken("OWN")] != 123456 {
+		t.Fatalf("admin tokens max_bytes_per_sec = %v", got)
+	}
+}
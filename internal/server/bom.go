@@ -0,0 +1,90 @@
+package server
+
+import (
+	"bytes"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+// Byte order marks removed by FlagWR_STRIP_BOM / strip_bom_extensions.
+var writeBOMs = [][]byte{
+	{0xEF, 0xBB, 0xBF}, // UTF-8
+	{0xFF, 0xFE},       // UTF-16 LE
+	{0xFE, 0xFF},       // UTF-16 BE
+}
+
+// bomShiftTTL is how long a stripped BOM shifts the offsets of later chunks.
+const bomShiftTTL = 10 * time.Minute
+
+// bomShifts remembers files whose first chunk lost a BOM. The client still
+// counts the BOM, so its later chunks arrive len(BOM) bytes too far; they
+// are moved back to keep the writes contiguous.
+type bomShifts struct {
+	mu sync.Mutex
+	m  map[string]bomShift
+}
+
+type bomShift struct {
+	n  uint32
+	at time.Time
+}
+
+func (b *bomShifts) set(key string, n uint32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	for k, v := range b.m {
+		if now.Sub(v.at) > bomShiftTTL {
+			delete(b.m, k)
+		}
+	}
+	if n == 0 {
+		delete(b.m, key)
+		return
+	}
+	if b.m == nil {
+		b.m = map[string]bomShift{}
+	}
+	b.m[key] = bomShift{n: n, at: now}
+}
+
+func (b *bomShifts) get(key string) uint32 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	v, ok := b.m[key]
+	if !ok || time.Since(v.at) > bomShiftTTL {
+		return 0
+	}
+	v.at = time.Now()
+	b.m[key] = v
+	return v.n
+}
+
+// stripWriteBOM applies BOM stripping to a WRITE_RANGE chunk. A chunk at
+// offset 0 starts a new file: its BOM (if stripping applies) is dropped and
+// remembered; later chunks of the same path get their offset shifted back.
+func (s *Server) stripWriteBOM(cfg config.Config, flags byte, rootAbs, p string, offset uint32, data []byte) (uint32, []byte) {
+	key := pathLockKey(filepath.Join(rootAbs, filepath.FromSlash(p)))
+	if offset != 0 {
+		if n := s.boms.get(key); n > 0 && offset >= n {
+			offset -= n
+		}
+		return offset, data
+	}
+	var n uint32
+	if flags&proto.FlagWR_STRIP_BOM != 0 || cfg.StripBOMFor(p) {
+		for _, bom := range writeBOMs {
+			if bytes.HasPrefix(data, bom) {
+				n = uint32(len(bom))
+				data = data[n:]
+				break
+			}
+		}
+	}
+	s.boms.set(key, n)
+	return offset, data
+}
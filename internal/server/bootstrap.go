@@ -1,6 +1,10 @@
 package server
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net"
@@ -25,6 +29,18 @@ import (
 //	RO=0|1
 //	END
 //
+// With bootstrap.hmac_key set the response is signed:
+//
+//	WICOS64CFG
+//	...
+//	NONCE=<nonce>   (only if the client sent nonce=)
+//	SIG=<hex>
+//	END
+//
+// SIG is the lowercase hex HMAC-SHA256 over the exact response bytes from
+// "WICOS64CFG" up to and including the "\n" of the line before SIG, in the
+// order sent. Every line ends with a single "\n" (no CR); END is not signed.
+//
 // Notes:
 //   - This endpoint is independent of the W64F binary API and does not change the
 //     wire protocol in any way.
@@ -54,7 +70,7 @@ func (s *Server) handleBootstrap(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	remoteIPStr := clientIP(r)
+	remoteIPStr := clientIP(cfg, r)
 	if bc.LanOnly {
 		ip := net.ParseIP(remoteIPStr)
 		if !isLANIP(ip) {
@@ -64,7 +80,10 @@ func (s *Server) handleBootstrap(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	cfgTok, macRaw, parseErr := bootstrapParams(r)
+	cfgTok, macRaw, nonce, parseErr := bootstrapParams(r)
+	if parseErr == nil && !validBootstrapNonce(nonce) {
+		parseErr = fmt.Errorf("bad nonce")
+	}
 	if parseErr != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		_, _ = w.Write([]byte("bad request\n"))
@@ -122,28 +141,25 @@ func (s *Server) handleBootstrap(w http.ResponseWriter, r *http.Request) {
 	}
 	apiURL := fmt.Sprintf("%s://%s%s", scheme, host, cfg.Endpoint)
 
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	w.Header().Set("Cache-Control", "no-store")
-	w.WriteHeader(http.StatusOK)
-
 	// Keep it tiny & deterministic.
-	_, _ = w.Write([]byte("WICOS64CFG\n"))
-	_, _ = w.Write([]byte("API_URL=" + apiURL + "\n"))
-	_, _ = w.Write([]byte("TOKEN=" + token + "\n"))
+	var b bytes.Buffer
+	b.WriteString("WICOS64CFG\n")
+	b.WriteString("API_URL=" + apiURL + "\n")
+	b.WriteString("TOKEN=" + token + "\n")
 	if ctx.ReadOnly {
-		_, _ = w.Write([]byte("RO=1\n"))
+		b.WriteString("RO=1\n")
 	} else {
-		_, _ = w.Write([]byte("RO=0\n"))
+		b.WriteString("RO=0\n")
 	}
-	_, _ = w.Write([]byte("MAC=" + mac + "\n"))
+	b.WriteString("MAC=" + mac + "\n")
 	if cfg.ServerName != "" {
-		_, _ = w.Write([]byte("SERVER_NAME=" + cfg.ServerName + "\n"))
+		b.WriteString("SERVER_NAME=" + cfg.ServerName + "\n")
 	}
 	if ctx.QuotaBytes > 0 {
-		_, _ = w.Write([]byte(fmt.Sprintf("QUOTA_BYTES=%d\n", ctx.QuotaBytes)))
+		fmt.Fprintf(&b, "QUOTA_BYTES=%d\n", ctx.QuotaBytes)
 	}
 	if ctx.MaxFileBytes > 0 {
-		_, _ = w.Write([]byte(fmt.Sprintf("MAX_FILE_BYTES=%d\n", ctx.MaxFileBytes)))
+		fmt.Fprintf(&b, "MAX_FILE_BYTES=%d\n", ctx.MaxFileBytes)
 	}
 
 	// Optional per-MAC extra key/value pairs.
@@ -157,15 +173,51 @@ func (s *Server) handleBootstrap(w http.ResponseWriter, r *http.Request) {
 			for _, k := range keys {
 				v := kv[k]
 				// Keys/values are validated/normalized on config load.
-				_, _ = w.Write([]byte(k + "=" + v + "\n"))
+				b.WriteString(k + "=" + v + "\n")
 			}
 		}
 	}
-	_, _ = w.Write([]byte("END\n"))
+	if bc.HMACKey != "" {
+		if nonce != "" {
+			b.WriteString("NONCE=" + nonce + "\n")
+		}
+		b.WriteString("SIG=" + bootstrapSignature([]byte(bc.HMACKey), b.Bytes()) + "\n")
+	}
+	b.WriteString("END\n")
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(b.Bytes())
+}
+
+// bootstrapSignature returns the SIG= value: lowercase hex HMAC-SHA256 of
+// the signed response lines.
+func bootstrapSignature(key, signed []byte) string {
+	m := hmac.New(sha256.New, key)
+	_, _ = m.Write(signed)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// validBootstrapNonce accepts an empty nonce or up to 64 characters of
+// [0-9A-Za-z._-], which keeps the NONCE= line trivial to compare on a 6502.
+func validBootstrapNonce(n string) bool {
+	if len(n) > 64 {
+		return false
+	}
+	for i := 0; i < len(n); i++ {
+		c := n[i]
+		switch {
+		case c >= '0' && c <= '9', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
 }
 
-// bootstrapParams returns (cfg, mac) from either the query string (GET/POST)
-// or the POST body.
+// bootstrapParams returns (cfg, mac, nonce) from either the query string
+// (GET/POST) or the POST body. nonce is optional.
 //
 // For POST, we support a tiny ASCII format so 6502 code can generate it easily:
 //
@@ -174,26 +226,27 @@ func (s *Server) handleBootstrap(w http.ResponseWriter, r *http.Request) {
 // or newline-separated:
 //
 //	cfg=CFG-1234\nmac=AABBCCDDEEFF
-func bootstrapParams(r *http.Request) (cfgTok string, macRaw string, err error) {
+func bootstrapParams(r *http.Request) (cfgTok string, macRaw string, nonce string, err error) {
 	q := r.URL.Query()
 	cfgTok = q.Get("cfg")
 	macRaw = q.Get("mac")
+	nonce = q.Get("nonce")
 	if r.Method != http.MethodPost {
-		return cfgTok, macRaw, nil
+		return cfgTok, macRaw, nonce, nil
 	}
 	// If both already present in query params, do not touch the body.
 	if cfgTok != "" && macRaw != "" {
-		return cfgTok, macRaw, nil
+		return cfgTok, macRaw, nonce, nil
 	}
 
 	body, readErr := io.ReadAll(io.LimitReader(r.Body, 1024))
 	_ = r.Body.Close()
 	if readErr != nil {
-		return "", "", readErr
+		return "", "", "", readErr
 	}
 	s := strings.TrimSpace(string(body))
 	if s == "" {
-		return cfgTok, macRaw, nil
+		return cfgTok, macRaw, nonce, nil
 	}
 
 	// Accept either a classic querystring, or newline separated key/value pairs.
@@ -225,7 +278,10 @@ func bootstrapParams(r *http.Request) (cfgTok string, macRaw string, err error)
 		if macRaw == "" {
 			macRaw = m["mac"]
 		}
-		return cfgTok, macRaw, nil
+		if nonce == "" {
+			nonce = m["nonce"]
+		}
+		return cfgTok, macRaw, nonce, nil
 	}
 	if cfgTok == "" {
 		cfgTok = vals.Get("cfg")
@@ -233,7 +289,10 @@ func bootstrapParams(r *http.Request) (cfgTok string, macRaw string, err error)
 	if macRaw == "" {
 		macRaw = vals.Get("mac")
 	}
-	return cfgTok, macRaw, nil
+	if nonce == "" {
+		nonce = vals.Get("nonce")
+	}
+	return cfgTok, macRaw, nonce, nil
 }
 
 // normalizeMAC extracts a 12-hex-digit MAC address from a string.
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"wicos64-server/internal/config"
+)
+
+func TestBootstrapSignature(t *testing.T) {
+	// RFC 4231 HMAC-SHA256 test cases 1 and 2.
+	tests := []struct {
+		key, data, want string
+	}{
+		{strings.Repeat("\x0b", 20), "Hi There", "b0344c61d8db38535ca8afceaf0bf12b881dc200c9833da726e9376c2e32cff7"},
+		{"Jefe", "what do ya want for nothing?", "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"},
+	}
+	for _, tt := range tests {
+		if got := bootstrapSignature([]byte(tt.key), []byte(tt.data)); got != tt.want {
+			t.Errorf("bootstrapSignature(%q, %q) = %s, want %s", tt.key, tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestValidBootstrapNonce(t *testing.T) {
+	tests := []struct {
+		nonce string
+		ok    bool
+	}{
+		{"", true},
+		{"abc-XYZ_0.9", true},
+		{strings.Repeat("n", 64), true},
+		{strings.Repeat("n", 65), false},
+		{"a b", false},
+		{"a\nSIG=00", false},
+		{"a=b", false},
+		{"ä", false},
+	}
+	for _, tt := range tests {
+		if got := validBootstrapNonce(tt.nonce); got != tt.ok {
+			t.Errorf("validBootstrapNonce(%q) = %v, want %v", tt.nonce, got, tt.ok)
+		}
+	}
+}
+
+func TestBootstrapSignedResponse(t *testing.T) {
+	s, _, _ := newTestServer(t, func(c *config.Config) {
+		c.Token = "SECRET"
+		c.Bootstrap.Enabled = true
+		c.Bootstrap.Token = "CFG-1"
+		c.Bootstrap.UnknownMACPolicy = "legacy"
+		c.Bootstrap.HMACKey = "k3y"
+	})
+
+	tests := []struct {
+		name, query string
+		code        int
+		nonce       string
+	}{
+		{"with nonce", "?cfg=CFG-1&mac=AA:BB:CC:DD:EE:FF&nonce=n-1", http.StatusOK, "NONCE=n-1\n"},
+		{"without nonce", "?cfg=CFG-1&mac=AA:BB:CC:DD:EE:FF", http.StatusOK, ""},
+		{"bad nonce", "?cfg=CFG-1&mac=AA:BB:CC:DD:EE:FF&nonce=a%0Ab", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/wicos64/bootstrap"+tt.query, nil)
+			r.RemoteAddr = "192.168.1.20:5000"
+			w := httptest.NewRecorder()
+			s.handleBootstrap(w, r)
+			if w.Code != tt.code {
+				t.Fatalf("status = %d, want %d", w.Code, tt.code)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			body := w.Body.Bytes()
+			i := bytes.Index(body, []byte("SIG="))
+			if i < 0 || !bytes.HasSuffix(body, []byte("\nEND\n")) {
+				t.Fatalf("unsigned response:\n%s", body)
+			}
+			signed := body[:i]
+			if !bytes.Contains(signed, []byte("\nMAC=AABBCCDDEEFF\n")) || !bytes.HasSuffix(signed, []byte("\n"+tt.nonce)) {
+				t.Fatalf("signed part lacks the MAC line or does not end with NONCE:\n%s", signed)
+			}
+			sig := strings.TrimSuffix(string(body[i+4:]), "\nEND\n")
+			if want := bootstrapSignature([]byte("k3y"), signed); sig != want {
+				t.Fatalf("SIG = %s, want %s", sig, want)
+			}
+		})
+	}
+}
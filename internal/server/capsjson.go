@@ -0,0 +1,109 @@
+package server
+
+import (
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"wicos64-server/internal/proto"
+	"wicos64-server/internal/version"
+)
+
+// capsJSONSchema is bumped on incompatible changes of the caps.json layout.
+const capsJSONSchema = 1
+
+type capsJSONResponse struct {
+	Schema         int            `json:"schema_version"`
+	ProtoVersion   int            `json:"proto_version"`
+	ServerName     string         `json:"server_name"`
+	Build          string         `json:"build"`
+	ServerTimeUnix int64          `json:"server_time_unix"`
+	Limits         capsJSONLimits `json:"limits"`
+	Features       uint64         `json:"features"`
+	FeatureNames   []string       `json:"feature_names"`
+	Token          *capsJSONToken `json:"token,omitempty"`
+}
+
+type capsJSONLimits struct {
+	MaxChunk   uint16 `json:"max_chunk"`
+	MaxPayload uint16 `json:"max_payload"`
+	MaxPath    uint16 `json:"max_path"`
+	MaxName    uint16 `json:"max_name"`
+	MaxEntries uint16 `json:"max_entries"`
+	// Largest orig_len of a compressed response, 0 = compression off.
+	MaxDecompressed uint16 `json:"max_decompressed"`
+	PreviewLen      uint16 `json:"preview_len"`
+}
+
+type capsJSONToken struct {
+	ReadOnly     bool   `json:"read_only"`
+	QuotaBytes   uint64 `json:"quota_bytes"`
+	MaxFileBytes uint64 `json:"max_file_bytes"`
+	MaxFiles     uint64 `json:"max_files"`
+}
+
+// handleCapsJSON serves GET /wicos64/caps.json (enable_caps_json): the data
+// of the binary CAPS op as versioned JSON, so tools do not have to decode the
+// CAPS layout. The binary op stays authoritative for devices.
+//
+// With ?token= the token-specific view is returned (state bits like READONLY
+// and the token's limits); an unknown token is refused.
+func (s *Server) handleCapsJSON(w http.ResponseWriter, r *http.Request) {
+	cfg := s.cfgSnapshot()
+	if !cfg.EnableCapsJSON {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var limits Limits
+	rootAbs := ""
+	var tok *capsJSONToken
+	if token := r.URL.Query().Get("token"); token != "" {
+		ctx, ok := cfg.ResolveTokenContext(token)
+		if !ok || tokenWindowError(ctx, time.Now()) != "" || ctx.Endpoint != "" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte("forbidden\n"))
+			return
+		}
+		limits = limitsFromContext(ctx)
+		if abs, err := filepath.Abs(ctx.Root); err == nil {
+			rootAbs = abs
+		}
+		tok = &capsJSONToken{ReadOnly: limits.ReadOnly, QuotaBytes: limits.QuotaBytes, MaxFileBytes: limits.MaxFileBytes, MaxFiles: limits.MaxFiles}
+	}
+
+	features := s.capsFeatures(cfg, limits, rootAbs)
+	names := []string{}
+	for _, f := range proto.FeatureNames {
+		if features&f.Bit != 0 {
+			names = append(names, f.Name)
+		}
+	}
+	if tok != nil && features&proto.FeatREADONLY != 0 {
+		tok.ReadOnly = true
+	}
+
+	writeJSON(w, http.StatusOK, capsJSONResponse{
+		Schema:         capsJSONSchema,
+		ProtoVersion:   proto.Version,
+		ServerName:     cfg.ServerName,
+		Build:          version.Get().String(),
+		ServerTimeUnix: time.Now().Unix(),
+		Limits: capsJSONLimits{
+			MaxChunk:        cfg.MaxChunk,
+			MaxPayload:      cfg.MaxPayload,
+			MaxPath:         cfg.MaxPath,
+			MaxName:         cfg.MaxName,
+			MaxEntries:      cfg.MaxEntries,
+			MaxDecompressed: maxDecompressed(cfg),
+			PreviewLen:      cfg.SearchPreviewBytes,
+		},
+		Features:     features,
+		FeatureNames: names,
+		Token:        tok,
+	})
+}
@@ -99,8 +99,11 @@ func prgFallbackCandidate(normPath string) bool {
 //   - fallback_prg_extension: If the exact path is missing and the name has no
 //     '.', it additionally tries "<name>.PRG".
 //
-// It also performs the no-symlink check and returns the error from that check.
+// It also performs the symlink check and returns the error from that check:
+// symlinks are rejected unless their target is whitelisted in
+// allow_symlink_targets (read access only, see fsops.LstatAllowSymlinks).
 func resolveReadPathWithCompat(cfg config.Config, rootAbs, normPath string) (abs string, usedCompat bool, err error) {
+	allow := cfg.AllowSymlinkTargets
 	// 1) Optional wildcard resolution (final segment only)
 	if cfg.Compat.WildcardLoad {
 		dirNorm, namePat := splitDirBase(normPath)
@@ -110,11 +113,11 @@ func resolveReadPathWithCompat(cfg config.Config, rootAbs, normPath string) (abs
 				return "", false, errors.New("wildcards are only allowed in the final path segment")
 			}
 
-			dirAbs, err2 := fsops.ToOSPath(rootAbs, dirNorm)
+			dirAbs, err2 := fsops.ToOSPathRead(rootAbs, dirNorm, allow)
 			if err2 != nil {
 				return "", false, err2
 			}
-			if err3 := fsops.LstatNoSymlink(rootAbs, dirAbs, false); err3 != nil {
+			if err3 := fsops.LstatAllowSymlinks(rootAbs, dirAbs, allow); err3 != nil {
 				return "", false, err3
 			}
 
@@ -137,13 +140,19 @@ func resolveReadPathWithCompat(cfg config.Config, rootAbs, normPath string) (abs
 				}
 
 				candAbs := filepath.Join(dirAbs, name)
-				if err5 := fsops.LstatNoSymlink(rootAbs, candAbs, false); err5 != nil {
+				if err5 := fsops.LstatAllowSymlinks(rootAbs, candAbs, allow); err5 != nil {
 					// Ignore symlinks and keep searching.
 					if errors.Is(err5, fsops.ErrSymlinkNotAllowed) {
 						continue
 					}
 					return "", true, err5
 				}
+				if e.Type()&os.ModeSymlink != 0 {
+					// Whitelisted link: skip it if it points to a directory.
+					if fi, err6 := os.Stat(candAbs); err6 != nil || fi.IsDir() {
+						continue
+					}
+				}
 				return candAbs, true, nil
 			}
 			return "", true, fs.ErrNotExist
@@ -151,11 +160,11 @@ func resolveReadPathWithCompat(cfg config.Config, rootAbs, normPath string) (abs
 	}
 
 	// 2) Exact path
-	abs, err = fsops.ToOSPath(rootAbs, normPath)
+	abs, err = fsops.ToOSPathRead(rootAbs, normPath, allow)
 	if err != nil {
 		return "", false, err
 	}
-	err = fsops.LstatNoSymlink(rootAbs, abs, false)
+	err = fsops.LstatAllowSymlinks(rootAbs, abs, allow)
 	if err == nil {
 		return abs, false, nil
 	}
@@ -163,9 +172,9 @@ func resolveReadPathWithCompat(cfg config.Config, rootAbs, normPath string) (abs
 	// 3) Optional <name>.PRG fallback
 	if errors.Is(err, fs.ErrNotExist) && cfg.Compat.FallbackPRGExtension && prgFallbackCandidate(normPath) {
 		altNorm := normPath + ".PRG"
-		altAbs, err2 := fsops.ToOSPath(rootAbs, altNorm)
+		altAbs, err2 := fsops.ToOSPathRead(rootAbs, altNorm, allow)
 		if err2 == nil {
-			if err3 := fsops.LstatNoSymlink(rootAbs, altAbs, false); err3 == nil {
+			if err3 := fsops.LstatAllowSymlinks(rootAbs, altAbs, allow); err3 == nil {
 				return altAbs, true, nil
 			}
 		}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"bytes"
+	"compress/flate"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+// compressPayload deflates a successful response payload for a client that
+// accepts compressed responses (compress_responses, proto.RespCompressed).
+// It returns the payload unchanged and ok=false when compression is off,
+// the payload is below compress_min_bytes or would not get smaller.
+func compressPayload(cfg config.Config, payload []byte) (out []byte, ok bool) {
+	if !cfg.CompressResponses || len(payload) < int(cfg.CompressMinBytes) || len(payload) > 0xFFFF {
+		return payload, false
+	}
+	var buf bytes.Buffer
+	buf.Write([]byte{byte(len(payload)), byte(len(payload) >> 8)}) // orig_len u16
+	zw, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return payload, false
+	}
+	if _, err := zw.Write(payload); err != nil {
+		return payload, false
+	}
+	if err := zw.Close(); err != nil {
+		return payload, false
+	}
+	if buf.Len() >= len(payload) {
+		return payload, false
+	}
+	return buf.Bytes(), true
+}
+
+// maxDecompressed is the CAPS max_decompressed value: the largest orig_len
+// of a compressed response, or 0 when compression is off.
+func maxDecompressed(cfg config.Config) uint16 {
+	if !cfg.CompressResponses {
+		return 0
+	}
+	return cfg.MaxPayload
+}
+
+// acceptsCompressed reports whether the request header allows a compressed
+// response.
+func acceptsCompressed(hdr proto.ReqHeader) bool {
+	return hdr.Reserved&proto.ReqAcceptCompressed != 0
+}
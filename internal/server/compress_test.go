@@ -0,0 +1,94 @@
+package server
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+func inflate(t *testing.T, z []byte) []byte {
+	t.Helper()
+	if len(z) < 2 {
+		t.Fatalf("compressed payload too short: %d bytes", len(z))
+	}
+	raw, err := io.ReadAll(flate.NewReader(bytes.NewReader(z[2:])))
+	if err != nil {
+		t.Fatalf("inflate: %v", err)
+	}
+	if n := int(binary.LittleEndian.Uint16(z)); n != len(raw) {
+		t.Fatalf("orig_len = %d, inflated %d bytes", n, len(raw))
+	}
+	return raw
+}
+
+func TestCompressPayloadLS(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, func(c *config.Config) { c.CompressResponses = true })
+	for i := 0; i < 20; i++ {
+		if err := os.WriteFile(filepath.Join(rootAbs, fmt.Sprintf("FILE%02d.PRG", i)), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e := proto.NewEncoder(16)
+	_ = e.WriteString("/")
+	e.WriteU16(0)
+	e.WriteU16(cfg.MaxEntries)
+	status, ls, errMsg := s.dispatch(cfg, Limits{}, proto.OpLS, 0, e.Bytes(), rootAbs)
+	if status != proto.StatusOK {
+		t.Fatalf("LS = %s (%s)", statusName(status), errMsg)
+	}
+	if len(ls) < int(cfg.CompressMinBytes) {
+		t.Fatalf("LS response only %d bytes, below compress_min_bytes", len(ls))
+	}
+
+	z, ok := compressPayload(cfg, ls)
+	if !ok {
+		t.Fatal("compressPayload: ok = false for a large LS response")
+	}
+	if len(z) >= len(ls) {
+		t.Fatalf("compressed %d bytes to %d", len(ls), len(z))
+	}
+	if raw := inflate(t, z); !bytes.Equal(raw, ls) {
+		t.Fatal("inflated payload differs from the LS response")
+	}
+}
+
+func TestCompressPayloadSkipped(t *testing.T) {
+	cfg := config.Default()
+	cfg.CompressResponses = true
+	random := make([]byte, 1024)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	off := cfg
+	off.CompressResponses = false
+
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		payload []byte
+	}{
+		{"below compress_min_bytes", cfg, bytes.Repeat([]byte{'A'}, int(cfg.CompressMinBytes)-1)},
+		{"incompressible", cfg, random},
+		{"compression off", off, bytes.Repeat([]byte{'A'}, 1024)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, ok := compressPayload(tt.cfg, tt.payload)
+			if ok {
+				t.Fatal("ok = true, want the payload sent uncompressed")
+			}
+			if !bytes.Equal(out, tt.payload) {
+				t.Fatal("payload changed")
+			}
+		})
+	}
+}
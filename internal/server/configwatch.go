@@ -0,0 +1,109 @@
+package server
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"wicos64-server/internal/config"
+)
+
+// configWatchInterval is how often config_watch polls the config file.
+const configWatchInterval = 2 * time.Second
+
+// cfgFileStamp identifies a version of the config file on disk.
+type cfgFileStamp struct {
+	mtime time.Time
+	size  int64
+}
+
+func statCfgFile(path string) (cfgFileStamp, bool) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return cfgFileStamp{}, false
+	}
+	return cfgFileStamp{mtime: fi.ModTime(), size: fi.Size()}, true
+}
+
+// cfgWatch tracks the last config file version the server has seen, so the
+// watcher neither reloads the server's own saves nor retries a broken file.
+type cfgWatch struct {
+	mu   sync.Mutex
+	seen cfgFileStamp
+}
+
+// markConfigSeen records the current config file version as already applied.
+// Called after the admin UI saved the config.
+func (s *Server) markConfigSeen() {
+	if st, ok := statCfgFile(s.cfgPath); ok {
+		s.cfgWatch.mu.Lock()
+		s.cfgWatch.seen = st
+		s.cfgWatch.mu.Unlock()
+	}
+}
+
+// reloadConfigFile loads and validates the config file and applies it.
+// Listen, listeners and endpoint are kept, as they only take effect on
+// restart.
+func (s *Server) reloadConfigFile() (config.Config, error) {
+	newCfg, err := config.Load(s.cfgPath)
+	if err != nil {
+		return config.Config{}, err
+	}
+	cur := s.cfgSnapshot()
+	newCfg.Listen = cur.Listen
+	newCfg.Listeners = cur.Listeners
+	newCfg.Endpoint = cur.Endpoint
+	s.setCfg(newCfg)
+	return newCfg, nil
+}
+
+// startConfigWatcher polls the config file (config_watch) and reloads it
+// after external edits.
+func (s *Server) startConfigWatcher() {
+	if s.cfgPath == "" {
+		return
+	}
+	s.markConfigSeen()
+	go func() {
+		var pending cfgFileStamp
+		for s.sleep(configWatchInterval) {
+			pending = s.pollConfigFile(pending)
+		}
+	}()
+}
+
+// pollConfigFile is one config_watch poll. pending is the file version seen
+// by the previous poll but not applied yet; the returned one is passed to the
+// next poll. A change is applied once the file has been stable for one poll,
+// so half-written files are not picked up. Failed loads are logged and the
+// running config is kept.
+func (s *Server) pollConfigFile(pending cfgFileStamp) cfgFileStamp {
+	if !s.cfgSnapshot().ConfigWatch {
+		// Forget pending changes while disabled; enabling again only
+		// reacts to edits made from then on.
+		s.markConfigSeen()
+		return cfgFileStamp{}
+	}
+	st, ok := statCfgFile(s.cfgPath)
+	s.cfgWatch.mu.Lock()
+	seen := s.cfgWatch.seen
+	s.cfgWatch.mu.Unlock()
+	if !ok || st == seen {
+		return cfgFileStamp{}
+	}
+	if st != pending {
+		// Changed since the last poll; wait until it settles.
+		return st
+	}
+	s.cfgWatch.mu.Lock()
+	s.cfgWatch.seen = st
+	s.cfgWatch.mu.Unlock()
+	if _, err := s.reloadConfigFile(); err != nil {
+		log.Printf("config watch: reload of %s failed, keeping current config: %v", s.cfgPath, err)
+		return cfgFileStamp{}
+	}
+	log.Printf("config watch: reloaded %s", s.cfgPath)
+	return cfgFileStamp{}
+}
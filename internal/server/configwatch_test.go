@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"wicos64-server/internal/config"
+)
+
+func TestConfigWatchReloadsTokenQuota(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	save := func(quota uint64, listen string) {
+		t.Helper()
+		c := config.Default()
+		c.BasePath = dir
+		c.Discovery.Enabled = false
+		c.ConfigWatch = true
+		c.Listen = listen
+		c.Tokens = []config.TokenEntry{{Token: "T", QuotaBytes: quota}}
+		b, err := json.Marshal(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	save(1000, ":8080")
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(cfg, path)
+	t.Cleanup(func() { _ = s.Shutdown(context.Background()) })
+
+	quota := func() uint64 {
+		t.Helper()
+		ctx, ok := s.cfgSnapshot().ResolveTokenContext("T")
+		if !ok {
+			t.Fatal("token T rejected")
+		}
+		return ctx.QuotaBytes
+	}
+	// poll runs n config_watch polls.
+	poll := func(n int) {
+		var pending cfgFileStamp
+		for i := 0; i < n; i++ {
+			pending = s.pollConfigFile(pending)
+		}
+	}
+
+	poll(2)
+	if q := quota(); q != 1000 {
+		t.Fatalf("quota %d before any edit, want 1000", q)
+	}
+
+	// An edit is applied once it has been stable for one poll. Listen only
+	// changes on restart.
+	save(250000, ":9090")
+	pending := s.pollConfigFile(cfgFileStamp{})
+	if q := quota(); q != 1000 {
+		t.Fatalf("quota %d after the first poll, want 1000 until the file settles", q)
+	}
+	s.pollConfigFile(pending)
+	if q := quota(); q != 250000 {
+		t.Fatalf("quota %d after the edit settled, want 250000", q)
+	}
+	if l := s.cfgSnapshot().Listen; l != ":8080" {
+		t.Fatalf("listen reloaded to %q", l)
+	}
+
+	// A broken file keeps the running config.
+	if err := os.WriteFile(path, []byte(`{"tokens": [`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	poll(3)
+	if q := quota(); q != 250000 {
+		t.Fatalf("quota %d after a broken edit, want 250000", q)
+	}
+
+	// The server's own saves (admin UI) are not reloaded.
+	save(7, ":8080")
+	s.markConfigSeen()
+	poll(3)
+	if q := quota(); q != 250000 {
+		t.Fatalf("quota %d after an admin save, want 250000", q)
+	}
+
+	// Edits made while config_watch is off are ignored.
+	cfg = s.cfgSnapshot()
+	cfg.ConfigWatch = false
+	s.setCfg(cfg)
+	save(42, ":8080")
+	poll(2)
+	cfg.ConfigWatch = true
+	s.setCfg(cfg)
+	poll(2)
+	if q := quota(); q != 250000 {
+		t.Fatalf("quota %d after an edit with config_watch off, want 250000", q)
+	}
+}
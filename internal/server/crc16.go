@@ -0,0 +1,50 @@
+package server
+
+import "hash"
+
+// CRC-16/CCITT-FALSE as used by HASH with FlagH_CRC16: polynomial 0x1021
+// (x^16 + x^12 + x^5 + 1), initial value 0xFFFF, MSB first (no reflection),
+// no final XOR. Check value for "123456789" is 0x29B1.
+const (
+	crc16Poly = 0x1021
+	crc16Init = 0xFFFF
+)
+
+var crc16Table = func() (t [256]uint16) {
+	for i := range t {
+		c := uint16(i) << 8
+		for b := 0; b < 8; b++ {
+			if c&0x8000 != 0 {
+				c = c<<1 ^ crc16Poly
+			} else {
+				c <<= 1
+			}
+		}
+		t[i] = c
+	}
+	return t
+}()
+
+// crc16Hash implements hash.Hash for CRC-16/CCITT-FALSE so it can be fed by
+// the same helpers as CRC32 and SHA-256.
+type crc16Hash struct{ crc uint16 }
+
+var _ hash.Hash = (*crc16Hash)(nil)
+
+func newCRC16() *crc16Hash { return &crc16Hash{crc: crc16Init} }
+
+func (h *crc16Hash) Write(p []byte) (int, error) {
+	c := h.crc
+	for _, b := range p {
+		c = c<<8 ^ crc16Table[byte(c>>8)^b]
+	}
+	h.crc = c
+	return len(p), nil
+}
+
+// Sum appends the checksum big-endian, like the hash/crc32 package.
+func (h *crc16Hash) Sum(b []byte) []byte { return append(b, byte(h.crc>>8), byte(h.crc)) }
+func (h *crc16Hash) Reset()              { h.crc = crc16Init }
+func (h *crc16Hash) Size() int           { return 2 }
+func (h *crc16Hash) BlockSize() int      { return 1 }
+func (h *crc16Hash) Sum16() uint16       { return h.crc }
@@ -39,6 +39,58 @@ func opName(op byte) string {
 		return "HASH"
 	case proto.OpMV:
 		return "MV"
+	case proto.OpDIRMTIME:
+		return "DIRMTIME"
+	case proto.OpSTRINGS:
+		return "STRINGS"
+	case proto.OpTREE:
+		return "TREE"
+	case proto.OpREAD_TAIL:
+		return "READ_TAIL"
+	case proto.OpTOKEN_NAMES:
+		return "TOKEN_NAMES"
+	case proto.OpTOUCH:
+		return "TOUCH"
+	case proto.OpMKTEMP:
+		return "MKTEMP"
+	case proto.OpBATCH:
+		return "BATCH"
+	case proto.OpECHO:
+		return "ECHO"
+	case proto.OpLOCK:
+		return "LOCK"
+	case proto.OpUNLOCK:
+		return "UNLOCK"
+	case proto.OpCOPY_RANGE:
+		return "COPY_RANGE"
+	case proto.OpMOTD:
+		return "MOTD"
+	case proto.OpSAMEFILE:
+		return "SAMEFILE"
+	case proto.OpLS_TREE:
+		return "LS_TREE"
+	case proto.OpIMAGE_CHANGES:
+		return "IMAGE_CHANGES"
+	case proto.OpEXISTS_EXACT:
+		return "EXISTS_EXACT"
+	case proto.OpPROGRESS:
+		return "PROGRESS"
+	case proto.OpVERIFY:
+		return "VERIFY"
+	case proto.OpTRASH_LS:
+		return "TRASH_LS"
+	case proto.OpDIRHASH:
+		return "DIRHASH"
+	case proto.OpTRASH_RESTORE:
+		return "TRASH_RESTORE"
+	case proto.OpSTAT_MANY:
+		return "STAT_MANY"
+	case proto.OpMKIMAGE:
+		return "MKIMAGE"
+	case proto.OpWHOAMI:
+		return "WHOAMI"
+	case proto.OpIMAGES:
+		return "IMAGES"
 	case proto.OpPING:
 		return "PING"
 	default:
@@ -79,6 +131,8 @@ func statusName(st byte) string {
 		return "TOO_LARGE"
 	case proto.StatusBusy:
 		return "BUSY"
+	case proto.StatusQuotaFull:
+		return "QUOTA_FULL"
 	default:
 		return fmt.Sprintf("STATUS_0x%02X", st)
 	}
@@ -121,7 +175,14 @@ func summarizeRequest(cfg config.Config, op byte, flags byte, payload []byte) st
 		p := readPath(d)
 		start, _ := d.ReadU16()
 		max, _ := d.ReadU16()
-		return fmt.Sprintf("path=%s start=%d max=%d", p, start, max)
+		fl := flagList(
+			lsSortFlagName(flags),
+			choose(flags&proto.FlagLS_REVERSE != 0, "REVERSE", ""),
+		)
+		if fl != "" {
+			fl = " flags=" + fl
+		}
+		return fmt.Sprintf("path=%s start=%d max=%d%s", p, start, max, fl)
 	case proto.OpSTAT:
 		p := readPath(d)
 		return fmt.Sprintf("path=%s", p)
@@ -135,7 +196,19 @@ func summarizeRequest(cfg config.Config, op byte, flags byte, payload []byte) st
 		p := readPath(d)
 		off, _ := d.ReadU32()
 		ln, _ := d.ReadU16()
-		return fmt.Sprintf("path=%s off=%d len=%d", p, off, ln)
+		fl := flagList(
+			choose(flags&proto.FlagR_SCREENCODE != 0, "SCREENCODE", ""),
+			choose(flags&proto.FlagR_SC_LOWER != 0, "SC_LOWER", ""),
+			choose(flags&proto.FlagR_IF_HASH != 0, "IF_HASH", ""),
+		)
+		if fl != "" {
+			fl = " flags=" + fl
+		}
+		if flags&proto.FlagR_IF_HASH != 0 {
+			crc, _ := d.ReadU32()
+			fl += fmt.Sprintf(" crc32=0x%08X", crc)
+		}
+		return fmt.Sprintf("path=%s off=%d len=%d%s", p, off, ln, fl)
 	case proto.OpWRITE_RANGE:
 		p := readPath(d)
 		off, _ := d.ReadU32()
@@ -143,6 +216,11 @@ func summarizeRequest(cfg config.Config, op byte, flags byte, payload []byte) st
 		fl := flagList(
 			choose(flags&proto.FlagWR_TRUNCATE != 0, "TRUNC", ""),
 			choose(flags&proto.FlagWR_CREATE != 0, "CREATE", ""),
+			choose(flags&proto.FlagWR_STRIP_BOM != 0, "STRIP_BOM", ""),
+			choose(flags&proto.FlagWR_WANT_SIZE != 0, "WANT_SIZE", ""),
+			choose(flags&proto.FlagWR_STAGED != 0, "STAGED", ""),
+			choose(flags&proto.FlagWR_COMMIT != 0, "COMMIT", ""),
+			choose(flags&proto.FlagWR_CONFIRM != 0, "CONFIRM", ""),
 		)
 		if fl != "" {
 			fl = " flags=" + fl
@@ -165,20 +243,27 @@ func summarizeRequest(cfg config.Config, op byte, flags byte, payload []byte) st
 		return fmt.Sprintf("path=%s%s", p, fl)
 	case proto.OpRMDIR:
 		p := readPath(d)
-		fl := choose(flags&proto.FlagRD_RECURSIVE != 0, "RECURSIVE", "")
+		fl := flagList(
+			choose(flags&proto.FlagRD_RECURSIVE != 0, "RECURSIVE", ""),
+			choose(flags&proto.FlagDRY_RUN != 0, "DRY_RUN", ""),
+		)
 		if fl != "" {
 			fl = " flags=" + fl
 		}
 		return fmt.Sprintf("path=%s%s", p, fl)
 	case proto.OpRM:
 		p := readPath(d)
-		return fmt.Sprintf("path=%s", p)
+		fl := choose(flags&proto.FlagDRY_RUN != 0, " flags=DRY_RUN", "")
+		return fmt.Sprintf("path=%s%s", p, fl)
 	case proto.OpCP:
 		src := readPath(d)
 		dst := readPath(d)
 		fl := flagList(
 			choose(flags&proto.FlagCP_OVERWRITE != 0, "OVERWRITE", ""),
 			choose(flags&proto.FlagCP_RECURSIVE != 0, "RECURSIVE", ""),
+			choose(flags&proto.FlagCP_ASYNC != 0, "ASYNC", ""),
+			choose(flags&proto.FlagCP_CONVERT != 0, "CONVERT", ""),
+			choose(flags&proto.FlagDRY_RUN != 0, "DRY_RUN", ""),
 		)
 		if fl != "" {
 			fl = " flags=" + fl
@@ -186,7 +271,7 @@ func summarizeRequest(cfg config.Config, op byte, flags byte, payload []byte) st
 		return fmt.Sprintf("src=%s dst=%s%s", src, dst, fl)
 	case proto.OpHASH:
 		p := readPath(d)
-		algo := choose(flags&proto.FlagH_ALGO != 0, "SHA1", "CRC32")
+		algo := choose(flags&proto.FlagH_SHA256 != 0, "SHA256", choose(flags&proto.FlagH_CRC16 != 0, "CRC16", "CRC32"))
 		return fmt.Sprintf("path=%s algo=%s", p, algo)
 	case proto.OpSEARCH:
 		base := readPath(d)
@@ -198,6 +283,9 @@ func summarizeRequest(cfg config.Config, op byte, flags byte, payload []byte) st
 			choose(flags&proto.FlagS_CASE_INSENSITIVE != 0, "CI", ""),
 			choose(flags&proto.FlagS_RECURSIVE != 0, "RECURSIVE", ""),
 			choose(flags&proto.FlagS_WHOLE_WORD != 0, "WHOLE", ""),
+			choose(flags&proto.FlagS_REGEX != 0, "REGEX", ""),
+			choose(flags&proto.FlagS_NAMES != 0, "NAMES", ""),
+			choose(flags&proto.FlagS_INTO_IMAGES != 0, "IMAGES", ""),
 		)
 		if fl != "" {
 			fl = " flags=" + fl
@@ -206,11 +294,142 @@ func summarizeRequest(cfg config.Config, op byte, flags byte, payload []byte) st
 	case proto.OpMV:
 		src := readPath(d)
 		dst := readPath(d)
-		fl := choose(flags&proto.FlagMV_OVERWRITE != 0, "OVERWRITE", "")
+		fl := flagList(
+			choose(flags&proto.FlagMV_OVERWRITE != 0, "OVERWRITE", ""),
+			choose(flags&proto.FlagDRY_RUN != 0, "DRY_RUN", ""),
+		)
 		if fl != "" {
 			fl = " flags=" + fl
 		}
 		return fmt.Sprintf("src=%s dst=%s%s", src, dst, fl)
+	case proto.OpDIRMTIME:
+		p := readPath(d)
+		return fmt.Sprintf("path=%s", p)
+	case proto.OpSTRINGS:
+		p := readPath(d)
+		off, _ := d.ReadU32()
+		minLen, _ := d.ReadU8()
+		maxScan, _ := d.ReadU32()
+		return fmt.Sprintf("path=%s off=%d min=%d scan=%d", p, off, minLen, maxScan)
+	case proto.OpTREE:
+		p := readPath(d)
+		depth, _ := d.ReadU8()
+		max, _ := d.ReadU16()
+		fl := choose(flags&proto.FlagTR_FILES != 0, " flags=FILES", "")
+		return fmt.Sprintf("path=%s depth=%d max=%d%s", p, depth, max, fl)
+	case proto.OpREAD_TAIL:
+		p := readPath(d)
+		ln, _ := d.ReadU16()
+		return fmt.Sprintf("path=%s len=%d", p, ln)
+	case proto.OpTOKEN_NAMES:
+		start, _ := d.ReadU16()
+		max, _ := d.ReadU16()
+		return fmt.Sprintf("start=%d max=%d", start, max)
+	case proto.OpTOUCH:
+		p := readPath(d)
+		mt, _ := d.ReadU32()
+		fl := choose(flags&proto.FlagT_CREATE != 0, " flags=CREATE", "")
+		return fmt.Sprintf("path=%s mtime=%d%s", p, mt, fl)
+	case proto.OpMKTEMP:
+		prefix, _ := d.ReadString(cfg.MaxName)
+		suffix, _ := d.ReadString(cfg.MaxName)
+		return fmt.Sprintf("prefix=%s suffix=%s", prefix, suffix)
+	case proto.OpBATCH:
+		n, _ := d.ReadU8()
+		names := make([]string, 0, n)
+		for i := 0; i < int(n); i++ {
+			ln, err := d.ReadU16()
+			if err != nil {
+				break
+			}
+			b, err := d.ReadBytes(int(ln))
+			if err != nil || len(b) == 0 {
+				break
+			}
+			names = append(names, opName(b[0]))
+		}
+		fl := choose(flags&proto.FlagB_CONTINUE != 0, " flags=CONTINUE", "")
+		return fmt.Sprintf("n=%d ops=%s%s", n, strings.Join(names, ","), fl)
+	case proto.OpECHO:
+		delay, _ := d.ReadU16()
+		return fmt.Sprintf("delay=%dms len=%d", delay, d.Remaining())
+	case proto.OpLOCK:
+		return "path=" + readPath(d)
+	case proto.OpUNLOCK:
+		fl := choose(flags&proto.FlagUL_FORCE != 0, " flags=FORCE", "")
+		return "path=" + readPath(d) + fl
+	case proto.OpCOPY_RANGE:
+		src := readPath(d)
+		srcOff, _ := d.ReadU32()
+		dst := readPath(d)
+		dstOff, _ := d.ReadU32()
+		ln, _ := d.ReadU16()
+		fl := flagList(
+			choose(flags&proto.FlagWR_TRUNCATE != 0, "TRUNC", ""),
+			choose(flags&proto.FlagWR_CREATE != 0, "CREATE", ""),
+			choose(flags&proto.FlagWR_OVERWRITE != 0, "OVERWRITE", ""),
+		)
+		if fl != "" {
+			fl = " flags=" + fl
+		}
+		return fmt.Sprintf("src=%s src_off=%d dst=%s dst_off=%d len=%d%s", src, srcOff, dst, dstOff, ln, fl)
+	case proto.OpSAMEFILE:
+		a := readPath(d)
+		b := readPath(d)
+		return fmt.Sprintf("src=%s dst=%s", a, b)
+	case proto.OpLS_TREE:
+		p := readPath(d)
+		depth, _ := d.ReadU8()
+		start, _ := d.ReadU16()
+		max, _ := d.ReadU16()
+		fl := choose(flags&proto.FlagLT_IMAGES != 0, " flags=IMAGES", "")
+		return fmt.Sprintf("path=%s depth=%d start=%d max=%d%s", p, depth, start, max, fl)
+	case proto.OpIMAGE_CHANGES:
+		p := readPath(d)
+		since, _ := d.ReadU32()
+		start, _ := d.ReadU16()
+		return fmt.Sprintf("path=%s since=%d start=%d", p, since, start)
+	case proto.OpEXISTS_EXACT:
+		return "path=" + readPath(d)
+	case proto.OpPROGRESS:
+		id, _ := d.ReadU32()
+		return fmt.Sprintf("id=%d", id)
+	case proto.OpVERIFY:
+		return "path=" + readPath(d)
+	case proto.OpTRASH_LS:
+		start, _ := d.ReadU16()
+		max, _ := d.ReadU16()
+		return fmt.Sprintf("start=%d max=%d", start, max)
+	case proto.OpDIRHASH:
+		p := readPath(d)
+		return "path=" + p + choose(flags&proto.FlagDH_RECURSIVE != 0, " flags=RECURSIVE", "")
+	case proto.OpTRASH_RESTORE:
+		id, _ := d.ReadString(0xFFFF)
+		dst, _ := d.ReadString(0xFFFF)
+		if dst == "" {
+			dst = "(original)"
+		}
+		return fmt.Sprintf("id=%s to=%s%s", id, dst, choose(flags&proto.FlagTRR_OVERWRITE != 0, " flags=OVERWRITE", ""))
+	case proto.OpSTAT_MANY:
+		n, _ := d.ReadU16()
+		var ps []string
+		for i := 0; i < int(n) && i < previewMaxEntries; i++ {
+			ps = append(ps, readPath(d))
+		}
+		if int(n) > len(ps) {
+			ps = append(ps, "...")
+		}
+		return fmt.Sprintf("count=%d paths=%s", n, strings.Join(ps, ","))
+	case proto.OpMKIMAGE:
+		p := readPath(d)
+		kind, _ := d.ReadU8()
+		name, _ := d.ReadString(0xFFFF)
+		id, _ := d.ReadString(0xFFFF)
+		return fmt.Sprintf("path=%s kind=%s name=%q id=%q%s", p, imageKindName(kind), name, id, choose(flags&proto.FlagMI_OVERWRITE != 0, " flags=OVERWRITE", ""))
+	case proto.OpIMAGES:
+		p := readPath(d)
+		start, _ := d.ReadU16()
+		return fmt.Sprintf("path=%s start=%d%s", p, start, choose(flags&proto.FlagIM_RECURSIVE != 0, " flags=RECURSIVE", ""))
 	default:
 		return ""
 	}
@@ -43,6 +43,15 @@ import (
 //	22..23 caps_flags u16 LE (reserved for future use)
 //	24..27 server_id u32 LE (CRC32(server_name))
 //	28..31 crc32 u32 LE over bytes 0..27
+//
+// Probes over IPv6 (to ff02::1 or a unicast address, discovery.ipv6) get the
+// same 32 bytes with server_ip 0.0.0.0 and flag bit4 set, followed by:
+//
+//	32..47 server_ip6 (network order)
+//	48     url_len u8
+//	49..   bootstrap URL, e.g. "http://[fd00::10]:8080/wicos64/bootstrap"
+//	       (no zone: for a link-local host the client adds its own)
+//	last 4 crc32 u32 LE over all preceding bytes
 const (
 	wdpMagic          = "WDP1"
 	wdpTypeDiscover   = 0x01
@@ -51,6 +60,10 @@ const (
 	wdpOfferFixedSize = 32
 
 	wdpReqFlagBootstrapOnly = 1 << 1
+
+	wdpOfferFlagIPv6 = 1 << 4
+
+	wdpBootstrapPath = "/wicos64/bootstrap"
 )
 
 type udpRateLimiter struct {
@@ -88,16 +101,31 @@ func (s *Server) StartDiscovery() {
 		if !dc.Enabled {
 			return
 		}
+		// One rate limiter for both sockets: a host probing over v4 and v6
+		// counts once per address, like any other source.
+		rl := newUDPRateLimiter()
 		addr := &net.UDPAddr{IP: net.IPv4zero, Port: dc.UDPPort}
 		conn, err := net.ListenUDP("udp4", addr)
 		if err != nil {
 			log.Printf("UDP discovery: listen %s failed: %v", addr.String(), err)
+		} else {
+			log.Printf("UDP discovery: listening on %s (LAN only=%v)", addr.String(), dc.LanOnly)
+			s.addDiscoveryConn(conn)
+			go s.discoveryLoop(conn, rl)
+		}
+		if !dc.IPv6 {
 			return
 		}
-		log.Printf("UDP discovery: listening on %s (LAN only=%v)", addr.String(), dc.LanOnly)
-
-		rl := newUDPRateLimiter()
-		go s.discoveryLoop(conn, rl)
+		// "udp6" sets IPV6_V6ONLY, so this does not collide with the v4 socket.
+		addr6 := &net.UDPAddr{IP: net.IPv6unspecified, Port: dc.UDPPort}
+		conn6, err := net.ListenUDP("udp6", addr6)
+		if err != nil {
+			log.Printf("UDP discovery: listen %s failed: %v", addr6.String(), err)
+			return
+		}
+		log.Printf("UDP discovery: listening on %s (LAN only=%v)", addr6.String(), dc.LanOnly)
+		s.addDiscoveryConn(conn6)
+		go s.discoveryLoop(conn6, rl)
 	})
 }
 
@@ -106,6 +134,9 @@ func (s *Server) discoveryLoop(conn *net.UDPConn, rl *udpRateLimiter) {
 	for {
 		n, src, err := conn.ReadFromUDP(buf)
 		if err != nil {
+			if s.stopping() {
+				return
+			}
 			log.Printf("UDP discovery: read error: %v", err)
 			continue
 		}
@@ -166,14 +197,31 @@ func (s *Server) discoveryLoop(conn *net.UDPConn, rl *udpRateLimiter) {
 			}
 		}
 
-		offer, flagsOffer, caps, sid, srvIP, httpPort := buildWDP1Offer(cfg, src.IP, seq, nonce)
+		var offer []byte
+		var flagsOffer byte
+		var caps uint16
+		var sid uint32
+		var where string
+		if src.IP.To4() != nil {
+			var srvIP net.IP
+			var httpPort int
+			offer, flagsOffer, caps, sid, srvIP, httpPort = buildWDP1Offer(cfg, src.IP, seq, nonce)
+			where = net.JoinHostPort(srvIP.String(), strconv.Itoa(httpPort))
+		} else {
+			offer, flagsOffer, caps, sid, where = buildWDP1Offer6(cfg, src.IP, src.Zone, seq, nonce)
+			if offer == nil {
+				log.Printf("UDP discovery: DISCOVER from %s ignored: HTTP listener %q is not reachable over IPv6", src.IP.String(), cfg.ListenAddr(config.ServeBootstrap))
+				continue
+			}
+		}
 		// reply: unicast to src.IP but client-chosen port.
-		_, werr := conn.WriteToUDP(offer, &net.UDPAddr{IP: src.IP, Port: int(listenPort)})
+		dst := &net.UDPAddr{IP: src.IP, Port: int(listenPort), Zone: src.Zone}
+		_, werr := conn.WriteToUDP(offer, dst)
 		if werr != nil {
-			log.Printf("UDP discovery: OFFER send failed to %s:%d: %v", src.IP.String(), listenPort, werr)
+			log.Printf("UDP discovery: OFFER send failed to %s: %v", dst.String(), werr)
 			continue
 		}
-		log.Printf("UDP discovery: DISCOVER ok from %s:%d mac=%s -> OFFER %s:%d flags=0x%02x caps=0x%04x id=0x%08x", src.IP.String(), listenPort, macStr, srvIP.String(), httpPort, flagsOffer, caps, sid)
+		log.Printf("UDP discovery: DISCOVER ok from %s mac=%s -> OFFER %s flags=0x%02x caps=0x%04x id=0x%08x", dst.String(), macStr, where, flagsOffer, caps, sid)
 	}
 }
 
@@ -197,14 +245,14 @@ func buildWDP1Offer(cfg config.Config, clientIP net.IP, seq uint16, nonce uint32
 	binary.LittleEndian.PutUint16(offer[6:8], seq)
 	binary.LittleEndian.PutUint32(offer[8:12], nonce)
 
-	serverIP = advertisedServerIP(cfg.Listen, clientIP)
+	serverIP = advertisedServerIP(cfg.ListenAddr(config.ServeBootstrap), clientIP)
 	if ip4 := serverIP.To4(); ip4 != nil {
 		copy(offer[12:16], ip4)
 	} else {
 		copy(offer[12:16], net.IPv4(127, 0, 0, 1))
 	}
 
-	httpPort = listenHTTPPort(cfg.Listen)
+	httpPort = listenHTTPPort(cfg.ListenAddr(config.ServeBootstrap))
 	binary.LittleEndian.PutUint16(offer[16:18], uint16(httpPort))
 
 	// Optional strings: keep empty for robustness (client defaults).
@@ -225,6 +273,36 @@ func buildWDP1Offer(cfg config.Config, clientIP net.IP, seq uint16, nonce uint32
 	return offer, flags, caps, serverID, serverIP, httpPort
 }
 
+// buildWDP1Offer6 builds the offer for a probe that arrived over IPv6: the
+// v4 layout with server_ip 0.0.0.0 and wdpOfferFlagIPv6, then the v6
+// address and the bootstrap URL. It returns a nil offer when the HTTP
+// listener is bound to a specific IPv4 address and thus unreachable over v6.
+func buildWDP1Offer6(cfg config.Config, clientIP net.IP, zone string, seq uint16, nonce uint32) (offer []byte, flags byte, caps uint16, serverID uint32, bootstrapURL string) {
+	serverIP := advertisedServerIP6(cfg.ListenAddr(config.ServeBootstrap), clientIP, zone)
+	if serverIP == nil {
+		return nil, 0, 0, 0, ""
+	}
+	fixed, flags, caps, serverID, _, httpPort := buildWDP1Offer(cfg, nil, seq, nonce)
+	copy(fixed[12:16], net.IPv4zero.To4())
+	flags |= wdpOfferFlagIPv6
+	fixed[5] = flags
+	binary.LittleEndian.PutUint32(fixed[28:32], crc32.ChecksumIEEE(fixed[0:28]))
+
+	scheme := "http"
+	if cfg.TLSEnabled() {
+		scheme = "https"
+	}
+	bootstrapURL = scheme + "://" + net.JoinHostPort(serverIP.String(), strconv.Itoa(httpPort)) + wdpBootstrapPath
+
+	offer = make([]byte, 0, wdpOfferFixedSize+16+1+len(bootstrapURL)+4)
+	offer = append(offer, fixed...)
+	offer = append(offer, serverIP.To16()...)
+	offer = append(offer, byte(len(bootstrapURL)))
+	offer = append(offer, bootstrapURL...)
+	offer = binary.LittleEndian.AppendUint32(offer, crc32.ChecksumIEEE(offer))
+	return offer, flags, caps, serverID, bootstrapURL
+}
+
 func listenHTTPPort(listen string) int {
 	_, portStr, err := net.SplitHostPort(listen)
 	if err != nil {
@@ -299,6 +377,52 @@ func outboundIPToClient(dst net.IP) net.IP {
 	return net.IPv4(127, 0, 0, 1)
 }
 
+// advertisedServerIP6 is the IPv6 counterpart of advertisedServerIP. It
+// returns nil if the HTTP listener is bound to an IPv4 address (other than
+// the wildcard, which Go opens dual-stack).
+func advertisedServerIP6(listen string, clientIP net.IP, zone string) net.IP {
+	host, _, err := net.SplitHostPort(listen)
+	if err == nil {
+		host = strings.TrimSpace(host)
+		if i := strings.IndexByte(host, '%'); i >= 0 {
+			host = host[:i]
+		}
+		if host != "" {
+			if ip := net.ParseIP(host); ip != nil {
+				if ip.To4() != nil {
+					if !ip.Equal(net.IPv4zero) {
+						return nil
+					}
+				} else if !ip.IsUnspecified() && !(ip.IsLoopback() && !clientIP.IsLoopback()) {
+					return ip
+				}
+			} else {
+				ips, _ := net.LookupIP(host)
+				for _, ip := range ips {
+					if ip.To4() == nil {
+						return ip
+					}
+				}
+				return nil
+			}
+		}
+	}
+	return outboundIP6ToClient(clientIP, zone)
+}
+
+func outboundIP6ToClient(dst net.IP, zone string) net.IP {
+	c, err := net.DialUDP("udp6", nil, &net.UDPAddr{IP: dst, Port: 9, Zone: zone})
+	if err != nil {
+		return net.IPv6loopback
+	}
+	defer c.Close()
+	la, ok := c.LocalAddr().(*net.UDPAddr)
+	if !ok || la.IP.To4() != nil {
+		return net.IPv6loopback
+	}
+	return la.IP
+}
+
 func serverVersionU16(ver string) uint16 {
 	// Expect formats like "v1.0.1.6" or "1.0.1".
 	ver = strings.TrimSpace(ver)
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"net"
+	"testing"
+	"time"
+
+	"wicos64-server/internal/config"
+)
+
+func TestIsLANIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"192.168.1.10", true},
+		{"10.0.0.1", true},
+		{"127.0.0.1", true},
+		{"169.254.1.1", true},
+		{"8.8.8.8", false},
+		{"::1", true},
+		{"fe80::1", true},
+		{"fd00::10", true},
+		{"fc00::1", true},
+		{"2001:db8::1", false},
+		{"2a00:1450::1", false},
+		{"::ffff:192.168.1.10", true},
+		{"::ffff:8.8.8.8", false},
+	}
+	for _, tt := range tests {
+		if got := isLANIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("isLANIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestBuildWDP1Offer6(t *testing.T) {
+	cfg := config.Default()
+	cfg.Listen = "[fd00::10]:8081"
+	offer, flags, _, _, url := buildWDP1Offer6(cfg, net.ParseIP("fd00::20"), "", 7, 0xCAFEBABE)
+	if want := "http://[fd00::10]:8081" + wdpBootstrapPath; url != want {
+		t.Fatalf("url = %q, want %q", url, want)
+	}
+	checkWDP1Offer6(t, offer, 7, 0xCAFEBABE, net.ParseIP("fd00::10"), url)
+	if flags&wdpOfferFlagIPv6 == 0 {
+		t.Fatalf("flags = %#x, want the IPv6 bit", flags)
+	}
+
+	cfg.Listen = "192.168.1.5:8080"
+	if offer, _, _, _, _ := buildWDP1Offer6(cfg, net.ParseIP("fd00::20"), "", 1, 1); offer != nil {
+		t.Fatal("offer for a v4-only HTTP listener, want none")
+	}
+}
+
+// checkWDP1Offer6 validates the layout and checksums of an IPv6 offer.
+func checkWDP1Offer6(t *testing.T, offer []byte, seq uint16, nonce uint32, ip net.IP, url string) {
+	t.Helper()
+	if len(offer) != wdpOfferFixedSize+16+1+len(url)+4 {
+		t.Fatalf("offer is %d bytes", len(offer))
+	}
+	if string(offer[0:4]) != wdpMagic || offer[4] != wdpTypeOffer || offer[5]&wdpOfferFlagIPv6 == 0 {
+		t.Fatalf("bad offer header % X", offer[:6])
+	}
+	if binary.LittleEndian.Uint16(offer[6:8]) != seq || binary.LittleEndian.Uint32(offer[8:12]) != nonce {
+		t.Fatal("seq/nonce not mirrored")
+	}
+	if !net.IP(offer[12:16]).Equal(net.IPv4zero) {
+		t.Fatalf("server_ip = %v, want 0.0.0.0", net.IP(offer[12:16]))
+	}
+	if crc32.ChecksumIEEE(offer[0:28]) != binary.LittleEndian.Uint32(offer[28:32]) {
+		t.Fatal("fixed part crc mismatch")
+	}
+	if ip != nil && !net.IP(offer[32:48]).Equal(ip) {
+		t.Fatalf("server_ip6 = %v, want %v", net.IP(offer[32:48]), ip)
+	}
+	if int(offer[48]) != len(url) || string(offer[49:49+len(url)]) != url {
+		t.Fatalf("url field = %q", offer[49:len(offer)-4])
+	}
+	n := len(offer) - 4
+	if crc32.ChecksumIEEE(offer[:n]) != binary.LittleEndian.Uint32(offer[n:]) {
+		t.Fatal("trailing crc mismatch")
+	}
+}
+
+func TestDiscoveryIPv6(t *testing.T) {
+	client, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	}
+	defer client.Close()
+	probe, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := probe.LocalAddr().(*net.UDPAddr).Port
+	probe.Close()
+
+	_, _, _ = newTestServer(t, func(c *config.Config) {
+		c.Listen = ":8082"
+		c.Discovery.Enabled = true
+		c.Discovery.IPv6 = true
+		c.Discovery.LanOnly = true
+		c.Discovery.UDPPort = port
+	})
+
+	req := make([]byte, wdpReqSize)
+	copy(req, wdpMagic)
+	req[4] = wdpTypeDiscover
+	binary.LittleEndian.PutUint16(req[6:8], 42)
+	binary.LittleEndian.PutUint32(req[8:12], 0x12345678)
+	binary.LittleEndian.PutUint16(req[18:20], uint16(client.LocalAddr().(*net.UDPAddr).Port))
+	binary.LittleEndian.PutUint32(req[20:24], crc32.ChecksumIEEE(req[0:20]))
+
+	// The listener starts asynchronously; resend until it answers.
+	buf := make([]byte, 512)
+	dst := &net.UDPAddr{IP: net.IPv6loopback, Port: port}
+	for i := 0; ; i++ {
+		if _, err := client.WriteToUDP(req, dst); err != nil {
+			t.Fatal(err)
+		}
+		_ = client.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := client.ReadFromUDP(buf)
+		if err == nil {
+			checkWDP1Offer6(t, buf[:n], 42, 0x12345678, net.IPv6loopback, "http://[::1]:8082"+wdpBootstrapPath)
+			return
+		}
+		if i == 10 {
+			t.Fatalf("no OFFER over IPv6: %v", err)
+		}
+	}
+}
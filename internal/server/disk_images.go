@@ -1,13 +1,19 @@
 package server
 
 import (
+	"crypto/sha256"
 	"errors"
+	"fmt"
+	"hash"
 	"hash/crc32"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	"wicos64-server/internal/config"
 	"wicos64-server/internal/diskimage"
 	"wicos64-server/internal/fsops"
 	"wicos64-server/internal/proto"
@@ -40,6 +46,167 @@ func splitD64Path(p string) (mountPath, innerPath string, ok bool) {
 	return "", "", false
 }
 
+// splitDiskImagePath is like splitD64Path/splitD71Path/splitD81Path/splitT64Path/
+// splitD82Path, but accepts any supported image type. kind is "d64", "d71",
+// "d81", "t64" or "d82".
+func splitDiskImagePath(p string) (kind, mountPath, innerPath string, ok bool) {
+	if m, in, ok := splitD64Path(p); ok {
+		return "d64", m, in, true
+	}
+	if m, in, ok := splitD71Path(p); ok {
+		return "d71", m, in, true
+	}
+	if m, in, ok := splitD81Path(p); ok {
+		return "d81", m, in, true
+	}
+	if m, in, ok := splitT64Path(p); ok {
+		return "t64", m, in, true
+	}
+	if m, in, ok := splitD82Path(p); ok {
+		return "d82", m, in, true
+	}
+	return "", "", "", false
+}
+
+// resolveDiskImageMountModTime validates a mounted image (any supported type) and
+// returns the modification time of the image file.
+func resolveDiskImageMountModTime(rootAbs, kind, mountPath string) (mtime uint32, status byte, msg string) {
+	var t time.Time
+	switch kind {
+	case "d64":
+		_, img, st, m := resolveD64Mount(rootAbs, mountPath)
+		if st != proto.StatusOK {
+			return 0, st, m
+		}
+		t = img.ModTime
+	case "d71":
+		_, img, st, m := resolveD71Mount(rootAbs, mountPath)
+		if st != proto.StatusOK {
+			return 0, st, m
+		}
+		t = img.ModTime
+	case "d81":
+		_, img, st, m := resolveD81Mount(rootAbs, mountPath)
+		if st != proto.StatusOK {
+			return 0, st, m
+		}
+		t = img.ModTime
+	case "t64":
+		_, img, st, m := resolveT64Mount(rootAbs, mountPath)
+		if st != proto.StatusOK {
+			return 0, st, m
+		}
+		t = img.ModTime
+	case "d82":
+		_, img, st, m := resolveD82Mount(rootAbs, mountPath)
+		if st != proto.StatusOK {
+			return 0, st, m
+		}
+		t = img.ModTime
+	default:
+		return 0, proto.StatusNotSupported, "unsupported disk image type"
+	}
+	if t.IsZero() {
+		return 0, proto.StatusOK, ""
+	}
+	return uint32(t.Unix()), proto.StatusOK, ""
+}
+
+// diskImageStatfs returns the STATFS numbers of a mounted D64/D71/D81/D82 image
+// in bytes (256 per block), taken from its BAM. For D81 the root BAM is
+// used, also for paths inside partitions.
+func diskImageStatfs(rootAbs, kind, mountPath string) (total, free uint64, status byte, msg string) {
+	var imgAbs string
+	var st byte
+	switch kind {
+	case "d64":
+		imgAbs, _, st, msg = resolveD64Mount(rootAbs, mountPath)
+	case "d71":
+		imgAbs, _, st, msg = resolveD71Mount(rootAbs, mountPath)
+	case "d81":
+		imgAbs, _, st, msg = resolveD81Mount(rootAbs, mountPath)
+	case "d82":
+		imgAbs, _, st, msg = resolveD82Mount(rootAbs, mountPath)
+	default:
+		return 0, 0, proto.StatusNotSupported, "no block counts for ." + kind + " images"
+	}
+	if st != proto.StatusOK {
+		return 0, 0, st, msg
+	}
+	freeBlocks, totalBlocks, err := diskimage.BlockCounts(imgAbs)
+	if err != nil {
+		return 0, 0, proto.StatusInternal, err.Error()
+	}
+	return uint64(totalBlocks) * 256, uint64(freeBlocks) * 256, proto.StatusOK, ""
+}
+
+// resolveDiskImageFile resolves a file inside a mounted image (any supported type).
+// The returned entry can be read with diskimage.ReadFileRange(imgAbs, fe, ...).
+func resolveDiskImageFile(rootAbs, kind, mountPath, inner string, fallbackPRG bool) (imgAbs string, fe *diskimage.FileEntry, status byte, msg string) {
+	if inner == "" {
+		return "", nil, proto.StatusIsADir, "is a directory"
+	}
+	switch kind {
+	case "d64":
+		imgAbs, img, st, m := resolveD64Mount(rootAbs, mountPath)
+		if st != proto.StatusOK {
+			return "", nil, st, m
+		}
+		_, fe, st, m := resolveD64Inner(img, inner, fallbackPRG)
+		return imgAbs, fe, st, m
+	case "d71":
+		imgAbs, img, st, m := resolveD71Mount(rootAbs, mountPath)
+		if st != proto.StatusOK {
+			return "", nil, st, m
+		}
+		_, fe, st, m := resolveD71Inner(img, inner, fallbackPRG)
+		return imgAbs, fe, st, m
+	case "d81":
+		imgAbs, img, st, m := resolveD81Mount(rootAbs, mountPath)
+		if st != proto.StatusOK {
+			return "", nil, st, m
+		}
+		_, fe, st, m := resolveD81Inner(img, inner, fallbackPRG)
+		return imgAbs, fe, st, m
+	case "t64":
+		imgAbs, img, st, m := resolveT64Mount(rootAbs, mountPath)
+		if st != proto.StatusOK {
+			return "", nil, st, m
+		}
+		_, fe, st, m := resolveT64Inner(img, inner, fallbackPRG)
+		return imgAbs, fe, st, m
+	case "d82":
+		imgAbs, img, st, m := resolveD82Mount(rootAbs, mountPath)
+		if st != proto.StatusOK {
+			return "", nil, st, m
+		}
+		_, fe, st, m := resolveD82Inner(img, inner, fallbackPRG)
+		return imgAbs, fe, st, m
+	default:
+		return "", nil, proto.StatusNotSupported, "unsupported disk image type"
+	}
+}
+
+// diskImageDetectByContent mirrors cfg.DiskImageDetectByContent for the mount
+// helpers, which are called from many places without a config snapshot.
+var diskImageDetectByContent atomic.Bool
+
+// checkDiskImageContent verifies that the image at abs really is of the given
+// kind when content detection is enabled.
+func checkDiskImageContent(abs, kind string) (status byte, msg string) {
+	if !diskImageDetectByContent.Load() {
+		return proto.StatusOK, ""
+	}
+	got, err := diskimage.DetectKind(abs)
+	if err != nil {
+		return proto.StatusNotSupported, fmt.Sprintf("not a valid disk image: %v", err)
+	}
+	if got != kind {
+		return proto.StatusNotSupported, fmt.Sprintf("image content is %s, not %s (check the file extension)", strings.ToUpper(got), strings.ToUpper(kind))
+	}
+	return proto.StatusOK, ""
+}
+
 // normalizeDiskImageLeafName normalizes a leaf file name used inside a mounted disk image.
 //
 // When the PRG fallback compatibility option is enabled, WiCOS64 directory listings may
@@ -123,6 +290,17 @@ func isD81Segment(seg string) bool {
 	return strings.EqualFold(ext, ".d81")
 }
 
+// imageLoadError maps a failed image load. Invalid/unsupported images are
+// reported as "not found" rather than "internal"; hitting the
+// max_concurrent_image_parses limit is BUSY, so the client can retry.
+func imageLoadError(err error, kind string) (byte, string) {
+	var se *diskimage.StatusError
+	if errors.As(err, &se) && se.Status() == proto.StatusBusy {
+		return proto.StatusBusy, se.Error()
+	}
+	return proto.StatusNotFound, "invalid or unsupported ." + kind + " image"
+}
+
 // resolveD81Mount validates the mount path and loads/parses the image.
 func resolveD81Mount(rootAbs string, mountPath string) (imgAbs string, img *diskimage.D81, status byte, msg string) {
 	abs, err := fsops.ToOSPath(rootAbs, mountPath)
@@ -144,10 +322,14 @@ func resolveD81Mount(rootAbs string, mountPath string) (imgAbs string, img *disk
 		return "", nil, proto.StatusNotFound, "image not found"
 	}
 
+	if st, msg := checkDiskImageContent(abs, "d81"); st != proto.StatusOK {
+		return "", nil, st, msg
+	}
+
 	img, err = diskimage.LoadD81(abs)
 	if err != nil {
-		// Treat invalid/unsupported images as "not found" rather than "internal".
-		return "", nil, proto.StatusNotFound, "invalid or unsupported .d81 image"
+		st, msg := imageLoadError(err, "d81")
+		return "", nil, st, msg
 	}
 	return abs, img, proto.StatusOK, ""
 }
@@ -274,24 +456,68 @@ func readD81FileRange(imgAbs string, fe *diskimage.FileEntry, offset, length uin
 	return diskimage.ReadFileRange(imgAbs, fe, offset, length)
 }
 
-func crc32D81File(imgAbs string, fe *diskimage.FileEntry) (uint32, error) {
+// crc32ImageFile returns the CRC-32 (IEEE) of a file inside an image.
+func crc32ImageFile(imgAbs string, fe *diskimage.FileEntry) (uint32, error) {
+	h := crc32.NewIEEE()
+	if err := hashDiskImageFile(imgAbs, fe, h); err != nil {
+		return 0, err
+	}
+	return h.Sum32(), nil
+}
+
+// sha256ImageFile returns the SHA-256 of a file inside an image.
+func sha256ImageFile(imgAbs string, fe *diskimage.FileEntry) ([]byte, error) {
+	h := sha256.New()
+	if err := hashDiskImageFile(imgAbs, fe, h); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// crc16ImageFile returns the CRC-16/CCITT-FALSE of a file inside an image.
+func crc16ImageFile(imgAbs string, fe *diskimage.FileEntry) (uint16, error) {
+	h := newCRC16()
+	if err := hashDiskImageFile(imgAbs, fe, h); err != nil {
+		return 0, err
+	}
+	return h.Sum16(), nil
+}
+
+// crc16Resp builds the HASH response for FlagH_CRC16 (u16 LE).
+func crc16Resp(sum uint16, err error) (byte, []byte, string) {
+	if err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+	e := proto.NewEncoder(2)
+	e.WriteU16(sum)
+	return proto.StatusOK, e.Bytes(), ""
+}
+
+// hashDiskImageFile feeds the data bytes of a file inside an image into h.
+func hashDiskImageFile(imgAbs string, fe *diskimage.FileEntry, h hash.Hash) error {
+	if fe.DataOffset > 0 {
+		// Tape entry: one contiguous block (plus load address).
+		data, err := diskimage.ReadFileRange(imgAbs, fe, 0, fe.Size)
+		if err != nil {
+			return err
+		}
+		_, _ = h.Write(data)
+		return nil
+	}
 	f, err := os.Open(imgAbs)
 	if err != nil {
-		return 0, err
+		return err
 	}
 	defer f.Close()
 
-	h := crc32.NewIEEE()
 	buf := make([]byte, 256)
 	for _, sec := range fe.Sectors {
-		_, err := f.ReadAt(buf, sec.Offset)
-		if err != nil {
-			return 0, err
+		if _, err := f.ReadAt(buf, sec.Offset); err != nil {
+			return err
 		}
-		data := buf[2 : 2+sec.DataLen]
-		_, _ = h.Write(data)
+		_, _ = h.Write(buf[2 : 2+sec.DataLen])
 	}
-	return h.Sum32(), nil
+	return nil
 }
 
 // resolveD64Mount validates the mount path and loads/parses the image.
@@ -315,10 +541,14 @@ func resolveD64Mount(rootAbs string, mountPath string) (imgAbs string, img *disk
 		return "", nil, proto.StatusNotFound, "image not found"
 	}
 
+	if st, msg := checkDiskImageContent(abs, "d64"); st != proto.StatusOK {
+		return "", nil, st, msg
+	}
+
 	img, err = diskimage.LoadD64(abs)
 	if err != nil {
-		// Treat invalid/unsupported images as "not found" rather than "internal".
-		return "", nil, proto.StatusNotFound, "invalid or unsupported .d64 image"
+		st, msg := imageLoadError(err, "d64")
+		return "", nil, st, msg
 	}
 	return abs, img, proto.StatusOK, ""
 }
@@ -370,26 +600,6 @@ func readD64FileRange(imgAbs string, fe *diskimage.FileEntry, offset, length uin
 	return diskimage.ReadFileRange(imgAbs, fe, offset, length)
 }
 
-func crc32D64File(imgAbs string, fe *diskimage.FileEntry) (uint32, error) {
-	f, err := os.Open(imgAbs)
-	if err != nil {
-		return 0, err
-	}
-	defer f.Close()
-
-	h := crc32.NewIEEE()
-	buf := make([]byte, 256)
-	for _, sec := range fe.Sectors {
-		_, err := f.ReadAt(buf, sec.Offset)
-		if err != nil {
-			return 0, err
-		}
-		data := buf[2 : 2+sec.DataLen]
-		_, _ = h.Write(data)
-	}
-	return h.Sum32(), nil
-}
-
 func resolveD71Mount(rootAbs, mountPath string) (imgAbs string, img *diskimage.D71, status byte, msg string) {
 	abs, err := fsops.ToOSPath(rootAbs, mountPath)
 	if err != nil {
@@ -414,9 +624,14 @@ func resolveD71Mount(rootAbs, mountPath string) (imgAbs string, img *diskimage.D
 		return "", nil, proto.StatusNotFound, "image not found"
 	}
 
+	if st, msg := checkDiskImageContent(abs, "d71"); st != proto.StatusOK {
+		return "", nil, st, msg
+	}
+
 	img, err = diskimage.LoadD71(abs)
 	if err != nil {
-		return "", nil, proto.StatusNotFound, "invalid or unsupported .d71 image"
+		st, msg := imageLoadError(err, "d71")
+		return "", nil, st, msg
 	}
 	return abs, img, proto.StatusOK, ""
 }
@@ -457,22 +672,331 @@ func readD71FileRange(imgAbs string, fe *diskimage.FileEntry, offset, length uin
 	return diskimage.ReadFileRange(imgAbs, fe, offset, length)
 }
 
-func crc32D71File(imgAbs string, fe *diskimage.FileEntry) (uint32, error) {
-	f, err := os.Open(imgAbs)
+// splitT64Path checks whether p contains a ".t64" segment and splits it into:
+//
+//	mountPath: the path up to and including the .t64 segment
+//	innerPath: the remaining path inside the tape image ("" means image root)
+func splitT64Path(p string) (mountPath, innerPath string, ok bool) {
+	if p == "" || p[0] != '/' {
+		return "", "", false
+	}
+	trim := strings.TrimPrefix(p, "/")
+	if trim == "" {
+		return "", "", false
+	}
+	segs := strings.Split(trim, "/")
+	for i, seg := range segs {
+		if isT64Segment(seg) {
+			mountPath = "/" + strings.Join(segs[:i+1], "/")
+			if i+1 < len(segs) {
+				innerPath = strings.Join(segs[i+1:], "/")
+			} else {
+				innerPath = ""
+			}
+			return mountPath, innerPath, true
+		}
+	}
+	return "", "", false
+}
+
+func isT64Segment(seg string) bool {
+	ext := strings.TrimSpace(filepath.Ext(seg))
+	return strings.EqualFold(ext, ".t64")
+}
+
+// resolveT64Mount validates the mount path and loads/parses the tape image.
+// Tape images are always read-only.
+func resolveT64Mount(rootAbs string, mountPath string) (imgAbs string, img *diskimage.T64, status byte, msg string) {
+	abs, err := fsops.ToOSPath(rootAbs, mountPath)
 	if err != nil {
-		return 0, err
+		return "", nil, proto.StatusInvalidPath, err.Error()
+	}
+	// First ensure the path contains no symlink components.
+	if err := fsops.LstatNoSymlink(rootAbs, abs, false); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil, proto.StatusNotFound, "image not found"
+		}
+		return "", nil, proto.StatusInvalidPath, err.Error()
+	}
+	st, err := fsops.Stat(abs)
+	if err != nil {
+		return "", nil, proto.StatusInternal, err.Error()
+	}
+	if !st.Exists || st.IsDir {
+		return "", nil, proto.StatusNotFound, "image not found"
 	}
-	defer f.Close()
 
-	h := crc32.NewIEEE()
-	buf := make([]byte, 256)
-	for _, sec := range fe.Sectors {
-		_, err := f.ReadAt(buf, sec.Offset)
-		if err != nil {
-			return 0, err
+	if st, msg := checkDiskImageContent(abs, "t64"); st != proto.StatusOK {
+		return "", nil, st, msg
+	}
+
+	img, err = diskimage.LoadT64(abs)
+	if err != nil {
+		st, msg := imageLoadError(err, "t64")
+		return "", nil, st, msg
+	}
+	return abs, img, proto.StatusOK, ""
+}
+
+// resolveT64Inner resolves an inner path inside a T64 image to a file entry.
+// Supports wildcards (*, ?) in the last segment.
+func resolveT64Inner(img *diskimage.T64, innerPath string, fallbackPRG bool) (name string, fe *diskimage.FileEntry, status byte, msg string) {
+	if innerPath == "" {
+		return "", nil, proto.StatusIsADir, "is a directory"
+	}
+	// Tapes have no subdirectories.
+	if strings.Contains(innerPath, "/") {
+		return "", nil, proto.StatusNotFound, "file not found"
+	}
+
+	name = strings.ToUpper(innerPath)
+	if strings.ContainsAny(name, "*?") {
+		for _, e := range img.SortedEntries() {
+			if wildcardMatch(name, strings.ToUpper(e.Name)) {
+				return strings.ToUpper(e.Name), e, proto.StatusOK, ""
+			}
 		}
-		data := buf[2 : 2+sec.DataLen]
-		_, _ = h.Write(data)
+		return "", nil, proto.StatusNotFound, "file not found"
 	}
-	return h.Sum32(), nil
+
+	if e, ok := img.Lookup(name); ok {
+		return name, e, proto.StatusOK, ""
+	}
+	if fallbackPRG && !strings.Contains(innerPath, ".") {
+		if e, ok := img.Lookup(name + ".PRG"); ok {
+			return name + ".PRG", e, proto.StatusOK, ""
+		}
+	}
+	return "", nil, proto.StatusNotFound, "file not found"
+}
+
+// readOnlyMountError returns the error for a write op that addresses a file
+// inside a read-only mount (.t64/.d82 image, .zip archive), or "". The source of CP
+// and COPY_RANGE may be inside one; the image or archive file itself can be
+// deleted, moved or replaced like any other file.
+func (s *Server) readOnlyMountError(cfg config.Config, limits Limits, op byte, payload []byte) string {
+	paths := s.writeLockPaths(cfg, op, payload)
+	if (op == proto.OpCP || op == proto.OpCOPY_RANGE) && len(paths) == 2 {
+		paths = paths[1:]
+	}
+	for _, p := range paths {
+		if _, inner, ok := splitT64Path(p); ok && inner != "" && limits.DiskImagesEnabled {
+			return "tape images are read-only"
+		}
+		if _, inner, ok := splitD82Path(p); ok && inner != "" && limits.DiskImagesEnabled {
+			return "d82 images are read-only"
+		}
+		if _, inner, ok := splitZipPath(p); ok && inner != "" && cfg.ZipMountEnabled {
+			return "zip archives are read-only"
+		}
+	}
+	return ""
+}
+
+// respFlags returns the response header flags for a finished request:
+// proto.RespFromImage when a path of the request resolved inside a mounted
+// disk image. A write op that addresses the image file itself (RM, MV, ...)
+// works on the host file and is not flagged; reading it (LS, STAT) is.
+// proto.RespNotModified marks a READ_RANGE FlagR_IF_HASH match.
+func (s *Server) respFlags(cfg config.Config, limits Limits, op, status byte, payload []byte, errMsg string) byte {
+	if status != proto.StatusOK {
+		return 0
+	}
+	var flags byte
+	if op == proto.OpREAD_RANGE && errMsg == notModifiedMsg {
+		flags |= proto.RespNotModified
+	}
+	if !limits.DiskImagesEnabled || !hasPathPayload(op) {
+		return flags
+	}
+	for _, p := range s.writeLockPaths(cfg, op, payload) {
+		if _, _, inner, ok := splitDiskImagePath(p); ok && (inner != "" || !isWriteOp(op)) {
+			return flags | proto.RespFromImage
+		}
+	}
+	return flags
+}
+
+// hasPathPayload reports whether op's payload starts with the W64 path(s) it
+// works on (see writeLockPaths).
+func hasPathPayload(op byte) bool {
+	switch op {
+	case proto.OpLS, proto.OpSTAT, proto.OpREAD_RANGE, proto.OpWRITE_RANGE, proto.OpAPPEND, proto.OpMKDIR,
+		proto.OpRMDIR, proto.OpRM, proto.OpCP, proto.OpMV, proto.OpSEARCH, proto.OpHASH, proto.OpDIRMTIME,
+		proto.OpTREE, proto.OpREAD_TAIL, proto.OpTOUCH, proto.OpCOPY_RANGE, proto.OpLS_TREE,
+		proto.OpEXISTS_EXACT, proto.OpVERIFY, proto.OpDIRHASH:
+		return true
+	default:
+		return false
+	}
+}
+
+func readT64FileRange(imgAbs string, fe *diskimage.FileEntry, offset, length uint64) ([]byte, error) {
+	return diskimage.ReadFileRange(imgAbs, fe, offset, length)
+}
+
+// readImageFileRange reads from a file inside an image of the given kind
+// ("d64", "d71", "d81", "t64" or "d82").
+func readImageFileRange(kind, imgAbs string, fe *diskimage.FileEntry, offset, length uint64) ([]byte, error) {
+	switch kind {
+	case "d71":
+		return readD71FileRange(imgAbs, fe, offset, length)
+	case "d81":
+		return readD81FileRange(imgAbs, fe, offset, length)
+	case "t64":
+		return readT64FileRange(imgAbs, fe, offset, length)
+	case "d82":
+		return readD82FileRange(imgAbs, fe, offset, length)
+	}
+	return readD64FileRange(imgAbs, fe, offset, length)
+}
+
+// searchImageEntries returns the image file and the files in the root
+// directory of the image at mountPath, for SEARCH. D81 partitions are left
+// out. ok is false if the image cannot be read.
+func searchImageEntries(rootAbs, kind, mountPath string) (imgAbs string, files []*diskimage.FileEntry, ok bool) {
+	var all []*diskimage.FileEntry
+	switch kind {
+	case "d64":
+		abs, img, st, _ := resolveD64Mount(rootAbs, mountPath)
+		if st != proto.StatusOK {
+			return "", nil, false
+		}
+		imgAbs, all = abs, img.SortedEntries()
+	case "d71":
+		abs, img, st, _ := resolveD71Mount(rootAbs, mountPath)
+		if st != proto.StatusOK {
+			return "", nil, false
+		}
+		imgAbs, all = abs, img.SortedEntries()
+	case "d81":
+		abs, img, st, _ := resolveD81Mount(rootAbs, mountPath)
+		if st != proto.StatusOK {
+			return "", nil, false
+		}
+		imgAbs, all = abs, img.SortedEntries()
+	case "t64":
+		abs, img, st, _ := resolveT64Mount(rootAbs, mountPath)
+		if st != proto.StatusOK {
+			return "", nil, false
+		}
+		imgAbs, all = abs, img.SortedEntries()
+	case "d82":
+		abs, img, st, _ := resolveD82Mount(rootAbs, mountPath)
+		if st != proto.StatusOK {
+			return "", nil, false
+		}
+		imgAbs, all = abs, img.SortedEntries()
+	default:
+		return "", nil, false
+	}
+	for _, fe := range all {
+		if fe.Type == 5 || fe.Type == 6 {
+			continue
+		}
+		files = append(files, fe)
+	}
+	return imgAbs, files, true
+}
+
+// splitD82Path checks whether p contains a ".d82" segment and splits it into:
+//
+//	mountPath: the path up to and including the .d82 segment
+//	innerPath: the remaining path inside the image ("" means image root)
+func splitD82Path(p string) (mountPath, innerPath string, ok bool) {
+	if p == "" || p[0] != '/' {
+		return "", "", false
+	}
+	trim := strings.TrimPrefix(p, "/")
+	if trim == "" {
+		return "", "", false
+	}
+	segs := strings.Split(trim, "/")
+	for i, seg := range segs {
+		if isD82Segment(seg) {
+			mountPath = "/" + strings.Join(segs[:i+1], "/")
+			if i+1 < len(segs) {
+				innerPath = strings.Join(segs[i+1:], "/")
+			} else {
+				innerPath = ""
+			}
+			return mountPath, innerPath, true
+		}
+	}
+	return "", "", false
+}
+
+func isD82Segment(seg string) bool {
+	ext := strings.TrimSpace(filepath.Ext(seg))
+	return strings.EqualFold(ext, ".d82")
+}
+
+// resolveD82Mount validates the mount path and loads/parses the 8250 image.
+// D82 images are read-only.
+func resolveD82Mount(rootAbs string, mountPath string) (imgAbs string, img *diskimage.D82, status byte, msg string) {
+	abs, err := fsops.ToOSPath(rootAbs, mountPath)
+	if err != nil {
+		return "", nil, proto.StatusInvalidPath, err.Error()
+	}
+	// First ensure the path contains no symlink components.
+	if err := fsops.LstatNoSymlink(rootAbs, abs, false); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil, proto.StatusNotFound, "image not found"
+		}
+		return "", nil, proto.StatusInvalidPath, err.Error()
+	}
+	st, err := fsops.Stat(abs)
+	if err != nil {
+		return "", nil, proto.StatusInternal, err.Error()
+	}
+	if !st.Exists || st.IsDir {
+		return "", nil, proto.StatusNotFound, "image not found"
+	}
+
+	if st, msg := checkDiskImageContent(abs, "d82"); st != proto.StatusOK {
+		return "", nil, st, msg
+	}
+
+	img, err = diskimage.LoadD82(abs)
+	if err != nil {
+		st, msg := imageLoadError(err, "d82")
+		return "", nil, st, msg
+	}
+	return abs, img, proto.StatusOK, ""
+}
+
+// resolveD82Inner resolves an inner path inside a D82 image to a file entry.
+// Supports wildcards (*, ?) in the last segment.
+func resolveD82Inner(img *diskimage.D82, innerPath string, fallbackPRG bool) (name string, fe *diskimage.FileEntry, status byte, msg string) {
+	if innerPath == "" {
+		return "", nil, proto.StatusIsADir, "is a directory"
+	}
+	// 8250 directories are flat.
+	if strings.Contains(innerPath, "/") {
+		return "", nil, proto.StatusNotFound, "file not found"
+	}
+
+	name = strings.ToUpper(innerPath)
+	if strings.ContainsAny(name, "*?") {
+		for _, e := range img.SortedEntries() {
+			if wildcardMatch(name, strings.ToUpper(e.Name)) {
+				return strings.ToUpper(e.Name), e, proto.StatusOK, ""
+			}
+		}
+		return "", nil, proto.StatusNotFound, "file not found"
+	}
+
+	if e, ok := img.Lookup(name); ok {
+		return name, e, proto.StatusOK, ""
+	}
+	if fallbackPRG && !strings.Contains(innerPath, ".") {
+		if e, ok := img.Lookup(name + ".PRG"); ok {
+			return name + ".PRG", e, proto.StatusOK, ""
+		}
+	}
+	return "", nil, proto.StatusNotFound, "file not found"
+}
+
+func readD82FileRange(imgAbs string, fe *diskimage.FileEntry, offset, length uint64) ([]byte, error) {
+	return diskimage.ReadFileRange(imgAbs, fe, offset, length)
 }
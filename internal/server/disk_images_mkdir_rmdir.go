@@ -74,20 +74,24 @@ func diskImageLabelFromPath(p string) string {
 	return base
 }
 
-func emptyDiskImageBytes(kind diskImageKind, label string) ([]byte, error) {
+// emptyDiskImageBytes returns a freshly formatted image. id is the 2-char
+// disk ID ("00" when empty; longer IDs are cut).
+func emptyDiskImageBytes(kind diskImageKind, label, id string) ([]byte, error) {
+	idb := []byte("00")
+	copy(idb, strings.ToUpper(id))
 	switch kind {
 	case diskImageD64:
-		return emptyD64Bytes(label), nil
+		return emptyD64Bytes(label, idb), nil
 	case diskImageD71:
-		return emptyD71Bytes(label), nil
+		return emptyD71Bytes(label, idb), nil
 	case diskImageD81:
-		return emptyD81Bytes(label), nil
+		return emptyD81Bytes(label, idb), nil
 	default:
 		return nil, fmt.Errorf("unknown disk image kind")
 	}
 }
 
-func emptyD64Bytes(label string) []byte {
+func emptyD64Bytes(label string, id []byte) []byte {
 	// Standard 35-track 1541 layout: 683 sectors * 256 = 174848 bytes.
 	sectorsPerTrack := func(track int) int {
 		switch {
@@ -139,8 +143,8 @@ func emptyD64Bytes(label string) []byte {
 	// as odd characters on the C64 (often '@').
 	bam[0xA0] = 0xA0
 	bam[0xA1] = 0xA0
-	bam[0xA2] = '0'
-	bam[0xA3] = '0'
+	bam[0xA2] = id[0]
+	bam[0xA3] = id[1]
 	bam[0xA4] = 0xA0
 	bam[0xA5] = '2'
 	bam[0xA6] = 'A'
@@ -195,7 +199,7 @@ func emptyD64Bytes(label string) []byte {
 	return img
 }
 
-func emptyD71Bytes(label string) []byte {
+func emptyD71Bytes(label string, id []byte) []byte {
 	// D71 is a 1571 double-sided image: 70 tracks total, 1366 sectors.
 	//
 	// Important: The 1571 BAM format differs from a simple "two D64 BAMs".
@@ -288,8 +292,8 @@ func emptyD71Bytes(label string) []byte {
 	// Fill standard padding/ID fields.
 	bam0[0xA0] = 0xA0
 	bam0[0xA1] = 0xA0
-	bam0[0xA2] = '0'
-	bam0[0xA3] = '0'
+	bam0[0xA2] = id[0]
+	bam0[0xA3] = id[1]
 	bam0[0xA4] = 0xA0
 	bam0[0xA5] = '2'
 	bam0[0xA6] = 'A'
@@ -367,7 +371,7 @@ func emptyD71Bytes(label string) []byte {
 	return img
 }
 
-func emptyD81Bytes(label string) []byte {
+func emptyD81Bytes(label string, id []byte) []byte {
 	// Standard 1581 layout: 80 tracks * 40 sectors * 256 = 819200 bytes.
 	const (
 		tracks          = 80
@@ -402,8 +406,8 @@ func emptyD81Bytes(label string) []byte {
 	// 0x00, they may show up as odd characters in some directory listings.
 	hdr[0x14] = 0xA0
 	hdr[0x15] = 0xA0
-	hdr[0x16] = '0'
-	hdr[0x17] = '0'
+	hdr[0x16] = id[0]
+	hdr[0x17] = id[1]
 	hdr[0x18] = 0xA0
 	// DOS+disk version is "3D" (0x33, 0x44) on a standard 1581.
 	hdr[0x19] = '3'
@@ -434,6 +438,18 @@ func emptyD81Bytes(label string) []byte {
 		bam1[i] = 0
 		bam2[i] = 0
 	}
+	// Link 40/1 -> 40/2 -> end, DOS version 'D' and its complement, a copy of
+	// the disk ID and the I/O byte, as the 1581 formats them.
+	for i, bam := range [][]byte{bam1, bam2} {
+		if i == 0 {
+			bam[0], bam[1] = byte(dirTrack), 2
+		} else {
+			bam[0], bam[1] = 0, 0xFF
+		}
+		bam[2], bam[3] = 'D', 0xBB
+		bam[4], bam[5] = id[0], id[1]
+		bam[6] = 0xC0
+	}
 	for t := 1; t <= tracks; t++ {
 		var sec []byte
 		var idx int
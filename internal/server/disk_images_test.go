@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+// statfs returns the total/free/used numbers of STATFS p.
+func statfs(t *testing.T, s *Server, cfg config.Config, limits Limits, rootAbs, p string) [3]uint32 {
+	t.Helper()
+	st, resp, msg := s.dispatch(cfg, limits, proto.OpSTATFS, 0, pathPayload(p), rootAbs)
+	if st != proto.StatusOK {
+		t.Fatalf("STATFS %s = %s (%s)", p, statusName(st), msg)
+	}
+	d := proto.NewDecoder(resp)
+	var out [3]uint32
+	for i := range out {
+		v, err := d.ReadU32()
+		if err != nil {
+			t.Fatalf("STATFS %s: %v", p, err)
+		}
+		out[i] = v
+	}
+	return out
+}
+
+func TestSTATFSDiskImageBlocks(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	limits := Limits{DiskImagesEnabled: true, DiskImagesWriteEnabled: true}
+	mkImage(t, s, cfg, limits, rootAbs, "/E.D64", proto.ImageKindD64)
+	mkImage(t, s, cfg, limits, rootAbs, "/P.D64", proto.ImageKindD64)
+	mkImage(t, s, cfg, limits, rootAbs, "/E.D81", proto.ImageKindD81)
+
+	// 1000 bytes need 4 blocks of 254 data bytes.
+	if st, _, msg := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, proto.FlagWR_CREATE, writeRangePayload(t, "/P.D64/F", 0, bytes.Repeat([]byte{1}, 1000)), rootAbs); st != proto.StatusOK {
+		t.Fatalf("WRITE_RANGE = %s (%s)", statusName(st), msg)
+	}
+
+	for _, tc := range []struct {
+		path              string
+		total, free, used uint32
+	}{
+		{"/E.D64", 664 * 256, 664 * 256, 0},
+		{"/P.D64", 664 * 256, 660 * 256, 4 * 256},
+		{"/P.D64/F", 664 * 256, 660 * 256, 4 * 256},
+		// 80 tracks of 40 sectors minus the directory track.
+		{"/E.D81", 3160 * 256, 3160 * 256, 0},
+	} {
+		got := statfs(t, s, cfg, limits, rootAbs, tc.path)
+		if want := [3]uint32{tc.total, tc.free, tc.used}; got != want {
+			t.Errorf("STATFS %s = %v, want %v", tc.path, got, want)
+		}
+	}
+
+	// Without disk images the mount is a plain file.
+	if got := statfs(t, s, cfg, Limits{}, rootAbs, "/E.D64"); got[0] == 664*256 {
+		t.Errorf("STATFS /E.D64 without disk images = %v, want host numbers", got)
+	}
+}
+
+func TestRespFromImage(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, func(c *config.Config) {
+		c.DiskImagesWriteEnabled = true
+		c.Tokens = []config.TokenEntry{{Token: "T"}}
+	})
+	limits := Limits{DiskImagesEnabled: true, DiskImagesWriteEnabled: true}
+	mkImage(t, s, cfg, limits, rootAbs, "/DISK.D64", proto.ImageKindD64)
+	mkImage(t, s, cfg, limits, rootAbs, "/OLD.D64", proto.ImageKindD64)
+	if st, _, msg := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, proto.FlagWR_CREATE, writeRangePayload(t, "/DISK.D64/F", 0, []byte{1, 8, 0}), rootAbs); st != proto.StatusOK {
+		t.Fatalf("WRITE_RANGE = %s (%s)", statusName(st), msg)
+	}
+	writeFiles(t, rootAbs, map[string]string{"F": "abc"})
+	read := func(p string) []byte {
+		return encode(func(e *proto.Encoder) {
+			_ = e.WriteString(p)
+			e.WriteU32(0)
+			e.WriteU16(3)
+		})
+	}
+
+	for _, tc := range []struct {
+		name    string
+		op      byte
+		payload []byte
+		want    bool
+	}{
+		{"STAT inside the image", proto.OpSTAT, pathPayload("/DISK.D64/F"), true},
+		{"READ_RANGE inside the image", proto.OpREAD_RANGE, read("/DISK.D64/F"), true},
+		{"LS of the image", proto.OpLS, encode(func(e *proto.Encoder) { _ = e.WriteString("/DISK.D64"); e.WriteU16(0); e.WriteU16(0) }), true},
+		{"STAT of a host file", proto.OpSTAT, pathPayload("/F"), false},
+		{"READ_RANGE of a host file", proto.OpREAD_RANGE, read("/F"), false},
+		{"STAT of a missing file inside the image", proto.OpSTAT, pathPayload("/DISK.D64/NOPE"), false},
+		{"RM of the image file", proto.OpRM, pathPayload("/OLD.D64"), false},
+	} {
+		resp := rpcRaw(t, s, "T", tc.op, 0, tc.payload)
+		if got := resp[7]&proto.RespFromImage != 0; got != tc.want {
+			t.Errorf("%s: %s, RespFromImage = %v, want %v", tc.name, statusName(resp[6]), got, tc.want)
+		}
+	}
+}
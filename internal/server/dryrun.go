@@ -0,0 +1,139 @@
+package server
+
+import (
+	"strings"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+// dryRunMaxPaths bounds the paths a dry run collects; the count keeps going.
+const dryRunMaxPaths = 256
+
+// dryRunMsg is the message of a successful dry run response; the previews
+// use it to tell the dry run payload apart from the op's own.
+const dryRunMsg = "dry run, nothing changed"
+
+// dryRun collects what RM, RMDIR, CP or MV would change with
+// proto.FlagDRY_RUN. The ops run all their checks (including quota) and
+// call skip right before each change; on the nil *dryRun of a normal request
+// skip reports false and the op goes ahead.
+type dryRun struct {
+	count int
+	paths []string
+}
+
+// skip records p (a W64 path) as affected and reports whether the change
+// must be skipped.
+func (r *dryRun) skip(p string) bool {
+	if r == nil {
+		return false
+	}
+	r.count++
+	if len(r.paths) < dryRunMaxPaths {
+		r.paths = append(r.paths, p)
+	}
+	return true
+}
+
+// skipAbs is skip for a host path below rootAbs.
+func (r *dryRun) skipAbs(rootAbs, abs string) bool {
+	if r == nil {
+		return false
+	}
+	p, err := osAbsToW64Path(rootAbs, abs)
+	if err != nil {
+		p = abs
+	}
+	return r.skip(p)
+}
+
+func isDryRunOp(op byte) bool {
+	switch op {
+	case proto.OpRM, proto.OpRMDIR, proto.OpCP, proto.OpMV:
+		return true
+	default:
+		return false
+	}
+}
+
+// runDryRun runs op with a dry run collector instead of runOp: nothing is
+// changed, so there is no bookkeeping or audit entry afterwards.
+//
+// Response: count u16 (affected paths, saturating), listed u16, then listed
+// path strings. RM/RMDIR list the removed paths, CP/MV the destinations.
+// The list stops early at max_entries or when max_payload is reached.
+func (s *Server) runDryRun(cfg config.Config, limits Limits, op byte, flags byte, payload []byte, rootAbs string) (byte, []byte, string) {
+	if op == proto.OpCP && flags&proto.FlagCP_ASYNC != 0 {
+		return proto.StatusBadRequest, nil, "async CP cannot be a dry run"
+	}
+	dr := &dryRun{}
+	limits.dryRun = dr
+	status, _, errMsg := s.dispatchOp(cfg, limits, op, flags&^proto.FlagDRY_RUN, payload, rootAbs)
+	if status != proto.StatusOK {
+		return status, nil, errMsg
+	}
+
+	count := dr.count
+	if count > 0xFFFF {
+		count = 0xFFFF
+	}
+	max := len(dr.paths)
+	if cfg.MaxEntries > 0 && max > int(cfg.MaxEntries) {
+		max = int(cfg.MaxEntries)
+	}
+	body := proto.NewEncoder(256)
+	listed := 0
+	for _, p := range dr.paths[:max] {
+		if 4+len(body.Bytes())+2+len(p) > int(cfg.MaxPayload) {
+			break
+		}
+		_ = body.WriteString(p)
+		listed++
+	}
+	e := proto.NewEncoder(4 + len(body.Bytes()))
+	e.WriteU16(uint16(count))
+	e.WriteU16(uint16(listed))
+	e.WriteBytes(body.Bytes())
+	return proto.StatusOK, e.Bytes(), dryRunMsg
+}
+
+// imageFile checks that the file a dry run would change inside a mounted
+// image exists (the image write checks that itself) and records p.
+func (r *dryRun) imageFile(rootAbs, kind, mountPath, inner string, fallbackPRG bool, p string) (byte, string) {
+	if _, _, st, msg := resolveDiskImageFile(rootAbs, kind, mountPath, inner, fallbackPRG); st != proto.StatusOK {
+		return st, msg
+	}
+	r.skip(p)
+	return proto.StatusOK, ""
+}
+
+// imageMove checks a rename inside a mounted image like imageFile and
+// refuses an existing destination without overwrite. D81 directories count
+// as existing entries. p (the destination) is recorded.
+func (r *dryRun) imageMove(rootAbs, kind, mountPath, srcInner, dstInner string, overwrite, fallbackPRG bool, p string) (byte, string) {
+	_, _, st, msg := resolveDiskImageFile(rootAbs, kind, mountPath, srcInner, fallbackPRG)
+	if st != proto.StatusOK && !(kind == "d81" && st == proto.StatusIsADir) {
+		return st, msg
+	}
+	if !overwrite {
+		if _, _, st, _ := resolveDiskImageFile(rootAbs, kind, mountPath, dstInner, fallbackPRG); st == proto.StatusOK || (kind == "d81" && st == proto.StatusIsADir) {
+			return proto.StatusAlreadyExists, "destination exists"
+		}
+	}
+	r.skip(p)
+	return proto.StatusOK, ""
+}
+
+// imageWrite checks a file write into a mounted image: without overwrite an
+// existing name is refused like the image write would. Free blocks are not
+// checked. mountPath + "/" + inner is recorded.
+func (r *dryRun) imageWrite(rootAbs, kind, mountPath, inner string, overwrite bool) (byte, string) {
+	if !overwrite {
+		if _, _, st, _ := resolveDiskImageFile(rootAbs, kind, mountPath, inner, false); st == proto.StatusOK || (kind == "d81" && st == proto.StatusIsADir) {
+			return proto.StatusAccessDenied, "overwrite is disabled"
+		}
+	}
+	r.skip(strings.TrimSuffix(mountPath, "/") + "/" + strings.ToUpper(inner))
+	return proto.StatusOK, ""
+}
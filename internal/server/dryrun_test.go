@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"wicos64-server/internal/proto"
+)
+
+// dryRunResult decodes a dry run answer: count u16, listed u16, paths.
+func dryRunResult(t *testing.T, resp []byte) (int, []string) {
+	t.Helper()
+	d := proto.NewDecoder(resp)
+	count, _ := d.ReadU16()
+	listed, _ := d.ReadU16()
+	var paths []string
+	for i := 0; i < int(listed); i++ {
+		p, err := d.ReadString(0xFFFF)
+		if err != nil {
+			t.Fatalf("dry run path %d: %v", i, err)
+		}
+		paths = append(paths, p)
+	}
+	if d.Remaining() != 0 {
+		t.Fatalf("dry run answer has %d extra bytes", d.Remaining())
+	}
+	return int(count), paths
+}
+
+func TestDryRunWildcardCopy(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	limits := Limits{DiskImagesEnabled: true, DiskImagesWriteEnabled: true}
+	writeFiles(t, rootAbs, map[string]string{
+		"DEV1/A.PRG": "aaaa",
+		"DEV1/B.PRG": "bbbb",
+		"DEV1/C.PRG": "cccc",
+		"DEV1/D.SEQ": "dddd",
+	})
+	if err := os.Mkdir(filepath.Join(rootAbs, "OUT"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	mkImage(t, s, cfg, limits, rootAbs, "/DISK.D81", proto.ImageKindD81)
+	image, err := os.ReadFile(filepath.Join(rootAbs, "DISK.D81"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dst := range []string{"/OUT", "/DISK.D81"} {
+		st, resp, msg := s.dispatch(cfg, limits, proto.OpCP, proto.FlagDRY_RUN, cpPayload("/DEV1/*.PRG", dst), rootAbs)
+		if st != proto.StatusOK || msg != dryRunMsg {
+			t.Fatalf("dry run CP into %s = %s (%s)", dst, statusName(st), msg)
+		}
+		if count, paths := dryRunResult(t, resp); count != 3 || len(paths) != 3 {
+			t.Fatalf("dry run CP into %s = %d %q, want 3 paths", dst, count, paths)
+		}
+	}
+	if ents, _ := os.ReadDir(filepath.Join(rootAbs, "OUT")); len(ents) != 0 {
+		t.Fatalf("dry run CP wrote %d files into /OUT", len(ents))
+	}
+	if after, _ := os.ReadFile(filepath.Join(rootAbs, "DISK.D81")); !bytes.Equal(after, image) {
+		t.Fatal("dry run CP changed the image")
+	}
+
+	// The real copy affects what the dry run reported.
+	if st, _, msg := s.dispatch(cfg, limits, proto.OpCP, 0, cpPayload("/DEV1/*.PRG", "/OUT"), rootAbs); st != proto.StatusOK {
+		t.Fatalf("CP = %s (%s)", statusName(st), msg)
+	}
+	if ents, _ := os.ReadDir(filepath.Join(rootAbs, "OUT")); len(ents) != 3 {
+		t.Fatalf("CP wrote %d files into /OUT, want 3", len(ents))
+	}
+	// Checks still run: without overwrite the dry run fails like the copy.
+	dry, _, _ := s.dispatch(cfg, limits, proto.OpCP, proto.FlagDRY_RUN, cpPayload("/DEV1/*.PRG", "/OUT"), rootAbs)
+	done, _, _ := s.dispatch(cfg, limits, proto.OpCP, 0, cpPayload("/DEV1/*.PRG", "/OUT"), rootAbs)
+	if dry == proto.StatusOK || dry != done {
+		t.Fatalf("CP over existing files: dry run %s, copy %s", statusName(dry), statusName(done))
+	}
+	st, resp, _ := s.dispatch(cfg, limits, proto.OpCP, proto.FlagDRY_RUN|proto.FlagCP_OVERWRITE, cpPayload("/DEV1/*.PRG", "/OUT"), rootAbs)
+	if count, _ := dryRunResult(t, resp); st != proto.StatusOK || count != 3 {
+		t.Fatalf("dry run CP with overwrite = %s, %d paths", statusName(st), count)
+	}
+	if st, _, _ := s.dispatch(cfg, limits, proto.OpCP, proto.FlagDRY_RUN|proto.FlagCP_ASYNC, cpPayload("/DEV1/A.PRG", "/OUT/X"), rootAbs); st != proto.StatusBadRequest {
+		t.Fatalf("async dry run CP = %s, want BAD_REQUEST", statusName(st))
+	}
+}
+
+func TestDryRunRemoveMove(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	writeFiles(t, rootAbs, map[string]string{
+		"A":         "a",
+		"DIR/X":     "x",
+		"DIR/SUB/Y": "y",
+	})
+
+	for _, tc := range []struct {
+		name    string
+		op      byte
+		flags   byte
+		payload []byte
+		want    []string
+	}{
+		{"RM", proto.OpRM, 0, pathPayload("/A"), []string{"/A"}},
+		{"MV", proto.OpMV, 0, cpPayload("/A", "/B"), []string{"/B"}},
+		{"RMDIR of a full dir", proto.OpRMDIR, proto.FlagRD_RECURSIVE, pathPayload("/DIR"), nil},
+	} {
+		st, resp, msg := s.dispatch(cfg, Limits{}, tc.op, tc.flags|proto.FlagDRY_RUN, tc.payload, rootAbs)
+		if st != proto.StatusOK || msg != dryRunMsg {
+			t.Fatalf("%s dry run = %s (%s)", tc.name, statusName(st), msg)
+		}
+		count, paths := dryRunResult(t, resp)
+		if tc.want != nil && (count != len(tc.want) || len(paths) != len(tc.want) || paths[0] != tc.want[0]) {
+			t.Fatalf("%s dry run = %d %q, want %q", tc.name, count, paths, tc.want)
+		}
+		if tc.want == nil && count == 0 {
+			t.Fatalf("%s dry run reported nothing", tc.name)
+		}
+	}
+	for _, p := range []string{"A", "DIR/X", "DIR/SUB/Y"} {
+		if _, err := os.Stat(filepath.Join(rootAbs, filepath.FromSlash(p))); err != nil {
+			t.Fatalf("%s after the dry runs: %v", p, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(rootAbs, "B")); err == nil {
+		t.Fatal("dry run MV created /B")
+	}
+	if st, _, _ := s.dispatch(cfg, Limits{}, proto.OpRM, proto.FlagDRY_RUN, pathPayload("/MISSING"), rootAbs); st != proto.StatusNotFound {
+		t.Fatalf("dry run RM of a missing file = %s, want NOT_FOUND", statusName(st))
+	}
+}
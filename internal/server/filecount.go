@@ -0,0 +1,245 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/fsops"
+	"wicos64-server/internal/proto"
+)
+
+// rootFileCount returns the number of entries (files + directories) below rootAbs.
+// The value is cached alongside the byte usage and shares its TTL.
+func (s *Server) rootFileCount(rootAbs string) (uint64, error) {
+	if s.usage != nil {
+		if n, ok := s.usage.getFreshFiles(rootAbs); ok {
+			return n, nil
+		}
+	}
+	n, err := countTreeEntries(rootAbs)
+	if err != nil {
+		return 0, err
+	}
+	if s.usage != nil {
+		s.usage.setFiles(rootAbs, n)
+	}
+	return n, nil
+}
+
+func (s *Server) invalidateRootFileCount(rootAbs string) {
+	if s.usage != nil {
+		s.usage.invalidateFiles(rootAbs)
+	}
+}
+
+// countTreeEntries counts all entries below dir (dir itself is not counted).
+func countTreeEntries(dir string) (uint64, error) {
+	var n uint64
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != dir {
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// precheckFileCount enforces limits.MaxFiles for ops that can create host
+// filesystem entries (WRITE_RANGE/APPEND with a new target, MKDIR incl. image
+// creation, CP incl. extraction from images).
+//
+// The number of new entries is a best-effort estimate computed from the request;
+// entries created inside disk images do not count (they don't use host inodes).
+func (s *Server) precheckFileCount(cfg config.Config, limits Limits, op, flags byte, payload []byte, rootAbs string) (uint64, byte, string) {
+	if limits.MaxFiles == 0 {
+		return 0, proto.StatusOK, ""
+	}
+	need := s.estimateNewEntries(cfg, limits, op, flags, payload, rootAbs)
+	if need == 0 {
+		return 0, proto.StatusOK, ""
+	}
+	have, err := s.rootFileCount(rootAbs)
+	if err != nil {
+		return 0, proto.StatusInternal, err.Error()
+	}
+	if have+need > limits.MaxFiles {
+		return 0, proto.StatusTooLarge, "file count limit exceeded"
+	}
+	return need, proto.StatusOK, ""
+}
+
+// updateFileCount keeps the cached entry count in sync after an op completed.
+func (s *Server) updateFileCount(op, status byte, newFiles uint64, rootAbs string) {
+	if status != proto.StatusOK {
+		return
+	}
+	switch op {
+	case proto.OpRM, proto.OpRMDIR, proto.OpMV, proto.OpUNLOCK, proto.OpTRASH_RESTORE:
+		s.invalidateRootFileCount(rootAbs)
+	default:
+		if newFiles == 0 || s.usage == nil {
+			return
+		}
+		s.usage.addFiles(rootAbs, newFiles)
+	}
+}
+
+func (s *Server) estimateNewEntries(cfg config.Config, limits Limits, op, flags byte, payload []byte, rootAbs string) uint64 {
+	d := proto.NewDecoder(payload)
+	switch op {
+	case proto.OpWRITE_RANGE, proto.OpAPPEND, proto.OpLOCK, proto.OpMKIMAGE:
+		if op == proto.OpWRITE_RANGE && flags&proto.FlagWR_STAGED != 0 && flags&proto.FlagWR_COMMIT == 0 {
+			// The target only appears on commit.
+			return 0
+		}
+		p, err := s.readPathString(cfg, d)
+		if err != nil || isInsideDiskImage(limits, p) {
+			return 0
+		}
+		return missingEntries(rootAbs, p, false)
+	case proto.OpCOPY_RANGE:
+		if _, err := s.readPathString(cfg, d); err != nil {
+			return 0
+		}
+		if _, err := d.ReadU32(); err != nil {
+			return 0
+		}
+		p, err := s.readPathString(cfg, d)
+		if err != nil || isInsideDiskImage(limits, p) {
+			return 0
+		}
+		return missingEntries(rootAbs, p, false)
+	case proto.OpMKTEMP:
+		// The temp file itself, plus .TMP if it does not exist yet.
+		return 1 + missingEntries(rootAbs, mktempDir, false)
+	case proto.OpTOUCH:
+		if flags&proto.FlagT_CREATE == 0 {
+			return 0
+		}
+		p, err := s.readPathString(cfg, d)
+		if err != nil || isInsideDiskImage(limits, p) {
+			return 0
+		}
+		return missingEntries(rootAbs, p, false)
+	case proto.OpMKDIR:
+		p, err := s.readPathString(cfg, d)
+		if err != nil || isInsideDiskImage(limits, p) {
+			return 0
+		}
+		return missingEntries(rootAbs, p, flags&proto.FlagMK_PARENTS != 0)
+	case proto.OpCP:
+		src, err := s.readPathStringRead(cfg, d)
+		if err != nil {
+			return 0
+		}
+		dst, err := s.readPathString(cfg, d)
+		if err != nil {
+			return 0
+		}
+		if limits.DiskImagesEnabled {
+			if _, mount, inner, ok := splitDiskImagePath(dst); ok {
+				if inner != "" || isRegularFile(rootAbs, mount) {
+					return 0
+				}
+			}
+			if _, _, inner, ok := splitDiskImagePath(src); ok && inner != "" {
+				// Extracting from an image: one file per request (wildcards: best-effort).
+				return 1
+			}
+		}
+		return countSourceEntries(cfg, rootAbs, src)
+	default:
+		return 0
+	}
+}
+
+// isInsideDiskImage reports whether p addresses something inside a mounted image.
+func isInsideDiskImage(limits Limits, p string) bool {
+	if !limits.DiskImagesEnabled {
+		return false
+	}
+	_, _, inner, ok := splitDiskImagePath(p)
+	return ok && inner != ""
+}
+
+func isRegularFile(rootAbs, p string) bool {
+	abs, err := fsops.ToOSPath(rootAbs, p)
+	if err != nil {
+		return false
+	}
+	fi, err := os.Lstat(abs)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// missingEntries returns how many path segments of p do not exist yet.
+// Without parents, only the final segment is considered.
+func missingEntries(rootAbs, p string, parents bool) uint64 {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return 0
+	}
+	segs := strings.Split(p, "/")
+	var n uint64
+	for i := len(segs); i >= 1; i-- {
+		abs, err := fsops.ToOSPath(rootAbs, "/"+strings.Join(segs[:i], "/"))
+		if err != nil {
+			return n
+		}
+		if _, err := os.Lstat(abs); err == nil || !errors.Is(err, fs.ErrNotExist) {
+			return n
+		}
+		n++
+		if !parents {
+			return n
+		}
+	}
+	return n
+}
+
+// countSourceEntries estimates how many entries a filesystem CP source produces.
+func countSourceEntries(cfg config.Config, rootAbs, src string) uint64 {
+	if cfg.Compat.WildcardLoad && strings.ContainsAny(src, "*?") {
+		dirNorm, pat := splitDirBase(src)
+		dirAbs, err := fsops.ToOSPath(rootAbs, dirNorm)
+		if err != nil {
+			return 0
+		}
+		ents, err := os.ReadDir(dirAbs)
+		if err != nil {
+			return 0
+		}
+		var n uint64
+		for _, e := range ents {
+			if !e.IsDir() && wildcardMatch(strings.ToUpper(pat), strings.ToUpper(e.Name())) {
+				n++
+			}
+		}
+		return n
+	}
+	abs, err := fsops.ToOSPath(rootAbs, src)
+	if err != nil {
+		return 0
+	}
+	fi, err := os.Lstat(abs)
+	if err != nil {
+		return 0
+	}
+	if !fi.IsDir() {
+		return 1
+	}
+	n, err := countTreeEntries(abs)
+	if err != nil {
+		return 1
+	}
+	return n + 1
+}
@@ -0,0 +1,321 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/fsops"
+	"wicos64-server/internal/pathutil"
+	"wicos64-server/internal/proto"
+)
+
+const httpFilesPrefix = "/wicos64/files/"
+
+type httpFilesEntry struct {
+	Name      string `json:"name"`
+	Dir       bool   `json:"dir"`
+	Size      int64  `json:"size"`
+	MTimeUnix int64  `json:"mtime_unix"`
+}
+
+type httpFilesIndex struct {
+	Path    string           `json:"path"`
+	Entries []httpFilesEntry `json:"entries"`
+}
+
+// handleHTTPFiles serves GET /wicos64/files/<path>?token=... (config
+// enable_http_files): files are streamed with http.ServeContent (Range,
+// Content-Type, Content-Length), directories are listed as HTML or, with
+// &format=json, as JSON in LS order. PUT/POST uploads a file (see
+// handleHTTPFilesUpload). Paths go through the same normalization and sandbox
+// checks as the RPC ops; symlinks are rejected.
+func (s *Server) handleHTTPFiles(w http.ResponseWriter, r *http.Request) {
+	cfg := s.cfgSnapshot()
+	if !cfg.EnableHTTPFiles {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPost:
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	ctx, ok := cfg.ResolveTokenContext(token)
+	// Tokens bound to an RPC endpoint are not usable here.
+	if !ok || tokenWindowError(ctx, time.Now()) != "" || !ctx.IPAllowed(net.ParseIP(clientIP(cfg, r))) || ctx.Endpoint != "" {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if ctx.RateLimitPerSec > 0 && !s.rate.allow(tokenID(token), ctx.RateLimitPerSec, time.Now()) {
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+		return
+	}
+	rootAbs, err := filepath.Abs(ctx.Root)
+	if err != nil {
+		http.Error(w, "bad root", http.StatusInternalServerError)
+		return
+	}
+	if ctx.MaxBytesPerSec > 0 {
+		// Shape downloads and uploads (max_bytes_per_sec).
+		limits := limitsFromContext(ctx)
+		limits.tokenID = tokenID(token)
+		w = throttledWriter{ResponseWriter: w, s: s, limits: limits}
+		r.Body = throttledReader{ReadCloser: r.Body, s: s, limits: limits}
+	}
+
+	p, err := pathutil.Normalize("/"+strings.TrimPrefix(r.URL.Path, httpFilesPrefix), cfg.MaxPath, cfg.MaxName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p = pathutil.Canonicalize(p)
+	if r.Method == http.MethodPut || r.Method == http.MethodPost {
+		s.handleHTTPFilesUpload(w, r, cfg, ctx, token, rootAbs, p)
+		return
+	}
+	abs, err := fsops.ToOSPath(rootAbs, p)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := fsops.LstatNoSymlink(rootAbs, abs, false); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	f, err := os.Open(abs)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if fi.IsDir() {
+		s.serveHTTPFilesIndex(w, r, abs, p, token)
+		return
+	}
+	if !fi.Mode().IsRegular() {
+		http.Error(w, "not a regular file", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.ServeContent(w, r, strings.ToUpper(fi.Name()), fi.ModTime(), f)
+}
+
+func (s *Server) serveHTTPFilesIndex(w http.ResponseWriter, r *http.Request, abs, p, token string) {
+	ents, err := os.ReadDir(abs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Same order as LS.
+	sort.SliceStable(ents, func(i, j int) bool {
+		return strings.ToUpper(ents[i].Name()) < strings.ToUpper(ents[j].Name())
+	})
+	idx := httpFilesIndex{Path: p, Entries: make([]httpFilesEntry, 0, len(ents))}
+	for _, e := range ents {
+		info, err := e.Info()
+		if err != nil || info.Mode()&os.ModeSymlink != 0 {
+			continue
+		}
+		ent := httpFilesEntry{Name: strings.ToUpper(e.Name()), Dir: info.IsDir(), MTimeUnix: info.ModTime().Unix()}
+		if !ent.Dir {
+			ent.Size = info.Size()
+		}
+		idx.Entries = append(idx.Entries, ent)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if r.URL.Query().Get("format") == "json" {
+		writeJSON(w, http.StatusOK, idx)
+		return
+	}
+
+	base := strings.TrimSuffix(httpFilesPrefix+strings.TrimPrefix(p, "/"), "/")
+	q := "?token=" + url.QueryEscape(token)
+	var b strings.Builder
+	fmt.Fprintf(&b, "<!doctype html><meta charset=\"utf-8\"><title>%s</title><h1>%s</h1><pre>\n", html.EscapeString(p), html.EscapeString(p))
+	if p != "/" {
+		parent := strings.TrimSuffix(base[:strings.LastIndex(base, "/")+1], "/")
+		fmt.Fprintf(&b, "<a href=\"%s/%s\">../</a>\n", html.EscapeString(parent), html.EscapeString(q))
+	}
+	for _, e := range idx.Entries {
+		href := base + "/" + url.PathEscape(e.Name)
+		name := e.Name
+		size := fmt.Sprintf("%10d", e.Size)
+		if e.Dir {
+			href += "/"
+			name += "/"
+			size = fmt.Sprintf("%10s", "&lt;DIR&gt;")
+		}
+		fmt.Fprintf(&b, "%s  %s  <a href=\"%s\">%s</a>\n", time.Unix(e.MTimeUnix, 0).UTC().Format("2006-01-02 15:04"), size, html.EscapeString(href+q), html.EscapeString(name))
+	}
+	b.WriteString("</pre>\n")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write([]byte(b.String()))
+	}
+}
+
+// httpFilesMaxUpload bounds an upload body (it is buffered in memory); the
+// token's max_file_bytes applies if smaller.
+const httpFilesMaxUpload = 64 << 20
+
+type httpFilesUploadResponse struct {
+	OK    bool   `json:"ok"`
+	Path  string `json:"path"`
+	Bytes int    `json:"bytes"`
+}
+
+// handleHTTPFilesUpload stores the request body as file p. It goes through
+// writeHostFileRange like a WRITE_RANGE with CREATE|TRUNCATE, so read-only,
+// disabled_ops, quota, max_file_bytes and overwrite protection behave the
+// same: replacing a non-empty file needs &overwrite=1 (and enable_overwrite).
+// Missing parent directories are created with &parents=1. Disk image paths
+// are rejected.
+func (s *Server) handleHTTPFilesUpload(w http.ResponseWriter, r *http.Request, cfg config.Config, ctx config.TokenContext, token, rootAbs, p string) {
+	limits := limitsFromContext(ctx)
+	limits.tokenID = tokenID(token)
+	limits.tokenName = ctx.Name
+	if limits.ReadOnly {
+		http.Error(w, "read-only mode", http.StatusForbidden)
+		return
+	}
+	// An upload is a WRITE_RANGE, and with &parents=1 also a MKDIR.
+	if opDisabled(cfg, proto.OpWRITE_RANGE) || (r.URL.Query().Get("parents") == "1" && opDisabled(cfg, proto.OpMKDIR)) {
+		http.Error(w, "op disabled by policy", http.StatusForbidden)
+		return
+	}
+	if p == "/" {
+		http.Error(w, "is a directory", http.StatusConflict)
+		return
+	}
+	if isInsideDiskImage(limits, p) {
+		http.Error(w, "uploads into disk images are not supported", http.StatusBadRequest)
+		return
+	}
+
+	max := int64(httpFilesMaxUpload)
+	if limits.MaxFileBytes > 0 && limits.MaxFileBytes < uint64(max) {
+		max = int64(limits.MaxFileBytes)
+	}
+	if r.ContentLength > max {
+		http.Error(w, "max file size exceeded", http.StatusRequestEntityTooLarge)
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, max))
+	if err != nil {
+		http.Error(w, "max file size exceeded", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	releaseQuota, limits := s.lockQuota(limits, rootAbs)
+	defer releaseQuota()
+	if s.quotaFull(limits, rootAbs) {
+		http.Error(w, "read-only: quota full", http.StatusForbidden)
+		return
+	}
+	release, _ := s.lockPaths(limits, rootAbs, true, p)
+	defer release()
+
+	q := r.URL.Query()
+	parents := q.Get("parents") == "1"
+	newFiles, st, msg := uint64(0), proto.StatusOK, ""
+	if limits.MaxFiles > 0 {
+		newFiles = missingEntries(rootAbs, p, parents)
+		if have, err := s.rootFileCount(rootAbs); err != nil {
+			st, msg = proto.StatusInternal, err.Error()
+		} else if have+newFiles > limits.MaxFiles {
+			st, msg = proto.StatusTooLarge, "file count limit exceeded"
+		}
+	}
+	if st == proto.StatusOK && parents {
+		st, msg = makeHTTPFilesParents(rootAbs, p)
+	}
+	if st == proto.StatusOK {
+		flags := byte(proto.FlagWR_CREATE | proto.FlagWR_TRUNCATE)
+		if q.Get("overwrite") == "1" {
+			flags |= proto.FlagWR_OVERWRITE
+		}
+		st, _, msg = s.writeHostFileRange(cfg, limits, flags, rootAbs, p, 0, data)
+	}
+	s.updateFileCount(proto.OpWRITE_RANGE, st, newFiles, rootAbs)
+	if cfg.AuditLogDir != "" {
+		s.auditToken(cfg, limits, "HTTP_PUT", fmt.Sprintf("path=%s len=%d", p, len(data)), st, msg)
+	}
+
+	if st != proto.StatusOK {
+		http.Error(w, msg, httpStatusFor(st))
+		return
+	}
+	writeJSON(w, http.StatusOK, httpFilesUploadResponse{OK: true, Path: p, Bytes: len(data)})
+}
+
+// makeHTTPFilesParents creates the missing parent directories of p after
+// checking the existing part of the path for symlinks.
+func makeHTTPFilesParents(rootAbs, p string) (byte, string) {
+	abs, err := fsops.ToOSPath(rootAbs, p)
+	if err != nil {
+		return proto.StatusInvalidPath, err.Error()
+	}
+	parent := filepath.Dir(abs)
+	existing := parent
+	for {
+		if _, err := os.Lstat(existing); !errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	if err := fsops.LstatNoSymlink(rootAbs, existing, false); err != nil {
+		return proto.StatusInvalidPath, err.Error()
+	}
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return proto.StatusInternal, err.Error()
+	}
+	return proto.StatusOK, ""
+}
+
+// httpStatusFor maps a W64F status to an HTTP status code.
+func httpStatusFor(st byte) int {
+	switch st {
+	case proto.StatusOK:
+		return http.StatusOK
+	case proto.StatusNotFound:
+		return http.StatusNotFound
+	case proto.StatusAccessDenied:
+		return http.StatusForbidden
+	case proto.StatusAlreadyExists, proto.StatusIsADir:
+		return http.StatusConflict
+	case proto.StatusTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case proto.StatusInvalidPath, proto.StatusBadRequest, proto.StatusRangeInvalid:
+		return http.StatusBadRequest
+	case proto.StatusBusy:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
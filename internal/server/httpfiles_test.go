@@ -0,0 +1,161 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"wicos64-server/internal/config"
+)
+
+func TestHTTPFilesGET(t *testing.T) {
+	s, _, rootAbs := newTestServer(t, func(c *config.Config) { c.EnableHTTPFiles = true })
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "SECRET"), []byte("top secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(rootAbs, "DIR"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(rootAbs, "DIR", "FILE"), []byte("hello world"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	haveLink := os.Symlink(outside, filepath.Join(rootAbs, "ESC")) == nil &&
+		os.Symlink(filepath.Join(outside, "SECRET"), filepath.Join(rootAbs, "LINK")) == nil
+
+	get := func(target string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", target, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		s.handleHTTPFiles(w, r)
+		return w
+	}
+
+	if w := get(httpFilesPrefix + "DIR/FILE"); w.Code != http.StatusOK || w.Body.String() != "hello world" {
+		t.Fatalf("GET file = %d %q", w.Code, w.Body.String())
+	}
+	if w := get(httpFilesPrefix+"dir/file", "Range", "bytes=6-"); w.Code != http.StatusPartialContent || w.Body.String() != "world" {
+		t.Fatalf("GET range = %d %q", w.Code, w.Body.String())
+	}
+	w := get(httpFilesPrefix + "?format=json")
+	var idx httpFilesIndex
+	if err := json.Unmarshal(w.Body.Bytes(), &idx); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("GET index = %d %q (%v)", w.Code, w.Body.String(), err)
+	}
+	for _, e := range idx.Entries {
+		if e.Name == "ESC" || e.Name == "LINK" {
+			t.Fatalf("index lists symlink %s", e.Name)
+		}
+	}
+	if len(idx.Entries) != 1 || idx.Entries[0].Name != "DIR" || !idx.Entries[0].Dir {
+		t.Fatalf("index = %+v", idx.Entries)
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		symlink bool
+	}{
+		{"dot-dot", httpFilesPrefix + "../SECRET", false},
+		{"dot-dot below a dir", httpFilesPrefix + "DIR/../../SECRET", false},
+		{"escaped dot-dot", httpFilesPrefix + "%2E%2E/SECRET", false},
+		{"backslash", httpFilesPrefix + "DIR%5C..%5C..%5CSECRET", false},
+		{"missing file", httpFilesPrefix + "NOPE", false},
+		{"symlinked dir", httpFilesPrefix + "ESC/SECRET", true},
+		{"symlinked file", httpFilesPrefix + "LINK", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.symlink && !haveLink {
+				t.Skip("symlinks not supported")
+			}
+			w := get(tt.target)
+			if w.Code == http.StatusOK || strings.Contains(w.Body.String(), "top secret") {
+				t.Fatalf("GET %s = %d %q", tt.target, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHTTPFilesUpload(t *testing.T) {
+	put := func(s *Server, target, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("PUT", httpFilesPrefix+target, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		s.handleHTTPFiles(w, r)
+		return w
+	}
+
+	t.Run("overwrite", func(t *testing.T) {
+		for _, tt := range []struct {
+			name    string
+			enable  bool
+			target  string
+			code    int
+			content string
+		}{
+			{"without overwrite=1", true, "A", http.StatusForbidden, "old"},
+			{"overwrite disabled by server", false, "A?overwrite=1", http.StatusForbidden, "old"},
+			{"overwrite=1", true, "A?overwrite=1", http.StatusOK, "new"},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				s, _, rootAbs := newTestServer(t, func(c *config.Config) {
+					c.EnableHTTPFiles = true
+					c.EnableOverwrite = tt.enable
+				})
+				if w := put(s, "A", "old"); w.Code != http.StatusOK {
+					t.Fatalf("first upload = %d %q", w.Code, w.Body.String())
+				}
+				if w := put(s, tt.target, "new"); w.Code != tt.code {
+					t.Fatalf("second upload = %d %q, want %d", w.Code, w.Body.String(), tt.code)
+				}
+				if b, _ := os.ReadFile(filepath.Join(rootAbs, "A")); string(b) != tt.content {
+					t.Fatalf("A = %q, want %q", b, tt.content)
+				}
+			})
+		}
+	})
+
+	t.Run("quota", func(t *testing.T) {
+		s, _, rootAbs := newTestServer(t, func(c *config.Config) {
+			c.EnableHTTPFiles = true
+			c.GlobalQuotaBytes = 10
+			c.GlobalMaxFileBytes = 8
+		})
+		if w := put(s, "A", "123456"); w.Code != http.StatusOK {
+			t.Fatalf("upload within quota = %d %q", w.Code, w.Body.String())
+		}
+		if w := put(s, "B", "123456789"); w.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("upload above max_file_bytes = %d %q, want 413", w.Code, w.Body.String())
+		}
+		if w := put(s, "C", "123456"); w.Code == http.StatusOK {
+			t.Fatalf("upload above the quota = %d %q", w.Code, w.Body.String())
+		}
+		for _, name := range []string{"B", "C"} {
+			if _, err := os.Stat(filepath.Join(rootAbs, name)); err == nil {
+				t.Fatalf("rejected upload left %s behind", name)
+			}
+		}
+		if w := put(s, "D", "1234"); w.Code != http.StatusOK {
+			t.Fatalf("upload filling the quota = %d %q", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("max_files", func(t *testing.T) {
+		s, _, _ := newTestServer(t, func(c *config.Config) {
+			c.EnableHTTPFiles = true
+			c.GlobalMaxFiles = 2
+		})
+		if w := put(s, "D/A?parents=1", "a"); w.Code != http.StatusOK {
+			t.Fatalf("upload = %d %q", w.Code, w.Body.String())
+		}
+		if w := put(s, "D/B", "b"); w.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("upload above max_files = %d %q, want 413", w.Code, w.Body.String())
+		}
+	})
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"encoding/binary"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+// notModifiedMsg marks the READ_RANGE answer for a FlagR_IF_HASH match; the
+// handler turns it into proto.RespNotModified (see respFlags).
+const notModifiedMsg = "not modified"
+
+// splitIfHash removes the CRC32 trailer FlagR_IF_HASH appends to a
+// READ_RANGE payload.
+func splitIfHash(payload []byte) ([]byte, uint32, bool) {
+	if len(payload) < 4 {
+		return payload, 0, false
+	}
+	n := len(payload) - 4
+	return payload[:n], binary.LittleEndian.Uint32(payload[n:]), true
+}
+
+// unchangedSince reports whether the file of a READ_RANGE payload still has
+// CRC32 want. The CRC comes from HASH, so it covers the same places
+// (host files, disk images, zip mounts); files larger than
+// if_hash_max_bytes are not hashed and count as changed.
+func (s *Server) unchangedSince(cfg config.Config, limits Limits, payload []byte, rootAbs string, want uint32) bool {
+	d := proto.NewDecoder(payload)
+	p, err := s.readPathStringRead(cfg, d)
+	if err != nil {
+		return false
+	}
+	st, resp, _ := s.statPath(cfg, limits, p, rootAbs)
+	if st != proto.StatusOK || len(resp) < 5 || resp[0] != 0 {
+		return false
+	}
+	if binary.LittleEndian.Uint32(resp[1:5]) > cfg.IfHashMaxBytes {
+		return false
+	}
+	st, resp, _ = s.opHASH(cfg, limits, 0, pathPayload(p), rootAbs)
+	return st == proto.StatusOK && len(resp) == 4 && binary.LittleEndian.Uint32(resp) == want
+}
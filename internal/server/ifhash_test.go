@@ -0,0 +1,83 @@
+package server
+
+import (
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+func TestREAD_RANGEIfHash(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, func(c *config.Config) {
+		c.DiskImagesWriteEnabled = true
+		c.Tokens = []config.TokenEntry{{Token: "T"}}
+	})
+	data := []byte("hello, cached world")
+	writeFiles(t, rootAbs, map[string]string{"F": string(data)})
+	limits := Limits{DiskImagesEnabled: true, DiskImagesWriteEnabled: true}
+	mkImage(t, s, cfg, limits, rootAbs, "/DISK.D64", proto.ImageKindD64)
+	if st, _, msg := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, proto.FlagWR_CREATE, writeRangePayload(t, "/DISK.D64/P", 0, data), rootAbs); st != proto.StatusOK {
+		t.Fatalf("WRITE_RANGE = %s (%s)", statusName(st), msg)
+	}
+	crc := crc32.ChecksumIEEE(data)
+
+	// read sends READ_RANGE p 0..5 through handleRPC, with FlagR_IF_HASH
+	// and the CRC when ifHash is set, and returns header flags and payload.
+	read := func(p string, ifHash bool, want uint32) (byte, []byte) {
+		t.Helper()
+		var flags byte
+		payload := encode(func(e *proto.Encoder) {
+			_ = e.WriteString(p)
+			e.WriteU32(0)
+			e.WriteU16(5)
+			if ifHash {
+				e.WriteU32(want)
+			}
+		})
+		if ifHash {
+			flags = proto.FlagR_IF_HASH
+		}
+		resp := rpcRaw(t, s, "T", proto.OpREAD_RANGE, flags, payload)
+		if resp[6] != proto.StatusOK {
+			t.Fatalf("READ_RANGE %s = %s", p, statusName(resp[6]))
+		}
+		return resp[7], resp[proto.HeaderSize:]
+	}
+	notModified := func(rf byte) bool { return rf&proto.RespNotModified != 0 }
+
+	for _, p := range []string{"/F", "/DISK.D64/P"} {
+		if rf, body := read(p, true, crc); !notModified(rf) || len(body) != 0 {
+			t.Errorf("%s with the matching CRC: flags %02X, %q, want NOT_MODIFIED and no data", p, rf, body)
+		}
+		if rf, body := read(p, true, crc^1); notModified(rf) || string(body) != "hello" {
+			t.Errorf("%s with another CRC: flags %02X, %q, want the data", p, rf, body)
+		}
+		if rf, body := read(p, false, 0); notModified(rf) || string(body) != "hello" {
+			t.Errorf("%s without IF_HASH: flags %02X, %q", p, rf, body)
+		}
+	}
+
+	// A changed file is read again with the old CRC.
+	if err := os.WriteFile(filepath.Join(rootAbs, "F"), []byte("HELLO, cached world"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if rf, body := read("/F", true, crc); notModified(rf) || string(body) != "HELLO" {
+		t.Errorf("changed file: flags %02X, %q", rf, body)
+	}
+
+	// Files above if_hash_max_bytes are not hashed and count as changed.
+	small := cfg
+	small.IfHashMaxBytes = 4
+	st, body, msg := s.dispatch(small, limits, proto.OpREAD_RANGE, proto.FlagR_IF_HASH, encode(func(e *proto.Encoder) {
+		_ = e.WriteString("/DISK.D64/P")
+		e.WriteU32(0)
+		e.WriteU16(5)
+		e.WriteU32(crc)
+	}), rootAbs)
+	if st != proto.StatusOK || msg == notModifiedMsg || string(body) != "hello" {
+		t.Errorf("IF_HASH above if_hash_max_bytes = %s %q (%s)", statusName(st), body, msg)
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// imageActivities tracks the mutating disk image operations (diskimage op
+// hooks): which images are being written right now and when each was last
+// written. Entries are keyed like path locks, so all spellings and tokens of
+// one image file share one entry. They are kept until the process exits; the
+// map only grows with the number of images written.
+type imageActivities struct {
+	mu sync.Mutex
+	m  map[string]*imageActivity
+}
+
+type imageActivity struct {
+	path    string // OS path as passed to the op
+	running int    // ops in progress
+	lastOp  string // WRITE, DELETE, RENAME, ... (diskimage.OpEvent.Op)
+	last    time.Time
+}
+
+// begin is the diskimage op start hook.
+func (a *imageActivities) begin(op, imgPath string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.m == nil {
+		a.m = map[string]*imageActivity{}
+	}
+	key := pathLockKey(imgPath)
+	ia := a.m[key]
+	if ia == nil {
+		ia = &imageActivity{path: imgPath}
+		a.m[key] = ia
+	}
+	ia.running++
+	ia.lastOp = op
+	ia.last = time.Now()
+}
+
+// end is called from the diskimage op hook when the op has finished.
+func (a *imageActivities) end(imgPath string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if ia := a.m[pathLockKey(imgPath)]; ia != nil && ia.running > 0 {
+		ia.running--
+		ia.last = time.Now()
+	}
+}
+
+// get returns a copy of the entry of the image at abs.
+func (a *imageActivities) get(abs string) (imageActivity, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if ia := a.m[pathLockKey(abs)]; ia != nil {
+		return *ia, true
+	}
+	return imageActivity{}, false
+}
+
+// snapshot returns copies of all entries, most recent first.
+func (a *imageActivities) snapshot() []imageActivity {
+	a.mu.Lock()
+	out := make([]imageActivity, 0, len(a.m))
+	for _, ia := range a.m {
+		out = append(out, *ia)
+	}
+	a.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].last.After(out[j].last) })
+	return out
+}
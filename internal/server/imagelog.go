@@ -0,0 +1,135 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"wicos64-server/internal/diskimage"
+	"wicos64-server/internal/version"
+)
+
+const imageLogCapacity = 256
+
+// ImageLogEntry is one detailed record of a mutating disk-image operation
+// (config log_disk_image_ops).
+type ImageLogEntry struct {
+	ID         uint64 `json:"id"`
+	TimeUnixMs int64  `json:"time_unix_ms"`
+	Op         string `json:"op"`
+	Image      string `json:"image"`
+	Inner      string `json:"inner"`
+	Target     string `json:"target,omitempty"`
+	OK         bool   `json:"ok"`
+	Error      string `json:"error,omitempty"`
+	FreeBlocks int    `json:"free_blocks"` // -1 if the image could not be read afterwards
+	Repacked   bool   `json:"repacked"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// imageLogRing keeps the most recent disk-image op records.
+type imageLogRing struct {
+	mu     sync.Mutex
+	ring   []ImageLogEntry
+	next   int
+	count  int
+	nextID uint64
+}
+
+func newImageLogRing(capacity int) *imageLogRing {
+	return &imageLogRing{ring: make([]ImageLogEntry, capacity)}
+}
+
+func (r *imageLogRing) add(e ImageLogEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	e.ID = r.nextID
+	r.ring[r.next] = e
+	r.next = (r.next + 1) % len(r.ring)
+	if r.count < len(r.ring) {
+		r.count++
+	}
+}
+
+// snapshot returns up to limit entries, oldest first.
+func (r *imageLogRing) snapshot(limit int) []ImageLogEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if limit <= 0 || limit > r.count {
+		limit = r.count
+	}
+	out := make([]ImageLogEntry, 0, limit)
+	start := (r.next - limit + len(r.ring)) % len(r.ring)
+	for i := 0; i < limit; i++ {
+		out = append(out, r.ring[(start+i)%len(r.ring)])
+	}
+	return out
+}
+
+// onImageOp is the diskimage op hook: it ends the op in the image activity
+// list, keeps logical quota usage in sync and feeds the image op log and the
+// image change index.
+func (s *Server) onImageOp(ev diskimage.OpEvent) {
+	s.images.end(ev.ImagePath)
+	cfg := s.cfgSnapshot()
+	if s.usage != nil && cfg.QuotaLogicalImageUsage {
+		s.usage.invalidateContaining(ev.ImagePath)
+	}
+	if cfg.ImageChangeIndex && ev.Err == nil {
+		if err := s.imgIndex.record(cfg.ImageChangeIndexDir, ev); err != nil {
+			log.Printf("image change index: %s: %v", ev.ImagePath, err)
+		}
+	}
+	s.recordImageOp(ev)
+}
+
+func (s *Server) recordImageOp(ev diskimage.OpEvent) {
+	if !s.cfgSnapshot().LogDiskImageOps {
+		return
+	}
+	e := ImageLogEntry{
+		TimeUnixMs: time.Now().UnixMilli(),
+		Op:         ev.Op,
+		Image:      ev.ImagePath,
+		Inner:      ev.Inner,
+		Target:     ev.Target,
+		OK:         ev.Err == nil,
+		FreeBlocks: -1,
+		Repacked:   ev.Repacked,
+		DurationMs: ev.Duration.Milliseconds(),
+	}
+	if ev.Err != nil {
+		e.Error = ev.Err.Error()
+	}
+	if n, err := diskimage.FreeBlocks(ev.ImagePath); err == nil {
+		e.FreeBlocks = n
+	}
+	s.imageLogs.add(e)
+}
+
+type adminImageLogResponse struct {
+	OK      bool            `json:"ok"`
+	Build   string          `json:"build"`
+	TSUnix  int64           `json:"ts_unix"`
+	Enabled bool            `json:"enabled"`
+	Entries []ImageLogEntry `json:"entries"`
+}
+
+// handleAdminImageLog returns the detailed disk-image op log (?limit=N).
+func (s *Server) handleAdminImageLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	writeJSON(w, http.StatusOK, adminImageLogResponse{
+		OK:      true,
+		Build:   version.Get().String(),
+		TSUnix:  time.Now().Unix(),
+		Enabled: s.cfgSnapshot().LogDiskImageOps,
+		Entries: s.imageLogs.snapshot(limit),
+	})
+}
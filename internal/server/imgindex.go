@@ -0,0 +1,165 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"wicos64-server/internal/diskimage"
+)
+
+// imageIndexMaxEntries bounds one sidecar index; the oldest entries are
+// dropped first.
+const imageIndexMaxEntries = 4096
+
+// imageIndexFile is the on-disk sidecar index of one disk image
+// (config image_change_index).
+type imageIndexFile struct {
+	Image string `json:"image"`
+	// Image file state after the last op recorded by the server. If the file
+	// differs, it was changed outside of the server and the index is stale.
+	ModTimeNs int64                      `json:"mtime_ns"`
+	Size      int64                      `json:"size"`
+	Entries   map[string]imageIndexEntry `json:"entries"`
+}
+
+type imageIndexEntry struct {
+	Changed int64 `json:"changed"` // unix seconds
+	Dir     bool  `json:"dir,omitempty"`
+	Deleted bool  `json:"deleted,omitempty"`
+}
+
+// imageIndex serializes access to the sidecar index files.
+type imageIndex struct {
+	mu sync.Mutex
+}
+
+// imageIndexPath returns the sidecar file for an image (OS path).
+func imageIndexPath(dir, imgAbs string) string {
+	sum := sha256.Sum256([]byte(filepath.Clean(imgAbs)))
+	return filepath.Join(dir, hex.EncodeToString(sum[:12])+".json")
+}
+
+func loadImageIndex(dir, imgAbs string) (*imageIndexFile, error) {
+	b, err := os.ReadFile(imageIndexPath(dir, imgAbs))
+	if err != nil {
+		return nil, err
+	}
+	var f imageIndexFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+	if f.Entries == nil {
+		f.Entries = map[string]imageIndexEntry{}
+	}
+	return &f, nil
+}
+
+func saveImageIndex(dir string, f *imageIndexFile) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	p := imageIndexPath(dir, f.Image)
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// load returns the sidecar index of an image (os.ErrNotExist if none).
+func (x *imageIndex) load(dir, imgAbs string) (*imageIndexFile, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return loadImageIndex(dir, imgAbs)
+}
+
+// record applies a successful image op to the image's sidecar index.
+func (x *imageIndex) record(dir string, ev diskimage.OpEvent) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	f, err := loadImageIndex(dir, ev.ImagePath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		f = &imageIndexFile{Image: filepath.Clean(ev.ImagePath), Entries: map[string]imageIndexEntry{}}
+	}
+
+	now := time.Now().Unix()
+	inner := imageIndexKey(ev.Inner)
+	target := imageIndexKey(ev.Target)
+	switch ev.Op {
+	case "WRITE":
+		f.Entries[inner] = imageIndexEntry{Changed: now}
+	case "MKDIR", "IMPORT":
+		f.Entries[inner] = imageIndexEntry{Changed: now, Dir: true}
+	case "DELETE":
+		f.Entries[inner] = imageIndexEntry{Changed: now, Deleted: true}
+	case "RMDIR":
+		f.markDeletedUnder(inner, now)
+		f.Entries[inner] = imageIndexEntry{Changed: now, Dir: true, Deleted: true}
+	case "RENAME":
+		f.Entries[inner] = imageIndexEntry{Changed: now, Deleted: true}
+		f.Entries[target] = imageIndexEntry{Changed: now}
+	case "RENAME_DIR":
+		// Known entries below the old directory move along with it.
+		for k, e := range f.Entries {
+			if rest, ok := strings.CutPrefix(k, inner+"/"); ok && !e.Deleted {
+				f.Entries[target+"/"+rest] = imageIndexEntry{Changed: now, Dir: e.Dir}
+			}
+		}
+		f.markDeletedUnder(inner, now)
+		f.Entries[inner] = imageIndexEntry{Changed: now, Dir: true, Deleted: true}
+		f.Entries[target] = imageIndexEntry{Changed: now, Dir: true}
+	}
+	f.prune()
+
+	if fi, err := os.Stat(ev.ImagePath); err == nil {
+		f.ModTimeNs = fi.ModTime().UnixNano()
+		f.Size = fi.Size()
+	}
+	return saveImageIndex(dir, f)
+}
+
+// imageIndexKey normalizes an inner image path for the index.
+func imageIndexKey(p string) string {
+	return strings.ToUpper(strings.Trim(p, "/"))
+}
+
+func (f *imageIndexFile) markDeletedUnder(dir string, now int64) {
+	for k, e := range f.Entries {
+		if strings.HasPrefix(k, dir+"/") {
+			f.Entries[k] = imageIndexEntry{Changed: now, Dir: e.Dir, Deleted: true}
+		}
+	}
+}
+
+// prune drops the oldest entries beyond imageIndexMaxEntries.
+func (f *imageIndexFile) prune() {
+	if len(f.Entries) <= imageIndexMaxEntries {
+		return
+	}
+	keys := make([]string, 0, len(f.Entries))
+	for k := range f.Entries {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return f.Entries[keys[i]].Changed < f.Entries[keys[j]].Changed
+	})
+	for _, k := range keys[:len(keys)-imageIndexMaxEntries] {
+		delete(f.Entries, k)
+	}
+}
@@ -0,0 +1,435 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+const jsonRPCPath = "/wicos64/jsonrpc"
+
+// jsonRPCRequest is one op for POST /wicos64/jsonrpc. Which fields are used
+// depends on the op (see jsonRPCPayload); unused ones are ignored.
+type jsonRPCRequest struct {
+	Token string `json:"token"` // alternative to ?token=
+	Op    string `json:"op"`    // ls, stat, read, write, ... (case-insensitive)
+
+	Path string `json:"path"`
+	Dst  string `json:"dst"` // cp, mv
+
+	Start   uint16 `json:"start"`   // ls
+	Max     uint16 `json:"max"`     // ls; 0 = max_entries
+	Sort    string `json:"sort"`    // ls: name|size|mtime|type
+	Reverse bool   `json:"reverse"` // ls
+
+	Offset uint32  `json:"offset"` // read, write
+	Length uint16  `json:"length"` // read; 0 = max_chunk
+	IfCRC  *uint32 `json:"if_crc"` // read: FlagR_IF_HASH
+	Data   string  `json:"data"`   // write, append (base64)
+	Algo   string  `json:"algo"`   // hash: crc32|sha256|crc16
+
+	Create    bool `json:"create"`    // write, append
+	Truncate  bool `json:"truncate"`  // write
+	Overwrite bool `json:"overwrite"` // write, cp, mv
+	Parents   bool `json:"parents"`   // mkdir
+	Recursive bool `json:"recursive"` // rmdir, cp
+
+	// Raw op flags, OR'ed into the ones the fields above set.
+	Flags byte `json:"flags"`
+}
+
+type jsonRPCResponse struct {
+	OK     bool          `json:"ok"`
+	Op     string        `json:"op,omitempty"`
+	Status string        `json:"status"`
+	Result any           `json:"result,omitempty"`
+	Error  *jsonRPCError `json:"error,omitempty"`
+}
+
+type jsonRPCError struct {
+	Status  string `json:"status"`
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type jsonRPCEntry struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"` // file|dir
+	Size      uint32 `json:"size"`
+	MTimeUnix uint32 `json:"mtime_unix"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// handleJSONRPC serves POST /wicos64/jsonrpc (enable_jsonrpc): the JSON body
+// is translated into the W64F payload of one op, run through dispatch with
+// the token's root and limits like a binary request, and the response
+// payload is decoded into JSON. Op errors are answered with HTTP 200 and an
+// error object carrying the status name; the error message follows
+// enable_errmsg like in the binary protocol.
+func (s *Server) handleJSONRPC(w http.ResponseWriter, r *http.Request) {
+	cfg := s.cfgSnapshot()
+	if !cfg.EnableJSONRPC {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	startTime := time.Now()
+	remoteIP := clientIP(cfg, r)
+	le := LogEntry{TimeUnixMs: startTime.UnixMilli(), RemoteIP: remoteIP, Op: 0xFF, OpName: "<jsonrpc>", HTTPStatus: http.StatusOK, Info: "jsonrpc"}
+	fail := func(httpStatus int, status byte, msg string) {
+		le.HTTPStatus = httpStatus
+		le.Status = status
+		le.StatusName = statusName(status)
+		le.DurationMs = time.Since(startTime).Milliseconds()
+		s.record(cfg, le)
+		writeJSON(w, httpStatus, jsonRPCResponse{
+			Op:     choose(le.Op != 0xFF, le.OpName, ""),
+			Status: statusName(status),
+			Error:  &jsonRPCError{Status: statusName(status), Code: int(status), Message: msg},
+		})
+	}
+
+	// Base64 data needs 4/3 of max_payload, plus room for the other fields.
+	r.Body = http.MaxBytesReader(w, r.Body, 2*int64(cfg.MaxPayload)+4096)
+	body, err := io.ReadAll(r.Body)
+	_ = r.Body.Close()
+	le.ReqBytes = len(body)
+	if err != nil {
+		fail(http.StatusRequestEntityTooLarge, proto.StatusTooLarge, "request body too large")
+		return
+	}
+	var req jsonRPCRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		fail(http.StatusBadRequest, proto.StatusBadRequest, "bad json: "+err.Error())
+		return
+	}
+	op, flags, payload, err := jsonRPCPayload(cfg, req)
+	if err != nil {
+		fail(http.StatusBadRequest, proto.StatusBadRequest, err.Error())
+		return
+	}
+	le.Op = op
+	le.OpName = opName(op)
+	le.Info = strings.TrimSpace("jsonrpc " + summarizeRequest(cfg, op, flags, payload))
+	le.ReqPreview = buildReqPreview(cfg, op, flags, payload)
+	if len(payload) > int(cfg.MaxPayload) {
+		fail(http.StatusOK, proto.StatusTooLarge, "payload too large")
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		token = req.Token
+	}
+	ctx, ok := cfg.ResolveTokenContext(token)
+	// Tokens bound to an RPC endpoint are not usable here.
+	if !ok || ctx.Endpoint != "" {
+		fail(http.StatusForbidden, proto.StatusAccessDenied, "access denied")
+		return
+	}
+	if msg := tokenWindowError(ctx, time.Now()); msg != "" {
+		fail(http.StatusForbidden, proto.StatusAccessDenied, msg)
+		return
+	}
+	if !ctx.IPAllowed(net.ParseIP(remoteIP)) {
+		fail(http.StatusForbidden, proto.StatusAccessDenied, "ip not allowed")
+		return
+	}
+	if ctx.RateLimitPerSec > 0 && !s.rate.allow(tokenID(token), ctx.RateLimitPerSec, time.Now()) {
+		fail(http.StatusTooManyRequests, proto.StatusBusy, "rate limited")
+		return
+	}
+	rootAbs, err := filepath.Abs(ctx.Root)
+	if err != nil {
+		fail(http.StatusInternalServerError, proto.StatusInternal, "bad root")
+		return
+	}
+	if err := config.EnsureRoot(rootAbs); err != nil {
+		fail(http.StatusInternalServerError, proto.StatusInternal, "cannot create root")
+		return
+	}
+	_ = s.ensureRecommendedDirs(cfg, rootAbs)
+
+	limits := limitsFromContext(ctx)
+	limits.tokenID = tokenID(token)
+	limits.tokenName = ctx.Name
+	limits.reqCtx = r.Context()
+
+	status, respPayload, errMsg := s.dispatch(cfg, limits, op, flags, payload, rootAbs)
+	s.throttle(limits, len(body)+len(respPayload))
+	le.RespPreview = buildRespPreview(cfg, op, status, respPayload, errMsg)
+	le.RespBytes = len(respPayload)
+	if status != proto.StatusOK {
+		if !cfg.EnableErrMsg {
+			errMsg = ""
+		}
+		fail(http.StatusOK, status, errMsg)
+		return
+	}
+	result, err := jsonRPCResult(op, respPayload, errMsg)
+	if err != nil {
+		fail(http.StatusInternalServerError, proto.StatusInternal, "decode response: "+err.Error())
+		return
+	}
+	le.Status = status
+	le.StatusName = statusName(status)
+	le.DurationMs = time.Since(startTime).Milliseconds()
+	s.record(cfg, le)
+	writeJSON(w, http.StatusOK, jsonRPCResponse{OK: true, Op: opName(op), Status: statusName(status), Result: result})
+}
+
+// jsonRPCPayload builds the W64F op, flags and payload for req.
+func jsonRPCPayload(cfg config.Config, req jsonRPCRequest) (op, flags byte, payload []byte, err error) {
+	e := proto.NewEncoder(64)
+	needPath := func() error {
+		if req.Path == "" {
+			return fmt.Errorf("%s: missing path", req.Op)
+		}
+		return e.WriteString(req.Path)
+	}
+	data := func() ([]byte, error) {
+		b, err := base64.StdEncoding.DecodeString(req.Data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: data is not base64: %v", req.Op, err)
+		}
+		if len(b) > 0xFFFF {
+			return nil, fmt.Errorf("%s: data too large", req.Op)
+		}
+		return b, nil
+	}
+	set := func(on bool, bit byte) {
+		if on {
+			flags |= bit
+		}
+	}
+
+	switch strings.ToLower(strings.TrimSpace(req.Op)) {
+	case "caps":
+		op = proto.OpCAPS
+	case "ping":
+		op = proto.OpPING
+	case "statfs":
+		op = proto.OpSTATFS
+		err = e.WriteString(choose(req.Path != "", req.Path, "/"))
+	case "ls":
+		op = proto.OpLS
+		if req.Sort != "" {
+			key := slices.Index(proto.LSSortNames, strings.ToLower(req.Sort))
+			if key < 0 {
+				return 0, 0, nil, fmt.Errorf("ls: unknown sort key %q (name, size, mtime, type)", req.Sort)
+			}
+			flags |= byte(key)
+		}
+		set(req.Reverse, proto.FlagLS_REVERSE)
+		max := req.Max
+		if max == 0 {
+			max = cfg.MaxEntries
+		}
+		err = e.WriteString(choose(req.Path != "", req.Path, "/"))
+		e.WriteU16(req.Start)
+		e.WriteU16(max)
+	case "stat":
+		op = proto.OpSTAT
+		err = needPath()
+	case "read":
+		op = proto.OpREAD_RANGE
+		ln := req.Length
+		if ln == 0 {
+			ln = cfg.MaxChunk
+		}
+		err = needPath()
+		e.WriteU32(req.Offset)
+		e.WriteU16(ln)
+		if req.IfCRC != nil {
+			flags |= proto.FlagR_IF_HASH
+			e.WriteU32(*req.IfCRC)
+		}
+	case "write":
+		op = proto.OpWRITE_RANGE
+		set(req.Create, proto.FlagWR_CREATE)
+		set(req.Truncate, proto.FlagWR_TRUNCATE)
+		set(req.Overwrite, proto.FlagWR_OVERWRITE)
+		var b []byte
+		if b, err = data(); err != nil {
+			return 0, 0, nil, err
+		}
+		err = needPath()
+		e.WriteU32(req.Offset)
+		e.WriteU16(uint16(len(b)))
+		e.WriteBytes(b)
+	case "append":
+		op = proto.OpAPPEND
+		set(req.Create, proto.FlagAP_CREATE)
+		var b []byte
+		if b, err = data(); err != nil {
+			return 0, 0, nil, err
+		}
+		err = needPath()
+		e.WriteU16(uint16(len(b)))
+		e.WriteBytes(b)
+	case "mkdir":
+		op = proto.OpMKDIR
+		set(req.Parents, proto.FlagMK_PARENTS)
+		err = needPath()
+	case "rmdir":
+		op = proto.OpRMDIR
+		set(req.Recursive, proto.FlagRD_RECURSIVE)
+		err = needPath()
+	case "rm":
+		op = proto.OpRM
+		err = needPath()
+	case "cp", "mv":
+		op = proto.OpCP
+		set(req.Overwrite, proto.FlagCP_OVERWRITE)
+		set(req.Recursive, proto.FlagCP_RECURSIVE)
+		if strings.EqualFold(strings.TrimSpace(req.Op), "mv") {
+			op = proto.OpMV
+			flags = 0
+			set(req.Overwrite, proto.FlagMV_OVERWRITE)
+		}
+		if req.Dst == "" {
+			return 0, 0, nil, fmt.Errorf("%s: missing dst", req.Op)
+		}
+		if err = needPath(); err == nil {
+			err = e.WriteString(req.Dst)
+		}
+	case "hash":
+		op = proto.OpHASH
+		switch strings.ToLower(req.Algo) {
+		case "", "crc32":
+		case "sha256":
+			flags |= proto.FlagH_SHA256
+		case "crc16":
+			flags |= proto.FlagH_CRC16
+		default:
+			return 0, 0, nil, fmt.Errorf("hash: unknown algo %q (crc32, sha256, crc16)", req.Algo)
+		}
+		err = needPath()
+	case "":
+		return 0, 0, nil, fmt.Errorf("missing op")
+	default:
+		return 0, 0, nil, fmt.Errorf("unknown op %q", req.Op)
+	}
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	return op, flags | req.Flags, e.Bytes(), nil
+}
+
+// jsonRPCResult decodes the OK response payload of op. Ops without a
+// decoder return their payload (if any) as base64.
+func jsonRPCResult(op byte, resp []byte, errMsg string) (any, error) {
+	d := newPrettyDecoder(resp)
+	var out any
+	switch {
+	case errMsg == dryRunMsg && isDryRunOp(op):
+		count := d.ReadU16()
+		listed := d.ReadU16()
+		paths := []string{}
+		for i := 0; i < int(listed) && d.Err == nil; i++ {
+			paths = append(paths, d.ReadString())
+		}
+		out = map[string]any{"dry_run": true, "count": count, "paths": paths}
+
+	case op == proto.OpCAPS:
+		res := map[string]any{
+			"max_chunk":   d.ReadU16(),
+			"max_payload": d.ReadU16(),
+			"max_path":    d.ReadU16(),
+			"max_name":    d.ReadU16(),
+			"max_entries": d.ReadU16(),
+		}
+		feats := uint64(d.ReadU32())
+		res["server_time_unix"] = d.ReadU32()
+		res["server_name"] = d.ReadString()
+		if d.Remaining() >= 2 {
+			res["max_decompressed"] = d.ReadU16()
+		}
+		if d.Remaining() >= 4 {
+			feats |= uint64(d.ReadU32()) << 32
+		}
+		if d.Remaining() >= 2 {
+			res["preview_len"] = d.ReadU16()
+		}
+		names := []string{}
+		for _, f := range proto.FeatureNames {
+			if feats&f.Bit != 0 {
+				names = append(names, f.Name)
+			}
+		}
+		res["features"] = feats
+		res["feature_names"] = names
+		out = res
+
+	case op == proto.OpPING:
+		out = map[string]any{"text": d.ReadString()}
+
+	case op == proto.OpSTATFS:
+		out = map[string]any{"total": d.ReadU32(), "free": d.ReadU32(), "used": d.ReadU32()}
+
+	case op == proto.OpLS:
+		cnt := d.ReadU16()
+		entries := make([]jsonRPCEntry, 0, cnt)
+		for i := 0; i < int(cnt) && d.Err == nil; i++ {
+			typ := d.ReadU8()
+			ent := jsonRPCEntry{Type: "file", Truncated: typ&proto.LSEntryTruncated != 0}
+			if typ&^proto.LSEntryTruncated == 1 {
+				ent.Type = "dir"
+			}
+			ent.Size = d.ReadU32()
+			ent.MTimeUnix = d.ReadU32()
+			ent.Name = d.ReadString()
+			entries = append(entries, ent)
+		}
+		next := d.ReadU16()
+		res := map[string]any{"entries": entries, "more": next != 0xFFFF}
+		if next != 0xFFFF {
+			res["next_index"] = next
+		}
+		out = res
+
+	case op == proto.OpSTAT:
+		typ := d.ReadU8()
+		out = map[string]any{"type": choose(typ == 1, "dir", "file"), "size": d.ReadU32(), "mtime_unix": d.ReadU32()}
+
+	case op == proto.OpREAD_RANGE:
+		if errMsg == notModifiedMsg {
+			return map[string]any{"not_modified": true}, nil
+		}
+		return map[string]any{"length": len(resp), "data": base64.StdEncoding.EncodeToString(resp)}, nil
+
+	case op == proto.OpHASH:
+		switch len(resp) {
+		case 32:
+			return map[string]any{"algo": "sha256", "value": fmt.Sprintf("%x", resp)}, nil
+		case 2:
+			return map[string]any{"algo": "crc16", "value": fmt.Sprintf("%04X", d.ReadU16())}, nil
+		}
+		out = map[string]any{"algo": "crc32", "value": fmt.Sprintf("%08X", d.ReadU32())}
+
+	default:
+		if len(resp) == 0 {
+			return nil, nil
+		}
+		return map[string]any{"payload": base64.StdEncoding.EncodeToString(resp)}, nil
+	}
+	if d.Err != nil {
+		return nil, d.Err
+	}
+	return out, nil
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+// encode builds an expected request payload.
+func encode(f func(e *proto.Encoder)) []byte {
+	e := proto.NewEncoder(64)
+	f(e)
+	return e.Bytes()
+}
+
+func TestJSONRPCPayload(t *testing.T) {
+	cfg := config.Default()
+	crc := uint32(0x11223344)
+
+	tests := []struct {
+		name    string
+		req     jsonRPCRequest
+		op      byte
+		flags   byte
+		payload []byte
+		err     string
+	}{
+		{name: "caps", req: jsonRPCRequest{Op: "CAPS"}, op: proto.OpCAPS, payload: []byte{}},
+		{name: "statfs defaults to /", req: jsonRPCRequest{Op: "statfs"}, op: proto.OpSTATFS, payload: pathPayload("/")},
+		{
+			name: "ls with defaults", req: jsonRPCRequest{Op: " ls "}, op: proto.OpLS,
+			payload: encode(func(e *proto.Encoder) { _ = e.WriteString("/"); e.WriteU16(0); e.WriteU16(cfg.MaxEntries) }),
+		},
+		{
+			name: "ls sorted", req: jsonRPCRequest{Op: "ls", Path: "/D", Start: 5, Max: 7, Sort: "MTIME", Reverse: true},
+			op: proto.OpLS, flags: proto.FlagLS_SORT_MTIME | proto.FlagLS_REVERSE,
+			payload: encode(func(e *proto.Encoder) { _ = e.WriteString("/D"); e.WriteU16(5); e.WriteU16(7) }),
+		},
+		{name: "ls unknown sort", req: jsonRPCRequest{Op: "ls", Sort: "color"}, err: "unknown sort key"},
+		{name: "stat", req: jsonRPCRequest{Op: "stat", Path: "/A"}, op: proto.OpSTAT, payload: pathPayload("/A")},
+		{name: "stat without path", req: jsonRPCRequest{Op: "stat"}, err: "stat: missing path"},
+		{
+			name: "read with if_crc", req: jsonRPCRequest{Op: "read", Path: "/A", Offset: 9, IfCRC: &crc},
+			op: proto.OpREAD_RANGE, flags: proto.FlagR_IF_HASH,
+			payload: encode(func(e *proto.Encoder) {
+				_ = e.WriteString("/A")
+				e.WriteU32(9)
+				e.WriteU16(cfg.MaxChunk)
+				e.WriteU32(crc)
+			}),
+		},
+		{
+			name: "write", req: jsonRPCRequest{Op: "write", Path: "/A", Offset: 2, Data: "aGk=", Create: true, Truncate: true, Overwrite: true},
+			op: proto.OpWRITE_RANGE, flags: proto.FlagWR_CREATE | proto.FlagWR_TRUNCATE | proto.FlagWR_OVERWRITE,
+			payload: encode(func(e *proto.Encoder) {
+				_ = e.WriteString("/A")
+				e.WriteU32(2)
+				e.WriteU16(2)
+				e.WriteBytes([]byte("hi"))
+			}),
+		},
+		{name: "write bad base64", req: jsonRPCRequest{Op: "write", Path: "/A", Data: "!!"}, err: "not base64"},
+		{
+			name: "append", req: jsonRPCRequest{Op: "append", Path: "/A", Data: "aGk=", Create: true},
+			op: proto.OpAPPEND, flags: proto.FlagAP_CREATE,
+			payload: encode(func(e *proto.Encoder) { _ = e.WriteString("/A"); e.WriteU16(2); e.WriteBytes([]byte("hi")) }),
+		},
+		{name: "mkdir -p", req: jsonRPCRequest{Op: "mkdir", Path: "/D", Parents: true}, op: proto.OpMKDIR, flags: proto.FlagMK_PARENTS, payload: pathPayload("/D")},
+		{name: "rmdir -r", req: jsonRPCRequest{Op: "rmdir", Path: "/D", Recursive: true}, op: proto.OpRMDIR, flags: proto.FlagRD_RECURSIVE, payload: pathPayload("/D")},
+		{name: "rm", req: jsonRPCRequest{Op: "rm", Path: "/A"}, op: proto.OpRM, payload: pathPayload("/A")},
+		{
+			name: "cp", req: jsonRPCRequest{Op: "cp", Path: "/A", Dst: "/B", Overwrite: true, Recursive: true},
+			op: proto.OpCP, flags: proto.FlagCP_OVERWRITE | proto.FlagCP_RECURSIVE,
+			payload: encode(func(e *proto.Encoder) { _ = e.WriteString("/A"); _ = e.WriteString("/B") }),
+		},
+		{
+			name: "mv drops the cp flags", req: jsonRPCRequest{Op: "MV", Path: "/A", Dst: "/B", Overwrite: true, Recursive: true},
+			op: proto.OpMV, flags: proto.FlagMV_OVERWRITE,
+			payload: encode(func(e *proto.Encoder) { _ = e.WriteString("/A"); _ = e.WriteString("/B") }),
+		},
+		{name: "mv without dst", req: jsonRPCRequest{Op: "mv", Path: "/A"}, err: "mv: missing dst"},
+		{name: "hash crc32", req: jsonRPCRequest{Op: "hash", Path: "/A"}, op: proto.OpHASH, payload: pathPayload("/A")},
+		{name: "hash sha256", req: jsonRPCRequest{Op: "hash", Path: "/A", Algo: "SHA256"}, op: proto.OpHASH, flags: proto.FlagH_SHA256, payload: pathPayload("/A")},
+		{name: "hash crc16", req: jsonRPCRequest{Op: "hash", Path: "/A", Algo: "crc16"}, op: proto.OpHASH, flags: proto.FlagH_CRC16, payload: pathPayload("/A")},
+		{name: "hash unknown algo", req: jsonRPCRequest{Op: "hash", Path: "/A", Algo: "md5"}, err: "unknown algo"},
+		{name: "raw flags are OR'ed in", req: jsonRPCRequest{Op: "rm", Path: "/A", Flags: 0x80}, op: proto.OpRM, flags: 0x80, payload: pathPayload("/A")},
+		{name: "missing op", req: jsonRPCRequest{}, err: "missing op"},
+		{name: "unknown op", req: jsonRPCRequest{Op: "format"}, err: `unknown op "format"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op, flags, payload, err := jsonRPCPayload(cfg, tt.req)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if op != tt.op || flags != tt.flags || !bytes.Equal(payload, tt.payload) {
+				t.Fatalf("got %s flags=%#x % X, want %s flags=%#x % X", opName(op), flags, payload, opName(tt.op), tt.flags, tt.payload)
+			}
+		})
+	}
+}
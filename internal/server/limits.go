@@ -1,12 +1,63 @@
 package server
 
+import (
+	"context"
+
+	"wicos64-server/internal/config"
+)
+
 // Limits are effective, per-request policy values derived from config + token.
 type Limits struct {
 	ReadOnly                     bool
+	ReadOnlyWhenFull             bool
 	QuotaBytes                   uint64
 	MaxFileBytes                 uint64
+	MaxFiles                     uint64
 	DiskImagesEnabled            bool
 	DiskImagesWriteEnabled       bool
 	DiskImagesAutoResizeEnabled  bool
 	DiskImagesAllowRenameConvert bool
+	MaxBytesPerSec               uint64
+
+	// writeLocked is set while a BATCH holds the root write lock for its sub-ops.
+	writeLocked bool
+	// quotaLocked is set while the root's quota lock is held (see lockQuota).
+	quotaLocked bool
+	// tokenID identifies the requesting token (crc32 hex, "" for no-auth).
+	tokenID string
+	// tokenName is the configured name of the token (may be empty).
+	tokenName string
+	// progress receives the bytes copied by an async CP (nil otherwise).
+	progress *asyncOp
+	// dryRun collects the changes of a FlagDRY_RUN op instead of making
+	// them (nil otherwise).
+	dryRun *dryRun
+	// reqCtx is the HTTP request's context (nil for internal callers); ops
+	// that wait stop waiting when the client goes away.
+	reqCtx context.Context
+}
+
+// canceled is closed when the client's request is canceled. It is nil (a
+// wait on it never ends) for limits without a request context.
+func (l Limits) canceled() <-chan struct{} {
+	if l.reqCtx == nil {
+		return nil
+	}
+	return l.reqCtx.Done()
+}
+
+// limitsFromContext derives the per-request limits from a resolved token context.
+func limitsFromContext(ctx config.TokenContext) Limits {
+	return Limits{
+		ReadOnly:                     ctx.ReadOnly,
+		ReadOnlyWhenFull:             ctx.ReadOnlyWhenFull,
+		QuotaBytes:                   ctx.QuotaBytes,
+		MaxFileBytes:                 ctx.MaxFileBytes,
+		MaxFiles:                     ctx.MaxFiles,
+		DiskImagesEnabled:            ctx.DiskImagesEnabled,
+		DiskImagesWriteEnabled:       ctx.DiskImagesWriteEnabled,
+		DiskImagesAutoResizeEnabled:  ctx.DiskImagesAutoResizeEnabled,
+		DiskImagesAllowRenameConvert: ctx.DiskImagesAllowRenameConvert,
+		MaxBytesPerSec:               ctx.MaxBytesPerSec,
+	}
 }
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+func TestListenersSplitHandlers(t *testing.T) {
+	s, cfg, _ := newTestServer(t, func(c *config.Config) {
+		c.Listeners = []config.ListenerConfig{
+			{Addr: "0.0.0.0:8080", Serve: []string{"API"}},
+			{Addr: "127.0.0.1:8081", Serve: []string{"admin"}},
+		}
+	})
+	ls := cfg.ListenerList()
+	if len(ls) != 2 || ls[0].Serve[0] != config.ServeAPI {
+		t.Fatalf("ListenerList = %+v", ls)
+	}
+	api := httptest.NewServer(s.HTTPHandlerFor(ls[0]))
+	defer api.Close()
+	admin := httptest.NewServer(s.HTTPHandlerFor(ls[1]))
+	defer admin.Close()
+
+	get := func(base, p string) int {
+		t.Helper()
+		resp, err := http.Get(base + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	rpcOK := func(base string) bool {
+		t.Helper()
+		resp, err := http.Post(base+cfg.Endpoint, "application/octet-stream", bytes.NewReader(w64fRequest(proto.OpCAPS, 0, nil)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode == http.StatusOK && len(b) >= proto.HeaderSize && b[6] == proto.StatusOK
+	}
+
+	if !rpcOK(api.URL) {
+		t.Fatal("CAPS on the api listener failed")
+	}
+	if rpcOK(admin.URL) {
+		t.Fatal("CAPS answered on the admin-only listener")
+	}
+	for _, p := range []string{"/admin", "/admin/", "/admin/api/config", "/admin/api/images"} {
+		if code := get(api.URL, p); code != http.StatusNotFound {
+			t.Errorf("GET %s on the api listener = %d, want 404", p, code)
+		}
+	}
+	if code := get(admin.URL, "/admin"); code != http.StatusOK {
+		t.Errorf("GET /admin on the admin listener = %d, want 200", code)
+	}
+	if get(api.URL, "/healthz") != http.StatusOK || get(admin.URL, "/healthz") != http.StatusOK {
+		t.Error("/healthz is not served on both listeners")
+	}
+
+	// The admin listener keeps the localhost-only check.
+	r := httptest.NewRequest("GET", "/admin", nil)
+	r.RemoteAddr = "203.0.113.7:1234"
+	w := httptest.NewRecorder()
+	s.HTTPHandlerFor(ls[1]).ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("remote GET /admin on the admin listener = %d, want 403", w.Code)
+	}
+
+	// Without listeners, listen serves everything.
+	if ls := (config.Config{Listen: ":9000"}).ListenerList(); len(ls) != 1 || ls[0].Addr != ":9000" || !ls[0].Serves(config.ServeAdmin) {
+		t.Fatalf("default ListenerList = %+v", ls)
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"wicos64-server/internal/proto"
+)
+
+// logMinuteAggregate is one per-minute row of the aggregated log export
+// (similar to the stats "recent" buckets, but with op/status breakdowns).
+type logMinuteAggregate struct {
+	MinuteUnix int64             `json:"minute_unix"`
+	Requests   uint64            `json:"requests"`
+	Errors     uint64            `json:"errors"`
+	BytesIn    uint64            `json:"bytes_in"`
+	BytesOut   uint64            `json:"bytes_out"`
+	AvgMs      uint64            `json:"avg_ms"`
+	ByOp       map[string]uint64 `json:"by_op"`
+	ByStatus   map[string]uint64 `json:"by_status"`
+
+	totalMs uint64
+}
+
+// aggregateLogsByMinute folds log entries into per-minute buckets (oldest first).
+//
+// The stats ring only keeps totals for the last hour, so the buckets are built
+// from the (filtered) log entries instead.
+func aggregateLogsByMinute(entries []LogEntry) []*logMinuteAggregate {
+	byMin := make(map[int64]*logMinuteAggregate)
+	for _, e := range entries {
+		m := (e.TimeUnixMs / 1000 / 60) * 60
+		a := byMin[m]
+		if a == nil {
+			a = &logMinuteAggregate{MinuteUnix: m, ByOp: map[string]uint64{}, ByStatus: map[string]uint64{}}
+			byMin[m] = a
+		}
+		a.Requests++
+		// Requests rejected at the HTTP level (e.g. short bodies) log Status 0.
+		if e.HTTPStatus >= 400 || e.Status != proto.StatusOK {
+			a.Errors++
+		}
+		if e.ReqBytes > 0 {
+			a.BytesIn += uint64(e.ReqBytes)
+		}
+		if e.RespBytes > 0 {
+			a.BytesOut += uint64(e.RespBytes)
+		}
+		if e.DurationMs > 0 {
+			a.totalMs += uint64(e.DurationMs)
+		}
+		a.ByOp[e.OpName]++
+		a.ByStatus[e.StatusName]++
+	}
+
+	out := make([]*logMinuteAggregate, 0, len(byMin))
+	for _, a := range byMin {
+		a.AvgMs = a.totalMs / a.Requests
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].MinuteUnix < out[j].MinuteUnix })
+	return out
+}
+
+// formatCountMap renders a breakdown as "A=1;B=2" (sorted by key) for CSV cells.
+func formatCountMap(m map[string]uint64) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%d", k, m[k]))
+	}
+	return strings.Join(parts, ";")
+}
+
+// writeAggregatedLogs writes the per-minute export as CSV or JSONL.
+func writeAggregatedLogs(w http.ResponseWriter, entries []LogEntry, format string) {
+	rows := aggregateLogsByMinute(entries)
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename=logs-per-minute.csv")
+		w.WriteHeader(http.StatusOK)
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"minute_unix", "minute_utc", "requests", "errors", "bytes_in", "bytes_out", "avg_ms", "by_op", "by_status"})
+		for _, a := range rows {
+			_ = cw.Write([]string{
+				strconv.FormatInt(a.MinuteUnix, 10),
+				time.Unix(a.MinuteUnix, 0).UTC().Format("2006-01-02T15:04Z"),
+				strconv.FormatUint(a.Requests, 10),
+				strconv.FormatUint(a.Errors, 10),
+				strconv.FormatUint(a.BytesIn, 10),
+				strconv.FormatUint(a.BytesOut, 10),
+				strconv.FormatUint(a.AvgMs, 10),
+				formatCountMap(a.ByOp),
+				formatCountMap(a.ByStatus),
+			})
+		}
+		cw.Flush()
+		return
+	}
+	// default: jsonl
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", "attachment; filename=logs-per-minute.jsonl")
+	w.WriteHeader(http.StatusOK)
+	for _, a := range rows {
+		b, _ := json.Marshal(a)
+		_, _ = w.Write(b)
+		_, _ = w.Write([]byte("\n"))
+	}
+}
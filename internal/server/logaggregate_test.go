@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"wicos64-server/internal/proto"
+)
+
+func TestAggregateLogsCountsErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry LogEntry
+		err   bool
+	}{
+		{"ok", LogEntry{HTTPStatus: http.StatusOK, Status: proto.StatusOK}, false},
+		{"rpc error", LogEntry{HTTPStatus: http.StatusOK, Status: proto.StatusNotFound}, true},
+		{"short body", LogEntry{HTTPStatus: http.StatusBadRequest, Status: proto.StatusOK}, true},
+		{"jsonrpc failure", LogEntry{HTTPStatus: http.StatusUnauthorized, Status: proto.StatusAccessDenied}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.entry.TimeUnixMs = 60_000
+			got := aggregateLogsByMinute([]LogEntry{tt.entry})
+			if len(got) != 1 || got[0].Requests != 1 {
+				t.Fatalf("aggregate = %+v, want one request", got)
+			}
+			if want := map[bool]uint64{false: 0, true: 1}[tt.err]; got[0].Errors != want {
+				t.Fatalf("errors = %d, want %d", got[0].Errors, want)
+			}
+		})
+	}
+}
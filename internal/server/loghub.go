@@ -68,11 +68,20 @@ func (h *logHub) add(e LogEntry) {
 		h.count++
 	}
 	// Broadcast (best-effort, non-blocking).
+	// If a subscriber is too slow and its buffer is full, drop its oldest
+	// pending entry so the stream stays current. This never blocks record().
 	for ch := range h.subs {
 		select {
 		case ch <- e:
 		default:
-			// Drop if subscriber is too slow.
+			select {
+			case <-ch:
+			default:
+			}
+			select {
+			case ch <- e:
+			default:
+			}
 		}
 	}
 	h.mu.Unlock()
@@ -195,8 +204,11 @@ func containsFold(hay, needle string) bool {
 	return strings.Contains(strings.ToLower(hay), strings.ToLower(needle))
 }
 
+// logSubBuffer is the per-subscriber buffer of the live log stream.
+const logSubBuffer = 128
+
 func (h *logHub) subscribe() (ch chan LogEntry, cancel func()) {
-	ch = make(chan LogEntry, 32)
+	ch = make(chan LogEntry, logSubBuffer)
 	h.mu.Lock()
 	h.subs[ch] = struct{}{}
 	h.mu.Unlock()
@@ -46,9 +46,16 @@ func buildReqPreview(cfg config.Config, op byte, flags byte, payload []byte) (ou
 			return "(empty)"
 		}
 		return fmt.Sprintf("unexpected payload len=%d\n%s", len(payload), dumpBytes(payload, previewMaxBytes))
-	case proto.OpSTAT, proto.OpRM:
+	case proto.OpSTAT:
 		p := readPath(d)
 		return fmt.Sprintf("path=%s", p)
+	case proto.OpRM:
+		p := readPath(d)
+		fl := ""
+		if flags&proto.FlagDRY_RUN != 0 {
+			fl = " flags=DRY_RUN"
+		}
+		return fmt.Sprintf("path=%s%s", p, fl)
 	case proto.OpSTATFS:
 		p := "/"
 		if d.Remaining() > 0 {
@@ -62,11 +69,25 @@ func buildReqPreview(cfg config.Config, op byte, flags byte, payload []byte) (ou
 		p := readPath(d)
 		start, _ := d.ReadU16()
 		max, _ := d.ReadU16()
+		fl := []string{}
+		if n := lsSortFlagName(flags); n != "" {
+			fl = append(fl, n)
+		}
+		if flags&proto.FlagLS_REVERSE != 0 {
+			fl = append(fl, "REVERSE")
+		}
+		if len(fl) > 0 {
+			return fmt.Sprintf("path=%s\nstart_index=%d max_entries=%d\nflags=%s", p, start, max, strings.Join(fl, "|"))
+		}
 		return fmt.Sprintf("path=%s\nstart_index=%d max_entries=%d", p, start, max)
 	case proto.OpREAD_RANGE:
 		p := readPath(d)
 		off, _ := d.ReadU32()
 		ln, _ := d.ReadU16()
+		if flags&proto.FlagR_IF_HASH != 0 {
+			crc, _ := d.ReadU32()
+			return fmt.Sprintf("path=%s\noffset=%d len=%d\nflags=IF_HASH crc32=0x%08X", p, off, ln, crc)
+		}
 		return fmt.Sprintf("path=%s\noffset=%d len=%d", p, off, ln)
 	case proto.OpWRITE_RANGE:
 		p := readPath(d)
@@ -80,6 +101,21 @@ func buildReqPreview(cfg config.Config, op byte, flags byte, payload []byte) (ou
 		if flags&proto.FlagWR_CREATE != 0 {
 			fl = append(fl, "CREATE")
 		}
+		if flags&proto.FlagWR_STRIP_BOM != 0 {
+			fl = append(fl, "STRIP_BOM")
+		}
+		if flags&proto.FlagWR_WANT_SIZE != 0 {
+			fl = append(fl, "WANT_SIZE")
+		}
+		if flags&proto.FlagWR_STAGED != 0 {
+			fl = append(fl, "STAGED")
+		}
+		if flags&proto.FlagWR_COMMIT != 0 {
+			fl = append(fl, "COMMIT")
+		}
+		if flags&proto.FlagWR_CONFIRM != 0 {
+			fl = append(fl, "CONFIRM")
+		}
 		fs := ""
 		if len(fl) > 0 {
 			fs = " flags=" + strings.Join(fl, "|")
@@ -111,11 +147,18 @@ func buildReqPreview(cfg config.Config, op byte, flags byte, payload []byte) (ou
 		return fmt.Sprintf("path=%s%s", p, fl)
 	case proto.OpRMDIR:
 		p := readPath(d)
-		fl := ""
+		fl := []string{}
 		if flags&proto.FlagRD_RECURSIVE != 0 {
-			fl = " flags=RECURSIVE"
+			fl = append(fl, "RECURSIVE")
 		}
-		return fmt.Sprintf("path=%s%s", p, fl)
+		if flags&proto.FlagDRY_RUN != 0 {
+			fl = append(fl, "DRY_RUN")
+		}
+		fs := ""
+		if len(fl) > 0 {
+			fs = " flags=" + strings.Join(fl, "|")
+		}
+		return fmt.Sprintf("path=%s%s", p, fs)
 	case proto.OpCP:
 		src := readPath(d)
 		dst := readPath(d)
@@ -126,6 +169,15 @@ func buildReqPreview(cfg config.Config, op byte, flags byte, payload []byte) (ou
 		if flags&proto.FlagCP_RECURSIVE != 0 {
 			fl = append(fl, "RECURSIVE")
 		}
+		if flags&proto.FlagCP_ASYNC != 0 {
+			fl = append(fl, "ASYNC")
+		}
+		if flags&proto.FlagCP_CONVERT != 0 {
+			fl = append(fl, "CONVERT")
+		}
+		if flags&proto.FlagDRY_RUN != 0 {
+			fl = append(fl, "DRY_RUN")
+		}
 		fs := ""
 		if len(fl) > 0 {
 			fs = " flags=" + strings.Join(fl, "|")
@@ -134,16 +186,25 @@ func buildReqPreview(cfg config.Config, op byte, flags byte, payload []byte) (ou
 	case proto.OpMV:
 		src := readPath(d)
 		dst := readPath(d)
-		fl := ""
+		fl := []string{}
 		if flags&proto.FlagMV_OVERWRITE != 0 {
-			fl = " flags=OVERWRITE"
+			fl = append(fl, "OVERWRITE")
+		}
+		if flags&proto.FlagDRY_RUN != 0 {
+			fl = append(fl, "DRY_RUN")
 		}
-		return fmt.Sprintf("src=%s\ndst=%s%s", src, dst, fl)
+		fs := ""
+		if len(fl) > 0 {
+			fs = " flags=" + strings.Join(fl, "|")
+		}
+		return fmt.Sprintf("src=%s\ndst=%s%s", src, dst, fs)
 	case proto.OpHASH:
 		p := readPath(d)
 		algo := "CRC32"
-		if flags&proto.FlagH_ALGO != 0 {
-			algo = "SHA1"
+		if flags&proto.FlagH_SHA256 != 0 {
+			algo = "SHA256"
+		} else if flags&proto.FlagH_CRC16 != 0 {
+			algo = "CRC16"
 		}
 		return fmt.Sprintf("path=%s\nalgo=%s", p, algo)
 	case proto.OpSEARCH:
@@ -162,11 +223,186 @@ func buildReqPreview(cfg config.Config, op byte, flags byte, payload []byte) (ou
 		if flags&proto.FlagS_WHOLE_WORD != 0 {
 			fl = append(fl, "WHOLE_WORD")
 		}
+		if flags&proto.FlagS_REGEX != 0 {
+			fl = append(fl, "REGEX")
+		}
+		if flags&proto.FlagS_NAMES != 0 {
+			fl = append(fl, "NAMES")
+		}
+		if flags&proto.FlagS_INTO_IMAGES != 0 {
+			fl = append(fl, "IMAGES")
+		}
 		fs := ""
 		if len(fl) > 0 {
 			fs = " flags=" + strings.Join(fl, "|")
 		}
 		return fmt.Sprintf("base=%s\nquery=%q\nstart_index=%d max_results=%d max_scan_bytes=%d%s", base, trunc(q, 80), start, max, maxScan, fs)
+	case proto.OpDIRMTIME:
+		p := readPath(d)
+		return fmt.Sprintf("path=%s", p)
+	case proto.OpSTRINGS:
+		p := readPath(d)
+		off, _ := d.ReadU32()
+		minLen, _ := d.ReadU8()
+		maxScan, _ := d.ReadU32()
+		return fmt.Sprintf("path=%s\nstart_offset=%d min_len=%d max_scan_bytes=%d", p, off, minLen, maxScan)
+	case proto.OpTREE:
+		p := readPath(d)
+		depth, _ := d.ReadU8()
+		max, _ := d.ReadU16()
+		fl := ""
+		if flags&proto.FlagTR_FILES != 0 {
+			fl = " flags=FILES"
+		}
+		return fmt.Sprintf("path=%s\nmax_depth=%d max_nodes=%d%s", p, depth, max, fl)
+	case proto.OpREAD_TAIL:
+		p := readPath(d)
+		ln, _ := d.ReadU16()
+		return fmt.Sprintf("path=%s\ncount=%d", p, ln)
+	case proto.OpTOKEN_NAMES:
+		start, _ := d.ReadU16()
+		max, _ := d.ReadU16()
+		return fmt.Sprintf("start_index=%d max_entries=%d", start, max)
+	case proto.OpTOUCH:
+		p := readPath(d)
+		mt, _ := d.ReadU32()
+		fl := ""
+		if flags&proto.FlagT_CREATE != 0 {
+			fl = " flags=CREATE"
+		}
+		ts := "now"
+		if mt != 0 {
+			ts = time.Unix(int64(mt), 0).UTC().Format(time.RFC3339)
+		}
+		return fmt.Sprintf("path=%s\nmtime=%d (%s)%s", p, mt, ts, fl)
+	case proto.OpMKTEMP:
+		prefix, _ := d.ReadString(cfg.MaxName)
+		suffix, _ := d.ReadString(cfg.MaxName)
+		return fmt.Sprintf("prefix=%q suffix=%q", prefix, suffix)
+	case proto.OpBATCH:
+		n, _ := d.ReadU8()
+		lines := []string{fmt.Sprintf("count=%d continue=%v", n, flags&proto.FlagB_CONTINUE != 0)}
+		for i := 0; i < int(n) && i < previewMaxEntries; i++ {
+			ln, err := d.ReadU16()
+			if err != nil {
+				break
+			}
+			b, err := d.ReadBytes(int(ln))
+			if err != nil || len(b) < 2 {
+				break
+			}
+			lines = append(lines, fmt.Sprintf("[%d] %s flags=0x%02X len=%d", i, opName(b[0]), b[1], len(b)-2))
+		}
+		return strings.Join(lines, "\n")
+	case proto.OpECHO:
+		delay, _ := d.ReadU16()
+		data, _ := d.ReadBytes(d.Remaining())
+		return fmt.Sprintf("delay_ms=%d\ndata_len=%d\n%s", delay, len(data), dumpBytes(data, previewMaxBytes))
+	case proto.OpLOCK:
+		return "path=" + readPath(d)
+	case proto.OpUNLOCK:
+		fl := ""
+		if flags&proto.FlagUL_FORCE != 0 {
+			fl = "\nforce=true"
+		}
+		return "path=" + readPath(d) + fl
+	case proto.OpCOPY_RANGE:
+		src := readPath(d)
+		srcOff, _ := d.ReadU32()
+		dst := readPath(d)
+		dstOff, _ := d.ReadU32()
+		ln, _ := d.ReadU16()
+		fl := []string{}
+		if flags&proto.FlagWR_TRUNCATE != 0 {
+			fl = append(fl, "TRUNCATE")
+		}
+		if flags&proto.FlagWR_CREATE != 0 {
+			fl = append(fl, "CREATE")
+		}
+		if flags&proto.FlagWR_OVERWRITE != 0 {
+			fl = append(fl, "OVERWRITE")
+		}
+		fs := ""
+		if len(fl) > 0 {
+			fs = " flags=" + strings.Join(fl, "|")
+		}
+		return fmt.Sprintf("src=%s offset=%d\ndst=%s offset=%d\nlen=%d%s", src, srcOff, dst, dstOff, ln, fs)
+	case proto.OpSAMEFILE:
+		a := readPath(d)
+		b := readPath(d)
+		return fmt.Sprintf("path_a=%s\npath_b=%s", a, b)
+	case proto.OpLS_TREE:
+		p := readPath(d)
+		depth, _ := d.ReadU8()
+		start, _ := d.ReadU16()
+		max, _ := d.ReadU16()
+		fl := ""
+		if flags&proto.FlagLT_IMAGES != 0 {
+			fl = " flags=IMAGES"
+		}
+		return fmt.Sprintf("path=%s\nmax_depth=%d start_index=%d max_entries=%d%s", p, depth, start, max, fl)
+	case proto.OpIMAGE_CHANGES:
+		p := readPath(d)
+		since, _ := d.ReadU32()
+		start, _ := d.ReadU16()
+		return fmt.Sprintf("path=%s\nsince=%d start_index=%d", p, since, start)
+	case proto.OpEXISTS_EXACT:
+		return "path=" + readPath(d)
+	case proto.OpPROGRESS:
+		id, _ := d.ReadU32()
+		return fmt.Sprintf("op_id=%d", id)
+	case proto.OpVERIFY:
+		return "path=" + readPath(d)
+	case proto.OpTRASH_LS:
+		start, _ := d.ReadU16()
+		max, _ := d.ReadU16()
+		return fmt.Sprintf("start_index=%d max_entries=%d", start, max)
+	case proto.OpDIRHASH:
+		p := readPath(d)
+		fl := ""
+		if flags&proto.FlagDH_RECURSIVE != 0 {
+			fl = " flags=RECURSIVE"
+		}
+		return "path=" + p + fl
+	case proto.OpTRASH_RESTORE:
+		id, _ := d.ReadString(0xFFFF)
+		dst, _ := d.ReadString(0xFFFF)
+		if dst == "" {
+			dst = "(original path)"
+		}
+		fs := ""
+		if flags&proto.FlagTRR_OVERWRITE != 0 {
+			fs = " flags=OVERWRITE"
+		}
+		return fmt.Sprintf("id=%s\nto=%s%s", id, dst, fs)
+	case proto.OpSTAT_MANY:
+		n, _ := d.ReadU16()
+		lines := []string{fmt.Sprintf("count=%d", n)}
+		for i := 0; i < int(n) && i < previewMaxEntries; i++ {
+			p, err := d.ReadString(0xFFFF)
+			if err != nil {
+				break
+			}
+			lines = append(lines, fmt.Sprintf("[%d] %s", i, p))
+		}
+		if int(n) > previewMaxEntries {
+			lines = append(lines, "...")
+		}
+		return strings.Join(lines, "\n")
+	case proto.OpMKIMAGE:
+		p, _ := d.ReadString(0xFFFF)
+		kind, _ := d.ReadU8()
+		name, _ := d.ReadString(0xFFFF)
+		id, _ := d.ReadString(0xFFFF)
+		fs := ""
+		if flags&proto.FlagMI_OVERWRITE != 0 {
+			fs = "\nflags=OVERWRITE"
+		}
+		return fmt.Sprintf("path=%s\nkind=%s\nname=%q id=%q%s", p, imageKindName(kind), name, id, fs)
+	case proto.OpIMAGES:
+		p, _ := d.ReadString(0xFFFF)
+		start, _ := d.ReadU16()
+		return fmt.Sprintf("path=%s\nstart_index=%d%s", p, start, choose(flags&proto.FlagIM_RECURSIVE != 0, "\nflags=RECURSIVE", ""))
 	default:
 		if len(payload) == 0 {
 			return "(empty)"
@@ -187,13 +423,40 @@ func buildRespPreview(cfg config.Config, op byte, status byte, payload []byte, e
 		if msg == "" {
 			msg = statusName(status)
 		}
+		if op == proto.OpWRITE_RANGE && status == proto.StatusAlreadyExists && len(payload) == 6 {
+			code := binary.LittleEndian.Uint32(payload)
+			sec := binary.LittleEndian.Uint16(payload[4:])
+			return fmt.Sprintf("%s\n%s\nconfirm=%d valid_sec=%d", statusName(status), msg, code, sec)
+		}
 		return fmt.Sprintf("%s\n%s", statusName(status), msg)
 	}
+	if op == proto.OpREAD_RANGE && errMsg == notModifiedMsg {
+		return "OK\nNOT_MODIFIED (CRC matches, nothing read)"
+	}
 	if len(payload) == 0 {
 		return "OK"
 	}
 
 	d := proto.NewDecoder(payload)
+	if errMsg == dryRunMsg && isDryRunOp(op) {
+		// count u16, listed u16, paths...
+		count, _ := d.ReadU16()
+		listed, _ := d.ReadU16()
+		lines := []string{fmt.Sprintf("DRY_RUN (nothing changed)\ncount=%d", count)}
+		shown := 0
+		for i := 0; i < int(listed) && shown < previewMaxEntries; i++ {
+			p, err := d.ReadString(cfg.MaxPath)
+			if err != nil {
+				break
+			}
+			lines = append(lines, "- "+p)
+			shown++
+		}
+		if int(count) > shown {
+			lines = append(lines, fmt.Sprintf("(+%d more)", int(count)-shown))
+		}
+		return strings.Join(lines, "\n")
+	}
 	switch op {
 	case proto.OpCAPS:
 		maxChunk, _ := d.ReadU16()
@@ -204,11 +467,14 @@ func buildRespPreview(cfg config.Config, op byte, status byte, payload []byte, e
 		features, _ := d.ReadU32()
 		serverTime, _ := d.ReadU32()
 		sname, _ := d.ReadString(cfg.MaxName)
+		maxDecompressed, _ := d.ReadU16()
+		featuresHi, _ := d.ReadU32()
+		previewLen, _ := d.ReadU16()
 
 		ft := time.Unix(int64(serverTime), 0).UTC().Format(time.RFC3339)
 		return fmt.Sprintf(
-			"CAPS\nmax_chunk=%d\nmax_payload=%d\nmax_path=%d\nmax_name=%d\nmax_entries=%d\nfeatures=0x%08X\nserver_time_utc=%s\nserver_name=%q",
-			maxChunk, maxPayload, maxPath, maxName, maxEntries, features, ft, sname,
+			"CAPS\nmax_chunk=%d\nmax_payload=%d\nmax_path=%d\nmax_name=%d\nmax_entries=%d\nmax_decompressed=%d\npreview_len=%d\nfeatures=0x%08X%08X\nserver_time_utc=%s\nserver_name=%q",
+			maxChunk, maxPayload, maxPath, maxName, maxEntries, maxDecompressed, previewLen, featuresHi, features, ft, sname,
 		)
 	case proto.OpSTATFS:
 		if len(payload) < 12 {
@@ -245,9 +511,12 @@ func buildRespPreview(cfg config.Config, op byte, status byte, payload []byte, e
 			mt, _ := d.ReadU32()
 			name, _ := d.ReadString(cfg.MaxName)
 			suffix := ""
-			if et != 0 {
+			if et&^proto.LSEntryTruncated != 0 {
 				suffix = "/"
 			}
+			if et&proto.LSEntryTruncated != 0 {
+				suffix += " (truncated)"
+			}
 			_ = sz
 			_ = mt
 			lines = append(lines, fmt.Sprintf("- %s%s", name, suffix))
@@ -263,6 +532,13 @@ func buildRespPreview(cfg config.Config, op byte, status byte, payload []byte, e
 		}
 		return strings.Join(lines, "\n")
 	case proto.OpHASH:
+		if len(payload) == 32 {
+			return fmt.Sprintf("HASH\nsha256=%x", payload)
+		}
+		if len(payload) == 2 {
+			sum := binary.LittleEndian.Uint16(payload)
+			return fmt.Sprintf("HASH\ncrc16=0x%04X (%d)", sum, sum)
+		}
 		if len(payload) != 4 {
 			return fmt.Sprintf("HASH payload len=%d\n%s", len(payload), dumpBytes(payload, previewMaxBytes))
 		}
@@ -294,6 +570,280 @@ func buildRespPreview(cfg config.Config, op byte, status byte, payload []byte, e
 			lines = append(lines, fmt.Sprintf("(+%d more)", int(count)-shown))
 		}
 		return strings.Join(lines, "\n")
+	case proto.OpDIRMTIME:
+		if len(payload) < 11 {
+			return fmt.Sprintf("DIRMTIME payload too short (%d)\n%s", len(payload), dumpBytes(payload, previewMaxBytes))
+		}
+		dirMt, _ := d.ReadU32()
+		maxMt, _ := d.ReadU32()
+		n, _ := d.ReadU16()
+		fl, _ := d.ReadU8()
+		return fmt.Sprintf("DIRMTIME\ndir_mtime_utc=%s\nmax_mtime_utc=%s\nentries=%d truncated=%v",
+			time.Unix(int64(dirMt), 0).UTC().Format(time.RFC3339), time.Unix(int64(maxMt), 0).UTC().Format(time.RFC3339), n, fl&0x01 != 0)
+	case proto.OpSTRINGS:
+		if len(payload) < 6 {
+			return fmt.Sprintf("STRINGS payload too short (%d)\n%s", len(payload), dumpBytes(payload, previewMaxBytes))
+		}
+		count, _ := d.ReadU16()
+		lines := []string{fmt.Sprintf("STRINGS\ncount=%d", count)}
+		shown := 0
+		for i := 0; i < int(count) && shown < previewMaxEntries && d.Remaining() > 4; i++ {
+			off, _ := d.ReadU32()
+			ln, _ := d.ReadU16()
+			run, _ := d.ReadBytes(int(ln))
+			lines = append(lines, fmt.Sprintf("- @%d: %q", off, trunc(asciiSanitize(string(run)), 40)))
+			shown++
+		}
+		next := binary.LittleEndian.Uint32(payload[len(payload)-4:])
+		lines = append(lines, fmt.Sprintf("next_offset=0x%08X", next))
+		if int(count) > shown {
+			lines = append(lines, fmt.Sprintf("(+%d more)", int(count)-shown))
+		}
+		return strings.Join(lines, "\n")
+	case proto.OpTREE:
+		if len(payload) < 3 {
+			return fmt.Sprintf("TREE payload too short (%d)\n%s", len(payload), dumpBytes(payload, previewMaxBytes))
+		}
+		count, _ := d.ReadU16()
+		tr, _ := d.ReadU8()
+		lines := []string{fmt.Sprintf("TREE\ncount=%d truncated=%v", count, tr != 0)}
+		shown := 0
+		for i := 0; i < int(count) && shown < previewMaxEntries && d.Remaining() > 0; i++ {
+			depth, _ := d.ReadU8()
+			typ, _ := d.ReadU8()
+			_, _ = d.ReadU16()
+			name, _ := d.ReadString(cfg.MaxName)
+			suffix := ""
+			if typ != 0 {
+				suffix = "/"
+			}
+			lines = append(lines, fmt.Sprintf("%s- %s%s", strings.Repeat("  ", int(depth)), name, suffix))
+			shown++
+		}
+		if int(count) > shown {
+			lines = append(lines, fmt.Sprintf("(+%d more)", int(count)-shown))
+		}
+		return strings.Join(lines, "\n")
+	case proto.OpREAD_TAIL:
+		if len(payload) < 4 {
+			return fmt.Sprintf("READ_TAIL payload too short (%d)\n%s", len(payload), dumpBytes(payload, previewMaxBytes))
+		}
+		off := binary.LittleEndian.Uint32(payload[0:4])
+		data := payload[4:]
+		return fmt.Sprintf("READ_TAIL\nstart_offset=%d bytes=%d\n\nDATA (preview)\n%s", off, len(data), dumpBytes(data, previewMaxBytes))
+	case proto.OpTOKEN_NAMES:
+		if len(payload) < 4 {
+			return fmt.Sprintf("TOKEN_NAMES payload too short (%d)\n%s", len(payload), dumpBytes(payload, previewMaxBytes))
+		}
+		count, _ := d.ReadU16()
+		lines := []string{fmt.Sprintf("TOKEN_NAMES\ncount=%d", count)}
+		shown := 0
+		for i := 0; i < int(count) && shown < previewMaxEntries && d.Remaining() > 2; i++ {
+			en, _ := d.ReadU8()
+			name, _ := d.ReadString(cfg.MaxPath)
+			state := ""
+			if en == 0 {
+				state = " (disabled)"
+			}
+			lines = append(lines, fmt.Sprintf("- %s%s", name, state))
+			shown++
+		}
+		next := binary.LittleEndian.Uint16(payload[len(payload)-2:])
+		lines = append(lines, fmt.Sprintf("next_index=%d", next))
+		if int(count) > shown {
+			lines = append(lines, fmt.Sprintf("(+%d more)", int(count)-shown))
+		}
+		return strings.Join(lines, "\n")
+	case proto.OpMKTEMP:
+		p, _ := d.ReadString(cfg.MaxPath)
+		return fmt.Sprintf("MKTEMP\npath=%s", p)
+	case proto.OpBATCH:
+		n, _ := d.ReadU8()
+		lines := []string{fmt.Sprintf("BATCH\ncount=%d", n)}
+		for i := 0; i < int(n) && i < previewMaxEntries; i++ {
+			ln, err := d.ReadU16()
+			if err != nil {
+				break
+			}
+			b, err := d.ReadBytes(int(ln))
+			if err != nil || len(b) < 1 {
+				break
+			}
+			lines = append(lines, fmt.Sprintf("[%d] %s len=%d", i, statusName(b[0]), len(b)-1))
+		}
+		return strings.Join(lines, "\n")
+	case proto.OpECHO:
+		delay, _ := d.ReadU16()
+		data, _ := d.ReadBytes(d.Remaining())
+		return fmt.Sprintf("ECHO\ndelay_ms=%d\ndata_len=%d\n%s", delay, len(data), dumpBytes(data, previewMaxBytes))
+	case proto.OpLOCK:
+		ttl, _ := d.ReadU32()
+		return fmt.Sprintf("LOCK\nttl_sec=%d", ttl)
+	case proto.OpCOPY_RANGE:
+		n, _ := d.ReadU32()
+		return fmt.Sprintf("COPY_RANGE\ncopied=%d", n)
+	case proto.OpMOTD:
+		m, _ := d.ReadString(0xFFFF)
+		return fmt.Sprintf("MOTD\n%q", m)
+	case proto.OpSAMEFILE:
+		same, _ := d.ReadU8()
+		return fmt.Sprintf("SAMEFILE\nsame=%t", same != 0)
+	case proto.OpLS_TREE:
+		if len(payload) < 4 {
+			return fmt.Sprintf("LS_TREE payload too short (%d)\n%s", len(payload), dumpBytes(payload, previewMaxBytes))
+		}
+		count, _ := d.ReadU16()
+		lines := []string{fmt.Sprintf("LS_TREE\ncount=%d", count)}
+		shown := 0
+		for i := 0; i < int(count) && shown < previewMaxEntries && d.Remaining() > 2; i++ {
+			typ, _ := d.ReadU8()
+			size, _ := d.ReadU32()
+			_, _ = d.ReadU32()
+			_, _ = d.ReadString(cfg.MaxName)
+			_, _ = d.ReadU8()
+			rel, _ := d.ReadString(0xFFFF)
+			if typ != 0 {
+				lines = append(lines, fmt.Sprintf("- %s/", rel))
+			} else {
+				lines = append(lines, fmt.Sprintf("- %s (%d)", rel, size))
+			}
+			shown++
+		}
+		if int(count) > shown {
+			lines = append(lines, fmt.Sprintf("(+%d more)", int(count)-shown))
+		}
+		return strings.Join(lines, "\n")
+	case proto.OpIMAGE_CHANGES:
+		if len(payload) < 9 {
+			return fmt.Sprintf("IMAGE_CHANGES payload too short (%d)\n%s", len(payload), dumpBytes(payload, previewMaxBytes))
+		}
+		mtime, _ := d.ReadU32()
+		state, _ := d.ReadU8()
+		count, _ := d.ReadU16()
+		lines := []string{fmt.Sprintf("IMAGE_CHANGES\nimage_mtime=%d index_state=%d count=%d", mtime, state, count)}
+		shown := 0
+		for i := 0; i < int(count) && shown < previewMaxEntries && d.Remaining() > 2; i++ {
+			fl, _ := d.ReadU8()
+			changed, _ := d.ReadU32()
+			p, _ := d.ReadString(0xFFFF)
+			lines = append(lines, fmt.Sprintf("- %s changed=%d%s%s", p, changed, choose(fl&2 != 0, " DIR", ""), choose(fl&1 != 0, " DELETED", "")))
+			shown++
+		}
+		if int(count) > shown {
+			lines = append(lines, fmt.Sprintf("(+%d more)", int(count)-shown))
+		}
+		return strings.Join(lines, "\n")
+	case proto.OpEXISTS_EXACT:
+		if len(payload) < 9 {
+			return fmt.Sprintf("EXISTS_EXACT payload too short (%d)\n%s", len(payload), dumpBytes(payload, previewMaxBytes))
+		}
+		typ, _ := d.ReadU8()
+		size, _ := d.ReadU32()
+		mtime, _ := d.ReadU32()
+		return fmt.Sprintf("EXISTS_EXACT\ntype=%s size=%d mtime=%d", choose(typ == 1, "DIR", "FILE"), size, mtime)
+	case proto.OpCP:
+		id, _ := d.ReadU32()
+		return fmt.Sprintf("CP async\nop_id=%d", id)
+	case proto.OpPROGRESS:
+		state, _ := d.ReadU8()
+		st, _ := d.ReadU8()
+		copied, _ := d.ReadU32()
+		total, _ := d.ReadU32()
+		if state == 0 {
+			return fmt.Sprintf("PROGRESS\nrunning copied=%d total=%d", copied, total)
+		}
+		return fmt.Sprintf("PROGRESS\ndone status=%s copied=%d total=%d", statusName(st), copied, total)
+	case proto.OpWRITE_RANGE:
+		if len(payload) == 8 {
+			size, _ := d.ReadU32()
+			written, _ := d.ReadU32()
+			return fmt.Sprintf("WRITE_RANGE\nnew_size=%d written=%d", size, written)
+		}
+		if len(payload) == 4 {
+			written, _ := d.ReadU32()
+			return fmt.Sprintf("WRITE_RANGE\nwritten=%d", written)
+		}
+		return fmt.Sprintf("len=%d\n%s", len(payload), dumpBytes(payload, previewMaxBytes))
+	case proto.OpVERIFY:
+		problems, _ := d.ReadU16()
+		files, _ := d.ReadU16()
+		summary, _ := d.ReadString(0xFFFF)
+		return fmt.Sprintf("VERIFY\nproblems=%d files=%d\n%s", problems, files, summary)
+	case proto.OpTRASH_LS:
+		count, _ := d.ReadU16()
+		lines := []string{fmt.Sprintf("TRASH_LS\ncount=%d", count)}
+		for i := 0; i < int(count) && i < previewMaxEntries && d.Remaining() > 2; i++ {
+			et, _ := d.ReadU8()
+			sz, _ := d.ReadU32()
+			_, _ = d.ReadU32()
+			id, _ := d.ReadString(0xFFFF)
+			p, _ := d.ReadString(0xFFFF)
+			if et == 1 {
+				p += "/"
+			}
+			lines = append(lines, fmt.Sprintf("- %s %s (%d bytes)", id, p, sz))
+		}
+		if len(payload) >= 2 {
+			lines = append(lines, fmt.Sprintf("next_index=%d", binary.LittleEndian.Uint16(payload[len(payload)-2:])))
+		}
+		return strings.Join(lines, "\n")
+	case proto.OpDIRHASH:
+		lo, _ := d.ReadU32()
+		hi, _ := d.ReadU32()
+		n, _ := d.ReadU32()
+		return fmt.Sprintf("DIRHASH\nhash=%08x%08x entries=%d", hi, lo, n)
+	case proto.OpTRASH_RESTORE:
+		p, _ := d.ReadString(0xFFFF)
+		return fmt.Sprintf("TRASH_RESTORE\nrestored to %s", p)
+	case proto.OpSTAT_MANY:
+		n, _ := d.ReadU16()
+		lines := []string{fmt.Sprintf("STAT_MANY\ncount=%d", n)}
+		for i := 0; i < int(n) && i < previewMaxEntries; i++ {
+			st, _ := d.ReadU8()
+			t, _ := d.ReadU8()
+			sz, _ := d.ReadU32()
+			_, err := d.ReadU32()
+			if err != nil {
+				break
+			}
+			if st != proto.StatusOK {
+				lines = append(lines, fmt.Sprintf("[%d] %s", i, statusName(st)))
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("[%d] %s size=%d", i, choose(t == 1, "dir", "file"), sz))
+		}
+		return strings.Join(lines, "\n")
+	case proto.OpMKIMAGE:
+		size, _ := d.ReadU32()
+		return fmt.Sprintf("MKIMAGE\nsize=%d", size)
+	case proto.OpWHOAMI:
+		fl, _ := d.ReadU8()
+		quota, _ := d.ReadU32()
+		used, _ := d.ReadU32()
+		maxFile, _ := d.ReadU32()
+		return fmt.Sprintf("WHOAMI\nread_only=%t quota_full=%t\nquota=%s used=%s max_file=%s\ndisk_images=%t write=%t",
+			fl&proto.WhoReadOnly != 0, fl&proto.WhoQuotaFull != 0, humanBytes(uint64(quota)), humanBytes(uint64(used)), humanBytes(uint64(maxFile)),
+			fl&proto.WhoDiskImages != 0, fl&proto.WhoDiskImagesWrite != 0)
+	case proto.OpIMAGES:
+		count, _ := d.ReadU16()
+		lines := []string{fmt.Sprintf("IMAGES\ncount=%d", count)}
+		for i := 0; i < int(count) && i < previewMaxEntries; i++ {
+			p, _ := d.ReadString(0xFFFF)
+			kind, _ := d.ReadU8()
+			size, _ := d.ReadU32()
+			free, _ := d.ReadU16()
+			fl, _ := d.ReadU8()
+			_, err := d.ReadU32()
+			if err != nil {
+				break
+			}
+			lines = append(lines, fmt.Sprintf("%s %s size=%d free=%s%s%s", p, imageKindName(kind), size,
+				choose(free == 0xFFFF, "?", fmt.Sprint(free)), choose(fl&proto.ImgBusy != 0, " BUSY", ""), choose(fl&proto.ImgLocked != 0, " LOCKED", "")))
+		}
+		if int(count) > previewMaxEntries {
+			lines = append(lines, fmt.Sprintf("(+%d more)", int(count)-previewMaxEntries))
+		}
+		return strings.Join(lines, "\n")
 	default:
 		// Unknown/other: show compact dump.
 		return fmt.Sprintf("len=%d\n%s", len(payload), dumpBytes(payload, previewMaxBytes))
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"container/list"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"wicos64-server/internal/config"
+)
+
+// LS pages through a directory with start_index; without a cache every page
+// re-reads and re-sorts the whole directory. lsCache keeps the sorted entries
+// of recently listed directories, keyed by pathLockKey of the directory and
+// checked against its mtime. Only the names are cached: os.DirEntry.Info is
+// still called for the entries of a page, so sizes and mtimes stay current.
+const (
+	lsCacheMaxDirs = 64
+
+	// lsCacheSettle: a directory changed this recently is not cached, as a
+	// second change within the mtime granularity of the filesystem would go
+	// unnoticed.
+	lsCacheSettle = 2 * time.Second
+)
+
+// readDir is os.ReadDir; a variable so tests can count directory reads.
+var readDir = os.ReadDir
+
+type lsCache struct {
+	mu    sync.Mutex
+	m     map[string]*list.Element
+	order list.List // front = most recently used
+}
+
+type lsCacheEntry struct {
+	key     string
+	mtime   time.Time
+	entries []os.DirEntry
+}
+
+func (c *lsCache) get(key string, mtime time.Time) ([]os.DirEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.m[key]
+	if !ok {
+		return nil, false
+	}
+	ent := el.Value.(*lsCacheEntry)
+	if !ent.mtime.Equal(mtime) {
+		c.order.Remove(el)
+		delete(c.m, key)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return ent.entries, true
+}
+
+func (c *lsCache) put(key string, mtime time.Time, entries []os.DirEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m == nil {
+		c.m = map[string]*list.Element{}
+	}
+	if el, ok := c.m[key]; ok {
+		c.order.Remove(el)
+	}
+	c.m[key] = c.order.PushFront(&lsCacheEntry{key: key, mtime: mtime, entries: entries})
+	for c.order.Len() > lsCacheMaxDirs {
+		el := c.order.Back()
+		c.order.Remove(el)
+		delete(c.m, el.Value.(*lsCacheEntry).key)
+	}
+}
+
+func (c *lsCache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.m[key]; ok {
+		c.order.Remove(el)
+		delete(c.m, key)
+	}
+}
+
+func (c *lsCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.m = nil
+	c.order.Init()
+}
+
+// readDirSorted returns the entries of the directory abs sorted by name
+// (case-insensitive), from the LS cache when the directory is unchanged.
+// The returned slice is shared: callers must not modify it.
+func (s *Server) readDirSorted(abs string) ([]os.DirEntry, error) {
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return nil, err
+	}
+	key := pathLockKey(abs)
+	mtime := fi.ModTime()
+	if entries, ok := s.lsDirs.get(key, mtime); ok {
+		return entries, nil
+	}
+
+	entries, err := readDir(abs)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return strings.ToUpper(entries[i].Name()) < strings.ToUpper(entries[j].Name())
+	})
+	if time.Since(mtime) >= lsCacheSettle {
+		s.lsDirs.put(key, mtime, entries)
+	}
+	return entries, nil
+}
+
+// invalidateLSCache drops the cached listings a write op may have changed:
+// the directories it addresses and their parents. If the paths cannot be
+// told, the whole cache is dropped.
+func (s *Server) invalidateLSCache(cfg config.Config, op byte, payload []byte, rootAbs string) {
+	paths := s.writeLockPaths(cfg, op, payload)
+	if len(paths) == 0 {
+		s.lsDirs.clear()
+		return
+	}
+	for _, p := range paths {
+		abs := filepath.Join(rootAbs, filepath.FromSlash(p))
+		s.lsDirs.invalidate(pathLockKey(abs))
+		s.lsDirs.invalidate(pathLockKey(filepath.Dir(abs)))
+	}
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+// countReadDirs counts the directory reads of readDirSorted.
+func countReadDirs(t *testing.T) *int {
+	t.Helper()
+	n := 0
+	readDir = func(name string) ([]os.DirEntry, error) {
+		n++
+		return os.ReadDir(name)
+	}
+	t.Cleanup(func() { readDir = os.ReadDir })
+	return &n
+}
+
+// lsNames pages through dir with LS, max entries per page, and returns the
+// names in order.
+func lsNames(t *testing.T, s *Server, cfg config.Config, rootAbs, dir string, max uint16) []string {
+	t.Helper()
+	return lsPages(t, s, cfg, Limits{}, rootAbs, dir, 0, max)
+}
+
+// lsPages lists dir with LS flags in pages of max entries and returns the
+// names in order.
+func lsPages(t *testing.T, s *Server, cfg config.Config, limits Limits, rootAbs, dir string, flags byte, max uint16) []string {
+	t.Helper()
+	var names []string
+	start := uint16(0)
+	for {
+		e := proto.NewEncoder(32)
+		_ = e.WriteString(dir)
+		e.WriteU16(start)
+		e.WriteU16(max)
+		st, resp, msg := s.dispatch(cfg, limits, proto.OpLS, flags, e.Bytes(), rootAbs)
+		if st != proto.StatusOK {
+			t.Fatalf("LS %s @%d = %s (%s)", dir, start, statusName(st), msg)
+		}
+		d := proto.NewDecoder(resp)
+		n, _ := d.ReadU16()
+		for i := 0; i < int(n); i++ {
+			_, _ = d.ReadU8()
+			_, _ = d.ReadU32()
+			_, _ = d.ReadU32()
+			name, err := d.ReadString(0xFFFF)
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, name)
+		}
+		next, _ := d.ReadU16()
+		if next == 0xFFFF {
+			return names
+		}
+		start = next
+	}
+}
+
+// settle backdates dir past lsCacheSettle, as if it had not changed for a while.
+func settle(t *testing.T, dir string, age time.Duration) {
+	t.Helper()
+	at := time.Now().Add(-age)
+	if err := os.Chtimes(dir, at, at); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLSCachePaging(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	reads := countReadDirs(t)
+	big := filepath.Join(rootAbs, "BIG")
+	files := map[string]string{}
+	for i := 0; i < 50; i++ {
+		files[fmt.Sprintf("BIG/F%02d", i)] = "x"
+	}
+	files["BIG/a-lower"] = "x"
+	writeFiles(t, rootAbs, files)
+	settle(t, big, time.Minute)
+
+	first := lsNames(t, s, cfg, rootAbs, "/BIG", 10)
+	if len(first) != 51 || first[0] != "A-LOWER" || first[1] != "F00" || first[50] != "F49" {
+		t.Fatalf("LS /BIG = %v", first)
+	}
+	if *reads != 1 {
+		t.Fatalf("6 pages read the directory %d times, want 1", *reads)
+	}
+	if again := lsNames(t, s, cfg, rootAbs, "/BIG", 10); len(again) != 51 || *reads != 1 {
+		t.Fatalf("second pass: %d names, %d reads", len(again), *reads)
+	}
+
+	// A write through the server drops the listing at once, even before
+	// the mtime moves.
+	if st, _, msg := s.dispatch(cfg, Limits{}, proto.OpWRITE_RANGE, proto.FlagWR_CREATE, writeRangePayload(t, "/BIG/NEW", 0, []byte("n")), rootAbs); st != proto.StatusOK {
+		t.Fatalf("WRITE_RANGE = %s (%s)", statusName(st), msg)
+	}
+	settle(t, big, time.Minute)
+	*reads = 0
+	if names := lsNames(t, s, cfg, rootAbs, "/BIG", 10); len(names) != 52 || *reads != 1 {
+		t.Fatalf("after WRITE_RANGE: %d names, %d reads", len(names), *reads)
+	}
+	for _, op := range []struct {
+		op byte
+		pl []byte
+	}{
+		{proto.OpRM, pathPayload("/BIG/NEW")},
+		{proto.OpMKDIR, pathPayload("/BIG/SUB")},
+		{proto.OpMV, cpPayload("/BIG/F00", "/BIG/G00")},
+		{proto.OpCP, cpPayload("/BIG/F01", "/BIG/G01")},
+	} {
+		if st, _, msg := s.dispatch(cfg, Limits{}, op.op, 0, op.pl, rootAbs); st != proto.StatusOK {
+			t.Fatalf("%s = %s (%s)", opName(op.op), statusName(st), msg)
+		}
+		if _, ok := s.lsDirs.m[pathLockKey(big)]; ok {
+			t.Errorf("%s kept the cached listing of /BIG", opName(op.op))
+		}
+		settle(t, big, time.Minute)
+		lsNames(t, s, cfg, rootAbs, "/BIG", 100)
+	}
+	if names := lsNames(t, s, cfg, rootAbs, "/BIG", 100); len(names) != 53 {
+		t.Fatalf("LS /BIG after the ops = %d names", len(names))
+	}
+
+	// Changed behind the server's back: the mtime tells.
+	writeFiles(t, rootAbs, map[string]string{"BIG/OUTSIDE": "o"})
+	settle(t, big, 2*time.Minute)
+	*reads = 0
+	if names := lsNames(t, s, cfg, rootAbs, "/BIG", 100); len(names) != 54 || *reads != 1 {
+		t.Fatalf("after an outside change: %d names, %d reads", len(names), *reads)
+	}
+
+	// A directory that just changed is not cached: a second change in the
+	// same mtime tick would go unnoticed.
+	writeFiles(t, rootAbs, map[string]string{"HOT/A": "a"})
+	*reads = 0
+	lsNames(t, s, cfg, rootAbs, "/HOT", 1)
+	lsNames(t, s, cfg, rootAbs, "/HOT", 1)
+	if *reads != 2 {
+		t.Errorf("fresh directory read %d times for 2 listings, want 2", *reads)
+	}
+}
+
+func TestLSCacheBounded(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	for i := 0; i < lsCacheMaxDirs+10; i++ {
+		dir := fmt.Sprintf("D%03d", i)
+		writeFiles(t, rootAbs, map[string]string{dir + "/F": "f"})
+		settle(t, filepath.Join(rootAbs, dir), time.Minute)
+		lsNames(t, s, cfg, rootAbs, "/"+dir, 10)
+	}
+	if n := len(s.lsDirs.m); n != lsCacheMaxDirs || s.lsDirs.order.Len() != lsCacheMaxDirs {
+		t.Fatalf("cache holds %d dirs, want %d", n, lsCacheMaxDirs)
+	}
+	// Least recently used went first.
+	if _, ok := s.lsDirs.m[pathLockKey(filepath.Join(rootAbs, "D000"))]; ok {
+		t.Error("oldest listing still cached")
+	}
+	if _, ok := s.lsDirs.m[pathLockKey(filepath.Join(rootAbs, fmt.Sprintf("D%03d", lsCacheMaxDirs+9)))]; !ok {
+		t.Error("newest listing not cached")
+	}
+}
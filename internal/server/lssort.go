@@ -0,0 +1,148 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/diskimage"
+	"wicos64-server/internal/proto"
+)
+
+// lsOrder is the LS sort order requested with FlagLS_SORT_* and
+// FlagLS_REVERSE. Listings start out sorted by name, so the sorts below are
+// stable and equal keys stay in name order: the order is the same for every
+// page of a listing and start_index keeps pointing at the same entries.
+type lsOrder struct {
+	key     byte
+	reverse bool
+}
+
+// lsOrderFromFlags decodes the LS flags; ok is false for an unknown key.
+func lsOrderFromFlags(flags byte) (o lsOrder, ok bool) {
+	o = lsOrder{key: flags & proto.FlagLS_SORT_MASK, reverse: flags&proto.FlagLS_REVERSE != 0}
+	return o, o.key <= proto.FlagLS_SORT_TYPE
+}
+
+// byName reports whether the name sorted listing is already in order.
+func (o lsOrder) byName() bool {
+	return o.key == proto.FlagLS_SORT_NAME && !o.reverse
+}
+
+// lsSortFlagName names the sort key of LS flags for previews ("" = name).
+func lsSortFlagName(flags byte) string {
+	key := int(flags & proto.FlagLS_SORT_MASK)
+	switch {
+	case key == proto.FlagLS_SORT_NAME:
+		return ""
+	case key < len(proto.LSSortNames):
+		return "SORT_" + strings.ToUpper(proto.LSSortNames[key])
+	}
+	return fmt.Sprintf("SORT_%d", key)
+}
+
+// lsSortItem holds the sort keys of one entry.
+type lsSortItem struct {
+	dir   bool
+	size  uint64
+	mtime int64
+	name  string // upper case
+}
+
+// less orders a before b: name A-Z, size and mtime descending, directories
+// before files; reverse flips the key. Ties are left to the stable sort.
+func (o lsOrder) less(a, b lsSortItem) bool {
+	switch o.key {
+	case proto.FlagLS_SORT_SIZE:
+		if a.size != b.size {
+			return (a.size > b.size) != o.reverse
+		}
+	case proto.FlagLS_SORT_MTIME:
+		if a.mtime != b.mtime {
+			return (a.mtime > b.mtime) != o.reverse
+		}
+	case proto.FlagLS_SORT_TYPE:
+		if a.dir != b.dir {
+			return a.dir != o.reverse
+		}
+	default:
+		return (a.name < b.name) != o.reverse && a.name != b.name
+	}
+	return false
+}
+
+// sortImageEntries returns files (as from SortedEntries/SortedDirEntries)
+// in order o. Entries of one image share its mtime; D81 partitions and
+// subdirectories (types 5 and 6) count as directories like in the listing.
+func sortImageEntries(files []*diskimage.FileEntry, o lsOrder) []*diskimage.FileEntry {
+	if o.byName() || len(files) < 2 {
+		return files
+	}
+	out := append([]*diskimage.FileEntry(nil), files...)
+	item := func(fe *diskimage.FileEntry) lsSortItem {
+		dir := fe.Type == 5 || fe.Type == 6
+		size := fe.Size
+		if dir {
+			size = 0
+		}
+		return lsSortItem{dir: dir, size: size, name: strings.ToUpper(fe.Name)}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return o.less(item(out[i]), item(out[j])) })
+	return out
+}
+
+// sortHostEntries returns a copy of the name sorted (and shared, see
+// readDirSorted) entries of dirAbs in order o. Types and sizes are those LS
+// reports: mounted images count as directories with size 0 and whitelisted
+// symlinks as their target (lsEntryInfo).
+func sortHostEntries(cfg config.Config, limits Limits, rootAbs, dirAbs string, entries []os.DirEntry, o lsOrder) []os.DirEntry {
+	if o.byName() || len(entries) < 2 {
+		return entries
+	}
+	items := make(map[os.DirEntry]lsSortItem, len(entries))
+	for _, e := range entries {
+		it := lsSortItem{name: strings.ToUpper(e.Name())}
+		if info, err := lsEntryInfo(cfg, rootAbs, dirAbs, e); err == nil {
+			it.dir = lsShownAsDir(cfg, limits, it.name, info.IsDir())
+			if !it.dir {
+				it.size = uint64(info.Size())
+			}
+			it.mtime = info.ModTime().Unix()
+		}
+		items[e] = it
+	}
+	out := append([]os.DirEntry(nil), entries...)
+	sort.SliceStable(out, func(i, j int) bool { return o.less(items[out[i]], items[out[j]]) })
+	return out
+}
+
+// sortZipNodes returns a copy of the name sorted zip directory in order o.
+func sortZipNodes(kids []*zipNode, o lsOrder) []*zipNode {
+	if o.byName() || len(kids) < 2 {
+		return kids
+	}
+	item := func(k *zipNode) lsSortItem {
+		it := lsSortItem{dir: k.dir, mtime: int64(k.mtime), name: strings.ToUpper(k.name)}
+		if !k.dir {
+			it.size = k.size
+		}
+		return it
+	}
+	out := append([]*zipNode(nil), kids...)
+	sort.SliceStable(out, func(i, j int) bool { return o.less(item(out[i]), item(out[j])) })
+	return out
+}
+
+// lsShownAsDir reports whether LS lists a host entry as a directory: real
+// directories and, with mounting enabled, disk images and zip archives.
+func lsShownAsDir(cfg config.Config, limits Limits, upperName string, isDir bool) bool {
+	if isDir {
+		return true
+	}
+	if limits.DiskImagesEnabled && isDiskImageName(upperName) {
+		return true
+	}
+	return cfg.ZipMountEnabled && strings.HasSuffix(upperName, ".ZIP")
+}
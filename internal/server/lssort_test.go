@@ -0,0 +1,103 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"wicos64-server/internal/proto"
+)
+
+func TestLSSortOrders(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	limits := Limits{DiskImagesEnabled: true, DiskImagesWriteEnabled: true}
+	if err := os.MkdirAll(filepath.Join(rootAbs, "D", "SUBDIR"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	mkImage(t, s, cfg, limits, rootAbs, "/D/DISK.D64", proto.ImageKindD64)
+	writeFiles(t, rootAbs, map[string]string{
+		"D/a.prg": strings.Repeat("a", 10),
+		"D/B.PRG": strings.Repeat("b", 300),
+		"D/C.SEQ": strings.Repeat("c", 300),
+		"D/E.TXT": strings.Repeat("e", 50),
+	})
+	now := time.Now()
+	for name, age := range map[string]int{"SUBDIR": 5, "a.prg": 10, "C.SEQ": 50, "B.PRG": 100, "E.TXT": 200, "DISK.D64": 300} {
+		mt := now.Add(-time.Duration(age) * time.Minute)
+		if err := os.Chtimes(filepath.Join(rootAbs, "D", name), mt, mt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		flags byte
+		want  string
+	}{
+		{"name", proto.FlagLS_SORT_NAME, "A.PRG B.PRG C.SEQ DISK.D64 E.TXT SUBDIR"},
+		{"name reversed", proto.FlagLS_SORT_NAME | proto.FlagLS_REVERSE, "SUBDIR E.TXT DISK.D64 C.SEQ B.PRG A.PRG"},
+		// Images and directories have size 0; ties stay in name order.
+		{"size", proto.FlagLS_SORT_SIZE, "B.PRG C.SEQ E.TXT A.PRG DISK.D64 SUBDIR"},
+		{"size reversed", proto.FlagLS_SORT_SIZE | proto.FlagLS_REVERSE, "DISK.D64 SUBDIR A.PRG E.TXT B.PRG C.SEQ"},
+		{"mtime", proto.FlagLS_SORT_MTIME, "SUBDIR A.PRG C.SEQ B.PRG E.TXT DISK.D64"},
+		{"mtime reversed", proto.FlagLS_SORT_MTIME | proto.FlagLS_REVERSE, "DISK.D64 E.TXT B.PRG C.SEQ A.PRG SUBDIR"},
+		// Mounted images count as directories.
+		{"type", proto.FlagLS_SORT_TYPE, "DISK.D64 SUBDIR A.PRG B.PRG C.SEQ E.TXT"},
+		{"type reversed", proto.FlagLS_SORT_TYPE | proto.FlagLS_REVERSE, "A.PRG B.PRG C.SEQ E.TXT DISK.D64 SUBDIR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			all := lsPages(t, s, cfg, limits, rootAbs, "/D", tt.flags, 100)
+			if got := strings.Join(all, " "); got != tt.want {
+				t.Fatalf("LS = %q, want %q", got, tt.want)
+			}
+			// Pages of any size add up to the same order.
+			for _, max := range []uint16{1, 2, 4} {
+				if got := strings.Join(lsPages(t, s, cfg, limits, rootAbs, "/D", tt.flags, max), " "); got != tt.want {
+					t.Fatalf("LS in pages of %d = %q, want %q", max, got, tt.want)
+				}
+			}
+		})
+	}
+
+	e := proto.NewEncoder(16)
+	_ = e.WriteString("/D")
+	e.WriteU16(0)
+	e.WriteU16(0)
+	if st, _, _ := s.dispatch(cfg, limits, proto.OpLS, 4, e.Bytes(), rootAbs); st != proto.StatusBadRequest {
+		t.Fatalf("LS with reserved sort key 4 = %s, want BAD_REQUEST", statusName(st))
+	}
+}
+
+func TestLSSortInsideImage(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	limits := Limits{DiskImagesEnabled: true, DiskImagesWriteEnabled: true}
+	mkImage(t, s, cfg, limits, rootAbs, "/DISK.D64", proto.ImageKindD64)
+	for name, size := range map[string]int{"X": 600, "Y": 10, "Z": 300} {
+		if st, _, msg := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, proto.FlagWR_CREATE, writeRangePayload(t, "/DISK.D64/"+name, 0, make([]byte, size)), rootAbs); st != proto.StatusOK {
+			t.Fatalf("WRITE_RANGE %s = %s (%s)", name, statusName(st), msg)
+		}
+	}
+	byName := lsPages(t, s, cfg, limits, rootAbs, "/DISK.D64", 0, 100)
+	if len(byName) != 3 {
+		t.Fatalf("LS of the image = %q", byName)
+	}
+	x, y, z := byName[0], byName[1], byName[2]
+	for _, tc := range []struct {
+		flags byte
+		want  []string
+	}{
+		{proto.FlagLS_SORT_NAME | proto.FlagLS_REVERSE, []string{z, y, x}},
+		{proto.FlagLS_SORT_SIZE, []string{x, z, y}},
+		{proto.FlagLS_SORT_SIZE | proto.FlagLS_REVERSE, []string{y, z, x}},
+		{proto.FlagLS_SORT_TYPE, []string{x, y, z}},
+	} {
+		for _, max := range []uint16{1, 100} {
+			if got := lsPages(t, s, cfg, limits, rootAbs, "/DISK.D64", tc.flags, max); strings.Join(got, " ") != strings.Join(tc.want, " ") {
+				t.Errorf("LS flags %02X in pages of %d = %q, want %q", tc.flags, max, got, tc.want)
+			}
+		}
+	}
+}
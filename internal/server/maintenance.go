@@ -47,15 +47,18 @@ func (s *Server) runTrashCleanupOnce(cfg config.Config) []TrashCleanupReport {
 	if len(roots) == 0 {
 		return nil
 	}
+	run := maintRun{AtUnix: time.Now().Unix(), Roots: len(roots)}
 	out := make([]TrashCleanupReport, 0, len(roots))
 	for _, rootAbs := range roots {
 		rep := cleanupTrashForRoot(cfg, rootAbs)
 		out = append(out, rep)
+		run.add(rep.DeletedFiles, rep.DeletedDirs, rep.FreedBytes, rep.DurationMs, rep.Error)
 		if rep.DeletedFiles > 0 || rep.DeletedDirs > 0 {
 			// Usage has changed; safest is to invalidate.
 			s.invalidateRootUsage(rootAbs)
 		}
 	}
+	s.maint.record(maintTrashCleanup, run)
 	return out
 }
 
@@ -153,6 +156,14 @@ func cleanupTrashForRoot(cfg config.Config, rootAbs string) TrashCleanupReport {
 	for _, e := range entries {
 		name := e.Name()
 		child := filepath.Join(trashAbs, name)
+		if id, ok := strings.CutSuffix(name, trashPathExt); ok && !e.IsDir() {
+			// Bookkeeping for the ID directory; goes with it (below) and
+			// is not counted. Drop it once the directory is gone.
+			if _, err := os.Lstat(filepath.Join(trashAbs, id)); errors.Is(err, fs.ErrNotExist) {
+				_ = os.Remove(child)
+			}
+			continue
+		}
 		info, err := e.Info()
 		if err != nil {
 			rep.Error = err.Error()
@@ -186,6 +197,7 @@ func cleanupTrashForRoot(cfg config.Config, rootAbs string) TrashCleanupReport {
 			rep.DurationMs = time.Since(start).Milliseconds()
 			return rep
 		}
+		_ = os.Remove(child + trashPathExt)
 		rep.DeletedFiles += files
 		rep.DeletedDirs += dirs
 		rep.FreedBytes += bytes
@@ -308,15 +320,18 @@ func (s *Server) runTmpCleanupOnce(cfg config.Config) []CleanupReport {
 	if len(roots) == 0 {
 		return nil
 	}
+	run := maintRun{AtUnix: time.Now().Unix(), Roots: len(roots)}
 	out := make([]CleanupReport, 0, len(roots))
 	for _, rootAbs := range roots {
 		rep := cleanupTmpForRoot(cfg, rootAbs)
 		out = append(out, rep)
+		run.add(rep.DeletedFiles, rep.DeletedDirs, rep.FreedBytes, rep.DurationMs, rep.Error)
 		if rep.DeletedFiles > 0 || rep.DeletedDirs > 0 {
 			// Usage has changed; safest is to invalidate.
 			s.invalidateRootUsage(rootAbs)
 		}
 	}
+	s.maint.record(maintTmpCleanup, run)
 	return out
 }
 
@@ -486,51 +501,74 @@ func selfTestForRoot(cfg config.Config, rootAbs string) SelfTestReport {
 }
 
 func (s *Server) startMaintenanceLoop() {
+	// Small initial delay so the server is fully up.
+	const initialDelay = 2 * time.Second
+	start := time.Now().Add(initialDelay)
+	s.maint.setNext(maintTmpCleanup, start)
+	s.maint.setNext(maintTrashCleanup, start)
+	s.maint.setNext(maintStagedCleanup, start)
+
 	// TMP cleanup loop
 	go func() {
-		// Small initial delay so the server is fully up.
-		time.Sleep(2 * time.Second)
+		if !s.sleep(initialDelay) {
+			return
+		}
 		for {
 			cfg := s.getCfg()
 			if !cfg.TmpCleanupEnabled {
 				// Sleep a bit, then re-check config.
-				time.Sleep(10 * time.Second)
+				s.maint.setNext(maintTmpCleanup, time.Time{})
+				if !s.sleep(10 * time.Second) {
+					return
+				}
 				continue
 			}
-			interval := time.Duration(cfg.TmpCleanupIntervalSec) * time.Second
-			if interval <= 0 {
-				interval = 15 * time.Minute
-			}
-			if interval < 10*time.Second {
-				interval = 10 * time.Second
-			}
+			interval := tmpCleanupInterval(cfg)
 
 			_ = s.runTmpCleanupOnce(cfg)
-			time.Sleep(interval)
+			s.maint.setNext(maintTmpCleanup, time.Now().Add(interval))
+			if !s.sleep(interval) {
+				return
+			}
 		}
 	}()
 
 	// Trash cleanup loop
 	go func() {
-		// Small initial delay so the server is fully up.
-		time.Sleep(2 * time.Second)
+		if !s.sleep(initialDelay) {
+			return
+		}
 		for {
 			cfg := s.getCfg()
 			if !cfg.TrashEnabled || !cfg.TrashCleanupEnabled {
 				// Sleep a bit, then re-check config.
-				time.Sleep(10 * time.Second)
+				s.maint.setNext(maintTrashCleanup, time.Time{})
+				if !s.sleep(10 * time.Second) {
+					return
+				}
 				continue
 			}
-			interval := time.Duration(cfg.TrashCleanupIntervalSec) * time.Second
-			if interval <= 0 {
-				interval = 6 * time.Hour
-			}
-			if interval < 60*time.Second {
-				interval = 60 * time.Second
-			}
+			interval := trashCleanupInterval(cfg)
 
 			_ = s.runTrashCleanupOnce(cfg)
-			time.Sleep(interval)
+			s.maint.setNext(maintTrashCleanup, time.Now().Add(interval))
+			if !s.sleep(interval) {
+				return
+			}
+		}
+	}()
+
+	// Abandoned staged writes (WRITE_RANGE FlagWR_STAGED)
+	go func() {
+		if !s.sleep(initialDelay) {
+			return
+		}
+		for {
+			_ = s.runStagedCleanupOnce(s.getCfg())
+			s.maint.setNext(maintStagedCleanup, time.Now().Add(stagedCleanupInterval))
+			if !s.sleep(stagedCleanupInterval) {
+				return
+			}
 		}
 	}()
 }
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wicos64-server/internal/config"
+)
+
+func TestAdminCleanupTrash(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, func(c *config.Config) {
+		c.TrashEnabled = true
+		c.TrashCleanupEnabled = true
+		c.TrashCleanupMaxAgeSec = 3600
+	})
+	trash := trashDirAbs(cfg, rootAbs)
+	old := time.Now().Add(-2 * time.Hour)
+	fresh := time.Now().UTC().Format("20060102T150405Z") + "-00000001"
+	writeFiles(t, trash, map[string]string{
+		// Aged by the time in the ID.
+		"20200101T000000Z-00000001/USR/A.PRG": "0123456789",
+		"20200101T000000Z-00000001.path":      "/USR/A.PRG",
+		"20200101T000000Z-00000002/DIR/X":     "xxxxx",
+		"20200101T000000Z-00000002/DIR/SUB/Y": "yyyyy",
+		"20200101T000000Z-00000002.path":      "/DIR",
+		fresh + "/NEW.PRG":                    "keep me",
+		fresh + ".path":                       "/NEW.PRG",
+		// No ID time: aged by mtime.
+		"MANUAL/OLD": "old",
+		"RECENT/NEW": "new",
+		// Left behind by an entry removed by hand.
+		"20200101T000000Z-00000003.path": "/GONE",
+	})
+	if err := os.Chtimes(filepath.Join(trash, "MANUAL"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	s.handleAdminCleanupTrash(w, httptest.NewRequest("POST", "/", nil))
+	var resp struct {
+		OK      bool `json:"ok"`
+		Payload struct {
+			Summary struct {
+				Roots        int    `json:"roots"`
+				DeletedFiles int    `json:"deleted_files"`
+				FreedBytes   uint64 `json:"freed_bytes"`
+			} `json:"summary"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK || !resp.OK {
+		t.Fatalf("cleanup = %d %s", w.Code, w.Body.String())
+	}
+	// The .path files go with their entries but are not counted.
+	sum := resp.Payload.Summary
+	if sum.Roots != 1 || sum.DeletedFiles != 4 || sum.FreedBytes != 10+5+5+3 {
+		t.Errorf("summary = %+v", sum)
+	}
+
+	var left []string
+	_ = filepath.WalkDir(trash, func(p string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(trash, p)
+			left = append(left, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	want := map[string]bool{fresh + "/NEW.PRG": true, fresh + ".path": true, "RECENT/NEW": true}
+	if len(left) != len(want) {
+		t.Fatalf("left in the trash: %v", left)
+	}
+	for _, p := range left {
+		if !want[p] {
+			t.Errorf("%s survived the cleanup", p)
+		}
+	}
+
+	// The run shows up in the maintenance status with the next scheduled run.
+	w = httptest.NewRecorder()
+	s.handleAdminMaintenance(w, httptest.NewRequest("GET", "/", nil))
+	var status adminMaintResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	var task *adminMaintTask
+	for i := range status.Tasks {
+		if status.Tasks[i].Name == maintTrashCleanup {
+			task = &status.Tasks[i]
+		}
+	}
+	if task == nil || !task.Enabled || task.Runs != 1 || task.LastRun == nil || task.LastRun.DeletedFiles != 4 {
+		t.Fatalf("trash task = %+v", task)
+	}
+	if task.NextRunUnix < time.Now().Unix() {
+		t.Errorf("next_run_unix = %d, want a future run", task.NextRunUnix)
+	}
+
+	// Disabled cleanup is refused, not run.
+	cfg.TrashCleanupEnabled = false
+	s.setCfg(cfg)
+	w = httptest.NewRecorder()
+	s.handleAdminCleanupTrash(w, httptest.NewRequest("POST", "/", nil))
+	if w.Code != http.StatusConflict {
+		t.Errorf("cleanup with trash_cleanup_enabled off = %d, want 409", w.Code)
+	}
+	if _, n, _ := s.maint.get(maintTrashCleanup); n != 1 {
+		t.Errorf("trash cleanup ran %d times, want 1", n)
+	}
+}
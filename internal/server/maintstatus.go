@@ -0,0 +1,171 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/version"
+)
+
+// Maintenance task names (GET /admin/api/maintenance).
+const (
+	maintTmpCleanup    = "tmp_cleanup"
+	maintTrashCleanup  = "trash_cleanup"
+	maintStagedCleanup = "staged_cleanup"
+)
+
+// stagedCleanupInterval is how often abandoned staged writes are looked for.
+const stagedCleanupInterval = time.Minute
+
+// maintRun is the outcome of the last run of a maintenance task, summed over
+// all roots.
+type maintRun struct {
+	AtUnix       int64  `json:"at_unix"`
+	DurationMs   int64  `json:"duration_ms"`
+	Roots        int    `json:"roots"`
+	DeletedFiles int    `json:"deleted_files"`
+	DeletedDirs  int    `json:"deleted_dirs"`
+	FreedBytes   uint64 `json:"freed_bytes"`
+	Errors       int    `json:"errors"`
+	LastError    string `json:"last_error,omitempty"`
+}
+
+func (r *maintRun) add(files, dirs int, freed uint64, durMs int64, errMsg string) {
+	r.DeletedFiles += files
+	r.DeletedDirs += dirs
+	r.FreedBytes += freed
+	r.DurationMs += durMs
+	if errMsg != "" {
+		r.Errors++
+		r.LastError = errMsg
+	}
+}
+
+// maintStatus remembers the last run of each maintenance task (scheduled or
+// started from the admin UI) and when the loop will run it next.
+type maintStatus struct {
+	mu   sync.Mutex
+	last map[string]maintRun
+	runs map[string]uint64
+	next map[string]int64
+}
+
+func (m *maintStatus) record(task string, run maintRun) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.last == nil {
+		m.last = map[string]maintRun{}
+		m.runs = map[string]uint64{}
+	}
+	m.last[task] = run
+	m.runs[task]++
+}
+
+func (m *maintStatus) get(task string) (maintRun, uint64, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	run, ok := m.last[task]
+	return run, m.runs[task], ok
+}
+
+// setNext records when the maintenance loop wakes up for task next. A zero
+// time means the task is not scheduled (disabled).
+func (m *maintStatus) setNext(task string, at time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.next == nil {
+		m.next = map[string]int64{}
+	}
+	if at.IsZero() {
+		delete(m.next, task)
+		return
+	}
+	m.next[task] = at.Unix()
+}
+
+func (m *maintStatus) getNext(task string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.next[task]
+}
+
+// tmpCleanupInterval returns the effective tmp cleanup interval.
+func tmpCleanupInterval(cfg config.Config) time.Duration {
+	interval := time.Duration(cfg.TmpCleanupIntervalSec) * time.Second
+	if interval <= 0 {
+		interval = 15 * time.Minute
+	}
+	if interval < 10*time.Second {
+		interval = 10 * time.Second
+	}
+	return interval
+}
+
+// trashCleanupInterval returns the effective trash cleanup interval.
+func trashCleanupInterval(cfg config.Config) time.Duration {
+	interval := time.Duration(cfg.TrashCleanupIntervalSec) * time.Second
+	if interval <= 0 {
+		interval = 6 * time.Hour
+	}
+	if interval < 60*time.Second {
+		interval = 60 * time.Second
+	}
+	return interval
+}
+
+type adminMaintTask struct {
+	Name        string    `json:"name"`
+	Enabled     bool      `json:"enabled"`
+	IntervalSec int64     `json:"interval_sec"`
+	MaxAgeSec   int64     `json:"max_age_sec"`
+	Runs        uint64    `json:"runs"`
+	LastRun     *maintRun `json:"last_run,omitempty"`
+	// NextRunUnix is the next scheduled run (0: not scheduled).
+	NextRunUnix int64 `json:"next_run_unix,omitempty"`
+}
+
+type adminMaintResponse struct {
+	OK     bool             `json:"ok"`
+	Build  string           `json:"build"`
+	TSUnix int64            `json:"ts_unix"`
+	Tasks  []adminMaintTask `json:"tasks"`
+}
+
+func (s *Server) handleAdminMaintenance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := s.getCfg()
+	task := func(name string, enabled bool, interval time.Duration, maxAgeSec int64) adminMaintTask {
+		t := adminMaintTask{Name: name, Enabled: enabled, IntervalSec: int64(interval / time.Second), MaxAgeSec: maxAgeSec}
+		if enabled {
+			t.NextRunUnix = s.maint.getNext(name)
+		}
+		if run, n, ok := s.maint.get(name); ok {
+			t.Runs = n
+			t.LastRun = &run
+		}
+		return t
+	}
+	tmpAge := int64(cfg.TmpCleanupMaxAgeSec)
+	if tmpAge <= 0 {
+		tmpAge = 24 * 3600
+	}
+	trashAge := int64(cfg.TrashCleanupMaxAgeSec)
+	if trashAge <= 0 {
+		trashAge = 7 * 24 * 3600
+	}
+	writeJSON(w, http.StatusOK, adminMaintResponse{
+		OK:     true,
+		Build:  version.Get().String(),
+		TSUnix: time.Now().Unix(),
+		Tasks: []adminMaintTask{
+			task(maintTmpCleanup, cfg.TmpCleanupEnabled, tmpCleanupInterval(cfg), tmpAge),
+			task(maintTrashCleanup, cfg.TrashEnabled && cfg.TrashCleanupEnabled, trashCleanupInterval(cfg), trashAge),
+			task(maintStagedCleanup, true, stagedCleanupInterval, int64(cfg.StagedWriteTimeoutSec)),
+		},
+	})
+}
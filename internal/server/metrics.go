@@ -0,0 +1,85 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"wicos64-server/internal/diskimage"
+	"wicos64-server/internal/version"
+)
+
+// handleMetrics serves GET /metrics (metrics_enabled) in the Prometheus text
+// exposition format. The values come from the same counters as the admin
+// stats and are reset together with them.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	cfg := s.cfgSnapshot()
+	if !cfg.MetricsEnabled {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !adminAccessAllowed(cfg, w, r) {
+		return
+	}
+
+	m := s.stats.metrics()
+	var b bytes.Buffer
+
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	metric("wicos64_build_info", "gauge", "Build of the running server.")
+	fmt.Fprintf(&b, "wicos64_build_info{version=\"%s\"} 1\n", promLabel(version.Get().String()))
+	metric("wicos64_uptime_seconds", "gauge", "Seconds since start or the last stats reset.")
+	fmt.Fprintf(&b, "wicos64_uptime_seconds %d\n", int64(m.uptime.Seconds()))
+
+	metric("wicos64_requests_total", "counter", "RPC requests handled.")
+	fmt.Fprintf(&b, "wicos64_requests_total %d\n", m.totalReq)
+	metric("wicos64_errors_total", "counter", "RPC requests answered with a non-OK status.")
+	fmt.Fprintf(&b, "wicos64_errors_total %d\n", m.totalErr)
+	metric("wicos64_request_bytes_total", "counter", "Bytes received in RPC requests.")
+	fmt.Fprintf(&b, "wicos64_request_bytes_total %d\n", m.bytesIn)
+	metric("wicos64_response_bytes_total", "counter", "Bytes sent in RPC responses.")
+	fmt.Fprintf(&b, "wicos64_response_bytes_total %d\n", m.bytesOut)
+
+	metric("wicos64_image_parses_in_flight", "gauge", "Disk images being parsed right now (max_concurrent_image_parses).")
+	fmt.Fprintf(&b, "wicos64_image_parses_in_flight %d\n", diskimage.ParsesInFlight())
+
+	metric("wicos64_op_requests_total", "counter", "RPC requests by opcode.")
+	for op, n := range m.byOp {
+		if n == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "wicos64_op_requests_total{op=\"%s\"} %d\n", promLabel(opName(byte(op))), n)
+	}
+
+	metric("wicos64_request_duration_seconds", "histogram", "RPC request handling time.")
+	var cum uint64
+	for i, le := range statsDurBucketsMs {
+		cum += m.durBuckets[i]
+		fmt.Fprintf(&b, "wicos64_request_duration_seconds_bucket{le=\"%s\"} %d\n", strconv.FormatFloat(float64(le)/1000, 'g', -1, 64), cum)
+	}
+	cum += m.durBuckets[len(statsDurBucketsMs)]
+	fmt.Fprintf(&b, "wicos64_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", cum)
+	fmt.Fprintf(&b, "wicos64_request_duration_seconds_sum %s\n", strconv.FormatFloat(float64(m.totalDurMs)/1000, 'g', -1, 64))
+	fmt.Fprintf(&b, "wicos64_request_duration_seconds_count %d\n", cum)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(b.Bytes())
+	}
+}
+
+// promLabel escapes a Prometheus label value.
+func promLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
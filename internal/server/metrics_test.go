@@ -0,0 +1,69 @@
+package server
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+func TestMetrics(t *testing.T) {
+	s, _, _ := newTestServer(t, func(c *config.Config) { c.MetricsEnabled = true })
+
+	reqBytes := 0
+	for _, p := range []string{"/", "/", "/MISSING"} {
+		rpcStatus(t, s, "", proto.OpSTAT, 0, pathPayload(p))
+		reqBytes += proto.HeaderSize + len(pathPayload(p))
+	}
+	rpcStatus(t, s, "", proto.OpSTATFS, 0, nil)
+	reqBytes += proto.HeaderSize
+
+	get := func(remote string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		s.handleMetrics(w, r)
+		return w
+	}
+	w := get("127.0.0.1:5000")
+	if w.Code != 200 || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("GET /metrics = %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	body := w.Body.String()
+	for _, line := range []string{
+		"# HELP wicos64_requests_total RPC requests handled.",
+		"# TYPE wicos64_requests_total counter",
+		"wicos64_requests_total 4",
+		"wicos64_errors_total 1",
+		fmt.Sprintf("wicos64_request_bytes_total %d", reqBytes),
+		`wicos64_op_requests_total{op="STAT"} 3`,
+		`wicos64_op_requests_total{op="STATFS"} 1`,
+		"# TYPE wicos64_request_duration_seconds histogram",
+		`wicos64_request_duration_seconds_bucket{le="+Inf"} 4`,
+		"wicos64_request_duration_seconds_count 4",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics lack %q:\n%s", line, body)
+		}
+	}
+	if strings.Contains(body, "wicos64_response_bytes_total 0\n") {
+		t.Error("no response bytes counted")
+	}
+	if strings.Contains(body, `op="LS"`) {
+		t.Error("unused opcodes are listed")
+	}
+
+	// Same access rules as the admin UI.
+	if w := get("192.0.2.1:5000"); w.Code != 403 {
+		t.Errorf("remote GET /metrics = %d, want 403", w.Code)
+	}
+	cfg := s.cfgSnapshot()
+	cfg.MetricsEnabled = false
+	s.setCfg(cfg)
+	if w := get("127.0.0.1:5000"); w.Code != 404 {
+		t.Errorf("GET /metrics with metrics_enabled=false = %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+type batchSubReq struct {
+	op      byte
+	flags   byte
+	payload []byte
+}
+
+// opBATCH runs several ops in one round trip. Every sub-op goes through the
+// regular dispatch (read-only, quota and file-count checks apply per sub-op).
+// If any sub-op writes, the whole root is write-locked once for the batch, so
+// the sequence is not interleaved with other clients' writes.
+//
+// Flags: FlagB_CONTINUE keeps going after a failed sub-op (default: stop at
+// the first non-OK status).
+// Payload: count u8, then count x (len u16, op u8, flags u8, payload[len-2]).
+// Response: count u8 (sub-ops actually run), then count x (len u16, status
+// u8, payload[len-1]). Error payloads follow enable_errmsg like top-level
+// responses.
+//
+// If a sub-response does not fit into max_payload it is replaced by a bare
+// TOO_LARGE status and the batch stops there; the sub-op itself has run.
+//
+// BATCH and ECHO cannot be sub-ops: a batch of ECHOs would add up their
+// delays (and hold the root write lock meanwhile).
+func (s *Server) opBATCH(cfg config.Config, limits Limits, flags byte, payload []byte, rootAbs string) (byte, []byte, string) {
+	d := proto.NewDecoder(payload)
+	n, err := d.ReadU8()
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	if n == 0 {
+		return proto.StatusBadRequest, nil, "empty BATCH"
+	}
+	subs := make([]batchSubReq, 0, n)
+	hasWrite := false
+	for i := 0; i < int(n); i++ {
+		ln, err := d.ReadU16()
+		if err != nil {
+			return proto.StatusBadRequest, nil, err.Error()
+		}
+		if ln < 2 {
+			return proto.StatusBadRequest, nil, "BATCH sub-request too short"
+		}
+		b, err := d.ReadBytes(int(ln))
+		if err != nil {
+			return proto.StatusBadRequest, nil, err.Error()
+		}
+		if b[0] == proto.OpBATCH {
+			return proto.StatusBadRequest, nil, "nested BATCH not allowed"
+		}
+		if b[0] == proto.OpECHO {
+			return proto.StatusBadRequest, nil, "ECHO not allowed in BATCH"
+		}
+		subs = append(subs, batchSubReq{op: b[0], flags: b[1], payload: b[2:]})
+		if isWriteOp(b[0]) {
+			hasWrite = true
+		}
+	}
+	if d.Remaining() != 0 {
+		return proto.StatusBadRequest, nil, "extra bytes in BATCH"
+	}
+
+	if hasWrite {
+		// Quota lock first, like dispatch does for single ops.
+		releaseQuota, l := s.lockQuota(limits, rootAbs)
+		defer releaseQuota()
+		limits = l
+		release, ok := s.lockPaths(limits, rootAbs, false)
+		if !ok {
+			return proto.StatusBusy, nil, "busy"
+		}
+		defer release()
+		limits.writeLocked = true
+	}
+
+	keepGoing := flags&proto.FlagB_CONTINUE != 0
+	maxPayload := int(cfg.MaxPayload)
+	buf := []byte{0}
+	for _, sub := range subs {
+		// Room for at least a bare status entry (len u16 + status u8).
+		if len(buf)+3 > maxPayload {
+			break
+		}
+		st, resp, msg := s.dispatch(cfg, limits, sub.op, sub.flags, sub.payload, rootAbs)
+		resp = responsePayload(cfg, st, resp, msg)
+		if len(buf)+3+len(resp) > maxPayload {
+			buf = appendBatchResp(buf, proto.StatusTooLarge, nil)
+			buf[0]++
+			break
+		}
+		buf = appendBatchResp(buf, st, resp)
+		buf[0]++
+		if st != proto.StatusOK && !keepGoing {
+			break
+		}
+	}
+	return proto.StatusOK, buf, ""
+}
+
+func appendBatchResp(buf []byte, status byte, payload []byte) []byte {
+	ln := 1 + len(payload)
+	buf = append(buf, byte(ln), byte(ln>>8), status)
+	return append(buf, payload...)
+}
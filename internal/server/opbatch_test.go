@@ -0,0 +1,165 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+// batchPayload encodes a BATCH request from (op, flags, payload) sub-requests.
+func batchPayload(subs ...batchSubReq) []byte {
+	e := proto.NewEncoder(64)
+	e.WriteU8(byte(len(subs)))
+	for _, sub := range subs {
+		e.WriteU16(uint16(2 + len(sub.payload)))
+		e.WriteU8(sub.op)
+		e.WriteU8(sub.flags)
+		e.WriteBytes(sub.payload)
+	}
+	return e.Bytes()
+}
+
+func TestBATCHRefusesNestedBATCHAndECHO(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, func(c *config.Config) { c.EnableEcho = true })
+	for _, op := range []byte{proto.OpBATCH, proto.OpECHO} {
+		payload := batchPayload(
+			batchSubReq{op: proto.OpPING},
+			batchSubReq{op: op, payload: []byte{0xD0, 0x07}},
+		)
+		status, _, _ := s.dispatch(cfg, Limits{}, proto.OpBATCH, 0, payload, rootAbs)
+		if status != proto.StatusBadRequest {
+			t.Errorf("BATCH with %s: status = %s, want BAD_REQUEST", opName(op), statusName(status))
+		}
+	}
+}
+
+type batchResult struct {
+	status  byte
+	payload []byte
+}
+
+// parseBatchResp splits a BATCH response into its sub-results.
+func parseBatchResp(t *testing.T, resp []byte) []batchResult {
+	t.Helper()
+	d := proto.NewDecoder(resp)
+	n, err := d.ReadU8()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([]batchResult, 0, n)
+	for i := 0; i < int(n); i++ {
+		ln, err := d.ReadU16()
+		if err != nil || ln < 1 {
+			t.Fatalf("entry %d: len %d, %v", i, ln, err)
+		}
+		b, err := d.ReadBytes(int(ln))
+		if err != nil {
+			t.Fatalf("entry %d: %v", i, err)
+		}
+		out = append(out, batchResult{b[0], b[1:]})
+	}
+	if d.Remaining() != 0 {
+		t.Fatalf("%d trailing bytes", d.Remaining())
+	}
+	return out
+}
+
+func TestBATCHMixedReads(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	if err := os.WriteFile(filepath.Join(rootAbs, "A"), []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(rootAbs, "D"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	ls := encode(func(e *proto.Encoder) { _ = e.WriteString("/"); e.WriteU16(0); e.WriteU16(10) })
+	read := func(p string, off uint32, n uint16) []byte {
+		return encode(func(e *proto.Encoder) { _ = e.WriteString(p); e.WriteU32(off); e.WriteU16(n) })
+	}
+	subs := []batchSubReq{
+		{op: proto.OpLS, payload: ls},
+		{op: proto.OpSTAT, payload: pathPayload("/A")},
+		{op: proto.OpSTAT, payload: pathPayload("/MISSING")},
+		{op: proto.OpREAD_RANGE, payload: read("/A", 2, 3)},
+		{op: proto.OpSTAT, payload: pathPayload("/D")},
+	}
+
+	tests := []struct {
+		name  string
+		flags byte
+		want  []byte
+	}{
+		{"stop at the first error", 0, []byte{proto.StatusOK, proto.StatusOK, proto.StatusNotFound}},
+		{"continue", proto.FlagB_CONTINUE, []byte{proto.StatusOK, proto.StatusOK, proto.StatusNotFound, proto.StatusOK, proto.StatusOK}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, resp, msg := s.dispatch(cfg, Limits{}, proto.OpBATCH, tt.flags, batchPayload(subs...), rootAbs)
+			if st != proto.StatusOK {
+				t.Fatalf("BATCH = %s (%s)", statusName(st), msg)
+			}
+			got := parseBatchResp(t, resp)
+			if len(got) != len(tt.want) {
+				t.Fatalf("%d results, want %d", len(got), len(tt.want))
+			}
+			for i, r := range got {
+				if r.status != tt.want[i] {
+					t.Fatalf("sub %d (%s) = %s, want %s", i, opName(subs[i].op), statusName(r.status), statusName(tt.want[i]))
+				}
+				// Each OK entry matches the op run on its own.
+				if r.status == proto.StatusOK && subs[i].op != proto.OpLS {
+					_, single, _ := s.dispatch(cfg, Limits{}, subs[i].op, subs[i].flags, subs[i].payload, rootAbs)
+					if !bytes.Equal(r.payload, single) {
+						t.Fatalf("sub %d payload % X, want % X", i, r.payload, single)
+					}
+				}
+			}
+			if count := binary.LittleEndian.Uint16(got[0].payload); count != 2 {
+				t.Fatalf("LS listed %d entries, want 2", count)
+			}
+			if len(got) > 3 && string(got[3].payload) != "234" {
+				t.Fatalf("READ_RANGE = %q, want %q", got[3].payload, "234")
+			}
+		})
+	}
+}
+
+func TestBATCHTruncatesAtMaxPayload(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	if err := os.WriteFile(filepath.Join(rootAbs, "A"), bytes.Repeat([]byte{'x'}, 200), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.MaxPayload = 64
+	read := encode(func(e *proto.Encoder) { _ = e.WriteString("/A"); e.WriteU32(0); e.WriteU16(40) })
+	payload := batchPayload(
+		batchSubReq{op: proto.OpSTAT, payload: pathPayload("/A")},
+		batchSubReq{op: proto.OpREAD_RANGE, payload: read},
+		batchSubReq{op: proto.OpREAD_RANGE, payload: read},
+		batchSubReq{op: proto.OpSTAT, payload: pathPayload("/A")},
+	)
+	st, resp, msg := s.dispatch(cfg, Limits{}, proto.OpBATCH, proto.FlagB_CONTINUE, payload, rootAbs)
+	if st != proto.StatusOK {
+		t.Fatalf("BATCH = %s (%s)", statusName(st), msg)
+	}
+	if len(resp) > int(cfg.MaxPayload) {
+		t.Fatalf("response is %d bytes, max_payload %d", len(resp), cfg.MaxPayload)
+	}
+	got := parseBatchResp(t, resp)
+	want := []byte{proto.StatusOK, proto.StatusOK, proto.StatusTooLarge}
+	if len(got) != len(want) {
+		t.Fatalf("%d results, want %d", len(got), len(want))
+	}
+	for i, r := range got {
+		if r.status != want[i] {
+			t.Fatalf("sub %d = %s, want %s", i, statusName(r.status), statusName(want[i]))
+		}
+	}
+	if len(got[1].payload) != 40 || len(got[2].payload) != 0 {
+		t.Fatalf("payload lengths %d/%d, want 40/0", len(got[1].payload), len(got[2].payload))
+	}
+}
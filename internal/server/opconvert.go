@@ -0,0 +1,146 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/diskimage"
+	"wicos64-server/internal/fsops"
+	"wicos64-server/internal/proto"
+)
+
+// cpConvertImage handles CP with FlagCP_CONVERT: all files of the source
+// image (D64/D71/D81) are copied into a new image whose type follows the
+// extension of dst (see diskimage.ConvertImage).
+//
+// The new image is built next to dst and renamed into place at the end, so
+// an existing dst (overwrite flag) is only replaced once the conversion has
+// worked.
+func (s *Server) cpConvertImage(cfg config.Config, limits Limits, rootAbs, srcNorm, dstNorm string, overwrite bool) (byte, string) {
+	if !limits.DiskImagesEnabled {
+		return proto.StatusNotSupported, "disk images are disabled"
+	}
+	if !limits.DiskImagesWriteEnabled {
+		return proto.StatusAccessDenied, "disk image writes are disabled"
+	}
+	if strings.ContainsAny(srcNorm, "*?") {
+		return proto.StatusBadRequest, "wildcards are not supported for conversion"
+	}
+
+	var dstKind string
+	switch strings.ToLower(path.Ext(dstNorm)) {
+	case ".d64":
+		dstKind = diskimage.KindD64
+	case ".d71":
+		dstKind = diskimage.KindD71
+	case ".d81":
+		dstKind = diskimage.KindD81
+	default:
+		return proto.StatusBadRequest, "destination must be a .d64, .d71 or .d81 file"
+	}
+
+	srcAbs, err := fsops.ToOSPath(rootAbs, srcNorm)
+	if err != nil {
+		return proto.StatusInvalidPath, err.Error()
+	}
+	dstAbs, err := fsops.ToOSPath(rootAbs, dstNorm)
+	if err != nil {
+		return proto.StatusInvalidPath, err.Error()
+	}
+	if err := fsops.LstatNoSymlink(rootAbs, srcAbs, false); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return proto.StatusNotFound, "source not found"
+		}
+		return proto.StatusInvalidPath, err.Error()
+	}
+	if err := fsops.LstatNoSymlink(rootAbs, dstAbs, true); err != nil {
+		return proto.StatusInvalidPath, err.Error()
+	}
+	srcSt, err := fsops.Stat(srcAbs)
+	if err != nil {
+		return proto.StatusInternal, err.Error()
+	}
+	if !srcSt.Exists {
+		return proto.StatusNotFound, "source not found"
+	}
+	if srcSt.IsDir {
+		return proto.StatusIsADir, "source is a directory"
+	}
+	dstSt, err := fsops.Stat(dstAbs)
+	if err != nil {
+		return proto.StatusInternal, err.Error()
+	}
+	if dstSt.IsDir {
+		return proto.StatusIsADir, "destination is a directory"
+	}
+	if dstSt.Exists && !overwrite {
+		return proto.StatusAccessDenied, "destination exists"
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dstAbs), ".wicos64-convert-*")
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return proto.StatusNotFound, "destination directory not found"
+		}
+		return proto.StatusInternal, err.Error()
+	}
+	tmpName := tmp.Name()
+	_ = tmp.Close()
+	_ = os.Chmod(tmpName, 0o644)
+	defer os.Remove(tmpName) // no-op once renamed
+
+	if _, err := diskimage.ConvertImage(srcAbs, tmpName, dstKind); err != nil {
+		var se *diskimage.StatusError
+		if errors.As(err, &se) {
+			return se.Status(), se.Error()
+		}
+		return proto.StatusInternal, err.Error()
+	}
+	tmpSt, err := os.Stat(tmpName)
+	if err != nil {
+		return proto.StatusInternal, err.Error()
+	}
+	newSize := uint64(tmpSt.Size())
+	if limits.MaxFileBytes > 0 && newSize > limits.MaxFileBytes {
+		return proto.StatusTooLarge, "file too large"
+	}
+
+	// Same accounting as a filesystem CP: a trashed dst keeps counting.
+	trashOverwrite := cfg.TrashEnabled
+	delta := int64(newSize)
+	if dstSt.Exists && !trashOverwrite {
+		delta -= int64(dstSt.Size)
+	}
+	haveUsed := limits.QuotaBytes > 0 && s.usage != nil
+	if haveUsed && delta > 0 {
+		used, err := s.rootUsageBytes(rootAbs)
+		if err != nil {
+			return proto.StatusInternal, err.Error()
+		}
+		if !s.fitQuota(cfg, limits, rootAbs, &used, uint64(delta)) {
+			return proto.StatusTooLarge, "quota exceeded"
+		}
+	}
+
+	if limits.dryRun.skipAbs(rootAbs, dstAbs) {
+		return proto.StatusOK, ""
+	}
+	if dstSt.Exists && trashOverwrite {
+		if _, err := s.moveToTrash(cfg, rootAbs, dstAbs); err != nil {
+			return proto.StatusInternal, err.Error()
+		}
+	}
+	if err := os.Rename(tmpName, dstAbs); err != nil {
+		s.invalidateRootUsage(rootAbs)
+		return proto.StatusInternal, err.Error()
+	}
+	if haveUsed {
+		s.adjustRootUsage(rootAbs, delta)
+	}
+	return proto.StatusOK, ""
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"wicos64-server/internal/proto"
+)
+
+// imageCRCs returns the HASH crc32 of the named files inside an image.
+func imageCRCs(t *testing.T, s *Server, limits Limits, rootAbs, img string, names []string) map[string]string {
+	t.Helper()
+	cfg := s.cfgSnapshot()
+	out := map[string]string{}
+	for _, name := range names {
+		st, resp, msg := s.dispatch(cfg, limits, proto.OpHASH, 0, pathPayload(img+"/"+name), rootAbs)
+		if st != proto.StatusOK {
+			t.Fatalf("HASH %s/%s = %s (%s)", img, name, statusName(st), msg)
+		}
+		out[name] = fmt.Sprintf("% X", resp)
+	}
+	return out
+}
+
+func cpPayload(src, dst string) []byte {
+	return encode(func(e *proto.Encoder) { _ = e.WriteString(src); _ = e.WriteString(dst) })
+}
+
+func TestCPConvertKeepsFileCRCs(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	limits := Limits{DiskImagesEnabled: true, DiskImagesWriteEnabled: true}
+	mkImage(t, s, cfg, limits, rootAbs, "/FULL.D64", proto.ImageKindD64)
+
+	// About 545 of the 664 free blocks.
+	var names []string
+	for i := 0; i < 20; i++ {
+		data := bytes.Repeat([]byte{byte(i), byte(i * 3), 0xA0, '\n'}, 1500+97*i)[:6000+97*i]
+		p := fmt.Sprintf("/FULL.D64/F%02d", i)
+		if st, _, msg := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, proto.FlagWR_CREATE, writeRangePayload(t, p, 0, data[:min(len(data), 1024)]), rootAbs); st != proto.StatusOK {
+			t.Fatalf("WRITE_RANGE %s = %s (%s)", p, statusName(st), msg)
+		}
+		for off := 1024; off < len(data); off += 1024 {
+			chunk := data[off:min(len(data), off+1024)]
+			if st, _, msg := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, 0, writeRangePayload(t, p, uint32(off), chunk), rootAbs); st != proto.StatusOK {
+				t.Fatalf("WRITE_RANGE %s @%d = %s (%s)", p, off, statusName(st), msg)
+			}
+		}
+		names = append(names, p[len("/FULL.D64/"):])
+	}
+	want := imageCRCs(t, s, limits, rootAbs, "/FULL.D64", names)
+
+	for _, step := range []struct{ src, dst string }{
+		{"/FULL.D64", "/BIG.D81"},
+		{"/BIG.D81", "/MID.D71"},
+		{"/MID.D71", "/BACK.D64"},
+	} {
+		if st, _, msg := s.dispatch(cfg, limits, proto.OpCP, proto.FlagCP_CONVERT, cpPayload(step.src, step.dst), rootAbs); st != proto.StatusOK {
+			t.Fatalf("CP CONVERT %s -> %s = %s (%s)", step.src, step.dst, statusName(st), msg)
+		}
+		got := imageCRCs(t, s, limits, rootAbs, step.dst, names)
+		for _, name := range names {
+			if got[name] != want[name] {
+				t.Fatalf("%s/%s crc32 %s, want %s", step.dst, name, got[name], want[name])
+			}
+		}
+	}
+
+	// The target exists now: no overwrite without the flag.
+	if st, _, _ := s.dispatch(cfg, limits, proto.OpCP, proto.FlagCP_CONVERT, cpPayload("/FULL.D64", "/BIG.D81"), rootAbs); st != proto.StatusAccessDenied {
+		t.Fatalf("CP CONVERT onto an existing image = %s, want ACCESS_DENIED", statusName(st))
+	}
+	if st, _, _ := s.dispatch(cfg, Limits{DiskImagesEnabled: true}, proto.OpCP, proto.FlagCP_CONVERT, cpPayload("/FULL.D64", "/RO.D81"), rootAbs); st != proto.StatusAccessDenied {
+		t.Fatalf("CP CONVERT with image writes disabled = %s, want ACCESS_DENIED", statusName(st))
+	}
+}
+
+func TestCPConvertTooLarge(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	limits := Limits{DiskImagesEnabled: true, DiskImagesWriteEnabled: true}
+	mkImage(t, s, cfg, limits, rootAbs, "/BIG.D81", proto.ImageKindD81)
+	chunk := bytes.Repeat([]byte{0x55}, 4096)
+	// 200 KB does not fit the 664 free blocks (~168 KB) of a D64.
+	for i := 0; i < 4; i++ {
+		p := fmt.Sprintf("/BIG.D81/F%d", i)
+		for off := 0; off < 50*1024; off += len(chunk) {
+			if st, _, msg := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, proto.FlagWR_CREATE, writeRangePayload(t, p, uint32(off), chunk), rootAbs); st != proto.StatusOK {
+				t.Fatalf("WRITE_RANGE %s = %s (%s)", p, statusName(st), msg)
+			}
+		}
+	}
+	if st, _, msg := s.dispatch(cfg, limits, proto.OpCP, proto.FlagCP_CONVERT, cpPayload("/BIG.D81", "/SMALL.D64"), rootAbs); st != proto.StatusTooLarge {
+		t.Fatalf("CP CONVERT = %s (%s), want TOO_LARGE", statusName(st), msg)
+	}
+	if _, err := os.Stat(filepath.Join(rootAbs, "SMALL.D64")); err == nil {
+		t.Fatal("failed conversion left the destination behind")
+	}
+	matches, _ := filepath.Glob(filepath.Join(rootAbs, ".wicos64-convert-*"))
+	if len(matches) != 0 {
+		t.Fatalf("temp files left behind: %v", matches)
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/fsops"
+	"wicos64-server/internal/proto"
+)
+
+// opCOPY_RANGE copies a byte range from one file to another (or within the
+// same file) without the data passing through the client. The destination
+// is written with the WRITE_RANGE flag semantics (TRUNCATE, CREATE,
+// OVERWRITE), so max_file_bytes and quota apply the same way.
+//
+// The source range is read completely before writing, so overlapping copies
+// within one file behave like memmove. Disk image paths are not supported.
+//
+// Payload: src string, src_off u32, dst string, dst_off u32, length u16.
+// Response: copied u32.
+func (s *Server) opCOPY_RANGE(cfg config.Config, limits Limits, flags byte, payload []byte, rootAbs string) (byte, []byte, string) {
+	release, ok := s.lockWrite(cfg, limits, proto.OpCOPY_RANGE, payload, rootAbs, false)
+	if !ok {
+		return proto.StatusBusy, nil, "busy"
+	}
+	defer release()
+
+	d := proto.NewDecoder(payload)
+	src, err := s.readPathString(cfg, d)
+	if err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	srcOff, err := d.ReadU32()
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	dst, err := s.readPathString(cfg, d)
+	if err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	dstOff, err := d.ReadU32()
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	ln, err := d.ReadU16()
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	if d.Remaining() != 0 {
+		return proto.StatusBadRequest, nil, "extra bytes in COPY_RANGE"
+	}
+	for _, p := range []string{src, dst} {
+		if _, _, _, ok := splitDiskImagePath(p); ok {
+			return proto.StatusNotSupported, nil, "COPY_RANGE is not supported for disk images"
+		}
+	}
+
+	srcAbs, err := fsops.ToOSPath(rootAbs, src)
+	if err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	if err := fsops.LstatNoSymlink(rootAbs, srcAbs, false); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return proto.StatusNotFound, nil, "not found"
+		}
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	sst, err := fsops.Stat(srcAbs)
+	if err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+	if !sst.Exists {
+		return proto.StatusNotFound, nil, "not found"
+	}
+	if sst.IsDir {
+		return proto.StatusIsADir, nil, "is a directory"
+	}
+	if uint64(srcOff)+uint64(ln) > sst.Size {
+		return proto.StatusRangeInvalid, nil, "range exceeds EOF"
+	}
+
+	data := make([]byte, ln)
+	if ln > 0 {
+		f, err := os.Open(srcAbs)
+		if err != nil {
+			return proto.StatusInternal, nil, err.Error()
+		}
+		n, err := f.ReadAt(data, int64(srcOff))
+		_ = f.Close()
+		if err != nil && !(errors.Is(err, io.EOF) && n == len(data)) {
+			return proto.StatusInternal, nil, err.Error()
+		}
+	}
+
+	st, _, msg := s.writeHostFileRange(cfg, limits, flags, rootAbs, dst, dstOff, data)
+	if st != proto.StatusOK {
+		return st, nil, msg
+	}
+	e := proto.NewEncoder(4)
+	e.WriteU32(uint32(len(data)))
+	return proto.StatusOK, e.Bytes(), ""
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"wicos64-server/internal/proto"
+)
+
+func copyRangePayload(src string, srcOff uint32, dst string, dstOff uint32, n uint16) []byte {
+	return encode(func(e *proto.Encoder) {
+		_ = e.WriteString(src)
+		e.WriteU32(srcOff)
+		_ = e.WriteString(dst)
+		e.WriteU32(dstOff)
+		e.WriteU16(n)
+	})
+}
+
+func TestCOPY_RANGEOverlap(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	const orig = "0123456789ABCDEF"
+	for _, tc := range []struct {
+		name           string
+		srcOff, dstOff uint32
+		n              uint16
+		want           string
+	}{
+		// Both directions must behave like memmove.
+		{"forward overlap", 0, 4, 8, "012301234567CDEF"},
+		{"backward overlap", 6, 2, 8, "016789ABCDABCDEF"},
+		{"onto itself", 3, 3, 5, orig},
+		{"append past EOF", 8, 16, 8, orig + "89ABCDEF"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(rootAbs, "F")
+			if err := os.WriteFile(path, []byte(orig), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			st, resp, msg := s.dispatch(cfg, Limits{}, proto.OpCOPY_RANGE, 0, copyRangePayload("/F", tc.srcOff, "/F", tc.dstOff, tc.n), rootAbs)
+			if st != proto.StatusOK {
+				t.Fatalf("COPY_RANGE = %s (%s)", statusName(st), msg)
+			}
+			if len(resp) != 4 || binary.LittleEndian.Uint32(resp) != uint32(tc.n) {
+				t.Fatalf("COPY_RANGE response % X, want copied=%d", resp, tc.n)
+			}
+			if b, _ := os.ReadFile(path); string(b) != tc.want {
+				t.Fatalf("F = %q, want %q", b, tc.want)
+			}
+		})
+	}
+}
+
+func TestCOPY_RANGERefusals(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	if err := os.WriteFile(filepath.Join(rootAbs, "F"), []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name    string
+		limits  Limits
+		flags   byte
+		payload []byte
+		want    byte
+	}{
+		{"source range past EOF", Limits{}, 0, copyRangePayload("/F", 6, "/F", 0, 5), proto.StatusRangeInvalid},
+		{"missing source", Limits{}, 0, copyRangePayload("/NONE", 0, "/F", 0, 1), proto.StatusNotFound},
+		{"missing destination without CREATE", Limits{}, 0, copyRangePayload("/F", 0, "/NEW", 0, 4), proto.StatusNotFound},
+		{"offset 0 of an existing file without TRUNCATE", Limits{}, 0, copyRangePayload("/F", 4, "/F", 0, 4), proto.StatusAlreadyExists},
+		{"sparse destination offset", Limits{}, 0, copyRangePayload("/F", 0, "/F", 11, 1), proto.StatusRangeInvalid},
+		{"read-only token", Limits{ReadOnly: true}, 0, copyRangePayload("/F", 0, "/F", 10, 4), proto.StatusAccessDenied},
+		{"max_file_bytes", Limits{MaxFileBytes: 12}, 0, copyRangePayload("/F", 0, "/F", 10, 4), proto.StatusTooLarge},
+		{"quota", Limits{QuotaBytes: 12}, 0, copyRangePayload("/F", 0, "/F", 10, 4), proto.StatusTooLarge},
+		{"disk image", Limits{DiskImagesEnabled: true}, 0, copyRangePayload("/A.D64/X", 0, "/F", 0, 1), proto.StatusNotSupported},
+	} {
+		if st, _, msg := s.dispatch(cfg, tc.limits, proto.OpCOPY_RANGE, tc.flags, tc.payload, rootAbs); st != tc.want {
+			t.Errorf("%s: COPY_RANGE = %s (%s), want %s", tc.name, statusName(st), msg, statusName(tc.want))
+		}
+	}
+	if b, _ := os.ReadFile(filepath.Join(rootAbs, "F")); string(b) != "0123456789" {
+		t.Fatalf("refused copies changed F to %q", b)
+	}
+
+	// CREATE+TRUNCATE replace the destination with just the copied range.
+	if st, _, msg := s.dispatch(cfg, Limits{}, proto.OpCOPY_RANGE, proto.FlagWR_CREATE, copyRangePayload("/F", 2, "/NEW", 0, 4), rootAbs); st != proto.StatusOK {
+		t.Fatalf("COPY_RANGE CREATE = %s (%s)", statusName(st), msg)
+	}
+	if err := os.WriteFile(filepath.Join(rootAbs, "OLD"), []byte("old contents"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if st, _, msg := s.dispatch(cfg, Limits{}, proto.OpCOPY_RANGE, proto.FlagWR_TRUNCATE|proto.FlagWR_OVERWRITE, copyRangePayload("/F", 5, "/OLD", 0, 3), rootAbs); st != proto.StatusOK {
+		t.Fatalf("COPY_RANGE TRUNCATE = %s (%s)", statusName(st), msg)
+	}
+	for name, want := range map[string]string{"NEW": "2345", "OLD": "567"} {
+		if b, _ := os.ReadFile(filepath.Join(rootAbs, name)); string(b) != want {
+			t.Errorf("%s = %q, want %q", name, b, want)
+		}
+	}
+}
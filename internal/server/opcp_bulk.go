@@ -109,7 +109,10 @@ func (s *Server) cpBulkFS(cfg config.Config, limits Limits, rootAbs, srcDirNorm,
 		var srcTotal uint64
 		var srcMax uint64
 		if isDir {
-			srcTotal, srcMax, err = pathSizeBytes(srcAbs)
+			if err := fsops.CheckTree(srcAbs, treeLimits(cfg)); err != nil {
+				return treeErrStatus(err)
+			}
+			srcTotal, srcMax, err = pathSizeBytes(cfg, rootAbs, srcAbs)
 			if err != nil {
 				return proto.StatusInvalidPath, err.Error()
 			}
@@ -133,7 +136,7 @@ func (s *Server) cpBulkFS(cfg config.Config, limits Limits, rootAbs, srcDirNorm,
 		// If not using trash for overwrite, compute old size (can reduce quota impact).
 		var dstOldTotal uint64
 		if dstSt.Exists && !trashOverwrite {
-			dstOldTotal, _, err = pathSizeBytes(dstAbs)
+			dstOldTotal, _, err = pathSizeBytes(cfg, rootAbs, dstAbs)
 			if err != nil {
 				return proto.StatusInternal, err.Error()
 			}
@@ -144,32 +147,38 @@ func (s *Server) cpBulkFS(cfg config.Config, limits Limits, rootAbs, srcDirNorm,
 			delta = int64(srcTotal)
 		}
 
-		if delta > 0 && haveUsed && used+uint64(delta) > limits.QuotaBytes {
+		if delta > 0 && haveUsed && !s.fitQuota(cfg, limits, rootAbs, &used, uint64(delta)) {
 			return proto.StatusTooLarge, "quota exceeded"
 		}
 
 		// Overwrite handling.
+		if limits.dryRun.skipAbs(rootAbs, dstAbs) {
+			used = applyDeltaBytes(used, delta)
+			copied++
+			continue
+		}
 		if dstSt.Exists {
 			if trashOverwrite {
 				if _, err := s.moveToTrash(cfg, rootAbs, dstAbs); err != nil {
 					return proto.StatusInternal, err.Error()
 				}
 			} else {
-				if err := os.RemoveAll(dstAbs); err != nil {
-					return proto.StatusInternal, err.Error()
+				if err := fsops.RemoveTree(dstAbs, treeLimits(cfg)); err != nil {
+					return treeErrStatus(err)
 				}
 				s.invalidateRootUsage(rootAbs)
 			}
 		}
 
 		// Copy.
+		limits.progress.addTotal(srcTotal)
 		if isDir {
-			if err := fsops.CopyDirRecursive(srcAbs, dstAbs); err != nil {
+			if err := fsops.CopyDirRecursiveProgress(srcAbs, dstAbs, treeLimits(cfg), limits.progress.progressFunc()); err != nil {
 				s.invalidateRootUsage(rootAbs)
-				return proto.StatusInternal, err.Error()
+				return treeErrStatus(err)
 			}
 		} else {
-			if err := fsops.CopyFile(srcAbs, dstAbs); err != nil {
+			if err := fsops.CopyFileProgress(srcAbs, dstAbs, limits.progress.progressFunc()); err != nil {
 				s.invalidateRootUsage(rootAbs)
 				return proto.StatusInternal, err.Error()
 			}
@@ -178,7 +187,7 @@ func (s *Server) cpBulkFS(cfg config.Config, limits Limits, rootAbs, srcDirNorm,
 		// Update cached usage as we go.
 		if haveUsed && s.usage != nil {
 			used = applyDeltaBytes(used, delta)
-			s.setRootUsageBytes(rootAbs, used)
+			s.adjustRootUsage(rootAbs, delta)
 		}
 
 		copied++
@@ -241,8 +250,10 @@ func (s *Server) cpSingleFromD64(cfg config.Config, limits Limits, rootAbs, moun
 	if err := fsops.LstatNoSymlink(rootAbs, dstAbs, true); err != nil {
 		return proto.StatusInvalidPath, err.Error()
 	}
-	if err := fsops.EnsureParents(dstAbs); err != nil {
-		return proto.StatusInternal, err.Error()
+	if limits.dryRun == nil {
+		if err := fsops.EnsureParents(dstAbs); err != nil {
+			return proto.StatusInternal, err.Error()
+		}
 	}
 
 	// Quota baseline.
@@ -259,7 +270,7 @@ func (s *Server) cpSingleFromD64(cfg config.Config, limits Limits, rootAbs, moun
 
 	var dstOldTotal uint64
 	if dstSt.Exists && !trashOverwrite {
-		dstOldTotal, _, err = pathSizeBytes(dstAbs)
+		dstOldTotal, _, err = pathSizeBytes(cfg, rootAbs, dstAbs)
 		if err != nil {
 			return proto.StatusInternal, err.Error()
 		}
@@ -270,7 +281,7 @@ func (s *Server) cpSingleFromD64(cfg config.Config, limits Limits, rootAbs, moun
 		delta = int64(srcTotal)
 	}
 
-	if delta > 0 && haveUsed && used+uint64(delta) > limits.QuotaBytes {
+	if delta > 0 && haveUsed && !s.fitQuota(cfg, limits, rootAbs, &used, uint64(delta)) {
 		return proto.StatusTooLarge, "quota exceeded"
 	}
 
@@ -280,17 +291,20 @@ func (s *Server) cpSingleFromD64(cfg config.Config, limits Limits, rootAbs, moun
 		return proto.StatusInternal, err.Error()
 	}
 
+	if dstSt.Exists && !overwrite {
+		return proto.StatusAccessDenied, "destination exists"
+	}
+	if limits.dryRun.skipAbs(rootAbs, dstAbs) {
+		return proto.StatusOK, ""
+	}
 	if dstSt.Exists {
-		if !overwrite {
-			return proto.StatusAccessDenied, "destination exists"
-		}
 		if trashOverwrite {
 			if _, err := s.moveToTrash(cfg, rootAbs, dstAbs); err != nil {
 				return proto.StatusInternal, err.Error()
 			}
 		} else {
-			if err := os.RemoveAll(dstAbs); err != nil {
-				return proto.StatusInternal, err.Error()
+			if err := fsops.RemoveTree(dstAbs, treeLimits(cfg)); err != nil {
+				return treeErrStatus(err)
 			}
 			s.invalidateRootUsage(rootAbs)
 		}
@@ -303,7 +317,7 @@ func (s *Server) cpSingleFromD64(cfg config.Config, limits Limits, rootAbs, moun
 
 	if haveUsed && s.usage != nil {
 		used = applyDeltaBytes(used, delta)
-		s.setRootUsageBytes(rootAbs, used)
+		s.adjustRootUsage(rootAbs, delta)
 	}
 
 	return proto.StatusOK, ""
@@ -407,6 +421,9 @@ func (s *Server) cpToD64(cfg config.Config, limits Limits, rootAbs, mountPath, d
 	}
 
 	allowOverwrite := cfg.EnableOverwrite && overwrite
+	if limits.dryRun != nil {
+		return limits.dryRun.imageWrite(rootAbs, "d64", mountPath, name, allowOverwrite)
+	}
 	_, err := diskimage.WriteFileRangeD64(imgAbs, name, 0, data, true, true, allowOverwrite)
 	if err != nil {
 		var se *diskimage.StatusError
@@ -444,6 +461,9 @@ func (s *Server) cpToD71(cfg config.Config, limits Limits, rootAbs, mountPath, d
 	}
 
 	allowOverwrite := cfg.EnableOverwrite && overwrite
+	if limits.dryRun != nil {
+		return limits.dryRun.imageWrite(rootAbs, "d71", mountPath, dstName, allowOverwrite)
+	}
 	_, err := diskimage.WriteFileRangeD71(imgAbs, dstName, 0, data, true, true, allowOverwrite)
 	if err != nil {
 		var se *diskimage.StatusError
@@ -498,17 +518,10 @@ func (s *Server) cpToD81(cfg config.Config, limits Limits, rootAbs, mountPath, d
 			return proto.StatusIsADir, "source is a directory"
 		}
 		allowOverwrite := cfg.EnableOverwrite && overwrite
-		// Import the directory tree into the image by repacking. This also creates required
-		// 1581 "partition" directories and ensures they occupy contiguous tracks.
-		err := diskimage.ImportDirD81(imgAbs, finalInner, srcAbs, allowOverwrite, cfg.Compat.FallbackPRGExtension, limits.MaxFileBytes)
-		if err != nil {
-			var se *diskimage.StatusError
-			if errors.As(err, &se) {
-				return se.Status(), se.Error()
-			}
-			return proto.StatusInternal, err.Error()
+		if limits.dryRun != nil {
+			return limits.dryRun.imageWrite(rootAbs, "d81", mountPath, finalInner, allowOverwrite)
 		}
-		return proto.StatusOK, ""
+		return s.cpDirToD81(cfg, limits, imgAbs, img, finalInner, srcAbs, allowOverwrite)
 	}
 	if limits.MaxFileBytes > 0 && stInfo.Size > limits.MaxFileBytes {
 		return proto.StatusTooLarge, "file too large"
@@ -520,6 +533,9 @@ func (s *Server) cpToD81(cfg config.Config, limits Limits, rootAbs, mountPath, d
 	}
 
 	allowOverwrite := cfg.EnableOverwrite && overwrite
+	if limits.dryRun != nil {
+		return limits.dryRun.imageWrite(rootAbs, "d81", mountPath, finalInner, allowOverwrite)
+	}
 	_, err = diskimage.WriteFileRangeD81(imgAbs, finalInner, 0, data, true, true, allowOverwrite)
 	if err != nil {
 		var se *diskimage.StatusError
@@ -532,6 +548,110 @@ func (s *Server) cpToD81(cfg config.Config, limits Limits, rootAbs, mountPath, d
 	return proto.StatusOK, ""
 }
 
+// cpDirToD81 copies the directory tree srcAbs into the D81 image imgAbs as
+// the partition finalInner (CP -r): it creates the partition and every
+// subdirectory with MkdirDirD81 and writes the files one by one under their
+// inner paths. A partition that runs out of space is grown by repacking
+// only with disk_images_auto_resize_enabled; otherwise, and when the image
+// itself is full, the copy fails with TOO_LARGE. An existing destination is
+// replaced only if overwriting is allowed. A failed copy puts the image
+// back as it was.
+func (s *Server) cpDirToD81(cfg config.Config, limits Limits, imgAbs string, img *diskimage.D81, finalInner, srcAbs string, allowOverwrite bool) (byte, string) {
+	if err := fsops.CheckTree(srcAbs, treeLimits(cfg)); err != nil {
+		return treeErrStatus(err)
+	}
+
+	isDir := d81InnerIsDirEntry(img, finalInner)
+	_, _, fst, _ := resolveD81Inner(img, finalInner, false)
+	isFile := !isDir && fst == proto.StatusOK
+	if (isDir || isFile) && !allowOverwrite {
+		return proto.StatusAlreadyExists, "destination exists"
+	}
+
+	fi, err := os.Stat(imgAbs)
+	if err != nil {
+		return proto.StatusInternal, err.Error()
+	}
+	orig, err := os.ReadFile(imgAbs)
+	if err != nil {
+		return proto.StatusInternal, err.Error()
+	}
+
+	write := diskimage.WriteFileRangeD81NoResize
+	if limits.DiskImagesAutoResizeEnabled {
+		write = diskimage.WriteFileRangeD81
+	}
+	var copyDir func(srcDir, inner string) error
+	copyDir = func(srcDir, inner string) error {
+		if err := diskimage.MkdirDirD81(imgAbs, inner, true); err != nil {
+			return err
+		}
+		entries, err := os.ReadDir(srcDir)
+		if err != nil {
+			return err
+		}
+		sort.SliceStable(entries, func(i, j int) bool {
+			return strings.ToUpper(entries[i].Name()) < strings.ToUpper(entries[j].Name())
+		})
+		for _, e := range entries {
+			src := filepath.Join(srcDir, e.Name())
+			if e.IsDir() {
+				if err := copyDir(src, inner+"/"+e.Name()); err != nil {
+					return err
+				}
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return fsops.ErrSymlinkNotAllowed
+			}
+			if limits.MaxFileBytes > 0 && uint64(info.Size()) > limits.MaxFileBytes {
+				return fmt.Errorf("%s: %w", e.Name(), errFileTooLarge)
+			}
+			data, err := os.ReadFile(src)
+			if err != nil {
+				return err
+			}
+			leaf := normalizeDiskImageLeafName(e.Name(), cfg.Compat.FallbackPRGExtension)
+			if _, err := write(imgAbs, inner+"/"+leaf, 0, data, true, true, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	switch {
+	case isDir:
+		err = diskimage.RmdirDirD81(imgAbs, finalInner, true)
+	case isFile:
+		err = diskimage.DeleteFileD81(imgAbs, finalInner)
+	}
+	if err == nil {
+		err = copyDir(srcAbs, finalInner)
+	}
+	if err == nil {
+		return proto.StatusOK, ""
+	}
+	if rerr := writeFileAtomic(imgAbs, orig, fi.Mode().Perm()); rerr != nil {
+		return proto.StatusInternal, fmt.Sprintf("%v (restoring the image failed: %v)", err, rerr)
+	}
+	var se *diskimage.StatusError
+	switch {
+	case errors.As(err, &se):
+		return se.Status(), se.Error()
+	case errors.Is(err, errFileTooLarge):
+		return proto.StatusTooLarge, err.Error()
+	default:
+		return treeErrStatus(err)
+	}
+}
+
+// errFileTooLarge reports a source file above the token's max_file_bytes.
+var errFileTooLarge = errors.New("file too large")
+
 // cpBulkFSToD64 copies wildcard-matched files from a filesystem directory into the *root*
 // of a mounted .d64 image.
 func (s *Server) cpBulkFSToD64(cfg config.Config, limits Limits, rootAbs, srcDirNorm, pat, dstMount string, overwrite bool) (byte, string) {
@@ -601,6 +721,12 @@ func (s *Server) cpBulkFSToD64(cfg config.Config, limits Limits, rootAbs, srcDir
 		}
 
 		imgName := normalizeDiskImageLeafName(name, cfg.Compat.FallbackPRGExtension)
+		if limits.dryRun != nil {
+			if st, msg := limits.dryRun.imageWrite(rootAbs, "d64", dstMount, imgName, allowOverwrite); st != proto.StatusOK {
+				return st, msg
+			}
+			continue
+		}
 		_, err = diskimage.WriteFileRangeD64(imgAbs, imgName, 0, data, true, true, allowOverwrite)
 		if err != nil {
 			var se *diskimage.StatusError
@@ -683,6 +809,12 @@ func (s *Server) cpBulkFSToD71(cfg config.Config, limits Limits, rootAbs, srcDir
 		}
 
 		imgName := normalizeDiskImageLeafName(name, cfg.Compat.FallbackPRGExtension)
+		if limits.dryRun != nil {
+			if st, msg := limits.dryRun.imageWrite(rootAbs, "d71", dstMount, imgName, allowOverwrite); st != proto.StatusOK {
+				return st, msg
+			}
+			continue
+		}
 		_, err = diskimage.WriteFileRangeD71(imgAbs, imgName, 0, data, true, true, allowOverwrite)
 		if err != nil {
 			var se *diskimage.StatusError
@@ -763,6 +895,12 @@ func (s *Server) cpBulkFSToD81(cfg config.Config, limits Limits, rootAbs, srcDir
 		}
 
 		imgName := normalizeDiskImageLeafName(name, cfg.Compat.FallbackPRGExtension)
+		if limits.dryRun != nil {
+			if st, msg := limits.dryRun.imageWrite(rootAbs, "d81", dstMount, imgName, allowOverwrite); st != proto.StatusOK {
+				return st, msg
+			}
+			continue
+		}
 		_, err = diskimage.WriteFileRangeD81(imgAbs, imgName, 0, data, true, true, allowOverwrite)
 		if err != nil {
 			var se *diskimage.StatusError
@@ -849,7 +987,7 @@ func (s *Server) cpBulkFromD64(cfg config.Config, limits Limits, rootAbs, mountP
 
 		var dstOldTotal uint64
 		if dstSt.Exists && !trashOverwrite {
-			dstOldTotal, _, err = pathSizeBytes(dstAbs)
+			dstOldTotal, _, err = pathSizeBytes(cfg, rootAbs, dstAbs)
 			if err != nil {
 				return proto.StatusInternal, err.Error()
 			}
@@ -860,7 +998,7 @@ func (s *Server) cpBulkFromD64(cfg config.Config, limits Limits, rootAbs, mountP
 			delta = int64(srcTotal)
 		}
 
-		if delta > 0 && haveUsed && used+uint64(delta) > limits.QuotaBytes {
+		if delta > 0 && haveUsed && !s.fitQuota(cfg, limits, rootAbs, &used, uint64(delta)) {
 			return proto.StatusTooLarge, "quota exceeded"
 		}
 
@@ -870,14 +1008,19 @@ func (s *Server) cpBulkFromD64(cfg config.Config, limits Limits, rootAbs, mountP
 			return proto.StatusInternal, err.Error()
 		}
 
+		if limits.dryRun.skipAbs(rootAbs, dstAbs) {
+			used = applyDeltaBytes(used, delta)
+			copied++
+			continue
+		}
 		if dstSt.Exists {
 			if trashOverwrite {
 				if _, err := s.moveToTrash(cfg, rootAbs, dstAbs); err != nil {
 					return proto.StatusInternal, err.Error()
 				}
 			} else {
-				if err := os.RemoveAll(dstAbs); err != nil {
-					return proto.StatusInternal, err.Error()
+				if err := fsops.RemoveTree(dstAbs, treeLimits(cfg)); err != nil {
+					return treeErrStatus(err)
 				}
 				s.invalidateRootUsage(rootAbs)
 			}
@@ -890,7 +1033,7 @@ func (s *Server) cpBulkFromD64(cfg config.Config, limits Limits, rootAbs, mountP
 
 		if haveUsed && s.usage != nil {
 			used = applyDeltaBytes(used, delta)
-			s.setRootUsageBytes(rootAbs, used)
+			s.adjustRootUsage(rootAbs, delta)
 		}
 
 		copied++
@@ -960,6 +1103,9 @@ func (s *Server) cpD71ToD71(cfg config.Config, limits Limits, rootAbs, srcMount,
 	}
 
 	allowOverwrite := cfg.EnableOverwrite && overwrite
+	if limits.dryRun != nil {
+		return limits.dryRun.imageWrite(rootAbs, "d71", dstMount, dstName, allowOverwrite)
+	}
 	_, err = diskimage.WriteFileRangeD71(dstImgAbs, dstName, 0, data, true, true, allowOverwrite)
 	if err != nil {
 		if se, ok := err.(*diskimage.StatusError); ok {
@@ -1076,6 +1222,9 @@ func (s *Server) cpD64ToD64(cfg config.Config, limits Limits, rootAbs, srcMount,
 	}
 
 	allowOverwrite := cfg.EnableOverwrite && overwrite
+	if limits.dryRun != nil {
+		return limits.dryRun.imageWrite(rootAbs, "d64", dstMount, dstName, allowOverwrite)
+	}
 	_, err = diskimage.WriteFileRangeD64(dstImgAbs, dstName, 0, data, true, true, allowOverwrite)
 	if err != nil {
 		var se *diskimage.StatusError
@@ -1140,6 +1289,9 @@ func (s *Server) cpD64ToD71(cfg config.Config, limits Limits, rootAbs, srcMount,
 	}
 
 	allowOverwrite := cfg.EnableOverwrite && overwrite
+	if limits.dryRun != nil {
+		return limits.dryRun.imageWrite(rootAbs, "d71", dstMount, dstName, allowOverwrite)
+	}
 	_, err = diskimage.WriteFileRangeD71(dstImgAbs, dstName, 0, data, true, true, allowOverwrite)
 	if err != nil {
 		var se *diskimage.StatusError
@@ -1199,6 +1351,9 @@ func (s *Server) cpD64ToD81(cfg config.Config, limits Limits, rootAbs, srcMount,
 	}
 
 	allowOverwrite := cfg.EnableOverwrite && overwrite
+	if limits.dryRun != nil {
+		return limits.dryRun.imageWrite(rootAbs, "d81", dstMount, finalInner, allowOverwrite)
+	}
 	_, err = diskimage.WriteFileRangeD81(dstImgAbs, finalInner, 0, data, true, true, allowOverwrite)
 	if err != nil {
 		var se *diskimage.StatusError
@@ -1263,6 +1418,9 @@ func (s *Server) cpD71ToD64(cfg config.Config, limits Limits, rootAbs, srcMount,
 	}
 
 	allowOverwrite := cfg.EnableOverwrite && overwrite
+	if limits.dryRun != nil {
+		return limits.dryRun.imageWrite(rootAbs, "d64", dstMount, dstName, allowOverwrite)
+	}
 	_, err = diskimage.WriteFileRangeD64(dstImgAbs, dstName, 0, data, true, true, allowOverwrite)
 	if err != nil {
 		var se *diskimage.StatusError
@@ -1322,6 +1480,9 @@ func (s *Server) cpD71ToD81(cfg config.Config, limits Limits, rootAbs, srcMount,
 	}
 
 	allowOverwrite := cfg.EnableOverwrite && overwrite
+	if limits.dryRun != nil {
+		return limits.dryRun.imageWrite(rootAbs, "d81", dstMount, finalInner, allowOverwrite)
+	}
 	_, err = diskimage.WriteFileRangeD81(dstImgAbs, finalInner, 0, data, true, true, allowOverwrite)
 	if err != nil {
 		var se *diskimage.StatusError
@@ -1383,6 +1544,9 @@ func (s *Server) cpD81ToD64(cfg config.Config, limits Limits, rootAbs, srcMount,
 	}
 
 	allowOverwrite := cfg.EnableOverwrite && overwrite
+	if limits.dryRun != nil {
+		return limits.dryRun.imageWrite(rootAbs, "d64", dstMount, dstName, allowOverwrite)
+	}
 	_, err = diskimage.WriteFileRangeD64(dstImgAbs, dstName, 0, data, true, true, allowOverwrite)
 	if err != nil {
 		var se *diskimage.StatusError
@@ -1444,6 +1608,9 @@ func (s *Server) cpD81ToD71(cfg config.Config, limits Limits, rootAbs, srcMount,
 	}
 
 	allowOverwrite := cfg.EnableOverwrite && overwrite
+	if limits.dryRun != nil {
+		return limits.dryRun.imageWrite(rootAbs, "d71", dstMount, dstName, allowOverwrite)
+	}
 	_, err = diskimage.WriteFileRangeD71(dstImgAbs, dstName, 0, data, true, true, allowOverwrite)
 	if err != nil {
 		var se *diskimage.StatusError
@@ -1510,6 +1677,9 @@ func (s *Server) cpD81ToD81(cfg config.Config, limits Limits, rootAbs, srcMount,
 	}
 
 	allowOverwrite := cfg.EnableOverwrite && overwrite
+	if limits.dryRun != nil {
+		return limits.dryRun.imageWrite(rootAbs, "d81", dstMount, finalInner, allowOverwrite)
+	}
 	_, err = diskimage.WriteFileRangeD81(dstImgAbs, finalInner, 0, data, true, true, allowOverwrite)
 	if err != nil {
 		var se *diskimage.StatusError
@@ -1569,8 +1739,10 @@ func (s *Server) cpSingleFromD71(cfg config.Config, limits Limits, rootAbs, moun
 	if err := fsops.LstatNoSymlink(rootAbs, dstAbs, true); err != nil {
 		return proto.StatusInvalidPath, err.Error()
 	}
-	if err := fsops.EnsureParents(dstAbs); err != nil {
-		return proto.StatusInternal, err.Error()
+	if limits.dryRun == nil {
+		if err := fsops.EnsureParents(dstAbs); err != nil {
+			return proto.StatusInternal, err.Error()
+		}
 	}
 
 	var used uint64
@@ -1586,7 +1758,7 @@ func (s *Server) cpSingleFromD71(cfg config.Config, limits Limits, rootAbs, moun
 
 	var dstOldTotal uint64
 	if dstSt.Exists && !trashOverwrite {
-		dstOldTotal, _, err = pathSizeBytes(dstAbs)
+		dstOldTotal, _, err = pathSizeBytes(cfg, rootAbs, dstAbs)
 		if err != nil {
 			return proto.StatusInternal, err.Error()
 		}
@@ -1597,7 +1769,7 @@ func (s *Server) cpSingleFromD71(cfg config.Config, limits Limits, rootAbs, moun
 		delta = int64(srcTotal)
 	}
 
-	if delta > 0 && haveUsed && used+uint64(delta) > limits.QuotaBytes {
+	if delta > 0 && haveUsed && !s.fitQuota(cfg, limits, rootAbs, &used, uint64(delta)) {
 		return proto.StatusTooLarge, "quota exceeded"
 	}
 
@@ -1606,17 +1778,20 @@ func (s *Server) cpSingleFromD71(cfg config.Config, limits Limits, rootAbs, moun
 		return proto.StatusInternal, err.Error()
 	}
 
+	if dstSt.Exists && !overwrite {
+		return proto.StatusAccessDenied, "destination exists"
+	}
+	if limits.dryRun.skipAbs(rootAbs, dstAbs) {
+		return proto.StatusOK, ""
+	}
 	if dstSt.Exists {
-		if !overwrite {
-			return proto.StatusAccessDenied, "destination exists"
-		}
 		if trashOverwrite {
 			if _, err := s.moveToTrash(cfg, rootAbs, dstAbs); err != nil {
 				return proto.StatusInternal, err.Error()
 			}
 		} else {
-			if err := os.RemoveAll(dstAbs); err != nil {
-				return proto.StatusInternal, err.Error()
+			if err := fsops.RemoveTree(dstAbs, treeLimits(cfg)); err != nil {
+				return treeErrStatus(err)
 			}
 			s.invalidateRootUsage(rootAbs)
 		}
@@ -1629,7 +1804,7 @@ func (s *Server) cpSingleFromD71(cfg config.Config, limits Limits, rootAbs, moun
 
 	if haveUsed && s.usage != nil {
 		used = applyDeltaBytes(used, delta)
-		s.setRootUsageBytes(rootAbs, used)
+		s.adjustRootUsage(rootAbs, delta)
 	}
 
 	return proto.StatusOK, ""
@@ -1705,7 +1880,7 @@ func (s *Server) cpBulkFromD71(cfg config.Config, limits Limits, rootAbs, mountP
 
 		var dstOldTotal uint64
 		if dstSt.Exists && !trashOverwrite {
-			dstOldTotal, _, err = pathSizeBytes(dstAbs)
+			dstOldTotal, _, err = pathSizeBytes(cfg, rootAbs, dstAbs)
 			if err != nil {
 				return proto.StatusInternal, err.Error()
 			}
@@ -1716,7 +1891,7 @@ func (s *Server) cpBulkFromD71(cfg config.Config, limits Limits, rootAbs, mountP
 			delta = int64(srcTotal)
 		}
 
-		if delta > 0 && haveUsed && used+uint64(delta) > limits.QuotaBytes {
+		if delta > 0 && haveUsed && !s.fitQuota(cfg, limits, rootAbs, &used, uint64(delta)) {
 			return proto.StatusTooLarge, "quota exceeded"
 		}
 
@@ -1725,14 +1900,19 @@ func (s *Server) cpBulkFromD71(cfg config.Config, limits Limits, rootAbs, mountP
 			return proto.StatusInternal, err.Error()
 		}
 
+		if limits.dryRun.skipAbs(rootAbs, dstAbs) {
+			used = applyDeltaBytes(used, delta)
+			copied++
+			continue
+		}
 		if dstSt.Exists {
 			if trashOverwrite {
 				if _, err := s.moveToTrash(cfg, rootAbs, dstAbs); err != nil {
 					return proto.StatusInternal, err.Error()
 				}
 			} else {
-				if err := os.RemoveAll(dstAbs); err != nil {
-					return proto.StatusInternal, err.Error()
+				if err := fsops.RemoveTree(dstAbs, treeLimits(cfg)); err != nil {
+					return treeErrStatus(err)
 				}
 				s.invalidateRootUsage(rootAbs)
 			}
@@ -1745,7 +1925,7 @@ func (s *Server) cpBulkFromD71(cfg config.Config, limits Limits, rootAbs, mountP
 
 		if haveUsed && s.usage != nil {
 			used = applyDeltaBytes(used, delta)
-			s.setRootUsageBytes(rootAbs, used)
+			s.adjustRootUsage(rootAbs, delta)
 		}
 
 		copied++
@@ -1814,8 +1994,10 @@ func (s *Server) cpDirFromD81(cfg config.Config, limits Limits, rootAbs, imgAbs
 	if err := fsops.LstatNoSymlink(rootAbs, dstAbs, true); err != nil {
 		return proto.StatusInvalidPath, err.Error()
 	}
-	if err := fsops.EnsureParents(dstAbs); err != nil {
-		return proto.StatusInternal, err.Error()
+	if limits.dryRun == nil {
+		if err := fsops.EnsureParents(dstAbs); err != nil {
+			return proto.StatusInternal, err.Error()
+		}
 	}
 
 	// Pre-compute source totals for quota enforcement.
@@ -1840,7 +2022,7 @@ func (s *Server) cpDirFromD81(cfg config.Config, limits Limits, rootAbs, imgAbs
 
 	var dstOldTotal uint64
 	if dstSt.Exists && !trashOverwrite {
-		dstOldTotal, _, err = pathSizeBytes(dstAbs)
+		dstOldTotal, _, err = pathSizeBytes(cfg, rootAbs, dstAbs)
 		if err != nil {
 			return proto.StatusInternal, err.Error()
 		}
@@ -1851,21 +2033,24 @@ func (s *Server) cpDirFromD81(cfg config.Config, limits Limits, rootAbs, imgAbs
 		delta = int64(srcTotal)
 	}
 
-	if delta > 0 && haveUsed && used+uint64(delta) > limits.QuotaBytes {
+	if delta > 0 && haveUsed && !s.fitQuota(cfg, limits, rootAbs, &used, uint64(delta)) {
 		return proto.StatusTooLarge, "quota exceeded"
 	}
 
+	if dstSt.Exists && !overwrite {
+		return proto.StatusAccessDenied, "destination exists"
+	}
+	if limits.dryRun.skipAbs(rootAbs, dstAbs) {
+		return proto.StatusOK, ""
+	}
 	if dstSt.Exists {
-		if !overwrite {
-			return proto.StatusAccessDenied, "destination exists"
-		}
 		if trashOverwrite {
 			if _, err := s.moveToTrash(cfg, rootAbs, dstAbs); err != nil {
 				return proto.StatusInternal, err.Error()
 			}
 		} else {
-			if err := os.RemoveAll(dstAbs); err != nil {
-				return proto.StatusInternal, err.Error()
+			if err := fsops.RemoveTree(dstAbs, treeLimits(cfg)); err != nil {
+				return treeErrStatus(err)
 			}
 			s.invalidateRootUsage(rootAbs)
 		}
@@ -1882,7 +2067,7 @@ func (s *Server) cpDirFromD81(cfg config.Config, limits Limits, rootAbs, imgAbs
 
 	if haveUsed && s.usage != nil {
 		used = applyDeltaBytes(used, delta)
-		s.setRootUsageBytes(rootAbs, used)
+		s.adjustRootUsage(rootAbs, delta)
 	}
 
 	return proto.StatusOK, ""
@@ -2038,8 +2223,10 @@ func (s *Server) cpSingleFromD81(cfg config.Config, limits Limits, rootAbs, moun
 	if err := fsops.LstatNoSymlink(rootAbs, dstAbs, true); err != nil {
 		return proto.StatusInvalidPath, err.Error()
 	}
-	if err := fsops.EnsureParents(dstAbs); err != nil {
-		return proto.StatusInternal, err.Error()
+	if limits.dryRun == nil {
+		if err := fsops.EnsureParents(dstAbs); err != nil {
+			return proto.StatusInternal, err.Error()
+		}
 	}
 
 	var used uint64
@@ -2055,7 +2242,7 @@ func (s *Server) cpSingleFromD81(cfg config.Config, limits Limits, rootAbs, moun
 
 	var dstOldTotal uint64
 	if dstSt.Exists && !trashOverwrite {
-		dstOldTotal, _, err = pathSizeBytes(dstAbs)
+		dstOldTotal, _, err = pathSizeBytes(cfg, rootAbs, dstAbs)
 		if err != nil {
 			return proto.StatusInternal, err.Error()
 		}
@@ -2066,7 +2253,7 @@ func (s *Server) cpSingleFromD81(cfg config.Config, limits Limits, rootAbs, moun
 		delta = int64(srcTotal)
 	}
 
-	if delta > 0 && haveUsed && used+uint64(delta) > limits.QuotaBytes {
+	if delta > 0 && haveUsed && !s.fitQuota(cfg, limits, rootAbs, &used, uint64(delta)) {
 		return proto.StatusTooLarge, "quota exceeded"
 	}
 
@@ -2075,17 +2262,20 @@ func (s *Server) cpSingleFromD81(cfg config.Config, limits Limits, rootAbs, moun
 		return proto.StatusInternal, err.Error()
 	}
 
+	if dstSt.Exists && !overwrite {
+		return proto.StatusAccessDenied, "destination exists"
+	}
+	if limits.dryRun.skipAbs(rootAbs, dstAbs) {
+		return proto.StatusOK, ""
+	}
 	if dstSt.Exists {
-		if !overwrite {
-			return proto.StatusAccessDenied, "destination exists"
-		}
 		if trashOverwrite {
 			if _, err := s.moveToTrash(cfg, rootAbs, dstAbs); err != nil {
 				return proto.StatusInternal, err.Error()
 			}
 		} else {
-			if err := os.RemoveAll(dstAbs); err != nil {
-				return proto.StatusInternal, err.Error()
+			if err := fsops.RemoveTree(dstAbs, treeLimits(cfg)); err != nil {
+				return treeErrStatus(err)
 			}
 			s.invalidateRootUsage(rootAbs)
 		}
@@ -2098,7 +2288,7 @@ func (s *Server) cpSingleFromD81(cfg config.Config, limits Limits, rootAbs, moun
 
 	if haveUsed && s.usage != nil {
 		used = applyDeltaBytes(used, delta)
-		s.setRootUsageBytes(rootAbs, used)
+		s.adjustRootUsage(rootAbs, delta)
 	}
 
 	return proto.StatusOK, ""
@@ -2193,7 +2383,7 @@ func (s *Server) cpBulkFromD81(cfg config.Config, limits Limits, rootAbs, mountP
 
 		var dstOldTotal uint64
 		if dstSt.Exists && !trashOverwrite {
-			dstOldTotal, _, err = pathSizeBytes(dstAbs)
+			dstOldTotal, _, err = pathSizeBytes(cfg, rootAbs, dstAbs)
 			if err != nil {
 				return proto.StatusInternal, err.Error()
 			}
@@ -2204,7 +2394,7 @@ func (s *Server) cpBulkFromD81(cfg config.Config, limits Limits, rootAbs, mountP
 			delta = int64(srcTotal)
 		}
 
-		if delta > 0 && haveUsed && used+uint64(delta) > limits.QuotaBytes {
+		if delta > 0 && haveUsed && !s.fitQuota(cfg, limits, rootAbs, &used, uint64(delta)) {
 			return proto.StatusTooLarge, "quota exceeded"
 		}
 
@@ -2213,14 +2403,19 @@ func (s *Server) cpBulkFromD81(cfg config.Config, limits Limits, rootAbs, mountP
 			return proto.StatusInternal, err.Error()
 		}
 
+		if limits.dryRun.skipAbs(rootAbs, dstAbs) {
+			used = applyDeltaBytes(used, delta)
+			copied++
+			continue
+		}
 		if dstSt.Exists {
 			if trashOverwrite {
 				if _, err := s.moveToTrash(cfg, rootAbs, dstAbs); err != nil {
 					return proto.StatusInternal, err.Error()
 				}
 			} else {
-				if err := os.RemoveAll(dstAbs); err != nil {
-					return proto.StatusInternal, err.Error()
+				if err := fsops.RemoveTree(dstAbs, treeLimits(cfg)); err != nil {
+					return treeErrStatus(err)
 				}
 				s.invalidateRootUsage(rootAbs)
 			}
@@ -2233,7 +2428,7 @@ func (s *Server) cpBulkFromD81(cfg config.Config, limits Limits, rootAbs, mountP
 
 		if haveUsed && s.usage != nil {
 			used = applyDeltaBytes(used, delta)
-			s.setRootUsageBytes(rootAbs, used)
+			s.adjustRootUsage(rootAbs, delta)
 		}
 
 		copied++
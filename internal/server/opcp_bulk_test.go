@@ -0,0 +1,213 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+// mkChain creates /DEEP/L1/.../L<depth> with a 100-byte file in each level.
+func mkChain(t *testing.T, rootAbs string, depth int) {
+	t.Helper()
+	dir := filepath.Join(rootAbs, "DEEP")
+	for i := 0; i <= depth; i++ {
+		if i > 0 {
+			dir = filepath.Join(dir, "L"+strings.Repeat("X", i))
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "F"), make([]byte, 100), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRecursiveOpsTreeBounds(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		edit func(*config.Config)
+		want byte
+	}{
+		{"depth", func(c *config.Config) { c.MaxTreeDepth = 5 }, proto.StatusInvalidPath},
+		{"files", func(c *config.Config) { c.MaxTreeFiles = 15 }, proto.StatusTooLarge},
+		{"bytes", func(c *config.Config) { c.MaxTreeBytes = 1000 }, proto.StatusTooLarge},
+		{"within bounds", func(c *config.Config) { c.MaxTreeDepth, c.MaxTreeFiles, c.MaxTreeBytes = 10, 21, 1100 }, proto.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, cfg, rootAbs := newTestServer(t, tc.edit)
+			// 10 levels below /DEEP, 21 entries, 1100 bytes.
+			mkChain(t, rootAbs, 10)
+
+			st, _, msg := s.dispatch(cfg, Limits{}, proto.OpCP, proto.FlagCP_RECURSIVE, cpPayload("/DEEP", "/COPY"), rootAbs)
+			if st != tc.want {
+				t.Fatalf("CP -r = %s (%s), want %s", statusName(st), msg, statusName(tc.want))
+			}
+			_, err := os.Stat(filepath.Join(rootAbs, "COPY"))
+			if copied := err == nil; copied != (tc.want == proto.StatusOK) {
+				t.Fatalf("after CP -r = %s, /COPY exists: %v", statusName(st), copied)
+			}
+			if err == nil {
+				deepest := filepath.Join(rootAbs, "COPY")
+				for i := 1; i <= 10; i++ {
+					deepest = filepath.Join(deepest, "L"+strings.Repeat("X", i))
+				}
+				if _, err := os.Stat(filepath.Join(deepest, "F")); err != nil {
+					t.Fatalf("deepest file not copied: %v", err)
+				}
+			}
+
+			st, _, msg = s.dispatch(cfg, Limits{}, proto.OpRMDIR, proto.FlagRD_RECURSIVE, pathPayload("/DEEP"), rootAbs)
+			if st != tc.want {
+				t.Fatalf("RMDIR -r = %s (%s), want %s", statusName(st), msg, statusName(tc.want))
+			}
+			// Bounds are checked before anything is removed.
+			_, err = os.Stat(filepath.Join(rootAbs, "DEEP", "LX", "F"))
+			if kept := err == nil; kept != (tc.want != proto.StatusOK) {
+				t.Fatalf("after RMDIR -r = %s, /DEEP/LX/F exists: %v", statusName(st), kept)
+			}
+		})
+	}
+}
+
+func TestRecursiveOpsRejectSymlinkLoop(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	mkChain(t, rootAbs, 3)
+	if err := os.Symlink(filepath.Join(rootAbs, "DEEP"), filepath.Join(rootAbs, "DEEP", "LX", "UP")); err != nil {
+		t.Skip(err)
+	}
+	if st, _, _ := s.dispatch(cfg, Limits{}, proto.OpCP, proto.FlagCP_RECURSIVE, cpPayload("/DEEP", "/COPY"), rootAbs); st != proto.StatusInvalidPath {
+		t.Fatalf("CP -r over a symlink loop = %s, want INVALID_PATH", statusName(st))
+	}
+	if st, _, _ := s.dispatch(cfg, Limits{}, proto.OpRMDIR, proto.FlagRD_RECURSIVE, pathPayload("/DEEP"), rootAbs); st != proto.StatusInvalidPath {
+		t.Fatalf("RMDIR -r over a symlink loop = %s, want INVALID_PATH", statusName(st))
+	}
+	if _, err := os.Stat(filepath.Join(rootAbs, "DEEP", "LX", "F")); err != nil {
+		t.Fatalf("RMDIR -r removed files before failing: %v", err)
+	}
+}
+
+func TestCPRecursiveTreeIntoD81(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	limits := Limits{DiskImagesEnabled: true, DiskImagesWriteEnabled: true}
+	mkImage(t, s, cfg, limits, rootAbs, "/DISK.D81", proto.ImageKindD81)
+	writeFiles(t, rootAbs, map[string]string{
+		"SRC/INTRO.PRG":          "\x01\x08intro",
+		"SRC/GAMES/PONG.PRG":     "\x01\x08pong",
+		"SRC/GAMES/SAVES/HI.SEQ": "hiscores",
+		"SRC/TOOLS/MON.PRG":      "\x01\x08mon",
+	})
+
+	cp := func(flags byte) (byte, string) {
+		st, _, msg := s.dispatch(cfg, limits, proto.OpCP, flags, cpPayload("/SRC", "/DISK.D81"), rootAbs)
+		return st, msg
+	}
+	if st, msg := cp(0); st != proto.StatusIsADir {
+		t.Fatalf("CP of a directory without -r = %s (%s), want IS_A_DIR", statusName(st), msg)
+	}
+	if st, msg := cp(proto.FlagCP_RECURSIVE); st != proto.StatusOK {
+		t.Fatalf("CP -r = %s (%s)", statusName(st), msg)
+	}
+
+	// Every source directory is a partition, two levels deep.
+	for dir, want := range map[string]string{
+		"/DISK.D81":                 "SRC",
+		"/DISK.D81/SRC":             "GAMES INTRO TOOLS",
+		"/DISK.D81/SRC/GAMES":       "PONG SAVES",
+		"/DISK.D81/SRC/GAMES/SAVES": "HI.SEQ",
+		"/DISK.D81/SRC/TOOLS":       "MON",
+	} {
+		if got := strings.Join(lsPages(t, s, cfg, limits, rootAbs, dir, 0, 50), " "); got != want {
+			t.Errorf("LS %s = %q, want %q", dir, got, want)
+		}
+	}
+	for p, want := range map[string]string{
+		"/DISK.D81/SRC/GAMES/PONG":         "\x01\x08pong",
+		"/DISK.D81/SRC/GAMES/SAVES/HI.SEQ": "hiscores",
+	} {
+		st, data, msg := s.dispatch(cfg, limits, proto.OpREAD_RANGE, 0, encode(func(e *proto.Encoder) {
+			_ = e.WriteString(p)
+			e.WriteU32(0)
+			e.WriteU16(uint16(len(want)))
+		}), rootAbs)
+		if st != proto.StatusOK || string(data) != want {
+			t.Errorf("READ_RANGE %s = %s %q (%s), want %q", p, statusName(st), data, msg, want)
+		}
+	}
+
+	// An existing tree is only replaced with overwrite.
+	if err := os.Remove(filepath.Join(rootAbs, "SRC", "TOOLS", "MON.PRG")); err != nil {
+		t.Fatal(err)
+	}
+	if st, msg := cp(proto.FlagCP_RECURSIVE); st != proto.StatusAlreadyExists {
+		t.Fatalf("CP -r over the partition = %s (%s), want ALREADY_EXISTS", statusName(st), msg)
+	}
+	if st, msg := cp(proto.FlagCP_RECURSIVE | proto.FlagCP_OVERWRITE); st != proto.StatusOK {
+		t.Fatalf("CP -r with overwrite = %s (%s)", statusName(st), msg)
+	}
+	if got := lsPages(t, s, cfg, limits, rootAbs, "/DISK.D81/SRC/TOOLS", 0, 50); len(got) != 0 {
+		t.Fatalf("TOOLS after overwrite = %v, want empty", got)
+	}
+}
+
+func TestCPRecursiveTreeIntoD81AutoResize(t *testing.T) {
+	// A new partition is sized for its directory; a 100 KB file only fits
+	// once the image is repacked to grow it.
+	for _, auto := range []bool{false, true} {
+		s, cfg, rootAbs := newTestServer(t, nil)
+		limits := Limits{DiskImagesEnabled: true, DiskImagesWriteEnabled: true, DiskImagesAutoResizeEnabled: auto}
+		mkImage(t, s, cfg, limits, rootAbs, "/DISK.D81", proto.ImageKindD81)
+		writeFiles(t, rootAbs, map[string]string{"SRC/SUB/BIG.SEQ": strings.Repeat("x", 100*1024)})
+		want := proto.StatusTooLarge
+		if auto {
+			want = proto.StatusOK
+		}
+		if st, _, msg := s.dispatch(cfg, limits, proto.OpCP, proto.FlagCP_RECURSIVE, cpPayload("/SRC", "/DISK.D81"), rootAbs); st != want {
+			t.Fatalf("CP -r (auto resize %v) = %s (%s), want %s", auto, statusName(st), msg, statusName(want))
+		}
+		if auto {
+			if got := lsPages(t, s, cfg, limits, rootAbs, "/DISK.D81/SRC/SUB", 0, 50); len(got) != 1 || got[0] != "BIG.SEQ" {
+				t.Fatalf("LS /DISK.D81/SRC/SUB = %v, want BIG.SEQ", got)
+			}
+		}
+	}
+}
+
+func TestCPRecursiveTreeIntoFullD81(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	limits := Limits{DiskImagesEnabled: true, DiskImagesWriteEnabled: true}
+	mkImage(t, s, cfg, limits, rootAbs, "/DISK.D81", proto.ImageKindD81)
+	// 900 KB in two levels does not fit the 3160 free blocks (~790 KB).
+	big := strings.Repeat("x", 150*1024)
+	files := map[string]string{"SRC/A.SEQ": "small"}
+	for i := 0; i < 6; i++ {
+		files[fmt.Sprintf("SRC/SUB/F%d.SEQ", i)] = big
+	}
+	writeFiles(t, rootAbs, files)
+	before, err := os.ReadFile(filepath.Join(rootAbs, "DISK.D81"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, auto := range []bool{false, true} {
+		limits.DiskImagesAutoResizeEnabled = auto
+		st, _, msg := s.dispatch(cfg, limits, proto.OpCP, proto.FlagCP_RECURSIVE, cpPayload("/SRC", "/DISK.D81/TREE"), rootAbs)
+		if st != proto.StatusTooLarge {
+			t.Fatalf("CP -r of 900 KB (auto resize %v) = %s (%s), want TOO_LARGE", auto, statusName(st), msg)
+		}
+		// The partial copy is rolled back.
+		after, err := os.ReadFile(filepath.Join(rootAbs, "DISK.D81"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(after, before) {
+			t.Fatalf("image changed by the failed copy (auto resize %v)", auto)
+		}
+	}
+}
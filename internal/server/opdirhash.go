@@ -0,0 +1,215 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/diskimage"
+	"wicos64-server/internal/fsops"
+	"wicos64-server/internal/proto"
+)
+
+// opDIRHASH returns one value that changes whenever the listing of a
+// directory changes, so a client can compare it instead of diffing LS pages.
+//
+// Payload: path string.
+// Flags: FlagDH_RECURSIVE also hashes all subdirectories (bounded by
+// max_tree_depth / max_tree_files).
+// Response: hash u64, entries u32 (number of hashed entries).
+//
+// The hash is FNV-1a 64 over the (rel_path, type, size, mtime) tuples of all
+// entries, sorted by name (case-insensitive) per directory. Inside a mounted
+// disk image the directory entries are hashed instead (name, type, size,
+// blocks, start track/sector) together with the image mtime; D81 partitions
+// are hashed as entries but not entered.
+func (s *Server) opDIRHASH(cfg config.Config, limits Limits, flags byte, payload []byte, rootAbs string) (byte, []byte, string) {
+	d := proto.NewDecoder(payload)
+	p, err := s.readPathString(cfg, d)
+	if err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	if d.Remaining() != 0 {
+		return proto.StatusBadRequest, nil, "extra bytes in DIRHASH"
+	}
+	recursive := flags&proto.FlagDH_RECURSIVE != 0
+
+	h := fnv.New64a()
+	var n uint64
+	if kind, mountPath, inner, ok := splitDiskImagePath(p); ok && limits.DiskImagesEnabled {
+		if st, msg := dirHashImage(h, &n, rootAbs, kind, mountPath, inner); st != proto.StatusOK {
+			return st, nil, msg
+		}
+	} else {
+		if st, msg := s.dirHashTree(h, &n, cfg, rootAbs, p, recursive); st != proto.StatusOK {
+			return st, nil, msg
+		}
+	}
+
+	e := proto.NewEncoder(12)
+	e.WriteBytes(binary.LittleEndian.AppendUint64(nil, h.Sum64()))
+	e.WriteU32(clampU32(n))
+	return proto.StatusOK, e.Bytes(), ""
+}
+
+// dirHashEntry feeds one (rel_path, type, size, mtime) tuple into h.
+func dirHashEntry(h hash.Hash64, rel string, typ byte, size uint64, mtime int64) {
+	var b [17]byte
+	b[0] = typ
+	binary.LittleEndian.PutUint64(b[1:9], size)
+	binary.LittleEndian.PutUint64(b[9:17], uint64(mtime))
+	_, _ = h.Write([]byte(rel))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write(b[:])
+}
+
+// dirHashTree hashes the host directory p (and its subdirectories when
+// recursive). Symlinks are rejected like in LS_TREE.
+func (s *Server) dirHashTree(h hash.Hash64, n *uint64, cfg config.Config, rootAbs, p string, recursive bool) (byte, string) {
+	baseAbs, err := fsops.ToOSPath(rootAbs, p)
+	if err != nil {
+		return proto.StatusInvalidPath, err.Error()
+	}
+	if err := fsops.LstatNoSymlink(rootAbs, baseAbs, false); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return proto.StatusNotFound, "not found"
+		}
+		return proto.StatusInvalidPath, err.Error()
+	}
+	top, err := os.Stat(baseAbs)
+	if err != nil {
+		return proto.StatusInternal, err.Error()
+	}
+	if !top.IsDir() {
+		return proto.StatusNotADir, "not a directory"
+	}
+
+	lim := treeLimits(cfg)
+	ancestors := []os.FileInfo{top}
+	var walk func(dir, rel string, depth int) error
+	walk = func(dir, rel string, depth int) error {
+		ents, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		sort.SliceStable(ents, func(i, j int) bool {
+			return strings.ToUpper(ents[i].Name()) < strings.ToUpper(ents[j].Name())
+		})
+		for _, e := range ents {
+			info, err := e.Info()
+			if err != nil {
+				return err
+			}
+			if info.Mode()&os.ModeSymlink != 0 {
+				return fsops.ErrSymlinkNotAllowed
+			}
+			*n++
+			if lim.MaxFiles > 0 && *n > lim.MaxFiles {
+				return fsops.ErrTreeTooLarge
+			}
+			relPath := e.Name()
+			if rel != "" {
+				relPath = rel + "/" + e.Name()
+			}
+			if !info.IsDir() {
+				dirHashEntry(h, relPath, 0, uint64(info.Size()), info.ModTime().Unix())
+				continue
+			}
+			dirHashEntry(h, relPath, 1, 0, info.ModTime().Unix())
+			if !recursive {
+				continue
+			}
+			if lim.MaxDepth > 0 && depth+1 > lim.MaxDepth {
+				return fsops.ErrTreeTooDeep
+			}
+			for _, a := range ancestors {
+				if os.SameFile(a, info) {
+					return fsops.ErrTreeCycle
+				}
+			}
+			ancestors = append(ancestors, info)
+			err = walk(filepath.Join(dir, e.Name()), relPath, depth+1)
+			ancestors = ancestors[:len(ancestors)-1]
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(baseAbs, "", 0); err != nil {
+		if errors.Is(err, fs.ErrPermission) {
+			return proto.StatusAccessDenied, "access denied"
+		}
+		return treeErrStatus(err)
+	}
+	return proto.StatusOK, ""
+}
+
+// dirHashImage hashes the directory entries of a mounted disk image. inner
+// may name a D81 partition; other image types only have a root directory.
+func dirHashImage(h hash.Hash64, n *uint64, rootAbs, kind, mountPath, inner string) (byte, string) {
+	inner = strings.Trim(inner, "/")
+	if inner != "" && kind != "d81" {
+		return proto.StatusNotADir, "not a directory"
+	}
+
+	var files []*diskimage.FileEntry
+	var mtime int64
+	switch kind {
+	case "d64":
+		_, img, st, msg := resolveD64Mount(rootAbs, mountPath)
+		if st != proto.StatusOK {
+			return st, msg
+		}
+		files, mtime = img.SortedEntries(), img.ModTime.Unix()
+	case "d71":
+		_, img, st, msg := resolveD71Mount(rootAbs, mountPath)
+		if st != proto.StatusOK {
+			return st, msg
+		}
+		files, mtime = img.SortedEntries(), img.ModTime.Unix()
+	case "d81":
+		_, img, st, msg := resolveD81Mount(rootAbs, mountPath)
+		if st != proto.StatusOK {
+			return st, msg
+		}
+		ents, _, _, _, st, msg := resolveD81Dir(img, inner)
+		if st != proto.StatusOK {
+			return st, msg
+		}
+		files = append([]*diskimage.FileEntry(nil), ents...)
+		sort.SliceStable(files, func(i, j int) bool {
+			return strings.ToUpper(files[i].Name) < strings.ToUpper(files[j].Name)
+		})
+		mtime = img.ModTime.Unix()
+	case "t64":
+		_, img, st, msg := resolveT64Mount(rootAbs, mountPath)
+		if st != proto.StatusOK {
+			return st, msg
+		}
+		files, mtime = img.SortedEntries(), img.ModTime.Unix()
+	case "d82":
+		_, img, st, msg := resolveD82Mount(rootAbs, mountPath)
+		if st != proto.StatusOK {
+			return st, msg
+		}
+		files, mtime = img.SortedEntries(), img.ModTime.Unix()
+	}
+
+	for _, fe := range files {
+		*n++
+		dirHashEntry(h, fe.Name, fe.Type, fe.Size, mtime)
+		var b [6]byte
+		binary.LittleEndian.PutUint32(b[0:4], uint32(fe.Blocks))
+		b[4], b[5] = fe.StartTrack, fe.StartSector
+		_, _ = h.Write(b[:])
+	}
+	return proto.StatusOK, ""
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/fsops"
+	"wicos64-server/internal/proto"
+)
+
+// opDIRMTIME is a cheap change-polling primitive.
+//
+// Payload: path string.
+// Response: dir_mtime u32, max_mtime u32, entries u16, flags u8.
+//
+// max_mtime is the newest mtime of the directory itself and its immediate
+// entries. flags bit0 = scan truncated: the directory has more entries than
+// max_tree_files (at most 0xFFFF, the width of entries); the client should
+// fall back to LS.
+// For mounted disk images both values are the image file's mtime.
+func (s *Server) opDIRMTIME(cfg config.Config, limits Limits, payload []byte, rootAbs string) (byte, []byte, string) {
+	d := proto.NewDecoder(payload)
+	p, err := s.readPathString(cfg, d)
+	if err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	if d.Remaining() != 0 {
+		return proto.StatusBadRequest, nil, "extra bytes in DIRMTIME"
+	}
+
+	if limits.DiskImagesEnabled {
+		if kind, mountPath, _, ok := splitDiskImagePath(p); ok {
+			mtime, st, msg := resolveDiskImageMountModTime(rootAbs, kind, mountPath)
+			if st != proto.StatusOK {
+				return st, nil, msg
+			}
+			e := proto.NewEncoder(11)
+			e.WriteU32(mtime)
+			e.WriteU32(mtime)
+			e.WriteU16(0)
+			e.WriteU8(0)
+			return proto.StatusOK, e.Bytes(), ""
+		}
+	}
+
+	abs, err := fsops.ToOSPath(rootAbs, p)
+	if err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	if err := fsops.LstatNoSymlink(rootAbs, abs, false); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return proto.StatusNotFound, nil, "not found"
+		}
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	st, err := fsops.Stat(abs)
+	if err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+	if !st.Exists {
+		return proto.StatusNotFound, nil, "not found"
+	}
+	if !st.IsDir {
+		return proto.StatusNotADir, nil, "not a directory"
+	}
+
+	dir, err := os.Open(abs)
+	if err != nil {
+		if errors.Is(err, fs.ErrPermission) {
+			return proto.StatusAccessDenied, nil, "access denied"
+		}
+		return proto.StatusInternal, nil, err.Error()
+	}
+	defer dir.Close()
+
+	// ReadDir(n) returns entries in directory order (no sorting needed here).
+	maxScan := 0xFFFF
+	if cfg.MaxTreeFiles > 0 && cfg.MaxTreeFiles < uint64(maxScan) {
+		maxScan = int(cfg.MaxTreeFiles)
+	}
+	entries, err := dir.ReadDir(maxScan + 1)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return proto.StatusInternal, nil, err.Error()
+	}
+	var flags byte
+	if len(entries) > maxScan {
+		entries = entries[:maxScan]
+		flags |= 0x01
+	}
+
+	maxMTime := st.MTimeUnix
+	for _, ent := range entries {
+		info, err := ent.Info()
+		if err != nil {
+			// Entry vanished between ReadDir and Info; ignore.
+			continue
+		}
+		if info.ModTime().IsZero() {
+			continue
+		}
+		if mt := uint32(info.ModTime().Unix()); mt > maxMTime {
+			maxMTime = mt
+		}
+	}
+
+	e := proto.NewEncoder(11)
+	e.WriteU32(st.MTimeUnix)
+	e.WriteU32(maxMTime)
+	e.WriteU16(uint16(len(entries)))
+	e.WriteU8(flags)
+	return proto.StatusOK, e.Bytes(), ""
+}
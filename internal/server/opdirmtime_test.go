@@ -0,0 +1,46 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"wicos64-server/internal/proto"
+)
+
+func TestDIRMTIMETruncatesAtMaxTreeFiles(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	for i := 0; i < 5; i++ {
+		if err := os.WriteFile(filepath.Join(rootAbs, fmt.Sprintf("F%d", i)), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		max       uint64
+		entries   uint16
+		truncated bool
+	}{
+		{"above the limit", 3, 3, true},
+		{"at the limit", 5, 5, false},
+		{"unlimited", 0, 5, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := cfg
+			cfg.MaxTreeFiles = tt.max
+			st, resp, msg := s.opDIRMTIME(cfg, Limits{}, pathPayload("/"), rootAbs)
+			if st != proto.StatusOK || len(resp) != 11 {
+				t.Fatalf("DIRMTIME = %s % X (%s)", statusName(st), resp, msg)
+			}
+			d := proto.NewDecoder(resp[8:])
+			n, _ := d.ReadU16()
+			flags, _ := d.ReadU8()
+			if n != tt.entries || (flags&0x01 != 0) != tt.truncated {
+				t.Fatalf("entries=%d flags=%#x, want entries=%d truncated=%v", n, flags, tt.entries, tt.truncated)
+			}
+		})
+	}
+}
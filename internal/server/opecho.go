@@ -0,0 +1,52 @@
+package server
+
+import (
+	"time"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+// echoMaxDelayMs caps the ECHO delay so a client cannot park handlers for long.
+const echoMaxDelayMs = 2000
+
+// opECHO is a diagnostic op for latency and timeout testing: it waits for
+// the requested delay (capped at echoMaxDelayMs) and returns the data
+// unchanged. Requires cfg.EnableEcho. The wait ends early with BUSY when the
+// client goes away or the server shuts down.
+//
+// Payload: delay_ms u16, data[] (rest of payload).
+// Response: applied_delay_ms u16, data[].
+func (s *Server) opECHO(cfg config.Config, limits Limits, payload []byte) (byte, []byte, string) {
+	if !cfg.EnableEcho {
+		return proto.StatusNotSupported, nil, "ECHO disabled"
+	}
+	d := proto.NewDecoder(payload)
+	delay, err := d.ReadU16()
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	data, _ := d.ReadBytes(d.Remaining())
+	if 2+len(data) > int(cfg.MaxPayload) {
+		return proto.StatusTooLarge, nil, "ECHO data exceeds max_payload"
+	}
+	if delay > echoMaxDelayMs {
+		delay = echoMaxDelayMs
+	}
+	if delay > 0 {
+		t := time.NewTimer(time.Duration(delay) * time.Millisecond)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-limits.canceled():
+			return proto.StatusBusy, nil, "request canceled"
+		case <-s.down.stop:
+			return proto.StatusBusy, nil, "server shutting down"
+		}
+	}
+
+	e := proto.NewEncoder(2 + len(data))
+	e.WriteU16(delay)
+	e.WriteBytes(data)
+	return proto.StatusOK, e.Bytes(), ""
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+func echoPayload(delayMs uint16, data string) []byte {
+	e := proto.NewEncoder(2 + len(data))
+	e.WriteU16(delayMs)
+	e.WriteBytes([]byte(data))
+	return e.Bytes()
+}
+
+func TestECHOStopsWaitingWhenCanceled(t *testing.T) {
+	s, cfg, _ := newTestServer(t, func(c *config.Config) { c.EnableEcho = true })
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	status, _, errMsg := s.opECHO(cfg, Limits{reqCtx: ctx}, echoPayload(echoMaxDelayMs, "x"))
+	if status != proto.StatusBusy {
+		t.Fatalf("status = %s (%s), want BUSY", statusName(status), errMsg)
+	}
+	if el := time.Since(start); el > time.Second {
+		t.Fatalf("ECHO returned after %v, want right after the cancel", el)
+	}
+}
+
+func TestECHOReturnsData(t *testing.T) {
+	s, cfg, _ := newTestServer(t, func(c *config.Config) { c.EnableEcho = true })
+	status, resp, errMsg := s.opECHO(cfg, Limits{}, echoPayload(0, "hello"))
+	if status != proto.StatusOK || string(resp[2:]) != "hello" {
+		t.Fatalf("ECHO = %s %q (%s)", statusName(status), resp, errMsg)
+	}
+}
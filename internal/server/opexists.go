@@ -0,0 +1,77 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/fsops"
+	"wicos64-server/internal/proto"
+)
+
+// opEXISTS_EXACT checks whether the literal path exists. Unlike STAT it never
+// applies the compat .PRG fallback and never accepts wildcards, so a client
+// can tell "/DEMO exists" from "/DEMO resolves to /DEMO.PRG" before it
+// writes the exact name.
+//
+// Payload: path string. Response (as STAT): type u8 (0 file, 1 dir),
+// size u32, mtime u32. A missing path yields NOT_FOUND.
+func (s *Server) opEXISTS_EXACT(cfg config.Config, limits Limits, payload []byte, rootAbs string) (byte, []byte, string) {
+	d := proto.NewDecoder(payload)
+	p, err := s.readPathString(cfg, d)
+	if err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	if d.Remaining() != 0 {
+		return proto.StatusBadRequest, nil, "extra bytes in EXISTS_EXACT"
+	}
+
+	stat := func(typ byte, size uint64, mtime uint32) (byte, []byte, string) {
+		e := proto.NewEncoder(9)
+		e.WriteU8(typ)
+		e.WriteU32(clampU32(size))
+		e.WriteU32(mtime)
+		return proto.StatusOK, e.Bytes(), ""
+	}
+
+	if kind, mountPath, inner, ok := splitDiskImagePath(p); ok && limits.DiskImagesEnabled {
+		mtime, st, msg := resolveDiskImageMountModTime(rootAbs, kind, mountPath)
+		if st != proto.StatusOK {
+			return st, nil, msg
+		}
+		if inner == "" {
+			return stat(1, 0, mtime)
+		}
+		_, fe, st, msg := resolveDiskImageFile(rootAbs, kind, mountPath, inner, false)
+		switch st {
+		case proto.StatusOK:
+			return stat(0, fe.Size, mtime)
+		case proto.StatusIsADir:
+			return stat(1, 0, mtime)
+		default:
+			return st, nil, msg
+		}
+	}
+
+	abs, err := fsops.ToOSPath(rootAbs, p)
+	if err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	if err := fsops.LstatNoSymlink(rootAbs, abs, false); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return proto.StatusNotFound, nil, "not found"
+		}
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	st, err := fsops.Stat(abs)
+	if err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+	if !st.Exists {
+		return proto.StatusNotFound, nil, "not found"
+	}
+	if st.IsDir {
+		return stat(1, 0, st.MTimeUnix)
+	}
+	return stat(0, st.Size, st.MTimeUnix)
+}
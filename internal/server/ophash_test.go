@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+func TestHASHInsideImages(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	limits := Limits{DiskImagesEnabled: true, DiskImagesWriteEnabled: true}
+	data := bytes.Repeat([]byte("WICOS64 "), 100)
+
+	sha := sha256.Sum256(data)
+	tests := []struct {
+		name  string
+		flags byte
+		want  []byte
+	}{
+		{"crc32", 0, binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(data))},
+		{"sha256", proto.FlagH_SHA256, sha[:]},
+	}
+
+	for _, img := range []struct {
+		path string
+		kind byte
+	}{
+		{"/DISK.D64", proto.ImageKindD64},
+		{"/DISK.D71", proto.ImageKindD71},
+		{"/DISK.D81", proto.ImageKindD81},
+	} {
+		mkImage(t, s, cfg, limits, rootAbs, img.path, img.kind)
+		file := img.path + "/FILE"
+		wr := writeRangePayload(t, file+".PRG", 0, data)
+		if st, _, msg := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, proto.FlagWR_CREATE|proto.FlagWR_TRUNCATE, wr, rootAbs); st != proto.StatusOK {
+			t.Fatalf("WRITE_RANGE %s = %s (%s)", file, statusName(st), msg)
+		}
+
+		for _, tt := range tests {
+			t.Run(img.path[1:]+"/"+tt.name, func(t *testing.T) {
+				st, got, msg := s.dispatch(cfg, limits, proto.OpHASH, tt.flags, pathPayload(file), rootAbs)
+				if st != proto.StatusOK || !bytes.Equal(got, tt.want) {
+					t.Fatalf("HASH = %s % X (%s), want % X", statusName(st), got, msg, tt.want)
+				}
+			})
+		}
+		if st, _, _ := s.dispatch(cfg, limits, proto.OpHASH, 0, pathPayload(img.path), rootAbs); st != proto.StatusIsADir {
+			t.Fatalf("HASH of the image root = %s, want IS_A_DIR", statusName(st))
+		}
+	}
+}
+
+// mkImage creates an empty disk image with MKIMAGE.
+func mkImage(t *testing.T, s *Server, cfg config.Config, limits Limits, rootAbs, p string, kind byte) {
+	t.Helper()
+	e := proto.NewEncoder(32)
+	_ = e.WriteString(p)
+	e.WriteU8(kind)
+	_ = e.WriteString("TEST")
+	_ = e.WriteString("")
+	if st, _, msg := s.dispatch(cfg, limits, proto.OpMKIMAGE, 0, e.Bytes(), rootAbs); st != proto.StatusOK {
+		t.Fatalf("MKIMAGE %s = %s (%s)", p, statusName(st), msg)
+	}
+}
+
+func TestHASHCRC16Vector(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	limits := Limits{DiskImagesEnabled: true, DiskImagesWriteEnabled: true}
+	// CRC-16/CCITT-FALSE check value: "123456789" -> 0x29B1, sent LE.
+	vector := []byte("123456789")
+	want := []byte{0xB1, 0x29}
+
+	mkImage(t, s, cfg, limits, rootAbs, "/DISK.D64", proto.ImageKindD64)
+	mkImage(t, s, cfg, limits, rootAbs, "/DISK.D71", proto.ImageKindD71)
+	mkImage(t, s, cfg, limits, rootAbs, "/DISK.D81", proto.ImageKindD81)
+	for _, p := range []string{"/V.SEQ", "/DISK.D64/V.PRG", "/DISK.D71/V.PRG", "/DISK.D81/V.PRG"} {
+		wr := writeRangePayload(t, p, 0, vector)
+		if st, _, msg := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, proto.FlagWR_CREATE|proto.FlagWR_TRUNCATE, wr, rootAbs); st != proto.StatusOK {
+			t.Fatalf("WRITE_RANGE %s = %s (%s)", p, statusName(st), msg)
+		}
+	}
+	for _, p := range []string{"/V.SEQ", "/DISK.D64/V", "/DISK.D71/V", "/DISK.D81/V"} {
+		st, got, msg := s.dispatch(cfg, limits, proto.OpHASH, proto.FlagH_CRC16, pathPayload(p), rootAbs)
+		if st != proto.StatusOK || !bytes.Equal(got, want) {
+			t.Errorf("HASH crc16 %s = %s % X (%s), want % X", p, statusName(st), got, msg, want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"sort"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/fsops"
+	"wicos64-server/internal/proto"
+)
+
+// IMAGE_CHANGES index states.
+const (
+	imageChangesCurrent = 0 // index matches the image file
+	imageChangesNoIndex = 1 // nothing recorded for this image yet
+	imageChangesStale   = 2 // image was changed outside of the server
+)
+
+// opIMAGE_CHANGES lists the inner files of a disk image that were changed
+// through this server since a given time, using the sidecar index kept with
+// config image_change_index.
+//
+// Payload: image path string (the mount path, e.g. /BACKUP.D81), since u32
+// (unix seconds, entries with changed >= since are returned), start_index u16.
+// Response: image_mtime u32, index_state u8 (0 = current, 1 = no index,
+// 2 = stale: the image file was modified outside of the server, do a full
+// sync), count u16, entries[], next_index u16 (0xFFFF = end):
+//
+//	flags u8 (bit0 = deleted, bit1 = directory), changed u32, path string
+//	(inner path, "/"-separated)
+//
+// Directory renames/removals are reported for the directory and for the
+// entries already known below it.
+func (s *Server) opIMAGE_CHANGES(cfg config.Config, limits Limits, payload []byte, rootAbs string) (byte, []byte, string) {
+	d := proto.NewDecoder(payload)
+	p, err := s.readPathString(cfg, d)
+	if err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	since, err := d.ReadU32()
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	start, err := d.ReadU16()
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	if d.Remaining() != 0 {
+		return proto.StatusBadRequest, nil, "extra bytes in IMAGE_CHANGES"
+	}
+	if !cfg.ImageChangeIndex {
+		return proto.StatusNotSupported, nil, "image change index disabled"
+	}
+	if !limits.DiskImagesEnabled {
+		return proto.StatusNotSupported, nil, "disk images disabled"
+	}
+	kind, mountPath, inner, ok := splitDiskImagePath(p)
+	if !ok || inner != "" {
+		return proto.StatusInvalidPath, nil, "not a disk image"
+	}
+	mtime, st, msg := resolveDiskImageMountModTime(rootAbs, kind, mountPath)
+	if st != proto.StatusOK {
+		return st, nil, msg
+	}
+	imgAbs, err := fsops.ToOSPath(rootAbs, mountPath)
+	if err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	fi, err := os.Stat(imgAbs)
+	if err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+
+	type change struct {
+		path string
+		imageIndexEntry
+	}
+	var list []change
+	state := byte(imageChangesCurrent)
+	idx, err := s.imgIndex.load(cfg.ImageChangeIndexDir, imgAbs)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		state = imageChangesNoIndex
+	case err != nil:
+		return proto.StatusInternal, nil, err.Error()
+	default:
+		if idx.ModTimeNs != fi.ModTime().UnixNano() || idx.Size != fi.Size() {
+			state = imageChangesStale
+		}
+		for k, e := range idx.Entries {
+			if e.Changed >= int64(since) {
+				list = append(list, change{path: k, imageIndexEntry: e})
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Changed != list[j].Changed {
+			return list[i].Changed < list[j].Changed
+		}
+		return list[i].path < list[j].path
+	})
+
+	hdr := proto.NewEncoder(7)
+	hdr.WriteU32(mtime)
+	hdr.WriteU8(state)
+	hdr.WriteU16(0) // count placeholder
+	buf := append(make([]byte, 0, 256), hdr.Bytes()...)
+	count := uint16(0)
+	pos := int(start)
+	for pos < len(list) {
+		c := list[pos]
+		var fl byte
+		if c.Deleted {
+			fl |= 1
+		}
+		if c.Dir {
+			fl |= 2
+		}
+		enc := proto.NewEncoder(8 + len(c.path))
+		enc.WriteU8(fl)
+		enc.WriteU32(uint32(c.Changed))
+		if err := enc.WriteString(c.path); err != nil {
+			return proto.StatusInternal, nil, err.Error()
+		}
+		// +2 for next_index u16 at end
+		if len(buf)+len(enc.Bytes())+2 > int(cfg.MaxPayload) || count == 0xFFFF {
+			break
+		}
+		buf = append(buf, enc.Bytes()...)
+		pos++
+		count++
+	}
+	if count == 0 && pos < len(list) {
+		return proto.StatusTooLarge, nil, "entry does not fit max_payload"
+	}
+
+	nextIndex := uint16(0xFFFF)
+	if pos < len(list) {
+		nextIndex = uint16(pos)
+	}
+	buf = proto.AppendU16(buf, nextIndex)
+	binary.LittleEndian.PutUint16(buf[5:7], count)
+	return proto.StatusOK, buf, ""
+}
@@ -0,0 +1,253 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/diskimage"
+	"wicos64-server/internal/fsops"
+	"wicos64-server/internal/proto"
+)
+
+// imageInfo describes one disk image file for IMAGES and /admin/api/images.
+type imageInfo struct {
+	Path       string `json:"path"` // W64 path
+	Kind       string `json:"kind"`
+	Size       uint64 `json:"size"`
+	FreeBlocks int    `json:"free_blocks"` // -1 if unknown (T64, unreadable)
+	Busy       bool   `json:"busy"`
+	Locked     bool   `json:"locked"`
+	LastOp     string `json:"last_op,omitempty"`
+	LastUnixMs int64  `json:"last_unix_ms,omitempty"`
+
+	kind byte
+	age  uint32 // seconds since LastUnixMs, 0xFFFFFFFF = none
+}
+
+// imageExtKinds maps the image file extensions to their IMAGES kind.
+var imageExtKinds = map[string]byte{
+	".D64": proto.ImageKindD64,
+	".D71": proto.ImageKindD71,
+	".D81": proto.ImageKindD81,
+	".T64": proto.ImageKindT64,
+	".D82": proto.ImageKindD82,
+}
+
+// isDiskImageName reports whether name has one of the mountable disk image
+// extensions (any case).
+func isDiskImageName(name string) bool {
+	_, ok := imageExtKinds[strings.ToUpper(filepath.Ext(name))]
+	return ok
+}
+
+// errImagesPageFull stops the scan once the page is complete.
+var errImagesPageFull = errors.New("images page full")
+
+// collectImages lists the disk image files in the directory base (W64 path)
+// of rootAbs, with subdirectories if recursive, in LS order (by upper case
+// name, depth first). It skips the first start images and returns up to limit;
+// next is the index of the following image, or -1 if there is none.
+//
+// The scan only reads directories: the image files of the returned page are
+// the only ones that are opened (for free_blocks). Symlinks are skipped.
+// Depth and the number of entries visited are bounded by max_tree_depth and
+// max_tree_files.
+func (s *Server) collectImages(cfg config.Config, rootAbs, base string, recursive bool, start, limit int) (page []imageInfo, next int, status byte, msg string) {
+	baseAbs, err := fsops.ToOSPath(rootAbs, base)
+	if err != nil {
+		return nil, -1, proto.StatusInvalidPath, err.Error()
+	}
+	if err := fsops.LstatNoSymlink(rootAbs, baseAbs, false); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, -1, proto.StatusNotFound, "not found"
+		}
+		return nil, -1, proto.StatusInvalidPath, err.Error()
+	}
+	st, err := fsops.Stat(baseAbs)
+	if err != nil {
+		return nil, -1, proto.StatusInternal, err.Error()
+	}
+	if !st.Exists {
+		return nil, -1, proto.StatusNotFound, "not found"
+	}
+	if !st.IsDir {
+		return nil, -1, proto.StatusNotADir, "not a directory"
+	}
+
+	next = -1
+	index := 0
+	visited := uint64(0)
+	var walk func(dirAbs, dir string, depth int) error
+	walk = func(dirAbs, dir string, depth int) error {
+		ents, err := os.ReadDir(dirAbs)
+		if err != nil {
+			return err
+		}
+		sort.SliceStable(ents, func(i, j int) bool {
+			return strings.ToUpper(ents[i].Name()) < strings.ToUpper(ents[j].Name())
+		})
+		for _, e := range ents {
+			visited++
+			if cfg.MaxTreeFiles > 0 && visited > cfg.MaxTreeFiles {
+				return fsops.ErrTreeTooLarge
+			}
+			if e.Type()&os.ModeSymlink != 0 {
+				continue
+			}
+			if e.IsDir() {
+				if recursive && (cfg.MaxTreeDepth <= 0 || depth+1 < cfg.MaxTreeDepth) {
+					if err := walk(filepath.Join(dirAbs, e.Name()), path.Join(dir, e.Name()), depth+1); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			kind, ok := imageExtKinds[strings.ToUpper(filepath.Ext(e.Name()))]
+			if !ok || !e.Type().IsRegular() {
+				continue
+			}
+			index++
+			if index <= start {
+				continue
+			}
+			if len(page) >= limit {
+				next = index - 1
+				return errImagesPageFull
+			}
+			page = append(page, imageInfo{Path: path.Join(dir, e.Name()), Kind: imageKindName(kind), kind: kind})
+		}
+		return nil
+	}
+	if err := walk(baseAbs, base, 0); err != nil && !errors.Is(err, errImagesPageFull) {
+		switch {
+		case errors.Is(err, fsops.ErrTreeTooLarge):
+			return nil, -1, proto.StatusTooLarge, "too many entries to scan (max_tree_files)"
+		case errors.Is(err, fs.ErrPermission):
+			return nil, -1, proto.StatusAccessDenied, "access denied"
+		default:
+			return nil, -1, proto.StatusInternal, err.Error()
+		}
+	}
+
+	for i := range page {
+		s.fillImageInfo(rootAbs, &page[i])
+	}
+	return page, next, proto.StatusOK, ""
+}
+
+// fillImageInfo adds size, free blocks and the busy/lock state to an entry
+// of collectImages.
+func (s *Server) fillImageInfo(rootAbs string, ii *imageInfo) {
+	ii.FreeBlocks = -1
+	ii.age = 0xFFFFFFFF
+	abs, err := fsops.ToOSPath(rootAbs, ii.Path)
+	if err != nil {
+		return
+	}
+	if fi, err := os.Stat(abs); err == nil {
+		ii.Size = uint64(fi.Size())
+	}
+	if n, err := diskimage.FreeBlocks(abs); err == nil {
+		ii.FreeBlocks = n
+	}
+	ii.Locked = s.paths.held(pathLockKey(abs))
+	if ia, ok := s.images.get(abs); ok {
+		ii.Busy = ia.running > 0
+		ii.LastOp = ia.lastOp
+		ii.LastUnixMs = ia.last.UnixMilli()
+		ii.age = clampU32(uint64(max(int64(time.Since(ia.last).Seconds()), 0)))
+	}
+}
+
+// opIMAGES lists the disk images below a directory with their state, so
+// clients sharing images see which ones are being rewritten (a D81 repack
+// can take a while) before they write to them.
+//
+// Payload: path string (directory), start_index u16.
+// Flags: FlagIM_RECURSIVE includes subdirectories (bounded by max_tree_*).
+// Response: count u16, entries, next_index u16 (0xFFFF = no more):
+//
+//	path string (W64 path), kind u8 (proto.ImageKind*), size u32,
+//	free_blocks u16 (0xFFFF = unknown), flags u8 (proto.ImgBusy, ImgLocked),
+//	last_op_age u32 (seconds since the last image write, 0xFFFFFFFF = none
+//	since the server started)
+//
+// Pages are bounded by max_entries and max_payload; the client continues
+// at next_index.
+func (s *Server) opIMAGES(cfg config.Config, limits Limits, flags byte, payload []byte, rootAbs string) (byte, []byte, string) {
+	if !limits.DiskImagesEnabled {
+		return proto.StatusNotSupported, nil, "disk images are disabled"
+	}
+	d := proto.NewDecoder(payload)
+	base, err := s.readPathString(cfg, d)
+	if err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	start, err := d.ReadU16()
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	if d.Remaining() != 0 {
+		return proto.StatusBadRequest, nil, "extra bytes in IMAGES"
+	}
+	if isInsideDiskImage(limits, base) {
+		return proto.StatusNotSupported, nil, "IMAGES is not supported inside disk images"
+	}
+
+	limit := 0xFFFE
+	if cfg.MaxEntries > 0 {
+		limit = int(cfg.MaxEntries)
+	}
+	page, next, st, msg := s.collectImages(cfg, rootAbs, base, flags&proto.FlagIM_RECURSIVE != 0, int(start), limit)
+	if st != proto.StatusOK {
+		return st, nil, msg
+	}
+
+	resp := make([]byte, 2, 256) // count u16, patched below
+	count := 0
+	for _, ii := range page {
+		enc := proto.NewEncoder(14 + len(ii.Path))
+		if err := enc.WriteString(ii.Path); err != nil {
+			return proto.StatusInternal, nil, err.Error()
+		}
+		enc.WriteU8(ii.kind)
+		enc.WriteU32(clampU32(ii.Size))
+		free := uint16(0xFFFF)
+		if ii.FreeBlocks >= 0 {
+			free = uint16(min(ii.FreeBlocks, 0xFFFE))
+		}
+		enc.WriteU16(free)
+		var fl byte
+		if ii.Busy {
+			fl |= proto.ImgBusy
+		}
+		if ii.Locked {
+			fl |= proto.ImgLocked
+		}
+		enc.WriteU8(fl)
+		enc.WriteU32(ii.age)
+		if len(resp)+len(enc.Bytes())+2 > int(cfg.MaxPayload) {
+			next = int(start) + count
+			break
+		}
+		resp = append(resp, enc.Bytes()...)
+		count++
+	}
+	if count == 0 && len(page) > 0 {
+		return proto.StatusTooLarge, nil, "max_payload too small for IMAGES"
+	}
+	resp[0], resp[1] = byte(count), byte(count>>8)
+	nextIndex := uint16(0xFFFF)
+	if next >= 0 {
+		nextIndex = uint16(min(next, 0xFFFE))
+	}
+	resp = append(resp, byte(nextIndex), byte(nextIndex>>8))
+	return proto.StatusOK, resp, ""
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/diskimage"
+	"wicos64-server/internal/proto"
+)
+
+type imagesEntry struct {
+	path  string
+	kind  byte
+	size  uint32
+	free  uint16
+	flags byte
+	age   uint32
+}
+
+func images(t *testing.T, s *Server, cfg config.Config, limits Limits, rootAbs, dir string) map[string]imagesEntry {
+	t.Helper()
+	st, resp, msg := s.dispatch(cfg, limits, proto.OpIMAGES, 0, encode(func(e *proto.Encoder) {
+		_ = e.WriteString(dir)
+		e.WriteU16(0)
+	}), rootAbs)
+	if st != proto.StatusOK {
+		t.Fatalf("IMAGES %s = %s (%s)", dir, statusName(st), msg)
+	}
+	d := proto.NewDecoder(resp)
+	n, _ := d.ReadU16()
+	out := map[string]imagesEntry{}
+	for i := 0; i < int(n); i++ {
+		var ie imagesEntry
+		ie.path, _ = d.ReadString(cfg.MaxPath)
+		ie.kind, _ = d.ReadU8()
+		ie.size, _ = d.ReadU32()
+		ie.free, _ = d.ReadU16()
+		ie.flags, _ = d.ReadU8()
+		ie.age, _ = d.ReadU32()
+		out[ie.path] = ie
+	}
+	if next, err := d.ReadU16(); err != nil || next != 0xFFFF || d.Remaining() != 0 {
+		t.Fatalf("IMAGES %s: next %#x (%v), %d bytes left", dir, next, err, d.Remaining())
+	}
+	return out
+}
+
+func TestIMAGESBusyWhileWriting(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	limits := Limits{DiskImagesEnabled: true, DiskImagesWriteEnabled: true}
+	if err := os.Mkdir(filepath.Join(rootAbs, "IMG"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	mkImage(t, s, cfg, limits, rootAbs, "/IMG/GAMES.D64", proto.ImageKindD64)
+	mkImage(t, s, cfg, limits, rootAbs, "/IMG/TOOLS.D81", proto.ImageKindD81)
+	if err := os.WriteFile(filepath.Join(rootAbs, "IMG", "README.TXT"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hold the write to GAMES.D64 inside the image code, after WRITE_RANGE
+	// took its path lock, as a long D81 repack would.
+	started, release := make(chan struct{}), make(chan struct{})
+	diskimage.SetOpStartHook(func(op, imgPath string) {
+		s.images.begin(op, imgPath)
+		if filepath.Base(imgPath) == "GAMES.D64" {
+			close(started)
+			<-release
+		}
+	})
+	t.Cleanup(func() { diskimage.SetOpStartHook(s.images.begin) })
+	// Let the write finish even if the test fails while it is held.
+	unblock := sync.OnceFunc(func() { close(release) })
+	t.Cleanup(unblock)
+	done := make(chan byte)
+	go func() {
+		st, _, _ := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, proto.FlagWR_CREATE,
+			writeRangePayload(t, "/IMG/GAMES.D64/HELLO", 0, []byte{1, 8, 0x60}), rootAbs)
+		done <- st
+	}()
+	<-started
+
+	got := images(t, s, cfg, limits, rootAbs, "/IMG")
+	if len(got) != 2 {
+		t.Fatalf("IMAGES /IMG = %v, want the two images", got)
+	}
+	games, tools := got["/IMG/GAMES.D64"], got["/IMG/TOOLS.D81"]
+	if games.kind != proto.ImageKindD64 || games.size != 174848 || games.free != 664 {
+		t.Fatalf("GAMES.D64 = %+v, want a blank D64", games)
+	}
+	if games.flags != proto.ImgBusy|proto.ImgLocked || games.age > 5 {
+		t.Fatalf("GAMES.D64 during the write: flags %#x age %d, want busy and locked", games.flags, games.age)
+	}
+	if tools.kind != proto.ImageKindD81 || tools.flags != 0 {
+		t.Fatalf("TOOLS.D81 = %+v, want an idle D81", tools)
+	}
+
+	// The admin list of written images and the per-token scan agree.
+	w := httptest.NewRecorder()
+	s.handleAdminImages(w, httptest.NewRequest("GET", "/", nil))
+	var active adminActiveImagesResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &active); err != nil {
+		t.Fatal(err)
+	}
+	if len(active.Active) == 0 || filepath.Base(active.Active[0].Image) != "GAMES.D64" ||
+		!active.Active[0].Busy || !active.Active[0].Locked || active.Active[0].LastOp != "WRITE" {
+		t.Fatalf("/admin/api/images = %+v, want GAMES.D64 busy first", active.Active)
+	}
+	w = httptest.NewRecorder()
+	s.handleAdminImages(w, httptest.NewRequest("GET", "/?token_kind=no_auth&path=/IMG", nil))
+	var scan adminImagesResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &scan); err != nil {
+		t.Fatal(err)
+	}
+	if len(scan.Images) != 2 || scan.Images[0].Path != "/IMG/GAMES.D64" || !scan.Images[0].Busy || !scan.Images[0].Locked ||
+		scan.Images[1].Busy || scan.Next != -1 {
+		t.Fatalf("/admin/api/images?path=/IMG = %+v", scan)
+	}
+
+	unblock()
+	if st := <-done; st != proto.StatusOK {
+		t.Fatalf("WRITE_RANGE = %s", statusName(st))
+	}
+	games = images(t, s, cfg, limits, rootAbs, "/IMG")["/IMG/GAMES.D64"]
+	if games.flags != 0 || games.age > 5 || games.free >= 664 {
+		t.Fatalf("GAMES.D64 after the write = %+v, want idle with a recent op and a file", games)
+	}
+}
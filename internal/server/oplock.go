@@ -0,0 +1,204 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/fsops"
+	"wicos64-server/internal/proto"
+)
+
+// Lock files are small text files; the first line marks them as ours so
+// LOCK/UNLOCK never clobber unrelated files.
+const lockFileMagic = "W64LOCK"
+
+type lockInfo struct {
+	holder string
+	age    time.Duration
+	size   int64
+}
+
+// opLOCK takes an advisory lock by creating a lock file with O_EXCL. The file
+// records the holder's token id and creation time; the server does not
+// interpret the locked resource in any way.
+//
+// If the lock is held by another token, ALREADY_EXISTS is returned (errmsg:
+// holder and age). Locks older than lock_ttl_sec are stale and are taken
+// over. LOCK by the current holder refreshes the lock (resets its age).
+//
+// Payload: path string (of the lock file; parent must exist).
+// Response: ttl_sec u32 (0 = the lock never expires).
+func (s *Server) opLOCK(cfg config.Config, limits Limits, payload []byte, rootAbs string) (byte, []byte, string) {
+	release, ok := s.lockWrite(cfg, limits, proto.OpLOCK, payload, rootAbs, false)
+	if !ok {
+		return proto.StatusBusy, nil, "busy"
+	}
+	defer release()
+
+	abs, st, msg := s.lockPathFromPayload(cfg, limits, payload, rootAbs, "LOCK")
+	if st != proto.StatusOK {
+		return st, nil, msg
+	}
+	pst, err := fsops.Stat(filepath.Dir(abs))
+	if err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+	if !pst.Exists || !pst.IsDir {
+		return proto.StatusNotFound, nil, "parent directory missing"
+	}
+
+	ttl := time.Duration(cfg.LockTTLSec) * time.Second
+	content := []byte(fmt.Sprintf("%s\nholder=%s\ncreated=%d\n", lockFileMagic, limits.tokenID, time.Now().Unix()))
+
+	f, err := os.OpenFile(abs, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err == nil {
+		_, werr := f.Write(content)
+		cerr := f.Close()
+		if werr != nil || cerr != nil {
+			_ = os.Remove(abs)
+			return proto.StatusInternal, nil, errors.Join(werr, cerr).Error()
+		}
+		s.adjustRootUsage(rootAbs, int64(len(content)))
+		return proto.StatusOK, lockTTLPayload(cfg), ""
+	}
+	if !errors.Is(err, fs.ErrExist) {
+		if errors.Is(err, fs.ErrPermission) {
+			return proto.StatusAccessDenied, nil, "access denied"
+		}
+		return proto.StatusInternal, nil, err.Error()
+	}
+
+	// Exists: refresh our own lock, take over a stale one, else report the holder.
+	li, st, msg := readLockFile(abs)
+	if st != proto.StatusOK {
+		return st, nil, msg
+	}
+	if li.holder != limits.tokenID && (ttl == 0 || li.age < ttl) {
+		return proto.StatusAlreadyExists, nil, fmt.Sprintf("locked by %s (age %ds)", lockHolderName(li.holder), int64(li.age.Seconds()))
+	}
+	if err := os.WriteFile(abs, content, 0o644); err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+	s.adjustRootUsage(rootAbs, int64(len(content))-li.size)
+	return proto.StatusOK, lockTTLPayload(cfg), ""
+}
+
+// opUNLOCK releases a lock taken with LOCK by deleting its lock file. Only
+// the holder may unlock, unless the lock is stale or FlagUL_FORCE is set.
+//
+// Payload: path string. Response: empty.
+func (s *Server) opUNLOCK(cfg config.Config, limits Limits, flags byte, payload []byte, rootAbs string) (byte, []byte, string) {
+	release, ok := s.lockWrite(cfg, limits, proto.OpUNLOCK, payload, rootAbs, false)
+	if !ok {
+		return proto.StatusBusy, nil, "busy"
+	}
+	defer release()
+
+	abs, st, msg := s.lockPathFromPayload(cfg, limits, payload, rootAbs, "UNLOCK")
+	if st != proto.StatusOK {
+		return st, nil, msg
+	}
+	li, st, msg := readLockFile(abs)
+	if st != proto.StatusOK {
+		return st, nil, msg
+	}
+	ttl := time.Duration(cfg.LockTTLSec) * time.Second
+	stale := ttl > 0 && li.age >= ttl
+	if li.holder != limits.tokenID && !stale && flags&proto.FlagUL_FORCE == 0 {
+		return proto.StatusAccessDenied, nil, fmt.Sprintf("locked by %s", lockHolderName(li.holder))
+	}
+	if err := os.Remove(abs); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return proto.StatusNotFound, nil, "not locked"
+		}
+		return proto.StatusInternal, nil, err.Error()
+	}
+	s.adjustRootUsage(rootAbs, -li.size)
+	return proto.StatusOK, nil, ""
+}
+
+// lockPathFromPayload decodes and validates the lock file path shared by LOCK
+// and UNLOCK.
+func (s *Server) lockPathFromPayload(cfg config.Config, limits Limits, payload []byte, rootAbs, opName string) (abs string, status byte, msg string) {
+	d := proto.NewDecoder(payload)
+	p, err := s.readPathString(cfg, d)
+	if err != nil {
+		return "", proto.StatusInvalidPath, err.Error()
+	}
+	if d.Remaining() != 0 {
+		return "", proto.StatusBadRequest, "extra bytes in " + opName
+	}
+	if p == "/" {
+		return "", proto.StatusInvalidPath, "lock path must name a file"
+	}
+	if _, _, inner, ok := splitDiskImagePath(p); ok && inner != "" {
+		if !limits.DiskImagesEnabled {
+			return "", proto.StatusNotSupported, "disk images are disabled"
+		}
+		return "", proto.StatusNotSupported, opName + " is not supported inside disk images"
+	}
+	abs, err = fsops.ToOSPath(rootAbs, p)
+	if err != nil {
+		return "", proto.StatusInvalidPath, err.Error()
+	}
+	if err := fsops.LstatNoSymlink(rootAbs, abs, true); err != nil {
+		return "", proto.StatusInvalidPath, err.Error()
+	}
+	return abs, proto.StatusOK, ""
+}
+
+// readLockFile parses an existing lock file. Files that were not written by
+// LOCK are refused so they are never overwritten or deleted.
+func readLockFile(abs string) (lockInfo, byte, string) {
+	fi, err := os.Lstat(abs)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return lockInfo{}, proto.StatusNotFound, "not locked"
+		}
+		return lockInfo{}, proto.StatusInternal, err.Error()
+	}
+	if fi.IsDir() {
+		return lockInfo{}, proto.StatusIsADir, "is a directory"
+	}
+	if !fi.Mode().IsRegular() || fi.Size() > 256 {
+		return lockInfo{}, proto.StatusAlreadyExists, "exists and is not a lock file"
+	}
+	b, err := os.ReadFile(abs)
+	if err != nil {
+		return lockInfo{}, proto.StatusInternal, err.Error()
+	}
+	lines := strings.Split(string(b), "\n")
+	if len(lines) == 0 || lines[0] != lockFileMagic {
+		return lockInfo{}, proto.StatusAlreadyExists, "exists and is not a lock file"
+	}
+	li := lockInfo{age: time.Since(fi.ModTime()), size: fi.Size()}
+	if li.age < 0 {
+		li.age = 0
+	}
+	for _, ln := range lines[1:] {
+		if v, ok := strings.CutPrefix(ln, "holder="); ok {
+			li.holder = v
+		}
+	}
+	return li, proto.StatusOK, ""
+}
+
+func lockHolderName(holder string) string {
+	if holder == "" {
+		return "no-auth"
+	}
+	return holder
+}
+
+func lockTTLPayload(cfg config.Config) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, uint32(cfg.LockTTLSec))
+	return b
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/diskimage"
+	"wicos64-server/internal/fsops"
+	"wicos64-server/internal/proto"
+)
+
+// lsTreeEntry is one entry of the flattened LS_TREE listing.
+type lsTreeEntry struct {
+	depth   byte
+	typ     byte
+	size    uint32
+	mtime   uint32
+	name    string
+	relPath string
+}
+
+// errLSTreeEnough stops the walk once the requested page (plus one entry to
+// detect more) has been collected.
+var errLSTreeEnough = errors.New("enough entries")
+
+// opLS_TREE lists a directory hierarchy as a flat, paginated pre-order list,
+// so a client can build a tree view without one LS per directory.
+//
+// Payload: base path string, max_depth u8 (0 -> 4, max 16), start_index u16,
+// max_entries u16 (0 -> max_entries from CAPS).
+// Flags: FlagLT_IMAGES also lists the contents of mounted disk images (one
+// level; D81 partitions are shown but not entered). Otherwise images are
+// listed as directories and not entered.
+// Response: count u16, entries[], next_index u16 (0xFFFF = end):
+//
+//	type u8, size u32, mtime u32, name string (as LS), depth u8 (0 = direct
+//	child of base), rel_path string (relative to base, "/"-separated)
+//
+// Symlinks anywhere in the walked tree are rejected with INVALID_PATH.
+func (s *Server) opLS_TREE(cfg config.Config, limits Limits, flags byte, payload []byte, rootAbs string) (byte, []byte, string) {
+	d := proto.NewDecoder(payload)
+	base, err := s.readPathString(cfg, d)
+	if err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	maxDepth, err := d.ReadU8()
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	start, err := d.ReadU16()
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	maxEntriesReq, err := d.ReadU16()
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	if d.Remaining() != 0 {
+		return proto.StatusBadRequest, nil, "extra bytes in LS_TREE"
+	}
+	if maxDepth == 0 {
+		maxDepth = treeDefaultDepth
+	}
+	if maxDepth > treeMaxDepth {
+		maxDepth = treeMaxDepth
+	}
+	maxEntries := cfg.MaxEntries
+	if maxEntriesReq != 0 && maxEntriesReq < maxEntries {
+		maxEntries = maxEntriesReq
+	}
+	if maxEntries == 0 {
+		maxEntries = 1
+	}
+	withImages := flags&proto.FlagLT_IMAGES != 0 && limits.DiskImagesEnabled
+
+	if isInsideDiskImage(limits, base) {
+		return proto.StatusNotSupported, nil, "LS_TREE is not supported inside disk images"
+	}
+
+	baseAbs, err := fsops.ToOSPath(rootAbs, base)
+	if err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	if err := fsops.LstatNoSymlink(rootAbs, baseAbs, false); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return proto.StatusNotFound, nil, "not found"
+		}
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	st, err := fsops.Stat(baseAbs)
+	if err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+	if !st.Exists {
+		return proto.StatusNotFound, nil, "not found"
+	}
+	if !st.IsDir {
+		return proto.StatusNotADir, nil, "not a directory"
+	}
+
+	// Collect the pre-order list up to the end of the requested page, plus one
+	// entry to know whether there is more. Indices are u16, so the walk never
+	// goes beyond 0xFFFE entries.
+	want := min(int(start)+int(maxEntries)+1, 0xFFFF)
+	var list []lsTreeEntry
+
+	var walk func(dir, rel string, depth int) error
+	walk = func(dir, rel string, depth int) error {
+		ents, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		sort.SliceStable(ents, func(i, j int) bool {
+			return strings.ToUpper(ents[i].Name()) < strings.ToUpper(ents[j].Name())
+		})
+		for _, e := range ents {
+			if len(list) >= want {
+				return errLSTreeEnough
+			}
+			info, err := e.Info()
+			if err != nil {
+				return err
+			}
+			if info.Mode()&os.ModeSymlink != 0 {
+				return fsops.ErrSymlinkNotAllowed
+			}
+			name := strings.ToUpper(e.Name())
+			relPath := name
+			if rel != "" {
+				relPath = rel + "/" + name
+			}
+			isImage := limits.DiskImagesEnabled && !info.IsDir() && isDiskImageName(name)
+			ent := lsTreeEntry{depth: byte(depth), name: name, relPath: relPath}
+			if !info.ModTime().IsZero() {
+				ent.mtime = uint32(info.ModTime().Unix())
+			}
+			if info.IsDir() || isImage {
+				ent.typ = 1
+			} else {
+				ent.size = clampU32(uint64(info.Size()))
+			}
+			list = append(list, ent)
+
+			if depth+1 >= int(maxDepth) {
+				continue
+			}
+			switch {
+			case info.IsDir():
+				if err := walk(filepath.Join(dir, e.Name()), relPath, depth+1); err != nil {
+					return err
+				}
+			case isImage && withImages:
+				if err := lsTreeImage(&list, want, rootAbs, joinProtoPath(base, relPath), relPath, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := walk(baseAbs, "", 0); err != nil && !errors.Is(err, errLSTreeEnough) {
+		switch {
+		case errors.Is(err, fsops.ErrSymlinkNotAllowed):
+			return proto.StatusInvalidPath, nil, "symlink not allowed"
+		case errors.Is(err, fs.ErrPermission):
+			return proto.StatusAccessDenied, nil, "access denied"
+		default:
+			return proto.StatusInternal, nil, err.Error()
+		}
+	}
+
+	if int(start) >= len(list) {
+		e := proto.NewEncoder(4)
+		e.WriteU16(0)
+		e.WriteU16(0xFFFF)
+		return proto.StatusOK, e.Bytes(), ""
+	}
+
+	buf := make([]byte, 2, 256) // count placeholder
+	count := uint16(0)
+	idx := int(start)
+	for idx < len(list) && count < maxEntries {
+		ent := list[idx]
+		enc := proto.NewEncoder(16 + len(ent.name) + len(ent.relPath))
+		enc.WriteU8(ent.typ)
+		enc.WriteU32(ent.size)
+		enc.WriteU32(ent.mtime)
+		if err := enc.WriteString(ent.name); err != nil {
+			return proto.StatusInternal, nil, err.Error()
+		}
+		enc.WriteU8(ent.depth)
+		if err := enc.WriteString(ent.relPath); err != nil {
+			return proto.StatusInternal, nil, err.Error()
+		}
+		// +2 for next_index u16 at end
+		if len(buf)+len(enc.Bytes())+2 > int(cfg.MaxPayload) {
+			break
+		}
+		buf = append(buf, enc.Bytes()...)
+		idx++
+		count++
+	}
+	if count == 0 {
+		return proto.StatusTooLarge, nil, "entry does not fit max_payload"
+	}
+
+	nextIndex := uint16(0xFFFF)
+	if idx < len(list) {
+		nextIndex = uint16(idx)
+	}
+	buf = proto.AppendU16(buf, nextIndex)
+	binary.LittleEndian.PutUint16(buf[0:2], count)
+	return proto.StatusOK, buf, ""
+}
+
+// lsTreeImage appends the root directory entries of the disk image at
+// mountPath to list. Broken images are skipped rather than failing the walk.
+func lsTreeImage(list *[]lsTreeEntry, want int, rootAbs, mountPath, rel string, depth int) error {
+	var files []*diskimage.FileEntry
+	var mtime uint32
+	switch kind, _, _, _ := splitDiskImagePath(mountPath); kind {
+	case "d64":
+		_, img, st, _ := resolveD64Mount(rootAbs, mountPath)
+		if st != proto.StatusOK {
+			return nil
+		}
+		files, mtime = img.SortedEntries(), uint32(img.ModTime.Unix())
+	case "d71":
+		_, img, st, _ := resolveD71Mount(rootAbs, mountPath)
+		if st != proto.StatusOK {
+			return nil
+		}
+		files, mtime = img.SortedEntries(), uint32(img.ModTime.Unix())
+	case "d81":
+		_, img, st, _ := resolveD81Mount(rootAbs, mountPath)
+		if st != proto.StatusOK {
+			return nil
+		}
+		files, mtime = img.SortedEntries(), uint32(img.ModTime.Unix())
+	case "t64":
+		_, img, st, _ := resolveT64Mount(rootAbs, mountPath)
+		if st != proto.StatusOK {
+			return nil
+		}
+		files, mtime = img.SortedEntries(), uint32(img.ModTime.Unix())
+	case "d82":
+		_, img, st, _ := resolveD82Mount(rootAbs, mountPath)
+		if st != proto.StatusOK {
+			return nil
+		}
+		files, mtime = img.SortedEntries(), uint32(img.ModTime.Unix())
+	}
+	for _, fe := range files {
+		if len(*list) >= want {
+			return errLSTreeEnough
+		}
+		name := strings.ToUpper(fe.Name)
+		ent := lsTreeEntry{depth: byte(depth), size: clampU32(fe.Size), mtime: mtime, name: name, relPath: rel + "/" + name}
+		// D81 partitions/subdirectories are shown as DIRs (not entered).
+		if fe.Type == 6 || fe.Type == 5 {
+			ent.typ = 1
+			ent.size = 0
+		}
+		*list = append(*list, ent)
+	}
+	return nil
+}
+
+// joinProtoPath joins a normalized protocol path and a relative path.
+func joinProtoPath(base, rel string) string {
+	if base == "/" || base == "" {
+		return "/" + rel
+	}
+	return base + "/" + rel
+}
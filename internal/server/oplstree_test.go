@@ -0,0 +1,205 @@
+package server
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+type lsTreeItem struct {
+	typ   byte
+	size  uint32
+	depth byte
+	rel   string
+}
+
+func lsTreePayload(base string, depth byte, start, max uint16) []byte {
+	return encode(func(e *proto.Encoder) {
+		_ = e.WriteString(base)
+		e.WriteU8(depth)
+		e.WriteU16(start)
+		e.WriteU16(max)
+	})
+}
+
+// lsTree runs one LS_TREE page and decodes it.
+func lsTree(t *testing.T, s *Server, cfg config.Config, limits Limits, rootAbs string, flags byte, payload []byte) ([]lsTreeItem, uint16) {
+	t.Helper()
+	st, resp, msg := s.dispatch(cfg, limits, proto.OpLS_TREE, flags, payload, rootAbs)
+	if st != proto.StatusOK {
+		t.Fatalf("LS_TREE = %s (%s)", statusName(st), msg)
+	}
+	d := proto.NewDecoder(resp)
+	n, _ := d.ReadU16()
+	var out []lsTreeItem
+	for i := 0; i < int(n); i++ {
+		var it lsTreeItem
+		it.typ, _ = d.ReadU8()
+		it.size, _ = d.ReadU32()
+		_, _ = d.ReadU32()
+		name, _ := d.ReadString(0xFFFF)
+		it.depth, _ = d.ReadU8()
+		it.rel, _ = d.ReadString(0xFFFF)
+		if !strings.HasSuffix(it.rel, name) {
+			t.Fatalf("entry %d: name %q is not the end of %q", i, name, it.rel)
+		}
+		out = append(out, it)
+	}
+	next, err := d.ReadU16()
+	if err != nil || d.Remaining() != 0 {
+		t.Fatalf("LS_TREE response malformed: %v, %d bytes left", err, d.Remaining())
+	}
+	return out, next
+}
+
+// mkDeepTree creates A/B/.../F with one file per level and a sibling Z.
+func mkDeepTree(t *testing.T, rootAbs string) {
+	t.Helper()
+	dir := rootAbs
+	for _, name := range []string{"A", "B", "C", "D", "E", "F"} {
+		dir = filepath.Join(dir, name)
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "X"+name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(rootAbs, "Z"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLS_TREEDeepNesting(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	mkDeepTree(t, rootAbs)
+
+	all, next := lsTree(t, s, cfg, Limits{}, rootAbs, 0, lsTreePayload("/", 16, 0, 0))
+	var got []string
+	for _, it := range all {
+		got = append(got, it.rel)
+		if want := byte(strings.Count(it.rel, "/")); it.depth != want {
+			t.Errorf("%s depth %d, want %d", it.rel, it.depth, want)
+		}
+		// Files are the X* entries.
+		if isFile := strings.HasPrefix(path.Base(it.rel), "X"); isFile != (it.typ == 0) {
+			t.Errorf("%s type %d", it.rel, it.typ)
+		}
+	}
+	// Pre-order, names sorted within each directory.
+	want := "A A/B A/B/C A/B/C/D A/B/C/D/E A/B/C/D/E/F A/B/C/D/E/F/XF A/B/C/D/E/XE A/B/C/D/XD A/B/C/XC A/B/XB A/XA Z"
+	if strings.Join(got, " ") != want || next != 0xFFFF {
+		t.Fatalf("LS_TREE depth 16 = %s (next %d), want %s", strings.Join(got, " "), next, want)
+	}
+
+	for _, tc := range []struct {
+		depth    byte
+		deepest  byte
+		expected int
+	}{
+		{1, 0, 2},
+		{3, 2, 6},
+		{0, 3, 8}, // default depth 4
+	} {
+		items, _ := lsTree(t, s, cfg, Limits{}, rootAbs, 0, lsTreePayload("/", tc.depth, 0, 0))
+		deepest := byte(0)
+		for _, it := range items {
+			deepest = max(deepest, it.depth)
+		}
+		if len(items) != tc.expected || deepest != tc.deepest {
+			t.Errorf("max_depth %d: %d entries, deepest %d; want %d, %d", tc.depth, len(items), deepest, tc.expected, tc.deepest)
+		}
+	}
+
+	// A base path lists relative to itself.
+	items, _ := lsTree(t, s, cfg, Limits{}, rootAbs, 0, lsTreePayload("/A/B/C/D", 16, 0, 0))
+	if len(items) != 5 || items[0].rel != "E" || items[0].depth != 0 || items[2].rel != "E/F/XF" {
+		t.Fatalf("LS_TREE /A/B/C/D = %+v", items)
+	}
+}
+
+func TestLS_TREEPagination(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	mkDeepTree(t, rootAbs)
+	all, _ := lsTree(t, s, cfg, Limits{}, rootAbs, 0, lsTreePayload("/", 16, 0, 0))
+
+	for _, pageSize := range []uint16{1, 3, 12, 13, 14} {
+		var got []lsTreeItem
+		start := uint16(0)
+		for pages := 0; ; pages++ {
+			if pages > len(all) {
+				t.Fatalf("page size %d: no end after %d pages", pageSize, pages)
+			}
+			items, next := lsTree(t, s, cfg, Limits{}, rootAbs, 0, lsTreePayload("/", 16, start, pageSize))
+			if len(items) > int(pageSize) {
+				t.Fatalf("page size %d: got %d entries", pageSize, len(items))
+			}
+			got = append(got, items...)
+			if next == 0xFFFF {
+				break
+			}
+			if next != start+uint16(len(items)) {
+				t.Fatalf("page size %d: next_index %d after %d+%d", pageSize, next, start, len(items))
+			}
+			start = next
+		}
+		if len(got) != len(all) {
+			t.Fatalf("page size %d: %d entries, want %d", pageSize, len(got), len(all))
+		}
+		for i := range got {
+			if got[i] != all[i] {
+				t.Fatalf("page size %d: entry %d = %+v, want %+v", pageSize, i, got[i], all[i])
+			}
+		}
+	}
+
+	// Past the end: an empty last page.
+	if items, next := lsTree(t, s, cfg, Limits{}, rootAbs, 0, lsTreePayload("/", 16, uint16(len(all)), 0)); len(items) != 0 || next != 0xFFFF {
+		t.Fatalf("LS_TREE past the end = %d entries, next %d", len(items), next)
+	}
+
+	// max_payload cuts a page short; next_index continues where it stopped.
+	small := cfg
+	small.MaxPayload = 64
+	items, next := lsTree(t, s, small, Limits{}, rootAbs, 0, lsTreePayload("/", 16, 0, 0))
+	if len(items) == 0 || len(items) >= len(all) || next != uint16(len(items)) {
+		t.Fatalf("LS_TREE with max_payload 64 = %d entries, next %d", len(items), next)
+	}
+}
+
+func TestLS_TREESymlinksAndImages(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	limits := Limits{DiskImagesEnabled: true, DiskImagesWriteEnabled: true}
+	if err := os.Mkdir(filepath.Join(rootAbs, "DIR"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	mkImage(t, s, cfg, limits, rootAbs, "/DIR/G.D64", proto.ImageKindD64)
+	if st, _, msg := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, proto.FlagWR_CREATE, writeRangePayload(t, "/DIR/G.D64/PRG1", 0, []byte("abc")), rootAbs); st != proto.StatusOK {
+		t.Fatalf("WRITE_RANGE = %s (%s)", statusName(st), msg)
+	}
+
+	items, _ := lsTree(t, s, cfg, limits, rootAbs, 0, lsTreePayload("/", 16, 0, 0))
+	if len(items) != 2 || items[1].rel != "DIR/G.D64" || items[1].typ != 1 {
+		t.Fatalf("LS_TREE without FlagLT_IMAGES = %+v", items)
+	}
+	items, _ = lsTree(t, s, cfg, limits, rootAbs, proto.FlagLT_IMAGES, lsTreePayload("/", 16, 0, 0))
+	if len(items) != 3 || items[2].rel != "DIR/G.D64/PRG1" || items[2].depth != 2 || items[2].size != 3 {
+		t.Fatalf("LS_TREE with FlagLT_IMAGES = %+v", items)
+	}
+	// The flag needs disk images enabled.
+	if items, _ = lsTree(t, s, cfg, Limits{}, rootAbs, proto.FlagLT_IMAGES, lsTreePayload("/", 16, 0, 0)); len(items) != 2 {
+		t.Fatalf("LS_TREE with images disabled = %+v", items)
+	}
+
+	if err := os.Symlink(rootAbs, filepath.Join(rootAbs, "DIR", "LOOP")); err != nil {
+		t.Skip(err)
+	}
+	if st, _, _ := s.dispatch(cfg, limits, proto.OpLS_TREE, 0, lsTreePayload("/", 16, 0, 0), rootAbs); st != proto.StatusInvalidPath {
+		t.Fatalf("LS_TREE over a symlink = %s, want INVALID_PATH", statusName(st))
+	}
+}
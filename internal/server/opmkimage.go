@@ -0,0 +1,210 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/fsops"
+	"wicos64-server/internal/proto"
+)
+
+// mkimageMaxName is the length of the disk name field in the header.
+const mkimageMaxName = 16
+
+// opMKIMAGE creates a freshly formatted disk image with the given disk name
+// and ID (MKDIR on an image path picks both itself).
+//
+// Payload: path string (must end in the extension of kind), kind u8
+// (proto.ImageKindD64/D71/D81), name string (up to 16 chars, upper-cased),
+// id string (2 chars, empty = "00").
+// Response: size u32 (bytes of the new image file).
+//
+// Flags: FlagMI_OVERWRITE replaces an existing file (it goes to the trash
+// when trash_enabled); without it an existing path is ALREADY_EXISTS. The
+// image is written next to the target and renamed into place.
+func (s *Server) opMKIMAGE(cfg config.Config, limits Limits, flags byte, payload []byte, rootAbs string) (byte, []byte, string) {
+	release, _ := s.lockWrite(cfg, limits, proto.OpMKIMAGE, payload, rootAbs, true)
+	defer release()
+
+	d := proto.NewDecoder(payload)
+	p, err := s.readPathString(cfg, d)
+	if err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	kindByte, err := d.ReadU8()
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	name, err := d.ReadString(0xFFFF)
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	id, err := d.ReadString(0xFFFF)
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	if d.Remaining() != 0 {
+		return proto.StatusBadRequest, nil, "extra bytes in MKIMAGE"
+	}
+
+	if !limits.DiskImagesEnabled {
+		return proto.StatusNotSupported, nil, "disk images are disabled"
+	}
+	if !limits.DiskImagesWriteEnabled {
+		return proto.StatusAccessDenied, nil, "disk image writes are disabled"
+	}
+
+	var kind diskImageKind
+	switch kindByte {
+	case proto.ImageKindD64:
+		kind = diskImageD64
+	case proto.ImageKindD71:
+		kind = diskImageD71
+	case proto.ImageKindD81:
+		kind = diskImageD81
+	default:
+		return proto.StatusBadRequest, nil, "unknown image kind"
+	}
+	if k, ok := detectDiskImageMountRootPath(p); !ok || k != kind {
+		return proto.StatusInvalidPath, nil, "path does not match the image kind"
+	}
+	if hasAnyDiskImageParent(p) {
+		return proto.StatusNotSupported, nil, "images inside images are not supported"
+	}
+	if len(name) > mkimageMaxName {
+		return proto.StatusBadRequest, nil, "disk name longer than 16 chars"
+	}
+	if id != "" && len(id) != 2 {
+		return proto.StatusBadRequest, nil, "disk ID must be 2 chars"
+	}
+	if !printableASCII(name) || !printableASCII(id) {
+		return proto.StatusBadRequest, nil, "disk name and ID must be printable ASCII"
+	}
+
+	abs, err := fsops.ToOSPath(rootAbs, p)
+	if err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	if err := fsops.LstatNoSymlink(rootAbs, abs, true); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return proto.StatusNotFound, nil, "parent directory missing"
+		}
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	st, err := fsops.Stat(abs)
+	if err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+	if st.IsDir {
+		return proto.StatusIsADir, nil, "is a directory"
+	}
+	if st.Exists && flags&proto.FlagMI_OVERWRITE == 0 {
+		return proto.StatusAlreadyExists, nil, "already exists"
+	}
+
+	img, err := emptyDiskImageBytes(kind, name, id)
+	if err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+	newSize := uint64(len(img))
+	if limits.MaxFileBytes > 0 && newSize > limits.MaxFileBytes {
+		return proto.StatusTooLarge, nil, "max file size exceeded"
+	}
+
+	// Same accounting as an image conversion: a trashed file keeps counting.
+	trashOverwrite := cfg.TrashEnabled
+	delta := int64(newSize)
+	if st.Exists && !trashOverwrite {
+		delta -= int64(st.Size)
+	}
+	haveUsed := limits.QuotaBytes > 0 && s.usage != nil
+	if haveUsed && delta > 0 {
+		used, err := s.rootUsageBytes(rootAbs)
+		if err != nil {
+			return proto.StatusInternal, nil, err.Error()
+		}
+		if !s.fitQuota(cfg, limits, rootAbs, &used, uint64(delta)) {
+			return proto.StatusTooLarge, nil, "quota exceeded"
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(abs), ".wicos64-mkimage-*")
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return proto.StatusNotFound, nil, "parent directory missing"
+		}
+		if errors.Is(err, fs.ErrPermission) {
+			return proto.StatusAccessDenied, nil, "access denied"
+		}
+		return proto.StatusInternal, nil, err.Error()
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // no-op once renamed
+	_, err = tmp.Write(img)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+	_ = os.Chmod(tmpName, 0o644)
+
+	if st.Exists && trashOverwrite {
+		if _, err := s.moveToTrash(cfg, rootAbs, abs); err != nil {
+			return proto.StatusInternal, nil, err.Error()
+		}
+	}
+	if err := os.Rename(tmpName, abs); err != nil {
+		s.invalidateRootUsage(rootAbs)
+		return proto.StatusInternal, nil, err.Error()
+	}
+	if haveUsed {
+		s.adjustRootUsage(rootAbs, delta)
+	}
+
+	e := proto.NewEncoder(4)
+	e.WriteU32(uint32(newSize))
+	return proto.StatusOK, e.Bytes(), ""
+}
+
+// printableASCII reports whether s only holds characters 0x20..0x7E, which
+// map 1:1 to PETSCII once upper-cased.
+func printableASCII(s string) bool {
+	return strings.IndexFunc(s, func(r rune) bool { return r < 0x20 || r > 0x7E }) < 0
+}
+
+// imageKindName names an image kind byte (MKIMAGE, IMAGES) for log previews.
+func imageKindName(kind byte) string {
+	switch kind {
+	case proto.ImageKindD64:
+		return "D64"
+	case proto.ImageKindD71:
+		return "D71"
+	case proto.ImageKindD81:
+		return "D81"
+	case proto.ImageKindT64:
+		return "T64"
+	case proto.ImageKindD82:
+		return "D82"
+	default:
+		return fmt.Sprintf("0x%02X", kind)
+	}
+}
+
+// imageKindByName is the reverse of imageKindName (case-insensitive).
+func imageKindByName(name string) (byte, bool) {
+	for _, k := range []byte{proto.ImageKindD64, proto.ImageKindD71, proto.ImageKindD81} {
+		if strings.EqualFold(name, imageKindName(k)) {
+			return k, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"wicos64-server/internal/proto"
+)
+
+func mkimagePayload(p string, kind byte, name, id string) []byte {
+	e := proto.NewEncoder(32)
+	_ = e.WriteString(p)
+	e.WriteU8(kind)
+	_ = e.WriteString(name)
+	_ = e.WriteString(id)
+	return e.Bytes()
+}
+
+func TestMKIMAGEHeader(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	limits := Limits{DiskImagesEnabled: true, DiskImagesWriteEnabled: true}
+
+	pad := func(s string, n int) []byte {
+		return append([]byte(s), bytes.Repeat([]byte{0xA0}, n-len(s))...)
+	}
+	const d64Header = 357 * 256 // 18/0
+	const d81Header = 39 * 40 * 256
+	cases := []struct {
+		path     string
+		kind     byte
+		name, id string
+		size     int
+		checks   map[int][]byte // offset -> bytes
+	}{
+		{"/A.D64", proto.ImageKindD64, "games 1", "g1", 174848, map[int][]byte{
+			d64Header + 0x90: pad("GAMES 1", 16),
+			d64Header + 0xA0: {0xA0, 0xA0, 'G', '1', 0xA0, '2', 'A'},
+			d64Header + 0x03: {0x00},
+		}},
+		{"/B.D71", proto.ImageKindD71, "DOUBLE SIDED 71!", "", 349696, map[int][]byte{
+			d64Header + 0x90: []byte("DOUBLE SIDED 71!"),
+			d64Header + 0xA0: {0xA0, 0xA0, '0', '0', 0xA0, '2', 'A'},
+			d64Header + 0x03: {0x80},
+		}},
+		{"/C.D81", proto.ImageKindD81, "", "zz", 819200, map[int][]byte{
+			d81Header + 0x02: {'D'},
+			d81Header + 0x04: pad("", 16),
+			d81Header + 0x14: {0xA0, 0xA0, 'Z', 'Z', 0xA0, '3', 'D'},
+			// The BAM sectors carry the ID too.
+			d81Header + 256:   {40, 2, 'D', 0xBB, 'Z', 'Z', 0xC0},
+			d81Header + 2*256: {0, 0xFF, 'D', 0xBB, 'Z', 'Z', 0xC0},
+		}},
+	}
+	for _, tc := range cases {
+		st, resp, msg := s.dispatch(cfg, limits, proto.OpMKIMAGE, 0, mkimagePayload(tc.path, tc.kind, tc.name, tc.id), rootAbs)
+		if st != proto.StatusOK || len(resp) != 4 || binary.LittleEndian.Uint32(resp) != uint32(tc.size) {
+			t.Fatalf("MKIMAGE %s = %s (%s) % x", tc.path, statusName(st), msg, resp)
+		}
+		img, err := os.ReadFile(filepath.Join(rootAbs, tc.path[1:]))
+		if err != nil || len(img) != tc.size {
+			t.Fatalf("%s: %d bytes, %v", tc.path, len(img), err)
+		}
+		for off, want := range tc.checks {
+			if got := img[off : off+len(want)]; !bytes.Equal(got, want) {
+				t.Errorf("%s @%#x = % x, want % x", tc.path, off, got, want)
+			}
+		}
+		// Freshly formatted: readable and empty.
+		ls := proto.NewEncoder(16)
+		_ = ls.WriteString(tc.path)
+		ls.WriteU16(0)
+		ls.WriteU16(10)
+		st, resp, msg = s.dispatch(cfg, limits, proto.OpLS, 0, ls.Bytes(), rootAbs)
+		if st != proto.StatusOK || binary.LittleEndian.Uint16(resp) != 0 {
+			t.Errorf("LS %s = %s (%s) % x", tc.path, statusName(st), msg, resp)
+		}
+	}
+
+	refused := []struct {
+		name   string
+		limits Limits
+		flags  byte
+		pl     []byte
+		want   byte
+	}{
+		{"unknown kind", limits, 0, mkimagePayload("/X.D64", 9, "X", ""), proto.StatusBadRequest},
+		{"kind and extension differ", limits, 0, mkimagePayload("/X.D64", proto.ImageKindD81, "X", ""), proto.StatusInvalidPath},
+		{"17-char name", limits, 0, mkimagePayload("/X.D64", proto.ImageKindD64, "ABCDEFGHIJKLMNOPQ", ""), proto.StatusBadRequest},
+		{"3-char ID", limits, 0, mkimagePayload("/X.D64", proto.ImageKindD64, "X", "ABC"), proto.StatusBadRequest},
+		{"1-char ID", limits, 0, mkimagePayload("/X.D64", proto.ImageKindD64, "X", "A"), proto.StatusBadRequest},
+		{"control char", limits, 0, mkimagePayload("/X.D64", proto.ImageKindD64, "X\x01", ""), proto.StatusBadRequest},
+		{"image in an image", limits, 0, mkimagePayload("/C.D81/X.D64", proto.ImageKindD64, "X", ""), proto.StatusNotSupported},
+		{"existing file", limits, 0, mkimagePayload("/A.D64", proto.ImageKindD64, "X", ""), proto.StatusAlreadyExists},
+		{"missing parent", limits, 0, mkimagePayload("/NO/X.D64", proto.ImageKindD64, "X", ""), proto.StatusNotFound},
+		{"images off", Limits{}, 0, mkimagePayload("/X.D64", proto.ImageKindD64, "X", ""), proto.StatusNotSupported},
+		{"images read-only", Limits{DiskImagesEnabled: true}, 0, mkimagePayload("/X.D64", proto.ImageKindD64, "X", ""), proto.StatusAccessDenied},
+		{"max_file_bytes", Limits{DiskImagesEnabled: true, DiskImagesWriteEnabled: true, MaxFileBytes: 174847}, 0, mkimagePayload("/X.D64", proto.ImageKindD64, "X", ""), proto.StatusTooLarge},
+		{"quota", Limits{DiskImagesEnabled: true, DiskImagesWriteEnabled: true, QuotaBytes: 174848*2 + 819200 + 100}, 0, mkimagePayload("/X.D64", proto.ImageKindD64, "X", ""), proto.StatusTooLarge},
+	}
+	for _, tc := range refused {
+		if st, _, msg := s.dispatch(cfg, tc.limits, proto.OpMKIMAGE, tc.flags, tc.pl, rootAbs); st != tc.want {
+			t.Errorf("%s: MKIMAGE = %s (%s), want %s", tc.name, statusName(st), msg, statusName(tc.want))
+		}
+	}
+	if _, err := os.Stat(filepath.Join(rootAbs, "X.D64")); err == nil {
+		t.Error("a refused MKIMAGE left /X.D64")
+	}
+
+	// OVERWRITE reformats in place.
+	if st, _, msg := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, proto.FlagWR_CREATE, writeRangePayload(t, "/A.D64/P", 0, []byte("data")), rootAbs); st != proto.StatusOK {
+		t.Fatalf("WRITE_RANGE = %s (%s)", statusName(st), msg)
+	}
+	if st, _, msg := s.dispatch(cfg, limits, proto.OpMKIMAGE, proto.FlagMI_OVERWRITE, mkimagePayload("/A.D64", proto.ImageKindD64, "NEW", "N1"), rootAbs); st != proto.StatusOK {
+		t.Fatalf("MKIMAGE OVERWRITE = %s (%s)", statusName(st), msg)
+	}
+	img, _ := os.ReadFile(filepath.Join(rootAbs, "A.D64"))
+	if !bytes.Equal(img[d64Header+0x90:d64Header+0xA4], append(pad("NEW", 16), 0xA0, 0xA0, 'N', '1')) {
+		t.Errorf("reformatted header % x", img[d64Header+0x90:d64Header+0xA4])
+	}
+	if st, _, _ := s.dispatch(cfg, limits, proto.OpSTAT, 0, pathPayload("/A.D64/P"), rootAbs); st != proto.StatusNotFound {
+		t.Errorf("STAT of a file on the reformatted image = %s, want NOT_FOUND", statusName(st))
+	}
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/fsops"
+	"wicos64-server/internal/pathutil"
+	"wicos64-server/internal/proto"
+)
+
+const (
+	mktempDir      = "/.TMP"
+	mktempAttempts = 16
+)
+
+// opMKTEMP creates a new, empty file with a unique name in the token's .TMP
+// directory and returns its path. Clients write to it and MV it into place.
+// Abandoned files are reaped by the regular .TMP cleanup.
+//
+// Payload: prefix string, suffix string (both may be empty).
+// Response: path string (e.g. "/.TMP/PREFIX1A2B3C4D.SEQ").
+func (s *Server) opMKTEMP(cfg config.Config, limits Limits, payload []byte, rootAbs string) (byte, []byte, string) {
+	release, ok := s.lockWrite(cfg, limits, proto.OpMKTEMP, payload, rootAbs, false)
+	if !ok {
+		return proto.StatusBusy, nil, "busy"
+	}
+	defer release()
+
+	d := proto.NewDecoder(payload)
+	prefix, err := d.ReadString(cfg.MaxName)
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	suffix, err := d.ReadString(cfg.MaxName)
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	if d.Remaining() != 0 {
+		return proto.StatusBadRequest, nil, "extra bytes in MKTEMP"
+	}
+	if strings.Contains(prefix, "/") || strings.Contains(suffix, "/") {
+		return proto.StatusInvalidPath, nil, "prefix/suffix must not contain '/'"
+	}
+
+	tmpAbs, err := fsops.ToOSPath(rootAbs, mktempDir)
+	if err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	if err := os.MkdirAll(tmpAbs, 0o755); err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+	if err := fsops.LstatNoSymlink(rootAbs, tmpAbs, false); err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+
+	buf := make([]byte, 4)
+	for i := 0; i < mktempAttempts; i++ {
+		_, _ = rand.Read(buf)
+		name := prefix + strings.ToUpper(hex.EncodeToString(buf)) + suffix
+		p, err := pathutil.Normalize(mktempDir+"/"+name, cfg.MaxPath, cfg.MaxName)
+		if err != nil {
+			return proto.StatusInvalidPath, nil, err.Error()
+		}
+		p = pathutil.Canonicalize(p)
+		abs, err := fsops.ToOSPath(rootAbs, p)
+		if err != nil {
+			return proto.StatusInvalidPath, nil, err.Error()
+		}
+		f, err := os.OpenFile(abs, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			if errors.Is(err, fs.ErrExist) {
+				continue
+			}
+			if errors.Is(err, fs.ErrPermission) {
+				return proto.StatusAccessDenied, nil, "access denied"
+			}
+			return proto.StatusInternal, nil, err.Error()
+		}
+		_ = f.Close()
+
+		e := proto.NewEncoder(2 + len(p))
+		if err := e.WriteString(p); err != nil {
+			_ = os.Remove(abs)
+			return proto.StatusInternal, nil, err.Error()
+		}
+		return proto.StatusOK, e.Bytes(), ""
+	}
+	return proto.StatusBusy, nil, "could not find a free temp name"
+}
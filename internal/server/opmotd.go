@@ -0,0 +1,24 @@
+package server
+
+import (
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+// opMOTD returns the configured message of the day. FeatMOTD is only
+// advertised when one is set; without it the op answers an empty string.
+//
+// Payload: empty.
+// Response: motd string (u16 length, truncated to fit max_payload).
+func (s *Server) opMOTD(cfg config.Config, payload []byte) (byte, []byte, string) {
+	if len(payload) != 0 {
+		return proto.StatusBadRequest, nil, "extra bytes in MOTD"
+	}
+	motd := cfg.Motd
+	if max := int(cfg.MaxPayload) - 2; len(motd) > max {
+		motd = motd[:max]
+	}
+	e := proto.NewEncoder(2 + len(motd))
+	_ = e.WriteString(motd)
+	return proto.StatusOK, e.Bytes(), ""
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+// disabled_ops names ops by their opName ("SEARCH") or by hex opcode
+// ("0x0B"). CAPS cannot be disabled: without it a client cannot tell what
+// the server supports.
+
+var (
+	opsByNameOnce sync.Once
+	opsByName     map[string]byte
+)
+
+// parseOpRef resolves a disabled_ops entry to its opcode.
+func parseOpRef(ref string) (byte, bool) {
+	ref = strings.ToUpper(strings.TrimSpace(ref))
+	if strings.HasPrefix(ref, "0X") {
+		n, err := strconv.ParseUint(ref[2:], 16, 8)
+		return byte(n), err == nil
+	}
+	opsByNameOnce.Do(func() {
+		opsByName = map[string]byte{}
+		for op := 0; op < 0xFF; op++ {
+			if name := opName(byte(op)); !strings.HasPrefix(name, "OP_0x") {
+				opsByName[name] = byte(op)
+			}
+		}
+	})
+	op, ok := opsByName[ref]
+	return op, ok
+}
+
+// opDisabled reports whether cfg.DisabledOps forbids op.
+func opDisabled(cfg config.Config, op byte) bool {
+	if op == proto.OpCAPS {
+		return false
+	}
+	for _, ref := range cfg.DisabledOps {
+		if o, ok := parseOpRef(ref); ok && o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// opFeatures are the CAPS feature bits that advertise (a mode of) an op.
+// Ops without an entry are part of the base protocol.
+var opFeatures = map[byte]uint64{
+	proto.OpSTATFS:        proto.FeatSTATFS | proto.FeatSTATFS_QUOTA,
+	proto.OpAPPEND:        proto.FeatAPPEND,
+	proto.OpSEARCH:        proto.FeatSEARCH,
+	proto.OpHASH:          proto.FeatHASH_CRC32 | proto.FeatHASH_SHA1 | proto.FeatHASH_SHA256 | proto.FeatHASH_CRC16,
+	proto.OpMKDIR:         proto.FeatMKDIR_PARENTS,
+	proto.OpRMDIR:         proto.FeatRMDIR_RECURSIVE,
+	proto.OpCP:            proto.FeatCP_RECURSIVE | proto.FeatCP_ASYNC | proto.FeatIMAGE_CONVERT,
+	proto.OpPROGRESS:      proto.FeatCP_ASYNC,
+	proto.OpREAD_RANGE:    proto.FeatSCREENCODE | proto.FeatIF_HASH,
+	proto.OpWRITE_RANGE:   proto.FeatWRITE_SIZE | proto.FeatSTAGED_WRITE | proto.FeatOVERWRITE_CONFIRM,
+	proto.OpDIRMTIME:      proto.FeatDIRMTIME,
+	proto.OpSTRINGS:       proto.FeatSTRINGS,
+	proto.OpTREE:          proto.FeatTREE,
+	proto.OpREAD_TAIL:     proto.FeatREAD_TAIL,
+	proto.OpTOKEN_NAMES:   proto.FeatTOKEN_NAMES,
+	proto.OpTOUCH:         proto.FeatTOUCH,
+	proto.OpMKTEMP:        proto.FeatMKTEMP,
+	proto.OpBATCH:         proto.FeatBATCH,
+	proto.OpECHO:          proto.FeatECHO,
+	proto.OpLOCK:          proto.FeatLOCK,
+	proto.OpUNLOCK:        proto.FeatLOCK,
+	proto.OpCOPY_RANGE:    proto.FeatCOPY_RANGE,
+	proto.OpMOTD:          proto.FeatMOTD,
+	proto.OpSAMEFILE:      proto.FeatSAMEFILE,
+	proto.OpLS:            proto.FeatLS_SORT,
+	proto.OpLS_TREE:       proto.FeatLS_TREE,
+	proto.OpIMAGE_CHANGES: proto.FeatIMAGE_CHANGES,
+	proto.OpEXISTS_EXACT:  proto.FeatEXISTS_EXACT,
+	proto.OpVERIFY:        proto.FeatVERIFY,
+	proto.OpTRASH_LS:      proto.FeatTRASH,
+	proto.OpTRASH_RESTORE: proto.FeatTRASH,
+	proto.OpDIRHASH:       proto.FeatDIRHASH,
+	proto.OpSTAT_MANY:     proto.FeatSTAT_MANY,
+	proto.OpMKIMAGE:       proto.FeatMKIMAGE,
+	proto.OpWHOAMI:        proto.FeatWHOAMI,
+	proto.OpIMAGES:        proto.FeatIMAGES,
+}
+
+// disabledOpFeatures returns the feature bits of the disabled ops, which
+// CAPS must not advertise.
+func disabledOpFeatures(cfg config.Config) uint64 {
+	var bits uint64
+	for _, ref := range cfg.DisabledOps {
+		if op, ok := parseOpRef(ref); ok && op != proto.OpCAPS {
+			bits |= opFeatures[op]
+		}
+	}
+	return bits
+}
+
+// disabledOpsWarnings reports disabled_ops entries that have no effect.
+func disabledOpsWarnings(cfg config.Config) []string {
+	var w []string
+	for _, ref := range cfg.DisabledOps {
+		op, ok := parseOpRef(ref)
+		switch {
+		case !ok:
+			w = append(w, "disabled_ops: unknown op "+strconv.Quote(ref))
+		case op == proto.OpCAPS:
+			w = append(w, "disabled_ops: CAPS cannot be disabled")
+		}
+	}
+	return w
+}
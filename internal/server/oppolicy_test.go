@@ -0,0 +1,95 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+// capsFeatureBits decodes features_lo and features_hi from a CAPS response.
+func capsFeatureBits(t *testing.T, resp []byte) uint64 {
+	t.Helper()
+	d := proto.NewDecoder(resp)
+	for i := 0; i < 5; i++ {
+		if _, err := d.ReadU16(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lo, _ := d.ReadU32()
+	_, _ = d.ReadU32()
+	_, _ = d.ReadString(0xFFFF)
+	_, _ = d.ReadU16()
+	hi, err := d.ReadU32()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uint64(hi)<<32 | uint64(lo)
+}
+
+func TestDisabledOps(t *testing.T) {
+	edit := func(c *config.Config) {
+		c.EnableHTTPFiles = true
+		c.DisabledOps = []string{"search", fmt.Sprintf("0x%02X", proto.OpWRITE_RANGE)}
+	}
+	s, cfg, rootAbs := newTestServer(t, edit)
+	base, baseCfg, baseRoot := newTestServer(t, func(c *config.Config) { c.EnableHTTPFiles = true })
+
+	for _, tt := range []struct {
+		op      byte
+		payload []byte
+	}{
+		{proto.OpSEARCH, pathPayload("/")},
+		{proto.OpWRITE_RANGE, writeRangePayload(t, "/A", 0, []byte("a"))},
+	} {
+		st, _, msg := s.dispatch(cfg, Limits{}, tt.op, proto.FlagWR_CREATE, tt.payload, rootAbs)
+		if st != proto.StatusNotSupported || msg != "op disabled by policy" {
+			t.Errorf("%s = %s (%s), want NOT_SUPPORTED (op disabled by policy)", opName(tt.op), statusName(st), msg)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(rootAbs, "A")); err == nil {
+		t.Error("disabled WRITE_RANGE created /A")
+	}
+	if st, _, msg := s.dispatch(cfg, Limits{}, proto.OpLS, 0, encode(func(e *proto.Encoder) {
+		_ = e.WriteString("/")
+		e.WriteU16(0)
+		e.WriteU16(10)
+	}), rootAbs); st != proto.StatusOK {
+		t.Errorf("LS = %s (%s), want OK", statusName(st), msg)
+	}
+
+	// CAPS drops the feature bits of the disabled ops and nothing else.
+	_, resp, _ := s.dispatch(cfg, Limits{}, proto.OpCAPS, 0, nil, rootAbs)
+	_, baseResp, _ := base.dispatch(baseCfg, Limits{}, proto.OpCAPS, 0, nil, baseRoot)
+	got, want := capsFeatureBits(t, resp), capsFeatureBits(t, baseResp)
+	dropped := proto.FeatSEARCH | proto.FeatWRITE_SIZE
+	if want&dropped != dropped {
+		t.Fatalf("baseline CAPS %#x lacks SEARCH/WRITE_SIZE", want)
+	}
+	if got != want&^disabledOpFeatures(cfg) || got&dropped != 0 {
+		t.Errorf("CAPS features = %#x, want %#x", got, want&^disabledOpFeatures(cfg))
+	}
+
+	// HTTP uploads are WRITE_RANGEs and are refused as well.
+	r := httptest.NewRequest("PUT", httpFilesPrefix+"UP", strings.NewReader("data"))
+	w := httptest.NewRecorder()
+	s.handleHTTPFiles(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("upload = %d %q, want 403", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(rootAbs, "UP")); err == nil {
+		t.Fatal("disabled upload created /UP")
+	}
+	r = httptest.NewRequest("PUT", httpFilesPrefix+"UP", strings.NewReader("data"))
+	w = httptest.NewRecorder()
+	base.handleHTTPFiles(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload without disabled_ops = %d %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,317 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+	"wicos64-server/internal/version"
+)
+
+// Async copies: CP with FlagCP_ASYNC answers at once with an op id and runs
+// the copy in the background. The client polls PROGRESS with that id; the
+// admin UI sees all of them via GET /admin/api/ops.
+
+const (
+	// asyncOpKeep is how long a finished op can still be polled.
+	asyncOpKeep = 10 * time.Minute
+	// asyncOpsPerToken bounds the concurrently running async ops of a token.
+	asyncOpsPerToken = 4
+)
+
+// asyncOp is one background operation. copied/total are updated while the
+// op runs; the result fields are set once when it finishes.
+type asyncOp struct {
+	id        uint32
+	tokenID   string
+	tokenName string
+	op        byte
+	src, dst  string
+	started   time.Time
+
+	copied atomic.Int64
+	total  atomic.Int64
+
+	mu       sync.Mutex
+	finished time.Time
+	status   byte
+	errMsg   string
+}
+
+// add records n more bytes copied. It is safe to call on a nil op (the
+// synchronous CP path).
+func (a *asyncOp) add(n int64) {
+	if a != nil {
+		a.copied.Add(n)
+	}
+}
+
+// addTotal adds n to the number of bytes the op is going to copy. Wildcard
+// copies add each entry when they get to it.
+func (a *asyncOp) addTotal(n uint64) {
+	if a != nil {
+		a.total.Add(int64(n))
+	}
+}
+
+// progressFunc returns the callback for fsops copies (nil if a is nil).
+func (a *asyncOp) progressFunc() func(int64) {
+	if a == nil {
+		return nil
+	}
+	return a.add
+}
+
+func (a *asyncOp) finish(status byte, errMsg string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.finished = time.Now()
+	a.status = status
+	a.errMsg = errMsg
+}
+
+func (a *asyncOp) result() (done bool, finished time.Time, status byte, errMsg string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return !a.finished.IsZero(), a.finished, a.status, a.errMsg
+}
+
+type asyncOps struct {
+	mu   sync.Mutex
+	next uint32
+	m    map[uint32]*asyncOp
+}
+
+// running returns the number of ops that have not finished yet.
+func (o *asyncOps) running() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n := 0
+	for _, a := range o.m {
+		if done, _, _, _ := a.result(); !done {
+			n++
+		}
+	}
+	return n
+}
+
+// start registers a new op, or returns nil if the token already runs
+// asyncOpsPerToken ops.
+func (o *asyncOps) start(limits Limits, op byte, src, dst string) *asyncOp {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := time.Now()
+	o.expireLocked(now)
+	running := 0
+	for _, a := range o.m {
+		if done, _, _, _ := a.result(); !done && a.tokenID == limits.tokenID {
+			running++
+		}
+	}
+	if running >= asyncOpsPerToken {
+		return nil
+	}
+	o.next++
+	if o.next == 0 {
+		o.next = 1
+	}
+	a := &asyncOp{id: o.next, tokenID: limits.tokenID, tokenName: limits.tokenName, op: op, src: src, dst: dst, started: now}
+	if o.m == nil {
+		o.m = map[uint32]*asyncOp{}
+	}
+	o.m[a.id] = a
+	return a
+}
+
+// get returns the op with id if it belongs to tokenID.
+func (o *asyncOps) get(id uint32, tokenID string) (*asyncOp, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.expireLocked(time.Now())
+	a, ok := o.m[id]
+	if !ok || a.tokenID != tokenID {
+		return nil, false
+	}
+	return a, true
+}
+
+func (o *asyncOps) list() []*asyncOp {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.expireLocked(time.Now())
+	out := make([]*asyncOp, 0, len(o.m))
+	for _, a := range o.m {
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].id < out[j].id })
+	return out
+}
+
+func (o *asyncOps) expireLocked(now time.Time) {
+	for id, a := range o.m {
+		if done, fin, _, _ := a.result(); done && now.Sub(fin) > asyncOpKeep {
+			delete(o.m, id)
+		}
+	}
+}
+
+// startAsyncCP validates a CP request and runs it in the background.
+// newFiles is the file-count precheck result from dispatch; releaseQuota
+// drops the quota lock dispatch took and is called once the copy is done.
+//
+// Response: op_id u32 (poll with PROGRESS).
+func (s *Server) startAsyncCP(cfg config.Config, limits Limits, flags byte, payload []byte, rootAbs string, newFiles uint64, releaseQuota func()) (byte, []byte, string) {
+	started := false
+	defer func() {
+		if !started {
+			releaseQuota()
+		}
+	}()
+	if limits.writeLocked {
+		return proto.StatusBadRequest, nil, "async CP is not allowed in BATCH"
+	}
+	d := proto.NewDecoder(payload)
+	src, err := s.readPathStringRead(cfg, d)
+	if err != nil {
+		return proto.StatusBadRequest, nil, "invalid src path: " + err.Error()
+	}
+	dst, err := s.readPathString(cfg, d)
+	if err != nil {
+		return proto.StatusBadRequest, nil, "invalid dst path: " + err.Error()
+	}
+	if d.Remaining() != 0 {
+		return proto.StatusBadRequest, nil, "trailing payload data"
+	}
+
+	a := s.asyncOps.start(limits, proto.OpCP, src, dst)
+	if a == nil {
+		return proto.StatusBusy, nil, "too many async operations"
+	}
+	limits.progress = a
+	payload = append([]byte(nil), payload...)
+	started = true
+	go func() {
+		defer releaseQuota()
+		st, _, msg := s.runOp(cfg, limits, proto.OpCP, flags&^proto.FlagCP_ASYNC, payload, rootAbs, newFiles)
+		a.finish(st, msg)
+	}()
+
+	e := proto.NewEncoder(4)
+	e.WriteU32(a.id)
+	return proto.StatusOK, e.Bytes(), ""
+}
+
+// opPROGRESS reports the state of an async op started by the same token.
+//
+// Payload: op_id u32.
+// Response: state u8 (0 running, 1 done), result status u8 (when done),
+// copied u32, total u32 (bytes, saturated; grows during wildcard copies),
+// errmsg string (when failed).
+func (s *Server) opPROGRESS(cfg config.Config, limits Limits, payload []byte) (byte, []byte, string) {
+	d := proto.NewDecoder(payload)
+	id, err := d.ReadU32()
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	if d.Remaining() != 0 {
+		return proto.StatusBadRequest, nil, "extra bytes in PROGRESS"
+	}
+	a, ok := s.asyncOps.get(id, limits.tokenID)
+	if !ok {
+		return proto.StatusNotFound, nil, "unknown op id"
+	}
+	done, _, status, errMsg := a.result()
+
+	e := proto.NewEncoder(16)
+	state := byte(0)
+	if done {
+		state = 1
+	}
+	e.WriteU8(state)
+	e.WriteU8(status)
+	e.WriteU32(satU32(a.copied.Load()))
+	e.WriteU32(satU32(a.total.Load()))
+	if len(errMsg) > 64 {
+		errMsg = errMsg[:64]
+	}
+	_ = e.WriteString(errMsg)
+	return proto.StatusOK, e.Bytes(), ""
+}
+
+func satU32(n int64) uint32 {
+	if n < 0 {
+		return 0
+	}
+	if n > 0xFFFFFFFF {
+		return 0xFFFFFFFF
+	}
+	return uint32(n)
+}
+
+type adminAsyncOp struct {
+	ID         uint32 `json:"id"`
+	Token      string `json:"token"`
+	Op         string `json:"op"`
+	Src        string `json:"src"`
+	Dst        string `json:"dst"`
+	State      string `json:"state"`
+	Status     string `json:"status,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Copied     int64  `json:"copied_bytes"`
+	Total      int64  `json:"total_bytes"`
+	StartUnix  int64  `json:"start_unix"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+type adminAsyncOpsResponse struct {
+	OK     bool           `json:"ok"`
+	Build  string         `json:"build"`
+	TSUnix int64          `json:"ts_unix"`
+	Ops    []adminAsyncOp `json:"ops"`
+}
+
+func (s *Server) handleAdminOps(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	now := time.Now()
+	ops := []adminAsyncOp{}
+	for _, a := range s.asyncOps.list() {
+		done, fin, status, errMsg := a.result()
+		tok := a.tokenName
+		if tok == "" {
+			tok = a.tokenID
+		}
+		row := adminAsyncOp{
+			ID:        a.id,
+			Token:     tok,
+			Op:        opName(a.op),
+			Src:       a.src,
+			Dst:       a.dst,
+			State:     "running",
+			Copied:    a.copied.Load(),
+			Total:     a.total.Load(),
+			StartUnix: a.started.Unix(),
+		}
+		end := now
+		if done {
+			row.State = "done"
+			row.Status = statusName(status)
+			row.Error = errMsg
+			end = fin
+		}
+		row.DurationMs = end.Sub(a.started).Milliseconds()
+		ops = append(ops, row)
+	}
+	writeJSON(w, http.StatusOK, adminAsyncOpsResponse{
+		OK:     true,
+		Build:  version.Get().String(),
+		TSUnix: now.Unix(),
+		Ops:    ops,
+	})
+}
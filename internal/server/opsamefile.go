@@ -0,0 +1,88 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/fsops"
+	"wicos64-server/internal/proto"
+)
+
+// opSAMEFILE reports whether two paths refer to the same host file or
+// directory (same inode, via os.SameFile), e.g. when aliases make /A/FILE and
+// /B/FILE the same file. Both paths must exist.
+//
+// Paths inside disk images are the same if they name the same image file and
+// their inner paths match (case-insensitive).
+//
+// Payload: path_a string, path_b string.
+// Response: same u8 (0/1).
+func (s *Server) opSAMEFILE(cfg config.Config, limits Limits, payload []byte, rootAbs string) (byte, []byte, string) {
+	d := proto.NewDecoder(payload)
+	a, err := s.readPathString(cfg, d)
+	if err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	b, err := s.readPathString(cfg, d)
+	if err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	if d.Remaining() != 0 {
+		return proto.StatusBadRequest, nil, "extra bytes in SAMEFILE"
+	}
+
+	innerA, innerB := "", ""
+	if limits.DiskImagesEnabled {
+		if kind, mount, inner, ok := splitDiskImagePath(a); ok {
+			if _, st, msg := resolveDiskImageMountModTime(rootAbs, kind, mount); st != proto.StatusOK {
+				return st, nil, msg
+			}
+			a, innerA = mount, inner
+		}
+		if kind, mount, inner, ok := splitDiskImagePath(b); ok {
+			if _, st, msg := resolveDiskImageMountModTime(rootAbs, kind, mount); st != proto.StatusOK {
+				return st, nil, msg
+			}
+			b, innerB = mount, inner
+		}
+	}
+
+	fa, st, msg := sameFileStat(rootAbs, a)
+	if st != proto.StatusOK {
+		return st, nil, msg
+	}
+	fb, st, msg := sameFileStat(rootAbs, b)
+	if st != proto.StatusOK {
+		return st, nil, msg
+	}
+	same := os.SameFile(fa, fb) && strings.EqualFold(innerA, innerB)
+	if same {
+		return proto.StatusOK, []byte{1}, ""
+	}
+	return proto.StatusOK, []byte{0}, ""
+}
+
+// sameFileStat resolves p inside rootAbs (no symlinks) and stats it.
+func sameFileStat(rootAbs, p string) (fs.FileInfo, byte, string) {
+	abs, err := fsops.ToOSPath(rootAbs, p)
+	if err != nil {
+		return nil, proto.StatusInvalidPath, err.Error()
+	}
+	if err := fsops.LstatNoSymlink(rootAbs, abs, false); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, proto.StatusNotFound, "not found"
+		}
+		return nil, proto.StatusInvalidPath, err.Error()
+	}
+	fi, err := os.Stat(abs)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, proto.StatusNotFound, "not found"
+		}
+		return nil, proto.StatusInternal, err.Error()
+	}
+	return fi, proto.StatusOK, ""
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+func searchPayload(base, q string, start, max uint16) []byte {
+	return encode(func(e *proto.Encoder) {
+		_ = e.WriteString(base)
+		_ = e.WriteString(q)
+		e.WriteU16(start)
+		e.WriteU16(max)
+		e.WriteU32(0)
+	})
+}
+
+// search runs one SEARCH page and returns its hits as "PATH@OFF:PREVIEW".
+func search(t *testing.T, s *Server, cfg config.Config, limits Limits, rootAbs string, flags byte, payload []byte) ([]string, uint16) {
+	t.Helper()
+	st, resp, msg := s.dispatch(cfg, limits, proto.OpSEARCH, flags, payload, rootAbs)
+	if st != proto.StatusOK {
+		t.Fatalf("SEARCH = %s (%s)", statusName(st), msg)
+	}
+	d := proto.NewDecoder(resp)
+	n, _ := d.ReadU16()
+	var hits []string
+	for i := 0; i < int(n); i++ {
+		p, _ := d.ReadString(0xFFFF)
+		off, _ := d.ReadU32()
+		pl, _ := d.ReadU16()
+		pv, _ := d.ReadBytes(int(pl))
+		hits = append(hits, fmt.Sprintf("%s@%d:%s", p, off, pv))
+	}
+	next, err := d.ReadU16()
+	if err != nil || d.Remaining() != 0 {
+		t.Fatalf("SEARCH response malformed: %v, %d bytes left", err, d.Remaining())
+	}
+	return hits, next
+}
+
+func writeFiles(t *testing.T, rootAbs string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		p := filepath.Join(rootAbs, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSEARCHRegex(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, func(c *config.Config) { c.SearchPreviewBytes = 5 })
+	writeFiles(t, rootAbs, map[string]string{
+		"LOG/A.TXT":     "ERROR disk\nWARN low\nerror again\n",
+		"LOG/SUB/B.TXT": "INFO ok\nERROR net\n",
+	})
+	const rec = proto.FlagS_REGEX | proto.FlagS_RECURSIVE
+
+	for _, tc := range []struct {
+		name  string
+		flags byte
+		q     string
+		want  string
+	}{
+		{"anchored alternation", rec, `^(ERROR|WARN)`, "/LOG/A.TXT@0:ERROR /LOG/A.TXT@11:WARN  /LOG/SUB/B.TXT@8:ERROR"},
+		{"case-insensitive", rec | proto.FlagS_CASE_INSENSITIVE, `^error`, "/LOG/A.TXT@0:ERROR /LOG/A.TXT@20:error /LOG/SUB/B.TXT@8:ERROR"},
+		{"end anchor", rec, `(net|low)$`, "/LOG/A.TXT@16:low\ne /LOG/SUB/B.TXT@14:net\n"},
+		{"whole word", rec | proto.FlagS_WHOLE_WORD, `o`, ""},
+		{"not recursive", proto.FlagS_REGEX, `ERROR|INFO`, "/LOG/A.TXT@0:ERROR"},
+	} {
+		hits, next := search(t, s, cfg, Limits{}, rootAbs, tc.flags, searchPayload("/LOG", tc.q, 0, 0))
+		if got := strings.Join(hits, " "); got != tc.want || next != 0xFFFF {
+			t.Errorf("%s: SEARCH %q = %q (next %d), want %q", tc.name, tc.q, got, next, tc.want)
+		}
+	}
+
+	// Pagination by next_index.
+	hits, next := search(t, s, cfg, Limits{}, rootAbs, rec, searchPayload("/LOG", `^(ERROR|WARN)`, 0, 2))
+	if len(hits) != 2 || next != 2 {
+		t.Fatalf("first page = %q, next %d", hits, next)
+	}
+	hits, next = search(t, s, cfg, Limits{}, rootAbs, rec, searchPayload("/LOG", `^(ERROR|WARN)`, next, 2))
+	if len(hits) != 1 || !strings.HasPrefix(hits[0], "/LOG/SUB/B.TXT@8") || next != 0xFFFF {
+		t.Fatalf("second page = %q, next %d", hits, next)
+	}
+
+	if st, _, _ := s.dispatch(cfg, Limits{}, proto.OpSEARCH, rec, searchPayload("/LOG", `(ERROR`, 0, 0), rootAbs); st != proto.StatusBadRequest {
+		t.Fatalf("SEARCH with an invalid regex = %s, want BAD_REQUEST", statusName(st))
+	}
+
+	// Files above search_regex_max_file_bytes are skipped.
+	small := cfg
+	small.SearchRegexMaxBytes = 20
+	if hits, _ := search(t, s, small, Limits{}, rootAbs, rec, searchPayload("/LOG", `ERROR`, 0, 0)); strings.Join(hits, " ") != "/LOG/SUB/B.TXT@8:ERROR" {
+		t.Fatalf("SEARCH with search_regex_max_file_bytes 20 = %q", hits)
+	}
+}
+
+func TestSEARCHNames(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	writeFiles(t, rootAbs, map[string]string{
+		"GAMES/A.PRG":          "a",
+		"GAMES/B.SEQ":          "A.PRG",
+		"GAMES/SUB/C.PRG":      "c",
+		"GAMES/SUB/DEEP/D.PRG": "d",
+		"OTHER/E.PRG":          "e",
+	})
+	const rec = proto.FlagS_NAMES | proto.FlagS_RECURSIVE
+
+	for _, tc := range []struct {
+		name  string
+		flags byte
+		q     string
+		want  string
+	}{
+		// Hits have offset 0 and no preview; contents are not searched.
+		{"recursive", rec, "*.PRG", "/GAMES/A.PRG@0: /GAMES/SUB/C.PRG@0: /GAMES/SUB/DEEP/D.PRG@0:"},
+		{"base dir only", proto.FlagS_NAMES, "*.PRG", "/GAMES/A.PRG@0:"},
+		{"single-char wildcard", rec, "?.SEQ", "/GAMES/B.SEQ@0:"},
+		{"case-insensitive", rec | proto.FlagS_CASE_INSENSITIVE, "c.prg", "/GAMES/SUB/C.PRG@0:"},
+		{"path below base", rec, "SUB/DEEP/*", "/GAMES/SUB/DEEP/D.PRG@0:"},
+		{"no match", rec, "*.D64", ""},
+	} {
+		hits, next := search(t, s, cfg, Limits{}, rootAbs, tc.flags, searchPayload("/GAMES", tc.q, 0, 0))
+		if got := strings.Join(hits, " "); got != tc.want || next != 0xFFFF {
+			t.Errorf("%s: SEARCH names %q = %q (next %d), want %q", tc.name, tc.q, got, next, tc.want)
+		}
+	}
+
+	// One hit per page.
+	var all []string
+	for start := uint16(0); start != 0xFFFF; {
+		hits, next := search(t, s, cfg, Limits{}, rootAbs, rec, searchPayload("/GAMES", "*.PRG", start, 1))
+		if len(hits) != 1 || len(all) == 3 {
+			t.Fatalf("page at %d = %q after %q", start, hits, all)
+		}
+		all = append(all, hits...)
+		start = next
+	}
+	if len(all) != 3 {
+		t.Fatalf("paged SEARCH names = %q", all)
+	}
+}
+
+func TestSEARCHIntoImages(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	limits := Limits{DiskImagesEnabled: true, DiskImagesWriteEnabled: true}
+	writeFiles(t, rootAbs, map[string]string{"DISKS/A.TXT": strings.Repeat("x", 200)})
+	mkImage(t, s, cfg, limits, rootAbs, "/DISKS/G.D64", proto.ImageKindD64)
+	for name, data := range map[string]string{"HIDDEN": "...SECRETWORD...", "OTHER": "nothing here"} {
+		if st, _, msg := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, proto.FlagWR_CREATE, writeRangePayload(t, "/DISKS/G.D64/"+name, 0, []byte(data)), rootAbs); st != proto.StatusOK {
+			t.Fatalf("WRITE_RANGE %s = %s (%s)", name, statusName(st), msg)
+		}
+	}
+	const rec = proto.FlagS_RECURSIVE
+
+	hits, _ := search(t, s, cfg, limits, rootAbs, rec|proto.FlagS_INTO_IMAGES, searchPayload("/", "SECRETWORD", 0, 0))
+	if got := strings.Join(hits, " "); !strings.HasPrefix(got, "/DISKS/G.D64/HIDDEN@3:") || len(hits) != 1 {
+		t.Fatalf("SEARCH into images = %q", got)
+	}
+	// Without the flag (or with disk images off) the image is one plain file.
+	for _, tc := range []struct {
+		flags  byte
+		limits Limits
+	}{{rec, limits}, {rec | proto.FlagS_INTO_IMAGES, Limits{}}} {
+		hits, _ := search(t, s, cfg, tc.limits, rootAbs, tc.flags, searchPayload("/", "SECRETWORD", 0, 0))
+		if len(hits) != 1 || !strings.HasPrefix(hits[0], "/DISKS/G.D64@") {
+			t.Fatalf("SEARCH flags %#x, images %v = %q", tc.flags, tc.limits.DiskImagesEnabled, hits)
+		}
+	}
+	// Names inside images, too.
+	if hits, _ := search(t, s, cfg, limits, rootAbs, rec|proto.FlagS_INTO_IMAGES|proto.FlagS_NAMES, searchPayload("/", "HID*", 0, 0)); strings.Join(hits, " ") != "/DISKS/G.D64/HIDDEN@0:" {
+		t.Fatalf("SEARCH names into images = %q", hits)
+	}
+
+	// max_scan_bytes is shared by host and image files: 150 bytes end the
+	// scan inside A.TXT, before the image.
+	budget := func(n uint32) []byte {
+		return encode(func(e *proto.Encoder) {
+			_ = e.WriteString("/DISKS")
+			_ = e.WriteString("SECRETWORD")
+			e.WriteU16(0)
+			e.WriteU16(0)
+			e.WriteU32(n)
+		})
+	}
+	if hits, next := search(t, s, cfg, limits, rootAbs, rec|proto.FlagS_INTO_IMAGES, budget(150)); len(hits) != 0 || next == 0xFFFF {
+		t.Fatalf("SEARCH with 150 scan bytes = %q, next %d; want an incomplete empty page", hits, next)
+	}
+	if hits, next := search(t, s, cfg, limits, rootAbs, rec|proto.FlagS_INTO_IMAGES, budget(300)); len(hits) != 1 || next != 0xFFFF {
+		t.Fatalf("SEARCH with 300 scan bytes = %q, next %d", hits, next)
+	}
+}
+
+func TestSEARCHScanBudget(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, func(c *config.Config) {
+		c.SearchDefaultScanBytes = 100
+		c.SearchMaxScanBytes = 250
+		c.SearchPreviewBytes = 80
+	})
+	pad := func(pre string, n int) string { return pre + strings.Repeat(".", n-len(pre)) }
+	writeFiles(t, rootAbs, map[string]string{
+		"S/A.TXT": pad("NEEDLE", 100),
+		"S/B.TXT": pad(strings.Repeat("-", 10)+"NEEDLE", 100),
+		"S/C.TXT": pad(strings.Repeat("-", 70)+"NEEDLE", 100),
+	})
+	payload := func(maxScan uint32) []byte {
+		return encode(func(e *proto.Encoder) {
+			_ = e.WriteString("/S")
+			_ = e.WriteString("NEEDLE")
+			e.WriteU16(0)
+			e.WriteU16(0)
+			e.WriteU32(maxScan)
+		})
+	}
+
+	for _, tc := range []struct {
+		name    string
+		cfg     config.Config
+		maxScan uint32
+		hits    int
+		next    uint16
+	}{
+		// 0 is search_default_scan_bytes: A only, then the budget is gone.
+		{"default budget", cfg, 0, 1, 1},
+		// Larger requests clamp to search_max_scan_bytes: C's match lies
+		// beyond the 50 bytes left for it.
+		{"clamped to the cap", cfg, 1 << 30, 2, 2},
+		{"request below the cap", cfg, 105, 1, 1},
+		{"cap raised", func() config.Config { c := cfg; c.SearchMaxScanBytes = 400; return c }(), 1 << 30, 3, 0xFFFF},
+	} {
+		hits, next := search(t, s, tc.cfg, Limits{}, rootAbs, 0, payload(tc.maxScan))
+		if len(hits) != tc.hits || next != tc.next {
+			t.Errorf("%s: SEARCH = %d hits, next %d, want %d hits, next %d (incomplete)", tc.name, len(hits), next, tc.hits, tc.next)
+		}
+	}
+
+	// Previews are search_preview_bytes long, and CAPS says so.
+	hits, _ := search(t, s, cfg, Limits{}, rootAbs, 0, payload(0))
+	if want := "/S/A.TXT@0:" + pad("NEEDLE", 80); len(hits) != 1 || hits[0] != want {
+		t.Fatalf("hit = %q, want %q", hits, want)
+	}
+	st, resp, _ := s.dispatch(cfg, Limits{}, proto.OpCAPS, 0, nil, rootAbs)
+	if st != proto.StatusOK || len(resp) < 2 {
+		t.Fatalf("CAPS = %s", statusName(st))
+	}
+	if got := uint16(resp[len(resp)-2]) | uint16(resp[len(resp)-1])<<8; got != 80 {
+		t.Fatalf("CAPS preview_len = %d, want 80", got)
+	}
+}
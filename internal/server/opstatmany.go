@@ -0,0 +1,83 @@
+package server
+
+import (
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+// statManyEntrySize is the size of one STAT_MANY result: status u8,
+// type u8, size u32, mtime u32.
+const statManyEntrySize = 10
+
+// opSTAT_MANY runs STAT for several paths in one round trip.
+//
+// Payload: count u16, then count path strings.
+// Response: count u16 (results returned), per path status u8 + type u8 +
+// size u32 + mtime u32 (the STAT triple; zero unless status is OK), then
+// next_index u16: the first path without a result (0xFFFF = all done).
+//
+// A path that fails (not found, invalid, ...) only sets its own status. The
+// number of results is bounded by max_entries and max_payload; the client
+// sends the remaining paths again, starting at next_index.
+func (s *Server) opSTAT_MANY(cfg config.Config, limits Limits, payload []byte, rootAbs string) (byte, []byte, string) {
+	d := proto.NewDecoder(payload)
+	count, err := d.ReadU16()
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+
+	max := (int(cfg.MaxPayload) - 4) / statManyEntrySize
+	if cfg.MaxEntries > 0 && int(cfg.MaxEntries) < max {
+		max = int(cfg.MaxEntries)
+	}
+	if max < 1 {
+		return proto.StatusTooLarge, nil, "max_payload too small for STAT_MANY"
+	}
+
+	e := proto.NewEncoder(4 + statManyEntrySize*min(int(count), max))
+	e.WriteU16(0) // patched below
+	n := 0
+	next := uint16(0xFFFF)
+	zero := make([]byte, statManyEntrySize-1)
+	for i := 0; i < int(count); i++ {
+		// A bad string length leaves no way to find the next path: that is
+		// a malformed request. A path that does not normalize is an entry
+		// error.
+		raw, err := d.ReadString(cfg.MaxPath)
+		if err != nil {
+			return proto.StatusBadRequest, nil, err.Error()
+		}
+		if n >= max {
+			// Keep decoding so malformed payloads are still rejected.
+			if next == 0xFFFF {
+				next = uint16(i)
+			}
+			continue
+		}
+		n++
+		p, err := s.normalizeReadPath(cfg, raw)
+		if err != nil {
+			e.WriteU8(proto.StatusInvalidPath)
+			e.WriteBytes(zero)
+			continue
+		}
+		st, resp, _ := s.statPath(cfg, limits, p, rootAbs)
+		if st == proto.StatusOK && len(resp) != len(zero) {
+			st = proto.StatusInternal
+		}
+		if st != proto.StatusOK {
+			e.WriteU8(st)
+			e.WriteBytes(zero)
+			continue
+		}
+		e.WriteU8(proto.StatusOK)
+		e.WriteBytes(resp)
+	}
+	if d.Remaining() != 0 {
+		return proto.StatusBadRequest, nil, "extra bytes in STAT_MANY"
+	}
+	e.WriteU16(next)
+	out := e.Bytes()
+	out[0], out[1] = byte(n), byte(n>>8)
+	return proto.StatusOK, out, ""
+}
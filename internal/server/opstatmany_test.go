@@ -0,0 +1,108 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+func statManyPayload(paths ...string) []byte {
+	e := proto.NewEncoder(64)
+	e.WriteU16(uint16(len(paths)))
+	for _, p := range paths {
+		_ = e.WriteString(p)
+	}
+	return e.Bytes()
+}
+
+func TestSTAT_MANY(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, func(c *config.Config) { c.MaxEntries = 4 })
+	limits := Limits{DiskImagesEnabled: true, DiskImagesWriteEnabled: true}
+	writeFiles(t, rootAbs, map[string]string{"A.PRG": "hello", "DIR/B.SEQ": "0123456789"})
+	mkImage(t, s, cfg, limits, rootAbs, "/D.D64", proto.ImageKindD64)
+	if st, _, msg := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, proto.FlagWR_CREATE, writeRangePayload(t, "/D.D64/P", 0, make([]byte, 300)), rootAbs); st != proto.StatusOK {
+		t.Fatalf("WRITE_RANGE = %s (%s)", statusName(st), msg)
+	}
+
+	paths := []string{"/A.PRG", "/MISSING", "/DIR", "/../ESC", "/DIR/B.SEQ", "/D.D64/P", "/D.D64/NOPE", "/a.prg"}
+	want := make([][]byte, len(paths))
+	for i, p := range paths {
+		st, resp, _ := s.dispatch(cfg, limits, proto.OpSTAT, 0, pathPayload(p), rootAbs)
+		if st != proto.StatusOK {
+			resp = make([]byte, statManyEntrySize-1)
+		}
+		want[i] = append([]byte{st}, resp...)
+	}
+	if want[0][0] != proto.StatusOK || want[1][0] != proto.StatusNotFound || want[2][1] != 1 || want[3][0] != proto.StatusInvalidPath || want[5][0] != proto.StatusOK {
+		t.Fatalf("single STATs = % x", want)
+	}
+
+	// max_entries 4: two requests, the second with the paths from next_index on.
+	var got [][]byte
+	start := 0
+	for round := 0; ; round++ {
+		st, resp, msg := s.dispatch(cfg, limits, proto.OpSTAT_MANY, 0, statManyPayload(paths[start:]...), rootAbs)
+		if st != proto.StatusOK {
+			t.Fatalf("STAT_MANY = %s (%s)", statusName(st), msg)
+		}
+		d := proto.NewDecoder(resp)
+		n, _ := d.ReadU16()
+		for i := 0; i < int(n); i++ {
+			r, err := d.ReadBytes(statManyEntrySize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, r)
+		}
+		next, err := d.ReadU16()
+		if err != nil || d.Remaining() != 0 {
+			t.Fatalf("STAT_MANY response % x", resp)
+		}
+		if next == 0xFFFF {
+			if round != 1 {
+				t.Fatalf("STAT_MANY done after %d rounds, want 2", round+1)
+			}
+			break
+		}
+		if n != 4 || next != 4 {
+			t.Fatalf("STAT_MANY round %d: %d results, next %d", round, n, next)
+		}
+		start += int(next)
+	}
+	if len(got) != len(paths) {
+		t.Fatalf("%d results for %d paths", len(got), len(paths))
+	}
+	for i := range paths {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Errorf("%s: STAT_MANY % x, STAT % x", paths[i], got[i], want[i])
+		}
+	}
+
+	// max_payload bounds the results too: 4 + 2*10 bytes.
+	cfg.MaxPayload = 24
+	_, resp, _ := s.dispatch(cfg, limits, proto.OpSTAT_MANY, 0, statManyPayload("/A.PRG", "/A.PRG", "/A.PRG"), rootAbs)
+	if len(resp) != 24 || resp[0] != 2 || resp[22] != 2 || resp[23] != 0 {
+		t.Errorf("STAT_MANY with max_payload 24 = % x", resp)
+	}
+	cfg.MaxPayload = config.Default().MaxPayload
+
+	empty := statManyPayload()
+	if st, resp, _ := s.dispatch(cfg, limits, proto.OpSTAT_MANY, 0, empty, rootAbs); st != proto.StatusOK || !bytes.Equal(resp, []byte{0, 0, 0xFF, 0xFF}) {
+		t.Errorf("STAT_MANY of nothing = %s % x", statusName(st), resp)
+	}
+	bad := [][]byte{
+		nil,
+		{1, 0},                               // count 1, no path
+		append(statManyPayload("/A.PRG"), 0), // trailing byte
+		{2, 0, 1, 0, 'A'},                    // second path missing
+		statManyPayload("/" + strings.Repeat("X", 600)), // over max_path
+	}
+	for _, pl := range bad {
+		if st, _, _ := s.dispatch(cfg, limits, proto.OpSTAT_MANY, 0, pl, rootAbs); st != proto.StatusBadRequest {
+			t.Errorf("STAT_MANY % x = %s, want BAD_REQUEST", pl, statusName(st))
+		}
+	}
+}
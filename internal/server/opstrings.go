@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+const (
+	stringsDefaultMinLen            = 4
+	stringsDefaultMaxScan    uint32 = 1 * 1024 * 1024  // 1 MiB
+	stringsMaxMaxScan        uint32 = 32 * 1024 * 1024 // 32 MiB
+	stringsMaxRun                   = 255              // longer runs are split
+	stringsEndOffset         uint32 = 0xFFFFFFFF
+	stringsEntryOverhead            = 4 + 2 // off u32 + len u16
+	stringsResponseTrailer          = 4     // next_offset u32
+	stringsResponseCountSize        = 2
+)
+
+// isStringsPrintable reports whether b is part of a readable run:
+// printable ASCII (0x20-0x7E) or shifted PETSCII letters (0xC1-0xDA).
+func isStringsPrintable(b byte) bool {
+	return (b >= 0x20 && b <= 0x7E) || (b >= 0xC1 && b <= 0xDA)
+}
+
+// opSTRINGS returns readable character runs of a file (like strings(1)).
+//
+// Payload: path string, start_offset u32, min_len u8 (0 -> 4), max_scan_bytes u32 (0 -> default).
+// Response: count u16, runs[] (offset u32, len u16, bytes), next_offset u32 (0xFFFFFFFF = end of file).
+//
+// Runs are returned raw (no PETSCII conversion). A run that is cut by the scan
+// budget is re-scanned by the next request (next_offset points at its start).
+func (s *Server) opSTRINGS(cfg config.Config, limits Limits, payload []byte, rootAbs string) (byte, []byte, string) {
+	d := proto.NewDecoder(payload)
+	p, err := s.readPathStringRead(cfg, d)
+	if err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	start, err := d.ReadU32()
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	minLen, err := d.ReadU8()
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	maxScan, err := d.ReadU32()
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	if d.Remaining() != 0 {
+		return proto.StatusBadRequest, nil, "extra bytes in STRINGS"
+	}
+	if minLen == 0 {
+		minLen = stringsDefaultMinLen
+	}
+	if maxScan == 0 {
+		maxScan = stringsDefaultMaxScan
+	}
+	if maxScan > stringsMaxMaxScan {
+		maxScan = stringsMaxMaxScan
+	}
+
+	if limits.DiskImagesEnabled {
+		if _, _, inner, ok := splitDiskImagePath(p); ok && inner != "" {
+			return proto.StatusNotSupported, nil, "STRINGS is not supported inside disk images"
+		}
+	}
+
+	abs, _, err := resolveReadPathWithCompat(cfg, rootAbs, p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return proto.StatusNotFound, nil, "not found"
+		}
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	f, err := os.Open(abs)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return proto.StatusNotFound, nil, "not found"
+		}
+		if errors.Is(err, fs.ErrPermission) {
+			return proto.StatusAccessDenied, nil, "access denied"
+		}
+		return proto.StatusInternal, nil, err.Error()
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+	if fi.IsDir() {
+		return proto.StatusIsADir, nil, "is a directory"
+	}
+	size := uint64(fi.Size())
+	if uint64(start) > size {
+		return proto.StatusRangeInvalid, nil, "start offset beyond end of file"
+	}
+	if _, err := f.Seek(int64(start), io.SeekStart); err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+
+	bufSize := int(cfg.MaxChunk)
+	if bufSize < 4096 {
+		bufSize = 4096
+	}
+	r := bufio.NewReaderSize(io.LimitReader(f, int64(maxScan)), bufSize)
+
+	resp := make([]byte, stringsResponseCountSize, 256)
+	count := uint16(0)
+	full := false
+
+	// emit appends one run; it returns false if the response is full.
+	emit := func(off uint64, run []byte) bool {
+		if count == 0xFFFF || len(resp)+stringsEntryOverhead+len(run)+stringsResponseTrailer > int(cfg.MaxPayload) {
+			full = true
+			return false
+		}
+		e := proto.NewEncoder(stringsEntryOverhead + len(run))
+		e.WriteU32(clampU32(off))
+		e.WriteU16(uint16(len(run)))
+		e.WriteBytes(run)
+		resp = append(resp, e.Bytes()...)
+		count++
+		return true
+	}
+
+	pos := uint64(start)
+	runStart := uint64(0)
+	run := make([]byte, 0, stringsMaxRun)
+	next := stringsEndOffset
+	eof := false
+
+	for !full {
+		b, rerr := r.ReadByte()
+		if rerr != nil {
+			if rerr != io.EOF {
+				return proto.StatusInternal, nil, rerr.Error()
+			}
+			eof = pos >= size
+			break
+		}
+		if isStringsPrintable(b) {
+			if len(run) == 0 {
+				runStart = pos
+			}
+			run = append(run, b)
+			if len(run) == stringsMaxRun {
+				if !emit(runStart, run) {
+					next = uint32(runStart)
+					break
+				}
+				run = run[:0]
+			}
+		} else if len(run) > 0 {
+			if len(run) >= int(minLen) && !emit(runStart, run) {
+				next = uint32(runStart)
+				break
+			}
+			run = run[:0]
+		}
+		pos++
+	}
+
+	if !full {
+		switch {
+		case eof:
+			if len(run) >= int(minLen) && !emit(runStart, run) {
+				next = uint32(runStart)
+			}
+		case len(run) > 0 && runStart > uint64(start):
+			// Budget ended inside a run: resume at its start next time.
+			next = uint32(runStart)
+		default:
+			if len(run) >= int(minLen) && !emit(runStart, run) {
+				next = uint32(runStart)
+			} else {
+				next = clampU32(pos)
+			}
+		}
+	}
+
+	// Patch count and append next_offset.
+	binary.LittleEndian.PutUint16(resp[0:2], count)
+	resp = binary.LittleEndian.AppendUint32(resp, next)
+	return proto.StatusOK, resp, ""
+}
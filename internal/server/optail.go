@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/diskimage"
+	"wicos64-server/internal/fsops"
+	"wicos64-server/internal/proto"
+)
+
+// opREAD_TAIL returns the last bytes of a file without the client knowing its size.
+//
+// Payload: path string, count u16 (0 -> max_chunk).
+// Response: start_offset u32, raw bytes (up to count, until EOF).
+//
+// The client can continue with READ_RANGE at start_offset+len(bytes) to follow
+// a growing file.
+func (s *Server) opREAD_TAIL(cfg config.Config, limits Limits, payload []byte, rootAbs string) (byte, []byte, string) {
+	d := proto.NewDecoder(payload)
+	p, err := s.readPathStringRead(cfg, d)
+	if err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	count, err := d.ReadU16()
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	if d.Remaining() != 0 {
+		return proto.StatusBadRequest, nil, "extra bytes in READ_TAIL"
+	}
+	if count == 0 {
+		count = cfg.MaxChunk
+	}
+	if count > cfg.MaxChunk {
+		return proto.StatusTooLarge, nil, "chunk too large"
+	}
+
+	// tailStart computes the offset of the last count bytes of a size-byte file.
+	tailStart := func(size uint64) (uint64, uint64) {
+		if size <= uint64(count) {
+			return 0, size
+		}
+		return size - uint64(count), uint64(count)
+	}
+
+	// Disk image virtual directories (.d64/.d71/.d81/.t64)
+	if limits.DiskImagesEnabled {
+		if kind, mountPath, inner, ok := splitDiskImagePath(p); ok {
+			imgAbs, fe, st, msg := resolveDiskImageFile(rootAbs, kind, mountPath, inner, cfg.Compat.FallbackPRGExtension)
+			if st != proto.StatusOK {
+				return st, nil, msg
+			}
+			off, n := tailStart(fe.Size)
+			data := []byte{}
+			if n > 0 {
+				data, err = diskimage.ReadFileRange(imgAbs, fe, off, n)
+				if err != nil {
+					return proto.StatusInternal, nil, err.Error()
+				}
+			}
+			return proto.StatusOK, encodeReadTail(off, data), ""
+		}
+	}
+
+	abs, _, err := resolveReadPathWithCompat(cfg, rootAbs, p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return proto.StatusNotFound, nil, "not found"
+		}
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	st, err := fsops.Stat(abs)
+	if err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+	if !st.Exists {
+		return proto.StatusNotFound, nil, "not found"
+	}
+	if st.IsDir {
+		return proto.StatusIsADir, nil, "is a directory"
+	}
+	if st.Size > 0xFFFFFFFF {
+		return proto.StatusTooLarge, nil, "file too large for u32 offsets"
+	}
+
+	f, err := os.Open(abs)
+	if err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+	defer f.Close()
+	off, n := tailStart(st.Size)
+	if _, err := f.Seek(int64(off), io.SeekStart); err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+	buf := make([]byte, int(n))
+	// The file may shrink between Stat and Read; return what is there.
+	m, err := io.ReadFull(f, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return proto.StatusInternal, nil, err.Error()
+	}
+	return proto.StatusOK, encodeReadTail(off, buf[:m]), ""
+}
+
+func encodeReadTail(off uint64, data []byte) []byte {
+	resp := make([]byte, 4+len(data))
+	binary.LittleEndian.PutUint32(resp[0:4], uint32(off))
+	copy(resp[4:], data)
+	return resp
+}
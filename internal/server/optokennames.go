@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+// opTOKEN_NAMES lists the public names of the configured tokens (tokens[]) for
+// a device picker. Secrets, roots and quotas are never included; unnamed
+// tokens are skipped. Requires cfg.ExposeTokenNames.
+//
+// Payload: start_index u16, max_entries u16 (0 -> max_entries).
+// Response: count u16, entries[] (enabled u8, name string), next_index u16 (0xFFFF = end).
+func (s *Server) opTOKEN_NAMES(cfg config.Config, payload []byte) (byte, []byte, string) {
+	d := proto.NewDecoder(payload)
+	start, err := d.ReadU16()
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	maxEntriesReq, err := d.ReadU16()
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	if d.Remaining() != 0 {
+		return proto.StatusBadRequest, nil, "extra bytes in TOKEN_NAMES"
+	}
+	if !cfg.ExposeTokenNames {
+		return proto.StatusAccessDenied, nil, "token names are not exposed"
+	}
+
+	maxEntries := cfg.MaxEntries
+	if maxEntriesReq != 0 && maxEntriesReq < maxEntries {
+		maxEntries = maxEntriesReq
+	}
+	if maxEntries == 0 {
+		maxEntries = 1
+	}
+
+	type tokenName struct {
+		name    string
+		enabled bool
+	}
+	var names []tokenName
+	for _, t := range cfg.Tokens {
+		name := strings.TrimSpace(t.Name)
+		if strings.TrimSpace(t.Token) == "" || name == "" {
+			continue
+		}
+		names = append(names, tokenName{name: name, enabled: t.Enabled == nil || *t.Enabled})
+	}
+
+	buf := make([]byte, 2, 128)
+	count := uint16(0)
+	idx := int(start)
+	for idx < len(names) && count < maxEntries {
+		enc := proto.NewEncoder(2 + len(names[idx].name) + 1)
+		if names[idx].enabled {
+			enc.WriteU8(1)
+		} else {
+			enc.WriteU8(0)
+		}
+		if err := enc.WriteString(names[idx].name); err != nil {
+			return proto.StatusInternal, nil, err.Error()
+		}
+		entryBytes := enc.Bytes()
+		// +2 for next_index u16 at end
+		if len(buf)+len(entryBytes)+2 > int(cfg.MaxPayload) {
+			break
+		}
+		buf = append(buf, entryBytes...)
+		idx++
+		count++
+	}
+
+	nextIndex := uint16(0xFFFF)
+	if idx < len(names) {
+		nextIndex = uint16(idx)
+	}
+	buf = proto.AppendU16(buf, nextIndex)
+	binary.LittleEndian.PutUint16(buf[0:2], count)
+	return proto.StatusOK, buf, ""
+}
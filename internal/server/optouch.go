@@ -0,0 +1,103 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/fsops"
+	"wicos64-server/internal/proto"
+)
+
+// opTOUCH sets the modification time of a file or directory and can create an
+// empty file.
+//
+// Flags: CREATE (bit1) creates a missing file (parent must exist).
+// Payload: path string, mtime u32 (unix seconds, 0 = now). Response: empty.
+//
+// Files inside disk images have no per-file timestamps, so TOUCH is rejected
+// there.
+func (s *Server) opTOUCH(cfg config.Config, limits Limits, flags byte, payload []byte, rootAbs string) (byte, []byte, string) {
+	release, ok := s.lockWrite(cfg, limits, proto.OpTOUCH, payload, rootAbs, false)
+	if !ok {
+		return proto.StatusBusy, nil, "busy"
+	}
+	defer release()
+
+	d := proto.NewDecoder(payload)
+	p, err := s.readPathString(cfg, d)
+	if err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	mtime, err := d.ReadU32()
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	if d.Remaining() != 0 {
+		return proto.StatusBadRequest, nil, "extra bytes in TOUCH"
+	}
+	if p == "/" {
+		return proto.StatusAccessDenied, nil, "cannot touch root"
+	}
+
+	if _, _, inner, ok := splitDiskImagePath(p); ok && inner != "" {
+		if !limits.DiskImagesEnabled {
+			return proto.StatusNotSupported, nil, "disk images are disabled"
+		}
+		if !limits.DiskImagesWriteEnabled {
+			return proto.StatusAccessDenied, nil, "disk images are read-only"
+		}
+		return proto.StatusNotSupported, nil, "TOUCH is not supported inside disk images (no per-file timestamps)"
+	}
+
+	abs, err := fsops.ToOSPath(rootAbs, p)
+	if err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	if err := fsops.LstatNoSymlink(rootAbs, abs, true); err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+
+	st, err := fsops.Stat(abs)
+	if err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+	if !st.Exists {
+		if flags&proto.FlagT_CREATE == 0 {
+			return proto.StatusNotFound, nil, "not found"
+		}
+		pst, err := fsops.Stat(filepath.Dir(abs))
+		if err != nil {
+			return proto.StatusInternal, nil, err.Error()
+		}
+		if !pst.Exists || !pst.IsDir {
+			return proto.StatusNotFound, nil, "parent directory missing"
+		}
+		f, err := os.OpenFile(abs, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			if errors.Is(err, fs.ErrExist) {
+				return proto.StatusAlreadyExists, nil, "already exists"
+			}
+			if errors.Is(err, fs.ErrPermission) {
+				return proto.StatusAccessDenied, nil, "access denied"
+			}
+			return proto.StatusInternal, nil, err.Error()
+		}
+		_ = f.Close()
+	}
+
+	t := time.Now()
+	if mtime != 0 {
+		t = time.Unix(int64(mtime), 0)
+	}
+	if err := os.Chtimes(abs, t, t); err != nil {
+		if errors.Is(err, fs.ErrPermission) {
+			return proto.StatusAccessDenied, nil, "access denied"
+		}
+		return proto.StatusInternal, nil, err.Error()
+	}
+	return proto.StatusOK, nil, ""
+}
@@ -0,0 +1,328 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/fsops"
+	"wicos64-server/internal/pathutil"
+	"wicos64-server/internal/proto"
+)
+
+// trashPathExt is the extension of the file next to a trash ID directory
+// that holds the original W64 path of the trashed item.
+const trashPathExt = ".path"
+
+// trashEntry is one trashed item: <trashDir>/<ID>/<original path>.
+type trashEntry struct {
+	ID      string
+	Path    string // original W64 path, e.g. "/USR/GAME.PRG"
+	Abs     string // current location inside the trash
+	Deleted time.Time
+	IsDir   bool
+	Size    uint64 // bytes (whole tree for directories)
+}
+
+func trashDirAbs(cfg config.Config, rootAbs string) string {
+	trashDir := strings.TrimSpace(cfg.TrashDir)
+	if trashDir == "" {
+		trashDir = ".TRASH"
+	}
+	return filepath.Join(rootAbs, trashDir)
+}
+
+// trashEntries lists the items in the trash of rootAbs, sorted by ID (that is
+// by deletion time; new items go to the end).
+//
+// Entries trashed before the .path files existed are resolved by descending
+// while a directory has exactly one subdirectory and nothing else.
+func trashEntries(cfg config.Config, rootAbs string) ([]trashEntry, error) {
+	base := trashDirAbs(cfg, rootAbs)
+	dirents, err := os.ReadDir(base)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var out []trashEntry
+	for _, de := range dirents {
+		if !de.IsDir() {
+			continue
+		}
+		id := de.Name()
+		idAbs := filepath.Join(base, id)
+		var rel string
+		if b, err := os.ReadFile(idAbs + trashPathExt); err == nil {
+			rel = strings.Trim(filepath.ToSlash(strings.TrimSpace(string(b))), "/")
+		} else {
+			rel = guessTrashRel(idAbs)
+		}
+		if rel == "" || strings.HasPrefix(rel, "../") || rel == ".." {
+			continue
+		}
+		abs := filepath.Join(idAbs, filepath.FromSlash(rel))
+		fi, err := os.Lstat(abs)
+		if err != nil || fi.Mode()&os.ModeSymlink != 0 {
+			// Already purged (or tampered with).
+			continue
+		}
+		e := trashEntry{ID: id, Path: "/" + rel, Abs: abs, IsDir: fi.IsDir()}
+		if t, ok := parseTrashIDTime(id); ok {
+			e.Deleted = t
+		} else {
+			e.Deleted = fi.ModTime()
+		}
+		if e.IsDir {
+			if _, _, n, err := countTreeSize(abs); err == nil {
+				e.Size = n
+			}
+		} else {
+			e.Size = uint64(fi.Size())
+		}
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+func guessTrashRel(idAbs string) string {
+	var parts []string
+	cur := idAbs
+	for {
+		des, err := os.ReadDir(cur)
+		if err != nil || len(des) != 1 {
+			break
+		}
+		parts = append(parts, des[0].Name())
+		if !des[0].IsDir() {
+			break
+		}
+		cur = filepath.Join(cur, des[0].Name())
+	}
+	return strings.Join(parts, "/")
+}
+
+// opTRASH_LS lists the trash of the token's root.
+//
+// Payload: start_index u16, max_entries u16 (0 = server maximum).
+// Response: count u16, entries (type u8 (0 file, 1 dir), size u32,
+// deleted_unix u32, id string, path string), next_index u16 (0xFFFF = end).
+//
+// Entries are ordered by ID, so items trashed while a client pages through
+// the list are appended at the end. The ID stays the same for the lifetime
+// of the entry.
+func (s *Server) opTRASH_LS(cfg config.Config, payload []byte, rootAbs string) (byte, []byte, string) {
+	d := proto.NewDecoder(payload)
+	start, err := d.ReadU16()
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	maxReq, err := d.ReadU16()
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	if d.Remaining() != 0 {
+		return proto.StatusBadRequest, nil, "extra bytes in TRASH_LS"
+	}
+	if !cfg.TrashEnabled {
+		return proto.StatusNotSupported, nil, "trash is disabled"
+	}
+	maxEntries := cfg.MaxEntries
+	if maxReq != 0 && maxReq < maxEntries {
+		maxEntries = maxReq
+	}
+	if maxEntries == 0 {
+		maxEntries = 1
+	}
+
+	entries, err := trashEntries(cfg, rootAbs)
+	if err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+
+	buf := []byte{0x00, 0x00}
+	count := uint16(0)
+	idx := int(start)
+	for idx < len(entries) && count < maxEntries {
+		te := entries[idx]
+		enc := proto.NewEncoder(48)
+		etype := byte(0)
+		if te.IsDir {
+			etype = 1
+		}
+		size := uint32(0xFFFFFFFF)
+		if te.Size < uint64(size) {
+			size = uint32(te.Size)
+		}
+		enc.WriteU8(etype)
+		enc.WriteU32(size)
+		enc.WriteU32(uint32(te.Deleted.Unix()))
+		_ = enc.WriteString(te.ID)
+		_ = enc.WriteString(te.Path)
+		entry := enc.Bytes()
+		// Need room for the entry + trailing next_index.
+		if len(buf)+len(entry)+2 > int(cfg.MaxPayload) {
+			if count == 0 {
+				return proto.StatusTooLarge, nil, "TRASH_LS entry too large"
+			}
+			break
+		}
+		buf = append(buf, entry...)
+		count++
+		idx++
+	}
+	next := uint16(0xFFFF)
+	if idx < len(entries) {
+		next = uint16(idx)
+	}
+	buf[0], buf[1] = byte(count), byte(count>>8)
+	buf = proto.AppendU16(buf, next)
+	return proto.StatusOK, buf, ""
+}
+
+// opTRASH_RESTORE moves a trashed item back into the tree.
+//
+// Payload: id string (from TRASH_LS), path string (destination; empty = the
+// original path).
+// Flags: FlagTRR_OVERWRITE replaces an existing file at the destination; the
+// replaced file goes to the trash (needs enable_overwrite). Directories are
+// never merged.
+// Response: path string (where the item was restored).
+//
+// Missing parent directories of the destination are created. Usage does not
+// change (the trash is part of the root), only max_file_bytes is checked.
+func (s *Server) opTRASH_RESTORE(cfg config.Config, limits Limits, flags byte, payload []byte, rootAbs string) (byte, []byte, string) {
+	release, ok := s.lockWrite(cfg, limits, proto.OpTRASH_RESTORE, payload, rootAbs, false)
+	if !ok {
+		return proto.StatusBusy, nil, "server busy"
+	}
+	defer release()
+
+	d := proto.NewDecoder(payload)
+	id, err := d.ReadString(0xFFFF)
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	dst, err := d.ReadString(cfg.MaxPath)
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	if d.Remaining() != 0 {
+		return proto.StatusBadRequest, nil, "extra bytes in TRASH_RESTORE"
+	}
+	if !cfg.TrashEnabled {
+		return proto.StatusNotSupported, nil, "trash is disabled"
+	}
+
+	entries, err := trashEntries(cfg, rootAbs)
+	if err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+	var te *trashEntry
+	for i := range entries {
+		if entries[i].ID == id {
+			te = &entries[i]
+			break
+		}
+	}
+	if te == nil {
+		return proto.StatusNotFound, nil, "no such trash entry"
+	}
+
+	// The original path keeps its case; ToOSPath still matches existing
+	// directories case-insensitively.
+	if dst == "" {
+		dst = te.Path
+	}
+	dst, err = pathutil.Normalize(dst, cfg.MaxPath, cfg.MaxName)
+	if err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	if dst == "/" {
+		return proto.StatusInvalidPath, nil, "cannot restore to /"
+	}
+	if _, _, inner, ok := splitDiskImagePath(dst); ok && inner != "" && limits.DiskImagesEnabled {
+		return proto.StatusNotSupported, nil, "cannot restore into a disk image"
+	}
+	dstAbs, err := fsops.ToOSPath(rootAbs, dst)
+	if err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	if isTopLevelDir(rootAbs, dstAbs, filepath.Base(trashDirAbs(cfg, rootAbs))) {
+		return proto.StatusInvalidPath, nil, "cannot restore into the trash"
+	}
+	// Missing parents are created below, so check the part that exists.
+	existing, root := dstAbs, filepath.Clean(rootAbs)
+	for len(existing) > len(root) {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	if err := fsops.LstatNoSymlink(rootAbs, existing, false); err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	if !te.IsDir && limits.MaxFileBytes > 0 && te.Size > limits.MaxFileBytes {
+		return proto.StatusTooLarge, nil, "max file size exceeded"
+	}
+
+	st, err := fsops.Stat(dstAbs)
+	if err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+	if st.Exists {
+		if st.IsDir || te.IsDir {
+			return proto.StatusAlreadyExists, nil, "destination exists"
+		}
+		if flags&proto.FlagTRR_OVERWRITE == 0 {
+			return proto.StatusAlreadyExists, nil, "destination exists; set OVERWRITE to replace"
+		}
+		if !cfg.EnableOverwrite {
+			return proto.StatusAccessDenied, nil, "overwrite disabled by server"
+		}
+		if _, err := s.moveToTrash(cfg, rootAbs, dstAbs); err != nil {
+			return proto.StatusInternal, nil, err.Error()
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(dstAbs), 0o755); err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+	if err := os.Rename(te.Abs, dstAbs); err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+	removeTrashID(trashDirAbs(cfg, rootAbs), id)
+
+	rel, err := filepath.Rel(rootAbs, dstAbs)
+	if err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+	e := proto.NewEncoder(len(rel) + 3)
+	_ = e.WriteString("/" + filepath.ToSlash(rel))
+	return proto.StatusOK, e.Bytes(), ""
+}
+
+// removeTrashID removes the (now empty) directory tree of a restored trash
+// entry and its .path file. Anything still holding files is left alone.
+func removeTrashID(base, id string) {
+	idAbs := filepath.Join(base, id)
+	var dirs []string
+	_ = filepath.WalkDir(idAbs, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			dirs = append(dirs, p)
+		}
+		return nil
+	})
+	for i := len(dirs) - 1; i >= 0; i-- {
+		_ = os.Remove(dirs[i])
+	}
+	if _, err := os.Lstat(idAbs); errors.Is(err, fs.ErrNotExist) {
+		_ = os.Remove(idAbs + trashPathExt)
+	}
+}
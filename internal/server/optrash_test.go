@@ -0,0 +1,216 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+type trashLsItem struct {
+	dir  bool
+	size uint32
+	id   string
+	path string
+}
+
+func trashLs(t *testing.T, s *Server, cfg config.Config, rootAbs string) []trashLsItem {
+	t.Helper()
+	e := proto.NewEncoder(4)
+	e.WriteU16(0)
+	e.WriteU16(0)
+	st, resp, msg := s.dispatch(cfg, Limits{}, proto.OpTRASH_LS, 0, e.Bytes(), rootAbs)
+	if st != proto.StatusOK {
+		t.Fatalf("TRASH_LS = %s (%s)", statusName(st), msg)
+	}
+	d := proto.NewDecoder(resp)
+	n, _ := d.ReadU16()
+	var out []trashLsItem
+	for i := 0; i < int(n); i++ {
+		typ, _ := d.ReadU8()
+		size, _ := d.ReadU32()
+		_, _ = d.ReadU32()
+		id, _ := d.ReadString(0xFFFF)
+		p, err := d.ReadString(0xFFFF)
+		if err != nil {
+			t.Fatalf("TRASH_LS entry %d: %v", i, err)
+		}
+		out = append(out, trashLsItem{dir: typ == 1, size: size, id: id, path: p})
+	}
+	if next, _ := d.ReadU16(); next != 0xFFFF {
+		t.Fatalf("TRASH_LS next = %d, want end", next)
+	}
+	return out
+}
+
+func restorePayload(id, dst string) []byte {
+	e := proto.NewEncoder(4 + len(id) + len(dst))
+	_ = e.WriteString(id)
+	_ = e.WriteString(dst)
+	return e.Bytes()
+}
+
+func TestTRASH_RESTORERoundTrip(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, func(c *config.Config) {
+		c.TrashEnabled = true
+		c.EnableOverwrite = true
+	})
+	var limits Limits
+	writeFiles(t, rootAbs, map[string]string{
+		"USR/GAME.PRG":    "game v1",
+		"USR/SUB/A.SEQ":   "aa",
+		"USR/SUB/B/C.SEQ": "ccc",
+	})
+	read := func(p string) string {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join(rootAbs, filepath.FromSlash(p)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	restore := func(flags byte, id, dst string) (byte, string) {
+		t.Helper()
+		st, resp, msg := s.dispatch(cfg, limits, proto.OpTRASH_RESTORE, flags, restorePayload(id, dst), rootAbs)
+		if st != proto.StatusOK {
+			return st, msg
+		}
+		p, _ := proto.NewDecoder(resp).ReadString(0xFFFF)
+		return st, p
+	}
+
+	if st, _, msg := s.dispatch(cfg, limits, proto.OpRM, 0, pathPayload("/USR/GAME.PRG"), rootAbs); st != proto.StatusOK {
+		t.Fatalf("RM = %s (%s)", statusName(st), msg)
+	}
+	if st, _, msg := s.dispatch(cfg, limits, proto.OpRMDIR, proto.FlagRD_RECURSIVE, pathPayload("/USR/SUB"), rootAbs); st != proto.StatusOK {
+		t.Fatalf("RMDIR -r = %s (%s)", statusName(st), msg)
+	}
+	items := trashLs(t, s, cfg, rootAbs)
+	if len(items) != 2 {
+		t.Fatalf("TRASH_LS = %+v, want 2 entries", items)
+	}
+	// IDs within the same second sort randomly.
+	if items[0].dir {
+		items[0], items[1] = items[1], items[0]
+	}
+	file, dir := items[0], items[1]
+	if file.path != "/USR/GAME.PRG" || file.dir || file.size != 7 {
+		t.Errorf("file entry = %+v", file)
+	}
+	if dir.path != "/USR/SUB" || !dir.dir || dir.size != 5 {
+		t.Errorf("dir entry = %+v", dir)
+	}
+
+	// The same file name was written again after the delete: not clobbered.
+	writeFiles(t, rootAbs, map[string]string{"USR/GAME.PRG": "game v2"})
+	if st, msg := restore(0, file.id, ""); st != proto.StatusAlreadyExists {
+		t.Fatalf("restore over a newer file = %s (%s), want ALREADY_EXISTS", statusName(st), msg)
+	}
+	if got := read("USR/GAME.PRG"); got != "game v2" {
+		t.Fatalf("newer file = %q after a refused restore", got)
+	}
+
+	// To another path; missing parents are created.
+	if st, p := restore(0, file.id, "/OLD/GAME.PRG"); st != proto.StatusOK || p != "/OLD/GAME.PRG" {
+		t.Fatalf("restore to /OLD/GAME.PRG = %s %q", statusName(st), p)
+	}
+	if got := read("OLD/GAME.PRG"); got != "game v1" {
+		t.Fatalf("restored file = %q", got)
+	}
+	if st, msg := restore(0, file.id, ""); st != proto.StatusNotFound {
+		t.Fatalf("restoring an entry twice = %s (%s), want NOT_FOUND", statusName(st), msg)
+	}
+
+	// The directory goes back to where it was, whole.
+	if st, p := restore(0, dir.id, ""); st != proto.StatusOK || p != "/USR/SUB" {
+		t.Fatalf("restore dir = %s %q", statusName(st), p)
+	}
+	if read("USR/SUB/A.SEQ") != "aa" || read("USR/SUB/B/C.SEQ") != "ccc" {
+		t.Fatal("restored directory lost files")
+	}
+	if items := trashLs(t, s, cfg, rootAbs); len(items) != 0 {
+		t.Fatalf("trash after restoring everything = %+v", items)
+	}
+	if des, _ := os.ReadDir(trashDirAbs(cfg, rootAbs)); len(des) != 0 {
+		t.Fatalf("trash dir still holds %d entries", len(des))
+	}
+
+	// OVERWRITE replaces the newer file, which goes to the trash in turn.
+	if st, _, msg := s.dispatch(cfg, limits, proto.OpRM, 0, pathPayload("/OLD/GAME.PRG"), rootAbs); st != proto.StatusOK {
+		t.Fatalf("RM = %s (%s)", statusName(st), msg)
+	}
+	items = trashLs(t, s, cfg, rootAbs)
+	if st, p := restore(proto.FlagTRR_OVERWRITE, items[0].id, "/USR/GAME.PRG"); st != proto.StatusOK || p != "/USR/GAME.PRG" {
+		t.Fatalf("restore with OVERWRITE = %s %q", statusName(st), p)
+	}
+	if got := read("USR/GAME.PRG"); got != "game v1" {
+		t.Fatalf("overwritten file = %q", got)
+	}
+	items = trashLs(t, s, cfg, rootAbs)
+	if len(items) != 1 || items[0].path != "/USR/GAME.PRG" || items[0].size != 7 {
+		t.Fatalf("trash after OVERWRITE = %+v, want the replaced file", items)
+	}
+
+	// Directories are never merged, even with OVERWRITE.
+	if st, _, msg := s.dispatch(cfg, limits, proto.OpRMDIR, proto.FlagRD_RECURSIVE, pathPayload("/USR/SUB"), rootAbs); st != proto.StatusOK {
+		t.Fatalf("RMDIR -r = %s (%s)", statusName(st), msg)
+	}
+	writeFiles(t, rootAbs, map[string]string{"USR/SUB/NEW": "n"})
+	var dirID string
+	for _, it := range trashLs(t, s, cfg, rootAbs) {
+		if it.dir {
+			dirID = it.id
+		}
+	}
+	if st, msg := restore(proto.FlagTRR_OVERWRITE, dirID, ""); st != proto.StatusAlreadyExists {
+		t.Fatalf("restore dir over a dir = %s (%s), want ALREADY_EXISTS", statusName(st), msg)
+	}
+
+	// The destination is sandboxed like any other path.
+	for _, dst := range []string{"/", "/../ESC", "/.TRASH/X"} {
+		if st, msg := restore(0, dirID, dst); st != proto.StatusInvalidPath {
+			t.Errorf("restore to %q = %s (%s), want INVALID_PATH", dst, statusName(st), msg)
+		}
+	}
+	limits.ReadOnly = true
+	if st, msg := restore(0, dirID, "/ELSEWHERE"); st != proto.StatusAccessDenied {
+		t.Errorf("restore with a read-only token = %s (%s), want ACCESS_DENIED", statusName(st), msg)
+	}
+}
+
+func TestAdminTrashRestore(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, func(c *config.Config) { c.TrashEnabled = true })
+	writeFiles(t, rootAbs, map[string]string{"DOC/README": "hello"})
+	if st, _, msg := s.dispatch(cfg, Limits{}, proto.OpRM, 0, pathPayload("/DOC/README"), rootAbs); st != proto.StatusOK {
+		t.Fatalf("RM = %s (%s)", statusName(st), msg)
+	}
+
+	w := httptest.NewRecorder()
+	s.handleAdminTrashList(w, httptest.NewRequest("GET", "/?token_kind=no_auth", nil))
+	var list struct {
+		OK      bool              `json:"ok"`
+		Entries []adminTrashEntry `json:"entries"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || !list.OK || len(list.Entries) != 1 {
+		t.Fatalf("trash list = %d %s", w.Code, w.Body.String())
+	}
+	if e := list.Entries[0]; e.Path != "/DOC/README" || e.Type != "file" || e.Size != 5 {
+		t.Fatalf("trash entry = %+v", e)
+	}
+
+	body, _ := json.Marshal(adminTrashRestoreRequest{TokenKind: "no_auth", ID: list.Entries[0].ID})
+	w = httptest.NewRecorder()
+	s.handleAdminTrashRestore(w, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("restore = %d %s", w.Code, w.Body.String())
+	}
+	if b, err := os.ReadFile(filepath.Join(rootAbs, "DOC", "README")); err != nil || string(b) != "hello" {
+		t.Fatalf("restored file = %q, %v", b, err)
+	}
+}
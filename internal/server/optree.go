@@ -0,0 +1,192 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/fsops"
+	"wicos64-server/internal/proto"
+)
+
+const (
+	treeDefaultDepth = 4
+	treeMaxDepth     = 16
+	treeDefaultNodes = 256
+	treeMaxNodes     = 2048
+
+	// treeChildUnknown marks nodes whose children are not counted (mounted disk images).
+	treeChildUnknown = 0xFFFF
+)
+
+// errTreeFull stops the walk once the node or payload budget is used up.
+var errTreeFull = errors.New("tree full")
+
+// opTREE returns the shape of a directory hierarchy.
+//
+// Payload: base path string, max_depth u8 (0 -> 4), max_nodes u16 (0 -> 256).
+// Flags: FlagTR_FILES includes files (default: directories only).
+// Response: count u16, truncated u8, nodes[] in pre-order:
+//
+//	depth u8 (0 = direct child of base), type u8 (0=file, 1=dir), child_count u16, name string
+//
+// child_count counts the same kind of entries that are listed (dirs, or dirs+files).
+// Disk images are shown as directories with child_count 0xFFFF and are not expanded.
+func (s *Server) opTREE(cfg config.Config, limits Limits, flags byte, payload []byte, rootAbs string) (byte, []byte, string) {
+	d := proto.NewDecoder(payload)
+	base, err := s.readPathString(cfg, d)
+	if err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	maxDepth, err := d.ReadU8()
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	maxNodes, err := d.ReadU16()
+	if err != nil {
+		return proto.StatusBadRequest, nil, err.Error()
+	}
+	if d.Remaining() != 0 {
+		return proto.StatusBadRequest, nil, "extra bytes in TREE"
+	}
+	if maxDepth == 0 {
+		maxDepth = treeDefaultDepth
+	}
+	if maxDepth > treeMaxDepth {
+		maxDepth = treeMaxDepth
+	}
+	if maxNodes == 0 {
+		maxNodes = treeDefaultNodes
+	}
+	if maxNodes > treeMaxNodes {
+		maxNodes = treeMaxNodes
+	}
+	withFiles := flags&proto.FlagTR_FILES != 0
+
+	if isInsideDiskImage(limits, base) {
+		return proto.StatusNotSupported, nil, "TREE is not supported inside disk images"
+	}
+
+	baseAbs, err := fsops.ToOSPath(rootAbs, base)
+	if err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	if err := fsops.LstatNoSymlink(rootAbs, baseAbs, false); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return proto.StatusNotFound, nil, "not found"
+		}
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	st, err := fsops.Stat(baseAbs)
+	if err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+	if !st.Exists {
+		return proto.StatusNotFound, nil, "not found"
+	}
+	if !st.IsDir {
+		return proto.StatusNotADir, nil, "not a directory"
+	}
+
+	isImage := func(name string) bool {
+		return limits.DiskImagesEnabled && isDiskImageName(name)
+	}
+
+	// children returns the listed entries of dir, sorted like LS.
+	children := func(dir string) ([]os.DirEntry, error) {
+		ents, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		out := ents[:0]
+		for _, e := range ents {
+			if e.Type()&os.ModeSymlink != 0 {
+				return nil, fsops.ErrSymlinkNotAllowed
+			}
+			if e.IsDir() || withFiles || isImage(strings.ToUpper(e.Name())) {
+				out = append(out, e)
+			}
+		}
+		sort.SliceStable(out, func(i, j int) bool {
+			return strings.ToUpper(out[i].Name()) < strings.ToUpper(out[j].Name())
+		})
+		return out, nil
+	}
+
+	resp := make([]byte, 3, 256) // count u16 + truncated u8
+	count := uint16(0)
+	truncated := false
+
+	var walk func(dir string, ents []os.DirEntry, depth int) error
+	walk = func(dir string, ents []os.DirEntry, depth int) error {
+		for _, e := range ents {
+			if count >= maxNodes {
+				return errTreeFull
+			}
+			name := strings.ToUpper(e.Name())
+			typ := byte(0)
+			childCount := uint16(0)
+			var sub []os.DirEntry
+			switch {
+			case e.IsDir():
+				typ = 1
+				sub, err = children(filepath.Join(dir, e.Name()))
+				if err != nil {
+					return err
+				}
+				childCount = uint16(min(len(sub), 0xFFFE))
+			case isImage(name):
+				typ = 1
+				childCount = treeChildUnknown
+			}
+
+			enc := proto.NewEncoder(8 + len(name))
+			enc.WriteU8(byte(depth))
+			enc.WriteU8(typ)
+			enc.WriteU16(childCount)
+			if err := enc.WriteString(name); err != nil {
+				return err
+			}
+			if len(resp)+len(enc.Bytes()) > int(cfg.MaxPayload) {
+				return errTreeFull
+			}
+			resp = append(resp, enc.Bytes()...)
+			count++
+
+			if e.IsDir() && len(sub) > 0 && depth+1 < int(maxDepth) {
+				if err := walk(filepath.Join(dir, e.Name()), sub, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	top, err := children(baseAbs)
+	if err == nil {
+		err = walk(baseAbs, top, 0)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, errTreeFull):
+			truncated = true
+		case errors.Is(err, fsops.ErrSymlinkNotAllowed):
+			return proto.StatusInvalidPath, nil, "symlink not allowed"
+		case errors.Is(err, fs.ErrPermission):
+			return proto.StatusAccessDenied, nil, "access denied"
+		default:
+			return proto.StatusInternal, nil, err.Error()
+		}
+	}
+
+	binary.LittleEndian.PutUint16(resp[0:2], count)
+	if truncated {
+		resp[2] = 1
+	}
+	return proto.StatusOK, resp, ""
+}
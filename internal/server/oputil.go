@@ -1,12 +1,130 @@
 package server
 
-import "wicos64-server/internal/proto"
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/fsops"
+	"wicos64-server/internal/proto"
+)
+
+// isGrowOp reports whether op can add bytes or entries under the root.
+// Deletes and renames are excluded so a full token can still free space.
+func isGrowOp(op byte) bool {
+	switch op {
+	case proto.OpWRITE_RANGE, proto.OpAPPEND, proto.OpMKDIR, proto.OpCP, proto.OpTOUCH, proto.OpMKTEMP, proto.OpLOCK, proto.OpCOPY_RANGE, proto.OpMKIMAGE:
+		return true
+	default:
+		return false
+	}
+}
 
 func isWriteOp(op byte) bool {
 	switch op {
-	case proto.OpWRITE_RANGE, proto.OpAPPEND, proto.OpMKDIR, proto.OpRMDIR, proto.OpRM, proto.OpCP, proto.OpMV:
+	case proto.OpWRITE_RANGE, proto.OpAPPEND, proto.OpMKDIR, proto.OpRMDIR, proto.OpRM, proto.OpCP, proto.OpMV, proto.OpTOUCH, proto.OpMKTEMP, proto.OpLOCK, proto.OpUNLOCK, proto.OpCOPY_RANGE, proto.OpTRASH_RESTORE, proto.OpMKIMAGE:
 		return true
 	default:
 		return false
 	}
 }
+
+// lockWrite takes the write locks for the paths a mutating op touches (see
+// writeLockPaths) and returns the release func. With wait=false it reports
+// false instead of blocking while another write holds one of the paths (the
+// op answers BUSY).
+func (s *Server) lockWrite(cfg config.Config, limits Limits, op byte, payload []byte, rootAbs string, wait bool) (release func(), ok bool) {
+	return s.lockPaths(limits, rootAbs, wait, s.writeLockPaths(cfg, op, payload)...)
+}
+
+// lockPaths locks the W64 paths exclusively (and their parents shared). A
+// path inside a disk image locks the whole image file, a wildcard path its
+// directory; no paths lock the whole root. Inside a BATCH that already holds
+// the root (limits.writeLocked) it is a no-op.
+func (s *Server) lockPaths(limits Limits, rootAbs string, wait bool, paths ...string) (release func(), ok bool) {
+	if limits.writeLocked {
+		return func() {}, true
+	}
+	keys := make([]string, 0, len(paths)+1)
+	for _, p := range paths {
+		if limits.DiskImagesEnabled {
+			if _, mountPath, _, ok := splitDiskImagePath(p); ok {
+				p = mountPath
+			}
+		}
+		if strings.ContainsAny(p, "*?") {
+			p, _ = splitDirBase(p)
+		}
+		keys = append(keys, pathLockKey(filepath.Join(rootAbs, filepath.FromSlash(p))))
+	}
+	if len(keys) == 0 {
+		keys = append(keys, pathLockKey(rootAbs))
+	}
+	return s.paths.lock(keys, wait)
+}
+
+// writeLockPaths returns the paths a write op's payload addresses. If they
+// cannot be decoded, nil is returned (the whole root is locked; the op fails
+// on the same payload anyway).
+func (s *Server) writeLockPaths(cfg config.Config, op byte, payload []byte) []string {
+	d := proto.NewDecoder(payload)
+	var paths []string
+	next := func() bool {
+		p, err := s.readPathStringRead(cfg, d)
+		if err != nil {
+			return false
+		}
+		paths = append(paths, p)
+		return true
+	}
+	switch op {
+	case proto.OpMKTEMP:
+		return []string{mktempDir}
+	case proto.OpCP, proto.OpMV:
+		if !next() || !next() {
+			return nil
+		}
+	case proto.OpCOPY_RANGE:
+		if !next() {
+			return nil
+		}
+		if _, err := d.ReadU32(); err != nil || !next() {
+			return nil
+		}
+	case proto.OpTRASH_RESTORE:
+		// id, then the destination. Restoring to the original path (empty
+		// destination) locks the whole root.
+		if _, err := d.ReadString(0xFFFF); err != nil || d.Remaining() <= 2 || !next() {
+			return nil
+		}
+	default:
+		if !next() {
+			return nil
+		}
+	}
+	return paths
+}
+
+// treeLimits returns the configured bounds for recursive directory ops.
+func treeLimits(cfg config.Config) fsops.TreeLimits {
+	return fsops.TreeLimits{MaxDepth: cfg.MaxTreeDepth, MaxFiles: cfg.MaxTreeFiles, MaxBytes: cfg.MaxTreeBytes}
+}
+
+// treeErrStatus maps errors of recursive directory ops (CopyDirRecursive,
+// RemoveTree, CheckTree) to a status and errmsg.
+func treeErrStatus(err error) (byte, string) {
+	switch {
+	case errors.Is(err, fsops.ErrTreeTooLarge):
+		return proto.StatusTooLarge, err.Error()
+	case errors.Is(err, fsops.ErrTreeTooDeep), errors.Is(err, fsops.ErrTreeCycle), errors.Is(err, fsops.ErrSymlinkNotAllowed):
+		return proto.StatusInvalidPath, err.Error()
+	case errors.Is(err, fs.ErrNotExist):
+		return proto.StatusNotFound, "not found"
+	case errors.Is(err, fs.ErrPermission):
+		return proto.StatusAccessDenied, "access denied"
+	default:
+		return proto.StatusInternal, err.Error()
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/diskimage"
+	"wicos64-server/internal/fsops"
+	"wicos64-server/internal/proto"
+)
+
+// opVERIFY checks the BAM, directory and file chains of a D64/D71 image and
+// reports inconsistencies (see diskimage.Verify). Nothing is repaired.
+//
+// Payload: path string (the image file).
+// Response: problems u16, files u16, summary string (one problem per line,
+// cut to fit max_payload; problems still counts all of them).
+func (s *Server) opVERIFY(cfg config.Config, limits Limits, payload []byte, rootAbs string) (byte, []byte, string) {
+	d := proto.NewDecoder(payload)
+	p, err := s.readPathString(cfg, d)
+	if err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	if d.Remaining() != 0 {
+		return proto.StatusBadRequest, nil, "extra bytes in VERIFY"
+	}
+	if !limits.DiskImagesEnabled {
+		return proto.StatusNotSupported, nil, "disk images are disabled"
+	}
+
+	abs, err := fsops.ToOSPath(rootAbs, p)
+	if err != nil {
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	if err := fsops.LstatNoSymlink(rootAbs, abs, false); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return proto.StatusNotFound, nil, "image not found"
+		}
+		return proto.StatusInvalidPath, nil, err.Error()
+	}
+	st, err := fsops.Stat(abs)
+	if err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+	if !st.Exists {
+		return proto.StatusNotFound, nil, "image not found"
+	}
+	if st.IsDir {
+		return proto.StatusIsADir, nil, "is a directory"
+	}
+
+	// Keep writers out while the image is read.
+	release, _ := s.lockPaths(limits, rootAbs, true, p)
+	rep, err := diskimage.Verify(abs)
+	release()
+	if err != nil {
+		var se *diskimage.StatusError
+		if errors.As(err, &se) {
+			return se.Status(), nil, se.Error()
+		}
+		return proto.StatusInternal, nil, err.Error()
+	}
+
+	// problems u16 + files u16 + string length u16.
+	room := int(cfg.MaxPayload) - 6
+	var sb strings.Builder
+	for _, line := range rep.Problems {
+		if sb.Len()+len(line)+1 > room {
+			break
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte('\n')
+		}
+		sb.WriteString(line)
+	}
+	summary := sb.String()
+	if len(rep.Problems) == 0 {
+		summary = "OK"
+	}
+
+	e := proto.NewEncoder(6 + len(summary))
+	e.WriteU16(uint16(min(len(rep.Problems), 0xFFFF)))
+	e.WriteU16(uint16(min(rep.Files, 0xFFFF)))
+	_ = e.WriteString(summary)
+	return proto.StatusOK, e.Bytes(), ""
+}
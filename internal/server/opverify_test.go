@@ -0,0 +1,96 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"wicos64-server/internal/proto"
+)
+
+// d64Offset returns the byte offset of track/sector in a 35-track .d64.
+func d64Offset(track, sector int) int {
+	off := 0
+	for t := 1; t < track; t++ {
+		switch {
+		case t <= 17:
+			off += 21 * 256
+		case t <= 24:
+			off += 19 * 256
+		case t <= 30:
+			off += 18 * 256
+		default:
+			off += 17 * 256
+		}
+	}
+	return off + sector*256
+}
+
+func TestVERIFYReportsCorruptImages(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	limits := Limits{DiskImagesEnabled: true, DiskImagesWriteEnabled: true}
+	mkImage(t, s, cfg, limits, rootAbs, "/DISK.D64", proto.ImageKindD64)
+	wr := writeRangePayload(t, "/DISK.D64/FILE.PRG", 0, bytes.Repeat([]byte("x"), 600))
+	if st, _, msg := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, proto.FlagWR_CREATE, wr, rootAbs); st != proto.StatusOK {
+		t.Fatalf("WRITE_RANGE = %s (%s)", statusName(st), msg)
+	}
+	abs := filepath.Join(rootAbs, "DISK.D64")
+	clean, err := os.ReadFile(abs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := d64Offset(18, 1) // first directory slot
+	startT, startS := int(clean[entry+3]), int(clean[entry+4])
+	first := d64Offset(startT, startS)
+
+	verify := func() (uint16, string) {
+		t.Helper()
+		st, resp, msg := s.dispatch(cfg, limits, proto.OpVERIFY, 0, pathPayload("/DISK.D64"), rootAbs)
+		if st != proto.StatusOK {
+			t.Fatalf("VERIFY = %s (%s)", statusName(st), msg)
+		}
+		d := proto.NewDecoder(resp)
+		problems, _ := d.ReadU16()
+		files, _ := d.ReadU16()
+		summary, _ := d.ReadString(0xFFFF)
+		if files != 1 {
+			t.Fatalf("files = %d, want 1", files)
+		}
+		return problems, summary
+	}
+	if n, summary := verify(); n != 0 || summary != "OK" {
+		t.Fatalf("fresh image: %d problems: %s", n, summary)
+	}
+
+	tests := []struct {
+		name    string
+		corrupt func(b []byte)
+		want    string
+	}{
+		{"block count", func(b []byte) { b[entry+30]++ }, "directory says 4 blocks, chain has 3"},
+		{"used sector marked free", func(b []byte) {
+			bam := d64Offset(18, 0) + 4*startT
+			b[bam]++
+			b[bam+1+startS/8] |= 1 << (startS % 8)
+		}, "used by \"FILE\" but marked free"},
+		{"BAM free count", func(b []byte) { b[d64Offset(18, 0)+4*startT]-- }, "BAM free count"},
+		{"chain loop", func(b []byte) { b[first], b[first+1] = byte(startT), byte(startS) }, "chain loops at"},
+		{"invalid link", func(b []byte) { b[first], b[first+1] = 99, 0 }, "invalid link 99/0"},
+		{"start outside the disk", func(b []byte) { b[entry+3] = 40 }, "is outside the disk"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := append([]byte{}, clean...)
+			tt.corrupt(b)
+			if err := os.WriteFile(abs, b, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			n, summary := verify()
+			if n == 0 || !strings.Contains(summary, tt.want) {
+				t.Fatalf("%d problems %q, want one containing %q", n, summary, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+// opWHOAMI reports the effective limits of the requesting token, which CAPS
+// (server-wide limits) cannot express. A loader can check up front whether a
+// write will fit.
+//
+// Payload: empty.
+// Response: flags u8 (proto.Who*), quota_bytes u32 (0 = none),
+// used_bytes u32, max_file_bytes u32 (0 = none).
+//
+// used_bytes comes from the usage cache. Without a quota the root is not
+// scanned just for WHOAMI; used_bytes is 0 until something else filled the
+// cache.
+func (s *Server) opWHOAMI(cfg config.Config, limits Limits, payload []byte, rootAbs string) (byte, []byte, string) {
+	if len(payload) != 0 {
+		return proto.StatusBadRequest, nil, "extra bytes in WHOAMI"
+	}
+
+	var used uint64
+	if limits.QuotaBytes > 0 {
+		u, err := s.rootUsageBytes(rootAbs)
+		if err != nil {
+			return proto.StatusInternal, nil, err.Error()
+		}
+		used = u
+	} else if s.usage != nil {
+		used, _ = s.usage.getFresh(rootAbs)
+	}
+
+	var flags byte
+	if limits.ReadOnlyWhenFull && limits.QuotaBytes > 0 && used >= limits.QuotaBytes {
+		flags |= proto.WhoQuotaFull | proto.WhoReadOnly
+	}
+	if limits.ReadOnly {
+		flags |= proto.WhoReadOnly
+	}
+	if limits.DiskImagesEnabled {
+		flags |= proto.WhoDiskImages
+		if limits.DiskImagesWriteEnabled && !limits.ReadOnly {
+			flags |= proto.WhoDiskImagesWrite
+		}
+	}
+
+	e := proto.NewEncoder(13)
+	e.WriteU8(flags)
+	e.WriteU32(clampU32(limits.QuotaBytes))
+	e.WriteU32(clampU32(used))
+	e.WriteU32(clampU32(limits.MaxFileBytes))
+	return proto.StatusOK, e.Bytes(), ""
+}
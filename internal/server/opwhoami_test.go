@@ -0,0 +1,88 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+func TestWHOAMI(t *testing.T) {
+	on := true
+	s, _, base := newTestServer(t, func(c *config.Config) {
+		c.Tokens = []config.TokenEntry{
+			{Token: "PLAIN", PathPrefix: "/PLAIN"},
+			{Token: "RO", PathPrefix: "/RO", ReadOnly: true, DiskImagesWriteEnabled: &on},
+			{Token: "QUOTA", PathPrefix: "/QUOTA", QuotaBytes: 10000, MaxFileBytes: 4000, DiskImagesWriteEnabled: &on},
+			{Token: "FULL", PathPrefix: "/FULL", QuotaBytes: 5000, ReadOnlyWhenFull: true},
+		}
+	})
+	for dir, size := range map[string]int{"PLAIN": 100, "RO": 100, "QUOTA": 3000, "FULL": 5000} {
+		if err := os.Mkdir(filepath.Join(base, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(base, dir, "F"), make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type whoami struct {
+		flags                 byte
+		quota, used, maxBytes uint32
+	}
+	tests := []struct {
+		token string
+		want  whoami
+	}{
+		// Without a quota the root is not scanned; used stays 0.
+		{"PLAIN", whoami{proto.WhoDiskImages, 0, 0, 0}},
+		// read_only wins over the token's image write switch.
+		{"RO", whoami{proto.WhoReadOnly | proto.WhoDiskImages, 0, 0, 0}},
+		{"QUOTA", whoami{proto.WhoDiskImages | proto.WhoDiskImagesWrite, 10000, 3000, 4000}},
+		{"FULL", whoami{proto.WhoReadOnly | proto.WhoQuotaFull | proto.WhoDiskImages, 5000, 5000, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.token, func(t *testing.T) {
+			st, resp := rpc(t, s, tt.token, proto.OpWHOAMI, 0, nil)
+			if st != proto.StatusOK {
+				t.Fatalf("WHOAMI = %s", statusName(st))
+			}
+			if len(resp) != 13 {
+				t.Fatalf("WHOAMI answer is %d bytes, want 13", len(resp))
+			}
+			d := proto.NewDecoder(resp)
+			var got whoami
+			got.flags, _ = d.ReadU8()
+			got.quota, _ = d.ReadU32()
+			got.used, _ = d.ReadU32()
+			got.maxBytes, _ = d.ReadU32()
+			if got != tt.want {
+				t.Fatalf("WHOAMI = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// The flags agree with what a write actually does.
+	if st := rpcStatus(t, s, "RO", proto.OpWRITE_RANGE, proto.FlagWR_CREATE, writeRangePayload(t, "/G", 0, []byte("x"))); st != proto.StatusAccessDenied {
+		t.Fatalf("write as RO = %s, want ACCESS_DENIED", statusName(st))
+	}
+	if st := rpcStatus(t, s, "FULL", proto.OpWRITE_RANGE, proto.FlagWR_CREATE, writeRangePayload(t, "/G", 0, []byte("x"))); st != proto.StatusQuotaFull {
+		t.Fatalf("write as FULL = %s, want QUOTA_FULL", statusName(st))
+	}
+	if st := rpcStatus(t, s, "QUOTA", proto.OpWRITE_RANGE, proto.FlagWR_CREATE, writeRangePayload(t, "/G", 0, make([]byte, 500))); st != proto.StatusOK {
+		t.Fatalf("write as QUOTA = %s", statusName(st))
+	}
+	st, resp := rpc(t, s, "QUOTA", proto.OpWHOAMI, 0, nil)
+	if st != proto.StatusOK {
+		t.Fatalf("WHOAMI = %s", statusName(st))
+	}
+	if used, _ := proto.NewDecoder(resp[5:]).ReadU32(); used != 3500 {
+		t.Fatalf("used after a 500-byte write = %d, want 3500", used)
+	}
+
+	if st := rpcStatus(t, s, "PLAIN", proto.OpWHOAMI, 0, []byte{0}); st != proto.StatusBadRequest {
+		t.Fatalf("WHOAMI with a payload = %s, want BAD_REQUEST", statusName(st))
+	}
+}
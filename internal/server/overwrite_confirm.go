@@ -0,0 +1,115 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/fsops"
+	"wicos64-server/internal/proto"
+)
+
+// overwriteConfirms holds the outstanding overwrite confirmations
+// (overwrite_confirm) by token and target path.
+type overwriteConfirms struct {
+	mu sync.Mutex
+	m  map[string]overwriteConfirm
+}
+
+type overwriteConfirm struct {
+	code    uint32
+	expires time.Time
+}
+
+// issue returns a fresh confirm value for key, valid for ttl. It replaces an
+// older one for the same key.
+func (oc *overwriteConfirms) issue(key string, ttl time.Duration) uint32 {
+	var b [4]byte
+	_, _ = rand.Read(b[:])
+	code := binary.LittleEndian.Uint32(b[:])
+	if code == 0 {
+		code = 1
+	}
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	now := time.Now()
+	for k, v := range oc.m {
+		if now.After(v.expires) {
+			delete(oc.m, k)
+		}
+	}
+	if oc.m == nil {
+		oc.m = map[string]overwriteConfirm{}
+	}
+	oc.m[key] = overwriteConfirm{code: code, expires: now.Add(ttl)}
+	return code
+}
+
+// take consumes the confirmation for key and reports whether code matched
+// one that has not expired yet.
+func (oc *overwriteConfirms) take(key string, code uint32) bool {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	v, ok := oc.m[key]
+	if !ok {
+		return false
+	}
+	delete(oc.m, key)
+	return v.code == code && !time.Now().After(v.expires)
+}
+
+// confirmOverwrite applies overwrite_confirm to a WRITE_RANGE chunk. A chunk
+// that would replace an existing file needs the confirm value handed out by
+// the previous attempt (FlagWR_CONFIRM); otherwise a new one is issued and
+// the chunk is refused with ALREADY_EXISTS, payload confirm u32 + valid_sec
+// u16. Chunks the write itself refuses (no OVERWRITE flag, read-only images)
+// pass through so they keep their usual error.
+func (s *Server) confirmOverwrite(cfg config.Config, limits Limits, flags byte, rootAbs, p string, confirm uint32) (byte, []byte, string) {
+	if !cfg.OverwriteConfirm || !cfg.EnableOverwrite {
+		return proto.StatusOK, nil, ""
+	}
+	if flags&proto.FlagWR_TRUNCATE == 0 || flags&proto.FlagWR_OVERWRITE == 0 {
+		return proto.StatusOK, nil, ""
+	}
+	if !replacesFile(cfg, limits, rootAbs, p) {
+		return proto.StatusOK, nil, ""
+	}
+
+	key := limits.tokenID + "\x00" + pathLockKey(filepath.Join(rootAbs, filepath.FromSlash(p)))
+	if flags&proto.FlagWR_CONFIRM != 0 && s.confirms.take(key, confirm) {
+		return proto.StatusOK, nil, ""
+	}
+	msg := "overwrite needs confirm"
+	if flags&proto.FlagWR_CONFIRM != 0 {
+		msg = "confirm expired or wrong"
+	}
+	code := s.confirms.issue(key, time.Duration(cfg.OverwriteConfirmSec)*time.Second)
+	e := proto.NewEncoder(6)
+	e.WriteU32(code)
+	e.WriteU16(uint16(cfg.OverwriteConfirmSec))
+	return proto.StatusAlreadyExists, e.Bytes(), msg
+}
+
+// replacesFile reports whether a truncating write to p hits an existing file
+// whose content would be lost: a non-empty host file or any file inside a
+// writable disk image.
+func replacesFile(cfg config.Config, limits Limits, rootAbs, p string) bool {
+	if isInsideDiskImage(limits, p) {
+		if !limits.DiskImagesWriteEnabled {
+			return false
+		}
+		kind, mountPath, inner, _ := splitDiskImagePath(p)
+		inner = normalizeDiskImageLeafName(inner, cfg.Compat.FallbackPRGExtension)
+		_, _, st, _ := resolveDiskImageFile(rootAbs, kind, mountPath, inner, false)
+		return st == proto.StatusOK
+	}
+	abs, err := fsops.ToOSPath(rootAbs, p)
+	if err != nil {
+		return false
+	}
+	st, err := fsops.Stat(abs)
+	return err == nil && st.Exists && !st.IsDir && st.Size > 0
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+func TestOverwriteConfirm(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, func(c *config.Config) {
+		c.EnableOverwrite = true
+		c.OverwriteConfirm = true
+		c.OverwriteConfirmSec = 20
+	})
+	writeFiles(t, rootAbs, map[string]string{"F": "old", "EMPTY": ""})
+	limits := Limits{tokenID: "A"}
+	const replace = proto.FlagWR_CREATE | proto.FlagWR_TRUNCATE | proto.FlagWR_OVERWRITE
+
+	// write sends one replacing chunk; confirm != 0 adds FlagWR_CONFIRM and
+	// the value after data.
+	write := func(limits Limits, p, data string, confirm uint32) (byte, []byte, string) {
+		flags := byte(replace)
+		payload := writeRangePayload(t, p, 0, []byte(data))
+		if confirm != 0 {
+			flags |= proto.FlagWR_CONFIRM
+			e := proto.NewEncoder(4)
+			e.WriteU32(confirm)
+			payload = append(payload, e.Bytes()...)
+		}
+		return s.dispatch(cfg, limits, proto.OpWRITE_RANGE, flags, payload, rootAbs)
+	}
+	// ask makes the first attempt and returns the confirm value handed out.
+	ask := func(limits Limits, p string) uint32 {
+		t.Helper()
+		st, resp, msg := write(limits, p, "new", 0)
+		if st != proto.StatusAlreadyExists || len(resp) != 6 {
+			t.Fatalf("first write to %s = %s % X (%s), want ALREADY_EXISTS with confirm", p, statusName(st), resp, msg)
+		}
+		d := proto.NewDecoder(resp)
+		code, _ := d.ReadU32()
+		if sec, _ := d.ReadU16(); sec != 20 {
+			t.Fatalf("valid_sec = %d, want 20", sec)
+		}
+		return code
+	}
+	content := func(p string) string {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join(rootAbs, p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	// Round trip: refused first, replaced with the confirm value.
+	code := ask(limits, "/F")
+	if got := content("F"); got != "old" {
+		t.Fatalf("file after the refused write = %q", got)
+	}
+	if st, _, msg := write(limits, "/F", "new", code); st != proto.StatusOK {
+		t.Fatalf("confirmed write = %s (%s)", statusName(st), msg)
+	}
+	if got := content("F"); got != "new" {
+		t.Fatalf("file after the confirmed write = %q", got)
+	}
+
+	// A confirm value works once, for its own token, and only in time.
+	for _, tc := range []struct {
+		name  string
+		reuse func(code uint32) (byte, []byte, string)
+	}{
+		{"replayed", func(code uint32) (byte, []byte, string) {
+			if st, _, _ := write(limits, "/F", "x", code); st != proto.StatusOK {
+				t.Fatalf("confirmed write = %s", statusName(st))
+			}
+			return write(limits, "/F", "y", code)
+		}},
+		{"wrong value", func(code uint32) (byte, []byte, string) { return write(limits, "/F", "y", code+1) }},
+		{"other token", func(code uint32) (byte, []byte, string) { return write(Limits{tokenID: "B"}, "/F", "y", code) }},
+		{"expired", func(code uint32) (byte, []byte, string) {
+			s.confirms.mu.Lock()
+			for k, v := range s.confirms.m {
+				v.expires = time.Now().Add(-time.Second)
+				s.confirms.m[k] = v
+			}
+			s.confirms.mu.Unlock()
+			return write(limits, "/F", "y", code)
+		}},
+	} {
+		st, resp, msg := tc.reuse(ask(limits, "/F"))
+		if st != proto.StatusAlreadyExists || len(resp) != 6 || msg != "confirm expired or wrong" {
+			t.Fatalf("%s: write = %s (%s), want ALREADY_EXISTS with a new confirm", tc.name, statusName(st), msg)
+		}
+		if got := content("F"); got == "y" {
+			t.Fatalf("%s: the refused write replaced the file", tc.name)
+		}
+	}
+
+	// New and empty files need no confirm.
+	for _, p := range []string{"/NEW", "/EMPTY"} {
+		if st, _, msg := write(limits, p, "new", 0); st != proto.StatusOK {
+			t.Fatalf("write to %s = %s (%s)", p, statusName(st), msg)
+		}
+	}
+
+	// Off by default.
+	plain := cfg
+	plain.OverwriteConfirm = false
+	if st, _, msg := s.dispatch(plain, limits, proto.OpWRITE_RANGE, replace, writeRangePayload(t, "/F", 0, []byte("z")), rootAbs); st != proto.StatusOK {
+		t.Fatalf("write without overwrite_confirm = %s (%s)", statusName(st), msg)
+	}
+}
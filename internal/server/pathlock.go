@@ -0,0 +1,130 @@
+package server
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// pathLocks serializes writes per path instead of per server. A write locks
+// its target paths exclusively and all their ancestors shared, so writes to
+// unrelated files run in parallel while a directory op (RMDIR, MV of a
+// directory, ...) still excludes everything below it. Locks are always taken
+// in sorted key order, which keeps blocking acquisition deadlock free.
+type pathLocks struct {
+	mu sync.Mutex
+	m  map[string]*pathLock
+}
+
+type pathLock struct {
+	rw   sync.RWMutex
+	refs int
+}
+
+type pathLockReq struct {
+	key       string
+	exclusive bool
+}
+
+// pathLockKey returns the lock key for an absolute OS path. Keys are upper
+// case like W64 paths, so differently cased spellings of a path (and roots
+// reached through different tokens) share one lock.
+func pathLockKey(abs string) string {
+	return strings.ToUpper(filepath.Clean(abs))
+}
+
+// lockSet expands the target keys into the sorted list of locks to take.
+func lockSet(keys []string) []pathLockReq {
+	want := map[string]bool{}
+	for _, k := range keys {
+		want[k] = true
+		for p := filepath.Dir(k); ; p = filepath.Dir(p) {
+			if _, ok := want[p]; !ok {
+				want[p] = false
+			}
+			if filepath.Dir(p) == p {
+				break
+			}
+		}
+	}
+	out := make([]pathLockReq, 0, len(want))
+	for k, ex := range want {
+		out = append(out, pathLockReq{key: k, exclusive: ex})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].key < out[j].key })
+	return out
+}
+
+func (l *pathLocks) get(key string) *pathLock {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.m == nil {
+		l.m = map[string]*pathLock{}
+	}
+	pl := l.m[key]
+	if pl == nil {
+		pl = &pathLock{}
+		l.m[key] = pl
+	}
+	pl.refs++
+	return pl
+}
+
+func (l *pathLocks) put(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if pl := l.m[key]; pl != nil {
+		pl.refs--
+		if pl.refs == 0 {
+			delete(l.m, key)
+		}
+	}
+}
+
+// lock takes the locks for keys. With wait=false it gives up (ok=false) as
+// soon as one of them is held by another writer.
+func (l *pathLocks) lock(keys []string, wait bool) (release func(), ok bool) {
+	reqs := lockSet(keys)
+	held := make([]*pathLock, 0, len(reqs))
+	unlock := func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			if reqs[i].exclusive {
+				held[i].rw.Unlock()
+			} else {
+				held[i].rw.RUnlock()
+			}
+			l.put(reqs[i].key)
+		}
+	}
+	for _, r := range reqs {
+		pl := l.get(r.key)
+		var got bool
+		switch {
+		case wait && r.exclusive:
+			pl.rw.Lock()
+			got = true
+		case wait:
+			pl.rw.RLock()
+			got = true
+		case r.exclusive:
+			got = pl.rw.TryLock()
+		default:
+			got = pl.rw.TryRLock()
+		}
+		if !got {
+			l.put(r.key)
+			unlock()
+			return nil, false
+		}
+		held = append(held, pl)
+	}
+	return unlock, true
+}
+
+// held reports whether a write holds or waits for the lock of key.
+func (l *pathLocks) held(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.m[key] != nil
+}
@@ -0,0 +1,270 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+func lockKey(p string) string { return pathLockKey(filepath.FromSlash(p)) }
+
+func TestLockSetSortedWithSharedAncestors(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []string
+		want []pathLockReq
+	}{
+		{
+			name: "sorted, ancestors shared",
+			keys: []string{lockKey("/R/B/X"), lockKey("/R/A")},
+			want: []pathLockReq{
+				{lockKey("/"), false},
+				{lockKey("/R"), false},
+				{lockKey("/R/A"), true},
+				{lockKey("/R/B"), false},
+				{lockKey("/R/B/X"), true},
+			},
+		},
+		{
+			name: "common ancestor once",
+			keys: []string{lockKey("/R/A/X"), lockKey("/R/A/Y")},
+			want: []pathLockReq{
+				{lockKey("/"), false},
+				{lockKey("/R"), false},
+				{lockKey("/R/A"), false},
+				{lockKey("/R/A/X"), true},
+				{lockKey("/R/A/Y"), true},
+			},
+		},
+		{
+			name: "target that is also an ancestor stays exclusive",
+			keys: []string{lockKey("/R/A/X"), lockKey("/R/A")},
+			want: []pathLockReq{
+				{lockKey("/"), false},
+				{lockKey("/R"), false},
+				{lockKey("/R/A"), true},
+				{lockKey("/R/A/X"), true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lockSet(tt.keys); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("lockSet = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathLocksConflicts(t *testing.T) {
+	tests := []struct {
+		name      string
+		held, try string
+		ok        bool
+	}{
+		{"different files", "/R/A", "/R/B", true},
+		{"siblings below a shared parent", "/R/D/A", "/R/D/B", true},
+		{"same path", "/R/A", "/R/A", false},
+		{"same path, other case", "/R/abc", "/R/ABC", false},
+		{"ancestor of a held path", "/R/D/A", "/R/D", false},
+		{"below a held directory", "/R/D", "/R/D/A", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var l pathLocks
+			release, ok := l.lock([]string{lockKey(tt.held)}, false)
+			if !ok {
+				t.Fatal("first lock failed")
+			}
+			r2, ok := l.lock([]string{lockKey(tt.try)}, false)
+			if ok != tt.ok {
+				t.Fatalf("second lock ok = %v, want %v", ok, tt.ok)
+			}
+			if ok {
+				r2()
+			}
+			release()
+			if l.held(lockKey(tt.held)) {
+				t.Fatal("lock still registered after release")
+			}
+		})
+	}
+}
+
+func TestPathLocksWaitSerializes(t *testing.T) {
+	var l pathLocks
+	release, _ := l.lock([]string{lockKey("/R/A")}, true)
+	got := make(chan struct{})
+	go func() {
+		r, _ := l.lock([]string{lockKey("/R/A")}, true)
+		close(got)
+		r()
+	}()
+	select {
+	case <-got:
+		t.Fatal("second writer got the lock while the first held it")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	select {
+	case <-got:
+	case <-time.After(time.Second):
+		t.Fatal("second writer did not get the lock after release")
+	}
+}
+
+func TestBATCHHoldsRootWriteLock(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+
+	// Sub-ops of a write batch skip their own locking (writeLocked).
+	release, ok := s.lockPaths(Limits{}, rootAbs, false)
+	if !ok {
+		t.Fatal("root lock failed")
+	}
+	if _, ok := s.lockPaths(Limits{}, rootAbs, false, "/A.SEQ"); ok {
+		t.Fatal("file lock taken while the root is locked")
+	}
+	r2, ok := s.lockPaths(Limits{writeLocked: true}, rootAbs, false, "/A.SEQ")
+	if !ok {
+		t.Fatal("writeLocked lockPaths must not lock again")
+	}
+	r2()
+	release()
+
+	// A write batch runs its sub-ops under the root lock (no self-deadlock)...
+	batch := batchPayload(batchSubReq{
+		op:      proto.OpWRITE_RANGE,
+		flags:   proto.FlagWR_CREATE | proto.FlagWR_TRUNCATE,
+		payload: writeRangePayload(t, "/A.SEQ", 0, []byte("hi")),
+	})
+	status, resp, errMsg := s.dispatch(cfg, Limits{}, proto.OpBATCH, 0, batch, rootAbs)
+	if status != proto.StatusOK || len(resp) < 4 || resp[3] != proto.StatusOK {
+		t.Fatalf("BATCH = %s % X (%s), want OK with an OK sub-op", statusName(status), resp, errMsg)
+	}
+
+	// ...and is refused while another writer holds a path in the root.
+	release, ok = s.lockPaths(Limits{}, rootAbs, false, "/B.SEQ")
+	if !ok {
+		t.Fatal("file lock failed")
+	}
+	defer release()
+	if status, _, _ := s.dispatch(cfg, Limits{}, proto.OpBATCH, 0, batch, rootAbs); status != proto.StatusBusy {
+		t.Fatalf("BATCH during another write = %s, want BUSY", statusName(status))
+	}
+}
+
+func TestParallelWritesStayWithinQuota(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits Limits
+		want   int // writes that may succeed
+	}{
+		{"quota_bytes", Limits{QuotaBytes: 1000}, 10},
+		{"max_files", Limits{MaxFiles: 5}, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, cfg, rootAbs := newTestServer(t, nil)
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			ok := 0
+			for i := 0; i < 30; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					payload := writeRangePayload(t, fmt.Sprintf("/F%02d", i), 0, bytes.Repeat([]byte{1}, 100))
+					st, _, _ := s.dispatch(cfg, tt.limits, proto.OpWRITE_RANGE, proto.FlagWR_CREATE|proto.FlagWR_TRUNCATE, payload, rootAbs)
+					if st == proto.StatusOK {
+						mu.Lock()
+						ok++
+						mu.Unlock()
+					}
+				}(i)
+			}
+			wg.Wait()
+			if ok != tt.want {
+				t.Fatalf("%d writes succeeded, want %d", ok, tt.want)
+			}
+			ents, _ := os.ReadDir(rootAbs)
+			if len(ents) > tt.want {
+				t.Fatalf("%d files in the root, limit allows %d", len(ents), tt.want)
+			}
+		})
+	}
+}
+
+func TestParallelWRITE_RANGE(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, func(c *config.Config) { c.EnableOverwrite = true })
+	write := func(p string, data []byte) byte {
+		payload := writeRangePayload(t, p, 0, data)
+		st, _, _ := s.dispatch(cfg, Limits{}, proto.OpWRITE_RANGE, proto.FlagWR_CREATE|proto.FlagWR_TRUNCATE|proto.FlagWR_OVERWRITE, payload, rootAbs)
+		return st
+	}
+
+	// Writers to different files never see BUSY.
+	var wg sync.WaitGroup
+	status := make([]byte, 20)
+	for i := range status {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if st := write(fmt.Sprintf("/F%02d", i), bytes.Repeat([]byte{byte(i)}, 512)); st != proto.StatusOK {
+					status[i] = st
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i, st := range status {
+		if st != 0 {
+			t.Fatalf("write to /F%02d = %s, want OK", i, statusName(st))
+		}
+	}
+
+	// A second writer to a file that is being written gets BUSY, while
+	// another file stays writable.
+	release, ok := s.lockWrite(cfg, Limits{}, proto.OpWRITE_RANGE, writeRangePayload(t, "/A", 0, nil), rootAbs, false)
+	if !ok {
+		t.Fatal("lockWrite failed")
+	}
+	if st := write("/A", []byte("x")); st != proto.StatusBusy {
+		t.Fatalf("write to a locked file = %s, want BUSY", statusName(st))
+	}
+	if st := write("/B", []byte("x")); st != proto.StatusOK {
+		t.Fatalf("write next to a locked file = %s, want OK", statusName(st))
+	}
+	release()
+	if st := write("/A", []byte("x")); st != proto.StatusOK {
+		t.Fatalf("write after release = %s, want OK", statusName(st))
+	}
+
+	// Racing writers to one file are serialized: each one either wins or
+	// gets BUSY, and the file holds one complete write.
+	for i := range status {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			status[i] = write("/SAME", bytes.Repeat([]byte{byte('a' + i)}, 4096))
+		}(i)
+	}
+	wg.Wait()
+	for i, st := range status {
+		if st != proto.StatusOK && st != proto.StatusBusy {
+			t.Fatalf("writer %d = %s, want OK or BUSY", i, statusName(st))
+		}
+	}
+	got, err := os.ReadFile(filepath.Join(rootAbs, "SAME"))
+	if err != nil || len(got) != 4096 || !bytes.Equal(got, bytes.Repeat(got[:1], 4096)) {
+		t.Fatalf("/SAME holds a mixed or short write (%d bytes, %v)", len(got), err)
+	}
+}
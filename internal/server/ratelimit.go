@@ -0,0 +1,84 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a per-token token bucket (rate_limit_per_sec). Each bucket
+// holds up to one second's worth of requests (at least 1) and refills based
+// on wall-clock time.
+type rateLimiter struct {
+	mu sync.Mutex
+	m  map[string]*rateBucket // key: tokenID
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{m: make(map[string]*rateBucket)}
+}
+
+func rateBurst(rate float64) float64 {
+	if rate < 1 {
+		return 1
+	}
+	return rate
+}
+
+// allow takes one request from key's bucket. It reports false if the bucket
+// is empty.
+func (r *rateLimiter) allow(key string, rate float64, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b := r.refill(key, rate, now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// take draws n from key's bucket like allow, but never refuses: the bucket
+// may go negative, and the returned duration is the time until it has
+// refilled to zero again. Used for byte budgets (max_bytes_per_sec), where
+// the caller waits that long instead of failing.
+func (r *rateLimiter) take(key string, rate, n float64, now time.Time) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b := r.refill(key, rate, now)
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+// level returns the current fill level of key's bucket (full if unused).
+func (r *rateLimiter) level(key string, rate float64, now time.Time) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.refill(key, rate, now).tokens
+}
+
+func (r *rateLimiter) refill(key string, rate float64, now time.Time) *rateBucket {
+	burst := rateBurst(rate)
+	b, ok := r.m[key]
+	if !ok {
+		b = &rateBucket{tokens: burst, last: now}
+		r.m[key] = b
+		return b
+	}
+	if el := now.Sub(b.last).Seconds(); el > 0 {
+		b.tokens += el * rate
+		b.last = now
+	}
+	if b.tokens > burst {
+		// Also clamps after the limit was lowered by a config change.
+		b.tokens = burst
+	}
+	return b
+}
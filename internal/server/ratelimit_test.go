@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+func TestRateLimiterBucket(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	r := newRateLimiter()
+
+	// A full bucket holds one second's worth: 5 of 8 requests pass.
+	allowed := 0
+	for i := 0; i < 8; i++ {
+		if r.allow("A", 5, now) {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Fatalf("allowed %d of 8 at 5/s, want 5", allowed)
+	}
+	// Buckets are per key.
+	if !r.allow("B", 5, now) {
+		t.Fatal("B limited by A's requests")
+	}
+	// 400 ms refill two requests.
+	now = now.Add(400 * time.Millisecond)
+	if !r.allow("A", 5, now) || !r.allow("A", 5, now) || r.allow("A", 5, now) {
+		t.Fatal("want exactly two requests after 400ms at 5/s")
+	}
+	// Refill never exceeds the burst.
+	now = now.Add(time.Hour)
+	if lvl := r.level("A", 5, now); lvl != 5 {
+		t.Fatalf("level after an hour = %v, want 5", lvl)
+	}
+	// Lowering the limit clamps the stored fill level.
+	if lvl := r.level("A", 2, now); lvl != 2 {
+		t.Fatalf("level after lowering to 2/s = %v, want 2", lvl)
+	}
+
+	// Rates below 1/s still allow one request, then one per 1/rate seconds.
+	if !r.allow("S", 0.5, now) || r.allow("S", 0.5, now) {
+		t.Fatal("want one request at 0.5/s")
+	}
+	if r.allow("S", 0.5, now.Add(time.Second)) {
+		t.Fatal("allowed after 1s at 0.5/s")
+	}
+	if !r.allow("S", 0.5, now.Add(2*time.Second)) {
+		t.Fatal("refused after 2s at 0.5/s")
+	}
+}
+
+func TestRateLimiterTake(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	r := newRateLimiter()
+	if d := r.take("A", 1000, 800, now); d != 0 {
+		t.Fatalf("take within the burst waits %v", d)
+	}
+	// 200 left; 700 more puts the bucket 500 below zero.
+	if d := r.take("A", 1000, 700, now); d != 500*time.Millisecond {
+		t.Fatalf("take over the burst waits %v, want 500ms", d)
+	}
+	if d := r.take("A", 1000, 0, now.Add(500*time.Millisecond)); d != 0 {
+		t.Fatalf("bucket not back at zero after the wait: %v", d)
+	}
+}
+
+// rpcStatus posts one W64F request to handleRPC and returns the status byte.
+func rpcStatus(t *testing.T, s *Server, token string, op, flags byte, payload []byte) byte {
+	t.Helper()
+	st, _ := rpc(t, s, token, op, flags, payload)
+	return st
+}
+
+// rpc posts one W64F request to handleRPC and returns the status byte and
+// the response payload.
+func rpc(t *testing.T, s *Server, token string, op, flags byte, payload []byte) (byte, []byte) {
+	t.Helper()
+	resp := rpcRaw(t, s, token, op, flags, payload)
+	return resp[6], resp[proto.HeaderSize:]
+}
+
+// w64fRequest encodes one W64F request: header and payload.
+func w64fRequest(op, flags byte, payload []byte) []byte {
+	req := make([]byte, proto.HeaderSize, proto.HeaderSize+len(payload))
+	copy(req, proto.Magic)
+	req[4], req[5], req[6] = proto.Version, op, flags
+	binary.LittleEndian.PutUint16(req[8:10], uint16(len(payload)))
+	return append(req, payload...)
+}
+
+// rpcRaw posts one W64F request to handleRPC and returns the whole response,
+// header included.
+func rpcRaw(t *testing.T, s *Server, token string, op, flags byte, payload []byte) []byte {
+	t.Helper()
+	r := httptest.NewRequest("POST", s.cfgSnapshot().Endpoint+"?token="+token, bytes.NewReader(w64fRequest(op, flags, payload)))
+	w := httptest.NewRecorder()
+	s.handleRPC(w, r)
+	resp := w.Body.Bytes()
+	if len(resp) < proto.HeaderSize {
+		t.Fatalf("short response %q", resp)
+	}
+	return resp
+}
+
+func TestRPCRateLimit(t *testing.T) {
+	s, _, _ := newTestServer(t, func(c *config.Config) {
+		c.RateLimitPerSec = 3
+		c.Tokens = []config.TokenEntry{
+			{Token: "GLOBAL"},
+			{Token: "OWN", RateLimitPerSec: 10},
+		}
+	})
+	for _, tc := range []struct {
+		token string
+		limit int
+	}{
+		{"GLOBAL", 3},
+		{"OWN", 10},
+	} {
+		const n = 15
+		busy := 0
+		for i := 0; i < n; i++ {
+			switch st := rpcStatus(t, s, tc.token, proto.OpSTAT, 0, pathPayload("/")); st {
+			case proto.StatusOK:
+			case proto.StatusBusy:
+				busy++
+			default:
+				t.Fatalf("%s: STAT = %s", tc.token, statusName(st))
+			}
+		}
+		// The test runs well within a second, so refill adds at most one.
+		if busy != n-tc.limit && busy != n-tc.limit-1 {
+			t.Errorf("%s: %d of %d requests BUSY, want %d", tc.token, busy, n, n-tc.limit)
+		}
+	}
+
+	// /admin/api/tokens shows the drained buckets.
+	w := httptest.NewRecorder()
+	s.handleAdminTokens(w, httptest.NewRequest("GET", "/", nil))
+	var resp adminTokensResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	for _, st := range resp.Tokens {
+		if st.RateBucket == nil || *st.RateBucket >= 1 {
+			t.Errorf("token %s rate_bucket = %v, want < 1", st.TokenMask, st.RateBucket)
+		}
+	}
+}
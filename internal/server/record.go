@@ -6,6 +6,9 @@ func (s *Server) record(cfg config.Config, le LogEntry) {
 	if cfg.LogRequests {
 		s.logs.add(le)
 	}
+	if cfg.AuditLogPath != "" && s.audit != nil {
+		s.audit.write(le)
+	}
 	if s.stats != nil {
 		s.stats.add(le.Op, le.Status, le.ReqBytes, le.RespBytes, le.DurationMs)
 	}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"wicos64-server/internal/config"
+)
+
+type rpcMethodHelp struct {
+	Error       string `json:"error"`
+	Method      string `json:"method"`
+	ContentType string `json:"content_type"`
+	Body        string `json:"body"`
+	Health      string `json:"health"`
+	Admin       string `json:"admin,omitempty"`
+}
+
+// writeRPCMethodHelp answers a non-POST request to the RPC endpoint with 405
+// and, unless rpc_method_help is off, a short note on how to use it (JSON if
+// the client accepts it, plain text otherwise). It does not reveal anything
+// from the config beyond whether the admin UI is enabled.
+func writeRPCMethodHelp(w http.ResponseWriter, r *http.Request, cfg config.Config) {
+	w.Header().Set("Allow", http.MethodPost)
+	if !cfg.RPCMethodHelp {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	help := rpcMethodHelp{
+		Error:       "method not allowed",
+		Method:      http.MethodPost,
+		ContentType: "application/octet-stream",
+		Body:        "binary W64F request: \"W64F\", version u8, op u8, flags u8, reserved u8 (bit 0: accept compressed response), payload_len u16 (LE), payload",
+		Health:      "/healthz",
+	}
+	if cfg.EnableAdminUI {
+		help.Admin = adminPath
+	}
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, http.StatusMethodNotAllowed, help)
+		return
+	}
+	var b strings.Builder
+	b.WriteString("405 method not allowed\n\n")
+	b.WriteString("This is the WiCOS64 remote storage RPC endpoint. It expects an HTTP POST\n")
+	b.WriteString("(Content-Type: " + help.ContentType + ") with a " + help.Body + ".\n\n")
+	b.WriteString("Health check: " + help.Health + "\n")
+	if help.Admin != "" {
+		b.WriteString("Admin UI:     " + help.Admin + "\n")
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusMethodNotAllowed)
+	_, _ = w.Write([]byte(b.String()))
+}
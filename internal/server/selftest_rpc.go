@@ -0,0 +1,221 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/fsops"
+	"wicos64-server/internal/proto"
+)
+
+// TokenSelfTestReport is the result of the RPC round trip for one token:
+// CAPS -> MKDIR -> WRITE_RANGE -> READ_RANGE -> HASH -> RM through dispatch,
+// with that token's root and limits. Read-only tokens run CAPS and STAT only.
+type TokenSelfTestReport struct {
+	Kind       string         `json:"kind"` // token|legacy_token|legacy_map|no_auth
+	Name       string         `json:"name,omitempty"`
+	TokenMask  string         `json:"token_mask"`
+	TokenID    string         `json:"token_id,omitempty"`
+	RootAbs    string         `json:"root_abs"`
+	ReadOnly   bool           `json:"read_only"`
+	OK         bool           `json:"ok"`
+	FailedStep string         `json:"failed_step,omitempty"`
+	Steps      []SelfTestStep `json:"steps"`
+	DurationMs int64          `json:"duration_ms"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// SelfTestStep is one RPC of a token self-test.
+type SelfTestStep struct {
+	Step       string `json:"step"`
+	OK         bool   `json:"ok"`
+	Skipped    bool   `json:"skipped,omitempty"`
+	Status     string `json:"status,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+type selfTestToken struct {
+	kind, token string
+}
+
+// selfTestTokens lists the tokens that clients can use with the current
+// config, in the same precedence as ResolveTokenContext.
+func selfTestTokens(cfg config.Config) []selfTestToken {
+	var out []selfTestToken
+	switch {
+	case len(cfg.Tokens) > 0:
+		for _, t := range cfg.Tokens {
+			if t.Token != "" {
+				out = append(out, selfTestToken{kind: "token", token: t.Token})
+			}
+		}
+	case len(cfg.TokenRoots) > 0:
+		keys := make([]string, 0, len(cfg.TokenRoots))
+		for k := range cfg.TokenRoots {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			out = append(out, selfTestToken{kind: "legacy_map", token: k})
+		}
+	case cfg.Token != "":
+		out = append(out, selfTestToken{kind: "legacy_token", token: cfg.Token})
+	default:
+		out = append(out, selfTestToken{kind: "no_auth"})
+	}
+	return out
+}
+
+// runTokenSelfTests runs the RPC self-test for every enabled token.
+// Disabled tokens and duplicates are skipped.
+func (s *Server) runTokenSelfTests(cfg config.Config) []TokenSelfTestReport {
+	seen := make(map[string]struct{})
+	var out []TokenSelfTestReport
+	for _, t := range selfTestTokens(cfg) {
+		if _, dup := seen[t.token]; dup {
+			continue
+		}
+		seen[t.token] = struct{}{}
+		ctx, ok := cfg.ResolveTokenContext(t.token)
+		if !ok {
+			continue
+		}
+		out = append(out, s.selfTestToken(cfg, t, ctx))
+	}
+	return out
+}
+
+func (s *Server) selfTestToken(cfg config.Config, t selfTestToken, ctx config.TokenContext) (rep TokenSelfTestReport) {
+	start := time.Now()
+	rep = TokenSelfTestReport{Kind: t.kind, Name: ctx.Name, TokenMask: maskToken(t.token), TokenID: tokenID(t.token), ReadOnly: ctx.ReadOnly}
+	if t.kind == "no_auth" {
+		rep.TokenMask = "<no-auth>"
+	}
+	defer func() { rep.DurationMs = time.Since(start).Milliseconds() }()
+
+	if msg := tokenWindowError(ctx, start); msg != "" {
+		rep.Error = msg
+		return rep
+	}
+	rootAbs, err := filepath.Abs(ctx.Root)
+	if err != nil {
+		rep.RootAbs = ctx.Root
+		rep.Error = "bad root: " + err.Error()
+		return rep
+	}
+	rep.RootAbs = rootAbs
+	if err := config.EnsureRoot(rootAbs); err != nil {
+		rep.Error = "cannot create root: " + err.Error()
+		return rep
+	}
+	_ = s.ensureRecommendedDirs(cfg, rootAbs)
+
+	limits := limitsFromContext(ctx)
+	limits.tokenID = rep.TokenID
+	limits.tokenName = ctx.Name
+
+	// call runs one step and stops the test at the first failure. Later
+	// steps are not run but listed as skipped so the report shows them.
+	failed := false
+	call := func(step string, op, flags byte, payload []byte, check func([]byte) string) []byte {
+		if failed {
+			rep.Steps = append(rep.Steps, SelfTestStep{Step: step, Skipped: true})
+			return nil
+		}
+		t0 := time.Now()
+		st, resp, msg := s.dispatch(cfg, limits, op, flags, payload, rootAbs)
+		res := SelfTestStep{Step: step, Status: statusName(st), DurationMs: time.Since(t0).Milliseconds()}
+		switch {
+		case st != proto.StatusOK:
+			res.Error = msg
+			if res.Error == "" {
+				res.Error = statusName(st)
+			}
+		case check != nil:
+			res.Error = check(resp)
+		}
+		res.OK = res.Error == ""
+		rep.Steps = append(rep.Steps, res)
+		if !res.OK {
+			failed = true
+			rep.FailedStep = step
+		}
+		return resp
+	}
+
+	call("CAPS", proto.OpCAPS, 0, nil, nil)
+	if ctx.ReadOnly {
+		call("STAT /", proto.OpSTAT, 0, pathPayload("/"), nil)
+		rep.OK = !failed
+		return rep
+	}
+
+	dir := fmt.Sprintf("%s/SELFTEST-%d", mktempDir, start.UnixNano())
+	file := dir + "/SELFTEST.BIN"
+	data := []byte(fmt.Sprintf("wicos64 selftest %d\n", start.UnixNano()))
+
+	// MKDIR of the test dir needs .TMP; ensureRecommendedDirs may be off.
+	if tmpAbs, err := fsops.ToOSPath(rootAbs, mktempDir); err == nil {
+		_ = os.MkdirAll(tmpAbs, 0o755)
+	}
+	// Clean up whatever the steps left behind, also after a failure: RM and
+	// RMDIR through dispatch first, then directly on disk as a fallback.
+	defer func() {
+		_, _, _ = s.dispatch(cfg, limits, proto.OpRM, 0, pathPayload(file), rootAbs)
+		_, _, _ = s.dispatch(cfg, limits, proto.OpRMDIR, 0, pathPayload(dir), rootAbs)
+		if dirAbs, err := fsops.ToOSPath(rootAbs, dir); err == nil {
+			_ = os.RemoveAll(dirAbs)
+		}
+		s.invalidateRootUsage(rootAbs)
+	}()
+
+	call("MKDIR "+dir, proto.OpMKDIR, 0, pathPayload(dir), nil)
+
+	e := proto.NewEncoder(64 + len(data))
+	_ = e.WriteString(file)
+	e.WriteU32(0)
+	e.WriteU16(uint16(len(data)))
+	e.WriteBytes(data)
+	call("WRITE_RANGE "+file, proto.OpWRITE_RANGE, proto.FlagWR_CREATE|proto.FlagWR_TRUNCATE, e.Bytes(), nil)
+
+	e = proto.NewEncoder(64)
+	_ = e.WriteString(file)
+	e.WriteU32(0)
+	e.WriteU16(uint16(len(data)))
+	call("READ_RANGE "+file, proto.OpREAD_RANGE, 0, e.Bytes(), func(resp []byte) string {
+		if !bytes.Equal(resp, data) {
+			return fmt.Sprintf("read back %d bytes, content differs from the %d written", len(resp), len(data))
+		}
+		return ""
+	})
+
+	call("HASH "+file, proto.OpHASH, 0, pathPayload(file), func(resp []byte) string {
+		if len(resp) != 4 {
+			return fmt.Sprintf("HASH returned %d bytes, want 4", len(resp))
+		}
+		got, want := binary.LittleEndian.Uint32(resp), crc32.ChecksumIEEE(data)
+		if got != want {
+			return fmt.Sprintf("CRC32 %08X, want %08X", got, want)
+		}
+		return ""
+	})
+
+	call("RM "+file, proto.OpRM, 0, pathPayload(file), nil)
+
+	rep.OK = !failed
+	return rep
+}
+
+func pathPayload(p string) []byte {
+	e := proto.NewEncoder(2 + len(p))
+	_ = e.WriteString(p)
+	return e.Bytes()
+}
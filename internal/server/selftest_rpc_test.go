@@ -0,0 +1,90 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"wicos64-server/internal/config"
+)
+
+func TestTokenSelfTest(t *testing.T) {
+	off := false
+	s, _, base := newTestServer(t, func(c *config.Config) {
+		c.Tokens = []config.TokenEntry{
+			{Token: "RWTOKEN", PathPrefix: "/RW"},
+			{Token: "ROTOKEN", PathPrefix: "/RO", ReadOnly: true},
+			{Token: "SMALLTOKEN", PathPrefix: "/SMALL", QuotaBytes: 10},
+			{Token: "OFFTOKEN", PathPrefix: "/OFF", Enabled: &off},
+		}
+	})
+	for _, dir := range []string{"RW", "RO", "SMALL", "OFF"} {
+		if err := os.Mkdir(filepath.Join(base, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.handleAdminSelfTest(w, httptest.NewRequest("POST", "/", nil))
+	var resp struct {
+		OK      bool `json:"ok"`
+		Payload struct {
+			Tokens  []TokenSelfTestReport `json:"tokens"`
+			Summary struct {
+				Tokens       int `json:"tokens"`
+				TokensOK     int `json:"tokens_ok"`
+				TokensFailed int `json:"tokens_failed"`
+			} `json:"summary"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK || !resp.OK {
+		t.Fatalf("selftest = %d %s", w.Code, w.Body.String())
+	}
+	// The disabled token is not tested.
+	if sum := resp.Payload.Summary; sum.Tokens != 3 || sum.TokensOK != 2 || sum.TokensFailed != 1 {
+		t.Fatalf("summary = %+v, want 3 tokens, 2 ok", sum)
+	}
+	reps := map[string]TokenSelfTestReport{}
+	for _, rep := range resp.Payload.Tokens {
+		reps[filepath.Base(rep.RootAbs)] = rep
+	}
+	steps := func(rep TokenSelfTestReport) string {
+		var out []string
+		for _, st := range rep.Steps {
+			name, _, _ := strings.Cut(st.Step, " ")
+			switch {
+			case st.Skipped:
+				name += ":skipped"
+			case !st.OK:
+				name += ":" + st.Status
+			}
+			out = append(out, name)
+		}
+		return strings.Join(out, " ")
+	}
+
+	if rep := reps["RW"]; !rep.OK || steps(rep) != "CAPS MKDIR WRITE_RANGE READ_RANGE HASH RM" {
+		t.Errorf("read-write token: ok=%v steps %q (%s)", rep.OK, steps(rep), rep.FailedStep)
+	}
+	if rep := reps["RO"]; !rep.OK || !rep.ReadOnly || steps(rep) != "CAPS STAT" {
+		t.Errorf("read-only token: ok=%v steps %q", rep.OK, steps(rep))
+	}
+	// A failing step stops the run; the rest is listed as skipped.
+	rep := reps["SMALL"]
+	if rep.OK || !strings.HasPrefix(rep.FailedStep, "WRITE_RANGE ") ||
+		steps(rep) != "CAPS MKDIR WRITE_RANGE:TOO_LARGE READ_RANGE:skipped HASH:skipped RM:skipped" {
+		t.Errorf("over-quota token: ok=%v failed %q steps %q", rep.OK, rep.FailedStep, steps(rep))
+	}
+
+	// Nothing is left behind, also after the failure.
+	for _, dir := range []string{"RW", "RO", "SMALL"} {
+		left, _ := filepath.Glob(filepath.Join(base, dir, ".TMP", "SELFTEST-*"))
+		if len(left) != 0 {
+			t.Errorf("%s: selftest left %q", dir, left)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -10,11 +11,13 @@ import (
 	"io/fs"
 	"mime"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
@@ -24,6 +27,7 @@ import (
 	"wicos64-server/internal/diskimage"
 	"wicos64-server/internal/fsops"
 	"wicos64-server/internal/pathutil"
+	"wicos64-server/internal/petscii"
 	"wicos64-server/internal/proto"
 	"wicos64-server/internal/version"
 )
@@ -36,8 +40,13 @@ type Server struct {
 	// initOncePerRoot tracks roots we already initialized with recommended dirs.
 	inited sync.Map // map[string]struct{}
 
-	// global write lock to optionally return BUSY instead of blocking.
-	writeMu sync.Mutex
+	// per-path write locks (see lockWrite); contended writes may answer BUSY.
+	paths pathLocks
+	// per-root quota locks (see lockQuota).
+	quota pathLocks
+
+	// running and recent disk image writes (IMAGES, /admin/api/images).
+	images imageActivities
 
 	// recent request logs for the admin UI.
 	logs *logHub
@@ -48,6 +57,51 @@ type Server struct {
 
 	// LAN discovery responder (UDP, WDP1)
 	discOnce sync.Once
+
+	// CSRF token for mutating admin requests (random per process).
+	adminCSRF string
+
+	// detailed disk-image op records (log_disk_image_ops).
+	imageLogs *imageLogRing
+
+	// per-token request buckets (rate_limit_per_sec).
+	rate *rateLimiter
+	// per-token byte buckets (max_bytes_per_sec), see throttle.
+	bandwidth *rateLimiter
+
+	// last config file version seen by the config_watch poller.
+	cfgWatch cfgWatch
+
+	// sidecar index of inner file changes per image (image_change_index).
+	imgIndex imageIndex
+
+	// JSONL request audit file (audit_log_path).
+	audit      *auditLog
+	tokenAudit tokenAuditLogs
+
+	// open .zip mounts (zip_mount_enabled), closed when idle.
+	zips zipArchives
+
+	// last run of each maintenance task (GET /admin/api/maintenance).
+	maint maintStatus
+
+	// background ops started with CP FlagCP_ASYNC (PROGRESS, /admin/api/ops).
+	asyncOps asyncOps
+
+	// files whose first WRITE_RANGE chunk lost a BOM (see stripWriteBOM).
+	boms bomShifts
+
+	// sorted directory entries for LS paging (see readDirSorted).
+	lsDirs lsCache
+
+	// open WRITE_RANGE FlagWR_STAGED writes (see writeStagedRange).
+	staged stagedWrites
+
+	// outstanding overwrite confirmations (overwrite_confirm).
+	confirms overwriteConfirms
+
+	// stops the background goroutines on Shutdown.
+	down shutdownState
 }
 
 func New(cfg config.Config, cfgPath string) *Server {
@@ -57,8 +111,24 @@ func New(cfg config.Config, cfgPath string) *Server {
 		logs:    newLogHub(1024),
 		usage:   newUsageCache(3 * time.Second),
 		stats:   newStatsHub(),
-	}
+
+		imageLogs: newImageLogRing(imageLogCapacity),
+		rate:      newRateLimiter(),
+		bandwidth: newRateLimiter(),
+		audit:     newAuditLog(auditSettings{path: cfg.AuditLogPath, maxBytes: cfg.AuditLogMaxBytes, keep: cfg.AuditLogKeep}),
+		down:      newShutdownState(),
+	}
+	diskimage.SetOpHook(s.onImageOp)
+	diskimage.SetOpStartHook(s.images.begin)
+	s.adminCSRF = newAdminCSRFToken()
+	diskImageDetectByContent.Store(cfg.DiskImageDetectByContent)
+	diskimage.SetReplaceRetries(cfg.DiskImageReplaceRetries)
+	diskimage.SetMaxConcurrentParses(cfg.MaxConcurrentImageParses)
+	s.watchAuditSignals()
+	s.loadUsageCache(cfg)
+	s.startUsageCacheSaver()
 	s.startMaintenanceLoop()
+	s.startConfigWatcher()
 	s.StartDiscovery()
 	return s
 }
@@ -73,15 +143,58 @@ func (s *Server) setCfg(cfg config.Config) {
 	s.cfgMu.Lock()
 	s.cfg = cfg
 	s.cfgMu.Unlock()
+	diskImageDetectByContent.Store(cfg.DiskImageDetectByContent)
+	diskimage.SetReplaceRetries(cfg.DiskImageReplaceRetries)
+	diskimage.SetMaxConcurrentParses(cfg.MaxConcurrentImageParses)
+	s.audit.configure(auditSettings{path: cfg.AuditLogPath, maxBytes: cfg.AuditLogMaxBytes, keep: cfg.AuditLogKeep})
+	s.tokenAudit.configure(cfg)
 }
 
+// HTTPHandler serves all handlers (single listen address).
 func (s *Server) HTTPHandler() http.Handler {
+	return s.HTTPHandlerFor(config.ListenerConfig{})
+}
+
+// HTTPHandlerFor serves the handler groups of one listeners[] entry. Paths
+// of the groups it does not serve answer 404, so e.g. /admin on an api-only
+// listener does not fall through to the "/" handler.
+func (s *Server) HTTPHandlerFor(l config.ListenerConfig) http.Handler {
 	mux := http.NewServeMux()
 	cfg := s.cfgSnapshot()
-	mux.HandleFunc(cfg.Endpoint, s.handleRPC)
+	serve := func(kind, pattern string, h http.HandlerFunc) {
+		if !l.Serves(kind) {
+			h = http.NotFound
+		}
+		mux.HandleFunc(pattern, h)
+	}
+	serve(config.ServeAPI, cfg.Endpoint, s.handleRPC)
+	// Extra endpoints that tokens[].endpoint binds tokens to.
+	for _, ep := range cfg.TokenEndpoints() {
+		serve(config.ServeAPI, ep, s.handleRPC)
+	}
 	// Optional LAN-only bootstrap helper (API URL + token per WiC64 MAC).
-	mux.HandleFunc("/wicos64/bootstrap", s.handleBootstrap)
-	s.mountAdmin(mux)
+	serve(config.ServeBootstrap, "/wicos64/bootstrap", s.handleBootstrap)
+	// Optional CAPS as JSON for tooling (enable_caps_json).
+	serve(config.ServeAPI, "/wicos64/caps.json", s.handleCapsJSON)
+	// Optional read-only HTTP download bridge (enable_http_files).
+	serve(config.ServeAPI, httpFilesPrefix, s.handleHTTPFiles)
+	// Optional JSON translation of single ops for scripts (enable_jsonrpc).
+	serve(config.ServeAPI, jsonRPCPath, s.handleJSONRPC)
+	// Optional Prometheus metrics (metrics_enabled).
+	serve(config.ServeAPI, "/metrics", s.handleMetrics)
+	if l.Serves(config.ServeAdmin) {
+		s.mountAdmin(mux)
+	} else {
+		mux.HandleFunc(adminPath, http.NotFound)
+		mux.HandleFunc(adminPath+"/", http.NotFound)
+	}
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		// Unauthenticated health probe. Only the warning count is exposed here;
+		// the warning texts are available via /admin/api/warnings.
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprintf(w, "ok\nwarnings=%d\n", len(configWarnings(s.cfgSnapshot())))
+	})
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		// Lightweight health endpoint.
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
@@ -93,13 +206,13 @@ func (s *Server) HTTPHandler() http.Handler {
 
 func (s *Server) handleRPC(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		writeRPCMethodHelp(w, r, s.cfgSnapshot())
 		return
 	}
 
 	startTime := time.Now()
 	cfg := s.cfgSnapshot()
-	remoteIP := clientIP(r)
+	remoteIP := clientIP(cfg, r)
 
 	// Log entry (filled progressively). We only log if cfg.LogRequests is true.
 	var le LogEntry
@@ -130,6 +243,21 @@ func (s *Server) handleRPC(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if len(body) < proto.HeaderSize {
+		if cfg.DebugShortRequest {
+			// Best-effort frame so firmware authors see why the request failed.
+			status := proto.StatusBadRequest
+			msg := fmt.Sprintf("request too short: %d bytes, the W64F header needs %d", len(body), proto.HeaderSize)
+			le.Op = 0xFF
+			le.OpName = "<short>"
+			le.Status = status
+			le.StatusName = statusName(status)
+			le.Info = debugBodyInfo(ct, body)
+			le.RespPreview = buildRespPreview(cfg, 0xFF, status, nil, msg)
+			le.RespBytes = s.writeResponse(w, withErrMsg(cfg), proto.Version, 0xFF, status, 0, nil, msg)
+			le.DurationMs = time.Since(startTime).Milliseconds()
+			s.record(cfg, le)
+			return
+		}
 		// Spec allows HTTP 4xx/5xx if we cannot build a W64F response (e.g. body < 10).
 		w.WriteHeader(http.StatusBadRequest)
 		le.HTTPStatus = http.StatusBadRequest
@@ -143,6 +271,7 @@ func (s *Server) handleRPC(w http.ResponseWriter, r *http.Request) {
 	// Parse header (magic, version, payload_len). If the magic is bad, attempt to
 	// unwrap WiC64-style HTTP POST bodies that embed binary data in a field named "data".
 	// This keeps the server compatible with both raw octet-stream and form/multipart posts.
+	raw := body
 	hdr, magicOK, hdrErr := proto.ParseReqHeader(body)
 	if hdrErr != nil {
 		if unwrapped, uwInfo, ok := tryUnwrapW64FBody(body, ct); ok {
@@ -171,7 +300,7 @@ func (s *Server) handleRPC(w http.ResponseWriter, r *http.Request) {
 		le.Status = status
 		le.StatusName = statusName(status)
 		le.RespPreview = buildRespPreview(cfg, opEcho, status, nil, errMsg)
-		le.RespBytes = s.writeResponse(w, cfg, versionEcho, opEcho, status, nil, errMsg)
+		le.RespBytes = s.writeResponse(w, cfg, versionEcho, opEcho, status, 0, nil, errMsg)
 		le.DurationMs = time.Since(startTime).Milliseconds()
 		s.record(cfg, le)
 		return
@@ -180,22 +309,31 @@ func (s *Server) handleRPC(w http.ResponseWriter, r *http.Request) {
 	if hdrErr != nil {
 		// Header parse error (bad magic) – still respond with BAD_REQUEST and op_echo=0xFF.
 		status := proto.StatusBadRequest
+		msg := hdrErr.Error()
+		rcfg := cfg
+		if cfg.DebugShortRequest {
+			if len(body) >= 4 {
+				msg = fmt.Sprintf("bad magic %q, want %q", body[:4], proto.Magic)
+			}
+			le.Info = debugBodyInfo(ct, raw)
+			rcfg = withErrMsg(cfg)
+		}
 		le.Status = status
 		le.StatusName = statusName(status)
-		le.RespPreview = buildRespPreview(cfg, opEcho, status, nil, hdrErr.Error())
-		le.RespBytes = s.writeResponse(w, cfg, versionEcho, opEcho, status, nil, hdrErr.Error())
+		le.RespPreview = buildRespPreview(cfg, opEcho, status, nil, msg)
+		le.RespBytes = s.writeResponse(w, rcfg, versionEcho, opEcho, status, 0, nil, msg)
 		le.DurationMs = time.Since(startTime).Milliseconds()
 		s.record(cfg, le)
 		return
 	}
 
 	// Validate fixed header constraints.
-	if hdr.Reserved != 0 {
+	if hdr.Reserved&^proto.ReqAcceptCompressed != 0 {
 		status := proto.StatusBadRequest
 		le.Status = status
 		le.StatusName = statusName(status)
-		le.RespPreview = buildRespPreview(cfg, opEcho, status, nil, "reserved must be 0")
-		le.RespBytes = s.writeResponse(w, cfg, versionEcho, opEcho, status, nil, "reserved must be 0")
+		le.RespPreview = buildRespPreview(cfg, opEcho, status, nil, "undefined reserved bits set")
+		le.RespBytes = s.writeResponse(w, cfg, versionEcho, opEcho, status, 0, nil, "undefined reserved bits set")
 		le.DurationMs = time.Since(startTime).Milliseconds()
 		s.record(cfg, le)
 		return
@@ -207,7 +345,7 @@ func (s *Server) handleRPC(w http.ResponseWriter, r *http.Request) {
 		le.StatusName = statusName(status)
 		msg := fmt.Sprintf("unsupported rpc version %d", hdr.Version)
 		le.RespPreview = buildRespPreview(cfg, opEcho, status, nil, msg)
-		le.RespBytes = s.writeResponse(w, cfg, versionEcho, opEcho, status, nil, msg)
+		le.RespBytes = s.writeResponse(w, cfg, versionEcho, opEcho, status, 0, nil, msg)
 		le.DurationMs = time.Since(startTime).Milliseconds()
 		s.record(cfg, le)
 		return
@@ -226,7 +364,7 @@ func (s *Server) handleRPC(w http.ResponseWriter, r *http.Request) {
 		le.Status = status
 		le.StatusName = statusName(status)
 		le.RespPreview = buildRespPreview(cfg, opEcho, status, nil, "payload_len mismatch")
-		le.RespBytes = s.writeResponse(w, cfg, versionEcho, opEcho, status, nil, "payload_len mismatch")
+		le.RespBytes = s.writeResponse(w, cfg, versionEcho, opEcho, status, 0, nil, "payload_len mismatch")
 		le.DurationMs = time.Since(startTime).Milliseconds()
 		s.record(cfg, le)
 		return
@@ -242,7 +380,7 @@ func (s *Server) handleRPC(w http.ResponseWriter, r *http.Request) {
 		le.Status = status
 		le.StatusName = statusName(status)
 		le.RespPreview = buildRespPreview(cfg, opEcho, status, nil, "payload too large")
-		le.RespBytes = s.writeResponse(w, cfg, versionEcho, opEcho, status, nil, "payload too large")
+		le.RespBytes = s.writeResponse(w, cfg, versionEcho, opEcho, status, 0, nil, "payload too large")
 		le.DurationMs = time.Since(startTime).Milliseconds()
 		s.record(cfg, le)
 		return
@@ -264,7 +402,48 @@ func (s *Server) handleRPC(w http.ResponseWriter, r *http.Request) {
 		le.Status = status
 		le.StatusName = statusName(status)
 		le.RespPreview = buildRespPreview(cfg, hdr.Op, status, nil, "access denied")
-		le.RespBytes = s.writeResponse(w, cfg, versionEcho, opEcho, status, nil, "access denied")
+		le.RespBytes = s.writeResponse(w, cfg, versionEcho, opEcho, status, 0, nil, "access denied")
+		le.DurationMs = time.Since(startTime).Milliseconds()
+		s.record(cfg, le)
+		return
+	}
+	if msg := tokenWindowError(ctx, time.Now()); msg != "" {
+		status := proto.StatusAccessDenied
+		le.Status = status
+		le.StatusName = statusName(status)
+		le.RespPreview = buildRespPreview(cfg, hdr.Op, status, nil, msg)
+		le.RespBytes = s.writeResponse(w, cfg, versionEcho, opEcho, status, 0, nil, msg)
+		le.DurationMs = time.Since(startTime).Milliseconds()
+		s.record(cfg, le)
+		return
+	}
+	if !ctx.IPAllowed(net.ParseIP(remoteIP)) {
+		status := proto.StatusAccessDenied
+		le.Status = status
+		le.StatusName = statusName(status)
+		le.RespPreview = buildRespPreview(cfg, hdr.Op, status, nil, "ip not allowed")
+		le.RespBytes = s.writeResponse(w, cfg, versionEcho, opEcho, status, 0, nil, "ip not allowed")
+		le.DurationMs = time.Since(startTime).Milliseconds()
+		s.record(cfg, le)
+		return
+	}
+	// The mux only routes exact endpoint paths, so URL.Path is the pattern.
+	if !ctx.EndpointAllowed(r.URL.Path) {
+		status := proto.StatusAccessDenied
+		le.Status = status
+		le.StatusName = statusName(status)
+		le.RespPreview = buildRespPreview(cfg, hdr.Op, status, nil, "wrong endpoint for token")
+		le.RespBytes = s.writeResponse(w, cfg, versionEcho, opEcho, status, 0, nil, "wrong endpoint for token")
+		le.DurationMs = time.Since(startTime).Milliseconds()
+		s.record(cfg, le)
+		return
+	}
+	if ctx.RateLimitPerSec > 0 && !s.rate.allow(tokenID(token), ctx.RateLimitPerSec, time.Now()) {
+		status := proto.StatusBusy
+		le.Status = status
+		le.StatusName = statusName(status)
+		le.RespPreview = buildRespPreview(cfg, hdr.Op, status, nil, "rate limited")
+		le.RespBytes = s.writeResponse(w, cfg, versionEcho, opEcho, status, 0, nil, "rate limited")
 		le.DurationMs = time.Since(startTime).Milliseconds()
 		s.record(cfg, le)
 		return
@@ -275,7 +454,7 @@ func (s *Server) handleRPC(w http.ResponseWriter, r *http.Request) {
 		le.Status = status
 		le.StatusName = statusName(status)
 		le.RespPreview = buildRespPreview(cfg, hdr.Op, status, nil, "bad root")
-		le.RespBytes = s.writeResponse(w, cfg, versionEcho, opEcho, status, nil, "bad root")
+		le.RespBytes = s.writeResponse(w, cfg, versionEcho, opEcho, status, 0, nil, "bad root")
 		le.DurationMs = time.Since(startTime).Milliseconds()
 		s.record(cfg, le)
 		return
@@ -285,7 +464,7 @@ func (s *Server) handleRPC(w http.ResponseWriter, r *http.Request) {
 		le.Status = status
 		le.StatusName = statusName(status)
 		le.RespPreview = buildRespPreview(cfg, hdr.Op, status, nil, "cannot create root")
-		le.RespBytes = s.writeResponse(w, cfg, versionEcho, opEcho, status, nil, "cannot create root")
+		le.RespBytes = s.writeResponse(w, cfg, versionEcho, opEcho, status, 0, nil, "cannot create root")
 		le.DurationMs = time.Since(startTime).Milliseconds()
 		s.record(cfg, le)
 		return
@@ -294,13 +473,26 @@ func (s *Server) handleRPC(w http.ResponseWriter, r *http.Request) {
 
 	// (request summary already computed above)
 
-	limits := Limits{ReadOnly: ctx.ReadOnly, QuotaBytes: ctx.QuotaBytes, MaxFileBytes: ctx.MaxFileBytes, DiskImagesEnabled: ctx.DiskImagesEnabled, DiskImagesWriteEnabled: ctx.DiskImagesWriteEnabled, DiskImagesAutoResizeEnabled: ctx.DiskImagesAutoResizeEnabled, DiskImagesAllowRenameConvert: ctx.DiskImagesAllowRenameConvert}
+	limits := limitsFromContext(ctx)
+	limits.tokenID = tokenID(token)
+	limits.tokenName = ctx.Name
+	limits.reqCtx = r.Context()
 
 	status, respPayload, errMsg := s.dispatch(cfg, limits, hdr.Op, hdr.Flags, payload, rootAbs)
 	le.RespPreview = buildRespPreview(cfg, hdr.Op, status, respPayload, errMsg)
+	respFlags := s.respFlags(cfg, limits, hdr.Op, status, payload, errMsg)
+	if status == proto.StatusOK && acceptsCompressed(hdr) {
+		if z, ok := compressPayload(cfg, respPayload); ok {
+			le.Info = strings.TrimSpace(le.Info + fmt.Sprintf(" deflate=%d->%d", len(respPayload), len(z)))
+			respPayload = z
+			respFlags |= proto.RespCompressed
+		}
+	}
+	// Bandwidth (max_bytes_per_sec) counts both directions of the round trip.
+	s.throttle(limits, len(body)+len(respPayload))
 	le.Status = status
 	le.StatusName = statusName(status)
-	le.RespBytes = s.writeResponse(w, cfg, versionEcho, opEcho, status, respPayload, errMsg)
+	le.RespBytes = s.writeResponse(w, cfg, versionEcho, opEcho, status, respFlags, respPayload, errMsg)
 	le.DurationMs = time.Since(startTime).Milliseconds()
 	s.record(cfg, le)
 }
@@ -329,20 +521,8 @@ func (s *Server) ensureRecommendedDirs(cfg config.Config, rootAbs string) error
 	return nil
 }
 
-func (s *Server) writeResponse(w http.ResponseWriter, cfg config.Config, versionEcho, opEcho, status byte, payload []byte, errMsg string) int {
-	respPayload := payload
-	if status != proto.StatusOK && cfg.EnableErrMsg {
-		// Optional debug message payload on errors.
-		e := proto.NewEncoder(64)
-		// Keep messages short to avoid blowing max_payload.
-		msg := errMsg
-		if len(msg) > 200 {
-			msg = msg[:200]
-		}
-		_ = e.WriteString(msg)
-		respPayload = e.Bytes()
-	}
-	resp, err := proto.BuildResponse(versionEcho, opEcho, status, respPayload)
+func (s *Server) writeResponse(w http.ResponseWriter, cfg config.Config, versionEcho, opEcho, status, flags byte, payload []byte, errMsg string) int {
+	resp, err := proto.BuildResponse(versionEcho, opEcho, status, flags, responsePayload(cfg, status, payload, errMsg))
 	if err != nil {
 		// Last resort: we cannot build a response -> HTTP 500 is allowed.
 		w.WriteHeader(http.StatusInternalServerError)
@@ -353,6 +533,45 @@ func (s *Server) writeResponse(w http.ResponseWriter, cfg config.Config, version
 	return len(resp)
 }
 
+// debugBodyMax caps the hex dump of a rejected body (debug_short_request).
+const debugBodyMax = 256
+
+// debugBodyInfo describes a body rejected before its header could be parsed:
+// content type, length and the received bytes in hex (up to debugBodyMax).
+func debugBodyInfo(ct string, body []byte) string {
+	b, more := body, ""
+	if len(b) > debugBodyMax {
+		b, more = b[:debugBodyMax], "..."
+	}
+	return fmt.Sprintf("ct=%s len=%d hex=%x%s", ct, len(body), b, more)
+}
+
+// withErrMsg returns cfg with enable_errmsg on, for responses that carry
+// their error message regardless of the setting.
+func withErrMsg(cfg config.Config) config.Config {
+	cfg.EnableErrMsg = true
+	return cfg
+}
+
+// responsePayload returns the payload to send for status: payload itself on
+// success or when an error carries data (the overwrite confirm of
+// WRITE_RANGE), otherwise the optional debug message (enable_errmsg) or
+// nothing.
+func responsePayload(cfg config.Config, status byte, payload []byte, errMsg string) []byte {
+	if status == proto.StatusOK || len(payload) > 0 || !cfg.EnableErrMsg {
+		return payload
+	}
+	// Optional debug message payload on errors.
+	e := proto.NewEncoder(64)
+	// Keep messages short to avoid blowing max_payload.
+	msg := errMsg
+	if len(msg) > 200 {
+		msg = msg[:200]
+	}
+	_ = e.WriteString(msg)
+	return e.Bytes()
+}
+
 // tryUnwrapW64FBody attempts to extract the raw W64F RPC blob from WiC64-style HTTP POST bodies.
 //
 // Some WiC64 firmware / helper stacks wrap the binary payload in a form field named "data".
@@ -408,20 +627,70 @@ func tryUnwrapW64FBody(body []byte, contentType string) ([]byte, string, bool) {
 }
 
 func (s *Server) dispatch(cfg config.Config, limits Limits, op byte, flags byte, payload []byte, rootAbs string) (status byte, respPayload []byte, errMsg string) {
+	if opDisabled(cfg, op) {
+		return proto.StatusNotSupported, nil, "op disabled by policy"
+	}
 	if limits.ReadOnly && isWriteOp(op) {
 		return proto.StatusAccessDenied, nil, "read-only mode"
 	}
+	if (limits.DiskImagesEnabled || cfg.ZipMountEnabled) && isWriteOp(op) {
+		if msg := s.readOnlyMountError(cfg, limits, op, payload); msg != "" {
+			return proto.StatusAccessDenied, nil, msg
+		}
+		if cfg.ZipMountEnabled {
+			s.forgetZipTargets(cfg, op, payload, rootAbs)
+		}
+	}
+	releaseQuota := func() {}
+	defer func() { releaseQuota() }()
+	if isGrowOp(op) {
+		releaseQuota, limits = s.lockQuota(limits, rootAbs)
+		if s.quotaFull(limits, rootAbs) {
+			return proto.StatusQuotaFull, nil, "read-only: quota full"
+		}
+	}
+	newFiles, st, msg := s.precheckFileCount(cfg, limits, op, flags, payload, rootAbs)
+	if st != proto.StatusOK {
+		return st, nil, msg
+	}
+	if flags&proto.FlagDRY_RUN != 0 && isDryRunOp(op) {
+		return s.runDryRun(cfg, limits, op, flags, payload, rootAbs)
+	}
+	if op == proto.OpCP && flags&proto.FlagCP_ASYNC != 0 {
+		// The background copy keeps the quota lock until it is done.
+		release := releaseQuota
+		releaseQuota = func() {}
+		return s.startAsyncCP(cfg, limits, flags, payload, rootAbs, newFiles, release)
+	}
+	return s.runOp(cfg, limits, op, flags, payload, rootAbs, newFiles)
+}
+
+// runOp executes a checked op and does the bookkeeping after it (file count,
+// audit log).
+func (s *Server) runOp(cfg config.Config, limits Limits, op byte, flags byte, payload []byte, rootAbs string, newFiles uint64) (status byte, respPayload []byte, errMsg string) {
+	status, respPayload, errMsg = s.dispatchOp(cfg, limits, op, flags, payload, rootAbs)
+	s.updateFileCount(op, status, newFiles, rootAbs)
+	if isWriteOp(op) {
+		s.invalidateLSCache(cfg, op, payload, rootAbs)
+	}
+	if cfg.AuditLogDir != "" && isWriteOp(op) {
+		s.auditTokenOp(cfg, limits, op, flags, payload, status, errMsg)
+	}
+	return status, respPayload, errMsg
+}
+
+func (s *Server) dispatchOp(cfg config.Config, limits Limits, op byte, flags byte, payload []byte, rootAbs string) (status byte, respPayload []byte, errMsg string) {
 	switch op {
 	case proto.OpCAPS:
-		return s.opCAPS(cfg, payload)
+		return s.opCAPS(cfg, limits, payload, rootAbs)
 	case proto.OpSTATFS:
-		return s.opSTATFS(cfg, payload, rootAbs)
+		return s.opSTATFS(cfg, limits, payload, rootAbs)
 	case proto.OpLS:
-		return s.opLS(cfg, limits, payload, rootAbs)
+		return s.opLS(cfg, limits, flags, payload, rootAbs)
 	case proto.OpSTAT:
 		return s.opSTAT(cfg, limits, payload, rootAbs)
 	case proto.OpREAD_RANGE:
-		return s.opREAD_RANGE(cfg, limits, payload, rootAbs)
+		return s.opREAD_RANGE(cfg, limits, flags, payload, rootAbs)
 	case proto.OpWRITE_RANGE:
 		return s.opWRITE_RANGE(cfg, limits, flags, payload, rootAbs)
 	case proto.OpAPPEND:
@@ -435,11 +704,63 @@ func (s *Server) dispatch(cfg config.Config, limits Limits, op byte, flags byte,
 	case proto.OpCP:
 		return s.opCP(cfg, limits, flags, payload, rootAbs)
 	case proto.OpSEARCH:
-		return s.opSEARCH(cfg, flags, payload, rootAbs)
+		return s.opSEARCH(cfg, limits, flags, payload, rootAbs)
 	case proto.OpHASH:
 		return s.opHASH(cfg, limits, flags, payload, rootAbs)
 	case proto.OpMV:
 		return s.opMV(cfg, limits, flags, payload, rootAbs)
+	case proto.OpDIRMTIME:
+		return s.opDIRMTIME(cfg, limits, payload, rootAbs)
+	case proto.OpSTRINGS:
+		return s.opSTRINGS(cfg, limits, payload, rootAbs)
+	case proto.OpTREE:
+		return s.opTREE(cfg, limits, flags, payload, rootAbs)
+	case proto.OpREAD_TAIL:
+		return s.opREAD_TAIL(cfg, limits, payload, rootAbs)
+	case proto.OpTOKEN_NAMES:
+		return s.opTOKEN_NAMES(cfg, payload)
+	case proto.OpTOUCH:
+		return s.opTOUCH(cfg, limits, flags, payload, rootAbs)
+	case proto.OpMKTEMP:
+		return s.opMKTEMP(cfg, limits, payload, rootAbs)
+	case proto.OpBATCH:
+		return s.opBATCH(cfg, limits, flags, payload, rootAbs)
+	case proto.OpECHO:
+		return s.opECHO(cfg, limits, payload)
+	case proto.OpLOCK:
+		return s.opLOCK(cfg, limits, payload, rootAbs)
+	case proto.OpUNLOCK:
+		return s.opUNLOCK(cfg, limits, flags, payload, rootAbs)
+	case proto.OpCOPY_RANGE:
+		return s.opCOPY_RANGE(cfg, limits, flags, payload, rootAbs)
+	case proto.OpMOTD:
+		return s.opMOTD(cfg, payload)
+	case proto.OpSAMEFILE:
+		return s.opSAMEFILE(cfg, limits, payload, rootAbs)
+	case proto.OpLS_TREE:
+		return s.opLS_TREE(cfg, limits, flags, payload, rootAbs)
+	case proto.OpIMAGE_CHANGES:
+		return s.opIMAGE_CHANGES(cfg, limits, payload, rootAbs)
+	case proto.OpEXISTS_EXACT:
+		return s.opEXISTS_EXACT(cfg, limits, payload, rootAbs)
+	case proto.OpPROGRESS:
+		return s.opPROGRESS(cfg, limits, payload)
+	case proto.OpVERIFY:
+		return s.opVERIFY(cfg, limits, payload, rootAbs)
+	case proto.OpTRASH_LS:
+		return s.opTRASH_LS(cfg, payload, rootAbs)
+	case proto.OpDIRHASH:
+		return s.opDIRHASH(cfg, limits, flags, payload, rootAbs)
+	case proto.OpTRASH_RESTORE:
+		return s.opTRASH_RESTORE(cfg, limits, flags, payload, rootAbs)
+	case proto.OpSTAT_MANY:
+		return s.opSTAT_MANY(cfg, limits, payload, rootAbs)
+	case proto.OpMKIMAGE:
+		return s.opMKIMAGE(cfg, limits, flags, payload, rootAbs)
+	case proto.OpWHOAMI:
+		return s.opWHOAMI(cfg, limits, payload, rootAbs)
+	case proto.OpIMAGES:
+		return s.opIMAGES(cfg, limits, flags, payload, rootAbs)
 	case proto.OpPING:
 		return s.opPING(cfg, payload)
 	default:
@@ -447,12 +768,49 @@ func (s *Server) dispatch(cfg config.Config, limits Limits, op byte, flags byte,
 	}
 }
 
-func (s *Server) opCAPS(cfg config.Config, payload []byte) (byte, []byte, string) {
+func (s *Server) opCAPS(cfg config.Config, limits Limits, payload []byte, rootAbs string) (byte, []byte, string) {
 	if len(payload) != 0 {
 		return proto.StatusBadRequest, nil, "CAPS request payload must be empty"
 	}
+	features := s.capsFeatures(cfg, limits, rootAbs)
+
+	// CAPS payload layout (v0.2.1+): max_chunk,u16 max_payload,u16 max_path,u16 max_name,u16 max_entries,u16 features_lo,u32 server_time_unix,u32 server_name,string max_decompressed,u16 features_hi,u32 preview_len,u16.
+	//
+	// max_decompressed is the largest orig_len of a compressed response
+	// (0 = compression off); older clients stop reading after server_name.
+	// features_hi was appended once the low word was full. preview_len is the
+	// SEARCH preview length (search_preview_bytes).
+	e := proto.NewEncoder(64)
+	e.WriteU16(cfg.MaxChunk)
+	e.WriteU16(cfg.MaxPayload)
+	e.WriteU16(cfg.MaxPath)
+	e.WriteU16(cfg.MaxName)
+	e.WriteU16(cfg.MaxEntries)
+	e.WriteU32(uint32(features))
+	e.WriteU32(uint32(time.Now().Unix()))
+	_ = e.WriteString(cfg.ServerName)
+	e.WriteU16(maxDecompressed(cfg))
+	e.WriteU32(uint32(features >> 32))
+	e.WriteU16(cfg.SearchPreviewBytes)
+	return proto.StatusOK, e.Bytes(), ""
+}
+
+// tokenWindowError returns the error message for a token used outside of its
+// valid_from_unix/valid_until_unix window, or "".
+func tokenWindowError(ctx config.TokenContext, now time.Time) string {
+	switch {
+	case config.TokenPending(ctx.ValidFromUnix, now.Unix()):
+		return fmt.Sprintf("token not valid before %s", time.Unix(ctx.ValidFromUnix, 0).UTC().Format(time.RFC3339))
+	case config.TokenExpired(ctx.ValidUntilUnix, now.Unix()):
+		return fmt.Sprintf("token expired at %s", time.Unix(ctx.ValidUntilUnix, 0).UTC().Format(time.RFC3339))
+	}
+	return ""
+}
+
+// capsFeatures returns the CAPS feature bits for a token (limits/rootAbs).
+func (s *Server) capsFeatures(cfg config.Config, limits Limits, rootAbs string) uint64 {
 	// Implemented operations (CAPS itself is always supported and is not listed as a feature).
-	features := proto.FeatSTATFS | proto.FeatAPPEND | proto.FeatSEARCH | proto.FeatHASH_CRC32
+	features := proto.FeatSTATFS | proto.FeatAPPEND | proto.FeatSEARCH | proto.FeatHASH_CRC32 | proto.FeatHASH_SHA256 | proto.FeatDIRMTIME | proto.FeatSTRINGS | proto.FeatTREE | proto.FeatREAD_TAIL | proto.FeatTOUCH | proto.FeatMKTEMP | proto.FeatBATCH | proto.FeatLOCK | proto.FeatCOPY_RANGE | proto.FeatSAMEFILE | proto.FeatLS_TREE | proto.FeatEXISTS_EXACT | proto.FeatSCREENCODE | proto.FeatCP_ASYNC | proto.FeatDIRHASH | proto.FeatWRITE_SIZE | proto.FeatSTAT_MANY | proto.FeatHASH_CRC16 | proto.FeatSTAGED_WRITE | proto.FeatWHOAMI | proto.FeatDRY_RUN | proto.FeatIF_HASH | proto.FeatLS_SORT
 	if cfg.EnableMkdirParents {
 		features |= proto.FeatMKDIR_PARENTS
 	}
@@ -464,23 +822,44 @@ func (s *Server) opCAPS(cfg config.Config, payload []byte) (byte, []byte, string
 	}
 	if cfg.EnableOverwrite {
 		features |= proto.FeatOVERWRITE
+		if cfg.OverwriteConfirm {
+			features |= proto.FeatOVERWRITE_CONFIRM
+		}
 	}
 	if cfg.EnableErrMsg {
 		features |= proto.FeatERRMSG
 	}
-
-	// CAPS payload layout (v0.2.1+): max_chunk,u16 max_payload,u16 max_path,u16 max_name,u16 max_entries,u16 features_lo,u32 server_time_unix,u32 server_name,string.
-	//
-	e := proto.NewEncoder(64)
-	e.WriteU16(cfg.MaxChunk)
-	e.WriteU16(cfg.MaxPayload)
-	e.WriteU16(cfg.MaxPath)
-	e.WriteU16(cfg.MaxName)
-	e.WriteU16(cfg.MaxEntries)
-	e.WriteU32(features)
-	e.WriteU32(uint32(time.Now().Unix()))
-	_ = e.WriteString(cfg.ServerName)
-	return proto.StatusOK, e.Bytes(), ""
+	if cfg.ExposeTokenNames {
+		features |= proto.FeatTOKEN_NAMES
+	}
+	if cfg.EnableEcho {
+		features |= proto.FeatECHO
+	}
+	if cfg.CompressResponses {
+		features |= proto.FeatCOMPRESS
+	}
+	if cfg.Motd != "" {
+		features |= proto.FeatMOTD
+	}
+	if cfg.ImageChangeIndex && limits.DiskImagesEnabled {
+		features |= proto.FeatIMAGE_CHANGES
+	}
+	if limits.DiskImagesEnabled {
+		features |= proto.FeatVERIFY | proto.FeatIMAGES
+		if limits.DiskImagesWriteEnabled {
+			features |= proto.FeatIMAGE_CONVERT | proto.FeatMKIMAGE
+		}
+	}
+	if cfg.TrashEnabled {
+		features |= proto.FeatTRASH
+	}
+	if limits.QuotaBytes > 0 {
+		features |= proto.FeatSTATFS_QUOTA
+	}
+	if limits.ReadOnly || s.quotaFull(limits, rootAbs) {
+		features |= proto.FeatREADONLY
+	}
+	return features &^ disabledOpFeatures(cfg)
 }
 
 func (s *Server) readPathString(cfg config.Config, d *proto.Decoder) (string, error) {
@@ -505,8 +884,14 @@ func (s *Server) readPathStringRead(cfg config.Config, d *proto.Decoder) (string
 	if err != nil {
 		return "", err
 	}
+	return s.normalizeReadPath(cfg, raw)
+}
 
+// normalizeReadPath is the validation part of readPathStringRead, for
+// callers that read the raw string themselves.
+func (s *Server) normalizeReadPath(cfg config.Config, raw string) (string, error) {
 	var p string
+	var err error
 	if cfg.Compat.WildcardLoad {
 		p, err = pathutil.NormalizeAllowWildcards(raw, cfg.MaxPath, cfg.MaxName)
 	} else {
@@ -528,8 +913,12 @@ func (s *Server) readPathStringRead(cfg config.Config, d *proto.Decoder) (string
 	return pathutil.Canonicalize(p), nil
 }
 
-func (s *Server) opSTATFS(cfg config.Config, payload []byte, rootAbs string) (byte, []byte, string) {
+func (s *Server) opSTATFS(cfg config.Config, limits Limits, payload []byte, rootAbs string) (byte, []byte, string) {
 	// path string optional; if empty -> "/".
+	// With a token quota (FeatSTATFS_QUOTA in CAPS) the numbers describe the
+	// quota: total=quota, used=root usage, free=quota-used. Otherwise they
+	// describe the host filesystem. Paths inside a disk image report the
+	// image's blocks instead.
 	d := proto.NewDecoder(payload)
 	p := "/"
 	if d.Remaining() == 0 {
@@ -546,6 +935,26 @@ func (s *Server) opSTATFS(cfg config.Config, payload []byte, rootAbs string) (by
 		return proto.StatusBadRequest, nil, "extra bytes in STATFS"
 	}
 
+	// Inside a D64/D71/D81 mount the numbers describe the image, so a client
+	// can show "BLOCKS FREE" like the drive (bytes = blocks * 256).
+	if limits.DiskImagesEnabled {
+		if kind, mountPath, _, ok := splitDiskImagePath(p); ok && kind != "t64" {
+			total, free, st, msg := diskImageStatfs(rootAbs, kind, mountPath)
+			if st != proto.StatusOK {
+				return st, nil, msg
+			}
+			used := uint64(0)
+			if total > free {
+				used = total - free
+			}
+			e := proto.NewEncoder(12)
+			e.WriteU32(clampU32(total))
+			e.WriteU32(clampU32(free))
+			e.WriteU32(clampU32(used))
+			return proto.StatusOK, e.Bytes(), ""
+		}
+	}
+
 	abs, err := fsops.ToOSPath(rootAbs, p)
 	if err != nil {
 		return proto.StatusInvalidPath, nil, err.Error()
@@ -564,6 +973,22 @@ func (s *Server) opSTATFS(cfg config.Config, payload []byte, rootAbs string) (by
 		return proto.StatusNotFound, nil, "not found"
 	}
 
+	if limits.QuotaBytes > 0 {
+		used, err := s.rootUsageBytes(rootAbs)
+		if err != nil {
+			return proto.StatusInternal, nil, err.Error()
+		}
+		free := uint64(0)
+		if used < limits.QuotaBytes {
+			free = limits.QuotaBytes - used
+		}
+		e := proto.NewEncoder(12)
+		e.WriteU32(clampU32(limits.QuotaBytes))
+		e.WriteU32(clampU32(free))
+		e.WriteU32(clampU32(used))
+		return proto.StatusOK, e.Bytes(), ""
+	}
+
 	total, free, err := fsops.DiskUsage(abs)
 	if err != nil {
 		// Spec allows 0 when unknown.
@@ -582,8 +1007,13 @@ func (s *Server) opSTATFS(cfg config.Config, payload []byte, rootAbs string) (by
 	return proto.StatusOK, e.Bytes(), ""
 }
 
-func (s *Server) opLS(cfg config.Config, limits Limits, payload []byte, rootAbs string) (byte, []byte, string) {
+func (s *Server) opLS(cfg config.Config, limits Limits, flags byte, payload []byte, rootAbs string) (byte, []byte, string) {
 	// Payload: path string (leer -> "/"), start_index u16, max_entries u16.
+	// Flags: sort order (FlagLS_SORT_*, FlagLS_REVERSE).
+	order, ok := lsOrderFromFlags(flags)
+	if !ok {
+		return proto.StatusBadRequest, nil, "unknown LS sort order"
+	}
 	d := proto.NewDecoder(payload)
 	p, err := s.readPathStringRead(cfg, d)
 	if err != nil {
@@ -609,7 +1039,14 @@ func (s *Server) opLS(cfg config.Config, limits Limits, payload []byte, rootAbs
 		maxEntries = 1
 	}
 
-	// --- Disk image virtual directories (.d64/.d71/.d81) ---
+	// --- Read-only .zip mounts ---
+	if cfg.ZipMountEnabled {
+		if mountPath, inner, ok := splitZipPath(p); ok {
+			return s.lsZip(cfg, rootAbs, mountPath, inner, start, maxEntries, order)
+		}
+	}
+
+	// --- Disk image virtual directories (.d64/.d71/.d81/.t64) ---
 	// If the requested path points to a supported disk image, list the image contents.
 	if limits.DiskImagesEnabled {
 		if mountPath, inner, ok := splitD64Path(p); ok {
@@ -651,6 +1088,181 @@ func (s *Server) opLS(cfg config.Config, limits Limits, payload []byte, rootAbs
 					}
 				}
 			}
+			files = sortImageEntries(files, order)
+			idx := int(start)
+			if idx < 0 || idx >= len(files) {
+				e := proto.NewEncoder(4)
+				e.WriteU16(0)      // count
+				e.WriteU16(0xFFFF) // next_index
+				return proto.StatusOK, e.Bytes(), ""
+			}
+
+			count := uint16(0)
+			buf := make([]byte, 0, 32*int(maxEntries)+2)
+			buf = proto.AppendU16(buf, 0) // placeholder count
+
+			for idx < len(files) && count < maxEntries {
+				fe := files[idx]
+				idx++
+				name := strings.ToUpper(fe.Name)
+				if uint16(len(name)) > cfg.MaxName {
+					// Truncate defensively (disk images can contain odd names).
+					name = name[:int(cfg.MaxName)]
+				}
+
+				enc := proto.NewEncoder(32)
+				enc.WriteU8(0) // file
+				enc.WriteU32(clampU32(fe.Size))
+				enc.WriteU32(uint32(img.ModTime.Unix()))
+				if err := enc.WriteString(name); err != nil {
+					return proto.StatusInternal, nil, err.Error()
+				}
+
+				entryBytes := enc.Bytes()
+				if len(buf)+len(entryBytes)+2 > int(cfg.MaxPayload) {
+					// stop early; still return a valid partial page
+					idx--
+					break
+				}
+				buf = append(buf, entryBytes...)
+				count++
+			}
+
+			nextIndex := uint16(0xFFFF)
+			if idx < len(files) {
+				nextIndex = uint16(idx)
+			}
+			buf = proto.AppendU16(buf, nextIndex)
+			binary.LittleEndian.PutUint16(buf[0:2], count)
+			return proto.StatusOK, buf, ""
+		}
+		if mountPath, inner, ok := splitT64Path(p); ok {
+			// Inside a disk image we support a flat namespace (no subdirectories).
+			// For compatibility, inner may be empty (list image root), a wildcard pattern
+			// (e.g. "*" or "DEMO*"), or an exact filename.
+			if strings.Contains(inner, "/") {
+				return proto.StatusNotADir, nil, "not a directory"
+			}
+			_, img, st, msg := resolveT64Mount(rootAbs, mountPath)
+			if st != proto.StatusOK {
+				return st, nil, msg
+			}
+
+			files := img.SortedEntries() // []*diskimage.FileEntry
+			// Optional filter (wildcard or exact)
+			if inner != "" {
+				if strings.ContainsAny(inner, "*?") {
+					pat := inner
+					filtered := make([]*diskimage.FileEntry, 0, len(files))
+					for _, fe := range files {
+						name := strings.ToUpper(fe.Name)
+						if wildcardMatch(pat, name) {
+							filtered = append(filtered, fe)
+						}
+					}
+					files = filtered
+				} else {
+					// exact match (+ optional .PRG fallback)
+					want := inner
+					fe, ok := img.Lookup(want)
+					if !ok && !strings.HasSuffix(want, ".PRG") {
+						fe, ok = img.Lookup(want + ".PRG")
+					}
+					if ok {
+						files = []*diskimage.FileEntry{fe}
+					} else {
+						files = nil
+					}
+				}
+			}
+			files = sortImageEntries(files, order)
+			idx := int(start)
+			if idx < 0 || idx >= len(files) {
+				e := proto.NewEncoder(4)
+				e.WriteU16(0)      // count
+				e.WriteU16(0xFFFF) // next_index
+				return proto.StatusOK, e.Bytes(), ""
+			}
+
+			count := uint16(0)
+			buf := make([]byte, 0, 32*int(maxEntries)+2)
+			buf = proto.AppendU16(buf, 0) // placeholder count
+
+			for idx < len(files) && count < maxEntries {
+				fe := files[idx]
+				idx++
+				name := strings.ToUpper(fe.Name)
+				if uint16(len(name)) > cfg.MaxName {
+					// Truncate defensively (disk images can contain odd names).
+					name = name[:int(cfg.MaxName)]
+				}
+
+				enc := proto.NewEncoder(32)
+				enc.WriteU8(0) // file
+				enc.WriteU32(clampU32(fe.Size))
+				enc.WriteU32(uint32(img.ModTime.Unix()))
+				if err := enc.WriteString(name); err != nil {
+					return proto.StatusInternal, nil, err.Error()
+				}
+
+				entryBytes := enc.Bytes()
+				if len(buf)+len(entryBytes)+2 > int(cfg.MaxPayload) {
+					// stop early; still return a valid partial page
+					idx--
+					break
+				}
+				buf = append(buf, entryBytes...)
+				count++
+			}
+
+			nextIndex := uint16(0xFFFF)
+			if idx < len(files) {
+				nextIndex = uint16(idx)
+			}
+			buf = proto.AppendU16(buf, nextIndex)
+			binary.LittleEndian.PutUint16(buf[0:2], count)
+			return proto.StatusOK, buf, ""
+		}
+		if mountPath, inner, ok := splitD82Path(p); ok {
+			// Inside a disk image we support a flat namespace (no subdirectories).
+			// For compatibility, inner may be empty (list image root), a wildcard pattern
+			// (e.g. "*" or "DEMO*"), or an exact filename.
+			if strings.Contains(inner, "/") {
+				return proto.StatusNotADir, nil, "not a directory"
+			}
+			_, img, st, msg := resolveD82Mount(rootAbs, mountPath)
+			if st != proto.StatusOK {
+				return st, nil, msg
+			}
+
+			files := img.SortedEntries() // []*diskimage.FileEntry
+			// Optional filter (wildcard or exact)
+			if inner != "" {
+				if strings.ContainsAny(inner, "*?") {
+					pat := inner
+					filtered := make([]*diskimage.FileEntry, 0, len(files))
+					for _, fe := range files {
+						name := strings.ToUpper(fe.Name)
+						if wildcardMatch(pat, name) {
+							filtered = append(filtered, fe)
+						}
+					}
+					files = filtered
+				} else {
+					// exact match (+ optional .PRG fallback)
+					want := inner
+					fe, ok := img.Lookup(want)
+					if !ok && !strings.HasSuffix(want, ".PRG") {
+						fe, ok = img.Lookup(want + ".PRG")
+					}
+					if ok {
+						files = []*diskimage.FileEntry{fe}
+					} else {
+						files = nil
+					}
+				}
+			}
+			files = sortImageEntries(files, order)
 			idx := int(start)
 			if idx < 0 || idx >= len(files) {
 				e := proto.NewEncoder(4)
@@ -737,6 +1349,7 @@ func (s *Server) opLS(cfg config.Config, limits Limits, payload []byte, rootAbs
 					}
 				}
 			}
+			files = sortImageEntries(files, order)
 			idx := int(start)
 			if idx < 0 || idx >= len(files) {
 				e := proto.NewEncoder(4)
@@ -854,6 +1467,7 @@ func (s *Server) opLS(cfg config.Config, limits Limits, payload []byte, rootAbs
 				}
 				files = filtered
 			}
+			files = sortImageEntries(files, order)
 
 			idx := int(start)
 			if idx < 0 || idx >= len(files) {
@@ -926,12 +1540,12 @@ func (s *Server) opLS(cfg config.Config, limits Limits, payload []byte, rootAbs
 		}
 	}
 
-	abs, err := fsops.ToOSPath(rootAbs, listPath)
+	abs, err := fsops.ToOSPathRead(rootAbs, listPath, cfg.AllowSymlinkTargets)
 	if err != nil {
 		return proto.StatusInvalidPath, nil, err.Error()
 	}
-	// Ensure the path does not contain symlinks.
-	if err := fsops.LstatNoSymlink(rootAbs, abs, false); err != nil {
+	// Ensure the path does not contain symlinks (other than whitelisted ones).
+	if err := fsops.LstatAllowSymlinks(rootAbs, abs, cfg.AllowSymlinkTargets); err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
 			return proto.StatusNotFound, nil, "not found"
 		}
@@ -949,7 +1563,10 @@ func (s *Server) opLS(cfg config.Config, limits Limits, payload []byte, rootAbs
 		return proto.StatusNotADir, nil, "not a directory"
 	}
 
-	entries, err := os.ReadDir(abs)
+	// Sorted by name (case-insensitive); cached while the directory is
+	// unchanged, so paging with start_index does not re-read it. Other
+	// orders are sorted from it below.
+	entries, err := s.readDirSorted(abs)
 	if err != nil {
 		return proto.StatusInternal, nil, err.Error()
 	}
@@ -963,11 +1580,7 @@ func (s *Server) opLS(cfg config.Config, limits Limits, payload []byte, rootAbs
 		}
 		entries = filtered
 	}
-
-	// Stable sort by name (case-insensitive).
-	sort.SliceStable(entries, func(i, j int) bool {
-		return strings.ToUpper(entries[i].Name()) < strings.ToUpper(entries[j].Name())
-	})
+	entries = sortHostEntries(cfg, limits, rootAbs, abs, entries, order)
 
 	if int(start) >= len(entries) {
 		// Clarified behavior: OK, count=0, next_index=0xFFFF.
@@ -985,7 +1598,7 @@ func (s *Server) opLS(cfg config.Config, limits Limits, payload []byte, rootAbs
 	idx := int(start)
 	for idx < len(entries) && count < maxEntries {
 		e := entries[idx]
-		info, err := e.Info()
+		info, err := lsEntryInfo(cfg, rootAbs, abs, e)
 		if err != nil {
 			return proto.StatusInternal, nil, err.Error()
 		}
@@ -995,8 +1608,7 @@ func (s *Server) opLS(cfg config.Config, limits Limits, payload []byte, rootAbs
 		name := strings.ToUpper(e.Name())
 		etype := byte(0)
 		size := uint32(0)
-		isImage := limits.DiskImagesEnabled && !info.IsDir() && (strings.HasSuffix(name, ".D64") || strings.HasSuffix(name, ".D71") || strings.HasSuffix(name, ".D81"))
-		if info.IsDir() || isImage {
+		if lsShownAsDir(cfg, limits, name, info.IsDir()) {
 			etype = 1
 			size = 0
 		} else {
@@ -1023,7 +1635,16 @@ func (s *Server) opLS(cfg config.Config, limits Limits, payload []byte, rootAbs
 
 		// Need room for entry + trailing next_index (2 bytes).
 		if len(buf)+len(entryBytes)+2 > int(cfg.MaxPayload) {
-			break
+			if count > 0 || !cfg.TruncateOversized {
+				break
+			}
+			// Not even one entry fits: shorten the name rather than leave the
+			// client with a page it can never get past.
+			short, ok := truncatedLSEntry(etype, size, mtime, name, int(cfg.MaxPayload)-len(buf)-2)
+			if !ok {
+				break
+			}
+			entryBytes = short
 		}
 		buf = append(buf, entryBytes...)
 		count++
@@ -1048,6 +1669,41 @@ func (s *Server) opLS(cfg config.Config, limits Limits, payload []byte, rootAbs
 	return proto.StatusOK, buf, ""
 }
 
+// truncatedLSEntry encodes an LS entry with the name shortened to fit room
+// bytes and proto.LSEntryTruncated set in the type byte. ok is false if not
+// even a one character name fits.
+func truncatedLSEntry(etype byte, size, mtime uint32, name string, room int) ([]byte, bool) {
+	keep := room - (1 + 4 + 4 + 2)
+	if keep < 1 {
+		return nil, false
+	}
+	if keep > len(name) {
+		keep = len(name)
+	}
+	enc := proto.NewEncoder(room)
+	enc.WriteU8(etype | proto.LSEntryTruncated)
+	enc.WriteU32(size)
+	enc.WriteU32(mtime)
+	_ = enc.WriteString(name[:keep])
+	return enc.Bytes(), true
+}
+
+// lsEntryInfo returns the info LS reports for entry e of the host directory
+// dirAbs: the entry itself, or the target of a symlink whitelisted by
+// allow_symlink_targets. Other symlinks keep their link info (and are
+// refused by the caller).
+func lsEntryInfo(cfg config.Config, rootAbs, dirAbs string, e os.DirEntry) (fs.FileInfo, error) {
+	info, err := e.Info()
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return info, err
+	}
+	link := filepath.Join(dirAbs, e.Name())
+	if !fsops.SymlinkAllowed(rootAbs, link, cfg.AllowSymlinkTargets) {
+		return info, nil
+	}
+	return os.Stat(link)
+}
+
 func (s *Server) opSTAT(cfg config.Config, limits Limits, payload []byte, rootAbs string) (byte, []byte, string) {
 	// Payload: path string (leer -> "/").
 	d := proto.NewDecoder(payload)
@@ -1058,8 +1714,19 @@ func (s *Server) opSTAT(cfg config.Config, limits Limits, payload []byte, rootAb
 	if d.Remaining() != 0 {
 		return proto.StatusBadRequest, nil, "extra bytes in STAT"
 	}
+	return s.statPath(cfg, limits, p, rootAbs)
+}
+
+// statPath answers STAT for a normalized path: type u8, size u32, mtime u32.
+func (s *Server) statPath(cfg config.Config, limits Limits, p string, rootAbs string) (byte, []byte, string) {
+	// Read-only .zip mounts
+	if cfg.ZipMountEnabled {
+		if mountPath, inner, ok := splitZipPath(p); ok {
+			return s.statZip(rootAbs, mountPath, inner)
+		}
+	}
 
-	// Disk image virtual directories (.d64/.d71/.d81)
+	// Disk image virtual directories (.d64/.d71/.d81/.t64)
 	if limits.DiskImagesEnabled {
 		if mountPath, inner, ok := splitD64Path(p); ok {
 			_, img, st, msg := resolveD64Mount(rootAbs, mountPath)
@@ -1084,6 +1751,52 @@ func (s *Server) opSTAT(cfg config.Config, limits Limits, payload []byte, rootAb
 			e.WriteU32(mtime)
 			return proto.StatusOK, e.Bytes(), ""
 		}
+		if mountPath, inner, ok := splitT64Path(p); ok {
+			_, img, st, msg := resolveT64Mount(rootAbs, mountPath)
+			if st != proto.StatusOK {
+				return st, nil, msg
+			}
+			mtime := uint32(img.ModTime.Unix())
+			if inner == "" {
+				e := proto.NewEncoder(9)
+				e.WriteU8(1) // dir
+				e.WriteU32(0)
+				e.WriteU32(mtime)
+				return proto.StatusOK, e.Bytes(), ""
+			}
+			_, fe, st, msg := resolveT64Inner(img, inner, cfg.Compat.FallbackPRGExtension)
+			if st != proto.StatusOK {
+				return st, nil, msg
+			}
+			e := proto.NewEncoder(9)
+			e.WriteU8(0) // file
+			e.WriteU32(clampU32(fe.Size))
+			e.WriteU32(mtime)
+			return proto.StatusOK, e.Bytes(), ""
+		}
+		if mountPath, inner, ok := splitD82Path(p); ok {
+			_, img, st, msg := resolveD82Mount(rootAbs, mountPath)
+			if st != proto.StatusOK {
+				return st, nil, msg
+			}
+			mtime := uint32(img.ModTime.Unix())
+			if inner == "" {
+				e := proto.NewEncoder(9)
+				e.WriteU8(1) // dir
+				e.WriteU32(0)
+				e.WriteU32(mtime)
+				return proto.StatusOK, e.Bytes(), ""
+			}
+			_, fe, st, msg := resolveD82Inner(img, inner, cfg.Compat.FallbackPRGExtension)
+			if st != proto.StatusOK {
+				return st, nil, msg
+			}
+			e := proto.NewEncoder(9)
+			e.WriteU8(0) // file
+			e.WriteU32(clampU32(fe.Size))
+			e.WriteU32(mtime)
+			return proto.StatusOK, e.Bytes(), ""
+		}
 		if mountPath, inner, ok := splitD71Path(p); ok {
 			_, img, st, msg := resolveD71Mount(rootAbs, mountPath)
 			if st != proto.StatusOK {
@@ -1173,7 +1886,27 @@ func (s *Server) opSTAT(cfg config.Config, limits Limits, payload []byte, rootAb
 	return proto.StatusOK, e.Bytes(), ""
 }
 
-func (s *Server) opREAD_RANGE(cfg config.Config, limits Limits, payload []byte, rootAbs string) (byte, []byte, string) {
+// opREAD_RANGE reads a file range. With FlagR_SCREENCODE the data is
+// translated from C64 screen codes to ASCII (1:1, offsets are preserved).
+func (s *Server) opREAD_RANGE(cfg config.Config, limits Limits, flags byte, payload []byte, rootAbs string) (byte, []byte, string) {
+	if flags&proto.FlagR_IF_HASH != 0 {
+		var want uint32
+		var ok bool
+		if payload, want, ok = splitIfHash(payload); !ok {
+			return proto.StatusBadRequest, nil, "missing IF_HASH crc"
+		}
+		if s.unchangedSince(cfg, limits, payload, rootAbs, want) {
+			return proto.StatusOK, nil, notModifiedMsg
+		}
+	}
+	st, data, msg := s.readRange(cfg, limits, payload, rootAbs)
+	if st == proto.StatusOK && flags&proto.FlagR_SCREENCODE != 0 {
+		petscii.ScreenCodesToASCII(data, flags&proto.FlagR_SC_LOWER != 0)
+	}
+	return st, data, msg
+}
+
+func (s *Server) readRange(cfg config.Config, limits Limits, payload []byte, rootAbs string) (byte, []byte, string) {
 	// Payload: path string, offset u32, length u16. Response: raw bytes.
 	d := proto.NewDecoder(payload)
 	p, err := s.readPathStringRead(cfg, d)
@@ -1195,17 +1928,86 @@ func (s *Server) opREAD_RANGE(cfg config.Config, limits Limits, payload []byte,
 		return proto.StatusTooLarge, nil, "chunk too large"
 	}
 
-	// Disk image virtual directories (.d64/.d71/.d81)
-	if limits.DiskImagesEnabled {
-		if mountPath, inner, ok := splitD64Path(p); ok {
-			imgAbs, img, st, msg := resolveD64Mount(rootAbs, mountPath)
+	// Read-only .zip mounts
+	if cfg.ZipMountEnabled {
+		if mountPath, inner, ok := splitZipPath(p); ok {
+			return s.readZip(rootAbs, mountPath, inner, offset, ln)
+		}
+	}
+
+	// Disk image virtual directories (.d64/.d71/.d81/.t64)
+	if limits.DiskImagesEnabled {
+		if mountPath, inner, ok := splitD64Path(p); ok {
+			imgAbs, img, st, msg := resolveD64Mount(rootAbs, mountPath)
+			if st != proto.StatusOK {
+				return st, nil, msg
+			}
+			if inner == "" {
+				return proto.StatusIsADir, nil, "is a directory"
+			}
+			_, fe, st, msg := resolveD64Inner(img, inner, cfg.Compat.FallbackPRGExtension)
+			if st != proto.StatusOK {
+				return st, nil, msg
+			}
+
+			off := uint64(offset)
+			want := uint64(ln)
+			if off > fe.Size {
+				return proto.StatusRangeInvalid, nil, "offset beyond EOF"
+			}
+			if off == fe.Size {
+				return proto.StatusOK, []byte{}, ""
+			}
+			if want > fe.Size-off {
+				return proto.StatusRangeInvalid, nil, "range exceeds EOF"
+			}
+
+			data, err := readD64FileRange(imgAbs, fe, off, want)
+			if err != nil {
+				return proto.StatusInternal, nil, err.Error()
+			}
+			return proto.StatusOK, data, ""
+		}
+		if mountPath, inner, ok := splitT64Path(p); ok {
+			imgAbs, img, st, msg := resolveT64Mount(rootAbs, mountPath)
+			if st != proto.StatusOK {
+				return st, nil, msg
+			}
+			if inner == "" {
+				return proto.StatusIsADir, nil, "is a directory"
+			}
+			_, fe, st, msg := resolveT64Inner(img, inner, cfg.Compat.FallbackPRGExtension)
+			if st != proto.StatusOK {
+				return st, nil, msg
+			}
+
+			off := uint64(offset)
+			want := uint64(ln)
+			if off > fe.Size {
+				return proto.StatusRangeInvalid, nil, "offset beyond EOF"
+			}
+			if off == fe.Size {
+				return proto.StatusOK, []byte{}, ""
+			}
+			if want > fe.Size-off {
+				return proto.StatusRangeInvalid, nil, "range exceeds EOF"
+			}
+
+			data, err := readT64FileRange(imgAbs, fe, off, want)
+			if err != nil {
+				return proto.StatusInternal, nil, err.Error()
+			}
+			return proto.StatusOK, data, ""
+		}
+		if mountPath, inner, ok := splitD82Path(p); ok {
+			imgAbs, img, st, msg := resolveD82Mount(rootAbs, mountPath)
 			if st != proto.StatusOK {
 				return st, nil, msg
 			}
 			if inner == "" {
 				return proto.StatusIsADir, nil, "is a directory"
 			}
-			_, fe, st, msg := resolveD64Inner(img, inner, cfg.Compat.FallbackPRGExtension)
+			_, fe, st, msg := resolveD82Inner(img, inner, cfg.Compat.FallbackPRGExtension)
 			if st != proto.StatusOK {
 				return st, nil, msg
 			}
@@ -1222,7 +2024,7 @@ func (s *Server) opREAD_RANGE(cfg config.Config, limits Limits, payload []byte,
 				return proto.StatusRangeInvalid, nil, "range exceeds EOF"
 			}
 
-			data, err := readD64FileRange(imgAbs, fe, off, want)
+			data, err := readD82FileRange(imgAbs, fe, off, want)
 			if err != nil {
 				return proto.StatusInternal, nil, err.Error()
 			}
@@ -1337,11 +2139,13 @@ func (s *Server) opREAD_RANGE(cfg config.Config, limits Limits, payload []byte,
 }
 
 func (s *Server) opWRITE_RANGE(cfg config.Config, limits Limits, flags byte, payload []byte, rootAbs string) (byte, []byte, string) {
-	// WRITE_RANGE flags: TRUNCATE|CREATE. Payload: path string, offset u32, data_len u16, data bytes.
-	if !s.writeMu.TryLock() {
+	// WRITE_RANGE flags: TRUNCATE|CREATE. Payload: path string, offset u32, data_len u16, data bytes
+	// (+ confirm u32 with FlagWR_CONFIRM).
+	release, ok := s.lockWrite(cfg, limits, proto.OpWRITE_RANGE, payload, rootAbs, false)
+	if !ok {
 		return proto.StatusBusy, nil, "server busy"
 	}
-	defer s.writeMu.Unlock()
+	defer release()
 
 	d := proto.NewDecoder(payload)
 	p, err := s.readPathString(cfg, d)
@@ -1359,13 +2163,21 @@ func (s *Server) opWRITE_RANGE(cfg config.Config, limits Limits, flags byte, pay
 	if dataLen > cfg.MaxChunk {
 		return proto.StatusTooLarge, nil, "chunk too large"
 	}
-	if d.Remaining() != int(dataLen) {
+	trailer := 0
+	if flags&proto.FlagWR_CONFIRM != 0 {
+		trailer = 4
+	}
+	if d.Remaining() != int(dataLen)+trailer {
 		return proto.StatusBadRequest, nil, "data_len mismatch"
 	}
 	data, err := d.ReadBytes(int(dataLen))
 	if err != nil {
 		return proto.StatusBadRequest, nil, err.Error()
 	}
+	var confirm uint32
+	if trailer > 0 {
+		confirm, _ = d.ReadU32()
+	}
 	if flags&proto.FlagWR_TRUNCATE != 0 {
 		if offset != 0 {
 			return proto.StatusBadRequest, nil, "TRUNCATE requires offset=0"
@@ -1377,6 +2189,25 @@ func (s *Server) opWRITE_RANGE(cfg config.Config, limits Limits, flags byte, pay
 		return proto.StatusIsADir, nil, "cannot write to /"
 	}
 
+	offset, data = s.stripWriteBOM(cfg, flags, rootAbs, p, offset, data)
+
+	if flags&proto.FlagWR_COMMIT != 0 && flags&proto.FlagWR_STAGED == 0 {
+		return proto.StatusBadRequest, nil, "COMMIT requires STAGED"
+	}
+	if st, resp, msg := s.confirmOverwrite(cfg, limits, flags, rootAbs, p, confirm); st != proto.StatusOK {
+		return st, resp, msg
+	}
+	if flags&proto.FlagWR_STAGED != 0 {
+		if isInsideDiskImage(limits, p) {
+			return proto.StatusNotSupported, nil, "staged writes are not supported inside disk images"
+		}
+		st, newSize, msg := s.writeStagedRange(cfg, limits, flags, rootAbs, p, offset, data)
+		if st != proto.StatusOK || flags&proto.FlagWR_WANT_SIZE == 0 {
+			return st, nil, msg
+		}
+		return st, writeSizeResp(newSize, len(data)), msg
+	}
+
 	// Disk images: if enabled, treat "/.../DISK.D64/FILE" as a file inside the image.
 	if limits.DiskImagesEnabled {
 		if mountPath, inner, ok := splitD64Path(p); ok {
@@ -1411,6 +2242,10 @@ func (s *Server) opWRITE_RANGE(cfg config.Config, limits Limits, flags byte, pay
 				}
 				return proto.StatusInternal, nil, err.Error()
 			}
+			if flags&proto.FlagWR_WANT_SIZE != 0 {
+				// Image writes are append-only: offset is the old size.
+				return proto.StatusOK, writeSizeResp(uint64(offset)+uint64(len(data)), len(data)), "written to d64"
+			}
 			resp := make([]byte, 4)
 			binary.LittleEndian.PutUint32(resp, written)
 			return proto.StatusOK, resp, "written to d64"
@@ -1447,6 +2282,10 @@ func (s *Server) opWRITE_RANGE(cfg config.Config, limits Limits, flags byte, pay
 				}
 				return proto.StatusInternal, nil, err.Error()
 			}
+			if flags&proto.FlagWR_WANT_SIZE != 0 {
+				// Image writes are append-only: offset is the old size.
+				return proto.StatusOK, writeSizeResp(uint64(offset)+uint64(len(data)), len(data)), "written to d71"
+			}
 			resp := make([]byte, 4)
 			binary.LittleEndian.PutUint32(resp, written)
 			return proto.StatusOK, resp, "written to d71"
@@ -1481,27 +2320,51 @@ func (s *Server) opWRITE_RANGE(cfg config.Config, limits Limits, flags byte, pay
 				}
 				return proto.StatusInternal, nil, err.Error()
 			}
+			if flags&proto.FlagWR_WANT_SIZE != 0 {
+				// Image writes are append-only: offset is the old size.
+				return proto.StatusOK, writeSizeResp(uint64(offset)+uint64(len(data)), len(data)), "written to d81"
+			}
 			resp := make([]byte, 4)
 			binary.LittleEndian.PutUint32(resp, written)
 			return proto.StatusOK, resp, "written to d81"
 		}
 	}
 
+	st, newSize, msg := s.writeHostFileRange(cfg, limits, flags, rootAbs, p, offset, data)
+	if st != proto.StatusOK || flags&proto.FlagWR_WANT_SIZE == 0 {
+		return st, nil, msg
+	}
+	return st, writeSizeResp(newSize, len(data)), msg
+}
+
+// writeSizeResp is the WRITE_RANGE answer for FlagWR_WANT_SIZE: new_size u32,
+// written u32.
+func writeSizeResp(newSize uint64, written int) []byte {
+	e := proto.NewEncoder(8)
+	e.WriteU32(clampU32(newSize))
+	e.WriteU32(uint32(written))
+	return e.Bytes()
+}
+
+// writeHostFileRange writes data at offset into the regular file p (not inside
+// a disk image), applying the WRITE_RANGE flag semantics (TRUNCATE, CREATE,
+// OVERWRITE), max file size and quota. It returns the new file size.
+func (s *Server) writeHostFileRange(cfg config.Config, limits Limits, flags byte, rootAbs, p string, offset uint32, data []byte) (byte, uint64, string) {
 	abs, err := fsops.ToOSPath(rootAbs, p)
 	if err != nil {
-		return proto.StatusInvalidPath, nil, err.Error()
+		return proto.StatusInvalidPath, 0, err.Error()
 	}
 	if err := fsops.LstatNoSymlink(rootAbs, abs, true); err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
-			return proto.StatusNotFound, nil, "not found"
+			return proto.StatusNotFound, 0, "not found"
 		}
-		return proto.StatusInvalidPath, nil, err.Error()
+		return proto.StatusInvalidPath, 0, err.Error()
 	}
 
 	// Check existence.
 	st, err := fsops.Stat(abs)
 	if err != nil {
-		return proto.StatusInternal, nil, err.Error()
+		return proto.StatusInternal, 0, err.Error()
 	}
 
 	truncate := flags&proto.FlagWR_TRUNCATE != 0
@@ -1510,21 +2373,21 @@ func (s *Server) opWRITE_RANGE(cfg config.Config, limits Limits, flags byte, pay
 	var oldSize uint64
 	if !st.Exists {
 		if !create {
-			return proto.StatusNotFound, nil, "not found"
+			return proto.StatusNotFound, 0, "not found"
 		}
 		// Parent must exist.
 		parent := filepath.Dir(abs)
 		pst, err := fsops.Stat(parent)
 		if err != nil {
-			return proto.StatusInternal, nil, err.Error()
+			return proto.StatusInternal, 0, err.Error()
 		}
 		if !pst.Exists || !pst.IsDir {
-			return proto.StatusNotFound, nil, "parent directory missing"
+			return proto.StatusNotFound, 0, "parent directory missing"
 		}
 		oldSize = 0
 	} else {
 		if st.IsDir {
-			return proto.StatusIsADir, nil, "is a directory"
+			return proto.StatusIsADir, 0, "is a directory"
 		}
 		oldSize = st.Size
 	}
@@ -1535,20 +2398,20 @@ func (s *Server) opWRITE_RANGE(cfg config.Config, limits Limits, flags byte, pay
 	// confirm that replacing an existing file is intended.
 	if st.Exists && !st.IsDir {
 		if oldSize > 0 && offset == 0 && !truncate && len(data) > 0 {
-			return proto.StatusAlreadyExists, nil, "file exists; set TRUNCATE (+OVERWRITE) to replace"
+			return proto.StatusAlreadyExists, 0, "file exists; set TRUNCATE (+OVERWRITE) to replace"
 		}
 		if truncate && oldSize > 0 {
 			if !cfg.EnableOverwrite {
-				return proto.StatusAccessDenied, nil, "overwrite disabled by server"
+				return proto.StatusAccessDenied, 0, "overwrite disabled by server"
 			}
 			if !overwrite {
-				return proto.StatusAccessDenied, nil, "overwrite requires OVERWRITE flag"
+				return proto.StatusAccessDenied, 0, "overwrite requires OVERWRITE flag"
 			}
 		}
 	}
 
 	if uint64(offset) > oldSize {
-		return proto.StatusRangeInvalid, nil, "no sparse writes"
+		return proto.StatusRangeInvalid, 0, "no sparse writes"
 	}
 
 	// Pre-check sizes for limits.
@@ -1565,7 +2428,7 @@ func (s *Server) opWRITE_RANGE(cfg config.Config, limits Limits, flags byte, pay
 	}
 
 	if limits.MaxFileBytes > 0 && newSize > limits.MaxFileBytes {
-		return proto.StatusTooLarge, nil, "max file size exceeded"
+		return proto.StatusTooLarge, 0, "max file size exceeded"
 	}
 
 	delta := int64(newSize) - int64(oldSize)
@@ -1574,12 +2437,12 @@ func (s *Server) opWRITE_RANGE(cfg config.Config, limits Limits, flags byte, pay
 	if limits.QuotaBytes > 0 && delta > 0 {
 		used, err := s.rootUsageBytes(rootAbs)
 		if err != nil {
-			return proto.StatusInternal, nil, err.Error()
+			return proto.StatusInternal, 0, err.Error()
 		}
 		haveUsed = true
 		usedBefore = used
-		if usedBefore+uint64(delta) > limits.QuotaBytes {
-			return proto.StatusTooLarge, nil, "quota exceeded"
+		if !s.fitQuota(cfg, limits, rootAbs, &usedBefore, uint64(delta)) {
+			return proto.StatusTooLarge, 0, "quota exceeded"
 		}
 	} else if s.usage != nil {
 		// Keep cache warm for future checks.
@@ -1602,34 +2465,35 @@ func (s *Server) opWRITE_RANGE(cfg config.Config, limits Limits, flags byte, pay
 	if err != nil {
 		// A directory might have appeared between stat and open.
 		if errors.Is(err, fs.ErrPermission) {
-			return proto.StatusAccessDenied, nil, "access denied"
+			return proto.StatusAccessDenied, 0, "access denied"
 		}
-		return proto.StatusInternal, nil, err.Error()
+		return proto.StatusInternal, 0, err.Error()
 	}
 	defer f.Close()
 
 	if _, err := f.Seek(int64(offset), io.SeekStart); err != nil {
 		s.invalidateRootUsage(rootAbs)
-		return proto.StatusRangeInvalid, nil, err.Error()
+		return proto.StatusRangeInvalid, 0, err.Error()
 	}
 	if _, err := f.Write(data); err != nil {
 		s.invalidateRootUsage(rootAbs)
-		return proto.StatusInternal, nil, err.Error()
+		return proto.StatusInternal, 0, err.Error()
 	}
 	_ = f.Sync()
 
 	if haveUsed && s.usage != nil {
-		s.setRootUsageBytes(rootAbs, applyDeltaBytes(usedBefore, delta))
+		s.adjustRootUsage(rootAbs, delta)
 	}
-	return proto.StatusOK, nil, ""
+	return proto.StatusOK, newSize, ""
 }
 
 func (s *Server) opAPPEND(cfg config.Config, limits Limits, flags byte, payload []byte, rootAbs string) (byte, []byte, string) {
 	// APPEND flags: CREATE (bit1). Payload: path string, data_len u16, data bytes.
-	if !s.writeMu.TryLock() {
+	release, ok := s.lockWrite(cfg, limits, proto.OpAPPEND, payload, rootAbs, false)
+	if !ok {
 		return proto.StatusBusy, nil, "busy"
 	}
-	defer s.writeMu.Unlock()
+	defer release()
 
 	d := proto.NewDecoder(payload)
 	p, err := s.readPathString(cfg, d)
@@ -1745,7 +2609,7 @@ func (s *Server) opAPPEND(cfg config.Config, limits Limits, flags byte, payload
 		}
 		haveUsed = true
 		usedBefore = used
-		if usedBefore+uint64(delta) > limits.QuotaBytes {
+		if !s.fitQuota(cfg, limits, rootAbs, &usedBefore, uint64(delta)) {
 			return proto.StatusTooLarge, nil, "quota exceeded"
 		}
 	} else if s.usage != nil {
@@ -1776,13 +2640,14 @@ func (s *Server) opAPPEND(cfg config.Config, limits Limits, flags byte, payload
 	_ = f.Sync()
 
 	if haveUsed && s.usage != nil {
-		s.setRootUsageBytes(rootAbs, applyDeltaBytes(usedBefore, delta))
+		s.adjustRootUsage(rootAbs, delta)
 	}
 	return proto.StatusOK, nil, ""
 }
 
 func (s *Server) opHASH(cfg config.Config, limits Limits, flags byte, payload []byte, rootAbs string) (byte, []byte, string) {
-	// HASH flags: bit0 selects algo (0=CRC32, 1=SHA1). Only CRC32 is implemented.
+	// HASH flags: none or bit0 -> CRC32 (u32), bit1 -> SHA-256 (32 bytes),
+	// bit2 -> CRC-16/CCITT-FALSE (u16, see crc16.go).
 	d := proto.NewDecoder(payload)
 	p, err := s.readPathStringRead(cfg, d)
 	if err != nil {
@@ -1791,65 +2656,37 @@ func (s *Server) opHASH(cfg config.Config, limits Limits, flags byte, payload []
 	if d.Remaining() != 0 {
 		return proto.StatusBadRequest, nil, "extra bytes in HASH"
 	}
-	if flags&proto.FlagH_ALGO != 0 {
-		return proto.StatusNotSupported, nil, "SHA1 not supported"
+	wantSHA256 := flags&proto.FlagH_SHA256 != 0
+	wantCRC16 := flags&proto.FlagH_CRC16 != 0
+	if (wantSHA256 || wantCRC16) && flags&proto.FlagH_ALGO != 0 || wantSHA256 && wantCRC16 {
+		return proto.StatusBadRequest, nil, "conflicting HASH algo flags"
 	}
 
-	// Disk image virtual directories (.d64/.d71/.d81)
-	if limits.DiskImagesEnabled {
-		if mountPath, inner, ok := splitD64Path(p); ok {
-			imgAbs, img, st, msg := resolveD64Mount(rootAbs, mountPath)
-			if st != proto.StatusOK {
-				return st, nil, msg
-			}
-			if inner == "" {
-				return proto.StatusIsADir, nil, "is a directory"
-			}
-			_, fe, st, msg := resolveD64Inner(img, inner, cfg.Compat.FallbackPRGExtension)
-			if st != proto.StatusOK {
-				return st, nil, msg
-			}
-			sum, err := crc32D64File(imgAbs, fe)
-			if err != nil {
-				return proto.StatusInternal, nil, err.Error()
-			}
-			e := proto.NewEncoder(4)
-			e.WriteU32(sum)
-			return proto.StatusOK, e.Bytes(), ""
-		}
-		if mountPath, inner, ok := splitD71Path(p); ok {
-			imgAbs, img, st, msg := resolveD71Mount(rootAbs, mountPath)
-			if st != proto.StatusOK {
-				return st, nil, msg
-			}
-			if inner == "" {
-				return proto.StatusIsADir, nil, "is a directory"
-			}
-			_, fe, st, msg := resolveD71Inner(img, inner, cfg.Compat.FallbackPRGExtension)
-			if st != proto.StatusOK {
-				return st, nil, msg
-			}
-			sum, err := crc32D71File(imgAbs, fe)
-			if err != nil {
-				return proto.StatusInternal, nil, err.Error()
-			}
-			e := proto.NewEncoder(4)
-			e.WriteU32(sum)
-			return proto.StatusOK, e.Bytes(), ""
+	// Read-only .zip mounts
+	if cfg.ZipMountEnabled {
+		if mountPath, inner, ok := splitZipPath(p); ok {
+			return s.hashZip(rootAbs, mountPath, inner, wantSHA256, wantCRC16)
 		}
-		if mountPath, inner, ok := splitD81Path(p); ok {
-			imgAbs, img, st, msg := resolveD81Mount(rootAbs, mountPath)
+	}
+
+	// Disk image virtual directories (.d64/.d71/.d81/.t64/.d82)
+	if limits.DiskImagesEnabled {
+		if kind, mountPath, inner, ok := splitDiskImagePath(p); ok {
+			imgAbs, fe, st, msg := resolveDiskImageFile(rootAbs, kind, mountPath, inner, cfg.Compat.FallbackPRGExtension)
 			if st != proto.StatusOK {
 				return st, nil, msg
 			}
-			if inner == "" {
-				return proto.StatusIsADir, nil, "is a directory"
+			if wantCRC16 {
+				return crc16Resp(crc16ImageFile(imgAbs, fe))
 			}
-			_, fe, st, msg := resolveD81Inner(img, inner, cfg.Compat.FallbackPRGExtension)
-			if st != proto.StatusOK {
-				return st, nil, msg
+			if wantSHA256 {
+				sum, err := sha256ImageFile(imgAbs, fe)
+				if err != nil {
+					return proto.StatusInternal, nil, err.Error()
+				}
+				return proto.StatusOK, sum, ""
 			}
-			sum, err := crc32D81File(imgAbs, fe)
+			sum, err := crc32ImageFile(imgAbs, fe)
 			if err != nil {
 				return proto.StatusInternal, nil, err.Error()
 			}
@@ -1884,6 +2721,19 @@ func (s *Server) opHASH(cfg config.Config, limits Limits, flags byte, payload []
 	}
 	defer f.Close()
 
+	if wantSHA256 {
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return proto.StatusInternal, nil, err.Error()
+		}
+		return proto.StatusOK, h.Sum(nil), ""
+	}
+	if wantCRC16 {
+		h := newCRC16()
+		_, err := io.Copy(h, f)
+		return crc16Resp(h.Sum16(), err)
+	}
+
 	h := crc32.NewIEEE()
 	if _, err := io.Copy(h, f); err != nil {
 		return proto.StatusInternal, nil, err.Error()
@@ -1894,14 +2744,22 @@ func (s *Server) opHASH(cfg config.Config, limits Limits, flags byte, payload []
 	return proto.StatusOK, e.Bytes(), ""
 }
 
-func (s *Server) opSEARCH(cfg config.Config, flags byte, payload []byte, rootAbs string) (byte, []byte, string) {
+func (s *Server) opSEARCH(cfg config.Config, limits Limits, flags byte, payload []byte, rootAbs string) (byte, []byte, string) {
 	// SEARCH payload: base_path string, query string, start_index u16, max_results u16, max_scan_bytes u32.
 	// Response: count u16, hits[], next_index u16.
-	const (
-		defaultMaxScanBytes uint32 = 4 * 1024 * 1024  // 4 MiB
-		maxMaxScanBytes     uint32 = 32 * 1024 * 1024 // 32 MiB
-		previewMax                 = 32
-	)
+	// FlagS_REGEX treats query as an RE2 regex; files above
+	// search_regex_max_file_bytes are skipped in that mode.
+	// FlagS_NAMES matches file names instead of contents: query is a wildcard
+	// pattern (* and ?), or a regex with FlagS_REGEX; a query containing '/'
+	// is matched against the path below base. Hits have offset 0 and no
+	// preview.
+	// FlagS_INTO_IMAGES (disk images enabled) searches the files inside
+	// mounted images instead of the image files; hits are reported as
+	// /DISK.D64/FILE. D81 partitions are not entered.
+	// max_scan_bytes 0 means search_default_scan_bytes; larger values are
+	// clamped to search_max_scan_bytes. Previews are search_preview_bytes
+	// long.
+	previewMax := int(cfg.SearchPreviewBytes)
 
 	d := proto.NewDecoder(payload)
 	base, err := s.readPathString(cfg, d)
@@ -1945,11 +2803,10 @@ func (s *Server) opSEARCH(cfg config.Config, flags byte, payload []byte, rootAbs
 	}
 
 	if maxScan == 0 {
-		maxScan = defaultMaxScanBytes
+		maxScan = cfg.SearchDefaultScanBytes
 	}
-	maxScan = clampU32(uint64(maxScan))
-	if maxScan > maxMaxScanBytes {
-		maxScan = maxMaxScanBytes
+	if maxScan > cfg.SearchMaxScanBytes {
+		maxScan = cfg.SearchMaxScanBytes
 	}
 	if maxScan < uint32(len(q)) {
 		maxScan = uint32(len(q))
@@ -1958,6 +2815,26 @@ func (s *Server) opSEARCH(cfg config.Config, flags byte, payload []byte, rootAbs
 	caseInsensitive := flags&proto.FlagS_CASE_INSENSITIVE != 0
 	recursive := flags&proto.FlagS_RECURSIVE != 0
 	wholeWord := flags&proto.FlagS_WHOLE_WORD != 0
+	names := flags&proto.FlagS_NAMES != 0
+	intoImages := flags&proto.FlagS_INTO_IMAGES != 0 && limits.DiskImagesEnabled
+
+	// Regex mode: ^ and $ match at line boundaries, CI and WHOLE_WORD map to
+	// (?i) and \b.
+	var re *regexp.Regexp
+	if flags&proto.FlagS_REGEX != 0 {
+		expr := q
+		if wholeWord {
+			expr = `\b(?:` + expr + `)\b`
+		}
+		mode := "(?m)"
+		if caseInsensitive {
+			mode = "(?mi)"
+		}
+		re, err = regexp.Compile(mode + expr)
+		if err != nil {
+			return proto.StatusBadRequest, nil, "invalid regex: " + err.Error()
+		}
+	}
 
 	baseAbs, err := fsops.ToOSPath(rootAbs, base)
 	if err != nil {
@@ -1977,11 +2854,14 @@ func (s *Server) opSEARCH(cfg config.Config, flags byte, payload []byte, rootAbs
 		return proto.StatusNotADir, nil, "not a directory"
 	}
 
-	// Collect candidate files (abs path + canonical w64 path).
+	// Collect candidate files (abs path + canonical w64 path). Files inside
+	// a disk image have abs = the image file and img set.
 	type fileEnt struct {
-		abs string
-		w64 string
-		key string
+		abs     string
+		w64     string
+		key     string
+		imgKind string
+		img     *diskimage.FileEntry
 	}
 	var files []fileEnt
 
@@ -2038,6 +2918,28 @@ func (s *Server) opSEARCH(cfg config.Config, flags byte, payload []byte, rootAbs
 		}
 	}
 
+	if intoImages {
+		var expanded []fileEnt
+		for _, fe := range files {
+			kind, mountPath, inner, ok := splitDiskImagePath(fe.w64)
+			if !ok || inner != "" {
+				expanded = append(expanded, fe)
+				continue
+			}
+			imgAbs, entries, ok := searchImageEntries(rootAbs, kind, mountPath)
+			if !ok {
+				// Not a readable image: search it as a plain file.
+				expanded = append(expanded, fe)
+				continue
+			}
+			for _, ie := range entries {
+				w64p := fe.w64 + "/" + strings.ToUpper(ie.Name)
+				expanded = append(expanded, fileEnt{abs: imgAbs, w64: w64p, key: strings.ToUpper(w64p), imgKind: kind, img: ie})
+			}
+		}
+		files = expanded
+	}
+
 	sort.Slice(files, func(i, j int) bool { return files[i].key < files[j].key })
 
 	qBytes := []byte(q)
@@ -2060,15 +2962,152 @@ func (s *Server) opSEARCH(cfg config.Config, flags byte, payload []byte, rootAbs
 	resp := make([]byte, 0, 256)
 	resp = append(resp, 0, 0) // count placeholder
 
+	// addHit pages one match: matches before start_index are only counted.
+	// It returns false once the page is full (hasMore is set then). preview
+	// is only called for hits on the page; nil means no preview.
+	addHit := func(w64 string, matchOff uint64, preview func() ([]byte, error)) (bool, error) {
+		if globalIdx < startIdx {
+			globalIdx++
+			return true, nil
+		}
+		if uint32(count) >= maxResU {
+			hasMore = true
+			globalIdx++
+			return false, nil
+		}
+		var pv []byte
+		if preview != nil {
+			var err error
+			if pv, err = preview(); err != nil {
+				return false, err
+			}
+		}
+		tmp := proto.NewEncoder(64)
+		_ = tmp.WriteString(w64)
+		tmp.WriteU32(clampU32(matchOff))
+		tmp.WriteU16(uint16(len(pv)))
+		tmp.WriteBytes(pv)
+		hit := tmp.Bytes()
+		if over := len(resp) + len(hit) + 2 - int(cfg.MaxPayload); over > 0 && count == 0 && cfg.TruncateOversized && over <= len(pv) {
+			// The first hit must fit; shorten its pv.
+			pv = pv[:len(pv)-over]
+			tmp = proto.NewEncoder(64)
+			_ = tmp.WriteString(w64)
+			tmp.WriteU32(clampU32(matchOff))
+			tmp.WriteU16(uint16(len(pv)))
+			tmp.WriteBytes(pv)
+			hit = tmp.Bytes()
+		}
+		if len(resp)+len(hit)+2 > int(cfg.MaxPayload) {
+			hasMore = true
+			return false, nil
+		}
+		resp = append(resp, hit...)
+		count++
+		globalIdx++
+		return true, nil
+	}
+
+	// scanData reports the matches in data, a whole file (or the part the
+	// scan budget allowed) held in memory. It returns false once the page is
+	// full.
+	scanData := func(w64 string, data []byte) bool {
+		memPreview := func(off int) func() ([]byte, error) {
+			return func() ([]byte, error) {
+				return append([]byte(nil), data[off:min(off+previewMax, len(data))]...), nil
+			}
+		}
+		if re != nil {
+			for _, m := range re.FindAllIndex(data, -1) {
+				if m[0] == m[1] {
+					continue // empty match (e.g. a lone ^), nothing to show
+				}
+				if ok, _ := addHit(w64, uint64(m[0]), memPreview(m[0])); !ok {
+					return false
+				}
+			}
+			return true
+		}
+		hay := data
+		if caseInsensitive {
+			hay = foldASCIIUpper(data)
+		}
+		for i := 0; ; {
+			idx := bytes.Index(hay[i:], qFold)
+			if idx < 0 {
+				return true
+			}
+			m := i + idx
+			i = m + len(qFold)
+			if wholeWord && ((m > 0 && isWordChar(data[m-1])) || (i < len(data) && isWordChar(data[i]))) {
+				continue
+			}
+			if ok, _ := addHit(w64, uint64(m), memPreview(m)); !ok {
+				return false
+			}
+		}
+	}
+
+	namePat := q
+	if caseInsensitive {
+		namePat = strings.ToUpper(namePat)
+	}
 	for _, fe := range files {
 		if hasMore {
 			break
 		}
+		if names {
+			subject := filepath.Base(fe.abs)
+			if strings.Contains(q, "/") {
+				if rel, err := filepath.Rel(baseAbs, fe.abs); err == nil {
+					subject = filepath.ToSlash(rel)
+				}
+			}
+			if fe.img != nil {
+				if strings.Contains(q, "/") {
+					subject += "/" + fe.img.Name
+				} else {
+					subject = fe.img.Name
+				}
+			}
+			var match bool
+			if re != nil {
+				match = re.MatchString(subject)
+			} else {
+				if caseInsensitive {
+					subject = strings.ToUpper(subject)
+				}
+				match = wildcardMatch(namePat, subject)
+			}
+			if match {
+				if ok, _ := addHit(fe.w64, 0, nil); !ok {
+					break
+				}
+			}
+			continue
+		}
 		if scanBudget == 0 {
 			incomplete = true
 			break
 		}
 
+		if fe.img != nil {
+			if re != nil && cfg.SearchRegexMaxBytes > 0 && fe.img.Size > cfg.SearchRegexMaxBytes {
+				continue
+			}
+			data, err := readImageFileRange(fe.imgKind, fe.abs, fe.img, 0, min(fe.img.Size, uint64(scanBudget)))
+			if err != nil {
+				continue // broken file chain, skip like a vanished file
+			}
+			scanBudget -= uint32(len(data))
+			scanData(fe.w64, data)
+			if scanBudget == 0 && !hasMore {
+				incomplete = true
+				break
+			}
+			continue
+		}
+
 		// Re-check symlink safety right before opening (best effort).
 		if err := fsops.LstatNoSymlink(rootAbs, fe.abs, false); err != nil {
 			return proto.StatusInvalidPath, nil, err.Error()
@@ -2092,6 +3131,27 @@ func (s *Server) opSEARCH(cfg config.Config, flags byte, payload []byte, rootAbs
 		}
 		fileSize := uint64(fi.Size())
 
+		if re != nil {
+			// Regex matches can span chunk boundaries: read the file whole.
+			if cfg.SearchRegexMaxBytes > 0 && fileSize > cfg.SearchRegexMaxBytes {
+				f.Close()
+				continue
+			}
+			data, err := io.ReadAll(io.LimitReader(f, int64(scanBudget)))
+			if err != nil {
+				f.Close()
+				return proto.StatusInternal, nil, err.Error()
+			}
+			scanBudget -= uint32(len(data))
+			f.Close()
+			scanData(fe.w64, data)
+			if scanBudget == 0 && !hasMore {
+				incomplete = true
+				break
+			}
+			continue
+		}
+
 		queryLen := len(qFold)
 		tailLen := queryLen - 1
 
@@ -2157,30 +3217,14 @@ func (s *Server) opSEARCH(cfg config.Config, flags byte, payload []byte, rootAbs
 						}
 					}
 
-					if globalIdx < startIdx {
-						globalIdx++
-					} else if uint32(count) < maxResU {
-						preview, perr := readPreviewAt(f, fileSize, matchOff, previewMax)
-						if perr != nil {
-							f.Close()
-							return proto.StatusInternal, nil, perr.Error()
-						}
-						tmp := proto.NewEncoder(64)
-						_ = tmp.WriteString(fe.w64)
-						tmp.WriteU32(clampU32(matchOff))
-						tmp.WriteU16(uint16(len(preview)))
-						tmp.WriteBytes(preview)
-						hit := tmp.Bytes()
-						if len(resp)+len(hit)+2 > int(cfg.MaxPayload) {
-							hasMore = true
-							break
-						}
-						resp = append(resp, hit...)
-						count++
-						globalIdx++
-					} else {
-						hasMore = true
-						globalIdx++
+					ok, herr := addHit(fe.w64, matchOff, func() ([]byte, error) {
+						return readPreviewAt(f, fileSize, matchOff, previewMax)
+					})
+					if herr != nil {
+						f.Close()
+						return proto.StatusInternal, nil, herr.Error()
+					}
+					if !ok {
 						break
 					}
 
@@ -2345,6 +3389,8 @@ func (s *Server) opMKDIR(cfg config.Config, limits Limits, flags byte, payload [
 	if d.Remaining() != 0 {
 		return proto.StatusBadRequest, nil, "extra bytes in MKDIR"
 	}
+	release, _ := s.lockPaths(limits, rootAbs, true, p)
+	defer release()
 
 	// Special case: when disk images are enabled and images are treated as directories,
 	// MKDIR on "foo.d64" should create an empty image file.
@@ -2444,10 +3490,6 @@ func (s *Server) opMKDIR(cfg config.Config, limits Limits, flags byte, payload [
 	}
 
 	if isImg {
-		// Creating a disk image is a write operation; serialize with other writes.
-		s.writeMu.Lock()
-		defer s.writeMu.Unlock()
-
 		// Ensure parent directory exists.
 		parent := filepath.Dir(abs)
 		if parents {
@@ -2465,7 +3507,7 @@ func (s *Server) opMKDIR(cfg config.Config, limits Limits, flags byte, payload [
 		}
 
 		label := diskImageLabelFromPath(p)
-		imgBytes, err := emptyDiskImageBytes(imgKind, label)
+		imgBytes, err := emptyDiskImageBytes(imgKind, label, "")
 		if err != nil {
 			return proto.StatusInternal, nil, err.Error()
 		}
@@ -2483,7 +3525,7 @@ func (s *Server) opMKDIR(cfg config.Config, limits Limits, flags byte, payload [
 			}
 			usedBefore = used
 			haveUsed = true
-			if usedBefore+newSize > limits.QuotaBytes {
+			if !s.fitQuota(cfg, limits, rootAbs, &usedBefore, newSize) {
 				return proto.StatusTooLarge, nil, "quota exceeded"
 			}
 		} else if s.usage != nil {
@@ -2518,7 +3560,7 @@ func (s *Server) opMKDIR(cfg config.Config, limits Limits, flags byte, payload [
 		_ = f.Sync()
 		ok = true
 		if haveUsed && s.usage != nil {
-			s.setRootUsageBytes(rootAbs, usedBefore+newSize)
+			s.adjustRootUsage(rootAbs, int64(newSize))
 		}
 		return proto.StatusOK, nil, ""
 	}
@@ -2545,8 +3587,8 @@ func (s *Server) opMKDIR(cfg config.Config, limits Limits, flags byte, payload [
 }
 
 func (s *Server) opRMDIR(cfg config.Config, limits Limits, flags byte, payload []byte, rootAbs string) (byte, []byte, string) {
-	s.writeMu.Lock()
-	defer s.writeMu.Unlock()
+	release, _ := s.lockWrite(cfg, limits, proto.OpRMDIR, payload, rootAbs, true)
+	defer release()
 
 	d := proto.NewDecoder(payload)
 
@@ -2592,7 +3634,7 @@ func (s *Server) opRMDIR(cfg config.Config, limits Limits, flags byte, payload [
 			if !limits.DiskImagesWriteEnabled {
 				return proto.StatusAccessDenied, nil, "disk images are read-only"
 			}
-			imgAbs, _, st, msg := resolveD81Mount(rootAbs, mountPath)
+			imgAbs, img, st, msg := resolveD81Mount(rootAbs, mountPath)
 			if st != proto.StatusOK {
 				return st, nil, msg
 			}
@@ -2600,6 +3642,17 @@ func (s *Server) opRMDIR(cfg config.Config, limits Limits, flags byte, payload [
 			if inner == "" {
 				return proto.StatusBadRequest, nil, "cannot remove image root"
 			}
+			if limits.dryRun != nil {
+				ents, _, _, _, st, msg := resolveD81Dir(img, inner)
+				if st != proto.StatusOK {
+					return st, nil, msg
+				}
+				if !recursive && len(ents) > 0 {
+					return proto.StatusDirNotEmpty, nil, "dir not empty"
+				}
+				limits.dryRun.skip(p)
+				return proto.StatusOK, nil, ""
+			}
 			if err := diskimage.RmdirDirD81(imgAbs, inner, recursive); err != nil {
 				var se *diskimage.StatusError
 				if errors.As(err, &se) {
@@ -2632,6 +3685,9 @@ func (s *Server) opRMDIR(cfg config.Config, limits Limits, flags byte, payload [
 		return proto.StatusNotFound, nil, "not found"
 	}
 	if isImg && !st.IsDir {
+		if limits.dryRun.skip(p) {
+			return proto.StatusOK, nil, ""
+		}
 		// Trash behavior: keep data under TrashDir instead of deleting permanently.
 		if shouldUseTrash(cfg, rootAbs, abs) {
 			if _, err := s.moveToTrash(cfg, rootAbs, abs); err != nil {
@@ -2658,6 +3714,24 @@ func (s *Server) opRMDIR(cfg config.Config, limits Limits, flags byte, payload [
 	if !st.IsDir {
 		return proto.StatusNotDir, nil, "not a dir"
 	}
+	if limits.dryRun != nil {
+		if recursive && !shouldUseTrash(cfg, rootAbs, abs) {
+			if err := fsops.CheckTree(abs, treeLimits(cfg)); err != nil {
+				st, msg := treeErrStatus(err)
+				return st, nil, msg
+			}
+		} else if !recursive {
+			ents, err := os.ReadDir(abs)
+			if err != nil {
+				return proto.StatusInternal, nil, err.Error()
+			}
+			if len(ents) > 0 {
+				return proto.StatusDirNotEmpty, nil, "dir not empty"
+			}
+		}
+		limits.dryRun.skip(p)
+		return proto.StatusOK, nil, ""
+	}
 
 	// Trash behavior: keep data under TrashDir instead of deleting permanently.
 	if shouldUseTrash(cfg, rootAbs, abs) {
@@ -2680,14 +3754,9 @@ func (s *Server) opRMDIR(cfg config.Config, limits Limits, flags byte, payload [
 	}
 
 	if recursive {
-		if err := os.RemoveAll(abs); err != nil {
-			if errors.Is(err, fs.ErrNotExist) {
-				return proto.StatusNotFound, nil, "not found"
-			}
-			if errors.Is(err, fs.ErrPermission) {
-				return proto.StatusAccessDenied, nil, "access denied"
-			}
-			return proto.StatusInternal, nil, err.Error()
+		if err := fsops.RemoveTree(abs, treeLimits(cfg)); err != nil {
+			st, msg := treeErrStatus(err)
+			return st, nil, msg
 		}
 		// Removing frees space, so invalidate quota usage cache.
 		s.invalidateRootUsage(rootAbs)
@@ -2710,8 +3779,8 @@ func (s *Server) opRMDIR(cfg config.Config, limits Limits, flags byte, payload [
 }
 
 func (s *Server) opRM(cfg config.Config, limits Limits, payload []byte, rootAbs string) (byte, []byte, string) {
-	s.writeMu.Lock()
-	defer s.writeMu.Unlock()
+	release, _ := s.lockWrite(cfg, limits, proto.OpRM, payload, rootAbs, true)
+	defer release()
 
 	d := proto.NewDecoder(payload)
 	p, err := s.readPathString(cfg, d)
@@ -2741,6 +3810,10 @@ func (s *Server) opRM(cfg config.Config, limits Limits, payload []byte, rootAbs
 		if strings.Contains(inner, "/") {
 			return proto.StatusNotSupported, nil, "D64 subdirectories are not supported"
 		}
+		if limits.dryRun != nil {
+			st, msg := limits.dryRun.imageFile(rootAbs, "d64", mountPath, inner, cfg.Compat.FallbackPRGExtension, p)
+			return st, nil, msg
+		}
 		if err := diskimage.DeleteFileD64(imgAbs, inner); err != nil {
 			var se *diskimage.StatusError
 			if errors.As(err, &se) {
@@ -2770,6 +3843,10 @@ func (s *Server) opRM(cfg config.Config, limits Limits, payload []byte, rootAbs
 		if st != proto.StatusOK {
 			return st, nil, msg
 		}
+		if limits.dryRun != nil {
+			st, msg := limits.dryRun.imageFile(rootAbs, "d71", mountPath, inner, cfg.Compat.FallbackPRGExtension, p)
+			return st, nil, msg
+		}
 		if err := diskimage.DeleteFileD71(imgAbs, inner); err != nil {
 			var se *diskimage.StatusError
 			if errors.As(err, &se) {
@@ -2796,6 +3873,10 @@ func (s *Server) opRM(cfg config.Config, limits Limits, payload []byte, rootAbs
 		if st != proto.StatusOK {
 			return st, nil, msg
 		}
+		if limits.dryRun != nil {
+			st, msg := limits.dryRun.imageFile(rootAbs, "d81", mountPath, inner, cfg.Compat.FallbackPRGExtension, p)
+			return st, nil, msg
+		}
 		if err := diskimage.DeleteFileD81(imgAbs, inner); err != nil {
 			var se *diskimage.StatusError
 			if errors.As(err, &se) {
@@ -2830,6 +3911,10 @@ func (s *Server) opRM(cfg config.Config, limits Limits, payload []byte, rootAbs
 		return proto.StatusIsDir, nil, "is a dir"
 	}
 
+	if limits.dryRun.skip(p) {
+		return proto.StatusOK, nil, ""
+	}
+
 	// Trash behavior: keep data under TrashDir instead of deleting permanently.
 	if shouldUseTrash(cfg, rootAbs, abs) {
 		if _, err := s.moveToTrash(cfg, rootAbs, abs); err != nil {
@@ -2853,18 +3938,13 @@ func (s *Server) opRM(cfg config.Config, limits Limits, payload []byte, rootAbs
 		return proto.StatusInternal, nil, err.Error()
 	}
 	// Update cached used bytes (if present).
-	if s.usage != nil {
-		usedBefore, err := s.rootUsageBytes(rootAbs)
-		if err == nil {
-			s.setRootUsageBytes(rootAbs, applyDeltaBytes(usedBefore, -int64(oldSize)))
-		}
-	}
+	s.adjustRootUsage(rootAbs, -int64(oldSize))
 	return proto.StatusOK, nil, ""
 }
 
 func (s *Server) opCP(cfg config.Config, limits Limits, flags byte, payload []byte, rootAbs string) (byte, []byte, string) {
-	s.writeMu.Lock()
-	defer s.writeMu.Unlock()
+	release, _ := s.lockWrite(cfg, limits, proto.OpCP, payload, rootAbs, true)
+	defer release()
 
 	overwrite := (flags & 0x01) != 0
 	recursive := (flags & 0x02) != 0
@@ -2890,6 +3970,11 @@ func (s *Server) opCP(cfg config.Config, limits Limits, flags byte, payload []by
 		return proto.StatusIsADir, nil, "source is a directory"
 	}
 
+	if flags&proto.FlagCP_CONVERT != 0 {
+		st, msg := s.cpConvertImage(cfg, limits, rootAbs, srcNorm, dstNorm, overwrite)
+		return st, nil, msg
+	}
+
 	// Disk images:
 	//   - allow extracting FROM a mounted image to the filesystem
 	//   - allow copying INTO a mounted image (write-enabled)
@@ -2962,10 +4047,7 @@ func (s *Server) opCP(cfg config.Config, limits Limits, flags byte, payload []by
 		// semantics if the *source* itself is a disk image file and the destination is
 		// the image root.
 		if dstMount, dstInner, ok := splitD64Path(dstNorm); ok {
-			srcBase := path.Base(srcNorm)
-			srcLooksLikeImage := strings.HasSuffix(strings.ToLower(srcBase), ".d64") ||
-				strings.HasSuffix(strings.ToLower(srcBase), ".d71") ||
-				strings.HasSuffix(strings.ToLower(srcBase), ".d81")
+			srcLooksLikeImage := isDiskImageName(path.Base(srcNorm))
 			if !(dstInner == "" && srcLooksLikeImage) {
 				if !limits.DiskImagesWriteEnabled {
 					return proto.StatusAccessDenied, nil, "disk images are read-only"
@@ -3002,9 +4084,7 @@ func (s *Server) opCP(cfg config.Config, limits Limits, flags byte, payload []by
 		}
 		// D71 destination: allow copy from filesystem into D71 (root only).
 		if dstMount, dstInner, ok := splitD71Path(dstNorm); ok {
-			srcBase := path.Base(srcNorm)
-			srcUpper := strings.ToUpper(srcBase)
-			srcLooksLikeImage := strings.HasSuffix(srcUpper, ".D64") || strings.HasSuffix(srcUpper, ".D71") || strings.HasSuffix(srcUpper, ".D81")
+			srcLooksLikeImage := isDiskImageName(path.Base(srcNorm))
 			// If both sides look like disk images and the destination points to the mount root,
 			// we assume the user wants to copy the image file itself (filesystem-level).
 			if !(dstInner == "" && srcLooksLikeImage) {
@@ -3048,9 +4128,7 @@ func (s *Server) opCP(cfg config.Config, limits Limits, flags byte, payload []by
 			// If the destination is the D81 mount root and the source looks like a
 			// disk image, assume the user wants to copy the image file itself, not
 			// write into the image.
-			srcBase := path.Base(srcNorm)
-			srcUpper := strings.ToUpper(srcBase)
-			srcLooksLikeImage := strings.HasSuffix(srcUpper, ".D64") || strings.HasSuffix(srcUpper, ".D71") || strings.HasSuffix(srcUpper, ".D81")
+			srcLooksLikeImage := isDiskImageName(path.Base(srcNorm))
 			if dstInner == "" && srcLooksLikeImage {
 				// Let filesystem copy logic handle it.
 			} else {
@@ -3141,7 +4219,14 @@ func (s *Server) opCP(cfg config.Config, limits Limits, flags byte, payload []by
 		}
 	}
 
-	srcTotal, srcMax, err := pathSizeBytes(srcAbs)
+	if srcSt.IsDir {
+		// Check the bounds before the destination is replaced.
+		if err := fsops.CheckTree(srcAbs, treeLimits(cfg)); err != nil {
+			st, msg := treeErrStatus(err)
+			return st, nil, msg
+		}
+	}
+	srcTotal, srcMax, err := pathSizeBytes(cfg, rootAbs, srcAbs)
 	if err != nil {
 		return proto.StatusInvalidPath, nil, err.Error()
 	}
@@ -3154,7 +4239,7 @@ func (s *Server) opCP(cfg config.Config, limits Limits, flags byte, payload []by
 
 	var dstOldTotal uint64
 	if dstSt.Exists && !trashOverwrite {
-		dstOldTotal, _, err = pathSizeBytes(dstAbs)
+		dstOldTotal, _, err = pathSizeBytes(cfg, rootAbs, dstAbs)
 		if err != nil {
 			return proto.StatusInternal, nil, err.Error()
 		}
@@ -3165,48 +4250,54 @@ func (s *Server) opCP(cfg config.Config, limits Limits, flags byte, payload []by
 		delta = int64(srcTotal) // old file stays in trash => usage increases by full src size
 	}
 
-	if delta > 0 && haveUsed && usedBefore+uint64(delta) > limits.QuotaBytes {
+	if delta > 0 && haveUsed && !s.fitQuota(cfg, limits, rootAbs, &usedBefore, uint64(delta)) {
 		return proto.StatusTooLarge, nil, "quota exceeded"
 	}
 
+	if dstSt.Exists && !overwrite {
+		return proto.StatusAccessDenied, nil, "destination exists"
+	}
+	if limits.dryRun.skipAbs(rootAbs, dstAbs) {
+		return proto.StatusOK, nil, ""
+	}
 	if dstSt.Exists {
-		if !overwrite {
-			return proto.StatusAccessDenied, nil, "destination exists"
-		}
 		if trashOverwrite {
 			if _, err := s.moveToTrash(cfg, rootAbs, dstAbs); err != nil {
 				return proto.StatusInternal, nil, err.Error()
 			}
 		} else {
-			if err := os.RemoveAll(dstAbs); err != nil {
-				return proto.StatusInternal, nil, err.Error()
+			if err := fsops.RemoveTree(dstAbs, treeLimits(cfg)); err != nil {
+				st, msg := treeErrStatus(err)
+				return st, nil, msg
 			}
 			s.invalidateRootUsage(rootAbs)
 		}
 	}
 
+	limits.progress.addTotal(srcTotal)
 	if srcSt.IsDir {
-		if err := fsops.CopyDirRecursive(srcAbs, dstAbs); err != nil {
+		if err := fsops.CopyDirRecursiveProgress(srcAbs, dstAbs, treeLimits(cfg), limits.progress.progressFunc()); err != nil {
 			s.invalidateRootUsage(rootAbs)
-			return proto.StatusInternal, nil, err.Error()
+			st, msg := treeErrStatus(err)
+			return st, nil, msg
 		}
 	} else {
-		if err := fsops.CopyFile(srcAbs, dstAbs); err != nil {
+		if err := fsops.CopyFileProgress(srcAbs, dstAbs, limits.progress.progressFunc()); err != nil {
 			s.invalidateRootUsage(rootAbs)
 			return proto.StatusInternal, nil, err.Error()
 		}
 	}
 
 	if haveUsed && s.usage != nil {
-		s.setRootUsageBytes(rootAbs, applyDeltaBytes(usedBefore, delta))
+		s.adjustRootUsage(rootAbs, delta)
 	}
 
 	return proto.StatusOK, nil, ""
 }
 
 func (s *Server) opMV(cfg config.Config, limits Limits, flags byte, payload []byte, rootAbs string) (byte, []byte, string) {
-	s.writeMu.Lock()
-	defer s.writeMu.Unlock()
+	release, _ := s.lockWrite(cfg, limits, proto.OpMV, payload, rootAbs, true)
+	defer release()
 
 	d := proto.NewDecoder(payload)
 
@@ -3251,6 +4342,10 @@ func (s *Server) opMV(cfg config.Config, limits Limits, flags byte, payload []by
 				return proto.StatusNotSupported, nil, "D64 subdirectories are not supported"
 			}
 
+			if limits.dryRun != nil {
+				st, msg := limits.dryRun.imageMove(rootAbs, "d64", srcMount, srcInner, dstInner, allowOverwrite, cfg.Compat.FallbackPRGExtension, dst)
+				return st, nil, msg
+			}
 			if err := diskimage.RenameFileD64(imgAbs, srcInner, dstInner, allowOverwrite); err != nil {
 				var se *diskimage.StatusError
 				if errors.As(err, &se) {
@@ -3295,6 +4390,10 @@ func (s *Server) opMV(cfg config.Config, limits Limits, flags byte, payload []by
 				return proto.StatusNotSupported, nil, "D71 subdirectories are not supported"
 			}
 
+			if limits.dryRun != nil {
+				st, msg := limits.dryRun.imageMove(rootAbs, "d71", srcMount, srcInner, dstInner, allowOverwrite, cfg.Compat.FallbackPRGExtension, dst)
+				return st, nil, msg
+			}
 			if err := diskimage.RenameFileD71(imgAbs, srcInner, dstInner, allowOverwrite); err != nil {
 				var se *diskimage.StatusError
 				if errors.As(err, &se) {
@@ -3343,6 +4442,10 @@ func (s *Server) opMV(cfg config.Config, limits Limits, flags byte, payload []by
 			if allowOverwrite && !cfg.EnableOverwrite {
 				return proto.StatusNotSupported, nil, "overwrite disabled"
 			}
+			if limits.dryRun != nil {
+				st, msg := limits.dryRun.imageMove(rootAbs, "d81", srcMount, srcInner, dstInner, allowOverwrite, cfg.Compat.FallbackPRGExtension, dst)
+				return st, nil, msg
+			}
 			if err := diskimage.RenameFileD81(imgAbs, srcInner, dstInner, allowOverwrite); err != nil {
 				// If the source is a directory/partition, try directory rename.
 				var se *diskimage.StatusError
@@ -3465,6 +4568,12 @@ func (s *Server) opMV(cfg config.Config, limits Limits, flags byte, payload []by
 		}
 	}
 
+	// A dry run cannot tell whether the rename would need the copy + delete
+	// fallback; it stops here.
+	if limits.dryRun.skipAbs(rootAbs, dstAbs) {
+		return proto.StatusOK, nil, ""
+	}
+
 	// If we overwrite existing destination, remove (or trash) it first.
 	if dstSt.Exists && overwrite {
 		if shouldUseTrash(cfg, rootAbs, dstAbs) {
@@ -3473,8 +4582,9 @@ func (s *Server) opMV(cfg config.Config, limits Limits, flags byte, payload []by
 			}
 		} else {
 			if dstSt.IsDir {
-				if err := os.RemoveAll(dstAbs); err != nil {
-					return proto.StatusInternal, nil, err.Error()
+				if err := fsops.RemoveTree(dstAbs, treeLimits(cfg)); err != nil {
+					st, msg := treeErrStatus(err)
+					return st, nil, msg
 				}
 			} else {
 				if err := os.Remove(dstAbs); err != nil {
@@ -3501,7 +4611,7 @@ func (s *Server) opMV(cfg config.Config, limits Limits, flags byte, payload []by
 
 	var srcTotal, srcMax uint64
 	if limits.MaxFileBytes > 0 || limits.QuotaBytes > 0 {
-		srcTotal, srcMax, err = pathSizeBytes(srcAbs)
+		srcTotal, srcMax, err = pathSizeBytes(cfg, rootAbs, srcAbs)
 		if err != nil {
 			return proto.StatusInternal, nil, err.Error()
 		}
@@ -3510,28 +4620,27 @@ func (s *Server) opMV(cfg config.Config, limits Limits, flags byte, payload []by
 		}
 	}
 
-	var usedBefore uint64
-	var haveUsed bool
 	if limits.QuotaBytes > 0 {
-		usedBefore, err = s.rootUsageBytes(rootAbs)
+		usedBefore, err := s.rootUsageBytes(rootAbs)
 		if err != nil {
 			return proto.StatusInternal, nil, err.Error()
 		}
-		haveUsed = true
 		// Peak usage while copying: source still exists, plus the full copy.
-		if usedBefore+srcTotal > limits.QuotaBytes {
+		if !s.fitQuota(cfg, limits, rootAbs, &usedBefore, srcTotal) {
 			return proto.StatusTooLarge, nil, "quota exceeded"
 		}
 	}
 
 	if srcSt.IsDir {
-		if err := fsops.CopyDirRecursive(srcAbs, dstAbs); err != nil {
+		if err := fsops.CopyDirRecursive(srcAbs, dstAbs, treeLimits(cfg)); err != nil {
 			s.invalidateRootUsage(rootAbs)
-			return proto.StatusInternal, nil, err.Error()
+			st, msg := treeErrStatus(err)
+			return st, nil, msg
 		}
-		if err := os.RemoveAll(srcAbs); err != nil {
+		if err := fsops.RemoveTree(srcAbs, treeLimits(cfg)); err != nil {
 			s.invalidateRootUsage(rootAbs)
-			return proto.StatusInternal, nil, err.Error()
+			st, msg := treeErrStatus(err)
+			return st, nil, msg
 		}
 	} else {
 		if err := fsops.CopyFile(srcAbs, dstAbs); err != nil {
@@ -3544,11 +4653,7 @@ func (s *Server) opMV(cfg config.Config, limits Limits, flags byte, payload []by
 		}
 	}
 
-	if haveUsed && s.usage != nil {
-		// After move fallback, total usage remains the same as before the copy.
-		s.setRootUsageBytes(rootAbs, usedBefore)
-	}
-
+	// After the move fallback, total usage is the same as before the copy.
 	return proto.StatusOK, nil, ""
 }
 
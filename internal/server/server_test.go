@@ -0,0 +1,239 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+// newTestServer returns a server with its base path (the no-auth token
+// root) in a temp dir and discovery off, after edit adjusted the defaults.
+// It is shut down when the test ends.
+func newTestServer(t *testing.T, edit func(*config.Config)) (*Server, config.Config, string) {
+	t.Helper()
+	cfg := config.Default()
+	cfg.BasePath = t.TempDir()
+	cfg.Discovery.Enabled = false
+	if edit != nil {
+		edit(&cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	rootAbs, err := filepath.Abs(cfg.BasePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(cfg, "")
+	t.Cleanup(func() { _ = s.Shutdown(context.Background()) })
+	return s, cfg, rootAbs
+}
+
+// writeRangePayload encodes a WRITE_RANGE request: path, offset u32,
+// data_len u16, data.
+func writeRangePayload(t *testing.T, path string, offset uint32, data []byte) []byte {
+	t.Helper()
+	e := proto.NewEncoder(64 + len(data))
+	if err := e.WriteString(path); err != nil {
+		t.Fatal(err)
+	}
+	e.WriteU32(offset)
+	e.WriteU16(uint16(len(data)))
+	e.WriteBytes(data)
+	return e.Bytes()
+}
+
+func TestTokenWindowError(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	tests := []struct {
+		name        string
+		from, until int64
+		want        string
+	}{
+		{"no window", 0, 0, ""},
+		{"inside the window", now.Unix() - 60, now.Unix() + 60, ""},
+		{"last valid second", 0, now.Unix(), ""},
+		{"first valid second", now.Unix(), 0, ""},
+		{"expired", 0, now.Unix() - 1, "token expired at 2023-11-14T22:13:19Z"},
+		{"not yet valid", now.Unix() + 1, 0, "token not valid before 2023-11-14T22:13:21Z"},
+		{"not yet valid wins over expired", now.Unix() + 1, now.Unix() - 1, "token not valid before 2023-11-14T22:13:21Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := config.TokenContext{ValidFromUnix: tt.from, ValidUntilUnix: tt.until}
+			if got := tokenWindowError(ctx, now); got != tt.want {
+				t.Fatalf("tokenWindowError = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathPrefixSharedRoot(t *testing.T) {
+	s, cfg, base := newTestServer(t, func(c *config.Config) {
+		c.Tokens = []config.TokenEntry{
+			{Token: "A", PathPrefix: "/USERA"},
+			{Token: "B", PathPrefix: "/USERB"},
+		}
+	})
+	for _, dir := range []string{"USERA", "USERB"} {
+		if err := os.Mkdir(filepath.Join(base, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	as := func(token string) string {
+		ctx, ok := cfg.ResolveTokenContext(token)
+		if !ok {
+			t.Fatalf("token %s rejected", token)
+		}
+		return ctx.Root
+	}
+	rootA, rootB := as("A"), as("B")
+
+	if st, _, msg := s.dispatch(cfg, Limits{}, proto.OpWRITE_RANGE, proto.FlagWR_CREATE, writeRangePayload(t, "/F", 0, []byte("a")), rootA); st != proto.StatusOK {
+		t.Fatalf("WRITE_RANGE as A = %s (%s)", statusName(st), msg)
+	}
+	if b, err := os.ReadFile(filepath.Join(base, "USERA", "F")); err != nil || string(b) != "a" {
+		t.Fatalf("A's file is not in its prefix: %q, %v", b, err)
+	}
+	for _, p := range []string{"/F", "/../USERA/F", "/../../USERA/F", "/..", "/../USERA"} {
+		if st, resp, _ := s.dispatch(cfg, Limits{}, proto.OpSTAT, 0, pathPayload(p), rootB); st == proto.StatusOK {
+			t.Errorf("STAT %s as B = OK % X, want an error", p, resp)
+		}
+	}
+}
+
+func TestSTATFSQuota(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	const quota = 5 << 20
+	limits := Limits{QuotaBytes: quota}
+	// Present before the first usage scan.
+	if err := os.WriteFile(filepath.Join(rootAbs, "OLD"), make([]byte, 1000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want := func(used uint32) {
+		t.Helper()
+		got := statfs(t, s, cfg, limits, rootAbs, "/")
+		if w := [3]uint32{quota, quota - used, used}; got != w {
+			t.Fatalf("STATFS = %v, want %v", got, w)
+		}
+	}
+	want(1000)
+
+	if st, _, msg := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, proto.FlagWR_CREATE, writeRangePayload(t, "/NEW", 0, make([]byte, 3000)), rootAbs); st != proto.StatusOK {
+		t.Fatalf("WRITE_RANGE = %s (%s)", statusName(st), msg)
+	}
+	want(4000)
+	// Growing a file and deleting one update the cached usage.
+	if st, _, msg := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, 0, writeRangePayload(t, "/OLD", 1000, make([]byte, 500)), rootAbs); st != proto.StatusOK {
+		t.Fatalf("WRITE_RANGE = %s (%s)", statusName(st), msg)
+	}
+	want(4500)
+	if st, _, msg := s.dispatch(cfg, limits, proto.OpRM, 0, pathPayload("/NEW"), rootAbs); st != proto.StatusOK {
+		t.Fatalf("RM = %s (%s)", statusName(st), msg)
+	}
+	want(1500)
+
+	// Over the quota free is 0, not a wrapped u32.
+	small := Limits{QuotaBytes: 1000}
+	if got := statfs(t, s, cfg, small, rootAbs, "/"); got != [3]uint32{1000, 0, 1500} {
+		t.Fatalf("STATFS over quota = %v, want [1000 0 1500]", got)
+	}
+
+	// CAPS tells the client which numbers STATFS reports.
+	for _, tc := range []struct {
+		limits Limits
+		want   bool
+	}{{limits, true}, {Limits{}, false}} {
+		_, resp, _ := s.dispatch(cfg, tc.limits, proto.OpCAPS, 0, nil, rootAbs)
+		if got := capsFeatureBits(t, resp)&proto.FeatSTATFS_QUOTA != 0; got != tc.want {
+			t.Errorf("CAPS STATFS_QUOTA with quota %d = %v, want %v", tc.limits.QuotaBytes, got, tc.want)
+		}
+	}
+}
+
+func TestWRITE_RANGEWantSize(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	limits := Limits{DiskImagesEnabled: true, DiskImagesWriteEnabled: true}
+	sizeResp := func(resp []byte) (uint32, uint32) {
+		t.Helper()
+		d := proto.NewDecoder(resp)
+		newSize, err1 := d.ReadU32()
+		written, err2 := d.ReadU32()
+		if err1 != nil || err2 != nil || d.Remaining() != 0 {
+			t.Fatalf("WANT_SIZE response % x", resp)
+		}
+		return newSize, written
+	}
+
+	_, resp, _ := s.dispatch(cfg, limits, proto.OpCAPS, 0, nil, rootAbs)
+	if capsFeatureBits(t, resp)&proto.FeatWRITE_SIZE == 0 {
+		t.Fatal("CAPS does not advertise WRITE_SIZE")
+	}
+
+	// Without the flag the legacy empty payload is kept.
+	st, resp, msg := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, proto.FlagWR_CREATE, writeRangePayload(t, "/F", 0, []byte("0123")), rootAbs)
+	if st != proto.StatusOK || len(resp) != 0 {
+		t.Fatalf("legacy WRITE_RANGE = %s (%s), resp % x", statusName(st), msg, resp)
+	}
+
+	cases := []struct {
+		name        string
+		flags       byte
+		off         uint32
+		data        string
+		size, wrote uint32
+	}{
+		{"append", proto.FlagWR_WANT_SIZE, 4, "456789", 10, 6},
+		{"overwrite inside", proto.FlagWR_WANT_SIZE | proto.FlagWR_OVERWRITE, 2, "ab", 10, 2},
+		{"overwrite past end", proto.FlagWR_WANT_SIZE | proto.FlagWR_OVERWRITE, 8, "xyz", 11, 3},
+		{"truncate", proto.FlagWR_WANT_SIZE | proto.FlagWR_TRUNCATE | proto.FlagWR_OVERWRITE, 0, "Q", 1, 1},
+	}
+	for _, tc := range cases {
+		st, resp, msg := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, tc.flags, writeRangePayload(t, "/F", tc.off, []byte(tc.data)), rootAbs)
+		if st != proto.StatusOK {
+			t.Fatalf("%s: WRITE_RANGE = %s (%s)", tc.name, statusName(st), msg)
+		}
+		if size, wrote := sizeResp(resp); size != tc.size || wrote != tc.wrote {
+			t.Errorf("%s: new_size %d written %d, want %d %d", tc.name, size, wrote, tc.size, tc.wrote)
+		}
+		if fi, err := os.Stat(filepath.Join(rootAbs, "F")); err != nil || uint32(fi.Size()) != tc.size {
+			t.Errorf("%s: file on disk is %v (%v), want %d bytes", tc.name, fi.Size(), err, tc.size)
+		}
+	}
+
+	// A refused write answers with no payload.
+	st, resp, _ = s.dispatch(cfg, limits, proto.OpWRITE_RANGE, proto.FlagWR_WANT_SIZE, writeRangePayload(t, "/MISSING", 0, []byte("x")), rootAbs)
+	if st != proto.StatusNotFound || len(resp) != 0 {
+		t.Errorf("WRITE_RANGE to a missing file = %s, resp % x", statusName(st), resp)
+	}
+
+	// Inside disk images the legacy payload is written u32; with the flag it
+	// grows to match the host-file answer.
+	for _, img := range []struct {
+		path string
+		kind byte
+	}{
+		{"/W.D64", proto.ImageKindD64},
+		{"/W.D71", proto.ImageKindD71},
+		{"/W.D81", proto.ImageKindD81},
+	} {
+		mkImage(t, s, cfg, limits, rootAbs, img.path, img.kind)
+		st, resp, msg := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, proto.FlagWR_CREATE, writeRangePayload(t, img.path+"/P", 0, make([]byte, 300)), rootAbs)
+		if st != proto.StatusOK || len(resp) != 4 || binary.LittleEndian.Uint32(resp) != 300 {
+			t.Fatalf("%s: legacy WRITE_RANGE = %s (%s), resp % x", img.path, statusName(st), msg, resp)
+		}
+		st, resp, msg = s.dispatch(cfg, limits, proto.OpWRITE_RANGE, proto.FlagWR_WANT_SIZE, writeRangePayload(t, img.path+"/P", 300, make([]byte, 200)), rootAbs)
+		if st != proto.StatusOK {
+			t.Fatalf("%s: WRITE_RANGE = %s (%s)", img.path, statusName(st), msg)
+		}
+		if size, wrote := sizeResp(resp); size != 500 || wrote != 200 {
+			t.Errorf("%s: new_size %d written %d, want 500 200", img.path, size, wrote)
+		}
+	}
+}
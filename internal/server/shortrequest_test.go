@@ -0,0 +1,76 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+func TestDebugShortRequest(t *testing.T) {
+	short := []byte{'W', '6', '4'}
+	badMagic := []byte("XXXX\x01\x01\x00\x00\x00\x00")
+
+	post := func(s *Server, body []byte) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.handleRPC(w, httptest.NewRequest("POST", s.cfgSnapshot().Endpoint, bytes.NewReader(body)))
+		return w
+	}
+	lastInfo := func(s *Server) string {
+		entries := s.logs.snapshot(1)
+		if len(entries) != 1 {
+			t.Fatalf("%d log entries, want 1", len(entries))
+		}
+		return entries[0].Info
+	}
+
+	// Default: a 3-byte body gets a bare HTTP 400 without a W64F frame.
+	s, _, _ := newTestServer(t, nil)
+	if w := post(s, short); w.Code != http.StatusBadRequest || w.Body.Len() != 0 {
+		t.Fatalf("strict 3-byte body = %d % x, want a bare 400", w.Code, w.Body.Bytes())
+	}
+
+	// debug_short_request: a BAD_REQUEST frame with op_echo 0xFF whose
+	// message is sent even with enable_errmsg off.
+	s, _, _ = newTestServer(t, func(c *config.Config) {
+		c.DebugShortRequest = true
+		c.EnableErrMsg = false
+	})
+	for _, tc := range []struct {
+		name, msg, info string
+		body            []byte
+	}{
+		{"3-byte body", "request too short: 3 bytes", "len=3 hex=573634", short},
+		{"bad magic", `bad magic "XXXX"`, "len=10 hex=58585858", badMagic},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := post(s, tc.body)
+			resp := w.Body.Bytes()
+			if w.Code != http.StatusOK || len(resp) < proto.HeaderSize || string(resp[:4]) != proto.Magic {
+				t.Fatalf("response = %d % x, want a W64F frame", w.Code, resp)
+			}
+			if resp[5] != 0xFF || resp[6] != proto.StatusBadRequest {
+				t.Fatalf("op_echo %#x status %s, want 0xff BAD_REQUEST", resp[5], statusName(resp[6]))
+			}
+			if msg := string(resp[proto.HeaderSize:]); !strings.Contains(msg, tc.msg) {
+				t.Fatalf("errmsg = %q, want %q", msg, tc.msg)
+			}
+			if info := lastInfo(s); !strings.Contains(info, tc.info) {
+				t.Fatalf("log info = %q, want %q", info, tc.info)
+			}
+		})
+	}
+
+	// The hex dump is capped.
+	long := append([]byte("XXXX"), make([]byte, 2*debugBodyMax)...)
+	post(s, long)
+	want := fmt.Sprintf("len=%d hex=%x...", len(long), long[:debugBodyMax])
+	if info := lastInfo(s); !strings.HasSuffix(info, want) {
+		t.Fatalf("log info for %d bytes = %q, want the first %d in hex", len(long), info, debugBodyMax)
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// shutdownState stops the background goroutines (maintenance, usage cache
+// saver, config watcher, discovery) when the process exits.
+type shutdownState struct {
+	once sync.Once
+	stop chan struct{} // closed by Shutdown
+
+	reqOnce sync.Once
+	req     chan struct{} // closed by requestShutdown (admin UI / tray)
+
+	mu    sync.Mutex
+	conns []*net.UDPConn // discovery sockets, closed by Shutdown
+}
+
+func newShutdownState() shutdownState {
+	return shutdownState{stop: make(chan struct{}), req: make(chan struct{})}
+}
+
+// sleep waits for d and reports false if the server is shutting down
+// instead; background loops return then.
+func (s *Server) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-s.down.stop:
+		return false
+	}
+}
+
+func (s *Server) stopping() bool {
+	select {
+	case <-s.down.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// addDiscoveryConn registers a discovery socket for Shutdown.
+func (s *Server) addDiscoveryConn(c *net.UDPConn) {
+	s.down.mu.Lock()
+	defer s.down.mu.Unlock()
+	s.down.conns = append(s.down.conns, c)
+}
+
+// ShutdownRequested is closed when the admin UI (POST /admin/api/shutdown)
+// asks the process to exit. The caller then shuts down like on SIGTERM.
+func (s *Server) ShutdownRequested() <-chan struct{} {
+	return s.down.req
+}
+
+func (s *Server) requestShutdown() {
+	s.down.reqOnce.Do(func() { close(s.down.req) })
+}
+
+// Shutdown stops the background loops and the discovery responder, waits
+// for running async ops (CP FlagCP_ASYNC) and saves the usage cache and
+// audit logs. Call it after http.Server.Shutdown has drained the in-flight
+// requests: their writes (including disk image rewrites, which hold the
+// path lock of the image) have finished by then. It returns ctx.Err() if
+// async ops were still running when ctx ended.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.down.once.Do(func() { close(s.down.stop) })
+	s.down.mu.Lock()
+	for _, c := range s.down.conns {
+		_ = c.Close()
+	}
+	s.down.conns = nil
+	s.down.mu.Unlock()
+
+	var err error
+wait:
+	for s.asyncOps.running() > 0 {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			log.Printf("shutdown: %d async op(s) still running", s.asyncOps.running())
+			break wait
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	cfg := s.getCfg()
+	if serr := s.saveUsageCache(cfg); serr != nil {
+		log.Printf("usage cache: %s: %v", cfg.UsageCachePath, serr)
+	}
+	s.audit.flush(2 * time.Second)
+	s.tokenAudit.flushAll(2 * time.Second)
+	return err
+}
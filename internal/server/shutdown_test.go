@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+func TestShutdownDrainsWrite(t *testing.T) {
+	cachePath := filepath.Join(t.TempDir(), "usage.json")
+	s, cfg, rootAbs := newTestServer(t, func(c *config.Config) {
+		c.UsageCachePath = cachePath
+		c.Tokens = []config.TokenEntry{{Token: "T", QuotaBytes: 1 << 20}}
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	active := make(chan struct{})
+	hs := &http.Server{Handler: s.HTTPHandler(), ConnState: func(_ net.Conn, st http.ConnState) {
+		if st == http.StateActive {
+			close(active)
+		}
+	}}
+	go func() { _ = hs.Serve(ln) }()
+
+	data := bytes.Repeat([]byte("w64"), 300)
+	req := w64fRequest(proto.OpWRITE_RANGE, proto.FlagWR_CREATE, writeRangePayload(t, "/F", 0, data))
+
+	// A slow client: the header and half of the body, the rest after the
+	// shutdown has started.
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "POST %s?token=T HTTP/1.1\r\nHost: x\r\nContent-Type: application/octet-stream\r\nContent-Length: %d\r\n\r\n", cfg.Endpoint, len(req))
+	half := len(req) / 2
+	if _, err := conn.Write(req[:half]); err != nil {
+		t.Fatal(err)
+	}
+	<-active
+
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done <- hs.Shutdown(ctx)
+	}()
+	// The listener stops accepting at once.
+	for i := 0; ; i++ {
+		c, err := net.DialTimeout("tcp", ln.Addr().String(), 100*time.Millisecond)
+		if err != nil {
+			break
+		}
+		c.Close()
+		if i == 100 {
+			t.Fatal("listener still accepts after Shutdown")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned %v with a request in flight", err)
+	default:
+	}
+
+	if _, err := conn.Write(req[half:]); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body bytes.Buffer
+	_, _ = body.ReadFrom(resp.Body)
+	resp.Body.Close()
+	if b := body.Bytes(); len(b) < proto.HeaderSize || b[6] != proto.StatusOK {
+		t.Fatalf("in-flight WRITE_RANGE answer = % X", b)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Shutdown = %v", err)
+	}
+	if got, err := os.ReadFile(filepath.Join(rootAbs, "F")); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("file after the drained write: %d bytes, %v", len(got), err)
+	}
+
+	// Server.Shutdown stops the background loops and saves the usage cache.
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !s.stopping() || s.sleep(time.Hour) {
+		t.Fatal("background loops keep running after Shutdown")
+	}
+	b, err := os.ReadFile(cachePath)
+	if err != nil {
+		t.Fatalf("usage cache after Shutdown: %v", err)
+	}
+	var saved usageCacheFile
+	if err := json.Unmarshal(b, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := saved.Roots[rootAbs]; !ok || e.UsedBytes != uint64(len(data)) {
+		t.Fatalf("usage cache after Shutdown = %s, want %d bytes for the root", b, len(data))
+	}
+}
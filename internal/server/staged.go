@@ -0,0 +1,237 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/fsops"
+	"wicos64-server/internal/proto"
+)
+
+// stagedWrites tracks the open staged writes (WRITE_RANGE FlagWR_STAGED) by
+// the pathLockKey of their target. Chunks go into a hidden copy next to the
+// target; FlagWR_COMMIT renames it into place. Copies that get no chunk for
+// staged_write_timeout_sec are deleted by the maintenance loop.
+type stagedWrites struct {
+	mu sync.Mutex
+	m  map[string]*stagedWrite
+}
+
+type stagedWrite struct {
+	tmp     string // staging copy (same directory as the target)
+	rootAbs string
+	size    uint64
+	touched time.Time
+}
+
+func (sw *stagedWrites) get(key string) *stagedWrite {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.m[key]
+}
+
+func (sw *stagedWrites) set(key string, w *stagedWrite) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.m == nil {
+		sw.m = map[string]*stagedWrite{}
+	}
+	sw.m[key] = w
+}
+
+func (sw *stagedWrites) remove(key string) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	delete(sw.m, key)
+}
+
+func (sw *stagedWrites) touch(w *stagedWrite) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	w.touched = time.Now()
+}
+
+// expired returns the keys of staged writes idle since before cutoff.
+func (sw *stagedWrites) expired(cutoff time.Time) []string {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	var keys []string
+	for k, w := range sw.m {
+		if w.touched.Before(cutoff) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// discardStaged deletes the staging copy of w. Its bytes counted against the
+// quota, so the usage shrinks by its size.
+func (s *Server) discardStaged(key string, w *stagedWrite) {
+	s.staged.remove(key)
+	if err := os.Remove(w.tmp); err != nil {
+		s.invalidateRootUsage(w.rootAbs)
+		return
+	}
+	s.adjustRootUsage(w.rootAbs, -int64(w.size))
+}
+
+// writeStagedRange is writeHostFileRange for FlagWR_STAGED. The overwrite
+// rules are checked against the target when the staged write starts (offset
+// 0, TRUNCATE); later chunks extend the staging copy, which counts against
+// max_file_bytes and the quota like the file itself. It returns the size of
+// the staging copy (the new file size once committed).
+func (s *Server) writeStagedRange(cfg config.Config, limits Limits, flags byte, rootAbs, p string, offset uint32, data []byte) (byte, uint64, string) {
+	abs, err := fsops.ToOSPath(rootAbs, p)
+	if err != nil {
+		return proto.StatusInvalidPath, 0, err.Error()
+	}
+	if err := fsops.LstatNoSymlink(rootAbs, abs, true); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return proto.StatusNotFound, 0, "not found"
+		}
+		return proto.StatusInvalidPath, 0, err.Error()
+	}
+	st, err := fsops.Stat(abs)
+	if err != nil {
+		return proto.StatusInternal, 0, err.Error()
+	}
+	if st.IsDir {
+		return proto.StatusIsADir, 0, "is a directory"
+	}
+
+	key := pathLockKey(abs)
+	w := s.staged.get(key)
+	if offset == 0 {
+		if flags&proto.FlagWR_TRUNCATE == 0 {
+			return proto.StatusBadRequest, 0, "staged write must start with TRUNCATE"
+		}
+		if !st.Exists {
+			if flags&proto.FlagWR_CREATE == 0 {
+				return proto.StatusNotFound, 0, "not found"
+			}
+			pst, err := fsops.Stat(filepath.Dir(abs))
+			if err != nil {
+				return proto.StatusInternal, 0, err.Error()
+			}
+			if !pst.Exists || !pst.IsDir {
+				return proto.StatusNotFound, 0, "parent directory missing"
+			}
+		} else if st.Size > 0 {
+			if !cfg.EnableOverwrite {
+				return proto.StatusAccessDenied, 0, "overwrite disabled by server"
+			}
+			if flags&proto.FlagWR_OVERWRITE == 0 {
+				return proto.StatusAccessDenied, 0, "overwrite requires OVERWRITE flag"
+			}
+		}
+		// Restarting a staged write drops the previous copy.
+		if w != nil {
+			s.discardStaged(key, w)
+		}
+		tmp, err := os.CreateTemp(filepath.Dir(abs), ".wicos64-staged-*")
+		if err != nil {
+			if errors.Is(err, fs.ErrPermission) {
+				return proto.StatusAccessDenied, 0, "access denied"
+			}
+			return proto.StatusInternal, 0, err.Error()
+		}
+		_ = tmp.Close()
+		w = &stagedWrite{tmp: tmp.Name(), rootAbs: rootAbs}
+		s.staged.set(key, w)
+	} else {
+		if w == nil {
+			return proto.StatusRangeInvalid, 0, "no staged write in progress"
+		}
+		if uint64(offset) > w.size {
+			return proto.StatusRangeInvalid, 0, "no sparse writes"
+		}
+	}
+	s.staged.touch(w)
+
+	newSize := w.size
+	if end := uint64(offset) + uint64(len(data)); end > newSize {
+		newSize = end
+	}
+	if limits.MaxFileBytes > 0 && newSize > limits.MaxFileBytes {
+		return proto.StatusTooLarge, 0, "max file size exceeded"
+	}
+	delta := int64(newSize - w.size)
+	if limits.QuotaBytes > 0 && delta > 0 {
+		used, err := s.rootUsageBytes(rootAbs)
+		if err != nil {
+			return proto.StatusInternal, 0, err.Error()
+		}
+		if !s.fitQuota(cfg, limits, rootAbs, &used, uint64(delta)) {
+			return proto.StatusTooLarge, 0, "quota exceeded"
+		}
+	}
+
+	f, err := os.OpenFile(w.tmp, os.O_WRONLY, 0o644)
+	if err != nil {
+		// The copy is gone (e.g. deleted by hand); the client has to restart.
+		s.staged.remove(key)
+		s.invalidateRootUsage(rootAbs)
+		return proto.StatusRangeInvalid, 0, "staged write lost, restart at offset 0"
+	}
+	defer f.Close()
+	if _, err := f.Seek(int64(offset), io.SeekStart); err != nil {
+		s.invalidateRootUsage(rootAbs)
+		return proto.StatusRangeInvalid, 0, err.Error()
+	}
+	if _, err := f.Write(data); err != nil {
+		s.invalidateRootUsage(rootAbs)
+		return proto.StatusInternal, 0, err.Error()
+	}
+	s.adjustRootUsage(rootAbs, delta)
+	w.size = newSize
+
+	if flags&proto.FlagWR_COMMIT == 0 {
+		return proto.StatusOK, newSize, ""
+	}
+	if err := f.Sync(); err != nil {
+		return proto.StatusInternal, 0, err.Error()
+	}
+	_ = f.Close()
+	_ = os.Chmod(w.tmp, 0o644)
+	if err := os.Rename(w.tmp, abs); err != nil {
+		s.invalidateRootUsage(rootAbs)
+		return proto.StatusInternal, 0, err.Error()
+	}
+	s.staged.remove(key)
+	if st.Exists {
+		s.adjustRootUsage(rootAbs, -int64(st.Size))
+	}
+	return proto.StatusOK, newSize, "committed"
+}
+
+// runStagedCleanupOnce deletes the staging copies of abandoned staged writes.
+// Writes whose target is locked right now are left for the next run.
+func (s *Server) runStagedCleanupOnce(cfg config.Config) maintRun {
+	start := time.Now()
+	run := maintRun{AtUnix: start.Unix()}
+	roots := map[string]bool{}
+	cutoff := start.Add(-time.Duration(cfg.StagedWriteTimeoutSec) * time.Second)
+	for _, key := range s.staged.expired(cutoff) {
+		release, ok := s.paths.lock([]string{key}, false)
+		if !ok {
+			continue
+		}
+		if w := s.staged.get(key); w != nil && w.touched.Before(cutoff) {
+			roots[w.rootAbs] = true
+			s.discardStaged(key, w)
+			run.DeletedFiles++
+			run.FreedBytes += w.size
+		}
+		release()
+	}
+	run.Roots = len(roots)
+	run.DurationMs = time.Since(start).Milliseconds()
+	s.maint.record(maintStagedCleanup, run)
+	return run
+}
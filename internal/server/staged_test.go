@@ -0,0 +1,175 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wicos64-server/internal/proto"
+)
+
+// stagedCopies returns the staging files in dir.
+func stagedCopies(t *testing.T, dir string) []string {
+	t.Helper()
+	m, err := filepath.Glob(filepath.Join(dir, ".wicos64-staged-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestStagedWriteCommit(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	limits := Limits{QuotaBytes: 100}
+	writeFiles(t, rootAbs, map[string]string{"F.SEQ": "OLD CONTENT"})
+	target := filepath.Join(rootAbs, "F.SEQ")
+	usage := func() uint64 {
+		t.Helper()
+		used, err := s.rootUsageBytes(rootAbs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return used
+	}
+	write := func(flags byte, off uint32, data string) (byte, string) {
+		t.Helper()
+		st, _, msg := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, flags|proto.FlagWR_STAGED, writeRangePayload(t, "/F.SEQ", off, []byte(data)), rootAbs)
+		return st, msg
+	}
+	if usage() != 11 {
+		t.Fatalf("usage = %d", usage())
+	}
+
+	if st, msg := write(proto.FlagWR_TRUNCATE|proto.FlagWR_OVERWRITE, 0, "new "); st != proto.StatusOK {
+		t.Fatalf("first staged chunk = %s (%s)", statusName(st), msg)
+	}
+	if st, msg := write(0, 4, "content!"); st != proto.StatusOK {
+		t.Fatalf("second staged chunk = %s (%s)", statusName(st), msg)
+	}
+	// Until the commit the file keeps its old content; the copy counts.
+	if b, _ := os.ReadFile(target); string(b) != "OLD CONTENT" {
+		t.Fatalf("target before commit = %q", b)
+	}
+	if n := len(stagedCopies(t, rootAbs)); n != 1 {
+		t.Fatalf("%d staging copies, want 1", n)
+	}
+	if usage() != 11+12 {
+		t.Fatalf("usage with a staged copy = %d, want 23", usage())
+	}
+
+	// An empty chunk with COMMIT swaps the copy in.
+	st, resp, msg := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, proto.FlagWR_STAGED|proto.FlagWR_COMMIT|proto.FlagWR_WANT_SIZE, writeRangePayload(t, "/F.SEQ", 12, nil), rootAbs)
+	if st != proto.StatusOK || len(resp) != 8 || resp[0] != 12 {
+		t.Fatalf("commit = %s (%s) % x", statusName(st), msg, resp)
+	}
+	if b, _ := os.ReadFile(target); string(b) != "new content!" {
+		t.Fatalf("target after commit = %q", b)
+	}
+	if n := len(stagedCopies(t, rootAbs)); n != 0 {
+		t.Fatalf("%d staging copies left after the commit", n)
+	}
+	if usage() != 12 {
+		t.Fatalf("usage after commit = %d, want 12", usage())
+	}
+	if st, _ := write(0, 12, "more"); st != proto.StatusRangeInvalid {
+		t.Fatalf("chunk after the commit = %s, want RANGE_INVALID", statusName(st))
+	}
+
+	// The staged copy is checked against the quota: 12 + 89 > 100.
+	if st, msg := write(proto.FlagWR_TRUNCATE|proto.FlagWR_OVERWRITE, 0, string(make([]byte, 89))); st != proto.StatusTooLarge {
+		t.Fatalf("staged chunk over the quota = %s (%s)", statusName(st), msg)
+	}
+	// Restarting at offset 0 replaces the previous copy.
+	for i := 0; i < 2; i++ {
+		if st, msg := write(proto.FlagWR_TRUNCATE|proto.FlagWR_OVERWRITE, 0, "abc"); st != proto.StatusOK {
+			t.Fatalf("staged restart = %s (%s)", statusName(st), msg)
+		}
+	}
+	if n := len(stagedCopies(t, rootAbs)); n != 1 || usage() != 12+3 {
+		t.Fatalf("after a restart: %d copies, usage %d", n, usage())
+	}
+
+	// A new file is created on commit only.
+	if st, _, msg := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, proto.FlagWR_STAGED|proto.FlagWR_CREATE|proto.FlagWR_TRUNCATE|proto.FlagWR_COMMIT, writeRangePayload(t, "/NEW", 0, []byte("one shot")), rootAbs); st != proto.StatusOK {
+		t.Fatalf("one-chunk staged create = %s (%s)", statusName(st), msg)
+	}
+	if b, _ := os.ReadFile(filepath.Join(rootAbs, "NEW")); string(b) != "one shot" {
+		t.Fatalf("/NEW = %q", b)
+	}
+
+	refused := []struct {
+		name  string
+		flags byte
+		path  string
+		off   uint32
+		want  byte
+	}{
+		{"COMMIT without STAGED", proto.FlagWR_COMMIT, "/F.SEQ", 0, proto.StatusBadRequest},
+		{"start without TRUNCATE", proto.FlagWR_STAGED | proto.FlagWR_OVERWRITE, "/F.SEQ", 0, proto.StatusBadRequest},
+		{"replace without OVERWRITE", proto.FlagWR_STAGED | proto.FlagWR_TRUNCATE, "/F.SEQ", 0, proto.StatusAccessDenied},
+		{"sparse chunk", proto.FlagWR_STAGED, "/F.SEQ", 10, proto.StatusRangeInvalid},
+		{"no staged write", proto.FlagWR_STAGED, "/NEW", 8, proto.StatusRangeInvalid},
+		{"missing without CREATE", proto.FlagWR_STAGED | proto.FlagWR_TRUNCATE, "/MISSING", 0, proto.StatusNotFound},
+		{"directory", proto.FlagWR_STAGED | proto.FlagWR_TRUNCATE, "/", 0, proto.StatusIsADir},
+	}
+	for _, tc := range refused {
+		if st, _, msg := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, tc.flags, writeRangePayload(t, tc.path, tc.off, []byte("x")), rootAbs); st != tc.want {
+			t.Errorf("%s: WRITE_RANGE = %s (%s), want %s", tc.name, statusName(st), msg, statusName(tc.want))
+		}
+	}
+	imgLimits := Limits{DiskImagesEnabled: true, DiskImagesWriteEnabled: true}
+	mkImage(t, s, cfg, imgLimits, rootAbs, "/D.D64", proto.ImageKindD64)
+	if st, _, _ := s.dispatch(cfg, imgLimits, proto.OpWRITE_RANGE, proto.FlagWR_STAGED|proto.FlagWR_CREATE|proto.FlagWR_TRUNCATE, writeRangePayload(t, "/D.D64/P", 0, []byte("x")), rootAbs); st != proto.StatusNotSupported {
+		t.Errorf("staged write inside an image = %s, want NOT_SUPPORTED", statusName(st))
+	}
+}
+
+func TestStagedWriteAbandoned(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	limits := Limits{QuotaBytes: 1000}
+	writeFiles(t, rootAbs, map[string]string{"A": "keep a", "B": "keep b"})
+	if _, err := s.rootUsageBytes(rootAbs); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"/A", "/B"} {
+		if st, _, msg := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, proto.FlagWR_STAGED|proto.FlagWR_TRUNCATE|proto.FlagWR_OVERWRITE, writeRangePayload(t, p, 0, make([]byte, 100)), rootAbs); st != proto.StatusOK {
+			t.Fatalf("staged %s = %s (%s)", p, statusName(st), msg)
+		}
+	}
+	if used, _ := s.rootUsageBytes(rootAbs); used != 12+200 {
+		t.Fatalf("usage = %d, want 212", used)
+	}
+
+	// Only /A has been idle for longer than the timeout.
+	keyA := pathLockKey(filepath.Join(rootAbs, "A"))
+	s.staged.mu.Lock()
+	s.staged.m[keyA].touched = time.Now().Add(-time.Duration(cfg.StagedWriteTimeoutSec+1) * time.Second)
+	s.staged.mu.Unlock()
+	run := s.runStagedCleanupOnce(cfg)
+	if run.DeletedFiles != 1 || run.FreedBytes != 100 || run.Roots != 1 {
+		t.Fatalf("cleanup run = %+v", run)
+	}
+	if n := len(stagedCopies(t, rootAbs)); n != 1 {
+		t.Fatalf("%d staging copies left, want the one of /B", n)
+	}
+	if used, _ := s.rootUsageBytes(rootAbs); used != 12+100 {
+		t.Fatalf("usage after cleanup = %d, want 112", used)
+	}
+	if b, _ := os.ReadFile(filepath.Join(rootAbs, "A")); string(b) != "keep a" {
+		t.Fatalf("/A = %q after its staged write was abandoned", b)
+	}
+	// The client that comes back has to start over.
+	if st, _, _ := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, proto.FlagWR_STAGED|proto.FlagWR_COMMIT, writeRangePayload(t, "/A", 100, nil), rootAbs); st != proto.StatusRangeInvalid {
+		t.Fatalf("commit of an abandoned write = %s, want RANGE_INVALID", statusName(st))
+	}
+	if st, _, msg := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, proto.FlagWR_STAGED|proto.FlagWR_COMMIT, writeRangePayload(t, "/B", 100, nil), rootAbs); st != proto.StatusOK {
+		t.Fatalf("commit of /B = %s (%s)", statusName(st), msg)
+	}
+	if fi, err := os.Stat(filepath.Join(rootAbs, "B")); err != nil || fi.Size() != 100 {
+		t.Fatalf("/B after commit: %v", err)
+	}
+	if _, n, _ := s.maint.get(maintStagedCleanup); n < 1 {
+		t.Error("staged cleanup run not recorded")
+	}
+}
@@ -26,8 +26,13 @@ type StatsSnapshot struct {
 	AvgMs       uint64            `json:"avg_ms"`
 	ByOp        map[string]uint64 `json:"by_op"`
 	Recent      []StatsPoint      `json:"recent"`
+
+	ImageParsesInFlight int `json:"image_parses_in_flight"`
 }
 
+// statsDurBucketsMs are the upper bounds of the request duration histogram.
+var statsDurBucketsMs = [...]int64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000}
+
 // statsHub keeps lightweight counters for an admin dashboard.
 //
 // It is intentionally simple and dependency-free.
@@ -45,6 +50,9 @@ type statsHub struct {
 
 	byOp [256]uint64
 
+	// request duration histogram (statsDurBucketsMs, last slot = +Inf)
+	durBuckets [len(statsDurBucketsMs) + 1]uint64
+
 	// per-minute ring (last 60 minutes)
 	curMin  int64
 	idx     int
@@ -78,6 +86,7 @@ func (h *statsHub) reset() {
 	h.bytesOut = 0
 	h.totalDurMs = 0
 	h.byOp = [256]uint64{}
+	h.durBuckets = [len(statsDurBucketsMs) + 1]uint64{}
 
 	h.curMin = m
 	h.idx = 0
@@ -130,6 +139,11 @@ func (h *statsHub) add(op byte, status byte, reqBytes, respBytes int, durMs int6
 	if durMs > 0 {
 		h.totalDurMs += uint64(durMs)
 	}
+	b := 0
+	for b < len(statsDurBucketsMs) && durMs > statsDurBucketsMs[b] {
+		b++
+	}
+	h.durBuckets[b]++
 }
 
 func (h *statsHub) snapshot() StatsSnapshot {
@@ -183,3 +197,30 @@ func (h *statsHub) snapshot() StatsSnapshot {
 		Recent:      recent,
 	}
 }
+
+// statsMetrics is a consistent copy of the totals for /metrics.
+type statsMetrics struct {
+	uptime     time.Duration
+	totalReq   uint64
+	totalErr   uint64
+	bytesIn    uint64
+	bytesOut   uint64
+	totalDurMs uint64
+	byOp       [256]uint64
+	durBuckets [len(statsDurBucketsMs) + 1]uint64
+}
+
+func (h *statsHub) metrics() statsMetrics {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return statsMetrics{
+		uptime:     time.Since(h.started),
+		totalReq:   h.totalReq,
+		totalErr:   h.totalErr,
+		bytesIn:    h.bytesIn,
+		bytesOut:   h.bytesOut,
+		totalDurMs: h.totalDurMs,
+		byOp:       h.byOp,
+		durBuckets: h.durBuckets,
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"wicos64-server/internal/config"
+)
+
+// tokenAuditEntry is one line of a per-token audit file (config
+// audit_log_dir). Args is the request summary (paths, offsets, lengths);
+// file contents are never logged.
+type tokenAuditEntry struct {
+	TimeUnixMs int64  `json:"ts_unix_ms"`
+	TokenID    string `json:"token_id"`
+	TokenName  string `json:"token_name,omitempty"`
+	Op         string `json:"op"`
+	Args       string `json:"args,omitempty"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+}
+
+// tokenAuditLogs holds one audit writer per token. Writers are created on
+// first use and live for the lifetime of the process; when audit_log_dir is
+// cleared they just close their file.
+type tokenAuditLogs struct {
+	mu   sync.Mutex
+	logs map[string]*auditLog // key: token id or "noauth"
+}
+
+func tokenAuditKey(id string) string {
+	if id == "" {
+		return "noauth"
+	}
+	return id
+}
+
+func tokenAuditSettings(cfg config.Config, key string) auditSettings {
+	st := auditSettings{maxBytes: cfg.AuditLogMaxBytes, keep: cfg.AuditLogKeep}
+	if cfg.AuditLogDir != "" {
+		st.path = filepath.Join(cfg.AuditLogDir, key+".jsonl")
+	}
+	return st
+}
+
+func (t *tokenAuditLogs) get(cfg config.Config, key string) *auditLog {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a := t.logs[key]
+	if a == nil {
+		if t.logs == nil {
+			t.logs = map[string]*auditLog{}
+		}
+		a = newAuditLog(tokenAuditSettings(cfg, key))
+		t.logs[key] = a
+	}
+	return a
+}
+
+func (t *tokenAuditLogs) configure(cfg config.Config) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, a := range t.logs {
+		a.configure(tokenAuditSettings(cfg, key))
+	}
+}
+
+func (t *tokenAuditLogs) reopenAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, a := range t.logs {
+		a.requestReopen()
+	}
+}
+
+// flushAll flushes all writers concurrently, each bounded by timeout.
+func (t *tokenAuditLogs) flushAll(timeout time.Duration) {
+	t.mu.Lock()
+	logs := make([]*auditLog, 0, len(t.logs))
+	for _, a := range t.logs {
+		logs = append(logs, a)
+	}
+	t.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, a := range logs {
+		wg.Add(1)
+		go func(a *auditLog) {
+			defer wg.Done()
+			a.flush(timeout)
+		}(a)
+	}
+	wg.Wait()
+}
+
+// auditTokenOp appends a finished mutating op to the token's audit file.
+func (s *Server) auditTokenOp(cfg config.Config, limits Limits, op, flags byte, payload []byte, status byte, errMsg string) {
+	s.auditToken(cfg, limits, opName(op), summarizeRequest(cfg, op, flags, payload), status, errMsg)
+}
+
+func (s *Server) auditToken(cfg config.Config, limits Limits, op, args string, status byte, errMsg string) {
+	b, err := json.Marshal(tokenAuditEntry{
+		TimeUnixMs: time.Now().UnixMilli(),
+		TokenID:    limits.tokenID,
+		TokenName:  limits.tokenName,
+		Op:         op,
+		Args:       args,
+		Status:     statusName(status),
+		Error:      errMsg,
+	})
+	if err != nil {
+		return
+	}
+	s.tokenAudit.get(cfg, tokenAuditKey(limits.tokenID)).writeLine(b)
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
@@ -13,6 +14,7 @@ import (
 )
 
 // shouldUseTrash returns true if trash is enabled and the target path is eligible.
+// trash_exclude_patterns/trash_only_patterns select per path (matchW64Pattern).
 //
 // We purposely do NOT trash anything inside the trash dir itself or inside .TMP.
 // That allows users/admins to permanently delete items by deleting from trash,
@@ -31,7 +33,66 @@ func shouldUseTrash(cfg config.Config, rootAbs, targetAbs string) bool {
 	if isTopLevelDir(rootAbs, targetAbs, ".TMP") {
 		return false
 	}
-	return true
+	if len(cfg.TrashExcludePatterns) == 0 && len(cfg.TrashOnlyPatterns) == 0 {
+		return true
+	}
+	rel, err := filepath.Rel(rootAbs, targetAbs)
+	if err != nil {
+		return true
+	}
+	w64 := "/" + filepath.ToSlash(rel)
+	for _, pat := range cfg.TrashExcludePatterns {
+		if matchW64Pattern(pat, w64) {
+			return false
+		}
+	}
+	if len(cfg.TrashOnlyPatterns) == 0 {
+		return true
+	}
+	for _, pat := range cfg.TrashOnlyPatterns {
+		if matchW64Pattern(pat, w64) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchW64Pattern matches a W64 path (e.g. "/USR/A.TMP") against a trash
+// pattern, case-insensitively. A pattern without "/" matches the last path
+// element; otherwise the whole path is matched segment by segment, where a
+// "**" segment matches zero or more segments.
+func matchW64Pattern(pattern, p string) bool {
+	pattern = strings.ToUpper(strings.TrimSpace(pattern))
+	p = strings.ToUpper(strings.Trim(p, "/"))
+	if pattern == "" {
+		return false
+	}
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base("/"+p))
+		return ok
+	}
+	return matchSegments(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(p, "/"))
+}
+
+func matchSegments(pat, segs []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for i := 0; i <= len(segs); i++ {
+				if matchSegments(pat[1:], segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], segs[0]); !ok {
+			return false
+		}
+		pat, segs = pat[1:], segs[1:]
+	}
+	return len(segs) == 0
 }
 
 func isTopLevelDir(rootAbs, targetAbs, dir string) bool {
@@ -89,7 +150,53 @@ func (s *Server) moveToTrash(cfg config.Config, rootAbs, targetAbs string) (stri
 			}
 			return "", err
 		}
+		// Remember what was trashed: <id>/<rel> alone cannot tell a trashed
+		// directory from a file inside it (see trashEntries).
+		_ = os.WriteFile(filepath.Join(base, id+trashPathExt), []byte("/"+filepath.ToSlash(rel)), 0o644)
 		return dstAbs, nil
 	}
 	return "", fmt.Errorf("failed to move to trash: too many name collisions")
 }
+
+// trashBytes returns the size of all trash entries of rootAbs.
+func trashBytes(cfg config.Config, rootAbs string) uint64 {
+	entries, err := trashEntries(cfg, rootAbs)
+	if err != nil {
+		return 0
+	}
+	var total uint64
+	for _, e := range entries {
+		total += e.Size
+	}
+	return total
+}
+
+// evictTrash permanently deletes the oldest trash entries of rootAbs until
+// at least need bytes are freed (trash_evict_on_quota). Nothing is deleted
+// if the whole trash is smaller than need. Returns the bytes freed.
+func (s *Server) evictTrash(cfg config.Config, rootAbs string, need uint64) uint64 {
+	entries, err := trashEntries(cfg, rootAbs)
+	if err != nil {
+		return 0
+	}
+	var total uint64
+	for _, e := range entries {
+		total += e.Size
+	}
+	if total < need {
+		return 0
+	}
+	base := trashDirAbs(cfg, rootAbs)
+	var freed uint64
+	for _, e := range entries {
+		if freed >= need {
+			break
+		}
+		if err := os.RemoveAll(filepath.Join(base, e.ID)); err != nil {
+			break
+		}
+		_ = os.Remove(filepath.Join(base, e.ID+trashPathExt))
+		freed += e.Size
+	}
+	return freed
+}
@@ -5,8 +5,13 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/diskimage"
+	"wicos64-server/internal/fsops"
 )
 
 type usageEntry struct {
@@ -22,13 +27,41 @@ type usageCache struct {
 	mu  sync.Mutex
 	ttl time.Duration
 	m   map[string]usageEntry
+	// files caches the per-root entry count (files + directories) for max_files.
+	files map[string]countEntry
+	// images caches the logical size of disk images (quota_logical_image_usage).
+	images map[string]imageUsageEntry
+
+	// last keeps the latest byte count per root past the TTL, for
+	// usage_cache_path. Entries loaded from that file are marked restored.
+	last  map[string]lastUsage
+	dirty bool
+}
+
+type lastUsage struct {
+	bytes    uint64
+	at       time.Time
+	restored bool
+	// rescanning is set while a restored entry is being revalidated.
+	rescanning bool
+}
+
+type imageUsageEntry struct {
+	size  int64
+	mtime time.Time
+	bytes uint64
+}
+
+type countEntry struct {
+	n  uint64
+	at time.Time
 }
 
 func newUsageCache(ttl time.Duration) *usageCache {
 	if ttl <= 0 {
 		ttl = 3 * time.Second
 	}
-	return &usageCache{ttl: ttl, m: make(map[string]usageEntry)}
+	return &usageCache{ttl: ttl, m: make(map[string]usageEntry), files: make(map[string]countEntry), images: make(map[string]imageUsageEntry), last: make(map[string]lastUsage)}
 }
 
 func (c *usageCache) getFresh(rootAbs string) (uint64, bool) {
@@ -47,13 +80,113 @@ func (c *usageCache) getFresh(rootAbs string) (uint64, bool) {
 
 func (c *usageCache) set(rootAbs string, bytes uint64) {
 	c.mu.Lock()
-	c.m[rootAbs] = usageEntry{bytes: bytes, at: time.Now()}
+	now := time.Now()
+	c.m[rootAbs] = usageEntry{bytes: bytes, at: now}
+	c.last[rootAbs] = lastUsage{bytes: bytes, at: now}
+	c.dirty = true
+	c.mu.Unlock()
+}
+
+// adjust applies delta to a fresh byte count of rootAbs in one step, so
+// concurrent writers cannot lose each other's updates. touch renews the
+// entry's age like set does.
+func (c *usageCache) adjust(rootAbs string, delta int64, touch bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if l, ok := c.last[rootAbs]; ok {
+		l.bytes = applyDeltaBytes(l.bytes, delta)
+		c.last[rootAbs] = l
+		c.dirty = true
+	}
+	e, ok := c.m[rootAbs]
+	if !ok || (c.ttl > 0 && time.Since(e.at) > c.ttl) {
+		return
+	}
+	e.bytes = applyDeltaBytes(e.bytes, delta)
+	if touch {
+		e.at = time.Now()
+	}
+	c.m[rootAbs] = e
+}
+
+// invalidateContaining drops the byte counts of all roots that contain absPath.
+func (c *usageCache) invalidateContaining(absPath string) {
+	c.mu.Lock()
+	for root := range c.m {
+		if absPath == root || strings.HasPrefix(absPath, root+string(filepath.Separator)) {
+			delete(c.m, root)
+		}
+	}
+	for root := range c.last {
+		if absPath == root || strings.HasPrefix(absPath, root+string(filepath.Separator)) {
+			delete(c.last, root)
+			c.dirty = true
+		}
+	}
+	c.mu.Unlock()
+}
+
+func (c *usageCache) getImage(abs string, fi fs.FileInfo) (uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.images[abs]
+	if !ok || e.size != fi.Size() || !e.mtime.Equal(fi.ModTime()) {
+		return 0, false
+	}
+	return e.bytes, true
+}
+
+func (c *usageCache) setImage(abs string, fi fs.FileInfo, bytes uint64) {
+	c.mu.Lock()
+	c.images[abs] = imageUsageEntry{size: fi.Size(), mtime: fi.ModTime(), bytes: bytes}
 	c.mu.Unlock()
 }
 
 func (c *usageCache) invalidate(rootAbs string) {
 	c.mu.Lock()
 	delete(c.m, rootAbs)
+	delete(c.files, rootAbs)
+	if _, ok := c.last[rootAbs]; ok {
+		delete(c.last, rootAbs)
+		c.dirty = true
+	}
+	c.mu.Unlock()
+}
+
+func (c *usageCache) getFreshFiles(rootAbs string) (uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.files[rootAbs]
+	if !ok {
+		return 0, false
+	}
+	if c.ttl > 0 && time.Since(e.at) > c.ttl {
+		delete(c.files, rootAbs)
+		return 0, false
+	}
+	return e.n, true
+}
+
+func (c *usageCache) setFiles(rootAbs string, n uint64) {
+	c.mu.Lock()
+	c.files[rootAbs] = countEntry{n: n, at: time.Now()}
+	c.mu.Unlock()
+}
+
+// addFiles adds n to a fresh entry count of rootAbs.
+func (c *usageCache) addFiles(rootAbs string, n uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.files[rootAbs]
+	if !ok || (c.ttl > 0 && time.Since(e.at) > c.ttl) {
+		return
+	}
+	c.files[rootAbs] = countEntry{n: e.n + n, at: time.Now()}
+}
+
+func (c *usageCache) invalidateFiles(rootAbs string) {
+	c.mu.Lock()
+	delete(c.files, rootAbs)
 	c.mu.Unlock()
 }
 
@@ -63,8 +196,26 @@ func (s *Server) getOrScanRootUsage(rootAbs string) (uint64, error) {
 		if b, ok := s.usage.getFresh(rootAbs); ok {
 			return b, nil
 		}
+		if b, ok, rescan := s.usage.getRestored(rootAbs); ok {
+			if rescan {
+				go s.rescanRestoredUsage(rootAbs)
+			}
+			return b, nil
+		}
+	}
+	return s.scanRootUsage(rootAbs)
+}
+
+// scanRootUsage walks rootAbs and caches the result.
+func (s *Server) scanRootUsage(rootAbs string) (uint64, error) {
+	var used uint64
+	var err error
+	cfg := s.cfgSnapshot()
+	if cfg.QuotaLogicalImageUsage {
+		used, err = s.logicalTreeSize(cfg, rootAbs)
+	} else {
+		used, _, err = pathSizeBytes(cfg, rootAbs, rootAbs)
 	}
-	used, _, err := pathSizeBytes(rootAbs)
 	if err != nil {
 		return 0, err
 	}
@@ -74,10 +225,17 @@ func (s *Server) getOrScanRootUsage(rootAbs string) (uint64, error) {
 	return used, nil
 }
 
-func (s *Server) setRootUsage(rootAbs string, used uint64) {
-	if s.usage != nil {
-		s.usage.set(rootAbs, used)
+// adjustRootUsage applies delta to the cached usage of rootAbs, if any.
+// Without a fresh entry nothing is done; the next read rescans anyway.
+// Writes to different paths run concurrently, so ops report their change as
+// a delta instead of storing an absolute value computed from an earlier read.
+func (s *Server) adjustRootUsage(rootAbs string, delta int64) {
+	if s.usage == nil {
+		return
 	}
+	// Deltas are raw byte counts, which are only an estimate for images
+	// (quota_logical_image_usage): keep the entry's age then.
+	s.usage.adjust(rootAbs, delta, !s.cfgSnapshot().QuotaLogicalImageUsage)
 }
 
 func (s *Server) invalidateRootUsage(rootAbs string) {
@@ -86,18 +244,72 @@ func (s *Server) invalidateRootUsage(rootAbs string) {
 	}
 }
 
+// quotaFull reports whether a readonly_when_full token has reached its quota.
+// Usage is read from the cache, so the state flips back as soon as deletes
+// bring the root below the quota again.
+func (s *Server) quotaFull(limits Limits, rootAbs string) bool {
+	if !limits.ReadOnlyWhenFull || limits.QuotaBytes == 0 {
+		return false
+	}
+	used, err := s.rootUsageBytes(rootAbs)
+	if err != nil {
+		return false
+	}
+	return used >= limits.QuotaBytes
+}
+
+// lockQuota serializes the grow ops of a root with a byte or file quota.
+// Path locks let writers to different files run in parallel, so without it
+// two of them could both pass the quota (or max_files) check and together
+// exceed it; under the lock the check and the write are atomic. Roots
+// without a quota are not locked, and with limits.quotaLocked (a BATCH or
+// dispatch already holds the lock) it is a no-op. The returned limits are
+// marked quotaLocked.
+//
+// The quota lock is always taken before any path lock.
+func (s *Server) lockQuota(limits Limits, rootAbs string) (release func(), _ Limits) {
+	if limits.quotaLocked || (limits.QuotaBytes == 0 && limits.MaxFiles == 0) {
+		return func() {}, limits
+	}
+	release, _ = s.quota.lock([]string{pathLockKey(rootAbs)}, true)
+	limits.quotaLocked = true
+	return release, limits
+}
+
+// fitQuota reports whether need more bytes fit the quota on top of *used.
+// With trash_evict_on_quota it first purges the oldest trash entries if
+// that makes them fit; *used is then the usage after the purge.
+func (s *Server) fitQuota(cfg config.Config, limits Limits, rootAbs string, used *uint64, need uint64) bool {
+	if *used+need <= limits.QuotaBytes {
+		return true
+	}
+	if !cfg.TrashEnabled || !cfg.TrashEvictOnQuota {
+		return false
+	}
+	if limits.dryRun != nil {
+		// Nothing is evicted on a dry run; it fits if eviction could make room.
+		return *used+need-limits.QuotaBytes <= trashBytes(cfg, rootAbs)
+	}
+	if s.evictTrash(cfg, rootAbs, *used+need-limits.QuotaBytes) == 0 {
+		return false
+	}
+	// Trash sizes are raw bytes (quota_logical_image_usage counts images
+	// differently): rescan instead of subtracting.
+	s.invalidateRootUsage(rootAbs)
+	u, err := s.rootUsageBytes(rootAbs)
+	if err != nil {
+		return false
+	}
+	*used = u
+	return u+need <= limits.QuotaBytes
+}
+
 // rootUsageBytes returns the current used bytes under rootAbs.
 // It is a thin wrapper kept for backwards compatibility with earlier refactors.
 func (s *Server) rootUsageBytes(rootAbs string) (uint64, error) {
 	return s.getOrScanRootUsage(rootAbs)
 }
 
-// setRootUsageBytes updates the cached used bytes for rootAbs.
-// It is a thin wrapper kept for backwards compatibility with earlier refactors.
-func (s *Server) setRootUsageBytes(rootAbs string, bytes uint64) {
-	s.setRootUsage(rootAbs, bytes)
-}
-
 // applyDeltaBytes applies a signed delta to an unsigned used-byte counter.
 func applyDeltaBytes(used uint64, delta int64) uint64 {
 	if delta >= 0 {
@@ -110,19 +322,23 @@ func applyDeltaBytes(used uint64, delta int64) uint64 {
 	return used - d
 }
 
-// pathSizeBytes returns (totalBytes, maxFileBytes) for absPath.
-// It sums regular file sizes; directories themselves do not contribute.
-// Symlinks are rejected.
-func pathSizeBytes(absPath string) (uint64, uint64, error) {
+// pathSizeBytes returns (totalBytes, maxFileBytes) for absPath in the token
+// root rootAbs. It sums regular file sizes; directories themselves do not
+// contribute. Symlinks whitelisted by allow_symlink_targets count as 0 (see
+// usageSkipSymlink), other symlinks are rejected.
+func pathSizeBytes(cfg config.Config, rootAbs, absPath string) (uint64, uint64, error) {
 	fi, err := os.Lstat(absPath)
 	if err != nil {
 		return 0, 0, err
 	}
 	if fi.Mode()&os.ModeSymlink != 0 {
-		return 0, 0, fmt.Errorf("symlink not allowed")
+		if err := usageSkipSymlink(cfg, rootAbs, absPath); err != nil {
+			return 0, 0, err
+		}
+		return 0, 0, nil
 	}
 	if fi.IsDir() {
-		return dirTreeSize(absPath)
+		return dirTreeSize(cfg, rootAbs, absPath)
 	}
 	if fi.Mode().IsRegular() {
 		sz := uint64(fi.Size())
@@ -131,7 +347,7 @@ func pathSizeBytes(absPath string) (uint64, uint64, error) {
 	return 0, 0, fmt.Errorf("unsupported file type")
 }
 
-func dirTreeSize(dir string) (uint64, uint64, error) {
+func dirTreeSize(cfg config.Config, rootAbs, dir string) (uint64, uint64, error) {
 	var total uint64
 	var maxFile uint64
 
@@ -143,9 +359,9 @@ func dirTreeSize(dir string) (uint64, uint64, error) {
 		if p == dir {
 			return nil
 		}
-		// Reject symlinks.
+		// Skip whitelisted symlinks, reject all others.
 		if d.Type()&os.ModeSymlink != 0 {
-			return fmt.Errorf("symlink not allowed")
+			return usageSkipSymlink(cfg, rootAbs, p)
 		}
 		if d.IsDir() {
 			return nil
@@ -169,3 +385,69 @@ func dirTreeSize(dir string) (uint64, uint64, error) {
 	}
 	return total, maxFile, nil
 }
+
+// logicalTreeSize is like dirTreeSize but counts disk images by the blocks
+// allocated in their BAM (256 bytes each) rather than by their file size.
+// Images that cannot be parsed are counted by file size.
+func (s *Server) logicalTreeSize(cfg config.Config, dir string) (uint64, error) {
+	var total uint64
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == dir {
+			return nil
+		}
+		if d.Type()&os.ModeSymlink != 0 {
+			return usageSkipSymlink(cfg, dir, p)
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("unsupported file type")
+		}
+		name := d.Name()
+		if isD64Segment(name) || isD71Segment(name) || isD81Segment(name) {
+			total += s.imageLogicalBytes(p, info)
+			return nil
+		}
+		total += uint64(info.Size())
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// usageSkipSymlink decides how the usage walks treat the symlink at p: nil
+// (skip it) when allow_symlink_targets whitelists it, an error otherwise. A
+// whitelisted link is never followed, so its target is not counted (again)
+// against the token's quota.
+func usageSkipSymlink(cfg config.Config, rootAbs, p string) error {
+	if fsops.SymlinkAllowed(rootAbs, p, cfg.AllowSymlinkTargets) {
+		return nil
+	}
+	return fmt.Errorf("symlink not allowed")
+}
+
+func (s *Server) imageLogicalBytes(abs string, info fs.FileInfo) uint64 {
+	if s.usage != nil {
+		if b, ok := s.usage.getImage(abs, info); ok {
+			return b
+		}
+	}
+	b := uint64(info.Size())
+	if used, err := diskimage.UsedBlocks(abs); err == nil {
+		b = uint64(used) * 256
+	}
+	if s.usage != nil {
+		s.usage.setImage(abs, info, b)
+	}
+	return b
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"wicos64-server/internal/config"
+)
+
+// usage_cache_path: the last known byte count of each root is saved to a
+// small JSON file and loaded again on startup. A restored value answers the
+// first quota check of its root right away and triggers a rescan in the
+// background; invalidation drops it like any other cache entry. Everything
+// here is best-effort: a missing or broken file just means a cold cache.
+
+const usageCacheSaveInterval = time.Minute
+
+type usageCacheFile struct {
+	Version int `json:"version"`
+	// LogicalImageUsage records quota_logical_image_usage: byte counts from
+	// the other mode are not reused.
+	LogicalImageUsage bool                           `json:"logical_image_usage"`
+	Roots             map[string]usageCacheFileEntry `json:"roots"`
+}
+
+type usageCacheFileEntry struct {
+	UsedBytes uint64 `json:"used_bytes"`
+	AtUnix    int64  `json:"at_unix"`
+}
+
+// getRestored returns a value loaded from usage_cache_path that has not been
+// replaced by a scan yet. rescan is true for the first caller, which is
+// expected to revalidate it.
+func (c *usageCache) getRestored(rootAbs string) (bytes uint64, ok, rescan bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, ok := c.last[rootAbs]
+	if !ok || !l.restored {
+		return 0, false, false
+	}
+	rescan = !l.rescanning
+	l.rescanning = true
+	c.last[rootAbs] = l
+	return l.bytes, true, rescan
+}
+
+// dropRestored forgets a restored value (its rescan failed).
+func (c *usageCache) dropRestored(rootAbs string) {
+	c.mu.Lock()
+	if l, ok := c.last[rootAbs]; ok && l.restored {
+		delete(c.last, rootAbs)
+		c.dirty = true
+	}
+	c.mu.Unlock()
+}
+
+// restore loads saved entries; roots that already have a value are kept.
+func (c *usageCache) restore(f *usageCacheFile) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for root, e := range f.Roots {
+		if _, ok := c.last[root]; ok {
+			continue
+		}
+		c.last[root] = lastUsage{bytes: e.UsedBytes, at: time.Unix(e.AtUnix, 0), restored: true}
+	}
+}
+
+// snapshot returns the entries to save, or nil if nothing changed since the
+// last snapshot.
+func (c *usageCache) snapshot() map[string]usageCacheFileEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	c.dirty = false
+	out := make(map[string]usageCacheFileEntry, len(c.last))
+	for root, l := range c.last {
+		out[root] = usageCacheFileEntry{UsedBytes: l.bytes, AtUnix: l.at.Unix()}
+	}
+	return out
+}
+
+func (s *Server) rescanRestoredUsage(rootAbs string) {
+	if _, err := s.scanRootUsage(rootAbs); err != nil {
+		s.usage.dropRestored(rootAbs)
+	}
+}
+
+// loadUsageCache fills the usage cache from cfg.UsageCachePath, if set.
+func (s *Server) loadUsageCache(cfg config.Config) {
+	if cfg.UsageCachePath == "" || s.usage == nil {
+		return
+	}
+	b, err := os.ReadFile(cfg.UsageCachePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("usage cache: %v", err)
+		}
+		return
+	}
+	var f usageCacheFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		log.Printf("usage cache: %s: %v", cfg.UsageCachePath, err)
+		return
+	}
+	if f.Version != 1 || f.LogicalImageUsage != cfg.QuotaLogicalImageUsage {
+		return
+	}
+	s.usage.restore(&f)
+}
+
+// saveUsageCache writes the usage cache to cfg.UsageCachePath if it changed.
+func (s *Server) saveUsageCache(cfg config.Config) error {
+	if cfg.UsageCachePath == "" || s.usage == nil {
+		return nil
+	}
+	roots := s.usage.snapshot()
+	if roots == nil {
+		return nil
+	}
+	err := writeUsageCacheFile(cfg.UsageCachePath, usageCacheFile{Version: 1, LogicalImageUsage: cfg.QuotaLogicalImageUsage, Roots: roots})
+	if err != nil {
+		// Try again on the next tick.
+		s.usage.mu.Lock()
+		s.usage.dirty = true
+		s.usage.mu.Unlock()
+	}
+	return err
+}
+
+func writeUsageCacheFile(p string, f usageCacheFile) error {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	_ = os.MkdirAll(filepath.Dir(p), 0o755)
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// startUsageCacheSaver saves the usage cache every usageCacheSaveInterval
+// while usage_cache_path is set. Shutdown saves it a last time.
+func (s *Server) startUsageCacheSaver() {
+	go func() {
+		for s.sleep(usageCacheSaveInterval) {
+			cfg := s.getCfg()
+			if err := s.saveUsageCache(cfg); err != nil {
+				log.Printf("usage cache: %s: %v", cfg.UsageCachePath, err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+func TestUsageSkipsWhitelistedSymlink(t *testing.T) {
+	var shared string
+	s, cfg, rootAbs := newTestServer(t, func(c *config.Config) {
+		shared = filepath.Join(c.BasePath, "SHARED")
+		c.AllowSymlinkTargets = []string{shared}
+	})
+	if err := os.Mkdir(shared, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(shared, "BIG.SEQ"), bytes.Repeat([]byte{0xAA}, 1000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(shared, filepath.Join(rootAbs, "LINK")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	used, _, err := pathSizeBytes(cfg, rootAbs, rootAbs)
+	if err != nil {
+		t.Fatalf("pathSizeBytes: %v", err)
+	}
+	if used != 1000 {
+		t.Fatalf("used = %d, want 1000 (link target counted once)", used)
+	}
+	if used, err := s.logicalTreeSize(cfg, rootAbs); err != nil || used != 1000 {
+		t.Fatalf("logicalTreeSize = %d, %v; want 1000", used, err)
+	}
+
+	// 1000 used + 100 written fits a 1500 byte quota only if the link's
+	// target is not counted a second time.
+	limits := Limits{QuotaBytes: 1500}
+	payload := writeRangePayload(t, "/NEW.SEQ", 0, bytes.Repeat([]byte{1}, 100))
+	status, _, errMsg := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, proto.FlagWR_CREATE|proto.FlagWR_TRUNCATE, payload, rootAbs)
+	if status != proto.StatusOK {
+		t.Fatalf("WRITE_RANGE status = %s (%s), want OK", statusName(status), errMsg)
+	}
+}
+
+func TestUsageRejectsOtherSymlinks(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, nil)
+	if err := os.Symlink(t.TempDir(), filepath.Join(rootAbs, "OUT")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	if _, _, err := pathSizeBytes(cfg, rootAbs, rootAbs); err == nil {
+		t.Fatal("pathSizeBytes: want error for a symlink that is not whitelisted")
+	}
+	if _, err := s.logicalTreeSize(cfg, rootAbs); err == nil {
+		t.Fatal("logicalTreeSize: want error for a symlink that is not whitelisted")
+	}
+}
+
+func TestReadThroughSymlinks(t *testing.T) {
+	var shared string
+	s, cfg, rootAbs := newTestServer(t, func(c *config.Config) {
+		c.EnableOverwrite = true
+		shared = filepath.Join(c.BasePath, "SHARED")
+		c.AllowSymlinkTargets = []string{shared}
+	})
+	other := filepath.Join(rootAbs, "OTHER")
+	for _, dir := range []string{shared, other} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "F.SEQ"), []byte("hello"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(shared, filepath.Join(rootAbs, "LINK")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	if err := os.Symlink(other, filepath.Join(rootAbs, "BAD")); err != nil {
+		t.Fatal(err)
+	}
+	read := func(p string) (byte, []byte) {
+		st, resp, _ := s.dispatch(cfg, Limits{}, proto.OpREAD_RANGE, 0, encode(func(e *proto.Encoder) {
+			_ = e.WriteString(p)
+			e.WriteU32(1)
+			e.WriteU16(3)
+		}), rootAbs)
+		return st, resp
+	}
+
+	if st, resp := read("/LINK/F.SEQ"); st != proto.StatusOK || string(resp) != "ell" {
+		t.Fatalf("READ_RANGE through the whitelisted link = %s %q", statusName(st), resp)
+	}
+	if st, _, msg := s.dispatch(cfg, Limits{}, proto.OpSTAT, 0, pathPayload("/LINK/F.SEQ"), rootAbs); st != proto.StatusOK {
+		t.Fatalf("STAT through the whitelisted link = %s (%s)", statusName(st), msg)
+	}
+	if st, resp := read("/BAD/F.SEQ"); st == proto.StatusOK {
+		t.Fatalf("READ_RANGE through a link outside the whitelist = OK %q", resp)
+	}
+	if st, _, _ := s.dispatch(cfg, Limits{}, proto.OpSTAT, 0, pathPayload("/BAD/F.SEQ"), rootAbs); st == proto.StatusOK {
+		t.Fatal("STAT through a link outside the whitelist = OK")
+	}
+
+	// Writes never follow a symlink, whitelisted or not.
+	payload := writeRangePayload(t, "/LINK/F.SEQ", 0, []byte("bye"))
+	if st, _, _ := s.dispatch(cfg, Limits{}, proto.OpWRITE_RANGE, proto.FlagWR_OVERWRITE, payload, rootAbs); st == proto.StatusOK {
+		t.Fatal("WRITE_RANGE through the whitelisted link = OK")
+	}
+	if b, _ := os.ReadFile(filepath.Join(shared, "F.SEQ")); string(b) != "hello" {
+		t.Fatalf("link target changed to %q", b)
+	}
+}
+
+func TestUsageCachePersists(t *testing.T) {
+	cachePath := filepath.Join(t.TempDir(), "usage.json")
+	root := t.TempDir()
+	edit := func(c *config.Config) {
+		c.BasePath = root
+		c.UsageCachePath = cachePath
+	}
+	s1, cfg, rootAbs := newTestServer(t, edit)
+	if err := os.WriteFile(filepath.Join(rootAbs, "A"), make([]byte, 1000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if used, err := s1.rootUsageBytes(rootAbs); err != nil || used != 1000 {
+		t.Fatalf("rootUsageBytes = %d, %v", used, err)
+	}
+	if err := s1.saveUsageCache(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// Grows behind the saved value's back, e.g. while the server was down.
+	if err := os.WriteFile(filepath.Join(rootAbs, "B"), make([]byte, 2000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restarted server answers the first quota check from the file: 1000
+	// saved + 1000 new fits 2500, although the root really holds 3000.
+	s2, _, _ := newTestServer(t, edit)
+	limits := Limits{QuotaBytes: 2500}
+	if st, _, msg := s2.dispatch(cfg, limits, proto.OpWRITE_RANGE, proto.FlagWR_CREATE, writeRangePayload(t, "/C", 0, make([]byte, 1000)), rootAbs); st != proto.StatusOK {
+		t.Fatalf("first WRITE_RANGE after restart = %s (%s), want OK from the restored usage", statusName(st), msg)
+	}
+	// The restored value is revalidated in the background.
+	for i := 0; ; i++ {
+		if _, ok := s2.usage.getFresh(rootAbs); ok {
+			break
+		}
+		if i == 200 {
+			t.Fatal("restored usage never rescanned")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st, _, _ := s2.dispatch(cfg, limits, proto.OpWRITE_RANGE, proto.FlagWR_CREATE, writeRangePayload(t, "/D", 0, []byte{1}), rootAbs); st != proto.StatusTooLarge {
+		t.Fatalf("WRITE_RANGE after the rescan = %s, want TOO_LARGE", statusName(st))
+	}
+
+	// Invalidation drops a restored value: the next read scans.
+	s3, _, _ := newTestServer(t, edit)
+	s3.invalidateRootUsage(rootAbs)
+	if used, err := s3.rootUsageBytes(rootAbs); err != nil || used != 4000 {
+		t.Fatalf("rootUsageBytes after invalidation = %d, %v; want 4000", used, err)
+	}
+
+	// Values saved in the other quota_logical_image_usage mode are not used.
+	if err := s1.saveUsageCache(cfg); err != nil {
+		t.Fatal(err)
+	}
+	s4, _, _ := newTestServer(t, func(c *config.Config) { edit(c); c.QuotaLogicalImageUsage = true })
+	if _, ok, _ := s4.usage.getRestored(rootAbs); ok {
+		t.Fatal("usage restored across quota_logical_image_usage modes")
+	}
+}
+
+func TestQuotaEvictsTrash(t *testing.T) {
+	appendPayload := func(p string, data []byte) []byte {
+		e := proto.NewEncoder(8 + len(p) + len(data))
+		_ = e.WriteString(p)
+		e.WriteU16(uint16(len(data)))
+		e.WriteBytes(data)
+		return e.Bytes()
+	}
+	for _, evict := range []bool{false, true} {
+		s, cfg, rootAbs := newTestServer(t, func(c *config.Config) {
+			c.TrashEnabled = true
+			c.TrashEvictOnQuota = evict
+		})
+		// 3000 in files plus the 2-byte .path file RM leaves for /B.
+		limits := Limits{QuotaBytes: 3002}
+		trash := trashDirAbs(cfg, rootAbs)
+		// An older trash entry, so eviction order is known.
+		old := filepath.Join(trash, "20200101T000000Z-00000000")
+		if err := os.MkdirAll(old, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(old, "OLD"), make([]byte, 600), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"A", "B"} {
+			if err := os.WriteFile(filepath.Join(rootAbs, name), make([]byte, 1000), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.WriteFile(filepath.Join(rootAbs, "C"), make([]byte, 400), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// Deleting a file to make room keeps it in the trash: still full.
+		if st, _, msg := s.dispatch(cfg, limits, proto.OpRM, 0, pathPayload("/B"), rootAbs); st != proto.StatusOK {
+			t.Fatalf("RM = %s (%s)", statusName(st), msg)
+		}
+		if used, _ := s.rootUsageBytes(rootAbs); used != 3002 {
+			t.Fatalf("usage after RM = %d, want 3002", used)
+		}
+
+		st, _, msg := s.dispatch(cfg, limits, proto.OpAPPEND, 0, appendPayload("/A", make([]byte, 500)), rootAbs)
+		if !evict {
+			if st != proto.StatusTooLarge {
+				t.Fatalf("APPEND without eviction = %s (%s), want TOO_LARGE", statusName(st), msg)
+			}
+			if n := len(trashEntriesOrFail(t, cfg, rootAbs)); n != 2 {
+				t.Fatalf("trash holds %d entries, want both kept", n)
+			}
+			continue
+		}
+		if st != proto.StatusOK {
+			t.Fatalf("APPEND with eviction = %s (%s)", statusName(st), msg)
+		}
+		// 500 bytes needed: the 600-byte entry is the oldest and enough.
+		entries := trashEntriesOrFail(t, cfg, rootAbs)
+		if len(entries) != 1 || entries[0].Path != "/B" {
+			t.Fatalf("trash after eviction = %+v, want only /B", entries)
+		}
+		if used, _ := s.rootUsageBytes(rootAbs); used != 2902 {
+			t.Fatalf("usage after eviction = %d, want 2902", used)
+		}
+
+		// More than the whole trash frees: nothing is evicted.
+		if st, _, _ := s.dispatch(cfg, limits, proto.OpWRITE_RANGE, proto.FlagWR_CREATE, writeRangePayload(t, "/D", 0, make([]byte, 1200)), rootAbs); st != proto.StatusTooLarge {
+			t.Fatalf("WRITE_RANGE beyond quota and trash = %s, want TOO_LARGE", statusName(st))
+		}
+		if n := len(trashEntriesOrFail(t, cfg, rootAbs)); n != 1 {
+			t.Fatalf("trash holds %d entries after a hopeless write, want 1", n)
+		}
+
+		// CP goes through the same check.
+		if st, _, msg := s.dispatch(cfg, limits, proto.OpCP, 0, cpPayload("/C", "/C2"), rootAbs); st != proto.StatusOK {
+			t.Fatalf("CP with eviction = %s (%s)", statusName(st), msg)
+		}
+		if n := len(trashEntriesOrFail(t, cfg, rootAbs)); n != 0 {
+			t.Fatalf("trash holds %d entries after CP, want 0", n)
+		}
+		if used, _ := s.rootUsageBytes(rootAbs); used != 2300 {
+			t.Fatalf("usage after CP = %d, want 2300", used)
+		}
+	}
+}
+
+func trashEntriesOrFail(t *testing.T, cfg config.Config, rootAbs string) []trashEntry {
+	t.Helper()
+	entries, err := trashEntries(cfg, rootAbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
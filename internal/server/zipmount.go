@@ -0,0 +1,572 @@
+package server
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/fsops"
+	"wicos64-server/internal/proto"
+)
+
+// Read-only .zip mounts (config zip_mount_enabled): "/BUNDLE.ZIP/SUB/FILE"
+// addresses an entry of the archive. Archives stay open for a short while so
+// LS paging and chunked READ_RANGE do not reparse the central directory on
+// every request.
+
+const (
+	// zipIdleTTL is how long an unused archive stays open. Keep it short: an
+	// open handle blocks replacing or deleting the file on Windows.
+	zipIdleTTL = 10 * time.Second
+	// Deflated entries up to this size are decompressed once and served from
+	// memory; larger ones are streamed.
+	zipBufferMax = 256 << 10
+)
+
+// splitZipPath checks whether p contains a ".zip" segment and splits it into:
+//
+//	mountPath: the path up to and including the .zip segment
+//	innerPath: the remaining path inside the archive ("" means archive root)
+func splitZipPath(p string) (mountPath, innerPath string, ok bool) {
+	if p == "" || p[0] != '/' {
+		return "", "", false
+	}
+	trim := strings.TrimPrefix(p, "/")
+	if trim == "" {
+		return "", "", false
+	}
+	segs := strings.Split(trim, "/")
+	for i, seg := range segs {
+		if isZipSegment(seg) {
+			mountPath = "/" + strings.Join(segs[:i+1], "/")
+			if i+1 < len(segs) {
+				innerPath = strings.Join(segs[i+1:], "/")
+			} else {
+				innerPath = ""
+			}
+			return mountPath, innerPath, true
+		}
+	}
+	return "", "", false
+}
+
+func isZipSegment(seg string) bool {
+	ext := strings.TrimSpace(filepath.Ext(seg))
+	return strings.EqualFold(ext, ".zip")
+}
+
+// zipNode is a file or (possibly implicit) directory inside an archive.
+type zipNode struct {
+	name  string // upper-case leaf name
+	dir   bool
+	size  uint64
+	mtime uint32
+	file  *zip.File // nil for directories
+}
+
+type zipArchive struct {
+	abs     string
+	modTime time.Time
+	size    int64
+
+	f    *os.File
+	zr   *zip.Reader
+	dirs map[string][]*zipNode // upper dir path ("" = root) -> children sorted by name
+
+	mu     sync.Mutex // guards bufs, stream
+	bufs   map[*zip.File][]byte
+	stream *zipStream
+
+	refs     int
+	lastUsed time.Time
+	stale    bool
+}
+
+// zipStream is the decompressor of the last large entry read, so sequential
+// READ_RANGE chunks continue where the previous one ended.
+type zipStream struct {
+	file *zip.File
+	rc   io.ReadCloser
+	pos  uint64
+}
+
+type zipArchives struct {
+	mu sync.Mutex
+	m  map[string]*zipArchive
+}
+
+// get returns the archive at abs (reopened when its size or mtime changed)
+// and a release func that must be called when done.
+func (z *zipArchives) get(abs string) (*zipArchive, func(), error) {
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fi.IsDir() {
+		return nil, nil, errors.New("not a file")
+	}
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	now := time.Now()
+	z.expireLocked(now)
+
+	a := z.m[abs]
+	if a != nil && (!a.modTime.Equal(fi.ModTime()) || a.size != fi.Size()) {
+		a.stale = true
+		delete(z.m, abs)
+		if a.refs == 0 {
+			a.close()
+		}
+		a = nil
+	}
+	if a == nil {
+		a, err = openZipArchive(abs, fi)
+		if err != nil {
+			return nil, nil, err
+		}
+		if z.m == nil {
+			z.m = map[string]*zipArchive{}
+		}
+		z.m[abs] = a
+	}
+	a.refs++
+	a.lastUsed = now
+	return a, func() { z.put(a) }, nil
+}
+
+func (z *zipArchives) put(a *zipArchive) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	a.refs--
+	a.lastUsed = time.Now()
+	if a.refs == 0 && a.stale {
+		a.close()
+	}
+}
+
+// expireLocked closes archives that have been idle for zipIdleTTL.
+func (z *zipArchives) expireLocked(now time.Time) {
+	for abs, a := range z.m {
+		if a.refs == 0 && now.Sub(a.lastUsed) > zipIdleTTL {
+			delete(z.m, abs)
+			a.close()
+		}
+	}
+}
+
+// forget drops the cached archive at abs so its handle does not block a write
+// to the archive file itself (Windows cannot replace or delete open files).
+func (z *zipArchives) forget(abs string) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if a := z.m[abs]; a != nil {
+		delete(z.m, abs)
+		a.stale = true
+		if a.refs == 0 {
+			a.close()
+		}
+	}
+}
+
+func openZipArchive(abs string, fi os.FileInfo) (*zipArchive, error) {
+	f, err := os.Open(abs)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(f, fi.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	a := &zipArchive{abs: abs, modTime: fi.ModTime(), size: fi.Size(), f: f, zr: zr, bufs: map[*zip.File][]byte{}}
+	a.index()
+	return a, nil
+}
+
+func (a *zipArchive) close() {
+	a.mu.Lock()
+	if a.stream != nil {
+		a.stream.rc.Close()
+		a.stream = nil
+	}
+	a.bufs = nil
+	a.mu.Unlock()
+	a.f.Close()
+}
+
+// zipEntryPath cleans an entry name into an upper-case relative path. Names
+// that are absolute or climb out of the archive ("../x", "C:\x") are rejected.
+func zipEntryPath(name string) (string, bool) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || strings.Contains(name, ":") {
+		return "", false
+	}
+	name = strings.TrimSuffix(name, "/")
+	if name == "" {
+		return "", false
+	}
+	for _, seg := range strings.Split(name, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return "", false
+		}
+	}
+	return strings.ToUpper(name), true
+}
+
+// index builds the directory tree, including directories that only exist
+// implicitly as a prefix of file names.
+func (a *zipArchive) index() {
+	nodes := map[string]*zipNode{} // full upper path -> node
+	a.dirs = map[string][]*zipNode{"": nil}
+	var addDir func(p string, mtime uint32)
+	addDir = func(p string, mtime uint32) {
+		if p == "" {
+			return
+		}
+		if n, ok := nodes[p]; ok {
+			if n.dir && n.mtime == 0 {
+				n.mtime = mtime
+			}
+			return
+		}
+		parent := path.Dir(p)
+		if parent == "." {
+			parent = ""
+		}
+		addDir(parent, mtime)
+		n := &zipNode{name: path.Base(p), dir: true, mtime: mtime}
+		nodes[p] = n
+		a.dirs[parent] = append(a.dirs[parent], n)
+		a.dirs[p] = a.dirs[p][:0:0]
+	}
+	for _, f := range a.zr.File {
+		p, ok := zipEntryPath(f.Name)
+		if !ok {
+			continue
+		}
+		mtime := uint32(0)
+		if t := f.Modified; !t.IsZero() {
+			mtime = uint32(t.Unix())
+		}
+		if f.FileInfo().IsDir() {
+			addDir(p, mtime)
+			continue
+		}
+		if _, dup := nodes[p]; dup {
+			continue
+		}
+		parent := path.Dir(p)
+		if parent == "." {
+			parent = ""
+		}
+		addDir(parent, mtime)
+		n := &zipNode{name: path.Base(p), size: f.UncompressedSize64, mtime: mtime, file: f}
+		nodes[p] = n
+		a.dirs[parent] = append(a.dirs[parent], n)
+	}
+	for _, kids := range a.dirs {
+		sort.Slice(kids, func(i, j int) bool { return kids[i].name < kids[j].name })
+	}
+}
+
+// lookup resolves an inner path: "" is the root directory, a wildcard in the
+// last segment picks the first match in name order.
+func (a *zipArchive) lookup(inner string) (*zipNode, byte, string) {
+	if inner == "" {
+		return &zipNode{dir: true, mtime: uint32(a.modTime.Unix())}, proto.StatusOK, ""
+	}
+	dir, leaf := "", inner
+	if i := strings.LastIndex(inner, "/"); i >= 0 {
+		dir, leaf = inner[:i], inner[i+1:]
+	}
+	kids, ok := a.dirs[dir]
+	if !ok {
+		return nil, proto.StatusNotFound, "not found"
+	}
+	wild := strings.ContainsAny(leaf, "*?")
+	for _, n := range kids {
+		if n.name == leaf || (wild && wildcardMatch(leaf, n.name)) {
+			return n, proto.StatusOK, ""
+		}
+	}
+	return nil, proto.StatusNotFound, "not found"
+}
+
+// readRange returns length bytes at offset of the decompressed entry f.
+func (a *zipArchive) readRange(f *zip.File, offset, length uint64) ([]byte, error) {
+	out := make([]byte, length)
+	if f.Method == zip.Store {
+		dataOff, err := f.DataOffset()
+		if err != nil {
+			return nil, err
+		}
+		if n, err := a.f.ReadAt(out, dataOff+int64(offset)); n != len(out) {
+			return nil, err
+		}
+		return out, nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if f.UncompressedSize64 <= zipBufferMax {
+		b, ok := a.bufs[f]
+		if !ok {
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			b, err = io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return nil, err
+			}
+			a.bufs[f] = b
+		}
+		if offset+length > uint64(len(b)) {
+			return nil, errors.New("short read")
+		}
+		copy(out, b[offset:offset+length])
+		return out, nil
+	}
+
+	st := a.stream
+	if st == nil || st.file != f || st.pos > offset {
+		if st != nil {
+			st.rc.Close()
+		}
+		rc, err := f.Open()
+		if err != nil {
+			a.stream = nil
+			return nil, err
+		}
+		st = &zipStream{file: f, rc: rc}
+		a.stream = st
+	}
+	if skip := offset - st.pos; skip > 0 {
+		n, err := io.CopyN(io.Discard, st.rc, int64(skip))
+		st.pos += uint64(n)
+		if err != nil {
+			return nil, err
+		}
+	}
+	n, err := io.ReadFull(st.rc, out)
+	st.pos += uint64(n)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// resolveZipMount opens the archive at mountPath. The caller must call
+// release when done.
+func (s *Server) resolveZipMount(rootAbs, mountPath string) (a *zipArchive, release func(), status byte, msg string) {
+	abs, err := fsops.ToOSPath(rootAbs, mountPath)
+	if err != nil {
+		return nil, nil, proto.StatusInvalidPath, err.Error()
+	}
+	if err := fsops.LstatNoSymlink(rootAbs, abs, false); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil, proto.StatusNotFound, "archive not found"
+		}
+		return nil, nil, proto.StatusInvalidPath, err.Error()
+	}
+	a, release, err = s.zips.get(abs)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil, proto.StatusNotFound, "archive not found"
+		}
+		// Treat invalid archives as "not found" like broken disk images.
+		return nil, nil, proto.StatusNotFound, "invalid or unsupported .zip archive"
+	}
+	return a, release, proto.StatusOK, ""
+}
+
+// lsZip lists a directory inside an archive. The last segment of inner may
+// be a wildcard pattern.
+func (s *Server) lsZip(cfg config.Config, rootAbs, mountPath, inner string, start, maxEntries uint16, order lsOrder) (byte, []byte, string) {
+	a, release, st, msg := s.resolveZipMount(rootAbs, mountPath)
+	if st != proto.StatusOK {
+		return st, nil, msg
+	}
+	defer release()
+
+	pattern := ""
+	if i := strings.LastIndex(inner, "/"); strings.ContainsAny(inner, "*?") {
+		pattern = inner[i+1:]
+		inner = strings.TrimSuffix(inner[:i+1], "/")
+	}
+	n, st, msg := a.lookup(inner)
+	if st != proto.StatusOK {
+		return st, nil, msg
+	}
+	if !n.dir {
+		return proto.StatusNotADir, nil, "not a directory"
+	}
+	kids := a.dirs[inner]
+	if pattern != "" {
+		filtered := make([]*zipNode, 0, len(kids))
+		for _, k := range kids {
+			if wildcardMatch(pattern, k.name) {
+				filtered = append(filtered, k)
+			}
+		}
+		kids = filtered
+	}
+	kids = sortZipNodes(kids, order)
+
+	buf := proto.AppendU16(make([]byte, 0, 256), 0) // placeholder count
+	count := uint16(0)
+	idx := int(start)
+	for idx < len(kids) && count < maxEntries {
+		k := kids[idx]
+		etype, size := byte(0), clampU32(k.size)
+		if k.dir {
+			etype, size = 1, 0
+		}
+		name := k.name
+		if uint16(len(name)) > cfg.MaxName {
+			name = name[:int(cfg.MaxName)]
+		}
+		enc := proto.NewEncoder(32)
+		enc.WriteU8(etype)
+		enc.WriteU32(size)
+		enc.WriteU32(k.mtime)
+		_ = enc.WriteString(name)
+		entry := enc.Bytes()
+		if len(buf)+len(entry)+2 > int(cfg.MaxPayload) {
+			if count > 0 || !cfg.TruncateOversized {
+				break
+			}
+			short, ok := truncatedLSEntry(etype, size, k.mtime, name, int(cfg.MaxPayload)-len(buf)-2)
+			if !ok {
+				break
+			}
+			entry = short
+		}
+		buf = append(buf, entry...)
+		count++
+		idx++
+	}
+	next := uint16(0xFFFF)
+	if idx < len(kids) {
+		next = uint16(idx)
+	}
+	buf = proto.AppendU16(buf, next)
+	binary.LittleEndian.PutUint16(buf[0:2], count)
+	return proto.StatusOK, buf, ""
+}
+
+func (s *Server) statZip(rootAbs, mountPath, inner string) (byte, []byte, string) {
+	a, release, st, msg := s.resolveZipMount(rootAbs, mountPath)
+	if st != proto.StatusOK {
+		return st, nil, msg
+	}
+	defer release()
+	n, st, msg := a.lookup(inner)
+	if st != proto.StatusOK {
+		return st, nil, msg
+	}
+	e := proto.NewEncoder(9)
+	if n.dir {
+		e.WriteU8(1)
+		e.WriteU32(0)
+	} else {
+		e.WriteU8(0)
+		e.WriteU32(clampU32(n.size))
+	}
+	e.WriteU32(n.mtime)
+	return proto.StatusOK, e.Bytes(), ""
+}
+
+func (s *Server) readZip(rootAbs, mountPath, inner string, offset uint32, ln uint16) (byte, []byte, string) {
+	a, release, st, msg := s.resolveZipMount(rootAbs, mountPath)
+	if st != proto.StatusOK {
+		return st, nil, msg
+	}
+	defer release()
+	n, st, msg := a.lookup(inner)
+	if st != proto.StatusOK {
+		return st, nil, msg
+	}
+	if n.dir {
+		return proto.StatusIsADir, nil, "is a directory"
+	}
+
+	off := uint64(offset)
+	want := uint64(ln)
+	if off > n.size {
+		return proto.StatusRangeInvalid, nil, "offset beyond EOF"
+	}
+	if off == n.size || want == 0 {
+		return proto.StatusOK, []byte{}, ""
+	}
+	if want > n.size-off {
+		return proto.StatusRangeInvalid, nil, "range exceeds EOF"
+	}
+	data, err := a.readRange(n.file, off, want)
+	if err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+	return proto.StatusOK, data, ""
+}
+
+// hashZip answers HASH for an archive entry. CRC32 comes straight from the
+// zip directory (same IEEE polynomial); SHA-256 and CRC16 decompress the
+// entry.
+func (s *Server) hashZip(rootAbs, mountPath, inner string, wantSHA256, wantCRC16 bool) (byte, []byte, string) {
+	a, release, st, msg := s.resolveZipMount(rootAbs, mountPath)
+	if st != proto.StatusOK {
+		return st, nil, msg
+	}
+	defer release()
+	n, st, msg := a.lookup(inner)
+	if st != proto.StatusOK {
+		return st, nil, msg
+	}
+	if n.dir {
+		return proto.StatusIsADir, nil, "is a directory"
+	}
+	if !wantSHA256 && !wantCRC16 {
+		e := proto.NewEncoder(4)
+		e.WriteU32(n.file.CRC32)
+		return proto.StatusOK, e.Bytes(), ""
+	}
+	rc, err := n.file.Open()
+	if err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+	defer rc.Close()
+	if wantCRC16 {
+		h := newCRC16()
+		_, err := io.Copy(h, rc)
+		return crc16Resp(h.Sum16(), err)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return proto.StatusInternal, nil, err.Error()
+	}
+	return proto.StatusOK, h.Sum(nil), ""
+}
+
+// forgetZipTargets drops cached archives that a write op is about to modify,
+// delete or replace as host files.
+func (s *Server) forgetZipTargets(cfg config.Config, op byte, payload []byte, rootAbs string) {
+	for _, p := range s.writeLockPaths(cfg, op, payload) {
+		if mountPath, inner, ok := splitZipPath(p); ok && inner == "" {
+			if abs, err := fsops.ToOSPath(rootAbs, mountPath); err == nil {
+				s.zips.forget(abs)
+			}
+		}
+	}
+}
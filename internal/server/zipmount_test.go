@@ -0,0 +1,145 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"wicos64-server/internal/config"
+	"wicos64-server/internal/proto"
+)
+
+func TestZipEntryPath(t *testing.T) {
+	tests := []struct {
+		name, want string
+		ok         bool
+	}{
+		{"a.txt", "A.TXT", true},
+		{"sub/dir/", "SUB/DIR", true},
+		{`win\path\f`, "WIN/PATH/F", true},
+		{"../x", "", false},
+		{"sub/../../x", "", false},
+		{"./x", "", false},
+		{"a//b", "", false},
+		{"/abs", "", false},
+		{`\abs`, "", false},
+		{"C:/x", "", false},
+		{`C:\x`, "", false},
+		{"", "", false},
+		{"/", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := zipEntryPath(tt.name)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("zipEntryPath(%q) = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestZipMount(t *testing.T) {
+	s, cfg, rootAbs := newTestServer(t, func(c *config.Config) { c.ZipMountEnabled = true })
+
+	big := make([]byte, zipBufferMax+4096)
+	for i := range big {
+		big[i] = byte(i * 7)
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range []struct {
+		name   string
+		method uint16
+		data   []byte
+	}{
+		{"../x", zip.Deflate, []byte("escaped")},
+		{"/abs", zip.Deflate, []byte("absolute")},
+		{`C:\win`, zip.Deflate, []byte("drive")},
+		{"sub/nested/stored.txt", zip.Store, []byte("0123456789")},
+		{"sub/nested/small.txt", zip.Deflate, []byte("abcdefghij")},
+		{"sub/big.bin", zip.Deflate, big},
+	} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: e.name, Method: e.method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(e.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(rootAbs, "BUNDLE.ZIP"), buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ls := func(p string) []string {
+		t.Helper()
+		st, resp, msg := s.dispatch(cfg, Limits{}, proto.OpLS, 0, encode(func(e *proto.Encoder) {
+			_ = e.WriteString(p)
+			e.WriteU16(0)
+			e.WriteU16(100)
+		}), rootAbs)
+		if st != proto.StatusOK {
+			t.Fatalf("LS %s = %s (%s)", p, statusName(st), msg)
+		}
+		d := proto.NewDecoder(resp)
+		n, _ := d.ReadU16()
+		var names []string
+		for i := 0; i < int(n); i++ {
+			_, _ = d.ReadU8()
+			_, _ = d.ReadU32()
+			_, _ = d.ReadU32()
+			name, _ := d.ReadString(0xFFFF)
+			names = append(names, name)
+		}
+		return names
+	}
+	if got := ls("/BUNDLE.ZIP"); len(got) != 1 || got[0] != "SUB" {
+		t.Fatalf("archive root = %q, want only SUB", got)
+	}
+	if got := ls("/BUNDLE.ZIP/SUB/NESTED"); len(got) != 2 || got[0] != "SMALL.TXT" || got[1] != "STORED.TXT" {
+		t.Fatalf("nested dir = %q", got)
+	}
+	for _, p := range []string{"/X", "/ABS", "/BUNDLE.ZIP/X", "/BUNDLE.ZIP/ABS", "/BUNDLE.ZIP/WIN"} {
+		if st, _, _ := s.dispatch(cfg, Limits{}, proto.OpSTAT, 0, pathPayload(p), rootAbs); st != proto.StatusNotFound {
+			t.Errorf("STAT %s = %s, want NOT_FOUND", p, statusName(st))
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(rootAbs), "x")); err == nil {
+		t.Error("archive entry escaped the root")
+	}
+
+	read := func(p string, off uint32, n uint16) []byte {
+		t.Helper()
+		st, resp, msg := s.dispatch(cfg, Limits{}, proto.OpREAD_RANGE, 0, encode(func(e *proto.Encoder) {
+			_ = e.WriteString(p)
+			e.WriteU32(off)
+			e.WriteU16(n)
+		}), rootAbs)
+		if st != proto.StatusOK {
+			t.Fatalf("READ_RANGE %s @%d = %s (%s)", p, off, statusName(st), msg)
+		}
+		return resp
+	}
+	if got := read("/BUNDLE.ZIP/SUB/NESTED/STORED.TXT", 3, 4); string(got) != "3456" {
+		t.Errorf("stored range = %q", got)
+	}
+	if got := read("/BUNDLE.ZIP/SUB/NESTED/SMALL.TXT", 6, 4); string(got) != "ghij" {
+		t.Errorf("deflated range = %q", got)
+	}
+	// Streamed entry: forward chunks, then a seek back that restarts it.
+	for _, off := range []uint32{100, 200000, 200100, zipBufferMax, 50} {
+		if got := read("/BUNDLE.ZIP/SUB/BIG.BIN", off, 100); !bytes.Equal(got, big[off:off+100]) {
+			t.Fatalf("big range @%d differs", off)
+		}
+	}
+	if st, _, _ := s.dispatch(cfg, Limits{}, proto.OpREAD_RANGE, 0, encode(func(e *proto.Encoder) {
+		_ = e.WriteString("/BUNDLE.ZIP/SUB/NESTED/SMALL.TXT")
+		e.WriteU32(8)
+		e.WriteU16(4)
+	}), rootAbs); st != proto.StatusRangeInvalid {
+		t.Errorf("range past EOF = %s, want RANGE_INVALID", statusName(st))
+	}
+}